@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"time"
 	"os/signal"
@@ -10,56 +15,112 @@ import (
     "os/exec"
     "log"
 
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/config"
 	"github.com/example/nfsserver/pkg/fuse"
+	"github.com/example/nfsserver/pkg/telemetry"
 )
 
 func main() {
-	// Parse command line arguments
-	mountPoint := flag.String("mount", "", "Mount point for NFS filesystem")
-	serverAddr := flag.String("server", "localhost:2049", "NFS server address")
-	readOnly := flag.Bool("readonly", false, "Mount filesystem as read-only")
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	
-	// Parse flags
-	flag.Parse()
-
-	// Check if mount point is provided
-	if *mountPoint == "" {
-		fmt.Println("Error: Mount point is required")
-		flag.Usage()
+	// Load the configuration from the command line, environment and file
+	defaults := fuse.DefaultOptions()
+	options := fuse.MountOptions{
+		ServerAddr:        "localhost:2049",
+		CacheTimeout:      1 * time.Minute,
+		Timeout:           30 * time.Second,
+		EntryTimeout:      defaults.EntryTimeout,
+		AttrTimeout:       defaults.AttrTimeout,
+		LookupBatchWindow: defaults.LookupBatchWindow,
+	}
+	var keyFile, metricsAddr string
+	traceOptions := telemetry.DefaultOptions()
+	settings := config.NewSet("nfs-fuse", "NFS_FUSE")
+	fuse.Settings(settings, &options, &keyFile)
+	config.Telemetry(settings, &traceOptions)
+	settings.String(&metricsAddr, "metrics-listen", "Network address serving client metrics at /metrics (Prometheus) and /debug/vars (expvar) (disabled if empty)")
+	if err := settings.Load(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	stopTracing, err := telemetry.Setup(context.Background(), "nfs-fuse", traceOptions)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer stopTracing(context.Background())
+
 	// Ensure mount point exists
-	if _, err := os.Stat(*mountPoint); os.IsNotExist(err) {
-		log.Printf("Creating mount point: %s", *mountPoint)
-		if err := os.MkdirAll(*mountPoint, 0755); err != nil {
+	if _, err := os.Stat(options.MountPoint); os.IsNotExist(err) {
+		log.Printf("Creating mount point: %s", options.MountPoint)
+		if err := os.MkdirAll(options.MountPoint, 0755); err != nil {
 			log.Fatalf("Failed to create mount point: %v", err)
 		}
 	}
 
-	// Create mount options
-	options := fuse.MountOptions{
-		MountPoint:   *mountPoint,
-		ServerAddr:   *serverAddr,
-		ReadOnly:     *readOnly,
-		CacheTimeout: 1 * time.Minute,
-		Debug:        *debug,
+	if keyFile != "" {
+		key, err := client.LoadKeyFile(keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption key: %v", err)
+		}
+		options.EncryptionKey = key
+	}
+
+	if metricsAddr != "" {
+		options.Metrics = client.NewMetrics()
+		if err := serveMetrics(metricsAddr, options.Metrics); err != nil {
+			log.Fatalf("Failed to serve metrics: %v", err)
+		}
 	}
 
+	// Mount filesystem
+	fmt.Printf("Mounting NFS filesystem at %s\n", options.MountPoint)
+	mount, err := fuse.Mount(options)
+	if err != nil {
+		fmt.Printf("Error mounting filesystem: %v\n", err)
+		os.Exit(1)
+	}
+	log.Println("FUSE filesystem mounted successfully")
+	log.Println("Press Ctrl+C to unmount")
+
     c := make(chan os.Signal, 1)
     signal.Notify(c, os.Interrupt, syscall.SIGTERM)
     go func() {
         <-c
         fmt.Println("\nReceived interrupt, exiting...")
-        exec.Command("fusermount", "-uz", *mountPoint).Run()
-        os.Exit(0)
+        if err := mount.Close(); err != nil {
+            // Still busy: detach lazily so the process can exit
+            log.Printf("Warning: %v", err)
+            exec.Command("fusermount", "-uz", options.MountPoint).Run()
+        }
     }()
 
-	// Mount filesystem
-	fmt.Printf("Mounting NFS filesystem at %s\n", *mountPoint)
-	if err := fuse.Mount(options); err != nil {
-		fmt.Printf("Error mounting filesystem: %v\n", err)
+	// Serve until unmounted, by the signal handler or externally
+	if err := mount.Wait(); err != nil {
+		fmt.Printf("Error serving filesystem: %v\n", err)
+		stopTracing(context.Background())
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
+
+// serveMetrics serves metrics at /metrics and, published to expvar, at
+// /debug/vars on addr
+func serveMetrics(addr string, metrics *client.Metrics) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	metrics.Publish("nfs_client")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		log.Printf("Metrics available at http://%s/metrics", lis.Addr())
+		if err := http.Serve(lis, mux); err != nil {
+			log.Printf("Metrics service stopped: %v", err)
+		}
+	}()
+	return nil
+}
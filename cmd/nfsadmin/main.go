@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: nfsadmin [flags] <command> [args]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  captures [n]   Show the n most recent captured requests\n")
+	fmt.Fprintf(os.Stderr, "  debug <target> [duration]\n")
+	fmt.Fprintf(os.Stderr, "                 Log operations on target in detail (a /path prefix or a hex handle)\n")
+	fmt.Fprintf(os.Stderr, "  debug off      Disable debug logging\n")
+	fmt.Fprintf(os.Stderr, "  check [repair] Check the export index against the tree, optionally repairing it\n")
+	fmt.Fprintf(os.Stderr, "  trace [duration] [file]\n")
+	fmt.Fprintf(os.Stderr, "                 Record an execution trace (default 5s to nfsserver.trace) for go tool trace\n")
+	fmt.Fprintf(os.Stderr, "  metrics        Show the server's counters and timings\n")
+	fmt.Fprintf(os.Stderr, "  policy <root_squash|no_root_squash> [uid:gid] [anonymous]\n")
+	fmt.Fprintf(os.Stderr, "                 Replace the squash and anonymous access rules (default anonymous identity 65534:65534)\n")
+	fmt.Fprintf(os.Stderr, "  usage [from [to]]\n")
+	fmt.Fprintf(os.Stderr, "                 Show bytes read and written per uid and day (YYYY-MM-DD, UTC; default the last 7 days)\n")
+	fmt.Fprintf(os.Stderr, "  revoke <handle> [reason]\n")
+	fmt.Fprintf(os.Stderr, "                 Refuse a hex file handle from now on\n")
+	fmt.Fprintf(os.Stderr, "  unrevoke <handle>\n")
+	fmt.Fprintf(os.Stderr, "                 Accept a revoked handle again\n")
+	fmt.Fprintf(os.Stderr, "  revoked        List the revoked handles\n")
+	fmt.Fprintf(os.Stderr, "  trash          List the trees waiting to be deleted in the background\n")
+	fmt.Fprintf(os.Stderr, "  trash purge [id...]\n")
+	fmt.Fprintf(os.Stderr, "                 Delete failed and orphaned trash entries (default all of them) as root\n")
+	fmt.Fprintf(os.Stderr, "  snapshot       List the export's snapshots\n")
+	fmt.Fprintf(os.Stderr, "  snapshot create <name>\n")
+	fmt.Fprintf(os.Stderr, "                 Take a read-only snapshot of the export, reached at /.snapshots/<name>\n")
+	fmt.Fprintf(os.Stderr, "  snapshot delete <name>\n")
+	fmt.Fprintf(os.Stderr, "                 Delete a snapshot\n")
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	// Parse command line flags
+	adminAddr := flag.String("admin", "localhost:2050", "Admin service address")
+	timeout := flag.Duration("timeout", 10*time.Second, "RPC timeout")
+	debugRate := flag.Uint("debug-rate", 0, "Maximum debug log lines per second (0 = server default)")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	// Connect to the admin service
+	conn, err := grpc.Dial(*adminAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	admin := api.NewAdminServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	args := flag.Args()
+	switch args[0] {
+	case "captures":
+		var max uint
+		if len(args) > 1 {
+			if _, err := fmt.Sscanf(args[1], "%d", &max); err != nil {
+				log.Fatalf("Invalid entry count %q", args[1])
+			}
+		}
+		showCaptures(ctx, admin, uint32(max))
+
+	case "debug":
+		if len(args) < 2 {
+			usage()
+			os.Exit(1)
+		}
+		duration := 5 * time.Minute
+		if len(args) > 2 {
+			if duration, err = time.ParseDuration(args[2]); err != nil {
+				log.Fatalf("Invalid duration %q: %v", args[2], err)
+			}
+		}
+		setDebugTarget(ctx, admin, args[1], duration, uint32(*debugRate))
+
+	case "check":
+		repair := len(args) > 1 && args[1] == "repair"
+		if len(args) > 1 && !repair {
+			usage()
+			os.Exit(1)
+		}
+		checkExport(ctx, admin, repair)
+
+	case "trace":
+		duration := 5 * time.Second
+		if len(args) > 1 {
+			if duration, err = time.ParseDuration(args[1]); err != nil {
+				log.Fatalf("Invalid duration %q: %v", args[1], err)
+			}
+		}
+		file := "nfsserver.trace"
+		if len(args) > 2 {
+			file = args[2]
+		}
+		// The RPC lasts as long as the trace, on top of the usual timeout
+		traceCtx, traceCancel := context.WithTimeout(context.Background(), duration+*timeout)
+		defer traceCancel()
+		captureTrace(traceCtx, admin, duration, file)
+
+	case "metrics":
+		showMetrics(ctx, admin)
+
+	case "policy":
+		if len(args) < 2 || (args[1] != "root_squash" && args[1] != "no_root_squash") {
+			usage()
+			os.Exit(1)
+		}
+		req := &api.SetExportPolicyRequest{
+			RootSquash: args[1] == "root_squash",
+			AnonUid:    65534,
+			AnonGid:    65534,
+		}
+		for _, arg := range args[2:] {
+			if arg == "anonymous" {
+				req.AllowAnonymous = true
+			} else if _, err := fmt.Sscanf(arg, "%d:%d", &req.AnonUid, &req.AnonGid); err != nil {
+				log.Fatalf("Invalid anonymous identity %q, want uid:gid", arg)
+			}
+		}
+		setExportPolicy(ctx, admin, req)
+
+	case "usage":
+		if len(args) > 3 {
+			usage()
+			os.Exit(1)
+		}
+		req := &api.GetUsageRequest{
+			FromDay: time.Now().UTC().AddDate(0, 0, -6).Format("2006-01-02"),
+		}
+		if len(args) > 1 {
+			req.FromDay = args[1]
+		}
+		if len(args) > 2 {
+			req.ToDay = args[2]
+		}
+		showUsage(ctx, admin, req)
+
+	case "revoke", "unrevoke":
+		if len(args) < 2 || (args[0] == "unrevoke" && len(args) > 2) {
+			usage()
+			os.Exit(1)
+		}
+		handle, err := hex.DecodeString(args[1])
+		if err != nil {
+			log.Fatalf("Invalid hex file handle %q: %v", args[1], err)
+		}
+		if args[0] == "unrevoke" {
+			unrevokeHandle(ctx, admin, handle)
+		} else {
+			revokeHandle(ctx, admin, handle, strings.Join(args[2:], " "))
+		}
+
+	case "revoked":
+		showRevoked(ctx, admin)
+
+	case "trash":
+		if len(args) == 1 {
+			showTrash(ctx, admin)
+			break
+		}
+		if args[1] != "purge" {
+			usage()
+			os.Exit(1)
+		}
+		var ids []uint64
+		for _, arg := range args[2:] {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid trash entry id %q", arg)
+			}
+			ids = append(ids, id)
+		}
+		purgeTrash(ctx, admin, ids)
+
+	case "snapshot":
+		if len(args) == 1 {
+			showSnapshots(ctx, admin)
+			break
+		}
+		if len(args) != 3 {
+			usage()
+			os.Exit(1)
+		}
+		switch args[1] {
+		case "create":
+			createSnapshot(ctx, admin, args[2])
+		case "delete":
+			deleteSnapshot(ctx, admin, args[2])
+		default:
+			usage()
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		usage()
+		os.Exit(1)
+	}
+}
+
+// showCaptures prints captured request summaries, oldest first
+func showCaptures(ctx context.Context, admin api.AdminServiceClient, max uint32) {
+	resp, err := admin.GetCaptures(ctx, &api.GetCapturesRequest{MaxEntries: max})
+	if err != nil {
+		log.Fatalf("GetCaptures failed: %v", err)
+	}
+
+	if resp.Capacity == 0 {
+		fmt.Println("Request capture is disabled on the server (start it with -capture-size)")
+		return
+	}
+
+	fmt.Printf("Showing %d of %d captured requests (buffer capacity %d)\n",
+		len(resp.Entries), resp.TotalCaptured, resp.Capacity)
+	for _, e := range resp.Entries {
+		ts := time.Unix(e.Time.GetSeconds(), int64(e.Time.GetNano()))
+		fmt.Printf("%s %-14s %-21s %-16s %s\n", ts.Format("15:04:05.000"), e.Operation,
+			e.Client, e.Status, time.Duration(e.DurationNanos))
+		if e.Tag != "" {
+			fmt.Printf("    tag:      %s\n", e.Tag)
+		}
+		fmt.Printf("    request:  %s\n", e.Request)
+		if e.Error != "" {
+			fmt.Printf("    error:    %s\n", e.Error)
+		} else {
+			fmt.Printf("    response: %s\n", e.Response)
+		}
+	}
+}
+
+// setDebugTarget enables or disables detailed logging on the server
+func setDebugTarget(ctx context.Context, admin api.AdminServiceClient, target string, duration time.Duration, rate uint32) {
+	req := &api.SetDebugTargetRequest{
+		DurationSeconds:   uint32(duration / time.Second),
+		MaxLinesPerSecond: rate,
+	}
+
+	switch {
+	case target == "off":
+		req.DurationSeconds = 0
+	case strings.HasPrefix(target, "/"):
+		req.PathPrefix = target
+	default:
+		handle, err := hex.DecodeString(target)
+		if err != nil {
+			log.Fatalf("Target must be a path starting with / or a hex file handle: %v", err)
+		}
+		req.FileHandle = handle
+	}
+
+	resp, err := admin.SetDebugTarget(ctx, req)
+	if err != nil {
+		log.Fatalf("SetDebugTarget failed: %v", err)
+	}
+
+	if resp.Expires == nil {
+		fmt.Println("Debug logging disabled")
+		return
+	}
+	fmt.Printf("Debug logging enabled for %s until %s\n", target,
+		time.Unix(resp.Expires.Seconds, int64(resp.Expires.Nano)).Format(time.RFC3339))
+}
+
+// checkExport runs a consistency check on the server and prints the summary
+func checkExport(ctx context.Context, admin api.AdminServiceClient, repair bool) {
+	resp, err := admin.CheckExport(ctx, &api.CheckExportRequest{Repair: repair})
+	if err != nil {
+		log.Fatalf("CheckExport failed: %v", err)
+	}
+
+	fmt.Printf("Scanned %d entries against %d index entries in %s\n",
+		resp.Scanned, resp.Indexed, time.Duration(resp.DurationNanos).Round(time.Millisecond))
+	verb := "found"
+	if resp.Repaired {
+		verb = "repaired"
+	}
+	fmt.Printf("Stale index entries %s: %d\n", verb, resp.Stale)
+	fmt.Printf("Moved index entries %s: %d\n", verb, resp.Moved)
+	fmt.Printf("Unindexed entries %s: %d\n", verb, resp.Added)
+	fmt.Printf("Orphaned entries: %d\n", len(resp.Orphans))
+	for _, orphan := range resp.Orphans {
+		fmt.Printf("    %s\n", orphan)
+	}
+	if !resp.Repaired && resp.Stale+resp.Moved+uint64(len(resp.Orphans)) > 0 {
+		fmt.Println("Run \"nfsadmin check repair\" to fix these")
+	}
+}
+
+// showMetrics prints every metric the server reports
+func showMetrics(ctx context.Context, admin api.AdminServiceClient) {
+	resp, err := admin.GetMetrics(ctx, &api.GetMetricsRequest{})
+	if err != nil {
+		log.Fatalf("GetMetrics failed: %v", err)
+	}
+	for _, m := range resp.Metrics {
+		fmt.Printf("%-32s %-14g %s\n", m.Name, m.Value, m.Help)
+	}
+}
+
+// showUsage prints the traffic per uid and day, then the total per uid
+func showUsage(ctx context.Context, admin api.AdminServiceClient, req *api.GetUsageRequest) {
+	resp, err := admin.GetUsage(ctx, req)
+	if err != nil {
+		log.Fatalf("GetUsage failed: %v", err)
+	}
+
+	fmt.Printf("Usage of export %q from %s to %s\n", resp.Export, req.FromDay, orToday(req.ToDay))
+	fmt.Printf("%-10s %10s %14s %14s %10s %10s\n", "DAY", "UID", "READ", "WRITTEN", "READS", "WRITES")
+
+	totals := make(map[uint32]*api.UsageRecord)
+	var uids []uint32
+	for _, r := range resp.Records {
+		fmt.Printf("%-10s %10d %14d %14d %10d %10d\n", r.Day, r.Uid, r.ReadBytes, r.WriteBytes, r.Reads, r.Writes)
+		t, ok := totals[r.Uid]
+		if !ok {
+			t = &api.UsageRecord{Uid: r.Uid}
+			totals[r.Uid] = t
+			uids = append(uids, r.Uid)
+		}
+		t.ReadBytes += r.ReadBytes
+		t.WriteBytes += r.WriteBytes
+		t.Reads += r.Reads
+		t.Writes += r.Writes
+	}
+
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	for _, uid := range uids {
+		t := totals[uid]
+		fmt.Printf("%-10s %10d %14d %14d %10d %10d\n", "total", uid, t.ReadBytes, t.WriteBytes, t.Reads, t.Writes)
+	}
+}
+
+// orToday returns day, or "today" if it is empty
+func orToday(day string) string {
+	if day == "" {
+		return "today"
+	}
+	return day
+}
+
+// revokeHandle adds a handle to the server's revocation list
+func revokeHandle(ctx context.Context, admin api.AdminServiceClient, handle []byte, reason string) {
+	resp, err := admin.RevokeHandle(ctx, &api.RevokeHandleRequest{FileHandle: handle, Reason: reason})
+	if err != nil {
+		log.Fatalf("RevokeHandle failed: %v", err)
+	}
+	if resp.Expires == nil {
+		fmt.Println("Handle revoked")
+		return
+	}
+	fmt.Printf("Handle revoked; it expires anyway at %s\n", time.Unix(resp.Expires.Seconds, 0).Format(time.RFC3339))
+}
+
+// unrevokeHandle removes a handle from the server's revocation list
+func unrevokeHandle(ctx context.Context, admin api.AdminServiceClient, handle []byte) {
+	resp, err := admin.UnrevokeHandle(ctx, &api.UnrevokeHandleRequest{FileHandle: handle})
+	if err != nil {
+		log.Fatalf("UnrevokeHandle failed: %v", err)
+	}
+	if !resp.Removed {
+		fmt.Println("Handle was not revoked")
+		return
+	}
+	fmt.Println("Handle reinstated")
+}
+
+// showRevoked prints the revocation list, oldest revocation first
+func showRevoked(ctx context.Context, admin api.AdminServiceClient) {
+	resp, err := admin.ListRevokedHandles(ctx, &api.ListRevokedHandlesRequest{})
+	if err != nil {
+		log.Fatalf("ListRevokedHandles failed: %v", err)
+	}
+	for _, h := range resp.Handles {
+		expires := "never"
+		if h.Expires != nil {
+			expires = time.Unix(h.Expires.Seconds, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("%x\n    revoked %s, expires %s", h.FileHandle,
+			time.Unix(h.Revoked.GetSeconds(), 0).Format(time.RFC3339), expires)
+		if h.Reason != "" {
+			fmt.Printf(": %s", h.Reason)
+		}
+		fmt.Println()
+	}
+}
+
+// showTrash prints the background deletion queue in the order it is worked
+// through
+func showTrash(ctx context.Context, admin api.AdminServiceClient) {
+	resp, err := admin.ListTrash(ctx, &api.ListTrashRequest{})
+	if err != nil {
+		log.Fatalf("ListTrash failed: %v", err)
+	}
+	if len(resp.Entries) == 0 {
+		fmt.Println("The trash is empty")
+		return
+	}
+	for _, e := range resp.Entries {
+		state := strings.ToLower(strings.TrimPrefix(e.State.String(), "TRASH_"))
+		origin := e.Path
+		if origin == "" {
+			origin = "(from an earlier run)"
+		}
+		fmt.Printf("%-4d %-9s %s\n", e.Id, state, origin)
+		fmt.Printf("    %s, queued %s by uid %d\n", e.TrashPath,
+			time.Unix(e.Queued.GetSeconds(), 0).Format(time.RFC3339), e.Uid)
+		if e.State == api.TrashState_TRASH_DELETING {
+			fmt.Printf("    removed %d files, %d directories, %d bytes\n",
+				e.FilesRemoved, e.DirectoriesRemoved, e.BytesRemoved)
+		}
+		if e.State == api.TrashState_TRASH_FAILED {
+			fmt.Printf("    %s at %s\n", e.Status, e.FailedPath)
+		}
+	}
+}
+
+// purgeTrash queues failed and orphaned trash entries for deletion
+func purgeTrash(ctx context.Context, admin api.AdminServiceClient, ids []uint64) {
+	resp, err := admin.PurgeTrash(ctx, &api.PurgeTrashRequest{Ids: ids})
+	if err != nil {
+		log.Fatalf("PurgeTrash failed: %v", err)
+	}
+	fmt.Printf("Queued %d trash entries for deletion\n", resp.Queued)
+}
+
+// showSnapshots prints the export's snapshots, oldest first
+func showSnapshots(ctx context.Context, admin api.AdminServiceClient) {
+	resp, err := admin.ListSnapshots(ctx, &api.ListSnapshotsRequest{})
+	if err != nil {
+		log.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(resp.Snapshots) == 0 {
+		fmt.Println("There are no snapshots")
+		return
+	}
+	for _, snapshot := range resp.Snapshots {
+		fmt.Printf("%-24s %s\n", snapshot.Name, time.Unix(snapshot.Created.GetSeconds(), 0).Format(time.RFC3339))
+	}
+}
+
+// createSnapshot takes a snapshot and reports how it was made
+func createSnapshot(ctx context.Context, admin api.AdminServiceClient, name string) {
+	resp, err := admin.CreateSnapshot(ctx, &api.CreateSnapshotRequest{Name: name})
+	if err != nil {
+		log.Fatalf("CreateSnapshot failed: %v", err)
+	}
+	how := "hard-linking"
+	if resp.Snapshot.Cloned {
+		how = "cloning"
+	}
+	fmt.Printf("Snapshot /.snapshots/%s taken by %s %d entries in %s\n", resp.Snapshot.Name, how,
+		resp.Snapshot.Files, time.Duration(resp.DurationNanos).Round(time.Millisecond))
+}
+
+// deleteSnapshot deletes a snapshot
+func deleteSnapshot(ctx context.Context, admin api.AdminServiceClient, name string) {
+	if _, err := admin.DeleteSnapshot(ctx, &api.DeleteSnapshotRequest{Name: name}); err != nil {
+		log.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	fmt.Printf("Deleted snapshot %s\n", name)
+}
+
+// setExportPolicy replaces the server's export policy and reports the result
+func setExportPolicy(ctx context.Context, admin api.AdminServiceClient, req *api.SetExportPolicyRequest) {
+	resp, err := admin.SetExportPolicy(ctx, req)
+	if err != nil {
+		log.Fatalf("SetExportPolicy failed: %v", err)
+	}
+	fmt.Printf("Export policy %d in effect; %d cached responses dropped\n", resp.Epoch, resp.Scrubbed)
+}
+
+// captureTrace records an execution trace on the server and saves it to file
+func captureTrace(ctx context.Context, admin api.AdminServiceClient, duration time.Duration, file string) {
+	stream, err := admin.CaptureTrace(ctx, &api.CaptureTraceRequest{
+		DurationSeconds: uint32((duration + time.Second - 1) / time.Second),
+	})
+	if err != nil {
+		log.Fatalf("CaptureTrace failed: %v", err)
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", file, err)
+	}
+	defer out.Close()
+
+	fmt.Printf("Tracing for %s...\n", duration)
+	var written int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("CaptureTrace failed: %v", err)
+		}
+		n, err := out.Write(chunk.Data)
+		if err != nil {
+			log.Fatalf("Failed to write %s: %v", file, err)
+		}
+		written += int64(n)
+	}
+
+	fmt.Printf("Wrote %d bytes to %s; view it with \"go tool trace %s\"\n", written, file, file)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// runCp implements "nfsctl cp"
+func runCp(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("cp", flag.ContinueOnError)
+	modeStr := flags.String("mode", "0644", "Permissions of a new destination (octal)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: nfsctl cp [-mode perm] <path> <path>")
+	}
+	mode, err := strconv.ParseUint(*modeStr, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %q", *modeStr)
+	}
+	return c.CopyFile(ctx, flags.Arg(0), flags.Arg(1), uint32(mode))
+}
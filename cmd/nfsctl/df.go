@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// runDf implements "nfsctl df"
+func runDf(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("df", flag.ContinueOnError)
+	human := flags.Bool("h", false, "Print sizes in powers of 1024 (e.g. 1.5G)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/"
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	handle, err := c.LookupPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	stat, err := c.FsStat(ctx, handle)
+	if err != nil {
+		return err
+	}
+
+	used := stat.TotalBytes - stat.FreeBytes
+	size := func(n uint64) string {
+		if *human {
+			return humanSize(n)
+		}
+		return fmt.Sprintf("%d", n/1024)
+	}
+
+	header := "1K-blocks"
+	if *human {
+		header = "Size"
+	}
+	fmt.Printf("%-20s %12s %12s %12s %5s\n", "Path", header, "Used", "Available", "Use%")
+	fmt.Printf("%-20s %12s %12s %12s %5s\n", path, size(stat.TotalBytes), size(used),
+		size(stat.AvailBytes), percent(used, used+stat.AvailBytes))
+	fmt.Printf("%-20s %12d %12d %12d %5s\n", "(inodes)", stat.TotalFiles,
+		stat.TotalFiles-stat.FreeFiles, stat.FreeFiles, percent(stat.TotalFiles-stat.FreeFiles, stat.TotalFiles))
+	return nil
+}
+
+// runQuota implements "nfsctl quota"
+func runQuota(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("quota", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/"
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	handle, err := c.LookupPath(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	quota, err := c.GetQuota(ctx, handle)
+	var nfsErr *client.NFSError
+	if errors.As(err, &nfsErr) && nfsErr.Status == api.Status_ERR_NOTSUPP {
+		fmt.Printf("No quotas are enforced on %s\n", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	limit := func(n uint64) string {
+		if n == 0 {
+			return "none"
+		}
+		return fmt.Sprintf("%d", n)
+	}
+
+	fmt.Printf("Quota for uid %d on %s\n", quota.Uid, path)
+	fmt.Printf("%-8s %14s %14s %5s\n", "", "Used", "Limit", "Use%")
+	fmt.Printf("%-8s %14s %14s %5s\n", "bytes", humanSize(quota.BytesUsed),
+		limitSize(quota.BytesLimit), percent(quota.BytesUsed, quota.BytesLimit))
+	fmt.Printf("%-8s %14d %14s %5s\n", "files", quota.FilesUsed,
+		limit(quota.FilesLimit), percent(quota.FilesUsed, quota.FilesLimit))
+	return nil
+}
+
+// humanSize formats a byte count with a binary unit suffix
+func humanSize(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n)
+	unit := -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%c", value, units[unit])
+}
+
+// limitSize formats a byte limit, where zero means unlimited
+func limitSize(n uint64) string {
+	if n == 0 {
+		return "none"
+	}
+	return humanSize(n)
+}
+
+// percent formats used/total as a rounded-up percentage, or "-" if total is 0
+func percent(used, total uint64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", (used*100+total-1)/total)
+}
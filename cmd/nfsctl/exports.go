@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// runExports implements "nfsctl exports"
+func runExports(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("exports", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	exports, err := c.ListExports(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-20s %s\n", "Export", "Mode")
+	for _, export := range exports {
+		mode := "rw"
+		if export.ReadOnly {
+			mode = "ro"
+		}
+		if export.Default {
+			mode += " (default)"
+		}
+		fmt.Printf("%-20s %s\n", export.Name, mode)
+	}
+	return nil
+}
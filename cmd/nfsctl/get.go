@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// stateSuffix names the file next to the local file that records how far
+// an interrupted get or put got
+const stateSuffix = ".nfsctl-state"
+
+// checkpointInterval is how often the transfer state is saved
+const checkpointInterval = time.Second
+
+// runGet implements "nfsctl get"
+func runGet(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	resume := flags.Bool("resume", false, "Continue an interrupted download of the same file")
+	progress := flags.Bool("progress", false, "Show progress on stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: nfsctl get [-resume] [-progress] <path> [local-file]")
+	}
+	source := flags.Arg(0)
+	target := path.Base(path.Clean("/" + source))
+	if flags.NArg() == 2 {
+		target = flags.Arg(1)
+	}
+
+	// Resumed downloads keep what earlier attempts wrote
+	openFlag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if *resume {
+		openFlag = os.O_WRONLY | os.O_CREATE
+	}
+	f, err := os.OpenFile(target, openFlag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	opts, finish, err := transferOptions(target, *resume, *progress, f.Sync)
+	if err != nil {
+		return err
+	}
+	err = c.Download(ctx, source, f, opts)
+	if err == nil {
+		// The file may have been longer before
+		err = f.Truncate(opts.State.Size)
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	return finish(err)
+}
+
+// transferOptions sets up the state and progress reporting of a get or put
+// of the local file local. With resume the state saved by an interrupted
+// attempt is loaded; sync makes the local side durable before the state is
+// saved. finish saves or removes the state once the transfer ended with err
+// and returns err.
+func transferOptions(local string, resume bool, progress bool, sync func() error) (client.TransferOptions, func(error) error, error) {
+	stateFile := local + stateSuffix
+	state := &client.TransferState{}
+	if resume {
+		var err error
+		if state, err = client.LoadTransferState(stateFile); err != nil {
+			return client.TransferOptions{}, nil, err
+		}
+	}
+
+	var saved time.Time
+	save := func(s *client.TransferState) error {
+		if err := sync(); err != nil {
+			return err
+		}
+		saved = time.Now()
+		return s.Save(stateFile)
+	}
+
+	opts := client.TransferOptions{
+		State: state,
+		Checkpoint: func(s *client.TransferState) error {
+			if time.Since(saved) < checkpointInterval {
+				return nil
+			}
+			return save(s)
+		},
+	}
+	if progress {
+		var shown time.Time
+		opts.Progress = func(done, total int64) {
+			if time.Since(shown) < 100*time.Millisecond && done < total {
+				return
+			}
+			shown = time.Now()
+			percent := 100.0
+			if total > 0 {
+				percent = float64(done) * 100 / float64(total)
+			}
+			fmt.Fprintf(os.Stderr, "\r%s / %s (%.0f%%)", humanSize(uint64(done)), humanSize(uint64(total)), percent)
+		}
+	}
+
+	finish := func(err error) error {
+		if progress {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err == nil {
+			os.Remove(stateFile)
+			return nil
+		}
+		// Keep what was done for -resume
+		if state.Completed() > 0 {
+			if saveErr := save(state); saveErr == nil {
+				err = fmt.Errorf("%w (run again with -resume to continue)", err)
+			}
+		}
+		return err
+	}
+	return opts, finish, nil
+}
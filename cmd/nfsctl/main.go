@@ -0,0 +1,125 @@
+// Command nfsctl inspects files on an NFS server from the command line
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/client"
+	pkgconfig "github.com/example/nfsserver/pkg/config"
+)
+
+// command is an nfsctl subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, c client.NFSClient, args []string) error
+}
+
+// commands lists all subcommands by name
+var commands = map[string]command{
+	"cp":      {"cp [-mode perm] <path> <path>", "Copy a file on the server", runCp},
+	"df":      {"df [-h] [path]", "Show file system capacity", runDf},
+	"exports": {"exports", "List the server's exports", runExports},
+	"get":     {"get [-resume] <path> [local]", "Download a file, -resume continues an interrupted one", runGet},
+	"put":     {"put [-atomic] <local> <path>", "Upload a file (- reads stdin), -resume continues an interrupted one", runPut},
+	"quota":   {"quota [path]", "Show quota usage", runQuota},
+	"rmtree":  {"rmtree [-j n] [-background] <path>", "Delete a directory tree on the server", runRmtree},
+	"stat":    {"stat [-json] <path>", "Show file attributes and handle details", runStat},
+	"sum":     {"sum [-a algorithm] <path>...", "Compute checksums on the server", runSum},
+	"tail":    {"tail [-n lines] [-f] <path>", "Print the end of a file, optionally following it", runTail},
+	"watch":   {"watch [-r] [-json] <path>", "Print changes below a directory as they happen", runWatch},
+}
+
+// usageHeader lists the commands ahead of the flags in usage
+func usageHeader() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Usage: nfsctl [flags] <command> [args]\n\n")
+	fmt.Fprintf(&b, "Commands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-28s %s\n", commands[name].usage, commands[name].help)
+	}
+
+	fmt.Fprintf(&b, "\nFlags:")
+	return b.String()
+}
+
+func main() {
+	// Load the configuration from the command line, environment and file
+	config := client.DefaultConfig()
+	config.Tag = "nfsctl"
+	var keyFile string
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	anonymous := false
+	settings := pkgconfig.NewSet("nfsctl", "NFSCTL")
+	settings.Header = usageHeader()
+	pkgconfig.Client(settings, config, &keyFile)
+	settings.Uint32(&uid, "uid", "User ID to act as")
+	settings.Uint32(&gid, "gid", "Group ID to act as")
+	settings.Bool(&anonymous, "anonymous", "Send no credentials (for exports allowing anonymous access)")
+	if err := settings.Load(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "nfsctl: %v\n", err)
+		os.Exit(1)
+	}
+	args := settings.Args()
+
+	if len(args) < 1 {
+		settings.Usage(os.Stderr)
+		os.Exit(1)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		settings.Usage(os.Stderr)
+		os.Exit(1)
+	}
+
+	// Connect to the server
+	if keyFile != "" {
+		key, err := client.LoadKeyFile(keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nfsctl: %v\n", err)
+			os.Exit(1)
+		}
+		config.EncryptionKey = key
+	}
+
+	nfsClient, err := client.NewClient(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nfsctl: %v\n", err)
+		os.Exit(1)
+	}
+	defer nfsClient.Close()
+
+	// Each RPC is bounded by the client timeout; the command as a whole
+	// runs until it finishes or is interrupted
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if anonymous {
+		ctx = client.WithoutCredentials(ctx)
+	} else {
+		ctx = client.WithCredentials(ctx, uid, gid)
+	}
+
+	if err := cmd.run(ctx, nfsClient, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "nfsctl %s: %v\n", args[0], err)
+		nfsClient.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// runPut implements "nfsctl put"
+func runPut(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	atomic := flags.Bool("atomic", false, "Stage the data in an unnamed file and link it into place")
+	modeStr := flags.String("mode", "0644", "Permissions of the remote file (octal)")
+	resume := flags.Bool("resume", false, "Continue an interrupted upload of the same file")
+	progress := flags.Bool("progress", false, "Show progress on stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: nfsctl put [-atomic] [-resume] [-progress] [-mode perm] <local-file|-> <path>")
+	}
+	source, target := flags.Arg(0), flags.Arg(1)
+
+	mode, err := strconv.ParseUint(*modeStr, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %q", *modeStr)
+	}
+	if *resume && (*atomic || source == "-") {
+		return errors.New("-resume needs a local file and cannot be combined with -atomic")
+	}
+
+	if *atomic || source == "-" {
+		var data []byte
+		if source == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(source)
+		}
+		if err != nil {
+			return err
+		}
+		if *atomic {
+			return c.WriteFileAtomic(ctx, target, data, uint32(mode))
+		}
+		return c.Upload(ctx, bytes.NewReader(data), int64(len(data)), target, uint32(mode), client.TransferOptions{})
+	}
+
+	// Plain put: create (truncating any existing file) and write in place
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The remote writes are FILE_SYNC, so there is nothing to sync locally
+	opts, finish, err := transferOptions(source, *resume, *progress, func() error { return nil })
+	if err != nil {
+		return err
+	}
+	opts.Verifier = client.FileVerifier(info)
+	return finish(c.Upload(ctx, f, info.Size(), target, uint32(mode), opts))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// runRmtree implements "nfsctl rmtree"
+func runRmtree(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("rmtree", flag.ContinueOnError)
+	concurrency := flags.Int("j", 0, "Maximum parallel deletions on the server (0 = server default)")
+	quiet := flags.Bool("q", false, "Do not report progress")
+	background := flags.Bool("background", false, "Return once the tree is out of the way and let the server delete it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: nfsctl rmtree [-j n] [-q] [-background] <path>")
+	}
+
+	target := path.Clean("/" + flags.Arg(0))
+	if target == "/" {
+		return errors.New("refusing to remove the export root")
+	}
+
+	dirHandle, err := c.LookupPath(ctx, path.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	if *background {
+		id, err := c.RemoveTreeInBackground(ctx, dirHandle, path.Base(target))
+		if err == nil && !*quiet {
+			fmt.Fprintf(os.Stderr, "Queued for deletion as trash entry %d\n", id)
+		}
+		return err
+	}
+
+	var last *api.RemoveTreeProgress
+	err = c.RemoveTree(ctx, dirHandle, path.Base(target), *concurrency, func(p *api.RemoveTreeProgress) {
+		last = p
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "\rRemoved %d files, %d directories, %s",
+				p.FilesRemoved, p.DirectoriesRemoved, humanSize(p.BytesRemoved))
+		}
+	})
+	if !*quiet && last != nil {
+		fmt.Fprintln(os.Stderr)
+	}
+	return err
+}
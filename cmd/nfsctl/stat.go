@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// statHandle describes the components of a file handle
+type statHandle struct {
+	Hex          string `json:"hex"`
+	FileSystemID uint32 `json:"filesystem_id"`
+	Inode        uint64 `json:"inode"`
+	Generation   uint32 `json:"generation"`
+}
+
+// statOutput is the -json form of nfsctl stat
+type statOutput struct {
+	Path      string     `json:"path"`
+	Type      string     `json:"type"`
+	Mode      string     `json:"mode"`
+	Perms     string     `json:"permissions"`
+	Nlink     uint32     `json:"nlink"`
+	UID       uint32     `json:"uid"`
+	GID       uint32     `json:"gid"`
+	Size      uint64     `json:"size"`
+	Used      uint64     `json:"used"`
+	RdevMajor uint32     `json:"rdev_major"`
+	RdevMinor uint32     `json:"rdev_minor"`
+	Fsid      uint64     `json:"fsid"`
+	Fileid    uint64     `json:"fileid"`
+	Blksize   uint32     `json:"blksize"`
+	Blocks    uint32     `json:"blocks"`
+	Atime     time.Time  `json:"atime"`
+	Mtime     time.Time  `json:"mtime"`
+	Ctime     time.Time  `json:"ctime"`
+	ChangeID  uint64     `json:"change_id"`
+	Handle    statHandle `json:"handle"`
+}
+
+// runStat implements "nfsctl stat"
+func runStat(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("stat", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print attributes as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: nfsctl stat [-json] <path>")
+	}
+	path := flags.Arg(0)
+
+	handle, err := c.LookupPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	attrs, err := c.GetAttr(ctx, handle)
+	if err != nil {
+		return err
+	}
+
+	out := newStatOutput(path, handle, attrs)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	printStat(os.Stdout, out)
+	return nil
+}
+
+// newStatOutput collects attributes and decoded handle details
+func newStatOutput(path string, handle []byte, attrs *api.FileAttributes) *statOutput {
+	out := &statOutput{
+		Path:      path,
+		Type:      fileTypeName(attrs.Type),
+		Mode:      fmt.Sprintf("%04o", attrs.Mode&07777),
+		Perms:     modeString(attrs.Type, attrs.Mode),
+		Nlink:     attrs.Nlink,
+		UID:       attrs.Uid,
+		GID:       attrs.Gid,
+		Size:      attrs.Size,
+		Used:      attrs.Used,
+		RdevMajor: attrs.RdevMajor,
+		RdevMinor: attrs.RdevMinor,
+		Fsid:      attrs.Fsid,
+		Fileid:    attrs.Fileid,
+		Blksize:   attrs.Blksize,
+		Blocks:    attrs.Blocks,
+		Atime:     fileTime(attrs.Atime),
+		Mtime:     fileTime(attrs.Mtime),
+		Ctime:     fileTime(attrs.Ctime),
+		ChangeID:  attrs.Change,
+		Handle:    statHandle{Hex: hex.EncodeToString(handle)},
+	}
+
+	if fh, err := fs.DeserializeFileHandle(handle); err == nil {
+		out.Handle.FileSystemID = fh.FileSystemID
+		out.Handle.Inode = fh.Inode
+		out.Handle.Generation = fh.Generation
+	}
+
+	return out
+}
+
+// printStat prints attributes in the layout used by stat(1)
+func printStat(w io.Writer, s *statOutput) {
+	fmt.Fprintf(w, "  File: %s\n", s.Path)
+	fmt.Fprintf(w, "  Size: %-15d Blocks: %-10d IO Block: %-6d %s\n", s.Size, s.Blocks, s.Blksize, s.Type)
+	fmt.Fprintf(w, "  Used: %-15d Device: %-10d Inode: %-10d Links: %d\n", s.Used, s.Fsid, s.Fileid, s.Nlink)
+	if s.Type == "block special file" || s.Type == "character special file" {
+		fmt.Fprintf(w, "  Rdev: %d,%d\n", s.RdevMajor, s.RdevMinor)
+	}
+	fmt.Fprintf(w, "Access: (%s/%s)  Uid: (%5d)   Gid: (%5d)\n", s.Mode, s.Perms, s.UID, s.GID)
+	fmt.Fprintf(w, "Access: %s\n", s.Atime.Format(statTimeFormat))
+	fmt.Fprintf(w, "Modify: %s\n", s.Mtime.Format(statTimeFormat))
+	fmt.Fprintf(w, "Change: %s  (change ID %d)\n", s.Ctime.Format(statTimeFormat), s.ChangeID)
+	fmt.Fprintf(w, "Handle: %s\n", s.Handle.Hex)
+	fmt.Fprintf(w, "        FSID: %d  Inode: %d  Generation: %d\n",
+		s.Handle.FileSystemID, s.Handle.Inode, s.Handle.Generation)
+}
+
+// statTimeFormat matches the timestamp format of GNU stat
+const statTimeFormat = "2006-01-02 15:04:05.000000000 -0700"
+
+// fileTime converts a protocol timestamp to a local time
+func fileTime(t *api.FileTime) time.Time {
+	return time.Unix(t.GetSeconds(), int64(t.GetNano()))
+}
+
+// fileTypeName returns the stat(1) description of a file type
+func fileTypeName(t api.FileType) string {
+	switch t {
+	case api.FileType_REGULAR:
+		return "regular file"
+	case api.FileType_DIRECTORY:
+		return "directory"
+	case api.FileType_SYMLINK:
+		return "symbolic link"
+	case api.FileType_BLOCK:
+		return "block special file"
+	case api.FileType_CHAR:
+		return "character special file"
+	case api.FileType_FIFO:
+		return "fifo"
+	case api.FileType_SOCKET:
+		return "socket"
+	default:
+		return "unknown"
+	}
+}
+
+// modeString renders permission bits like ls -l, e.g. "-rw-r--r--"
+func modeString(fileType api.FileType, mode uint32) string {
+	typeChars := map[api.FileType]byte{
+		api.FileType_DIRECTORY: 'd',
+		api.FileType_SYMLINK:   'l',
+		api.FileType_BLOCK:     'b',
+		api.FileType_CHAR:      'c',
+		api.FileType_FIFO:      'p',
+		api.FileType_SOCKET:    's',
+	}
+
+	buf := []byte("----------")
+	if c, ok := typeChars[fileType]; ok {
+		buf[0] = c
+	}
+
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			buf[i+1] = rwx[i]
+		}
+	}
+
+	// setuid, setgid and sticky bits
+	if mode&04000 != 0 {
+		buf[3] = suidChar(buf[3], 's')
+	}
+	if mode&02000 != 0 {
+		buf[6] = suidChar(buf[6], 's')
+	}
+	if mode&01000 != 0 {
+		buf[9] = suidChar(buf[9], 't')
+	}
+
+	return string(buf)
+}
+
+// suidChar returns the lower-case mark when execute is set, upper-case otherwise
+func suidChar(current byte, mark byte) byte {
+	if current == '-' {
+		return mark - ('a' - 'A')
+	}
+	return mark
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// checksumAlgorithms maps -a values to protocol algorithms
+var checksumAlgorithms = map[string]api.ChecksumAlgorithm{
+	"sha256": api.ChecksumAlgorithm_CHECKSUM_SHA256,
+	"md5":    api.ChecksumAlgorithm_CHECKSUM_MD5,
+	"xxhash": api.ChecksumAlgorithm_CHECKSUM_XXHASH64,
+}
+
+// runSum implements "nfsctl sum"
+func runSum(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("sum", flag.ContinueOnError)
+	algorithmName := flags.String("a", "sha256", "Algorithm: sha256, md5 or xxhash")
+	offset := flags.Uint64("offset", 0, "First byte to hash")
+	length := flags.Uint64("length", 0, "Number of bytes to hash (0 = to end of file)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: nfsctl sum [-a algorithm] [-offset n] [-length n] <path>...")
+	}
+
+	algorithm, ok := checksumAlgorithms[*algorithmName]
+	if !ok {
+		return fmt.Errorf("unknown algorithm %q", *algorithmName)
+	}
+
+	// Print in the format of sha256sum and friends
+	for _, path := range flags.Args() {
+		handle, err := c.LookupPath(ctx, path)
+		if err != nil {
+			return err
+		}
+		sum, _, err := c.Checksum(ctx, handle, algorithm, *offset, *length)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Printf("%s  %s\n", hex.EncodeToString(sum), path)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// tailReadSize is how much is read per step when searching backwards for lines
+const tailReadSize = 8192
+
+// runTail implements "nfsctl tail"
+func runTail(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+	lines := flags.Int("n", 10, "Number of trailing lines to print first")
+	follow := flags.Bool("f", false, "Keep printing data as the file grows")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: nfsctl tail [-n lines] [-f] <path>")
+	}
+	path := flags.Arg(0)
+
+	handle, err := c.LookupPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	attrs, err := c.GetAttr(ctx, handle)
+	if err != nil {
+		return err
+	}
+	size := int64(attrs.Size)
+
+	start, err := lastLinesOffset(ctx, c, handle, size, *lines)
+	if err != nil {
+		return err
+	}
+
+	if !*follow {
+		return copyRange(ctx, c, handle, start, size, os.Stdout)
+	}
+
+	stream, err := c.TailFollowFrom(ctx, path, start)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	_, err = io.Copy(os.Stdout, stream)
+	if ctx.Err() != nil {
+		// Interrupted by the user
+		return nil
+	}
+	return err
+}
+
+// lastLinesOffset returns the offset at which the last n lines of a file start
+func lastLinesOffset(ctx context.Context, c client.NFSClient, handle []byte, size int64, n int) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+
+	offset := size
+	newlines := 0
+	for offset > 0 {
+		count := int64(tailReadSize)
+		if count > offset {
+			count = offset
+		}
+		offset -= count
+
+		data, _, err := c.Read(ctx, handle, offset, int(count))
+		if err != nil {
+			return 0, err
+		}
+
+		// Scan backwards; a trailing newline ends the last line rather than
+		// starting a new one
+		for i := len(data) - 1; i >= 0; i-- {
+			if data[i] != '\n' || offset+int64(i) == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return offset + int64(i) + 1, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// copyRange writes bytes [start, end) of a file to w
+func copyRange(ctx context.Context, c client.NFSClient, handle []byte, start, end int64, w io.Writer) error {
+	for start < end {
+		count := end - start
+		if count > 1024*1024 {
+			count = 1024 * 1024
+		}
+		data, _, err := c.Read(ctx, handle, start, int(count))
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		start += int64(len(data))
+	}
+	return nil
+}
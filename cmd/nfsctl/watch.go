@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// watchOutput is the -json form of one nfsctl watch event
+type watchOutput struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Path    string    `json:"path"`
+	NewPath string    `json:"new_path,omitempty"`
+}
+
+// runWatch implements "nfsctl watch"
+func runWatch(ctx context.Context, c client.NFSClient, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "Also report changes in subdirectories")
+	asJSON := flags.Bool("json", false, "Print one JSON object per event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: nfsctl watch [-r] [-json] <path>")
+	}
+
+	handle, err := c.LookupPath(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	w, err := c.Watch(ctx, handle, *recursive)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	for event := range w.Events() {
+		out := watchOutput{
+			Time:    time.Unix(0, event.TimeNs),
+			Event:   changeName(event.Type),
+			Path:    event.Path,
+			NewPath: event.NewPath,
+		}
+		if *asJSON {
+			if err := enc.Encode(out); err != nil {
+				return err
+			}
+			continue
+		}
+		printWatch(os.Stdout, &out)
+	}
+	// Interrupted by the user, or the stream broke
+	return w.Err()
+}
+
+// printWatch prints an event as a single line
+func printWatch(w io.Writer, out *watchOutput) {
+	line := fmt.Sprintf("%s %-8s %s", out.Time.Format(watchTimeFormat), strings.ToUpper(out.Event), out.Path)
+	if out.NewPath != "" {
+		line += " -> " + out.NewPath
+	}
+	fmt.Fprintln(w, line)
+}
+
+// watchTimeFormat shows when an event happened to the microsecond
+const watchTimeFormat = "15:04:05.000000"
+
+// changeName names a change type for output, e.g. "create"
+func changeName(t api.ChangeType) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "CHANGE_"))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// opStats accumulates latencies for a single operation
+type opStats struct {
+	count    int
+	errors   int
+	original time.Duration
+	replayed time.Duration
+}
+
+func main() {
+	// Parse command line flags
+	serverAddr := flag.String("server", "localhost:2049", "NFS server address")
+	traceFile := flag.String("trace", "", "Trace file recorded by the client")
+	speed := flag.Float64("speed", 1.0, "Replay speed multiplier (1 = original timing, 0 = as fast as possible)")
+	parallel := flag.Int("parallel", 16, "Maximum number of calls in flight")
+	timeout := flag.Duration("timeout", 30*time.Second, "Per-call timeout")
+
+	flag.Parse()
+
+	if *traceFile == "" {
+		fmt.Println("Error: trace file is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *speed < 0 || *parallel <= 0 {
+		log.Fatalf("Invalid -speed or -parallel value")
+	}
+
+	// Load the trace
+	f, err := os.Open(*traceFile)
+	if err != nil {
+		log.Fatalf("Failed to open trace: %v", err)
+	}
+	records, err := client.ReadTrace(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read trace: %v", err)
+	}
+	if len(records) == 0 {
+		log.Fatalf("Trace %s is empty", *traceFile)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Start.Before(records[j].Start)
+	})
+
+	// Connect to the server
+	conn, err := grpc.Dial(*serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	log.Printf("Replaying %d calls from %s against %s (speed %.2fx)", len(records), *traceFile, *serverAddr, *speed)
+
+	var (
+		mu    sync.Mutex
+		stats = make(map[string]*opStats)
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, *parallel)
+	)
+
+	first := records[0].Start
+	begin := time.Now()
+
+	for _, rec := range records {
+		// Honor the original inter-arrival times, scaled by -speed
+		if *speed > 0 {
+			due := begin.Add(time.Duration(float64(rec.Start.Sub(first)) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(rec client.TraceRecord) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			elapsed, err := replay(conn, rec, *timeout)
+			if err != nil {
+				log.Printf("%s: %v", rec.Op, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			st, ok := stats[rec.Op]
+			if !ok {
+				st = &opStats{}
+				stats[rec.Op] = st
+			}
+			st.count++
+			st.original += rec.Duration
+			st.replayed += elapsed
+			if err != nil {
+				st.errors++
+			}
+		}(rec)
+	}
+	wg.Wait()
+
+	// Print the summary
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Printf("Replay finished in %s (trace spanned %s)\n",
+		time.Since(begin).Round(time.Millisecond),
+		records[len(records)-1].Start.Sub(first).Round(time.Millisecond))
+	fmt.Printf("%-16s %8s %8s %14s %14s\n", "Operation", "Calls", "Errors", "Orig avg", "Replay avg")
+	for _, op := range ops {
+		st := stats[op]
+		name := op[strings.LastIndex(op, "/")+1:]
+		fmt.Printf("%-16s %8d %8d %14s %14s\n", name, st.count, st.errors,
+			(st.original / time.Duration(st.count)).Round(time.Microsecond),
+			(st.replayed / time.Duration(st.count)).Round(time.Microsecond))
+	}
+}
+
+// replay re-issues a single recorded call and returns its latency
+func replay(conn *grpc.ClientConn, rec client.TraceRecord, timeout time.Duration) (time.Duration, error) {
+	req, resp, err := messagesFor(rec.Op)
+	if err != nil {
+		return 0, err
+	}
+	if err := proto.Unmarshal(rec.Request, req); err != nil {
+		return 0, fmt.Errorf("failed to decode request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = conn.Invoke(ctx, rec.Op, req, resp)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+
+	// Report NFS-level failures that did not happen in the original run
+	if fd := resp.ProtoReflect().Descriptor().Fields().ByName("status"); fd != nil && fd.Kind() == protoreflect.EnumKind {
+		status := api.Status(resp.ProtoReflect().Get(fd).Enum())
+		if status.String() != rec.Status {
+			return elapsed, fmt.Errorf("status %s differs from recorded %s", status, rec.Status)
+		}
+	}
+
+	return elapsed, nil
+}
+
+// messagesFor returns empty request and response messages for a gRPC method
+func messagesFor(method string) (proto.Message, proto.Message, error) {
+	// method has the form "/package.Service/Method"
+	parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("malformed method name %q", method)
+	}
+
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(parts[0]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown service %q: %w", parts[0], err)
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%q is not a service", parts[0])
+	}
+	md := service.Methods().ByName(protoreflect.Name(parts[1]))
+	if md == nil {
+		return nil, nil, fmt.Errorf("unknown method %q", method)
+	}
+
+	reqType, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return nil, nil, err
+	}
+	respType, err := protoregistry.GlobalTypes.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reqType.New().Interface(), respType.New().Interface(), nil
+}
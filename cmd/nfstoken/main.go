@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func main() {
+	// Parse command line flags
+	keyFile := flag.String("key-file", "", "Token key file of the server (-auth-key-file)")
+	uid := flag.Uint("uid", 1000, "User ID the token grants")
+	gid := flag.Uint("gid", 1000, "Group ID the token grants")
+	groups := flag.String("groups", "", "Comma-separated supplementary group IDs (default the gid)")
+	ttl := flag.Duration("ttl", 0, "How long the token is valid (0 = does not expire)")
+
+	flag.Parse()
+
+	if *keyFile == "" {
+		fmt.Fprintf(os.Stderr, "Usage: nfstoken -key-file <file> [-uid n] [-gid n] [-groups a,b] [-ttl duration]\n")
+		os.Exit(1)
+	}
+
+	key, err := server.LoadAuthKey(*keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load key: %v", err)
+	}
+
+	creds := &api.Credentials{Uid: uint32(*uid), Gid: uint32(*gid)}
+	for _, g := range strings.Split(*groups, ",") {
+		if g == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			log.Fatalf("Bad group %q: %v", g, err)
+		}
+		creds.Groups = append(creds.Groups, uint32(n))
+	}
+
+	token, err := server.MintToken(key, creds, *ttl)
+	if err != nil {
+		log.Fatalf("Failed to mint token: %v", err)
+	}
+	fmt.Println(token)
+}
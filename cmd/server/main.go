@@ -1,58 +1,136 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/example/nfsserver/pkg/client"
+	pkgconfig "github.com/example/nfsserver/pkg/config"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/cachingproxy"
+	"github.com/example/nfsserver/pkg/fs/crypt"
 	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+	"github.com/example/nfsserver/pkg/fs/overlay"
+	"github.com/example/nfsserver/pkg/fs/quota"
+	"github.com/example/nfsserver/pkg/logging"
 	"github.com/example/nfsserver/pkg/server"
+	"github.com/example/nfsserver/pkg/telemetry"
 )
 
 func main() {
-	// Parse command line flags
-	listenAddr := flag.String("listen", ":2049", "Network address to listen on")
-	rootPath := flag.String("root", "./exports", "Root directory to export")
-	maxConcurrent := flag.Int("max-concurrent", 100, "Maximum concurrent requests")
-	maxReadSize := flag.Int("max-read", 1024*1024, "Maximum read size in bytes")
-	maxWriteSize := flag.Int("max-write", 1024*1024, "Maximum write size in bytes")
-	enableRootSquash := flag.Bool("root-squash", true, "Enable root squashing")
-	anonUID := flag.Uint("anon-uid", 65534, "Anonymous user ID")
-	anonGID := flag.Uint("anon-gid", 65534, "Anonymous group ID")
-	requestTimeout := flag.Int("timeout", 30, "Request timeout in seconds")
-	
-	flag.Parse()
-	
-	// Create the server configuration
-	config := &server.Config{
-		ListenAddress:    *listenAddr,
-		MaxConcurrent:    *maxConcurrent,
-		MaxReadSize:      *maxReadSize,
-		MaxWriteSize:     *maxWriteSize,
-		EnableRootSquash: *enableRootSquash,
-		AnonUID:          uint32(*anonUID),
-		AnonGID:          uint32(*anonGID),
-		RequestTimeout:   *requestTimeout,
+	// Load the configuration from the command line, environment and file
+	config := server.DefaultConfig()
+	rootPath := "./exports"
+	exportsFile := ""
+	backend := "local"
+	seedDir := ""
+	shadowRoot := ""
+	atRestKeyFile := ""
+	upstream := client.DefaultConfig()
+	upstream.ServerAddress = ""
+	proxyOptions := cachingproxy.DefaultOptions()
+	var quotaLimits quota.Limits
+	logOptions := logging.DefaultOptions()
+	traceOptions := telemetry.DefaultOptions()
+	settings := pkgconfig.NewSet("nfs-server", "NFS_SERVER")
+	pkgconfig.Server(settings, config, &rootPath, &exportsFile)
+	pkgconfig.Backend(settings, &backend, &seedDir)
+	pkgconfig.Proxy(settings, &backend, upstream, &proxyOptions)
+	pkgconfig.Shadow(settings, config, &shadowRoot)
+	pkgconfig.AtRest(settings, &atRestKeyFile)
+	pkgconfig.Quota(settings, &quotaLimits)
+	pkgconfig.Logging(settings, &logOptions)
+	pkgconfig.Telemetry(settings, &traceOptions)
+	if err := settings.Load(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	
-	// Ensure export directory exists
-	if err := os.MkdirAll(*rootPath, 0755); err != nil {
-		log.Fatalf("Failed to create export directory: %v", err)
+	logger, err := logging.New(os.Stderr, logOptions)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	slog.SetDefault(logger)
+	stopTracing, err := telemetry.Setup(context.Background(), "nfs-server", traceOptions)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	if backend != "local" && exportsFile != "" {
+		log.Fatalf("Invalid configuration: -exports needs -backend local")
 	}
 	
 	// Create the filesystem
-	fileSystem, err := local.NewLocalFileSystem(*rootPath)
+	fileSystem, exportName, err := newFileSystem(backend, rootPath, seedDir, upstream, proxyOptions)
 	if err != nil {
 		log.Fatalf("Failed to initialize filesystem: %v", err)
 	}
+	var atRestKey []byte
+	if atRestKeyFile != "" {
+		if atRestKey, err = client.LoadKeyFile(atRestKeyFile); err != nil {
+			log.Fatalf("Failed to load the at-rest encryption key: %v", err)
+		}
+	}
+	if fileSystem, err = withAtRestKey(fileSystem, atRestKey); err != nil {
+		log.Fatalf("Failed to initialize filesystem: %v", err)
+	}
+	if fileSystem, err = withQuota(fileSystem, quotaLimits); err != nil {
+		log.Fatalf("Failed to count quota usage: %v", err)
+	}
+	if config.ExportName == "" {
+		config.ExportName = exportName
+	}
+	if shadowRoot != "" {
+		shadowFS, err := local.NewLocalFileSystem(shadowRoot)
+		if err != nil {
+			log.Fatalf("Failed to initialize shadow filesystem: %v", err)
+		}
+		config.Shadow = shadowFS
+		slog.Info("Comparing reads with a second copy of the export", "shadow", shadowRoot)
+	}
 	
 	// Create and start the NFS server
 	nfsServer, err := server.NewNFSServer(config, fileSystem)
 	if err != nil {
 		log.Fatalf("Failed to create NFS server: %v", err)
 	}
+	servers := []*server.NFSServer{nfsServer}
+	
+	// Add the exports listed in the exports file
+	if exportsFile != "" {
+		exports, err := pkgconfig.LoadExports(exportsFile, config)
+		if err != nil {
+			log.Fatalf("Invalid exports: %v", err)
+		}
+		for _, export := range exports {
+			layeredFS, err := newExportFileSystem(export)
+			if err != nil {
+				log.Fatalf("Failed to initialize export %q: %v", export.Name, err)
+			}
+			encryptedFS, err := withAtRestKey(layeredFS, atRestKey)
+			if err != nil {
+				log.Fatalf("Failed to initialize export %q: %v", export.Name, err)
+			}
+			exportFS, err := withQuota(encryptedFS, quotaLimits)
+			if err != nil {
+				log.Fatalf("Failed to count quota usage of export %q: %v", export.Name, err)
+			}
+			exportServer, err := nfsServer.AddExport(export.ServerConfig(config), exportFS)
+			if err != nil {
+				log.Fatalf("Failed to add export: %v", err)
+			}
+			servers = append(servers, exportServer)
+		}
+	}
 	
 	// Start the server in a goroutine
 	serverErr := make(chan error, 1)
@@ -71,8 +149,104 @@ func main() {
 			log.Fatalf("Server error: %v", err)
 		}
 	case sig := <-sigChan:
-		log.Printf("Received signal %v, shutting down...", sig)
+		slog.Info("Shutting down", "signal", sig.String())
+	}
+	
+	for _, s := range servers {
+		if err := s.SaveUsage(); err != nil {
+			slog.Error("Saving state failed", "err", err)
+		}
+		if err := s.SaveIndex(); err != nil {
+			slog.Error("Saving state failed", "err", err)
+		}
+		if err := s.DumpHandles(context.Background()); err != nil {
+			slog.Error("Saving state failed", "err", err)
+		}
+	}
+	if closer, ok := fs.As[io.Closer](fileSystem); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("Closing the backend failed", "err", err)
+		}
 	}
 	
-	log.Println("NFS server stopped")
-}
\ No newline at end of file
+	if err := stopTracing(context.Background()); err != nil {
+		slog.Error("Flushing spans failed", "err", err)
+	}
+	slog.Info("NFS server stopped")
+}
+
+// newExportFileSystem returns the file system of an export of the exports
+// file: its root, layered over its lower directories if it has any
+func newExportFileSystem(export pkgconfig.Export) (fs.FileSystem, error) {
+	upperFS, err := local.NewLocalFileSystem(export.Root)
+	if err != nil || len(export.Lowers) == 0 {
+		return upperFS, err
+	}
+	var lowers []fs.FileSystem
+	for _, lower := range export.Lowers {
+		lowerFS, err := local.NewLocalFileSystem(lower)
+		if err != nil {
+			return nil, err
+		}
+		lowers = append(lowers, lowerFS)
+	}
+	return overlay.NewOverlayFileSystem(context.Background(), upperFS, lowers...)
+}
+
+// withAtRestKey wraps fileSystem to store file contents encrypted with
+// key, unless there is none
+func withAtRestKey(fileSystem fs.FileSystem, key []byte) (fs.FileSystem, error) {
+	if key == nil {
+		return fileSystem, nil
+	}
+	return crypt.NewCryptFileSystem(fileSystem, key)
+}
+
+// withQuota wraps fileSystem to enforce limits, unless there are none
+func withQuota(fileSystem fs.FileSystem, limits quota.Limits) (fs.FileSystem, error) {
+	if limits == (quota.Limits{}) {
+		return fileSystem, nil
+	}
+	return quota.NewQuotaFileSystem(context.Background(), fileSystem, limits)
+}
+
+// newFileSystem returns the file system to export and the name it is
+// reported under by default: the directory at rootPath, created if missing,
+// for the memfs backend a tree in memory holding a copy of seedDir, or for
+// the proxy backend the export of the server upstream names
+func newFileSystem(backend string, rootPath string, seedDir string, upstream *client.Config, proxyOptions cachingproxy.Options) (fs.FileSystem, string, error) {
+	if backend == "proxy" {
+		upstreamClient, err := client.NewClient(upstream)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to connect to %s: %w", upstream.ServerAddress, err)
+		}
+		proxyFS, err := cachingproxy.NewCachingProxyFileSystem(context.Background(), upstreamClient, proxyOptions)
+		if err != nil {
+			upstreamClient.Close()
+			return nil, "", err
+		}
+		slog.Info("Serving the export of another server", "upstream", upstream.ServerAddress, "write_back", proxyOptions.WriteBack)
+		return proxyFS, upstream.ServerAddress, nil
+	}
+	if backend == "memfs" {
+		memFS := memfs.NewMemFileSystem()
+		if seedDir == "" {
+			return memFS, "memfs", nil
+		}
+		if err := memFS.LoadDir(seedDir); err != nil {
+			return nil, "", fmt.Errorf("failed to load seed %s: %w", seedDir, err)
+		}
+		slog.Info("Serving a copy of the seed from memory", "seed", seedDir)
+		return memFS, seedDir, nil
+	}
+
+	// Ensure export directory exists
+	if err := os.MkdirAll(rootPath, 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	localFS, err := local.NewLocalFileSystem(rootPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return localFS, rootPath, nil
+}
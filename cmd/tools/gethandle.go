@@ -6,14 +6,18 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
 )
 
 func main() {
 	// Parse command line flags
 	rootPath := flag.String("root", "./exports", "Root directory to export")
 	path := flag.String("path", "/", "Path relative to root")
+	keyFile := flag.String("key-file", "", "Handle key file of the server (-handle-key-file), to print the handle clients use")
+	lifetime := flag.Duration("lifetime", 0, "Handle lifetime of the server (-handle-lifetime), to print a handle valid for as long")
 	
 	flag.Parse()
 	
@@ -24,7 +28,7 @@ func main() {
 	}
 	
 	// Get the file handle
-	handle, err := fileSystem.PathToFileHandle(*path)
+	handle, err := fileSystem.PathToFileHandle(context.Background(), *path)
 	if err != nil {
 		log.Fatalf("Failed to get file handle: %v", err)
 	}
@@ -33,7 +37,7 @@ func main() {
 	fmt.Printf("File handle for '%s': %s\n", *path, hex.EncodeToString(handle))
 	
 	// Test validating the handle
-	resolvedPath, err := fileSystem.FileHandleToPath(handle)
+	resolvedPath, err := fileSystem.FileHandleToPath(context.Background(), handle)
 	if err != nil {
 		log.Fatalf("Failed to validate handle: %v", err)
 	}
@@ -51,6 +55,19 @@ func main() {
 	fmt.Printf("Owner: %d:%d\n", info.Uid, info.Gid)
 	fmt.Printf("Modified: %s\n", info.ModifyTime)
 
+	// Sign the handle as the server would
+	if *keyFile != "" {
+		key, err := server.LoadHandleKey(*keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load handle key: %v", err)
+		}
+		if *lifetime > 0 {
+			handle = server.SignExpiringHandle(key, handle, server.HandleExpiry(time.Now(), *lifetime))
+		} else {
+			handle = server.SignHandle(key, handle)
+		}
+	}
+
 	fmt.Printf("File handle for '%s':\n%s\n", *path, hex.EncodeToString(handle))
 	fmt.Printf("Use this exact string with client:\n")
 	fmt.Printf("./bin/nfsclient -handle %s -op getattr\n", hex.EncodeToString(handle))
@@ -0,0 +1,51 @@
+// Embed runs the NFS server inside another program, exporting a file
+// system kept in memory. pkg/fs/memfs shows what a backend has to
+// implement.
+//
+//	go run ./examples/embed -listen localhost:2049
+//	nfsctl -server localhost:2049 cat /hello.txt
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:2049", "Network address to serve on")
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Fatal(serve(lis))
+}
+
+// serve exports a small in-memory tree on lis until it is closed
+func serve(lis net.Listener) error {
+	fileSystem := memfs.NewMemFileSystem()
+	ctx := context.Background()
+	if _, _, err := fileSystem.Create(ctx, "/", "hello.txt", fs.FileAttr{}, true); err != nil {
+		return err
+	}
+	if _, err := fileSystem.Write(ctx, "/hello.txt", 0, []byte("Hello from memory\n"), true); err != nil {
+		return err
+	}
+
+	// Clients are trusted with root, as the tree is theirs to play with
+	config := server.DefaultConfig()
+	config.ListenAddress = lis.Addr().String()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		return err
+	}
+	return nfsServer.Serve(lis)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+func TestEmbed(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go serve(lis)
+
+	config := client.DefaultConfig()
+	config.ServerAddress = lis.Addr().String()
+	config.Timeout = 5 * time.Second
+	nfsClient, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer nfsClient.Close()
+	c := nfsClient.(*client.Client)
+	ctx := client.WithCredentials(context.Background(), 0, 0)
+
+	f, err := c.Open(ctx, "/hello.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || string(data) != "Hello from memory\n" {
+		t.Fatalf("ReadAll returned %q, %v", data, err)
+	}
+
+	// Files are created owned by root, which is not squashed here
+	root, err := c.LookupPath(ctx, "/")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	if _, _, err := c.Mkdir(ctx, root, "notes", &api.FileAttributes{Mode: 0755}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	f, err = c.OpenFile(ctx, "/notes/todo.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	if _, err := f.Write([]byte("write examples")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if data, err := io.ReadAll(f); err != nil || string(data) != "write examples" {
+		t.Fatalf("ReadAll returned %q, %v", data, err)
+	}
+	f.Close()
+}
@@ -0,0 +1,50 @@
+// Httpfs serves an export over HTTP, using the client as an io/fs.FS so
+// the standard library's http.FileServer does the rest.
+//
+//	go run ./examples/httpfs -server localhost:2049 -listen localhost:8080
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+func main() {
+	serverAddr := flag.String("server", "localhost:2049", "NFS server address")
+	listen := flag.String("listen", "localhost:8080", "Network address to serve HTTP on")
+	flag.Parse()
+
+	handler, closeClient, err := newHandler(*serverAddr)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer closeClient()
+
+	log.Printf("Serving %s on http://%s", *serverAddr, *listen)
+	log.Fatal(http.ListenAndServe(*listen, handler))
+}
+
+// newHandler connects to the server at serverAddr and returns a handler
+// serving its export read-only, and a function closing the connection
+func newHandler(serverAddr string) (http.Handler, func() error, error) {
+	config := client.DefaultConfig()
+	config.ServerAddress = serverAddr
+	nfsClient, err := client.NewClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without encryption NewClient returns a *client.Client, which offers
+	// the io/fs view
+	c, ok := nfsClient.(*client.Client)
+	if !ok {
+		nfsClient.Close()
+		return nil, nil, fmt.Errorf("client does not support io/fs")
+	}
+	return http.FileServer(http.FS(c.FS(context.Background()))), nfsClient.Close, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestHTTPFS(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to chmod temp dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "index.txt"), []byte("served over HTTP"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	nfsServer, err := server.NewNFSServer(server.DefaultConfig(), fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go nfsServer.Serve(lis)
+
+	handler, closeClient, err := newHandler(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("newHandler failed: %v", err)
+	}
+	defer closeClient()
+	web := httptest.NewServer(handler)
+	defer web.Close()
+
+	get := func(path string) string {
+		resp, err := web.Client().Get(web.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET %s: %s", path, resp.Status)
+		}
+		return string(body)
+	}
+	if body := get("/index.txt"); body != "served over HTTP" {
+		t.Errorf("GET /index.txt returned %q", body)
+	}
+	if body := get("/"); !strings.Contains(body, "index.txt") {
+		t.Errorf("Directory listing lacks index.txt: %q", body)
+	}
+}
@@ -0,0 +1,48 @@
+// Mount mounts an export with FUSE until interrupted, the least a program
+// needs to give its users the export as a local directory.
+//
+//	go run ./examples/mount -server localhost:2049 -mount /mnt/nfs
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/example/nfsserver/pkg/fuse"
+)
+
+func main() {
+	serverAddr := flag.String("server", "localhost:2049", "NFS server address")
+	mountPoint := flag.String("mount", "", "Directory to mount the export on")
+	flag.Parse()
+
+	if *mountPoint == "" {
+		log.Fatal("-mount must name the mount point")
+	}
+
+	mounted, err := fuse.Mount(fuse.MountOptions{
+		ServerAddr: *serverAddr,
+		MountPoint: *mountPoint,
+	})
+	if err != nil {
+		log.Fatalf("Failed to mount: %v", err)
+	}
+	log.Printf("Mounted %s on %s; interrupt to unmount", *serverAddr, *mountPoint)
+
+	// Serve until interrupted or unmounted externally
+	served := make(chan error, 1)
+	go func() { served <- mounted.Wait() }()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signals:
+		err = mounted.Close()
+	case err = <-served:
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -3,48 +3,146 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/telemetry"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Config contains the NFS client configuration options
 type Config struct {
-	// ServerAddress is the address of the NFS server (e.g., "localhost:2049")
+	// ServerAddress is the address of the NFS server (e.g., "localhost:2049").
+	// A "dns:///name:port" address connects to every server the name
+	// resolves to, looked up again every ResolveInterval, and spreads
+	// requests over them as LoadBalancing says.
 	ServerAddress string
 	
+	// ResolveInterval is how often dns:/// addresses are looked up again,
+	// so servers added behind the name are used and removed ones dropped
+	// (0 = only when a connection fails)
+	ResolveInterval time.Duration
+	
+	// LoadBalancing picks how requests are spread over the servers an
+	// address resolves to: PickFirst (the default) or RoundRobin. Servers
+	// balanced over must serve the same export with the same handle key.
+	LoadBalancing string
+	
+	// Export names the export to use on servers with several (empty = the
+	// server's default)
+	Export string
+	
 	// Timeout is the default timeout for RPC operations
 	Timeout time.Duration
 	
 	// MaxRetries is the maximum number of retries for operations
 	MaxRetries int
 	
+	// CloseTimeout bounds how long Close spends committing UNSTABLE writes
+	CloseTimeout time.Duration
+	
+	// Hard retries operations through server outages, unreachable or not
+	// answering within Timeout, until they succeed or their context ends,
+	// like an NFS hard mount. A soft client, the default, fails them once
+	// MaxRetries retries or Timeout in total have passed.
+	Hard bool
+	
 	// RetryDelay is the initial delay between retries (will be multiplied by backoff factor)
 	RetryDelay time.Duration
 	
 	// BackoffFactor is the multiplier for retry delay after each attempt
 	BackoffFactor float64
 	
+	// MaxTransferSize caps the read and write sizes taken from the server's
+	// FsInfo, and gRPC's message limits are sized to it (0 =
+	// nfs.MaxTransferSize)
+	MaxTransferSize int
+	
+	// KeepaliveTime pings the server after this long without activity,
+	// and KeepaliveTimeout closes the connection if a ping goes unanswered
+	// that long, so a vanished server is noticed (KeepaliveTime 0 = no
+	// pings). Servers disconnect clients pinging more often than their
+	// minimum, 10s by default.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	
 	// MaxCacheSize is the maximum number of entries in the file handle cache
 	MaxCacheSize int
 	
 	// CacheTTL is the time-to-live for cache entries
 	CacheTTL time.Duration
+	
+	// TraceFile enables record mode: every RPC is appended to this file
+	// so it can later be replayed with cmd/nfsreplay
+	TraceFile string
+	
+	// Tag is sent with every request that has no tag of its own (see WithTag)
+	Tag string
+	
+	// AuthTokenFile holds a bearer token sent with every request, for
+	// servers establishing identity from tokens. The file is read again
+	// when it changes.
+	AuthTokenFile string
+	
+	// TailPollInterval is how often TailFollow checks for appended data
+	TailPollInterval time.Duration
+	
+	// ReplicaAddresses lists servers exporting a mirrored copy of the same
+	// tree. Reads that fail on the primary with an I/O error are retried
+	// against them, looking the file up again by path.
+	ReplicaAddresses []string
+	
+	// EncryptionKey, if set, is a 32-byte master key: file contents are
+	// encrypted before they are sent and decrypted after they are read
+	// (see NewEncryptingClient)
+	EncryptionKey []byte
+	
+	// EncryptNames encrypts file names too; requires EncryptionKey
+	EncryptNames bool
+	
+	// SortedReadDir lists directories sorted by name, from a snapshot the
+	// server takes when the listing starts, so paging through a directory
+	// others are changing neither skips nor repeats entries
+	SortedReadDir bool
+	
+	// DiskCacheDir, if set, keeps recently read blocks in this directory,
+	// shared with other clients on the host using it and kept across
+	// restarts; DiskCacheSize bounds it in bytes
+	DiskCacheDir  string
+	DiskCacheSize int
+	
+	// ClientID identifies the client to the server, which holds its
+	// byte-range locks under it and releases them together if the client
+	// stops renewing them (empty = the host name and a random suffix)
+	ClientID string
+	
+	// Metrics, if set, collects the client's RPC counts, latencies and
+	// cache lookups, and may be shared by several clients; otherwise the
+	// client keeps its own (see Client.Metrics)
+	Metrics *Metrics
 }
 
 // DefaultConfig returns a configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
-		ServerAddress: "localhost:2049",
-		Timeout:       30 * time.Second,
-		MaxRetries:    3,
-		RetryDelay:    500 * time.Millisecond,
-		BackoffFactor: 2.0,
-		MaxCacheSize:  1000,
-		CacheTTL:      5 * time.Minute,
+		ServerAddress:    "localhost:2049",
+		ResolveInterval:  30 * time.Second,
+		Timeout:          30 * time.Second,
+		MaxRetries:       3,
+		CloseTimeout:     10 * time.Second,
+		RetryDelay:       500 * time.Millisecond,
+		BackoffFactor:    2.0,
+		KeepaliveTimeout: 20 * time.Second,
+		MaxCacheSize:     1000,
+		CacheTTL:         5 * time.Minute,
+		TailPollInterval: time.Second,
+		DiskCacheSize:    1 << 30,
 	}
 }
 
@@ -62,6 +160,39 @@ type Client struct {
 	// File handle cache
 	handleCache *HandleCache
 	
+	// RPC trace recorder (nil unless record mode is enabled)
+	tracer *TraceRecorder
+	
+	// Clients for Config.ReplicaAddresses, used as read fallbacks
+	replicas []*Client
+	
+	// Paths that handles were resolved from; only populated when replicas
+	// are configured
+	handlePaths replicaPaths
+	
+	// Read and Write sizes from FsInfo, zero until known (see transferSizes)
+	sizesMu   sync.Mutex
+	readSize  int
+	writeSize int
+	
+	// Blocks read, kept on local disk (nil unless Config.DiskCacheDir)
+	diskCache *diskCache
+	
+	// RPC and cache counts, Config.Metrics if set
+	metrics *Metrics
+	
+	// Set once the server turns out not to support WriteStream
+	noWriteStream atomic.Bool
+	
+	// Files written UNSTABLE and not yet committed, and directory and
+	// file delegations held, which Close settles
+	pending         pendingWrites
+	delegations     delegationSet
+	fileDelegations fileDelegations
+	
+	// Lease on the byte-range locks held, renewed while any are
+	locks lockLease
+	
 	// TODO: Add attribute cache when implemented
 	// attrCache *AttrCache
 }
@@ -71,41 +202,133 @@ func NewClient(config *Config) (NFSClient, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	if err := ValidLoadBalancing(config.LoadBalancing); err != nil {
+		return nil, err
+	}
+	
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+	dialOpts := append(transportOptions(config), balancingOptions(config)...)
+	dialOpts = append(dialOpts,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithChainUnaryInterceptor(telemetry.UnaryClientInterceptor(), tagInterceptor(config.Tag), metrics.interceptor()),
+		grpc.WithChainStreamInterceptor(telemetry.StreamClientInterceptor()),
+	)
+	if config.AuthTokenFile != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&tokenCredentials{file: config.AuthTokenFile}))
+	}
+	
+	// Open the trace file if record mode is enabled
+	var tracer *TraceRecorder
+	if config.TraceFile != "" {
+		var err error
+		tracer, err = OpenTraceFile(config.TraceFile)
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(tracer.UnaryClientInterceptor()))
+	}
 	
 	// Create gRPC connection
 	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
 	defer cancel()
 	
-	conn, err := grpc.DialContext(
-		ctx,
-		config.ServerAddress,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+	conn, err := grpc.DialContext(ctx, config.ServerAddress, dialOpts...)
 	if err != nil {
+		if tracer != nil {
+			tracer.Close()
+		}
 		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
 	
 	// Create NFS service client
 	nfsClient := api.NewNFSServiceClient(conn)
 	
+	// Open the disk cache if configured
+	var blocks *diskCache
+	if config.DiskCacheDir != "" {
+		blocks, err = openDiskCache(config.DiskCacheDir, int64(config.DiskCacheSize))
+		if err != nil {
+			conn.Close()
+			if tracer != nil {
+				tracer.Close()
+			}
+			return nil, err
+		}
+	}
+	
 	// Create handle cache stub
 	// TODO: Implement proper handle cache
 	handleCache := NewHandleCache(config.MaxCacheSize, config.CacheTTL)
 	
 	// Create and return the client
-	return &Client{
+	c := &Client{
 		conn:        conn,
 		nfsClient:   nfsClient,
 		config:      config,
 		handleCache: handleCache,
-	}, nil
+		tracer:      tracer,
+		replicas:    dialReplicas(config, dialOpts, metrics),
+		handlePaths: replicaPaths{max: config.MaxCacheSize},
+		diskCache:   blocks,
+		metrics:     metrics,
+	}
+	if config.EncryptionKey == nil {
+		return c, nil
+	}
+	
+	e, err := newEncryptingClient(c, config.EncryptionKey, config.EncryptNames, config.TailPollInterval)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Metrics returns the counts of the client's RPCs and cache lookups,
+// shared with its replicas
+func (c *Client) Metrics() *Metrics {
+	return c.metrics
 }
 
-// Close closes the client connection
+// GetStatistics returns the totals of the client's RPCs, including those
+// to its replicas
+func (c *Client) GetStatistics() ClientStats {
+	return c.metrics.Stats()
+}
+
+// Close commits the UNSTABLE writes not committed yet, within
+// Config.CloseTimeout, returns the delegations held and closes
+// the connection. Files whose writes may not have reached stable storage
+// are reported in an error matching ErrUnflushed; the connection is closed
+// regardless.
 func (c *Client) Close() error {
+	timeout := c.config.CloseTimeout
+	if timeout <= 0 {
+		timeout = DefaultConfig().CloseTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	flushErr := c.flush(ctx)
+	cancel()
+	c.delegations.returnAll()
+	c.fileDelegations.close()
+	c.locks.close()
+	
+	if c.tracer != nil {
+		if err := c.tracer.Close(); err != nil {
+			log.Printf("Warning: failed to close trace file: %v", err)
+		}
+	}
+	for _, replica := range c.replicas {
+		replica.Close()
+	}
 	if c.conn != nil {
-		return c.conn.Close()
+		if err := c.conn.Close(); err != nil {
+			return errors.Join(flushErr, err)
+		}
 	}
-	return nil
+	return flushErr
 }
\ No newline at end of file
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// CopyFile copies the file at srcPath to dstPath, creating it with mode or
+// truncating it. The server copies the data itself with CopyRange; servers
+// without it get the data read and written back instead. The copy is on
+// stable storage when CopyFile returns.
+func (c *Client) CopyFile(ctx context.Context, srcPath string, dstPath string, mode uint32) error {
+	return copyFile(ctx, c, srcPath, dstPath, mode)
+}
+
+// copyFile implements CopyFile on top of any client
+func copyFile(ctx context.Context, c NFSClient, srcPath string, dstPath string, mode uint32) error {
+	srcPath, dstPath = path.Clean("/"+srcPath), path.Clean("/"+dstPath)
+	dir, name := path.Split(dstPath)
+	if name == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, dstPath)
+	}
+	srcHandle, err := c.LookupPath(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	attrs, err := c.GetAttr(ctx, srcHandle)
+	if err != nil {
+		return err
+	}
+	if attrs.Type == api.FileType_DIRECTORY {
+		return NewNFSError("CopyFile", api.Status_ERR_ISDIR, srcPath, ErrIsDir)
+	}
+	dirHandle, err := c.LookupPath(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	// Truncating the destination must not empty the source
+	if existing, _, err := c.Lookup(ctx, dirHandle, name); err == nil && bytes.Equal(existing, srcHandle) {
+		return fmt.Errorf("%w: %q and %q are the same file", ErrInvalidPath, srcPath, dstPath)
+	}
+	handle, dstAttrs, err := c.Create(ctx, dirHandle, name, &api.FileAttributes{Mode: mode}, api.CreateMode_UNCHECKED)
+	if err != nil {
+		return err
+	}
+	if dstAttrs.GetSize() != 0 {
+		if _, err := c.Truncate(ctx, handle, 0); err != nil {
+			return err
+		}
+	}
+
+	var offset uint64
+	for {
+		n, eof, err := c.CopyRange(ctx, srcHandle, handle, offset, offset, 0)
+		if errors.Is(err, ErrNotImplemented) && offset == 0 {
+			return copyBytes(ctx, c, srcHandle, handle)
+		}
+		if err != nil {
+			return err
+		}
+		offset += n
+		if eof || n == 0 {
+			break
+		}
+	}
+	_, err = c.Commit(ctx, handle, 0, 0)
+	return err
+}
+
+// copyBytes copies the file srcHandle to dstHandle through the client,
+// with FILE_SYNC stability
+func copyBytes(ctx context.Context, c NFSClient, srcHandle []byte, dstHandle []byte) error {
+	readSize, _ := chunkSizes(ctx, c, srcHandle)
+	_, writeSize := chunkSizes(ctx, c, dstHandle)
+	for offset := int64(0); ; {
+		data, eof, err := c.Read(ctx, srcHandle, offset, readSize)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		for len(data) > 0 {
+			n, err := c.Write(ctx, dstHandle, offset, data[:min(len(data), writeSize)], 2) // 2 = FILE_SYNC
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("short write at offset %d", offset)
+			}
+			data = data[n:]
+			offset += int64(n)
+		}
+		if eof {
+			return nil
+		}
+	}
+}
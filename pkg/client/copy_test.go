@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	c, tempDir, ctx := newChunkedClient(t)
+	data := bytes.Repeat([]byte("0123456789"), 1050)
+	if err := os.WriteFile(filepath.Join(tempDir, "big"), data, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "longer"), bytes.Repeat([]byte("x"), 20000), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// New and existing destinations end up holding the source, though
+	// the server moves only 1000 bytes per Read or Write
+	for _, dst := range []string{"/copy", "/longer"} {
+		if err := c.CopyFile(ctx, "/big", dst, 0600); err != nil {
+			t.Fatalf("CopyFile to %s failed: %v", dst, err)
+		}
+		if got, err := os.ReadFile(filepath.Join(tempDir, dst)); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Copy at %s has %d bytes, %v; want the source", dst, len(got), err)
+		}
+	}
+	if err := c.CopyFile(ctx, "/big", "/./big", 0600); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("CopyFile onto the source = %v, want ErrInvalidPath", err)
+	}
+
+	// Ranges land at the offset asked for, and stop at the end of the source
+	src, _ := c.LookupPath(ctx, "/big")
+	dst, _ := c.LookupPath(ctx, "/copy")
+	n, eof, err := c.CopyRange(ctx, src, dst, 10000, 100, 1000)
+	if err != nil || n != 500 || !eof {
+		t.Errorf("CopyRange past the end of the source = %d, %v, %v; want 500 and eof", n, eof, err)
+	}
+	got, _ := os.ReadFile(filepath.Join(tempDir, "copy"))
+	if !bytes.Equal(got[100:600], data[10000:]) || !bytes.Equal(got[600:], data[600:]) {
+		t.Errorf("CopyRange wrote elsewhere than at offset 100")
+	}
+}
+
+func TestCopyFileEncrypted(t *testing.T) {
+	// Encrypted files are copied through the client, under a key of their own
+	c, _, ctx := newLocalClient(t)
+	data := bytes.Repeat([]byte("0123456789"), 1050)
+	e, err := NewEncryptingClient(c, testKey(1), false)
+	if err != nil {
+		t.Fatalf("NewEncryptingClient failed: %v", err)
+	}
+	if err := e.WriteFileAtomic(ctx, "/secret", data, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := e.CopyFile(ctx, "/secret", "/secret-copy", 0600); err != nil {
+		t.Fatalf("CopyFile of an encrypted file failed: %v", err)
+	}
+	handle, err := e.LookupPath(ctx, "/secret-copy")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	if got, _, err := e.Read(ctx, handle, 0, len(data)+1); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Encrypted copy reads %d bytes, %v; want the source", len(got), err)
+	}
+}
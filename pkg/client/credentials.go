@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// credentialsKey is the context key for per-call credentials
+type credentialsKey struct{}
+
+// WithCredentials returns a context whose operations are performed on behalf
+// of the given user instead of the client's default identity. This lets a
+// FUSE daemon ask questions such as Access for the process that made a call.
+func WithCredentials(ctx context.Context, uid, gid uint32, groups ...uint32) context.Context {
+	if len(groups) == 0 {
+		groups = []uint32{gid}
+	}
+	return context.WithValue(ctx, credentialsKey{}, &api.Credentials{
+		Uid:    uid,
+		Gid:    gid,
+		Groups: groups,
+	})
+}
+
+// WithoutCredentials returns a context whose operations are sent with no
+// credentials at all, for servers exporting read-only anonymous access
+func WithoutCredentials(ctx context.Context) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, (*api.Credentials)(nil))
+}
+
+// credentialsFromContext returns the credentials attached to ctx, or the
+// client's default identity when there are none
+func credentialsFromContext(ctx context.Context) *api.Credentials {
+	return credentialsOrDefault(ctx, &api.Credentials{
+		Uid:    1000,
+		Gid:    1000,
+		Groups: []uint32{1000},
+	})
+}
+
+// credentialsOrDefault returns the credentials attached to ctx, or def for
+// operations whose historical default identity differs
+func credentialsOrDefault(ctx context.Context, def *api.Credentials) *api.Credentials {
+	if creds, ok := ctx.Value(credentialsKey{}).(*api.Credentials); ok {
+		return creds
+	}
+	return def
+}
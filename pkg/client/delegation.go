@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DirDelegation is the right, granted by the server, to cache a directory's
+// listing. It holds until the server recalls it because an entry was added,
+// removed or renamed, or until it is returned.
+type DirDelegation struct {
+	// Attributes of the directory when the delegation was granted
+	Attributes *api.FileAttributes
+
+	recalled chan struct{}
+	cancel   context.CancelFunc
+}
+
+// Recalled is closed once the delegation ends, whether the server recalled
+// it, it was returned, or the connection was lost
+func (d *DirDelegation) Recalled() <-chan struct{} {
+	return d.recalled
+}
+
+// Valid reports whether a listing read under the delegation may still be used
+func (d *DirDelegation) Valid() bool {
+	select {
+	case <-d.recalled:
+		return false
+	default:
+		return true
+	}
+}
+
+// Return gives the delegation back to the server
+func (d *DirDelegation) Return() {
+	d.cancel()
+}
+
+// DelegateDirectory asks the server for a delegation of the directory
+// dirHandle. A listing read after it is granted stays accurate until
+// Recalled is closed. Servers that do not support delegations report
+// ErrNotImplemented; a busy server may refuse with ERR_JUKEBOX.
+func (c *Client) DelegateDirectory(ctx context.Context, dirHandle []byte) (*DirDelegation, error) {
+	req := &api.DelegateDirectoryRequest{
+		DirectoryHandle: dirHandle,
+		Credentials:     credentialsFromContext(ctx),
+	}
+
+	// The stream outlives this call, so only ctx's values carry over
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stream, err := c.nfsClient.DelegateDirectory(streamCtx, req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("DelegateDirectory RPC failed: %w", err)
+	}
+
+	// Bound the wait for the grant by the usual timeout
+	timer := time.AfterFunc(c.config.Timeout, cancel)
+	granted, err := stream.Recv()
+	timer.Stop()
+	if status.Code(err) == codes.Unimplemented {
+		cancel()
+		return nil, NewNFSError("DelegateDirectory", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("DelegateDirectory RPC failed: %w", err)
+	}
+	if granted.Status != api.Status_OK {
+		cancel()
+		return nil, StatusToError("DelegateDirectory", granted.Status)
+	}
+
+	deleg := &DirDelegation{
+		Attributes: granted.DirAttributes,
+		recalled:   make(chan struct{}),
+		cancel:     cancel,
+	}
+
+	// Anything but a grant, including a broken stream, ends the delegation
+	c.delegations.add(deleg)
+	go func() {
+		defer close(deleg.recalled)
+		defer cancel()
+		defer c.delegations.remove(deleg)
+		for {
+			event, err := stream.Recv()
+			if err != nil || event.Type == api.DelegationEventType_DELEGATION_RECALLED {
+				return
+			}
+		}
+	}()
+
+	return deleg, nil
+}
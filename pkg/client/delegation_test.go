@@ -0,0 +1,49 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+func TestDirectoryDelegationRecall(t *testing.T) {
+	c, _, ctx := newLocalClient(t)
+
+	rootHandle, err := c.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+
+	deleg, err := c.DelegateDirectory(ctx, rootHandle)
+	if err != nil {
+		t.Fatalf("DelegateDirectory failed: %v", err)
+	}
+	if !deleg.Valid() {
+		t.Fatal("Delegation is not valid after being granted")
+	}
+	if deleg.Attributes.GetType() != api.FileType_DIRECTORY {
+		t.Errorf("Delegated attributes type = %v, want DIRECTORY", deleg.Attributes.GetType())
+	}
+
+	if _, _, err := c.Mkdir(ctx, rootHandle, "obj", &api.FileAttributes{Mode: 0755}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	select {
+	case <-deleg.Recalled():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delegation was not recalled after Mkdir")
+	}
+
+	// Returning a delegation ends it as well
+	deleg, err = c.DelegateDirectory(ctx, rootHandle)
+	if err != nil {
+		t.Fatalf("DelegateDirectory failed: %v", err)
+	}
+	deleg.Return()
+	select {
+	case <-deleg.Recalled():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delegation still valid after Return")
+	}
+}
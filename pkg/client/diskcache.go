@@ -0,0 +1,289 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+const (
+	// diskCacheBlockSize is the unit file contents are cached in
+	diskCacheBlockSize = 256 * 1024
+
+	// diskCacheRevalidate is how long a file's change attribute is trusted
+	// before the server is asked again, like the attribute cache of an NFS
+	// mount
+	diskCacheRevalidate = 3 * time.Second
+
+	// diskCacheLowWater is the share of the size limit eviction frees down to
+	diskCacheLowWater = 0.9
+)
+
+// diskCache keeps recently read blocks in a local directory, so they are
+// shared by every client on the host using the same directory and survive
+// remounts. Blocks are stored under the file handle and the change
+// attribute they were read under, so a modified file is never served from
+// old blocks; those age out instead. Blocks hold what the server returned,
+// ciphertext for encrypting clients.
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64 // Bytes in dir, as of the last scan plus what was stored since
+
+	// Change attributes and sizes learned from the server, by string(handle)
+	known map[string]knownVersion
+}
+
+// knownVersion is the version of a file a client last saw
+type knownVersion struct {
+	change  uint64
+	size    uint64
+	checked time.Time
+}
+
+// openDiskCache opens the cache in dir, creating it if needed, limited to
+// maxSize bytes
+func openDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("disk cache: %w", err)
+	}
+	d := &diskCache{dir: dir, maxSize: maxSize, known: make(map[string]knownVersion)}
+	d.size = d.scan(nil)
+	return d, nil
+}
+
+// cachedFile is a block file found by scan
+type cachedFile struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+// scan returns the bytes held in the cache, appending each block to files
+// if it is not nil
+func (d *diskCache) scan(files *[]cachedFile) int64 {
+	var total int64
+	filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		total += info.Size()
+		if files != nil {
+			*files = append(*files, cachedFile{path: path, size: info.Size(), mtime: info.ModTime()})
+		}
+		return nil
+	})
+	return total
+}
+
+// blockPath returns the file holding block index of the file with handle
+// at change
+func (d *diskCache) blockPath(handle []byte, change uint64, index int64) string {
+	sum := sha256.Sum256(handle)
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.dir, name[:2], fmt.Sprintf("%s-%d-%d", name, change, index))
+}
+
+// load returns a cached block, marking it recently used
+func (d *diskCache) load(handle []byte, change uint64, index int64) ([]byte, bool) {
+	path := d.blockPath(handle, change, index)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// store caches a block. Blocks are written to a temporary file and renamed
+// into place, so other processes never read a partial one.
+func (d *diskCache) store(handle []byte, change uint64, index int64, data []byte) {
+	path := d.blockPath(handle, change, index)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Printf("Warning: disk cache: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".block-*")
+	if err != nil {
+		log.Printf("Warning: disk cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Warning: disk cache: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	d.size += int64(len(data))
+	full := d.size > d.maxSize
+	d.mu.Unlock()
+	if full {
+		d.evict()
+	}
+}
+
+// evict removes the least recently used blocks until the cache is below
+// its low-water mark. The directory is rescanned, so blocks stored by
+// other processes count too.
+func (d *diskCache) evict() {
+	var files []cachedFile
+	total := d.scan(&files)
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+
+	target := int64(float64(d.maxSize) * diskCacheLowWater)
+	for _, f := range files {
+		if total <= target {
+			break
+		}
+		if strings.HasPrefix(filepath.Base(f.path), ".block-") && time.Since(f.mtime) < time.Minute {
+			continue // Still being written
+		}
+		if err := os.Remove(f.path); err == nil || os.IsNotExist(err) {
+			total -= f.size
+		}
+	}
+
+	d.mu.Lock()
+	d.size = total
+	d.mu.Unlock()
+}
+
+// version returns the version of a file last seen, if it was checked
+// recently enough to trust
+func (d *diskCache) version(handle []byte) (knownVersion, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.known[string(handle)]
+	if !ok || time.Since(v.checked) > diskCacheRevalidate {
+		return knownVersion{}, false
+	}
+	return v, true
+}
+
+// remember records the version of a file seen in attrs
+func (d *diskCache) remember(handle []byte, attrs *api.FileAttributes) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.known[string(handle)] = knownVersion{change: attrs.Change, size: attrs.Size, checked: time.Now()}
+}
+
+// forget drops the version of a file this client is changing, so the next
+// read asks the server
+func (d *diskCache) forget(handle []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.known, string(handle))
+}
+
+// forgetVersion is forget for clients that may have no disk cache
+func (c *Client) forgetVersion(handle []byte) {
+	if c.diskCache != nil {
+		c.diskCache.forget(handle)
+	}
+}
+
+// readCached serves a Read from the disk cache, fetching the blocks it
+// lacks whole from the server. Servers not reporting a change attribute
+// are read directly.
+func (c *Client) readCached(ctx context.Context, fileHandle []byte, offset int64, count int) ([]byte, bool, error) {
+	d := c.diskCache
+	v, ok := d.version(fileHandle)
+	if !ok {
+		attrs, err := c.GetAttr(ctx, fileHandle)
+		if err != nil {
+			return nil, false, err
+		}
+		d.remember(fileHandle, attrs)
+		v = knownVersion{change: attrs.Change, size: attrs.Size}
+	}
+	if v.change == 0 {
+		data, eof, _, err := c.readRemote(ctx, fileHandle, offset, count)
+		return data, eof, err
+	}
+
+	end := min(uint64(offset)+uint64(count), v.size)
+	var data []byte
+	for pos := uint64(offset); pos < end; {
+		index := int64(pos / diskCacheBlockSize)
+		block, ok := d.load(fileHandle, v.change, index)
+		c.metrics.CacheLookup(CacheDisk, ok)
+		if !ok {
+			var err error
+			block, ok, err = c.fetchBlock(ctx, fileHandle, v, index)
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				// The file changed since its version was learned
+				d.forget(fileHandle)
+				data, eof, _, err := c.readRemote(ctx, fileHandle, offset, count)
+				return data, eof, err
+			}
+		}
+		start := pos - uint64(index)*diskCacheBlockSize
+		if start >= uint64(len(block)) {
+			break
+		}
+		n := min(uint64(len(block))-start, end-pos)
+		data = append(data, block[start:start+n]...)
+		pos += n
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, uint64(offset)+uint64(len(data)) >= v.size, nil
+}
+
+// fetchBlock reads block index of the file at version v from the server
+// and caches it. ok is false if the server's copy is no longer at v.
+func (c *Client) fetchBlock(ctx context.Context, fileHandle []byte, v knownVersion, index int64) ([]byte, bool, error) {
+	start := index * diskCacheBlockSize
+	length := min(int64(diskCacheBlockSize), int64(v.size)-start)
+	readSize, _ := c.transferSizes(ctx, fileHandle)
+	block := make([]byte, 0, length)
+	for int64(len(block)) < length {
+		count := min(int(length)-len(block), readSize)
+		chunk, eof, attrs, err := c.readRemote(ctx, fileHandle, start+int64(len(block)), count)
+		if err != nil {
+			return nil, false, err
+		}
+		if attrs == nil || attrs.Change != v.change {
+			return nil, false, nil
+		}
+		block = append(block, chunk...)
+		if eof || len(chunk) == 0 {
+			break
+		}
+	}
+	if int64(len(block)) != length {
+		return nil, false, nil
+	}
+	c.diskCache.store(fileHandle, v.change, index, block)
+	return block, true, nil
+}
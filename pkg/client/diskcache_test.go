@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// countingReads counts the Read RPCs a client sends
+type countingReads struct {
+	api.NFSServiceClient
+	reads *atomic.Int64
+}
+
+func (c countingReads) Read(ctx context.Context, req *api.ReadRequest, opts ...grpc.CallOption) (*api.ReadResponse, error) {
+	c.reads.Add(1)
+	return c.NFSServiceClient.Read(ctx, req, opts...)
+}
+
+func TestDiskCache(t *testing.T) {
+	tempDir := t.TempDir()
+	data := make([]byte, 3*diskCacheBlockSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "big"), data, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	// Two clients sharing a cache directory, like two mounts on one host
+	cacheDir := t.TempDir()
+	var reads atomic.Int64
+	newCachingClient := func() *Client {
+		c := newServiceClient(t, nfsServer)
+		c.nfsClient = countingReads{NFSServiceClient: c.nfsClient, reads: &reads}
+		c.diskCache, err = openDiskCache(cacheDir, 1<<30)
+		if err != nil {
+			t.Fatalf("openDiskCache failed: %v", err)
+		}
+		return c
+	}
+	first, second := newCachingClient(), newCachingClient()
+	handle, err := first.LookupPath(ctx, "/big")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+
+	// A read spanning blocks returns the data and caches whole blocks
+	offset := int64(diskCacheBlockSize - 10)
+	got, eof, err := first.Read(ctx, handle, offset, diskCacheBlockSize)
+	if err != nil || eof || !bytes.Equal(got, data[offset:offset+diskCacheBlockSize]) {
+		t.Fatalf("Read returned %d bytes, eof %v, %v", len(got), eof, err)
+	}
+	if reads.Load() == 0 {
+		t.Fatalf("Read was not sent to the server")
+	}
+
+	// The other client reads the cached blocks without asking the server
+	reads.Store(0)
+	got, _, err = second.Read(ctx, handle, diskCacheBlockSize, 1000)
+	if err != nil || !bytes.Equal(got, data[diskCacheBlockSize:diskCacheBlockSize+1000]) {
+		t.Fatalf("Read from the shared cache returned %d bytes, %v", len(got), err)
+	}
+	if n := reads.Load(); n != 0 {
+		t.Errorf("Read of cached blocks sent %d Read RPCs, want 0", n)
+	}
+
+	// The short last block reports the end of the file
+	got, eof, err = second.Read(ctx, handle, int64(len(data)-50), 1000)
+	if err != nil || !eof || !bytes.Equal(got, data[len(data)-50:]) {
+		t.Errorf("Read of the end returned %d bytes, eof %v, %v", len(got), eof, err)
+	}
+
+	// Blocks of an older version are not served once the file changes
+	if _, err := first.Write(ctx, handle, offset, []byte("changed"), 2); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	got, _, err = first.Read(ctx, handle, offset, 7)
+	if err != nil || string(got) != "changed" {
+		t.Errorf("Read after Write returned %q, %v", got, err)
+	}
+
+	// The cache stays within its size, evicting the least recently used
+	small, err := openDiskCache(t.TempDir(), 2*diskCacheBlockSize)
+	if err != nil {
+		t.Fatalf("openDiskCache failed: %v", err)
+	}
+	first.diskCache = small
+	if _, _, err := first.Read(ctx, handle, 0, len(data)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if size := small.scan(nil); size > 2*diskCacheBlockSize {
+		t.Errorf("Cache holds %d bytes, above its limit of %d", size, 2*diskCacheBlockSize)
+	}
+}
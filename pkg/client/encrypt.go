@@ -0,0 +1,1016 @@
+package client
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// Layout of encrypted files. A file starts with a header holding a random
+// file ID and the file's own key, sealed with the master key. The data
+// follows in blocks of cryptBlockSize plaintext bytes, each sealed with the
+// file key under a fresh random nonce and stored as nonce, ciphertext, tag;
+// the last block may be shorter. Blocks are bound to their position, so
+// they cannot be reordered, but dropping whole blocks from the end of a
+// file is not detected.
+const (
+	cryptMagic        = "NFSE"
+	cryptVersion      = 1
+	cryptKeySize      = 32
+	cryptFileIDSize   = 16
+	cryptNonceSize    = 12
+	cryptTagSize      = 16
+	cryptBlockSize    = 4096
+	cryptOverhead     = cryptNonceSize + cryptTagSize
+	cryptStoredBlock  = cryptBlockSize + cryptOverhead
+	cryptHeaderPrefix = 4 + 4 + cryptFileIDSize // magic, version, 3 reserved bytes, file ID
+	cryptHeaderSize   = cryptHeaderPrefix + cryptNonceSize + cryptKeySize + cryptTagSize
+
+	// Blocks sent in one Write RPC
+	cryptWriteBlocks = 128
+
+	// Unwrapped file keys kept before the cache is emptied
+	cryptKeyCacheSize = 4096
+
+	// Locks serializing the read-modify-write of blocks, chosen by handle
+	cryptLockStripes = 64
+)
+
+// Labels for the keys derived from the master key
+const (
+	cryptWrapLabel = "nfs file key wrapping"
+	cryptNameLabel = "nfs file name encryption"
+	cryptNameIV    = "nfs file name nonce"
+)
+
+// errBlockAuth is returned internally when a block fails to decrypt, which
+// is retried once with the file key read again in case the file was replaced
+var errBlockAuth = errors.New("block failed authentication")
+
+// encryptingClient encrypts file data (and optionally names) before they
+// reach the server and decrypts them on the way back. Everything else is
+// passed to the wrapped client.
+type encryptingClient struct {
+	NFSClient
+
+	// Seals file keys in headers
+	wrap cipher.AEAD
+
+	// Seal names deterministically; nil unless names are encrypted
+	names   cipher.AEAD
+	nameMAC []byte
+
+	// Poll interval for TailFollow
+	tailInterval time.Duration
+
+	keysMu sync.Mutex
+	keys   map[string]cipher.AEAD // by string(handle)
+
+	locks [cryptLockStripes]sync.Mutex
+}
+
+// NewEncryptingClient wraps inner so that file contents are encrypted on the
+// client with a key per file, itself wrapped by the 32-byte masterKey, and
+// names too if encryptNames is set. The server stores only ciphertext and
+// needs no changes, so an untrusted server can host sensitive exports.
+//
+// Sizes and offsets seen through the returned client are those of the
+// plaintext. Name encryption is deterministic, which lets lookups work but
+// reveals which entries share a name, and it lengthens names: see PathConf.
+// Writes that are not block-aligned read, modify and rewrite the blocks they
+// touch, so clients writing the same blocks at the same time must
+// coordinate, as must concurrent appenders.
+func NewEncryptingClient(inner NFSClient, masterKey []byte, encryptNames bool) (NFSClient, error) {
+	return newEncryptingClient(inner, masterKey, encryptNames, defaultTailPollInterval)
+}
+
+// newEncryptingClient is NewEncryptingClient with a TailFollow poll interval
+func newEncryptingClient(inner NFSClient, masterKey []byte, encryptNames bool, tailInterval time.Duration) (*encryptingClient, error) {
+	if len(masterKey) != cryptKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", cryptKeySize, len(masterKey))
+	}
+
+	wrap, err := newAEAD(deriveKey(masterKey, cryptWrapLabel))
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptingClient{
+		NFSClient:    inner,
+		wrap:         wrap,
+		tailInterval: tailInterval,
+		keys:         make(map[string]cipher.AEAD),
+	}
+	if encryptNames {
+		if e.names, err = newAEAD(deriveKey(masterKey, cryptNameLabel)); err != nil {
+			return nil, err
+		}
+		e.nameMAC = deriveKey(masterKey, cryptNameIV)
+	}
+	return e, nil
+}
+
+// LoadKeyFile reads a master key for NewEncryptingClient: either 32 raw
+// bytes or 64 hexadecimal digits, optionally followed by a newline
+func LoadKeyFile(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == cryptKeySize {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != cryptKeySize {
+		return nil, fmt.Errorf("key file %s must hold %d raw bytes or %d hex digits", file, cryptKeySize, 2*cryptKeySize)
+	}
+	return key, nil
+}
+
+// deriveKey derives an independent key for one purpose from the master key
+func deriveKey(masterKey []byte, label string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// newAEAD returns AES-256-GCM keyed with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// plainSize is the plaintext size of an encrypted file of size stored bytes
+func plainSize(stored uint64) uint64 {
+	if stored <= cryptHeaderSize {
+		return 0
+	}
+	n := stored - cryptHeaderSize
+	size := n / cryptStoredBlock * cryptBlockSize
+	if rem := n % cryptStoredBlock; rem > cryptOverhead {
+		size += rem - cryptOverhead
+	}
+	return size
+}
+
+// storedSize is the size on the server of an encrypted file holding size
+// plaintext bytes
+func storedSize(size uint64) uint64 {
+	stored := cryptHeaderSize + size/cryptBlockSize*cryptStoredBlock
+	if rem := size % cryptBlockSize; rem > 0 {
+		stored += rem + cryptOverhead
+	}
+	return stored
+}
+
+// blockOffset is where block index starts on the server
+func blockOffset(index int64) int64 {
+	return cryptHeaderSize + index*cryptStoredBlock
+}
+
+// plainAttrs rewrites the size of a regular file to that of its plaintext
+func plainAttrs(attrs *api.FileAttributes) *api.FileAttributes {
+	if attrs != nil && attrs.Type == api.FileType_REGULAR {
+		attrs.Size = plainSize(attrs.Size)
+	}
+	return attrs
+}
+
+// lock returns the lock serializing writes to handle
+func (e *encryptingClient) lock(handle []byte) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(handle)
+	return &e.locks[h.Sum32()%cryptLockStripes]
+}
+
+// cachedKey returns the file key of handle if it was unwrapped before
+func (e *encryptingClient) cachedKey(handle []byte) cipher.AEAD {
+	e.keysMu.Lock()
+	defer e.keysMu.Unlock()
+	return e.keys[string(handle)]
+}
+
+// rememberKey caches the file key of handle
+func (e *encryptingClient) rememberKey(handle []byte, key cipher.AEAD) {
+	e.keysMu.Lock()
+	defer e.keysMu.Unlock()
+	if len(e.keys) >= cryptKeyCacheSize {
+		clear(e.keys)
+	}
+	e.keys[string(handle)] = key
+}
+
+// forgetKey drops the cached file key of handle
+func (e *encryptingClient) forgetKey(handle []byte) {
+	e.keysMu.Lock()
+	defer e.keysMu.Unlock()
+	delete(e.keys, string(handle))
+}
+
+// fileKey returns the file key of handle, reading its header if the key is
+// not cached. It returns nil for a file that has no header yet because
+// nothing was ever written to it.
+func (e *encryptingClient) fileKey(ctx context.Context, handle []byte) (cipher.AEAD, error) {
+	if key := e.cachedKey(handle); key != nil {
+		return key, nil
+	}
+
+	header, _, err := e.NFSClient.Read(ctx, handle, 0, cryptHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 {
+		return nil, nil
+	}
+	if len(header) < cryptHeaderSize || string(header[:len(cryptMagic)]) != cryptMagic {
+		return nil, NewNFSError("Read", api.Status_ERR_IO, "file has no encryption header", ErrNotEncrypted)
+	}
+	if header[len(cryptMagic)] != cryptVersion {
+		return nil, NewNFSError("Read", api.Status_ERR_IO,
+			fmt.Sprintf("unsupported encryption version %d", header[len(cryptMagic)]), ErrNotEncrypted)
+	}
+
+	sealed := header[cryptHeaderPrefix:]
+	raw, err := e.wrap.Open(nil, sealed[:cryptNonceSize], sealed[cryptNonceSize:], header[:cryptHeaderPrefix])
+	if err != nil {
+		return nil, NewNFSError("Read", api.Status_ERR_IO, "file key does not match the master key", ErrCorrupt)
+	}
+	key, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	e.rememberKey(handle, key)
+	return key, nil
+}
+
+// newFileKey writes a header with a fresh file key to the empty file handle
+func (e *encryptingClient) newFileKey(ctx context.Context, handle []byte) (cipher.AEAD, error) {
+	raw := make([]byte, cryptKeySize)
+	header := make([]byte, cryptHeaderPrefix+cryptNonceSize, cryptHeaderSize)
+	copy(header, cryptMagic)
+	header[len(cryptMagic)] = cryptVersion
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, header[len(cryptMagic)+4:]); err != nil {
+		return nil, err
+	}
+	header = e.wrap.Seal(header, header[cryptHeaderPrefix:], raw, header[:cryptHeaderPrefix])
+
+	key, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.NFSClient.Write(ctx, handle, 0, header, 2); err != nil {
+		return nil, err
+	}
+	e.rememberKey(handle, key)
+	return key, nil
+}
+
+// withKey runs fn with the file key of handle. If a block fails to decrypt
+// the key is read again and fn retried once, since the file may have been
+// replaced by another with the same handle.
+func (e *encryptingClient) withKey(ctx context.Context, op string, handle []byte, fn func(key cipher.AEAD) error) error {
+	key, err := e.fileKey(ctx, handle)
+	if err != nil {
+		return err
+	}
+	err = fn(key)
+	if errors.Is(err, errBlockAuth) {
+		e.forgetKey(handle)
+		if key, err = e.fileKey(ctx, handle); err != nil {
+			return err
+		}
+		err = fn(key)
+	}
+	if errors.Is(err, errBlockAuth) {
+		return NewNFSError(op, api.Status_ERR_IO, err.Error(), ErrCorrupt)
+	}
+	return err
+}
+
+// readBlocks reads and decrypts up to count blocks starting at block first.
+// eof is set if the file ends within them.
+func (e *encryptingClient) readBlocks(ctx context.Context, handle []byte, key cipher.AEAD, first int64, count int) ([]byte, bool, error) {
+	want := count * cryptStoredBlock
+	stored := make([]byte, 0, want)
+	eof := false
+	for len(stored) < want {
+		chunk, chunkEOF, err := e.NFSClient.Read(ctx, handle, blockOffset(first)+int64(len(stored)), min(want-len(stored), fileChunkSize))
+		if err != nil {
+			return nil, false, err
+		}
+		stored = append(stored, chunk...)
+		if chunkEOF || len(chunk) == 0 {
+			eof = true
+			break
+		}
+	}
+
+	plain := make([]byte, 0, count*cryptBlockSize)
+	for index := first; len(stored) > 0; index++ {
+		n := min(cryptStoredBlock, len(stored))
+		if n <= cryptOverhead {
+			return nil, false, fmt.Errorf("block %d is truncated: %w", index, errBlockAuth)
+		}
+		var err error
+		plain, err = key.Open(plain, stored[:cryptNonceSize], stored[cryptNonceSize:n], blockAAD(index))
+		if err != nil {
+			return nil, false, fmt.Errorf("block %d: %w", index, errBlockAuth)
+		}
+		stored = stored[n:]
+	}
+	return plain, eof, nil
+}
+
+// blockAAD binds a block to its position in the file
+func blockAAD(index int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(index))
+}
+
+// sealBlocks encrypts plain as consecutive blocks starting at block first
+func sealBlocks(key cipher.AEAD, first int64, plain []byte) ([]byte, error) {
+	blocks := (len(plain) + cryptBlockSize - 1) / cryptBlockSize
+	stored := make([]byte, 0, len(plain)+blocks*cryptOverhead)
+	for index := first; len(plain) > 0; index++ {
+		n := min(cryptBlockSize, len(plain))
+		nonce := make([]byte, cryptNonceSize)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		stored = append(stored, nonce...)
+		stored = key.Seal(stored, nonce, plain[:n], blockAAD(index))
+		plain = plain[n:]
+	}
+	return stored, nil
+}
+
+// Read reads plaintext from a file
+func (e *encryptingClient) Read(ctx context.Context, fileHandle []byte, offset int64, count int) ([]byte, bool, error) {
+	if offset < 0 || count <= 0 {
+		return e.NFSClient.Read(ctx, fileHandle, offset, count)
+	}
+
+	var data []byte
+	var eof bool
+	err := e.withKey(ctx, "Read", fileHandle, func(key cipher.AEAD) error {
+		if key == nil {
+			data, eof = nil, true
+			return nil
+		}
+
+		first := offset / cryptBlockSize
+		last := (offset + int64(count) - 1) / cryptBlockSize
+		plain, blocksEOF, err := e.readBlocks(ctx, fileHandle, key, first, int(last-first+1))
+		if err != nil {
+			return err
+		}
+
+		start := int(offset - first*cryptBlockSize)
+		if start >= len(plain) {
+			data, eof = nil, true
+			return nil
+		}
+		end := min(start+count, len(plain))
+		data = plain[start:end]
+		eof = blocksEOF && end == len(plain)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return data, eof, nil
+}
+
+// prepareWrite returns the file key of handle, creating the header of an
+// empty file, and its plaintext size. The caller holds e.lock(handle).
+func (e *encryptingClient) prepareWrite(ctx context.Context, handle []byte) (cipher.AEAD, int64, error) {
+	attrs, err := e.NFSClient.GetAttr(ctx, handle)
+	if err != nil {
+		return nil, 0, err
+	}
+	if attrs.Size == 0 {
+		key, err := e.newFileKey(ctx, handle)
+		return key, 0, err
+	}
+	key, err := e.fileKey(ctx, handle)
+	if err == nil && key == nil {
+		// Emptied between the two calls
+		key, err = e.newFileKey(ctx, handle)
+	}
+	return key, int64(plainSize(attrs.Size)), err
+}
+
+// writeLocked writes data at offset to a file whose plaintext is size bytes
+// long, filling any gap after the end with zeros. The caller holds
+// e.lock(handle).
+func (e *encryptingClient) writeLocked(ctx context.Context, handle []byte, key cipher.AEAD, size, offset int64, data []byte, stability int) error {
+	start := offset
+	end := offset + int64(len(data))
+	if start > size {
+		start = size
+	}
+	first := start / cryptBlockSize
+	last := (end - 1) / cryptBlockSize
+
+	plainEnd := max(end, min(size, (last+1)*cryptBlockSize))
+	plain := make([]byte, plainEnd-first*cryptBlockSize)
+
+	// Keep the existing bytes of the blocks written only in part
+	if start%cryptBlockSize != 0 {
+		head, _, err := e.readBlocks(ctx, handle, key, first, 1)
+		if err != nil {
+			return err
+		}
+		copy(plain, head)
+	}
+	if end%cryptBlockSize != 0 && end < size && (last != first || start%cryptBlockSize == 0) {
+		tail, _, err := e.readBlocks(ctx, handle, key, last, 1)
+		if err != nil {
+			return err
+		}
+		copy(plain[(last-first)*cryptBlockSize:], tail)
+	}
+	copy(plain[offset-first*cryptBlockSize:], data)
+
+	for index := first; len(plain) > 0; index += cryptWriteBlocks {
+		n := min(cryptWriteBlocks*cryptBlockSize, len(plain))
+		stored, err := sealBlocks(key, index, plain[:n])
+		if err != nil {
+			return err
+		}
+		written, err := e.NFSClient.Write(ctx, handle, blockOffset(index), stored, stability)
+		if err != nil {
+			return err
+		}
+		if written != len(stored) {
+			return fmt.Errorf("short write at block %d", index)
+		}
+		plain = plain[n:]
+	}
+	return nil
+}
+
+// Write encrypts data and writes it to a file
+func (e *encryptingClient) Write(ctx context.Context, fileHandle []byte, offset int64, data []byte, stability int) (int, error) {
+	if offset < 0 || len(data) == 0 {
+		return e.NFSClient.Write(ctx, fileHandle, offset, data, stability)
+	}
+
+	lock := e.lock(fileHandle)
+	lock.Lock()
+	defer lock.Unlock()
+
+	err := e.withKey(ctx, "Write", fileHandle, func(cipher.AEAD) error {
+		key, size, err := e.prepareWrite(ctx, fileHandle)
+		if err != nil {
+			return err
+		}
+		return e.writeLocked(ctx, fileHandle, key, size, offset, data, stability)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteStream encrypts through Write, which must read and rewrite whole
+// blocks, so chunks are sent as Writes rather than on a stream
+func (e *encryptingClient) WriteStream(ctx context.Context, fileHandle []byte, offset int64, r io.ReaderAt, size int64, stability int) (int64, error) {
+	return writeChunks(ctx, e, fileHandle, offset, r, size, stability, fileChunkSize)
+}
+
+// Append writes data at the end of a file. The end is read from the server
+// first, so unlike with a plain client two clients appending at once may
+// overwrite each other's data.
+func (e *encryptingClient) Append(ctx context.Context, fileHandle []byte, data []byte, stability int) (int64, int, error) {
+	lock := e.lock(fileHandle)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var offset int64
+	err := e.withKey(ctx, "Append", fileHandle, func(cipher.AEAD) error {
+		key, size, err := e.prepareWrite(ctx, fileHandle)
+		if err != nil {
+			return err
+		}
+		offset = size
+		if len(data) == 0 {
+			return nil
+		}
+		return e.writeLocked(ctx, fileHandle, key, size, offset, data, stability)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, len(data), nil
+}
+
+// resize changes the plaintext size of a file, re-encrypting the block cut
+// in two when it shrinks
+func (e *encryptingClient) resize(ctx context.Context, handle []byte, size uint64) (*api.FileAttributes, error) {
+	lock := e.lock(handle)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var attrs *api.FileAttributes
+	err := e.withKey(ctx, "Truncate", handle, func(cipher.AEAD) error {
+		key, current, err := e.prepareWrite(ctx, handle)
+		if err != nil {
+			return err
+		}
+
+		// Grow by writing zeros, which must be encrypted like any other data
+		for current < int64(size) {
+			n := min(int64(size)-current, fileChunkSize)
+			if err := e.writeLocked(ctx, handle, key, current, current, make([]byte, n), 2); err != nil {
+				return err
+			}
+			current += n
+		}
+
+		if rem := size % cryptBlockSize; size < uint64(current) && rem != 0 {
+			index := int64(size / cryptBlockSize)
+			plain, _, err := e.readBlocks(ctx, handle, key, index, 1)
+			if err != nil {
+				return err
+			}
+			stored, err := sealBlocks(key, index, plain[:rem])
+			if err != nil {
+				return err
+			}
+			if _, err := e.NFSClient.Write(ctx, handle, blockOffset(index), stored, 2); err != nil {
+				return err
+			}
+		}
+
+		attrs, err = e.NFSClient.Truncate(ctx, handle, storedSize(size))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plainAttrs(attrs), nil
+}
+
+// Truncate changes the plaintext size of a file
+func (e *encryptingClient) Truncate(ctx context.Context, fileHandle []byte, size uint64) (*api.FileAttributes, error) {
+	return e.resize(ctx, fileHandle, size)
+}
+
+// SetAttr applies changes to a file. A size change is made after the other
+// changes, and GuardCtime only protects those.
+func (e *encryptingClient) SetAttr(ctx context.Context, fileHandle []byte, changes AttrChanges) (*api.FileAttributes, error) {
+	if changes.Size == nil {
+		attrs, err := e.NFSClient.SetAttr(ctx, fileHandle, changes)
+		return plainAttrs(attrs), err
+	}
+
+	size := *changes.Size
+	changes.Size = nil
+	if changes != (AttrChanges{}) {
+		if _, err := e.NFSClient.SetAttr(ctx, fileHandle, changes); err != nil {
+			return nil, err
+		}
+	}
+	return e.resize(ctx, fileHandle, size)
+}
+
+// GetAttr returns the attributes of a file, with the plaintext size
+func (e *encryptingClient) GetAttr(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.GetAttr(ctx, fileHandle)
+	return plainAttrs(attrs), err
+}
+
+// SnapshotHandle returns the handle of a file as it was in a snapshot,
+// with the plaintext size there
+func (e *encryptingClient) SnapshotHandle(ctx context.Context, fileHandle []byte, snapshot string) ([]byte, *api.FileAttributes, error) {
+	handle, attrs, err := e.NFSClient.SnapshotHandle(ctx, fileHandle, snapshot)
+	return handle, plainAttrs(attrs), err
+}
+
+// SetTimes sets the times of a file
+func (e *encryptingClient) SetTimes(ctx context.Context, fileHandle []byte, atime, mtime *time.Time) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.SetTimes(ctx, fileHandle, atime, mtime)
+	return plainAttrs(attrs), err
+}
+
+// Touch sets the times of a file to the server's current time
+func (e *encryptingClient) Touch(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.Touch(ctx, fileHandle)
+	return plainAttrs(attrs), err
+}
+
+// Chmod changes the mode of a file
+func (e *encryptingClient) Chmod(ctx context.Context, fileHandle []byte, mode uint32) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.Chmod(ctx, fileHandle, mode)
+	return plainAttrs(attrs), err
+}
+
+// Chown changes the owner and group of a file
+func (e *encryptingClient) Chown(ctx context.Context, fileHandle []byte, uid, gid int) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.Chown(ctx, fileHandle, uid, gid)
+	return plainAttrs(attrs), err
+}
+
+// Checksum hashes the plaintext of a file. The server only holds
+// ciphertext, so the data is read and hashed locally.
+func (e *encryptingClient) Checksum(ctx context.Context, fileHandle []byte, algorithm api.ChecksumAlgorithm, offset, length uint64) ([]byte, uint64, error) {
+	var h hash.Hash
+	switch algorithm {
+	case api.ChecksumAlgorithm_CHECKSUM_SHA256:
+		h = sha256.New()
+	case api.ChecksumAlgorithm_CHECKSUM_MD5:
+		h = md5.New()
+	case api.ChecksumAlgorithm_CHECKSUM_XXHASH64:
+		h = xxhash.New()
+	default:
+		return nil, 0, NewNFSError("Checksum", api.Status_ERR_NOTSUPP, "unknown checksum algorithm", ErrNotImplemented)
+	}
+
+	var hashed uint64
+	for length == 0 || hashed < length {
+		count := uint64(fileChunkSize)
+		if length != 0 {
+			count = min(count, length-hashed)
+		}
+		data, eof, err := e.Read(ctx, fileHandle, int64(offset+hashed), int(count))
+		if err != nil {
+			return nil, hashed, err
+		}
+		h.Write(data)
+		hashed += uint64(len(data))
+		if eof || len(data) == 0 {
+			break
+		}
+	}
+	return h.Sum(nil), hashed, nil
+}
+
+// Allocate is not supported: the server cannot lay out ciphertext for
+// plaintext it has not seen, as blocks are written whole with their tags
+func (e *encryptingClient) Allocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error) {
+	return nil, NewNFSError("Allocate", api.Status_ERR_NOTSUPP, "not supported on encrypted files", ErrNotImplemented)
+}
+
+// Deallocate is not supported: a hole punched in ciphertext would fail
+// authentication rather than read as zeros
+func (e *encryptingClient) Deallocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error) {
+	return nil, NewNFSError("Deallocate", api.Status_ERR_NOTSUPP, "not supported on encrypted files", ErrNotImplemented)
+}
+
+// Seek is not supported: offsets on the server are those of the
+// ciphertext, not of the plaintext
+func (e *encryptingClient) Seek(ctx context.Context, fileHandle []byte, offset uint64, what api.SeekContent) (uint64, bool, error) {
+	return 0, false, NewNFSError("Seek", api.Status_ERR_NOTSUPP, "not supported on encrypted files", ErrNotImplemented)
+}
+
+// CopyRange is not supported: each file is encrypted with a key of its
+// own, so the server cannot copy the data of one into another
+func (e *encryptingClient) CopyRange(ctx context.Context, srcHandle, dstHandle []byte, srcOffset, dstOffset, length uint64) (uint64, bool, error) {
+	return 0, false, NewNFSError("CopyRange", api.Status_ERR_NOTSUPP, "not supported on encrypted files", ErrNotImplemented)
+}
+
+// CopyFile copies the plaintext of the file at srcPath to dstPath, which
+// is encrypted with a key of its own
+func (e *encryptingClient) CopyFile(ctx context.Context, srcPath, dstPath string, mode uint32) error {
+	return copyFile(ctx, e, srcPath, dstPath, mode)
+}
+
+// WriteFileAtomic replaces the file at path with data, encrypted
+func (e *encryptingClient) WriteFileAtomic(ctx context.Context, path string, data []byte, mode uint32) error {
+	return writeFileAtomic(ctx, e, path, data, mode)
+}
+
+// Download copies the plaintext of the file at path to w
+func (e *encryptingClient) Download(ctx context.Context, path string, w io.WriterAt, opts TransferOptions) error {
+	return download(ctx, e, path, w, opts)
+}
+
+// Upload writes size bytes from r to the file at path, encrypted
+func (e *encryptingClient) Upload(ctx context.Context, r io.ReaderAt, size int64, path string, mode uint32, opts TransferOptions) error {
+	return upload(ctx, e, r, size, path, mode, opts)
+}
+
+// TailFollow streams the plaintext appended to the file at path
+func (e *encryptingClient) TailFollow(ctx context.Context, path string) (io.ReadCloser, error) {
+	return e.TailFollowFrom(ctx, path, -1)
+}
+
+// TailFollowFrom is like TailFollow but starts at offset
+func (e *encryptingClient) TailFollowFrom(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return tailFollow(ctx, e, path, offset, e.tailInterval)
+}
+
+// encryptName returns the name stored on the server for name
+func (e *encryptingClient) encryptName(name string) string {
+	if e.names == nil || name == "." || name == ".." || name == "" {
+		return name
+	}
+	mac := hmac.New(sha256.New, e.nameMAC)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:cryptNonceSize]
+	sealed := e.names.Seal(nonce, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// decryptName returns the name a stored name stands for, or false if it was
+// not encrypted with this key
+func (e *encryptingClient) decryptName(stored string) (string, bool) {
+	if e.names == nil || stored == "." || stored == ".." {
+		return stored, true
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(stored)
+	if err != nil || len(sealed) < cryptOverhead {
+		return "", false
+	}
+	name, err := e.names.Open(nil, sealed[:cryptNonceSize], sealed[cryptNonceSize:], nil)
+	if err != nil {
+		return "", false
+	}
+	return string(name), true
+}
+
+// decryptPath decrypts each component of a path, leaving those that are
+// not encrypted with this key unchanged
+func (e *encryptingClient) decryptPath(p string) string {
+	if e.names == nil || p == "" {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if name, ok := e.decryptName(part); ok && part != "" {
+			parts[i] = name
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// encryptPath encrypts each component of a relative path, such as a link
+// target, keeping "." and ".." so the server can still check where it leads
+func (e *encryptingClient) encryptPath(p string) string {
+	if e.names == nil || p == "" {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = e.encryptName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Lookup looks up a name in a directory
+func (e *encryptingClient) Lookup(ctx context.Context, dirHandle []byte, name string) ([]byte, *api.FileAttributes, error) {
+	handle, attrs, err := e.NFSClient.Lookup(ctx, dirHandle, e.encryptName(name))
+	return handle, plainAttrs(attrs), err
+}
+
+// BulkLookup looks up several names in one directory
+func (e *encryptingClient) BulkLookup(ctx context.Context, dirHandle []byte, names []string) ([]*api.BulkLookupResult, error) {
+	stored := make([]string, len(names))
+	for i, name := range names {
+		stored[i] = e.encryptName(name)
+	}
+	results, err := e.NFSClient.BulkLookup(ctx, dirHandle, stored)
+	for _, result := range results {
+		plainAttrs(result.Attributes)
+	}
+	return results, err
+}
+
+// LookupPath resolves a path of plaintext names to a file handle
+func (e *encryptingClient) LookupPath(ctx context.Context, path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+	handle, err := e.GetRootFileHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, component := range strings.Split(path, "/") {
+		if component == "" {
+			continue
+		}
+		if handle, _, err = e.Lookup(ctx, handle, component); err != nil {
+			return nil, fmt.Errorf("failed to look up %q: %w", component, err)
+		}
+	}
+	return handle, nil
+}
+
+// ReadDir lists a directory with plaintext names. Entries whose names were
+// not encrypted with this key are left out.
+func (e *encryptingClient) ReadDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry, error) {
+	entries, err := e.NFSClient.ReadDir(ctx, dirHandle)
+	if err != nil || e.names == nil {
+		return entries, err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if name, ok := e.decryptName(entry.Name); ok {
+			entry.Name = name
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// ReadDirPlus lists a directory with plaintext names and sizes. Entries
+// whose names were not encrypted with this key are left out.
+func (e *encryptingClient) ReadDirPlus(ctx context.Context, dirHandle []byte) ([]*api.DirEntryPlus, error) {
+	entries, err := e.NFSClient.ReadDirPlus(ctx, dirHandle)
+	if err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		name, ok := e.decryptName(entry.Name)
+		if !ok {
+			continue
+		}
+		entry.Name = name
+		plainAttrs(entry.Attributes)
+		kept = append(kept, entry)
+	}
+	return kept, nil
+}
+
+// Create creates a file and gives it an encryption header
+func (e *encryptingClient) Create(ctx context.Context, dirHandle []byte, name string, attrs *api.FileAttributes, mode api.CreateMode) ([]byte, *api.FileAttributes, error) {
+	handle, created, err := e.NFSClient.Create(ctx, dirHandle, e.encryptName(name), attrs, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return handle, e.initHeader(ctx, handle, created), nil
+}
+
+// CreateUnnamed creates an unnamed file and gives it an encryption header
+func (e *encryptingClient) CreateUnnamed(ctx context.Context, dirHandle []byte, attrs *api.FileAttributes) ([]byte, *api.FileAttributes, error) {
+	handle, created, err := e.NFSClient.CreateUnnamed(ctx, dirHandle, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return handle, e.initHeader(ctx, handle, created), nil
+}
+
+// initHeader writes the header of a newly created empty file. Failing to
+// is not an error: the first write creates it instead, as it would for a
+// file created without encryption.
+func (e *encryptingClient) initHeader(ctx context.Context, handle []byte, attrs *api.FileAttributes) *api.FileAttributes {
+	if attrs != nil && attrs.Type == api.FileType_REGULAR && attrs.Size == 0 {
+		lock := e.lock(handle)
+		lock.Lock()
+		defer lock.Unlock()
+		if _, err := e.newFileKey(ctx, handle); err != nil {
+			return attrs
+		}
+	}
+	return plainAttrs(attrs)
+}
+
+// Mkdir creates a directory
+func (e *encryptingClient) Mkdir(ctx context.Context, dirHandle []byte, name string, attrs *api.FileAttributes) ([]byte, *api.FileAttributes, error) {
+	return e.NFSClient.Mkdir(ctx, dirHandle, e.encryptName(name), attrs)
+}
+
+// Remove removes a file
+func (e *encryptingClient) Remove(ctx context.Context, dirHandle []byte, name string) error {
+	return e.NFSClient.Remove(ctx, dirHandle, e.encryptName(name))
+}
+
+// Rmdir removes a directory
+func (e *encryptingClient) Rmdir(ctx context.Context, dirHandle []byte, name string) error {
+	return e.NFSClient.Rmdir(ctx, dirHandle, e.encryptName(name))
+}
+
+// Symlink creates a symbolic link. With name encryption the components of
+// the target are encrypted like any other name.
+func (e *encryptingClient) Symlink(ctx context.Context, dirHandle []byte, name string, target string) ([]byte, *api.FileAttributes, error) {
+	return e.NFSClient.Symlink(ctx, dirHandle, e.encryptName(name), e.encryptPath(target))
+}
+
+// Link creates a hard link to an existing file
+func (e *encryptingClient) Link(ctx context.Context, fileHandle []byte, dirHandle []byte, name string) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.Link(ctx, fileHandle, dirHandle, e.encryptName(name))
+	return plainAttrs(attrs), err
+}
+
+// Commit flushes the whole file: plaintext ranges do not map onto stored
+// ones without reading the header
+func (e *encryptingClient) Commit(ctx context.Context, fileHandle []byte, offset uint64, count uint32) (uint64, error) {
+	return e.NFSClient.Commit(ctx, fileHandle, 0, 0)
+}
+
+// Readlink returns the target of a symbolic link
+func (e *encryptingClient) Readlink(ctx context.Context, fileHandle []byte) (string, error) {
+	target, err := e.NFSClient.Readlink(ctx, fileHandle)
+	return e.decryptPath(target), err
+}
+
+// RemoveTree deletes a directory tree
+func (e *encryptingClient) RemoveTree(ctx context.Context, dirHandle []byte, name string, concurrency int, progress func(*api.RemoveTreeProgress)) error {
+	return e.NFSClient.RemoveTree(ctx, dirHandle, e.encryptName(name), concurrency, progress)
+}
+
+// RemoveTreeInBackground deletes a directory tree in the background
+func (e *encryptingClient) RemoveTreeInBackground(ctx context.Context, dirHandle []byte, name string) (uint64, error) {
+	return e.NFSClient.RemoveTreeInBackground(ctx, dirHandle, e.encryptName(name))
+}
+
+// Rename renames a file or directory
+func (e *encryptingClient) Rename(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string) error {
+	return e.NFSClient.Rename(ctx, fromDirHandle, e.encryptName(fromName), toDirHandle, e.encryptName(toName))
+}
+
+// RenameWithFlags renames a file or directory as flags say
+func (e *encryptingClient) RenameWithFlags(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string, flags RenameFlags) error {
+	return e.NFSClient.RenameWithFlags(ctx, fromDirHandle, e.encryptName(fromName), toDirHandle, e.encryptName(toName), flags)
+}
+
+// LinkIntoPlace publishes an unnamed file as name
+func (e *encryptingClient) LinkIntoPlace(ctx context.Context, fileHandle []byte, dirHandle []byte, name string, replace bool) (*api.FileAttributes, error) {
+	attrs, err := e.NFSClient.LinkIntoPlace(ctx, fileHandle, dirHandle, e.encryptName(name), replace)
+	return plainAttrs(attrs), err
+}
+
+// PathConf reports the server's limits, with the longest name that still
+// fits once encrypted
+func (e *encryptingClient) PathConf(ctx context.Context, fileHandle []byte) (*api.PathConfResponse, error) {
+	resp, err := e.NFSClient.PathConf(ctx, fileHandle)
+	if err != nil || e.names == nil || resp.NameMax == 0 {
+		return resp, err
+	}
+	// A name of n bytes is stored as base64 of nonce, name and tag
+	limit := int64(resp.NameMax)*3/4 - cryptOverhead
+	resp.NameMax = uint32(max(limit, 0))
+	return resp, nil
+}
+
+// FsInfo reports the server's capabilities with plaintext attributes and,
+// as for PathConf, the longest name that still fits once encrypted
+func (e *encryptingClient) FsInfo(ctx context.Context, fileHandle []byte) (*api.FsInfoResponse, error) {
+	resp, err := e.NFSClient.FsInfo(ctx, fileHandle)
+	if err != nil {
+		return resp, err
+	}
+	plainAttrs(resp.Attributes)
+	if e.names != nil && resp.NameMax != 0 {
+		limit := int64(resp.NameMax)*3/4 - cryptOverhead
+		resp.NameMax = uint32(max(limit, 0))
+	}
+	return resp, nil
+}
+
+// Watch reports changes with plaintext paths
+func (e *encryptingClient) Watch(ctx context.Context, dirHandle []byte, recursive bool) (*Watcher, error) {
+	if e.names == nil {
+		return e.NFSClient.Watch(ctx, dirHandle, recursive)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	inner, err := e.NFSClient.Watch(ctx, dirHandle, recursive)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	w := &Watcher{
+		events: make(chan *api.ChangeEvent),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(w.events)
+		for event := range inner.Events() {
+			event.Path = e.decryptPath(event.Path)
+			event.NewPath = e.decryptPath(event.NewPath)
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+			}
+		}
+		w.err = inner.Err()
+	}()
+	return w, nil
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// testKey returns a master key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, cryptKeySize)
+}
+
+func TestEncryptedReadWrite(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+	e, err := NewEncryptingClient(c, testKey(1), false)
+	if err != nil {
+		t.Fatalf("NewEncryptingClient failed: %v", err)
+	}
+
+	root, err := e.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	handle, attrs, err := e.Create(ctx, root, "data.bin", &api.FileAttributes{Mode: 0644}, api.CreateMode_GUARDED)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if attrs.Size != 0 {
+		t.Fatalf("New file has size %d, want 0", attrs.Size)
+	}
+
+	// Mirror every change in a plain buffer and compare after each step
+	var want []byte
+	rng := rand.New(rand.NewSource(1))
+	check := func(step string) {
+		t.Helper()
+		attrs, err := e.GetAttr(ctx, handle)
+		if err != nil {
+			t.Fatalf("%s: GetAttr failed: %v", step, err)
+		}
+		if attrs.Size != uint64(len(want)) {
+			t.Fatalf("%s: size %d, want %d", step, attrs.Size, len(want))
+		}
+
+		var got []byte
+		for {
+			chunk, eof, err := e.Read(ctx, handle, int64(len(got)), 1+rng.Intn(3*cryptBlockSize))
+			if err != nil {
+				t.Fatalf("%s: Read at %d failed: %v", step, len(got), err)
+			}
+			got = append(got, chunk...)
+			if eof {
+				break
+			}
+			if len(chunk) == 0 {
+				t.Fatalf("%s: empty read at %d without EOF", step, len(got))
+			}
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: content differs from what was written", step)
+		}
+	}
+	write := func(offset int, n int) {
+		t.Helper()
+		data := make([]byte, n)
+		rng.Read(data)
+		if _, err := e.Write(ctx, handle, int64(offset), data, 2); err != nil {
+			t.Fatalf("Write of %d bytes at %d failed: %v", n, offset, err)
+		}
+		if end := offset + n; end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[offset:], data)
+	}
+
+	write(0, 10000)
+	check("initial write")
+	write(100, 50)
+	check("overwrite within a block")
+	write(4000, 300)
+	check("overwrite across a block boundary")
+	write(20000, 10)
+	check("write past the end")
+	write(len(want)-5, 5000)
+	check("write extending the last block")
+
+	if _, err := e.Truncate(ctx, handle, 9000); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	want = want[:9000]
+	check("shrink")
+	if _, err := e.Truncate(ctx, handle, 12000); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	want = append(want, make([]byte, 3000)...)
+	check("grow")
+
+	offset, _, err := e.Append(ctx, handle, []byte("appended"), 2)
+	if err != nil || offset != 12000 {
+		t.Fatalf("Append returned offset %d, %v; want 12000", offset, err)
+	}
+	want = append(want, "appended"...)
+	check("append")
+
+	// The server only holds ciphertext
+	stored, err := os.ReadFile(filepath.Join(tempDir, "data.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read stored file: %v", err)
+	}
+	if uint64(len(stored)) != storedSize(uint64(len(want))) {
+		t.Errorf("Stored file is %d bytes, want %d", len(stored), storedSize(uint64(len(want))))
+	}
+	if bytes.Contains(stored, []byte("appended")) {
+		t.Errorf("Stored file contains plaintext")
+	}
+
+	sum, n, err := e.Checksum(ctx, handle, api.ChecksumAlgorithm_CHECKSUM_SHA256, 0, 0)
+	if wantSum := sha256.Sum256(want); err != nil || n != uint64(len(want)) || !bytes.Equal(sum, wantSum[:]) {
+		t.Errorf("Checksum returned %x over %d bytes, %v; want %x over %d", sum, n, err, wantSum, len(want))
+	}
+}
+
+func TestEncryptedWrongKey(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+	e, err := NewEncryptingClient(c, testKey(1), false)
+	if err != nil {
+		t.Fatalf("NewEncryptingClient failed: %v", err)
+	}
+	if err := e.WriteFileAtomic(ctx, "/secret", []byte("attack at dawn"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "plain"), []byte("not encrypted"), 0644); err != nil {
+		t.Fatalf("Failed to write plain file: %v", err)
+	}
+
+	other, err := NewEncryptingClient(c, testKey(2), false)
+	if err != nil {
+		t.Fatalf("NewEncryptingClient failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		client NFSClient
+		path   string
+		want   error
+	}{
+		{"Right key", e, "/secret", nil},
+		{"Wrong key", other, "/secret", ErrCorrupt},
+		{"Plain file", e, "/plain", ErrNotEncrypted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := tt.client.LookupPath(ctx, tt.path)
+			if err != nil {
+				t.Fatalf("LookupPath failed: %v", err)
+			}
+			data, _, err := tt.client.Read(ctx, handle, 0, 100)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Read returned %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && string(data) != "attack at dawn" {
+				t.Errorf("Read returned %q", data)
+			}
+		})
+	}
+}
+
+func TestEncryptedNames(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+	e, err := NewEncryptingClient(c, testKey(1), true)
+	if err != nil {
+		t.Fatalf("NewEncryptingClient failed: %v", err)
+	}
+
+	root, err := e.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	dir, _, err := e.Mkdir(ctx, root, "payroll", &api.FileAttributes{Mode: 0755})
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := e.Create(ctx, dir, "salaries.csv", &api.FileAttributes{Mode: 0644}, api.CreateMode_GUARDED); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := e.Rename(ctx, dir, "salaries.csv", dir, "2026.csv"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	link, _, err := e.Symlink(ctx, root, "latest", "payroll/2026.csv")
+	if err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	// Names on the server are unreadable but resolve through the client
+	stored, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to list export: %v", err)
+	}
+	for _, entry := range stored {
+		if entry.Name() == "payroll" || entry.Name() == "latest" {
+			t.Errorf("Name %s stored in plaintext", entry.Name())
+		}
+	}
+	if _, err := e.LookupPath(ctx, "/payroll/2026.csv"); err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	if target, err := e.Readlink(ctx, link); err != nil || target != "payroll/2026.csv" {
+		t.Errorf("Readlink returned %q, %v; want payroll/2026.csv", target, err)
+	}
+
+	entries, err := e.ReadDir(ctx, dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name != "." && entry.Name != ".." {
+			names = append(names, entry.Name)
+		}
+	}
+	if len(names) != 1 || names[0] != "2026.csv" {
+		t.Errorf("ReadDir returned %v, want [2026.csv]", names)
+	}
+	plus, err := e.ReadDirPlus(ctx, dir)
+	if err != nil {
+		t.Fatalf("ReadDirPlus failed: %v", err)
+	}
+	for _, entry := range plus {
+		if entry.Name == "2026.csv" && entry.Attributes.Size != 0 {
+			t.Errorf("ReadDirPlus reports size %d for an empty file", entry.Attributes.Size)
+		}
+		if entry.Name != "." && entry.Name != ".." && entry.Name != "2026.csv" {
+			t.Errorf("ReadDirPlus returned unexpected name %q", entry.Name)
+		}
+	}
+
+	conf, err := e.PathConf(ctx, root)
+	if err != nil {
+		t.Fatalf("PathConf failed: %v", err)
+	}
+	if conf.NameMax != 0 && len(e.(*encryptingClient).encryptName(string(bytes.Repeat([]byte("x"), int(conf.NameMax))))) > 255 {
+		t.Errorf("Names of NameMax=%d bytes do not fit once encrypted", conf.NameMax)
+	}
+}
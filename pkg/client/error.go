@@ -3,6 +3,7 @@ package client
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 
 	"github.com/example/nfsserver/pkg/api"
 )
@@ -15,9 +16,20 @@ var (
 	ErrInvalidPath    = errors.New("invalid path")
 	ErrPermission     = errors.New("permission denied")
 	ErrNotExist       = errors.New("file does not exist")
+	ErrExist          = errors.New("file already exists")
 	ErrIsDir          = errors.New("is a directory")
 	ErrNotDir         = errors.New("not a directory")
+	ErrNameTooLong    = errors.New("file name too long")
 	ErrTimeout        = errors.New("operation timed out")
+	ErrFenced         = errors.New("fencing epoch superseded")
+	ErrNotEmpty       = errors.New("directory not empty")
+	ErrNotSync        = errors.New("file changed since its attributes were read")
+	ErrNotEncrypted   = errors.New("file is not encrypted")
+	ErrCorrupt        = errors.New("encrypted data failed authentication")
+	ErrChanged        = errors.New("file changed during the transfer")
+	ErrLocked         = errors.New("conflicting lock held")
+	ErrDeadlock       = errors.New("waiting for the lock would deadlock")
+	ErrNoData         = errors.New("no data past offset")
 )
 
 // NFSError represents an error in an NFS operation
@@ -48,6 +60,20 @@ func (e *NFSError) Unwrap() error {
 	return e.Err
 }
 
+// Is lets errors.Is match the standard io/fs sentinels, so code written
+// against os.File can test NFS errors with fs.ErrNotExist and friends
+func (e *NFSError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Err == ErrNotExist
+	case fs.ErrExist:
+		return e.Err == ErrExist
+	case fs.ErrPermission:
+		return e.Err == ErrPermission
+	}
+	return false
+}
+
 // NewNFSError creates a new NFS error
 func NewNFSError(op string, status api.Status, message string, err error) *NFSError {
 	return &NFSError{
@@ -78,11 +104,15 @@ func StatusToError(op string, status api.Status) error {
 		message = "I/O error"
 	case api.Status_ERR_NXIO:
 		message = "no such device or address"
+		err = ErrNoData
 	case api.Status_ERR_ACCES:
 		message = "permission denied"
 		err = ErrPermission
 	case api.Status_ERR_EXIST:
 		message = "file exists"
+		err = ErrExist
+	case api.Status_ERR_XDEV:
+		message = "cross-export link"
 	case api.Status_ERR_NODEV:
 		message = "no such device"
 	case api.Status_ERR_NOTDIR:
@@ -101,8 +131,10 @@ func StatusToError(op string, status api.Status) error {
 		message = "read-only file system"
 	case api.Status_ERR_NAMETOOLONG:
 		message = "filename too long"
+		err = ErrNameTooLong
 	case api.Status_ERR_NOTEMPTY:
 		message = "directory not empty"
+		err = ErrNotEmpty
 	case api.Status_ERR_DQUOT:
 		message = "disk quota exceeded"
 	case api.Status_ERR_STALE:
@@ -113,6 +145,7 @@ func StatusToError(op string, status api.Status) error {
 		err = ErrInvalidHandle
 	case api.Status_ERR_NOT_SYNC:
 		message = "update synchronization mismatch"
+		err = ErrNotSync
 	case api.Status_ERR_BAD_COOKIE:
 		message = "READDIR cookie is stale"
 	case api.Status_ERR_NOTSUPP:
@@ -126,6 +159,21 @@ func StatusToError(op string, status api.Status) error {
 		message = "type not supported"
 	case api.Status_ERR_JUKEBOX:
 		message = "operation requires human intervention"
+	case api.Status_ERR_FENCED:
+		message = "write fenced by a newer epoch"
+		err = ErrFenced
+	case api.Status_ERR_DELAY:
+		message = "server overloaded, retry later"
+	case api.Status_ERR_DENIED:
+		message = "conflicting lock held"
+		err = ErrLocked
+	case api.Status_ERR_DEADLOCK:
+		message = "lock wait would deadlock"
+		err = ErrDeadlock
+	case api.Status_ERR_GRACE:
+		message = "server reclaiming locks after a restart, retry later"
+	case api.Status_ERR_NO_GRACE:
+		message = "no locks to reclaim"
 	default:
 		message = "unknown error"
 	}
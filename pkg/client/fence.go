@@ -0,0 +1,20 @@
+package client
+
+import "context"
+
+// fenceKey is the context key for fencing epochs
+type fenceKey struct{}
+
+// WithFence returns a context whose writes carry epoch, as returned by
+// AcquireFence. The server rejects them with ErrFenced once a newer epoch
+// has been issued for the file, so a writer that lost leadership cannot
+// clobber its successor's data. An epoch of 0 removes the fence.
+func WithFence(ctx context.Context, epoch uint64) context.Context {
+	return context.WithValue(ctx, fenceKey{}, epoch)
+}
+
+// fenceFromContext returns the fencing epoch attached to ctx, or 0
+func fenceFromContext(ctx context.Context) uint64 {
+	epoch, _ := ctx.Value(fenceKey{}).(uint64)
+	return epoch
+}
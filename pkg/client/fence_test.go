@@ -0,0 +1,59 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFencing(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+	if err := os.WriteFile(filepath.Join(tempDir, "leader"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	handle, err := c.LookupPath(ctx, "/leader")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+
+	oldEpoch, err := c.AcquireFence(ctx, handle)
+	if err != nil {
+		t.Fatalf("AcquireFence failed: %v", err)
+	}
+	oldCtx := WithFence(ctx, oldEpoch)
+	if _, err := c.Write(oldCtx, handle, 0, []byte("old"), 2); err != nil {
+		t.Fatalf("Write with current epoch failed: %v", err)
+	}
+
+	// A new leader takes over; the old one is fenced out
+	newEpoch, err := c.AcquireFence(ctx, handle)
+	if err != nil {
+		t.Fatalf("AcquireFence failed: %v", err)
+	}
+	if _, err := c.Write(oldCtx, handle, 0, []byte("OLD"), 2); !errors.Is(err, ErrFenced) {
+		t.Errorf("Write with superseded epoch returned %v, want ErrFenced", err)
+	}
+	if _, _, err := c.Append(oldCtx, handle, []byte("!"), 0); !errors.Is(err, ErrFenced) {
+		t.Errorf("Append with superseded epoch returned %v, want ErrFenced", err)
+	}
+
+	// Files opened with a fenced context carry the epoch on every write
+	f, err := c.OpenFile(WithFence(ctx, newEpoch), "/leader", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("new")); err != nil {
+		t.Fatalf("Write with new epoch failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "leader"))
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(data) != "new" {
+		t.Errorf("File content = %q, want \"new\"", data)
+	}
+}
@@ -0,0 +1,381 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// fileChunkSize is the largest single Read or Write issued by a File when
+// the server does not say what it prefers
+const fileChunkSize = 1024 * 1024
+
+// createTempAttempts bounds the names CreateTemp tries before giving up
+const createTempAttempts = 10000
+
+// File is an open file on the server with the method set of *os.File that
+// matters to most libraries: io.Reader, io.Writer, io.Seeker, io.ReaderAt,
+// io.WriterAt and io.Closer, plus Truncate, Sync and Stat. Like os.File,
+// seeking past the end is allowed and a later write leaves a hole that
+// reads back as zeros. A File is safe for concurrent use; Read, Write and
+// Seek share one offset.
+type File struct {
+	client *Client
+	ctx    context.Context
+	name   string
+	handle []byte
+	flag   int
+
+	closed atomic.Bool
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// Open opens the named file for reading, like os.Open
+func (c *Client) Open(ctx context.Context, name string) (*File, error) {
+	return c.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with the os.O_* flags in flag, creating it
+// with mode perm when os.O_CREATE is set, like os.OpenFile. The credentials
+// attached to ctx are used for every operation on the returned File.
+func (c *Client) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (*File, error) {
+	dir, base := path.Split(path.Clean("/" + name))
+	if base == "" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDir}
+	}
+
+	dirHandle, err := c.LookupPath(ctx, dir)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	handle, attrs, err := c.Lookup(ctx, dirHandle, base)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+	case errors.Is(err, ErrNotExist) && flag&os.O_CREATE != 0:
+		handle, attrs, err = c.Create(ctx, dirHandle, base, &api.FileAttributes{Mode: uint32(perm.Perm())}, api.CreateMode_GUARDED)
+		if errors.Is(err, ErrExist) && flag&os.O_EXCL == 0 {
+			// Lost a race with another creator; open what it made
+			handle, attrs, err = c.Lookup(ctx, dirHandle, base)
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	default:
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if attrs.Type == api.FileType_DIRECTORY && writable {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDir}
+	}
+
+	f := &File{client: c, ctx: ctx, name: name, handle: handle, flag: flag}
+	if flag&os.O_TRUNC != 0 && writable && attrs.Size > 0 {
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// CreateTemp creates a new file in dir with a name built from pattern and
+// opens it for reading and writing, like os.CreateTemp. The last "*" in
+// pattern is replaced by a random string; without one, the random string
+// is appended. The caller is responsible for removing the file.
+func (c *Client) CreateTemp(ctx context.Context, dir, pattern string) (*File, error) {
+	if strings.ContainsRune(pattern, '/') {
+		return nil, &os.PathError{Op: "createtemp", Path: pattern, Err: ErrInvalidPath}
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	random := make([]byte, 5)
+	for i := 0; i < createTempAttempts; i++ {
+		if _, err := io.ReadFull(rand.Reader, random); err != nil {
+			return nil, err
+		}
+		name := path.Join("/", dir, prefix+hex.EncodeToString(random)+suffix)
+
+		f, err := c.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, &os.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: fs.ErrExist}
+}
+
+// Name returns the name the file was opened with
+func (f *File) Name() string {
+	return f.name
+}
+
+// Handle returns the NFS file handle of the open file
+func (f *File) Handle() []byte {
+	return f.handle
+}
+
+// Read reads up to len(p) bytes at the current offset and advances it
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes starting at off without moving the offset.
+// As with os.File, a short read always comes with an error, io.EOF when
+// the end of the file was reached.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+
+	total := 0
+	for total < len(p) {
+		n, err := f.readAt(p[total:], off+int64(total))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// readAt issues a single Read RPC of at most the server's read size
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	readSize, _ := f.client.transferSizes(f.ctx, f.handle)
+	count := min(len(p), readSize)
+	data, eof, err := f.client.Read(f.ctx, f.handle, off, count)
+	if err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	n := copy(p, data)
+	if n == 0 || (eof && n < len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes p at the current offset, or at the end of the file when it
+// was opened with os.O_APPEND, and advances the offset
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		_, writeSize := f.client.transferSizes(f.ctx, f.handle)
+		total := 0
+		for total < len(p) {
+			end := min(total+writeSize, len(p))
+			// The server picks the offset, so concurrent appenders never interleave
+			offset, n, err := f.client.Append(f.ctx, f.handle, p[total:end], 0) // 0 = UNSTABLE
+			total += n
+			if err != nil {
+				return total, &os.PathError{Op: "write", Path: f.name, Err: err}
+			}
+			f.offset = offset + int64(n)
+		}
+		return total, nil
+	}
+
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p starting at off without moving the offset. Writing past
+// the end of the file extends it, leaving a zero-filled hole. As with
+// os.File, WriteAt fails on files opened with os.O_APPEND.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errors.New("client: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: syscall.EINVAL}
+	}
+	return f.writeAt(p, off)
+}
+
+// writeAt writes all of p at off in chunks of at most the server's write size
+func (f *File) writeAt(p []byte, off int64) (int, error) {
+	_, writeSize := f.client.transferSizes(f.ctx, f.handle)
+	total := 0
+	for total < len(p) {
+		end := min(total+writeSize, len(p))
+		n, err := f.client.Write(f.ctx, f.handle, off+int64(total), p[total:end], 0) // 0 = UNSTABLE
+		total += n
+		if err != nil {
+			return total, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		if n == 0 {
+			return total, &os.PathError{Op: "write", Path: f.name, Err: io.ErrShortWrite}
+		}
+	}
+	return total, nil
+}
+
+// Seek sets the offset for the next Read or Write, like os.File.Seek.
+// Seeking beyond the end of the file is allowed.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("seek", false); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		attrs, err := f.client.GetAttr(f.ctx, f.handle)
+		if err != nil {
+			return 0, &os.PathError{Op: "seek", Path: f.name, Err: err}
+		}
+		offset += int64(attrs.Size)
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file without moving the offset
+func (f *File) Truncate(size int64) error {
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+	if _, err := f.client.Truncate(f.ctx, f.handle, uint64(size)); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// Sync asks the server to commit the file's data to stable storage. Servers
+// without Commit are sent an empty DATA_SYNC append instead, which they
+// acknowledge only after flushing the file.
+func (f *File) Sync() error {
+	if err := f.check("sync", false); err != nil {
+		return err
+	}
+	_, err := f.client.Commit(f.ctx, f.handle, 0, 0)
+	if errors.Is(err, ErrNotImplemented) {
+		_, _, err = f.client.Append(f.ctx, f.handle, nil, 1) // 1 = DATA_SYNC
+	}
+	if err != nil {
+		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// Stat returns the current attributes of the file
+func (f *File) Stat() (os.FileInfo, error) {
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+	attrs, err := f.client.GetAttr(f.ctx, f.handle)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return &fileInfo{name: path.Base(f.name), attrs: attrs}, nil
+}
+
+// Close marks the file closed; NFS has no open state on the server
+func (f *File) Close() error {
+	if !f.closed.CompareAndSwap(false, true) {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+// check rejects operations on closed files and writes to read-only ones
+func (f *File) check(op string, write bool) error {
+	if f.closed.Load() {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+// fileInfo adapts api.FileAttributes to os.FileInfo
+type fileInfo struct {
+	name  string
+	attrs *api.FileAttributes
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64  { return int64(fi.attrs.Size) }
+func (fi *fileInfo) Sys() any     { return fi.attrs }
+
+func (fi *fileInfo) IsDir() bool { return fi.attrs.Type == api.FileType_DIRECTORY }
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.attrs.Mtime == nil {
+		return time.Time{}
+	}
+	return time.Unix(fi.attrs.Mtime.Seconds, int64(fi.attrs.Mtime.Nano))
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(fi.attrs.Mode).Perm()
+	switch fi.attrs.Type {
+	case api.FileType_DIRECTORY:
+		mode |= fs.ModeDir
+	case api.FileType_SYMLINK:
+		mode |= fs.ModeSymlink
+	}
+	return mode
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newLocalClient serves a fresh temporary directory with the real server and
+// returns a client for it along with the directory and a root context
+func newLocalClient(t *testing.T) (*Client, string, context.Context) {
+	tempDir := t.TempDir()
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	return newServiceClient(t, nfsServer), tempDir, WithCredentials(context.Background(), 0, 0)
+}
+
+func TestFileSeekPastEOFLeavesHole(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+
+	f, err := c.OpenFile(ctx, "/sparse.bin", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("head")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if pos, err := f.Seek(10, io.SeekEnd); err != nil || pos != 14 {
+		t.Fatalf("Seek returned %d, %v; want 14", pos, err)
+	}
+	if _, err := f.Write([]byte("tail")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	want := append(append([]byte("head"), make([]byte, 10)...), "tail"...)
+	got, err := os.ReadFile(filepath.Join(tempDir, "sparse.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read back file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("File content = %q, want %q", got, want)
+	}
+
+	// Reading through the File sees the same bytes and then io.EOF
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(read, want) {
+		t.Errorf("ReadAll = %q, want %q", read, want)
+	}
+
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative offset should fail")
+	}
+}
+
+func TestFileReadAtWriteAt(t *testing.T) {
+	c, _, ctx := newLocalClient(t)
+
+	f, err := c.OpenFile(ctx, "/data.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt([]byte("hello world"), 0); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("WORLD"), 6); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+
+	buf := make([]byte, 5)
+	if n, err := f.ReadAt(buf, 6); err != nil || string(buf[:n]) != "WORLD" {
+		t.Errorf("ReadAt = %q, %v; want \"WORLD\"", buf[:n], err)
+	}
+
+	// A read running off the end is short and reports io.EOF
+	buf = make([]byte, 8)
+	n, err := f.ReadAt(buf, 6)
+	if err != io.EOF || string(buf[:n]) != "WORLD" {
+		t.Errorf("ReadAt past EOF = %q, %v; want \"WORLD\", io.EOF", buf[:n], err)
+	}
+
+	// Positional I/O leaves the offset alone
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("Offset after ReadAt/WriteAt = %d, want 0", pos)
+	}
+}
+
+func TestFileTruncateAndStat(t *testing.T) {
+	c, _, ctx := newLocalClient(t)
+
+	f, err := c.OpenFile(ctx, "/trunc.txt", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := f.Truncate(4); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != 4 || info.Name() != "trunc.txt" || info.Mode().Perm() != 0600 || info.IsDir() {
+		t.Errorf("Stat = size %d, name %q, mode %v; want 4, \"trunc.txt\", -rw-------",
+			info.Size(), info.Name(), info.Mode())
+	}
+
+	// Truncating up extends the file with zeros
+	if err := f.Truncate(8); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	buf := make([]byte, 8)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if !bytes.Equal(buf, []byte("0123\x00\x00\x00\x00")) {
+		t.Errorf("Content after extending = %q", buf)
+	}
+}
+
+func TestFileOpenFlags(t *testing.T) {
+	c, _, ctx := newLocalClient(t)
+
+	if _, err := c.Open(ctx, "/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a missing file returned %v, want fs.ErrNotExist", err)
+	}
+
+	f, err := c.OpenFile(ctx, "/excl", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	f.Close()
+	if _, err := c.OpenFile(ctx, "/excl", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("O_EXCL on an existing file returned %v, want fs.ErrExist", err)
+	}
+
+	ro, err := c.Open(ctx, "/excl")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := ro.Write([]byte("x")); err == nil {
+		t.Error("Write on a read-only File should fail")
+	}
+	ro.Close()
+	if _, err := ro.Read(make([]byte, 1)); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read after Close returned %v, want os.ErrClosed", err)
+	}
+
+	// O_APPEND writes land at the end regardless of the offset
+	af, err := c.OpenFile(ctx, "/excl", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer af.Close()
+	af.Write([]byte("one,"))
+	af.Write([]byte("two"))
+	if _, err := af.WriteAt([]byte("x"), 0); err == nil {
+		t.Error("WriteAt on an O_APPEND File should fail")
+	}
+	if info, err := af.Stat(); err != nil || info.Size() != int64(len("one,two")) {
+		t.Errorf("Size after appends = %v, %v; want 7", info.Size(), err)
+	}
+}
+
+func TestCreateTemp(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+
+	f, err := c.CreateTemp(ctx, "/", "upload-*.part")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	defer f.Close()
+
+	name := filepath.Base(f.Name())
+	if !strings.HasPrefix(name, "upload-") || !strings.HasSuffix(name, ".part") || len(name) <= len("upload-.part") {
+		t.Errorf("CreateTemp name = %q, want upload-<random>.part", name)
+	}
+
+	info, err := os.Stat(filepath.Join(tempDir, name))
+	if err != nil {
+		t.Fatalf("Temporary file not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Temporary file mode = %v, want -rw-------", info.Mode())
+	}
+
+	other, err := c.CreateTemp(ctx, "/", "upload-*.part")
+	if err != nil {
+		t.Fatalf("CreateTemp failed: %v", err)
+	}
+	defer other.Close()
+	if other.Name() == f.Name() {
+		t.Errorf("CreateTemp returned the same name twice: %q", f.Name())
+	}
+}
+
+func TestFileTransfersFollowServerSizes(t *testing.T) {
+	tempDir := t.TempDir()
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// A server taking much less than fileChunkSize per RPC
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxReadSize = 1000
+	config.MaxWriteSize = 1500
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	c := newServiceClient(t, nfsServer)
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	data := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 300)
+	f, err := c.OpenFile(ctx, "/big.bin", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	if n, err := f.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write returned %d, %v; want %d", n, err, len(data))
+	}
+
+	got := make([]byte, len(data))
+	if n, err := f.ReadAt(got, 0); err != nil || n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt returned %d, %v, or different data", n, err)
+	}
+
+	if err := c.WriteFileAtomic(ctx, "/atomic.bin", data, 0644); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if written, err := os.ReadFile(filepath.Join(tempDir, "atomic.bin")); err != nil || !bytes.Equal(written, data) {
+		t.Errorf("WriteFileAtomic wrote %d bytes, %v; want %d", len(written), err, len(data))
+	}
+}
+
+func TestFileTransfersAboveDefaultMessageLimit(t *testing.T) {
+	tempDir := t.TempDir()
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxReadSize = 6 << 20
+	config.MaxWriteSize = 6 << 20
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// A server accepting anything, so only the client's limits apply
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(grpc.MaxRecvMsgSize(1<<30), grpc.MaxSendMsgSize(1<<30))
+	api.RegisterNFSServiceServer(grpcServer, nfsServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	newClient := func(maxTransfer int) *Client {
+		clientConfig := DefaultConfig()
+		clientConfig.MaxTransferSize = maxTransfer
+		dialOpts := append(transportOptions(clientConfig),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.DialContext(context.Background(), "bufnet", dialOpts...)
+		if err != nil {
+			t.Fatalf("Failed to dial bufnet: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &Client{
+			conn:        conn,
+			nfsClient:   api.NewNFSServiceClient(conn),
+			config:      clientConfig,
+			handleCache: NewHandleCache(100, 5*time.Minute),
+		}
+	}
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	// Whole 6MiB transfers, above gRPC's default 4MiB limit
+	c := newClient(0)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12<<20/16)
+	f, err := c.OpenFile(ctx, "/big.bin", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	if n, err := f.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write returned %d, %v; want %d", n, err, len(data))
+	}
+	got := make([]byte, len(data))
+	if n, err := f.ReadAt(got, 0); err != nil || n != len(data) || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAt returned %d, %v, or different data", n, err)
+	}
+	if c.readSize != 6<<20 || c.writeSize != 6<<20 {
+		t.Errorf("Transfer sizes are %d and %d, want the server's 6MiB", c.readSize, c.writeSize)
+	}
+
+	// A client limited to smaller transfers stays below the server's sizes
+	small := newClient(1 << 20)
+	handle, err := small.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	if read, write := small.transferSizes(ctx, handle); read != 1<<20 || write != 1<<20 {
+		t.Errorf("Transfer sizes are %d and %d, want the client's 1MiB", read, write)
+	}
+}
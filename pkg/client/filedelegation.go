@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientIDMetadataKey is the gRPC metadata key carrying the client's ID, by
+// which the server tells its requests apart from those of other clients
+// holding delegations of the same files
+const ClientIDMetadataKey = "x-nfs-client-id"
+
+// withClientID returns a context sending the client's ID, unless ctx
+// already sends one
+func (c *Client) withClientID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(ClientIDMetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, ClientIDMetadataKey, c.clientID())
+}
+
+// FileDelegation is the right, granted by the server, to cache a file. No
+// other client changes the file while it is held, nor, for a write
+// delegation, reads it. It holds until the server recalls it because
+// another client needs the file, or until it is returned.
+type FileDelegation struct {
+	// Attributes of the file when the delegation was granted
+	Attributes *api.FileAttributes
+
+	handle   []byte
+	write    atomic.Bool
+	session  *delegationSession
+	recalled chan struct{}
+}
+
+// Recalled is closed once the delegation ends, whether the server recalled
+// it, it was returned, or the connection was lost
+func (d *FileDelegation) Recalled() <-chan struct{} {
+	return d.recalled
+}
+
+// Write reports whether writes may be cached too, not just reads
+func (d *FileDelegation) Write() bool {
+	return d.write.Load()
+}
+
+// Valid reports whether data cached under the delegation may still be used
+func (d *FileDelegation) Valid() bool {
+	select {
+	case <-d.recalled:
+		return false
+	default:
+		return true
+	}
+}
+
+// Return gives the delegation back to the server. Writes cached under it
+// must have been sent first.
+func (d *FileDelegation) Return() {
+	if d.session.take(d) {
+		d.session.giveBack(d)
+	}
+}
+
+// fileDelegations holds the client's Delegate stream, opened on first use.
+// Its zero value is ready to use.
+type fileDelegations struct {
+	mu      sync.Mutex
+	session *delegationSession
+	closed  bool
+}
+
+// delegationSession is one Delegate stream and the delegations held on it
+type delegationSession struct {
+	stream api.NFSService_DelegateClient
+	cancel context.CancelFunc
+	sendMu sync.Mutex
+
+	mu       sync.Mutex
+	sequence uint64
+	waiting  map[uint64]chan delegationAnswer
+	held     map[string]*FileDelegation
+	err      error // Why the stream ended, once it has
+}
+
+// delegationAnswer is the server's answer to a request for a delegation,
+// and the delegation granted, if it was
+type delegationAnswer struct {
+	callback *api.FileDelegationCallback
+	deleg    *FileDelegation
+}
+
+// DelegateFile asks the server for a delegation of the file fileHandle, to
+// cache its writes too if write is set. While it is held, data and
+// attributes read stay accurate; Recalled is closed once they may not.
+// When the server recalls a write delegation, the file's UNSTABLE writes
+// are committed before it is returned. Servers that do not support
+// delegations report ErrNotImplemented; one refusing because another
+// client holds the file, or too many are held, reports ERR_JUKEBOX.
+func (c *Client) DelegateFile(ctx context.Context, fileHandle []byte, write bool) (*FileDelegation, error) {
+	session, err := c.delegationSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.Lock()
+	if session.err != nil {
+		session.mu.Unlock()
+		return nil, session.failed()
+	}
+	session.sequence++
+	sequence := session.sequence
+	answer := make(chan delegationAnswer, 1)
+	session.waiting[sequence] = answer
+	session.mu.Unlock()
+	defer func() {
+		session.mu.Lock()
+		delete(session.waiting, sequence)
+		session.mu.Unlock()
+	}()
+
+	if err := session.send(&api.FileDelegationRequest{
+		Op:          api.FileDelegationOp_FILE_DELEGATION_OPEN,
+		Sequence:    sequence,
+		FileHandle:  fileHandle,
+		Credentials: credentialsFromContext(ctx),
+		Write:       write,
+	}); err != nil {
+		return nil, fmt.Errorf("Delegate RPC failed: %w", err)
+	}
+
+	// Bound the wait for the answer by the usual timeout
+	timer := time.NewTimer(c.config.Timeout)
+	defer timer.Stop()
+	select {
+	case result, ok := <-answer:
+		if !ok {
+			return nil, session.failed()
+		}
+		if result.deleg == nil {
+			return nil, StatusToError("DelegateFile", result.callback.Status)
+		}
+		return result.deleg, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("Delegate RPC failed: %w", context.DeadlineExceeded)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// delegationSession returns the client's Delegate stream, opening it if
+// none is open
+func (c *Client) delegationSession(ctx context.Context) (*delegationSession, error) {
+	c.fileDelegations.mu.Lock()
+	defer c.fileDelegations.mu.Unlock()
+
+	if c.fileDelegations.closed {
+		return nil, fmt.Errorf("Delegate RPC failed: client closed")
+	}
+	if session := c.fileDelegations.session; session != nil {
+		session.mu.Lock()
+		ended := session.err != nil
+		session.mu.Unlock()
+		if !ended {
+			return session, nil
+		}
+	}
+
+	// The stream outlives this call, so only ctx's values carry over
+	streamCtx, cancel := context.WithCancel(c.withClientID(context.WithoutCancel(ctx)))
+	stream, err := c.nfsClient.Delegate(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Delegate RPC failed: %w", err)
+	}
+	session := &delegationSession{
+		stream:  stream,
+		cancel:  cancel,
+		waiting: make(map[uint64]chan delegationAnswer),
+		held:    make(map[string]*FileDelegation),
+	}
+	c.fileDelegations.session = session
+	go c.receiveCallbacks(session)
+	return session, nil
+}
+
+// receiveCallbacks hands answers to the calls waiting for them and acts on
+// recalls until the stream ends, when every delegation held on it ends too
+func (c *Client) receiveCallbacks(session *delegationSession) {
+	for {
+		callback, err := session.stream.Recv()
+		if err != nil {
+			session.end(err)
+			return
+		}
+
+		switch callback.Type {
+		case api.FileDelegationCallbackType_FILE_DELEGATION_GRANTED:
+			session.granted(callback)
+		case api.FileDelegationCallbackType_FILE_DELEGATION_DENIED:
+			session.answer(callback.Sequence, delegationAnswer{callback: callback})
+		case api.FileDelegationCallbackType_FILE_DELEGATION_RECALLED:
+			session.mu.Lock()
+			deleg, ok := session.held[string(callback.FileHandle)]
+			session.mu.Unlock()
+			if ok {
+				go c.settleRecall(deleg)
+			}
+		}
+	}
+}
+
+// granted records a delegation granted, before any recall of it is
+// received, and hands it to the call that asked for it. Asking again for a
+// file already held upgrades the delegation held. A delegation nobody
+// waits for any more is returned at once.
+func (s *delegationSession) granted(callback *api.FileDelegationCallback) {
+	s.mu.Lock()
+	deleg, held := s.held[string(callback.FileHandle)]
+	if !held {
+		deleg = &FileDelegation{
+			Attributes: callback.Attributes,
+			handle:     callback.FileHandle,
+			session:    s,
+			recalled:   make(chan struct{}),
+		}
+		s.held[string(callback.FileHandle)] = deleg
+	}
+	deleg.write.Store(callback.Write)
+	s.mu.Unlock()
+
+	if !s.answer(callback.Sequence, delegationAnswer{callback: callback, deleg: deleg}) && !held {
+		go deleg.Return()
+	}
+}
+
+// answer hands an answer to the call waiting for it, returning false if
+// none is
+func (s *delegationSession) answer(sequence uint64, answer delegationAnswer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	waiting, ok := s.waiting[sequence]
+	if ok {
+		waiting <- answer
+		delete(s.waiting, sequence)
+	}
+	return ok
+}
+
+// settleRecall ends a delegation the server recalled, committing the
+// file's UNSTABLE writes before returning it
+func (c *Client) settleRecall(deleg *FileDelegation) {
+	if !deleg.session.take(deleg) {
+		return
+	}
+	if deleg.Write() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+		if w := c.pending.takeFile(deleg.handle); w != nil {
+			if err := c.commitPending(ctx, w); err != nil {
+				log.Printf("Warning: returning recalled delegation of %x: %v", deleg.handle, err)
+			}
+		}
+		cancel()
+	}
+	deleg.session.giveBack(deleg)
+}
+
+// send sends a request on the stream; gRPC streams take one sender at a
+// time
+func (s *delegationSession) send(req *api.FileDelegationRequest) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.stream.Send(req)
+}
+
+// take removes a delegation from those held on the session, returning
+// false if it already ended
+func (s *delegationSession) take(deleg *FileDelegation) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held[string(deleg.handle)] != deleg {
+		return false
+	}
+	delete(s.held, string(deleg.handle))
+	return true
+}
+
+// giveBack returns a delegation taken from those held, then ends it, so
+// the file is asked for again only once the server knows it was returned
+func (s *delegationSession) giveBack(deleg *FileDelegation) {
+	s.send(&api.FileDelegationRequest{
+		Op:         api.FileDelegationOp_FILE_DELEGATION_RETURN,
+		FileHandle: deleg.handle,
+	})
+	close(deleg.recalled)
+}
+
+// end records why the stream ended, failing the calls waiting on it and
+// ending every delegation held on it
+func (s *delegationSession) end(err error) {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	for sequence, answer := range s.waiting {
+		close(answer)
+		delete(s.waiting, sequence)
+	}
+	for handle, deleg := range s.held {
+		close(deleg.recalled)
+		delete(s.held, handle)
+	}
+}
+
+// failed returns the error the stream ended with, as DelegateFile reports it
+func (s *delegationSession) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status.Code(s.err) == codes.Unimplemented {
+		return NewNFSError("DelegateFile", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	return fmt.Errorf("Delegate RPC failed: %w", s.err)
+}
+
+// close ends the stream, which returns every delegation held on it
+func (d *fileDelegations) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.session != nil {
+		d.session.stream.CloseSend()
+		d.session.end(context.Canceled)
+	}
+}
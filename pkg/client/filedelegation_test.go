@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestFileDelegationRecall(t *testing.T) {
+	fileSystem, err := local.NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Two clients of the same server
+	a, b := newServiceClient(t, nfsServer), newServiceClient(t, nfsServer)
+	defer a.Close()
+	defer b.Close()
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	rootHandle, err := a.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	handle, _, err := a.Create(ctx, rootHandle, "shared.txt", &api.FileAttributes{Mode: 0644}, api.CreateMode_UNCHECKED)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	recalled := func(deleg *FileDelegation, want bool, after string) {
+		t.Helper()
+		select {
+		case <-deleg.Recalled():
+			if !want {
+				t.Fatalf("Delegation recalled after %s", after)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if want {
+				t.Fatalf("Delegation not recalled after %s", after)
+			}
+		}
+	}
+
+	// Reads by another client leave a read delegation alone, as do the
+	// holder's own writes; another client's write recalls it
+	deleg, err := a.DelegateFile(ctx, handle, false)
+	if err != nil {
+		t.Fatalf("DelegateFile failed: %v", err)
+	}
+	if deleg.Write() || deleg.Attributes.GetType() != api.FileType_REGULAR {
+		t.Errorf("Granted write = %v, type %v; want a read delegation of a regular file", deleg.Write(), deleg.Attributes.GetType())
+	}
+	if _, _, err := b.Read(ctx, handle, 0, 10); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := a.Write(ctx, handle, 0, []byte("mine"), 2); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	recalled(deleg, false, "reads by another client and the holder's writes")
+	if _, err := b.Write(ctx, handle, 0, []byte("theirs"), 2); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	recalled(deleg, true, "another client's write")
+
+	// Another client asking for a conflicting delegation is refused while
+	// the holder's is recalled
+	deleg, err = a.DelegateFile(ctx, handle, true)
+	if err != nil {
+		t.Fatalf("DelegateFile failed: %v", err)
+	}
+	if !deleg.Write() {
+		t.Error("Write delegation granted without write")
+	}
+	var nfsErr *NFSError
+	if _, err := b.DelegateFile(ctx, handle, false); !errors.As(err, &nfsErr) || nfsErr.Status != api.Status_ERR_JUKEBOX {
+		t.Errorf("Conflicting DelegateFile = %v, want ERR_JUKEBOX", err)
+	}
+	recalled(deleg, true, "a conflicting delegation was asked for")
+
+	// Writes cached under a write delegation are committed when it is
+	// recalled, before the other client's read is answered
+	deleg, err = a.DelegateFile(ctx, handle, true)
+	if err != nil {
+		t.Fatalf("DelegateFile failed: %v", err)
+	}
+	if _, err := a.Write(ctx, handle, 0, []byte("cached"), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, _, err := b.Read(ctx, handle, 0, 6)
+	if err != nil || string(data) != "cached" {
+		t.Fatalf("Read = %q, %v; want \"cached\"", data, err)
+	}
+	recalled(deleg, true, "another client's read")
+	a.pending.mu.Lock()
+	unflushed := len(a.pending.byFile)
+	a.pending.mu.Unlock()
+	if unflushed != 0 {
+		t.Errorf("%d files with UNSTABLE writes left after the recall", unflushed)
+	}
+
+	// Returning a delegation ends it as well
+	deleg, err = b.DelegateFile(ctx, handle, false)
+	if err != nil {
+		t.Fatalf("DelegateFile failed: %v", err)
+	}
+	deleg.Return()
+	recalled(deleg, true, "Return")
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// ErrUnflushed is matched by the errors Close returns for files whose
+// UNSTABLE writes it could not make stable
+var ErrUnflushed = errors.New("unstable writes could not be committed")
+
+// pendingWrite is a file with UNSTABLE writes not yet committed
+type pendingWrite struct {
+	handle []byte
+
+	// Verifier the writes were acknowledged under
+	verifier uint64
+
+	// Credentials of the last writer, which the commit is sent with
+	creds *api.Credentials
+
+	// The server restarted between writes, so earlier ones may be gone
+	lost bool
+}
+
+// pendingWrites tracks the files a client wrote UNSTABLE and has not
+// committed, so Close can commit them
+type pendingWrites struct {
+	mu     sync.Mutex
+	byFile map[string]*pendingWrite
+}
+
+// wrote records an UNSTABLE write to handle acknowledged under verifier
+func (p *pendingWrites) wrote(handle []byte, verifier uint64, creds *api.Credentials) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byFile == nil {
+		p.byFile = make(map[string]*pendingWrite)
+	}
+	w, ok := p.byFile[string(handle)]
+	if !ok {
+		w = &pendingWrite{handle: handle, verifier: verifier}
+		p.byFile[string(handle)] = w
+	}
+	if w.verifier != verifier {
+		w.lost, w.verifier = true, verifier
+	}
+	w.creds = creds
+}
+
+// committed records a commit of handle answered with verifier. Writes
+// acknowledged under another verifier stay pending, lost.
+func (p *pendingWrites) committed(handle []byte, verifier uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, ok := p.byFile[string(handle)]
+	if !ok {
+		return
+	}
+	if w.verifier != verifier {
+		w.lost = true
+		return
+	}
+	if !w.lost {
+		delete(p.byFile, string(handle))
+	}
+}
+
+// take removes and returns every pending file
+func (p *pendingWrites) take() []*pendingWrite {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var files []*pendingWrite
+	for _, w := range p.byFile {
+		files = append(files, w)
+	}
+	clear(p.byFile)
+	return files
+}
+
+// takeFile removes and returns the pending writes of handle, or nil
+func (p *pendingWrites) takeFile(handle []byte) *pendingWrite {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w := p.byFile[string(handle)]
+	delete(p.byFile, string(handle))
+	return w
+}
+
+// flush commits every file with UNSTABLE writes before ctx ends, returning
+// an error matching ErrUnflushed for each whose writes may not be stable
+func (c *Client) flush(ctx context.Context) error {
+	var errs []error
+	for _, w := range c.pending.take() {
+		errs = append(errs, c.commitPending(ctx, w))
+	}
+	return errors.Join(errs...)
+}
+
+// commitPending commits a file's UNSTABLE writes, returning an error
+// matching ErrUnflushed if they may not be stable
+func (c *Client) commitPending(ctx context.Context, w *pendingWrite) error {
+	if w.lost {
+		return fmt.Errorf("%w: file %x: the server restarted before they were committed", ErrUnflushed, w.handle)
+	}
+	verifier, err := c.Commit(context.WithValue(ctx, credentialsKey{}, w.creds), w.handle, 0, 0)
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: file %x: %w", ErrUnflushed, w.handle, err)
+	case verifier != w.verifier:
+		return fmt.Errorf("%w: file %x: the server restarted before they were committed", ErrUnflushed, w.handle)
+	}
+	return nil
+}
+
+// delegationSet tracks the directory delegations a client holds, so Close
+// can return them
+type delegationSet struct {
+	mu   sync.Mutex
+	held map[*DirDelegation]struct{}
+}
+
+func (d *delegationSet) add(deleg *DirDelegation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held == nil {
+		d.held = make(map[*DirDelegation]struct{})
+	}
+	d.held[deleg] = struct{}{}
+}
+
+func (d *delegationSet) remove(deleg *DirDelegation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.held, deleg)
+}
+
+// returnAll gives every delegation held back to the server
+func (d *delegationSet) returnAll() {
+	d.mu.Lock()
+	held := d.held
+	d.held = nil
+	d.mu.Unlock()
+	for deleg := range held {
+		deleg.Return()
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// restartingServer counts commits and can answer them as a server that
+// restarted since the writes, with a new verifier
+type restartingServer struct {
+	api.NFSServiceServer
+	commits   atomic.Int32
+	restarted atomic.Bool
+}
+
+func (r *restartingServer) Commit(ctx context.Context, req *api.CommitRequest) (*api.CommitResponse, error) {
+	r.commits.Add(1)
+	resp, err := r.NFSServiceServer.Commit(ctx, req)
+	if err == nil && r.restarted.Load() {
+		resp.Verifier++
+	}
+	return resp, err
+}
+
+func TestCloseCommitsUnstableWrites(t *testing.T) {
+	fileSystem, err := local.NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	write := func(c *Client, name string) {
+		t.Helper()
+		f, err := c.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		if _, err := f.Write([]byte("unstable")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		f.Close()
+	}
+
+	// Writes not committed are committed by Close, once per file
+	svc := &restartingServer{NFSServiceServer: nfsServer}
+	c := newServiceClient(t, svc)
+	write(c, "/a")
+	write(c, "/b")
+	f, _ := c.OpenFile(ctx, "/c", os.O_RDWR|os.O_CREATE, 0644)
+	f.Write([]byte("synced"))
+	if err := f.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	svc.commits.Store(0)
+	if err := c.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if got := svc.commits.Load(); got != 2 {
+		t.Errorf("Close sent %d commits, want 2", got)
+	}
+
+	// Writes the server may have lost are reported
+	svc = &restartingServer{NFSServiceServer: nfsServer}
+	c = newServiceClient(t, svc)
+	write(c, "/d")
+	svc.restarted.Store(true)
+	if err := c.Close(); !errors.Is(err, ErrUnflushed) {
+		t.Errorf("Close after a restart returned %v, want ErrUnflushed", err)
+	}
+}
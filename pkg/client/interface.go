@@ -3,6 +3,7 @@ package client
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/example/nfsserver/pkg/api"
@@ -15,10 +16,50 @@ type NFSClient interface {
     // GetAttr retrieves attributes for a file or directory
     GetAttr(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error)
     
+    // SetTimes sets the access and modification times of a file
+    // A nil time leaves that timestamp unchanged
+    SetTimes(ctx context.Context, fileHandle []byte, atime, mtime *time.Time) (*api.FileAttributes, error)
+    
+    // Touch sets the access and modification times of a file to the server's current time
+    Touch(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error)
+    
+    // Truncate changes the size of a file, extending it with a hole if size is past the end
+    Truncate(ctx context.Context, fileHandle []byte, size uint64) (*api.FileAttributes, error)
+    
+    // Chmod changes the mode of a file, including the setuid, setgid and sticky bits
+    Chmod(ctx context.Context, fileHandle []byte, mode uint32) (*api.FileAttributes, error)
+    
+    // Chown changes the owner and group of a file; -1 leaves an ID unchanged
+    Chown(ctx context.Context, fileHandle []byte, uid, gid int) (*api.FileAttributes, error)
+    
+    // SetAttr changes mode, owner, size and times in one request, optionally
+    // only if the file's ctime is unchanged
+    SetAttr(ctx context.Context, fileHandle []byte, changes AttrChanges) (*api.FileAttributes, error)
+    
+    // EntryCount returns the number of entries in a directory, excluding "." and ".."
+    // A count of zero means the directory is empty
+    EntryCount(ctx context.Context, dirHandle []byte) (uint64, error)
+    
     // Lookup looks up a file name in a directory
     // Returns the file handle, attributes, and any error
     Lookup(ctx context.Context, dirHandle []byte, name string) ([]byte, *api.FileAttributes, error)
     
+    // BulkLookup looks up several names in one directory with a single RPC
+    // Returns one result per name, in order; each carries its own status
+    BulkLookup(ctx context.Context, dirHandle []byte, names []string) ([]*api.BulkLookupResult, error)
+    
+    // DelegateDirectory asks for the right to cache a directory listing
+    // until the server recalls it because the directory's entries changed
+    DelegateDirectory(ctx context.Context, dirHandle []byte) (*DirDelegation, error)
+    
+    // DelegateFile asks for the right to cache a file, and its writes too
+    // if write is set, until the server recalls it for another client
+    DelegateFile(ctx context.Context, fileHandle []byte, write bool) (*FileDelegation, error)
+    
+    // Watch subscribes to the changes made through the server below a
+    // directory, and with recursive set below its subdirectories too
+    Watch(ctx context.Context, dirHandle []byte, recursive bool) (*Watcher, error)
+    
     // Read and write operations
     
     // Read reads data from a file at the specified offset
@@ -30,12 +71,90 @@ type NFSClient interface {
     // Returns the number of bytes written and any error
     Write(ctx context.Context, fileHandle []byte, offset int64, data []byte, stability int) (int, error)
     
+    // WriteStream writes the first size bytes of r to the file at offset,
+    // as chunks sent on one stream rather than a Write RPC each, for large
+    // files. Returns the bytes written, also when it fails part way.
+    WriteStream(ctx context.Context, fileHandle []byte, offset int64, r io.ReaderAt, size int64, stability int) (int64, error)
+    
+    // Append writes data at the current end of the file, as chosen by the server
+    // Returns the offset the data was written at, the number of bytes written, and any error
+    Append(ctx context.Context, fileHandle []byte, data []byte, stability int) (int64, int, error)
+    
+    // Commit flushes UNSTABLE writes in a range of a file (count 0 = to end of file)
+    // Returns the server's write verifier and any error
+    Commit(ctx context.Context, fileHandle []byte, offset uint64, count uint32) (uint64, error)
+    
+    // AcquireFence issues a new fencing epoch for a file; writes made with
+    // WithFence and an older epoch then fail with ErrFenced
+    AcquireFence(ctx context.Context, fileHandle []byte) (uint64, error)
+    
+    // Lock takes a byte-range lock on a file, failing with ErrLocked if
+    // another owner holds a conflicting one, or waiting for it to be
+    // released if wait is set
+    Lock(ctx context.Context, fileHandle []byte, lock FileLock, wait bool) error
+    
+    // Unlock releases an owner's locks over a range of a file
+    Unlock(ctx context.Context, fileHandle []byte, lock FileLock) error
+    
+    // TestLock returns a lock that would keep lock from being taken, or nil
+    TestLock(ctx context.Context, fileHandle []byte, lock FileLock) (*FileLock, error)
+    
+    // Checksum asks the server to hash length bytes of a file starting at offset
+    // (length 0 = to end of file), so large files need not cross the network
+    // Returns the digest, the number of bytes hashed, and any error
+    Checksum(ctx context.Context, fileHandle []byte, algorithm api.ChecksumAlgorithm, offset, length uint64) ([]byte, uint64, error)
+    
+    // Allocate reserves space for length bytes of a file starting at offset,
+    // extending it if needed, so writes to the range cannot run out of space
+    Allocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error)
+    
+    // Deallocate punches a hole of length bytes in a file starting at offset:
+    // the range reads as zeros and no longer takes space
+    Deallocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error)
+    
+    // Seek returns the offset of the first data or hole at or after offset,
+    // and whether the range starting there runs to the end of the file.
+    // Seeking data past the last of it fails with ErrNoData.
+    Seek(ctx context.Context, fileHandle []byte, offset uint64, what api.SeekContent) (uint64, bool, error)
+    
+    // CopyRange asks the server to copy length bytes of srcHandle from
+    // srcOffset into dstHandle at dstOffset (length 0 = to end of file)
+    // Returns the number of bytes copied, which may be fewer, and whether
+    // the end of the source was reached
+    CopyRange(ctx context.Context, srcHandle, dstHandle []byte, srcOffset, dstOffset, length uint64) (uint64, bool, error)
+    
+    // SnapshotHandle returns the handle of a file as it was in the snapshot
+    // called snapshot, along with its attributes there. GetAttr, Read and
+    // the like take the handle as they take any other; changes through it
+    // fail with ERR_ROFS.
+    SnapshotHandle(ctx context.Context, fileHandle []byte, snapshot string) ([]byte, *api.FileAttributes, error)
+    
+    // CopyFile copies the file at srcPath to dstPath on the server, creating
+    // or truncating it
+    CopyFile(ctx context.Context, srcPath, dstPath string, mode uint32) error
+    
+    // WriteFileAtomic replaces the file at path with data by writing an unnamed
+    // (or, if unsupported, temporary) file with FILE_SYNC and linking it over the destination
+    WriteFileAtomic(ctx context.Context, path string, data []byte, mode uint32) error
+    
+    // Download copies the file at path to w, resuming from and recording
+    // progress in opts.State
+    Download(ctx context.Context, path string, w io.WriterAt, opts TransferOptions) error
+    
+    // Upload writes size bytes from r to the file at path with FILE_SYNC,
+    // resuming from and recording progress in opts.State
+    Upload(ctx context.Context, r io.ReaderAt, size int64, path string, mode uint32, opts TransferOptions) error
+    
     // Directory operations
     
     // ReadDir reads the contents of a directory
     // Returns directory entries and any error
     ReadDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry, error)
     
+    // ReadDirPlus reads the contents of a directory with the handle and
+    // attributes of each entry, sparing a Lookup per entry
+    ReadDirPlus(ctx context.Context, dirHandle []byte) ([]*api.DirEntryPlus, error)
+    
     // File system modification operations
     
     // Create creates a new file in the specified directory
@@ -55,6 +174,65 @@ type NFSClient interface {
     // Rename renames a file or directory
     Rename(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string) error
     
+    // RenameWithFlags renames a file or directory, refusing to replace the
+    // destination or swapping it with the source as flags say
+    RenameWithFlags(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string, flags RenameFlags) error
+    
+    // Symlink creates a symbolic link pointing at target
+    // Returns the link handle, attributes, and any error
+    Symlink(ctx context.Context, dirHandle []byte, name string, target string) ([]byte, *api.FileAttributes, error)
+    
+    // Readlink returns the target of a symbolic link
+    Readlink(ctx context.Context, fileHandle []byte) (string, error)
+    
+    // Link creates a hard link to an existing file
+    // Returns the file's attributes with the new link count
+    Link(ctx context.Context, fileHandle []byte, dirHandle []byte, name string) (*api.FileAttributes, error)
+    
+    // CreateUnnamed creates a file with no name that is invisible until it is
+    // published with LinkIntoPlace; unpublished files are discarded by the server
+    CreateUnnamed(ctx context.Context, dirHandle []byte, attrs *api.FileAttributes) ([]byte, *api.FileAttributes, error)
+    
+    // LinkIntoPlace atomically publishes an unnamed file as name in dirHandle,
+    // replacing any existing entry only if replace is set
+    LinkIntoPlace(ctx context.Context, fileHandle []byte, dirHandle []byte, name string, replace bool) (*api.FileAttributes, error)
+    
+    // RemoveTree deletes a directory and everything below it in a single call,
+    // with at most concurrency parallel deletions on the server (0 = server default)
+    // progress, if not nil, receives periodic progress updates
+    RemoveTree(ctx context.Context, dirHandle []byte, name string, concurrency int, progress func(*api.RemoveTreeProgress)) error
+    
+    // RemoveTreeInBackground takes a directory and everything below it out of
+    // the namespace at once, leaving the server to delete it later. It
+    // returns the id the deletion is listed under in the admin service.
+    RemoveTreeInBackground(ctx context.Context, dirHandle []byte, name string) (uint64, error)
+    
+    // Access checks whether the caller may access a file
+    // mode bits: 4=read, 2=write, 1=execute (0 only checks existence)
+    // Returns nil if access is granted
+    Access(ctx context.Context, fileHandle []byte, mode uint32) error
+    
+    // CheckAccess returns which of the requested rights (a mask of
+    // api.AccessRight) the caller holds on a file
+    CheckAccess(ctx context.Context, fileHandle []byte, rights uint32) (uint32, error)
+    
+    // File system information
+    
+    // FsStat retrieves capacity statistics for the file system containing fileHandle
+    FsStat(ctx context.Context, fileHandle []byte) (*api.FsStatResponse, error)
+    
+    // PathConf retrieves the name and path limits enforced by the server
+    // (zero = no limit), so callers can reject over-long names up front
+    PathConf(ctx context.Context, fileHandle []byte) (*api.PathConfResponse, error)
+    
+    // FsInfo retrieves the server's transfer sizes and capabilities, so
+    // requests can be sized to what it accepts
+    FsInfo(ctx context.Context, fileHandle []byte) (*api.FsInfoResponse, error)
+    
+    // GetQuota retrieves the caller's quota usage on the export containing fileHandle
+    // Returns an *NFSError with status ERR_NOTSUPP if the server enforces no quotas
+    GetQuota(ctx context.Context, fileHandle []byte) (*api.GetQuotaResponse, error)
+    
     // Resource management
     
     // Close closes the client connection and releases all resources
@@ -62,11 +240,22 @@ type NFSClient interface {
     
     // Path operations
     
-    // GetRootFileHandle retrieves the root directory file handle from the server
+    // GetRootFileHandle retrieves the root directory file handle of the
+    // configured export from the server
     GetRootFileHandle(ctx context.Context) ([]byte, error)
     
+    // ListExports lists the exports of the server this client may use
+    ListExports(ctx context.Context) ([]*api.ExportInfo, error)
+    
     // LookupPath resolves a file path to a file handle, starting from the root
     LookupPath(ctx context.Context, path string) ([]byte, error)
+    
+    // TailFollow streams bytes appended to the file at path until ctx is
+    // canceled or the returned reader is closed
+    TailFollow(ctx context.Context, path string) (io.ReadCloser, error)
+    
+    // TailFollowFrom is like TailFollow but starts at offset (negative = end of file)
+    TailFollowFrom(ctx context.Context, path string, offset int64) (io.ReadCloser, error)
 }
 
 
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// FS returns the export as an io/fs.FS, for code written against the
+// standard library's file system interfaces such as http.FS, fs.WalkDir and
+// template.ParseFS. Names are slash-separated paths from the export root.
+// Every operation uses the credentials attached to ctx.
+func (c *Client) FS(ctx context.Context) fs.FS {
+	return &exportFS{client: c, ctx: ctx}
+}
+
+// exportFS implements fs.FS over a Client
+type exportFS struct {
+	client *Client
+	ctx    context.Context
+}
+
+// Open implements fs.FS. Directories open as fs.ReadDirFile.
+func (e *exportFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	handle, err := e.client.LookupPath(e.ctx, "/"+name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	attrs, err := e.client.GetAttr(e.ctx, handle)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if attrs.Type == api.FileType_DIRECTORY {
+		return &dirFile{fs: e, name: name, handle: handle, attrs: attrs}, nil
+	}
+	return &File{client: e.client, ctx: e.ctx, name: name, handle: handle, flag: os.O_RDONLY}, nil
+}
+
+// dirFile is an open directory of an exportFS
+type dirFile struct {
+	fs     *exportFS
+	name   string
+	handle []byte
+	attrs  *api.FileAttributes
+
+	// Entries not yet returned by ReadDir, once the directory is listed
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: path.Base(d.name), attrs: d.attrs}, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: ErrIsDir}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile. The directory is listed on the first
+// call, in the order the server returns it.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		plus, err := d.fs.client.ReadDirPlus(d.fs.ctx, d.handle)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.listed = true
+		for _, entry := range plus {
+			if entry.Name == "." || entry.Name == ".." || entry.Attributes == nil {
+				continue
+			}
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(&fileInfo{name: entry.Name, attrs: entry.Attributes}))
+		}
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
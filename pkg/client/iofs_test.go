@@ -0,0 +1,33 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+
+	files := map[string]string{
+		"hello.txt":       "hello, world\n",
+		"docs/readme.md":  "# docs\n",
+		"docs/deep/a.txt": "a",
+		"docs/deep/b.txt": "bb",
+		"empty/.keep":     "",
+	}
+	for name, data := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	if err := fstest.TestFS(c.FS(ctx), "hello.txt", "docs/readme.md", "docs/deep/a.txt", "docs/deep/b.txt", "empty/.keep"); err != nil {
+		t.Fatal(err)
+	}
+}
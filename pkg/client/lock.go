@@ -0,0 +1,496 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FileLock is an advisory byte-range lock. Locks of the same owner never
+// conflict; a new lock replaces the owner's locks over its range.
+type FileLock struct {
+	// Owner of the lock within this client, such as a process ID
+	Owner uint64
+
+	// Range locked: Length bytes from Offset (Length 0 = through the end
+	// of the file, however far it grows)
+	Offset uint64
+	Length uint64
+
+	// Exclusive (write) lock; shared (read) locks only conflict with
+	// exclusive ones
+	Exclusive bool
+
+	// Client holding the lock, set on the conflicting locks TestLock
+	// returns
+	ClientID string
+}
+
+// graceRetryDelay is how long Lock waits before trying again while the
+// server is letting clients reclaim their locks after a restart
+const graceRetryDelay = time.Second
+
+// heldLock is a lock the client holds, kept to reclaim it if the server
+// restarts
+type heldLock struct {
+	handle []byte
+	lock   FileLock
+	creds  *api.Credentials
+}
+
+// lockLease keeps the server's lease on the client's locks alive while it
+// holds or waits for any, and reclaims them if the server restarts. Its
+// zero value is ready to use.
+type lockLease struct {
+	mu      sync.Mutex
+	id      string
+	boot    uint64 // Server instance holding the locks (0 = not registered)
+	held    []heldLock
+	running bool
+	waiting int
+	grants  uint64 // Locks granted, so renewLoop sees those granted while it renewed
+	closed  bool
+	stop    chan struct{}
+}
+
+// clientID returns the ID the client's locks are held under:
+// Config.ClientID, or else the host name with a random suffix, so locks
+// taken before a restart are not mistaken for the new process's own
+func (c *Client) clientID() string {
+	c.locks.mu.Lock()
+	defer c.locks.mu.Unlock()
+	if c.locks.id == "" {
+		c.locks.id = c.config.ClientID
+	}
+	if c.locks.id == "" {
+		host, _ := os.Hostname()
+		suffix := make([]byte, 8)
+		rand.Read(suffix)
+		c.locks.id = host + "-" + hex.EncodeToString(suffix)
+	}
+	return c.locks.id
+}
+
+// Lock takes a byte-range lock on a file. If another owner holds a
+// conflicting lock it fails with ErrLocked, unless wait is set, in which
+// case it waits until the lock is released or ctx is done, failing with
+// ErrDeadlock if the holder is itself waiting, directly or not, for a lock
+// owner of this client. The server releases the client's locks if it
+// stops hearing from it, so they are renewed in the background until none
+// are left; if the server restarts, they are reclaimed.
+func (c *Client) Lock(ctx context.Context, fileHandle []byte, lock FileLock, wait bool) error {
+	if err := c.register(ctx); err != nil {
+		return err
+	}
+
+	// Create request
+	req := &api.LockRequest{
+		FileHandle:  fileHandle,
+		Credentials: credentialsFromContext(ctx),
+		ClientId:    c.clientID(),
+		Owner:       lock.Owner,
+		Offset:      lock.Offset,
+		Length:      lock.Length,
+		Exclusive:   lock.Exclusive,
+		Block:       wait,
+	}
+
+	// A waiting Lock takes as long as the holder keeps its lock, so only
+	// ctx bounds it
+	callCtx, cancel := c.operationContext(ctx)
+	if wait {
+		callCtx, cancel = context.WithCancel(ctx)
+		c.startWaiting()
+		defer c.stopWaiting()
+	}
+	defer cancel()
+
+	// Not retried: a retry of a lock granted after the first attempt timed
+	// out would wait for the lock this client already holds. Only a server
+	// refusing new locks while others are reclaimed is asked again.
+	var resp *api.LockResponse
+	var err error
+	for {
+		resp, err = c.nfsClient.Lock(callCtx, req)
+		if err != nil || resp.Status != api.Status_ERR_GRACE {
+			break
+		}
+		select {
+		case <-callCtx.Done():
+		case <-time.After(graceRetryDelay):
+			continue
+		}
+		break
+	}
+	if status.Code(err) == codes.Unimplemented {
+		return NewNFSError("Lock", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	if err != nil {
+		return fmt.Errorf("Lock RPC failed: %w", err)
+	}
+
+	// Check the status
+	if resp.Status != api.Status_OK {
+		return StatusToError("Lock", resp.Status)
+	}
+
+	c.locks.hold(heldLock{handle: fileHandle, lock: lock, creds: req.Credentials})
+	c.renewLocks(resp.LeaseSeconds)
+	return nil
+}
+
+// Unlock releases an owner's locks over a range of a file, splitting
+// locks that extend beyond it. Releasing a range the owner holds no lock
+// on succeeds.
+func (c *Client) Unlock(ctx context.Context, fileHandle []byte, lock FileLock) error {
+	// Create request
+	req := &api.UnlockRequest{
+		FileHandle:  fileHandle,
+		Credentials: credentialsFromContext(ctx),
+		ClientId:    c.clientID(),
+		Owner:       lock.Owner,
+		Offset:      lock.Offset,
+		Length:      lock.Length,
+	}
+
+	// Create a context with timeout
+	callCtx, cancel := c.operationContext(ctx)
+	defer cancel()
+
+	// Call the RPC method with retry logic; unlocking twice is harmless
+	var resp *api.UnlockResponse
+	var err error
+
+	err = c.callWithRetry(callCtx, "Unlock", func(retryCtx context.Context) error {
+		resp, err = c.nfsClient.Unlock(retryCtx, req)
+		return err
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return NewNFSError("Unlock", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	if err != nil {
+		return fmt.Errorf("Unlock RPC failed: %w", err)
+	}
+
+	// Check the status
+	if resp.Status != api.Status_OK {
+		return StatusToError("Unlock", resp.Status)
+	}
+
+	c.locks.mu.Lock()
+	c.locks.releaseLocked(fileHandle, lock)
+	c.locks.mu.Unlock()
+	return nil
+}
+
+// TestLock returns a lock held by another owner that would keep lock from
+// being taken, or nil if it could be taken now
+func (c *Client) TestLock(ctx context.Context, fileHandle []byte, lock FileLock) (*FileLock, error) {
+	// Create request
+	req := &api.TestLockRequest{
+		FileHandle:  fileHandle,
+		Credentials: credentialsFromContext(ctx),
+		ClientId:    c.clientID(),
+		Owner:       lock.Owner,
+		Offset:      lock.Offset,
+		Length:      lock.Length,
+		Exclusive:   lock.Exclusive,
+	}
+
+	// Create a context with timeout
+	callCtx, cancel := c.operationContext(ctx)
+	defer cancel()
+
+	// Call the RPC method with retry logic
+	var resp *api.TestLockResponse
+	var err error
+
+	for {
+		err = c.callWithRetry(callCtx, "TestLock", func(retryCtx context.Context) error {
+			resp, err = c.nfsClient.TestLock(retryCtx, req)
+			return err
+		})
+		if err != nil || resp.Status != api.Status_ERR_GRACE {
+			break
+		}
+		// Locks not yet reclaimed would be missed
+		select {
+		case <-callCtx.Done():
+		case <-time.After(graceRetryDelay):
+			continue
+		}
+		break
+	}
+	if status.Code(err) == codes.Unimplemented {
+		return nil, NewNFSError("TestLock", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("TestLock RPC failed: %w", err)
+	}
+
+	// Check the status
+	if resp.Status != api.Status_OK {
+		return nil, StatusToError("TestLock", resp.Status)
+	}
+
+	if resp.Holder == nil {
+		return nil, nil
+	}
+	return &FileLock{
+		Owner:     resp.Holder.Owner,
+		Offset:    resp.Holder.Offset,
+		Length:    resp.Holder.Length,
+		Exclusive: resp.Holder.Exclusive,
+		ClientID:  resp.Holder.ClientId,
+	}, nil
+}
+
+// register starts the client's lease on the server before its first lock,
+// learning which instance of the server holds its locks. Servers that
+// cannot recover locks after a restart are not registered with.
+func (c *Client) register(ctx context.Context) error {
+	c.locks.mu.Lock()
+	registered := c.locks.boot != 0
+	c.locks.mu.Unlock()
+	if registered {
+		return nil
+	}
+
+	callCtx, cancel := c.operationContext(ctx)
+	defer cancel()
+	req := &api.RegisterClientRequest{ClientId: c.clientID(), Credentials: credentialsFromContext(ctx)}
+	var resp *api.RegisterClientResponse
+	var err error
+	err = c.callWithRetry(callCtx, "RegisterClient", func(retryCtx context.Context) error {
+		resp, err = c.nfsClient.RegisterClient(retryCtx, req)
+		return err
+	})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("RegisterClient RPC failed: %w", err)
+	}
+	if resp.Status != api.Status_OK {
+		return StatusToError("RegisterClient", resp.Status)
+	}
+
+	c.locks.mu.Lock()
+	if c.locks.boot == 0 {
+		c.locks.boot = resp.ServerBoot
+	}
+	c.locks.mu.Unlock()
+	return nil
+}
+
+// reclaim takes back the locks the client held on a server that has since
+// restarted, during the grace period the server gives it.
+// Locks the server no longer lets it take are dropped with a warning, as
+// the client lost them.
+func (c *Client) reclaim() {
+	ctx, cancel := c.operationContext(context.Background())
+	defer cancel()
+	resp, err := c.nfsClient.RegisterClient(ctx, &api.RegisterClientRequest{ClientId: c.clientID()})
+	if err == nil && resp.Status != api.Status_OK {
+		err = StatusToError("RegisterClient", resp.Status)
+	}
+	if err != nil {
+		// Try again at the next renewal
+		log.Printf("Warning: failed to register with restarted server: %v", err)
+		return
+	}
+
+	c.locks.mu.Lock()
+	held := c.locks.held
+	c.locks.boot = resp.ServerBoot
+	if !resp.Reclaim {
+		c.locks.held = nil
+	}
+	c.locks.mu.Unlock()
+	if !resp.Reclaim {
+		if len(held) > 0 {
+			log.Printf("Warning: server restarted without a grace period; %d locks lost", len(held))
+		}
+		return
+	}
+
+	log.Printf("Server restarted; reclaiming %d locks", len(held))
+	for _, h := range held {
+		if err := c.reclaimLock(ctx, h); err != nil {
+			log.Printf("Warning: lost lock on %x (owner %d, range %d+%d): %v", h.handle, h.lock.Owner, h.lock.Offset, h.lock.Length, err)
+			c.locks.mu.Lock()
+			c.locks.releaseLocked(h.handle, h.lock)
+			c.locks.mu.Unlock()
+		}
+	}
+	if _, err := c.nfsClient.ReclaimComplete(ctx, &api.ReclaimCompleteRequest{ClientId: c.clientID()}); err != nil {
+		log.Printf("Warning: ReclaimComplete failed: %v", err)
+	}
+}
+
+// reclaimLock takes back one lock held before the server restarted
+func (c *Client) reclaimLock(ctx context.Context, h heldLock) error {
+	resp, err := c.nfsClient.Lock(ctx, &api.LockRequest{
+		FileHandle:  h.handle,
+		Credentials: h.creds,
+		ClientId:    c.clientID(),
+		Owner:       h.lock.Owner,
+		Offset:      h.lock.Offset,
+		Length:      h.lock.Length,
+		Exclusive:   h.lock.Exclusive,
+		Reclaim:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("Lock RPC failed: %w", err)
+	}
+	if resp.Status != api.Status_OK {
+		return StatusToError("Lock", resp.Status)
+	}
+	return nil
+}
+
+// startWaiting counts a waiting Lock, which keeps the lease renewed
+func (c *Client) startWaiting() {
+	c.locks.mu.Lock()
+	c.locks.waiting++
+	c.locks.mu.Unlock()
+}
+
+// stopWaiting ends what startWaiting began
+func (c *Client) stopWaiting() {
+	c.locks.mu.Lock()
+	c.locks.waiting--
+	c.locks.mu.Unlock()
+}
+
+// renewLocks starts renewing the lease on the client's locks, a third of
+// leaseSeconds apart, unless that is already under way or the server's
+// leases never run out
+func (c *Client) renewLocks(leaseSeconds uint32) {
+	if leaseSeconds == 0 {
+		return
+	}
+	c.locks.mu.Lock()
+	defer c.locks.mu.Unlock()
+	c.locks.grants++
+	if c.locks.running || c.locks.closed {
+		return
+	}
+	if c.locks.stop == nil {
+		c.locks.stop = make(chan struct{})
+	}
+	c.locks.running = true
+	go c.renewLoop(time.Duration(leaseSeconds)*time.Second/3, c.locks.stop)
+}
+
+// renewLoop renews the lease every interval until the server reports no
+// locks held or waited for, or the client is closed
+func (c *Client) renewLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		c.locks.mu.Lock()
+		grants := c.locks.grants
+		c.locks.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		resp, err := c.nfsClient.RenewLocks(ctx, &api.RenewLocksRequest{ClientId: c.clientID()})
+		cancel()
+		if err != nil {
+			// Try again at the next tick; the lease outlasts a few misses
+			log.Printf("Warning: failed to renew lock lease: %v", err)
+			continue
+		}
+
+		c.locks.mu.Lock()
+		restarted := resp.Status == api.Status_OK && c.locks.boot != 0 && resp.ServerBoot != c.locks.boot
+		c.locks.mu.Unlock()
+		if restarted {
+			c.reclaim()
+			continue
+		}
+
+		c.locks.mu.Lock()
+		if resp.Status == api.Status_OK && resp.Locks == 0 && c.locks.waiting == 0 && c.locks.grants == grants {
+			c.locks.running = false
+			c.locks.mu.Unlock()
+			return
+		}
+		c.locks.mu.Unlock()
+	}
+}
+
+// hold records a lock granted, replacing the owner's locks over its range
+func (l *lockLease) hold(h heldLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(h.handle, h.lock)
+	l.held = append(l.held, h)
+}
+
+// releaseLocked forgets lock's owner's locks over its range of the file
+// with handle, keeping the parts outside it, as the server does
+func (l *lockLease) releaseLocked(handle []byte, lock FileLock) {
+	start, end := lockRange(lock)
+	var kept []heldLock
+	for _, h := range l.held {
+		hstart, hend := lockRange(h.lock)
+		if h.lock.Owner != lock.Owner || !bytes.Equal(h.handle, handle) || hend <= start || end <= hstart {
+			kept = append(kept, h)
+			continue
+		}
+		if hstart < start {
+			part := h
+			part.lock.Length = start - hstart
+			kept = append(kept, part)
+		}
+		if end < hend {
+			part := h
+			part.lock.Offset = end
+			part.lock.Length = 0
+			if hend != math.MaxUint64 {
+				part.lock.Length = hend - end
+			}
+			kept = append(kept, part)
+		}
+	}
+	l.held = kept
+}
+
+// lockRange returns the bytes lock covers, from start up to but excluding
+// end, with end math.MaxUint64 for locks through the end of the file
+func lockRange(lock FileLock) (uint64, uint64) {
+	end := lock.Offset + lock.Length
+	if lock.Length == 0 || end < lock.Offset {
+		end = math.MaxUint64
+	}
+	return lock.Offset, end
+}
+
+// close stops renewing the lease, leaving the server to release the
+// locks still held once it runs out
+func (l *lockLease) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed && l.stop != nil {
+		close(l.stop)
+	}
+	l.closed = true
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestByteRangeLocks(t *testing.T) {
+	c, tempDir, ctx := newLocalClient(t)
+	if err := os.WriteFile(filepath.Join(tempDir, "db"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	handle, err := c.LookupPath(ctx, "/db")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+
+	// A second client on the same server, as on another host
+	other := &Client{conn: c.conn, nfsClient: c.nfsClient, config: c.config, handleCache: c.handleCache}
+	if other.clientID() == c.clientID() {
+		t.Fatalf("Clients share the ID %q", c.clientID())
+	}
+
+	if err := c.Lock(ctx, handle, FileLock{Owner: 1, Exclusive: true}, false); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := other.Lock(ctx, handle, FileLock{Owner: 1, Offset: 2, Length: 1}, false); !errors.Is(err, ErrLocked) {
+		t.Errorf("Conflicting Lock returned %v, want ErrLocked", err)
+	}
+	holder, err := other.TestLock(ctx, handle, FileLock{Owner: 1})
+	if err != nil || holder == nil || holder.ClientID != c.clientID() || !holder.Exclusive {
+		t.Errorf("TestLock = %+v, %v; want the first client's exclusive lock", holder, err)
+	}
+
+	// A waiting Lock is granted once the holder unlocks
+	granted := make(chan error)
+	go func() {
+		granted <- other.Lock(ctx, handle, FileLock{Owner: 1}, true)
+	}()
+	select {
+	case err := <-granted:
+		t.Fatalf("Waiting Lock returned %v while the file was locked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := c.Unlock(ctx, handle, FileLock{Owner: 1}); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	select {
+	case err := <-granted:
+		if err != nil {
+			t.Errorf("Waiting Lock failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting Lock still waiting after Unlock")
+	}
+	if holder, err := c.TestLock(ctx, handle, FileLock{Owner: 1}); err != nil || holder != nil {
+		t.Errorf("TestLock of a shared lock = %+v, %v; want none", holder, err)
+	}
+}
+
+// restartingService passes lock calls to a server the test replaces, as if
+// it restarted
+type restartingService struct {
+	api.UnimplementedNFSServiceServer
+
+	server atomic.Pointer[server.NFSServer]
+}
+
+func (s *restartingService) Lock(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+	return s.server.Load().Lock(ctx, req)
+}
+
+func (s *restartingService) Unlock(ctx context.Context, req *api.UnlockRequest) (*api.UnlockResponse, error) {
+	return s.server.Load().Unlock(ctx, req)
+}
+
+func (s *restartingService) RenewLocks(ctx context.Context, req *api.RenewLocksRequest) (*api.RenewLocksResponse, error) {
+	return s.server.Load().RenewLocks(ctx, req)
+}
+
+func (s *restartingService) RegisterClient(ctx context.Context, req *api.RegisterClientRequest) (*api.RegisterClientResponse, error) {
+	return s.server.Load().RegisterClient(ctx, req)
+}
+
+func (s *restartingService) ReclaimComplete(ctx context.Context, req *api.ReclaimCompleteRequest) (*api.ReclaimCompleteResponse, error) {
+	return s.server.Load().ReclaimComplete(ctx, req)
+}
+
+func TestLocksReclaimedAfterServerRestart(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "db"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	stateDir := t.TempDir()
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(stateDir, "handle.key")
+	config.LockClientsFile = filepath.Join(stateDir, "locks.json")
+	config.LockLease = 3 * time.Second // Renewed every second
+	start := func() *server.NFSServer {
+		nfsServer, err := server.NewNFSServer(config, fileSystem)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return nfsServer
+	}
+
+	svc := &restartingService{}
+	svc.server.Store(start())
+	ctx := WithCredentials(context.Background(), 0, 0)
+	handle, err := newServiceClient(t, svc.server.Load()).LookupPath(ctx, "/db")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	c := newServiceClient(t, svc)
+	other := &Client{conn: c.conn, nfsClient: c.nfsClient, config: c.config, handleCache: c.handleCache}
+	defer c.Close()
+
+	if err := c.Lock(ctx, handle, FileLock{Owner: 1, Exclusive: true}, false); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	// The new instance grants no locks until the holder has reclaimed its
+	// own, so the file is still locked
+	svc.server.Store(start())
+	if err := other.Lock(ctx, handle, FileLock{Owner: 1}, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock after the restart returned %v, want ErrLocked", err)
+	}
+
+	if err := c.Unlock(ctx, handle, FileLock{Owner: 1}); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := other.Lock(ctx, handle, FileLock{Owner: 1}, false); err != nil {
+		t.Errorf("Lock after the reclaimed lock was released failed: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Caches whose lookups Metrics counts
+const (
+	// CacheDisk is the block cache of Config.DiskCacheDir
+	CacheDisk = "disk"
+
+	// CacheListing is the FUSE client's cache of delegated directory
+	// listings
+	CacheListing = "listing"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the RPC latency
+// histogram buckets, the same as the server's
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics counts the RPCs clients make, their latencies and the lookups in
+// their caches, for monitoring services that embed the client. Every client
+// has one, its own unless Config.Metrics supplies one to share. The counts
+// are served in the Prometheus text format by Handler and published to
+// expvar by Publish. The zero value is not usable; use NewMetrics.
+type Metrics struct {
+	mu  sync.Mutex
+	ops map[opKey]*opMetrics
+
+	// Bytes received by reads and sent by writes the server accepted
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+
+	cacheMu sync.Mutex
+	caches  map[string]*cacheMetrics
+}
+
+// opKey identifies the RPCs counted together
+type opKey struct {
+	server string
+	op     string
+}
+
+// opMetrics are the counts of one operation on one server
+type opMetrics struct {
+	count   uint64
+	retries uint64
+
+	// RPCs failing with each gRPC code or answered with each NFS status
+	// other than OK
+	errors map[string]uint64
+
+	// RPCs per latency bucket, the last for those above every bound
+	buckets []uint64
+	sum     float64
+}
+
+// cacheMetrics are the lookups in one cache
+type cacheMetrics struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewMetrics returns empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ops:    make(map[opKey]*opMetrics),
+		caches: make(map[string]*cacheMetrics),
+	}
+}
+
+// opLocked returns the counts of op on server, creating them; m.mu must be
+// held
+func (m *Metrics) opLocked(server, op string) *opMetrics {
+	key := opKey{server: server, op: op}
+	o, ok := m.ops[key]
+	if !ok {
+		o = &opMetrics{errors: make(map[string]uint64), buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.ops[key] = o
+	}
+	return o
+}
+
+// observe records an RPC for op to server that ended with outcome, empty
+// for success, after duration
+func (m *Metrics) observe(server, op, outcome string, duration time.Duration) {
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := m.opLocked(server, op)
+	o.count++
+	o.buckets[bucket]++
+	o.sum += seconds
+	if outcome != "" {
+		o.errors[outcome]++
+	}
+}
+
+// observeStream records a streaming RPC for op to server that ended with
+// resp or err after duration, counting the bytes a WriteStream wrote. Nil
+// metrics record nothing.
+func (m *Metrics) observeStream(server, op string, resp *api.WriteStreamResponse, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	outcome := ""
+	if err != nil {
+		outcome = status.Code(err).String()
+	} else if resp.Status != api.Status_OK {
+		outcome = resp.Status.String()
+	}
+	m.observe(server, op, outcome, duration)
+	if resp != nil {
+		m.bytesWritten.Add(resp.Count)
+	}
+}
+
+// retried records a retry of op to server. Nil metrics record nothing.
+func (m *Metrics) retried(server, op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opLocked(server, op).retries++
+}
+
+// CacheLookup records a lookup in cache, one of the Cache constants, that
+// hit or missed. Nil metrics record nothing.
+func (m *Metrics) CacheLookup(cache string, hit bool) {
+	if m == nil {
+		return
+	}
+	m.cacheMu.Lock()
+	c, ok := m.caches[cache]
+	if !ok {
+		c = &cacheMetrics{}
+		m.caches[cache] = c
+	}
+	m.cacheMu.Unlock()
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// interceptor returns a client interceptor recording every RPC, and the
+// bytes moved by reads and writes
+func (m *Metrics) interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		outcome := ""
+		if err != nil {
+			outcome = status.Code(err).String()
+		} else if r, ok := reply.(interface{ GetStatus() api.Status }); ok && r.GetStatus() != api.Status_OK {
+			outcome = r.GetStatus().String()
+		}
+		m.observe(cc.Target(), method[strings.LastIndex(method, "/")+1:], outcome, time.Since(start))
+
+		if outcome == "" {
+			switch r := reply.(type) {
+			case *api.ReadResponse:
+				m.bytesRead.Add(uint64(len(r.Data)))
+			case *api.WriteResponse:
+				m.bytesWritten.Add(uint64(r.Count))
+			}
+		}
+		return err
+	}
+}
+
+// Stats sums the counts over every operation and server
+func (m *Metrics) Stats() ClientStats {
+	stats := ClientStats{
+		BytesRead:    m.bytesRead.Load(),
+		BytesWritten: m.bytesWritten.Load(),
+	}
+	var seconds float64
+	m.mu.Lock()
+	for _, o := range m.ops {
+		stats.Operations += o.count
+		for _, n := range o.errors {
+			stats.Errors += n
+		}
+		seconds += o.sum
+	}
+	m.mu.Unlock()
+	if stats.Operations > 0 {
+		stats.AvgResponseTime = time.Duration(seconds / float64(stats.Operations) * float64(time.Second))
+	}
+	return stats
+}
+
+// Handler serves the metrics in the Prometheus text format, to be mounted
+// at /metrics on the embedding program's HTTP server
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WritePrometheus(w)
+	})
+}
+
+// Publish exports the metrics to expvar under name, and so at /debug/vars
+// on servers using expvar's handler. Like expvar.Publish, it panics if name
+// is already taken.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return m.snapshot() }))
+}
+
+// snapshot returns the metrics as expvar shows them
+func (m *Metrics) snapshot() map[string]any {
+	type opSnapshot struct {
+		Server     string            `json:"server"`
+		Op         string            `json:"op"`
+		Count      uint64            `json:"count"`
+		Retries    uint64            `json:"retries"`
+		Errors     map[string]uint64 `json:"errors,omitempty"`
+		AvgSeconds float64           `json:"avg_seconds"`
+	}
+	var ops []opSnapshot
+	m.eachOp(func(key opKey, o *opMetrics) {
+		s := opSnapshot{Server: key.server, Op: key.op, Count: o.count, Retries: o.retries}
+		if len(o.errors) > 0 {
+			s.Errors = make(map[string]uint64, len(o.errors))
+			for outcome, n := range o.errors {
+				s.Errors[outcome] = n
+			}
+		}
+		if o.count > 0 {
+			s.AvgSeconds = o.sum / float64(o.count)
+		}
+		ops = append(ops, s)
+	})
+	caches := make(map[string]map[string]uint64)
+	m.eachCache(func(name string, c *cacheMetrics) {
+		caches[name] = map[string]uint64{"hits": c.hits.Load(), "misses": c.misses.Load()}
+	})
+	return map[string]any{
+		"operations":    ops,
+		"bytes_read":    m.bytesRead.Load(),
+		"bytes_written": m.bytesWritten.Load(),
+		"caches":        caches,
+	}
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	family := func(name string, kind string, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	family("nfs_client_requests_total", "counter", "RPCs sent, by server and operation.")
+	m.eachOp(func(key opKey, o *opMetrics) {
+		fmt.Fprintf(bw, "nfs_client_requests_total{server=%q,op=%q} %d\n", key.server, key.op, o.count)
+	})
+
+	family("nfs_client_request_errors_total", "counter", "RPCs that failed or were answered with a status other than OK, by server, operation and gRPC code or NFS status.")
+	m.eachOp(func(key opKey, o *opMetrics) {
+		outcomes := make([]string, 0, len(o.errors))
+		for outcome := range o.errors {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(bw, "nfs_client_request_errors_total{server=%q,op=%q,status=%q} %d\n",
+				key.server, key.op, outcome, o.errors[outcome])
+		}
+	})
+
+	family("nfs_client_retries_total", "counter", "RPCs sent again after a failure, by server and operation.")
+	m.eachOp(func(key opKey, o *opMetrics) {
+		fmt.Fprintf(bw, "nfs_client_retries_total{server=%q,op=%q} %d\n", key.server, key.op, o.retries)
+	})
+
+	family("nfs_client_request_duration_seconds", "histogram", "Time from sending an RPC to its response, by server and operation.")
+	m.eachOp(func(key opKey, o *opMetrics) {
+		labels := fmt.Sprintf("server=%q,op=%q", key.server, key.op)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += o.buckets[i]
+			fmt.Fprintf(bw, "nfs_client_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(bw, "nfs_client_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, o.count)
+		fmt.Fprintf(bw, "nfs_client_request_duration_seconds_sum{%s} %g\n", labels, o.sum)
+		fmt.Fprintf(bw, "nfs_client_request_duration_seconds_count{%s} %d\n", labels, o.count)
+	})
+
+	family("nfs_client_read_bytes_total", "counter", "Bytes received by reads.")
+	fmt.Fprintf(bw, "nfs_client_read_bytes_total %d\n", m.bytesRead.Load())
+	family("nfs_client_written_bytes_total", "counter", "Bytes sent by writes the server accepted.")
+	fmt.Fprintf(bw, "nfs_client_written_bytes_total %d\n", m.bytesWritten.Load())
+
+	family("nfs_client_cache_hits_total", "counter", "Lookups answered from a client cache, by cache.")
+	m.eachCache(func(name string, c *cacheMetrics) {
+		fmt.Fprintf(bw, "nfs_client_cache_hits_total{cache=%q} %d\n", name, c.hits.Load())
+	})
+	family("nfs_client_cache_misses_total", "counter", "Lookups a client cache could not answer, by cache.")
+	m.eachCache(func(name string, c *cacheMetrics) {
+		fmt.Fprintf(bw, "nfs_client_cache_misses_total{cache=%q} %d\n", name, c.misses.Load())
+	})
+}
+
+// eachOp calls fn with every operation seen, in server and name order,
+// holding the lock
+func (m *Metrics) eachOp(fn func(key opKey, o *opMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]opKey, 0, len(m.ops))
+	for key := range m.ops {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].server != keys[j].server {
+			return keys[i].server < keys[j].server
+		}
+		return keys[i].op < keys[j].op
+	})
+	for _, key := range keys {
+		fn(key, m.ops[key])
+	}
+}
+
+// eachCache calls fn with every cache looked up, in name order
+func (m *Metrics) eachCache(fn func(name string, c *cacheMetrics)) {
+	m.cacheMu.Lock()
+	names := make([]string, 0, len(m.caches))
+	for name := range m.caches {
+		names = append(names, name)
+	}
+	caches := make([]*cacheMetrics, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		caches[i] = m.caches[name]
+	}
+	m.cacheMu.Unlock()
+	for i, name := range names {
+		fn(name, caches[i])
+	}
+}
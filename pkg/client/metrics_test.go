@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// metricsRuns numbers TestMetrics's runs: expvar names cannot be published
+// twice, and -count reruns the test in the same process
+var metricsRuns atomic.Int32
+
+func TestMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	serverConfig := server.DefaultConfig()
+	serverConfig.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(serverConfig, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go nfsServer.Serve(lis)
+
+	metrics := NewMetrics()
+	config := DefaultConfig()
+	config.ServerAddress = lis.Addr().String()
+	config.Timeout = 5 * time.Second
+	config.DiskCacheDir = t.TempDir()
+	config.Metrics = metrics
+	nfsClient, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer nfsClient.Close()
+	ctx := WithCredentials(context.Background(), 0, 0)
+
+	handle, err := nfsClient.LookupPath(ctx, "/file.txt")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := nfsClient.Read(ctx, handle, 0, 11); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	if _, err := nfsClient.Write(ctx, handle, 11, []byte("!!!"), 2); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := nfsClient.LookupPath(ctx, "/missing"); err == nil {
+		t.Fatal("LookupPath of a missing file succeeded")
+	}
+
+	// The second read is served from the disk cache
+	stats := nfsClient.(StatisticsClient).GetStatistics()
+	if stats.Operations == 0 || stats.Errors != 1 || stats.BytesRead != 11 || stats.BytesWritten != 3 || stats.AvgResponseTime <= 0 {
+		t.Errorf("GetStatistics = %+v, want 1 error, 11 bytes read and 3 written", stats)
+	}
+
+	var text strings.Builder
+	metrics.WritePrometheus(&text)
+	target := lis.Addr().String()
+	for _, want := range []string{
+		`nfs_client_requests_total{server="` + target + `",op="Read"} 1`,
+		`nfs_client_request_errors_total{server="` + target + `",op="Lookup",status="ERR_NOENT"} 1`,
+		`nfs_client_request_duration_seconds_count{server="` + target + `",op="Write"} 1`,
+		`nfs_client_read_bytes_total 11`,
+		`nfs_client_written_bytes_total 3`,
+		`nfs_client_cache_hits_total{cache="disk"} 1`,
+		`nfs_client_cache_misses_total{cache="disk"} 1`,
+	} {
+		if !strings.Contains(text.String(), want+"\n") {
+			t.Errorf("Metrics lack %q:\n%s", want, text.String())
+		}
+	}
+
+	// The same counts are published to expvar
+	name := fmt.Sprintf("nfs_client_test_%d", metricsRuns.Add(1))
+	metrics.Publish(name)
+	var published struct {
+		BytesRead uint64                       `json:"bytes_read"`
+		Caches    map[string]map[string]uint64 `json:"caches"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &published); err != nil {
+		t.Fatalf("Published metrics are not JSON: %v", err)
+	}
+	if published.BytesRead != 11 || published.Caches[CacheDisk]["hits"] != 1 {
+		t.Errorf("Published %+v, want 11 bytes read and 1 disk cache hit", published)
+	}
+}
@@ -9,6 +9,9 @@ import (
     "time"
 	"fmt"
 	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
 
     "github.com/example/nfsserver/pkg/api"
     "google.golang.org/grpc"
@@ -36,7 +39,7 @@ func setupMockServer(t *testing.T) (*bufconn.Listener, *mockNFSService, *Client)
     
     go func() {
         if err := server.Serve(listener); err != nil {
-            t.Fatalf("Server exited with error: %v", err)
+            t.Errorf("Server exited with error: %v", err)
         }
     }()
     
@@ -713,4 +716,319 @@ func TestCreate(t *testing.T) {
             }
         })
     }
-}
\ No newline at end of file
+}
+func TestRemove(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    if _, _, err := c.Create(ctx, rootHandle, "doomed.txt", nil, api.CreateMode_GUARDED); err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    
+    if err := c.Remove(ctx, rootHandle, "doomed.txt"); err != nil {
+        t.Fatalf("Remove failed: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "doomed.txt")); !os.IsNotExist(err) {
+        t.Errorf("File still exists after Remove: %v", err)
+    }
+    
+    // Removing it again reports that it no longer exists
+    if err := c.Remove(ctx, rootHandle, "doomed.txt"); !errors.Is(err, ErrNotExist) {
+        t.Errorf("Second Remove error = %v, want ErrNotExist", err)
+    }
+}
+
+func TestRmdir(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    dirHandle, _, err := c.Mkdir(ctx, rootHandle, "doomed", &api.FileAttributes{Mode: 0755})
+    if err != nil {
+        t.Fatalf("Mkdir failed: %v", err)
+    }
+    if _, _, err := c.Create(ctx, dirHandle, "inside.txt", nil, api.CreateMode_GUARDED); err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    
+    // A directory with entries is refused
+    if err := c.Rmdir(ctx, rootHandle, "doomed"); !errors.Is(err, ErrNotEmpty) {
+        t.Fatalf("Rmdir of non-empty directory error = %v, want ErrNotEmpty", err)
+    }
+    
+    if err := c.Remove(ctx, dirHandle, "inside.txt"); err != nil {
+        t.Fatalf("Remove failed: %v", err)
+    }
+    if err := c.Rmdir(ctx, rootHandle, "doomed"); err != nil {
+        t.Fatalf("Rmdir failed: %v", err)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, "doomed")); !os.IsNotExist(err) {
+        t.Errorf("Directory still exists after Rmdir: %v", err)
+    }
+}
+
+func TestReadDirPlus(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+
+    // More entries than fit in one page
+    const files = readDirPlusPage + 10
+    for i := 0; i < files; i++ {
+        if err := os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("f%04d", i)), []byte("x"), 0644); err != nil {
+            t.Fatalf("Failed to create test file: %v", err)
+        }
+    }
+
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    entries, err := c.ReadDirPlus(ctx, rootHandle)
+    if err != nil {
+        t.Fatalf("ReadDirPlus failed: %v", err)
+    }
+    if len(entries) != files+2 { // plus "." and ".."
+        t.Fatalf("ReadDirPlus returned %d entries, want %d", len(entries), files+2)
+    }
+
+    // Each entry carries what a Lookup would have returned
+    for _, entry := range entries[2:] {
+        if entry.Attributes.GetSize() != 1 {
+            t.Fatalf("Entry %s has size %d, want 1", entry.Name, entry.Attributes.GetSize())
+        }
+        attrs, err := c.GetAttr(ctx, entry.FileHandle)
+        if err != nil || attrs.Fileid != entry.Attributes.Fileid {
+            t.Fatalf("Handle of %s does not resolve to the entry: %v, %v", entry.Name, attrs, err)
+        }
+    }
+}
+
+func TestChmodChown(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    handle, attrs, err := c.Create(ctx, rootHandle, "tool", nil, api.CreateMode_GUARDED)
+    if err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    
+    attrs, err = c.Chmod(ctx, handle, 04755)
+    if err != nil {
+        t.Fatalf("Chmod failed: %v", err)
+    }
+    if attrs.Mode != 04755 {
+        t.Errorf("Mode after Chmod = %o, want 4755", attrs.Mode)
+    }
+    
+    // -1 keeps the owner
+    if os.Getuid() == 0 {
+        owner := attrs.Uid
+        attrs, err = c.Chown(ctx, handle, -1, 4242)
+        if err != nil {
+            t.Fatalf("Chown failed: %v", err)
+        }
+        if attrs.Uid != owner || attrs.Gid != 4242 {
+            t.Errorf("Owner after Chown = %d:%d, want %d:4242", attrs.Uid, attrs.Gid, owner)
+        }
+        if _, gid := fileOwner(t, filepath.Join(tempDir, "tool")); gid != 4242 {
+            t.Errorf("Group on disk = %d, want 4242", gid)
+        }
+    }
+    
+    // A change guarded by a stale ctime is refused
+    stale := time.Unix(1, 0)
+    mode := uint32(0700)
+    _, err = c.SetAttr(ctx, handle, AttrChanges{Mode: &mode, GuardCtime: &stale})
+    if !errors.Is(err, ErrNotSync) {
+        t.Errorf("Guarded SetAttr error = %v, want ErrNotSync", err)
+    }
+    ctime := time.Unix(attrs.Ctime.Seconds, int64(attrs.Ctime.Nano))
+    attrs, err = c.SetAttr(ctx, handle, AttrChanges{Mode: &mode, GuardCtime: &ctime})
+    if err != nil {
+        t.Fatalf("Guarded SetAttr with current ctime failed: %v", err)
+    }
+    if attrs.Mode != mode {
+        t.Errorf("Mode after guarded SetAttr = %o, want %o", attrs.Mode, mode)
+    }
+}
+
+func TestSymlinkReadlink(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    if err := os.WriteFile(filepath.Join(tempDir, "target.txt"), []byte("data"), 0644); err != nil {
+        t.Fatalf("Failed to create test file: %v", err)
+    }
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    
+    handle, attrs, err := c.Symlink(ctx, rootHandle, "link", "target.txt")
+    if err != nil {
+        t.Fatalf("Symlink failed: %v", err)
+    }
+    if attrs.Type != api.FileType_SYMLINK {
+        t.Errorf("Symlink returned type %v, want SYMLINK", attrs.Type)
+    }
+    if target, err := os.Readlink(filepath.Join(tempDir, "link")); err != nil || target != "target.txt" {
+        t.Errorf("Link on disk points at %q, %v", target, err)
+    }
+    
+    // Lookup finds the link itself rather than its target
+    lookedUp, lookupAttrs, err := c.Lookup(ctx, rootHandle, "link")
+    if err != nil || lookupAttrs.Type != api.FileType_SYMLINK {
+        t.Fatalf("Lookup returned %v, %v; want a SYMLINK", lookupAttrs, err)
+    }
+    for _, h := range [][]byte{handle, lookedUp} {
+        if target, err := c.Readlink(ctx, h); err != nil || target != "target.txt" {
+            t.Errorf("Readlink returned %q, %v; want target.txt", target, err)
+        }
+    }
+    
+    // Targets outside the export are refused
+    var nfsErr *NFSError
+    if _, _, err := c.Symlink(ctx, rootHandle, "escape", "../../etc/passwd"); !errors.As(err, &nfsErr) || nfsErr.Status != api.Status_ERR_INVAL {
+        t.Errorf("Symlink out of the export returned %v, want ERR_INVAL", err)
+    }
+    if _, _, err := c.Symlink(ctx, rootHandle, "link", "target.txt"); !errors.Is(err, ErrExist) {
+        t.Errorf("Symlink over an existing name returned %v, want ErrExist", err)
+    }
+    
+    fileHandle, _, err := c.Lookup(ctx, rootHandle, "target.txt")
+    if err != nil {
+        t.Fatalf("Lookup failed: %v", err)
+    }
+    if _, err := c.Readlink(ctx, fileHandle); !errors.As(err, &nfsErr) || nfsErr.Status != api.Status_ERR_INVAL {
+        t.Errorf("Readlink of a file returned %v, want ERR_INVAL", err)
+    }
+}
+
+func TestLink(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    fileHandle, _, err := c.Create(ctx, rootHandle, "original", &api.FileAttributes{Mode: 0644}, api.CreateMode_GUARDED)
+    if err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    
+    attrs, err := c.Link(ctx, fileHandle, rootHandle, "alias")
+    if err != nil {
+        t.Fatalf("Link failed: %v", err)
+    }
+    if attrs.Nlink != 2 {
+        t.Errorf("Link returned nlink %d, want 2", attrs.Nlink)
+    }
+    
+    // Data written through one name is read through the other
+    if _, err := c.Write(ctx, fileHandle, 0, []byte("shared"), 2); err != nil {
+        t.Fatalf("Write failed: %v", err)
+    }
+    if data, err := os.ReadFile(filepath.Join(tempDir, "alias")); err != nil || string(data) != "shared" {
+        t.Errorf("Link reads %q, %v; want shared", data, err)
+    }
+    
+    if _, err := c.Link(ctx, fileHandle, rootHandle, "alias"); !errors.Is(err, ErrExist) {
+        t.Errorf("Link over an existing name returned %v, want ErrExist", err)
+    }
+    if _, err := c.Link(ctx, rootHandle, rootHandle, "rootlink"); !errors.Is(err, ErrIsDir) {
+        t.Errorf("Link of a directory returned %v, want ErrIsDir", err)
+    }
+}
+
+func TestCommit(t *testing.T) {
+    c, _, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    fileHandle, _, err := c.Create(ctx, rootHandle, "unstable", &api.FileAttributes{Mode: 0644}, api.CreateMode_GUARDED)
+    if err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    if _, err := c.Write(ctx, fileHandle, 0, []byte("not yet durable"), 0); err != nil { // 0 = UNSTABLE
+        t.Fatalf("Write failed: %v", err)
+    }
+    
+    first, err := c.Commit(ctx, fileHandle, 0, 0)
+    if err != nil || first == 0 {
+        t.Fatalf("Commit returned verifier %d, %v", first, err)
+    }
+    if second, err := c.Commit(ctx, fileHandle, 4, 4); err != nil || second != first {
+        t.Errorf("Second Commit returned verifier %d, %v; want %d", second, err, first)
+    }
+    if _, err := c.Commit(ctx, rootHandle, 0, 0); !errors.Is(err, ErrIsDir) {
+        t.Errorf("Commit of a directory returned %v, want ErrIsDir", err)
+    }
+}
+
+func TestCheckAccess(t *testing.T) {
+    c, _, ctx := newLocalClient(t)
+    
+    rootHandle, err := c.GetRootFileHandle(ctx)
+    if err != nil {
+        t.Fatalf("GetRootFileHandle failed: %v", err)
+    }
+    fileHandle, _, err := c.Create(ctx, rootHandle, "readonly", &api.FileAttributes{Mode: 0444}, api.CreateMode_GUARDED)
+    if err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+    
+    rights := uint32(api.AccessRight_ACCESS_READ | api.AccessRight_ACCESS_MODIFY | api.AccessRight_ACCESS_LOOKUP)
+    granted, err := c.CheckAccess(ctx, fileHandle, rights)
+    if err != nil {
+        t.Fatalf("CheckAccess failed: %v", err)
+    }
+    if granted != uint32(api.AccessRight_ACCESS_READ) {
+        t.Errorf("CheckAccess granted %#x on a read-only file, want READ only", granted)
+    }
+    
+    granted, err = c.CheckAccess(ctx, rootHandle, rights)
+    if err != nil || granted != rights {
+        t.Errorf("CheckAccess on the root granted %#x, %v; want %#x", granted, err, rights)
+    }
+}
+
+func TestReadDirPages(t *testing.T) {
+    c, tempDir, ctx := newLocalClient(t)
+    
+    // More entries than one ReadDir RPC asks for
+    dir := filepath.Join(tempDir, "big")
+    if err := os.Mkdir(dir, 0755); err != nil {
+        t.Fatalf("Failed to create directory: %v", err)
+    }
+    for i := 0; i < 1500; i++ {
+        if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d", i)), nil, 0644); err != nil {
+            t.Fatalf("Failed to create file: %v", err)
+        }
+    }
+    
+    dirHandle, err := c.LookupPath(ctx, "/big")
+    if err != nil {
+        t.Fatalf("LookupPath failed: %v", err)
+    }
+    entries, err := c.ReadDir(ctx, dirHandle)
+    if err != nil {
+        t.Fatalf("ReadDir failed: %v", err)
+    }
+    seen := make(map[string]bool)
+    for _, entry := range entries {
+        seen[entry.Name] = true
+    }
+    for i := 0; i < 1500; i++ {
+        if name := fmt.Sprintf("f%04d", i); !seen[name] {
+            t.Fatalf("ReadDir is missing %s of 1500 entries (got %d)", name, len(entries))
+        }
+    }
+}
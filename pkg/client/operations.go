@@ -9,6 +9,8 @@ import (
 	"log"
 
 	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Ensure Client implements NFSClient interface
@@ -19,15 +21,11 @@ func (c *Client) GetAttr(ctx context.Context, fileHandle []byte) (*api.FileAttri
     // Create request
     req := &api.GetAttrRequest{
         FileHandle: fileHandle,
-        Credentials: &api.Credentials{
-            Uid: 1000,
-            Gid: 1000,
-            Groups: []uint32{1000},
-        },
+        Credentials: credentialsFromContext(ctx),
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -57,15 +55,11 @@ func (c *Client) Lookup(ctx context.Context, dirHandle []byte, name string) ([]b
     req := &api.LookupRequest{
         DirectoryHandle: dirHandle,
         Name: name,
-        Credentials: &api.Credentials{
-            Uid: 1000,
-            Gid: 1000,
-            Groups: []uint32{1000},
-        },
+        Credentials: credentialsFromContext(ctx),
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -91,6 +85,8 @@ func (c *Client) Lookup(ctx context.Context, dirHandle []byte, name string) ([]b
         c.handleCache.StorePathHandle(name, resp.FileHandle)
     }
     
+    c.rememberChild(dirHandle, name, resp.FileHandle)
+    
     return resp.FileHandle, resp.Attributes, nil
 }
 
@@ -103,20 +99,26 @@ func (c *Client) Read(ctx context.Context, fileHandle []byte, offset int64, coun
         count = 10 * 1024 * 1024 // Cap at 10MB for safety
     }
     
+    if c.diskCache != nil {
+        return c.readCached(ctx, fileHandle, offset, count)
+    }
+    data, eof, _, err := c.readRemote(ctx, fileHandle, offset, count)
+    return data, eof, err
+}
+
+// readRemote reads data from a file on the server, returning the file's
+// attributes after the read if the server sent them
+func (c *Client) readRemote(ctx context.Context, fileHandle []byte, offset int64, count int) ([]byte, bool, *api.FileAttributes, error) {
     // Create request
     req := &api.ReadRequest{
         FileHandle: fileHandle,
-        Credentials: &api.Credentials{
-            Uid: 1000,
-            Gid: 1000,
-            Groups: []uint32{1000},
-        },
+        Credentials: credentialsFromContext(ctx),
         Offset: uint64(offset),
         Count: uint32(count),
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -129,15 +131,20 @@ func (c *Client) Read(ctx context.Context, fileHandle []byte, offset int64, coun
     })
     
     if err != nil {
-        return nil, false, fmt.Errorf("Read RPC failed: %w", err)
+        return nil, false, nil, fmt.Errorf("Read RPC failed: %w", err)
     }
     
     // Check the status
+    if resp.Status == api.Status_ERR_IO && len(c.replicas) > 0 {
+        // The primary could not read its copy; try a replica instead
+        data, eof, err := c.readFromReplica(ctx, fileHandle, offset, count, StatusToError("Read", resp.Status))
+        return data, eof, nil, err
+    }
     if resp.Status != api.Status_OK {
-        return nil, false, StatusToError("Read", resp.Status)
+        return nil, false, nil, StatusToError("Read", resp.Status)
     }
     
-    return resp.Data, resp.Eof, nil
+    return resp.Data, resp.Eof, resp.Attributes, nil
 }
 
 // Write writes data to a file
@@ -147,21 +154,25 @@ func (c *Client) Write(ctx context.Context, fileHandle []byte, offset int64, dat
         stability = 0 // Default to UNSTABLE if invalid
     }
     
+    // Cached blocks are of the version before this write
+    c.forgetVersion(fileHandle)
+    
     // Create request
     req := &api.WriteRequest{
         FileHandle: fileHandle,
-        Credentials: &api.Credentials{
+        Credentials: credentialsOrDefault(ctx, &api.Credentials{
             Uid: 0,
             Gid: 0,
             Groups: []uint32{0},
-        },
+        }),
         Offset: uint64(offset),
         Data: data,
         Stability: uint32(stability),
+        FenceEpoch: fenceFromContext(ctx),
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -188,10 +199,105 @@ func (c *Client) Write(ctx context.Context, fileHandle []byte, offset int64, dat
             stability, resp.Stability)
     }
     
+    // Remember to commit the data on Close
+    if resp.Stability == 0 {
+        c.pending.wrote(fileHandle, resp.Verifier, req.Credentials)
+    }
+    
     return int(resp.Count), nil
 }
 
-// ReadDir reads the contents of a directory
+// Append writes data at the current end of the file. The server picks the
+// offset under a per-file lock, so concurrent appenders never overwrite each
+// other. Appends are not retried, since a retry after a lost response would
+// append the data twice. Returns the offset used and the bytes written
+func (c *Client) Append(ctx context.Context, fileHandle []byte, data []byte, stability int) (int64, int, error) {
+    // Validate stability level
+    if stability < 0 || stability > 2 {
+        stability = 0 // Default to UNSTABLE if invalid
+    }
+    
+    // Cached blocks are of the version before this write
+    c.forgetVersion(fileHandle)
+    
+    // Create request
+    req := &api.WriteRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Data:        data,
+        Stability:   uint32(stability),
+        Append:      true,
+        FenceEpoch:  fenceFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    resp, err := c.nfsClient.Write(callCtx, req)
+    if err != nil {
+        return 0, 0, fmt.Errorf("Write RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return 0, 0, StatusToError("Write", resp.Status)
+    }
+    if resp.Stability == 0 {
+        c.pending.wrote(fileHandle, resp.Verifier, req.Credentials)
+    }
+    
+    return int64(resp.Offset), int(resp.Count), nil
+}
+
+// Commit asks the server to flush count bytes of a file starting at offset
+// (count 0 = through the end of the file) to stable storage. It returns the
+// server's write verifier; if it differs from the one the UNSTABLE writes
+// were acknowledged under, the server restarted and they may have been lost.
+func (c *Client) Commit(ctx context.Context, fileHandle []byte, offset uint64, count uint32) (uint64, error) {
+    // Create request
+    req := &api.CommitRequest{
+        FileHandle:  fileHandle,
+        Offset:      offset,
+        Count:       count,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.CommitResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Commit", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Commit(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return 0, NewNFSError("Commit", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return 0, fmt.Errorf("Commit RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return 0, StatusToError("Commit", resp.Status)
+    }
+    c.pending.committed(fileHandle, resp.Verifier)
+    
+    return resp.Verifier, nil
+}
+
+// readDirRestarts is how many times a listing is started over when the
+// directory changes under it before ERR_BAD_COOKIE is given up on
+const readDirRestarts = 3
+
+// ReadDir reads the contents of a directory, one RPC per 1000 entries or
+// per response the server cut short to keep it within its size limit
 func (c *Client) ReadDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry, error) {
 	// Create the request
 	req := &api.ReadDirRequest{
@@ -203,24 +309,105 @@ func (c *Client) ReadDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry
 		Cookie: 0,
 		CookieVerifier: 0,
 		Count: 1000, // Request up to 1000 entries
+		Sorted: c.config.SortedReadDir,
 	}
 	
-	// Create a context with timeout
-	callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
-	
-	// Call the RPC method
-	resp, err := c.nfsClient.ReadDir(callCtx, req)
-	if err != nil {
-		return nil, fmt.Errorf("ReadDir RPC failed: %w", err)
-	}
-	
-	// Check the status
-	if resp.Status != api.Status_OK {
-		return nil, StatusToError("ReadDir", resp.Status)
+	var entries []*api.DirEntry
+	restarts := 0
+	for {
+		// Each page gets the full timeout
+		callCtx, cancel := c.operationContext(ctx)
+		
+		// Call the RPC method
+		resp, err := c.nfsClient.ReadDir(callCtx, req)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("ReadDir RPC failed: %w", err)
+		}
+		
+		// A directory that changed since the first page is listed again
+		if resp.Status == api.Status_ERR_BAD_COOKIE && req.Cookie != 0 && restarts < readDirRestarts {
+			restarts++
+			entries = nil
+			req.Cookie, req.CookieVerifier = 0, 0
+			continue
+		}
+		
+		// Check the status
+		if resp.Status != api.Status_OK {
+			return nil, StatusToError("ReadDir", resp.Status)
+		}
+		
+		entries = append(entries, resp.Entries...)
+		if resp.Eof || len(resp.Entries) == 0 {
+			return entries, nil
+		}
+		req.Cookie = resp.Entries[len(resp.Entries)-1].Cookie
+		req.CookieVerifier = resp.CookieVerifier
 	}
-	
-	return resp.Entries, nil
+}
+
+// readDirPlusPage is the number of entries asked for per ReadDirPlus RPC
+const readDirPlusPage = 1000
+
+// ReadDirPlus reads all entries of a directory together with their handles
+// and attributes, one RPC per readDirPlusPage entries
+func (c *Client) ReadDirPlus(ctx context.Context, dirHandle []byte) ([]*api.DirEntryPlus, error) {
+    req := &api.ReadDirPlusRequest{
+        DirectoryHandle: dirHandle,
+        Credentials:     credentialsFromContext(ctx),
+        Count:           readDirPlusPage,
+        Sorted:          c.config.SortedReadDir,
+    }
+    
+    var entries []*api.DirEntryPlus
+    restarts := 0
+    for {
+        // Each page gets the full timeout
+        callCtx, cancel := c.operationContext(ctx)
+        
+        var resp *api.ReadDirPlusResponse
+        var err error
+        
+        err = c.callWithRetry(callCtx, "ReadDirPlus", func(retryCtx context.Context) error {
+            resp, err = c.nfsClient.ReadDirPlus(retryCtx, req)
+            return err
+        })
+        cancel()
+        
+        if status.Code(err) == codes.Unimplemented {
+            return nil, NewNFSError("ReadDirPlus", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("ReadDirPlus RPC failed: %w", err)
+        }
+        
+        // As in ReadDir
+        if resp.Status == api.Status_ERR_BAD_COOKIE && req.Cookie != 0 && restarts < readDirRestarts {
+            restarts++
+            entries = nil
+            req.Cookie, req.CookieVerifier = 0, 0
+            continue
+        }
+        
+        // Check the status
+        if resp.Status != api.Status_OK {
+            return nil, StatusToError("ReadDirPlus", resp.Status)
+        }
+        
+        for _, entry := range resp.Entries {
+            if entry.Name != "." && entry.Name != ".." {
+                c.rememberChild(dirHandle, entry.Name, entry.FileHandle)
+            }
+        }
+        entries = append(entries, resp.Entries...)
+        
+        if resp.Eof || len(resp.Entries) == 0 {
+            return entries, nil
+        }
+        req.Cookie = resp.Entries[len(resp.Entries)-1].Cookie
+        req.CookieVerifier = resp.CookieVerifier
+    }
 }
 
 // Create creates a new file in the specified directory
@@ -236,18 +423,14 @@ func (c *Client) Create(ctx context.Context, dirHandle []byte, name string, attr
     req := &api.CreateRequest{
         DirectoryHandle: dirHandle,
         Name:            name,
-        Credentials: &api.Credentials{
-            Uid: 1000,
-            Gid: 1000,
-            Groups: []uint32{1000},
-        },
+        Credentials:     credentialsFromContext(ctx),
         Attributes: attrs,
         Mode:       mode,
         Verifier:   uint64(time.Now().UnixNano()), // Use current time as verifier
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -293,7 +476,7 @@ func (c *Client) Mkdir(ctx context.Context, dirHandle []byte, name string, attrs
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
@@ -317,58 +500,1128 @@ func (c *Client) Mkdir(ctx context.Context, dirHandle []byte, name string, attrs
     return resp.DirectoryHandle, resp.Attributes, nil
 }
 
-// Remove removes a file
+// Remove removes a file, or any other entry that is not a directory
 func (c *Client) Remove(ctx context.Context, dirHandle []byte, name string) error {
-	// TODO: Implement actual Remove operation
-	return fmt.Errorf("not implemented")
+    // Create request
+    req := &api.RemoveRequest{
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.RemoveResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Remove", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Remove(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return NewNFSError("Remove", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return fmt.Errorf("Remove RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return StatusToError("Remove", resp.Status)
+    }
+    
+    c.forgetChild(dirHandle, name)
+    return nil
 }
 
-// Rmdir removes a directory
+// Rmdir removes an empty directory. A directory that still has entries
+// fails with ErrNotEmpty.
 func (c *Client) Rmdir(ctx context.Context, dirHandle []byte, name string) error {
-	// TODO: Implement actual Rmdir operation
-	return fmt.Errorf("not implemented")
+    // Create request
+    req := &api.RmdirRequest{
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.RmdirResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Rmdir", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Rmdir(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return NewNFSError("Rmdir", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return fmt.Errorf("Rmdir RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return StatusToError("Rmdir", resp.Status)
+    }
+    
+    c.forgetChild(dirHandle, name)
+    return nil
+}
+
+// Symlink creates a symbolic link called name in dirHandle pointing at
+// target. The server only accepts relative targets that stay inside the
+// export; others fail with status ERR_INVAL.
+func (c *Client) Symlink(ctx context.Context, dirHandle []byte, name string, target string) ([]byte, *api.FileAttributes, error) {
+    // Create request
+    req := &api.SymlinkRequest{
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Target:          target,
+        Credentials:     credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.SymlinkResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Symlink", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Symlink(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return nil, nil, NewNFSError("Symlink", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, nil, fmt.Errorf("Symlink RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, nil, StatusToError("Symlink", resp.Status)
+    }
+    
+    return resp.FileHandle, resp.Attributes, nil
+}
+
+// Link creates a hard link called name in dirHandle to the file fileHandle
+// and returns the file's attributes with the new link count
+func (c *Client) Link(ctx context.Context, fileHandle []byte, dirHandle []byte, name string) (*api.FileAttributes, error) {
+    // Create request
+    req := &api.LinkRequest{
+        TargetHandle:    fileHandle,
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.LinkResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Link", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Link(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return nil, NewNFSError("Link", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("Link RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("Link", resp.Status)
+    }
+    
+    return resp.Attributes, nil
+}
+
+// Readlink returns the target of a symbolic link
+func (c *Client) Readlink(ctx context.Context, fileHandle []byte) (string, error) {
+    // Create request
+    req := &api.ReadlinkRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.ReadlinkResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Readlink", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Readlink(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return "", NewNFSError("Readlink", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return "", fmt.Errorf("Readlink RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return "", StatusToError("Readlink", resp.Status)
+    }
+    
+    return resp.Target, nil
 }
 
+// RenameFlags modify RenameWithFlags like the flags of renameat2(2)
+type RenameFlags uint32
+
+const (
+    // RenameNoReplace fails with ERR_EXIST instead of replacing the destination
+    RenameNoReplace RenameFlags = 1 << iota
+    // RenameExchange atomically swaps source and destination, which must
+    // both exist
+    RenameExchange
+)
+
 // Rename renames a file or directory
 func (c *Client) Rename(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string) error {
-	// TODO: Implement actual Rename operation
-	return fmt.Errorf("not implemented")
+    return c.RenameWithFlags(ctx, fromDirHandle, fromName, toDirHandle, toName, 0)
 }
 
-// GetRootFileHandle retrieves the root directory file handle from the server
-func (c *Client) GetRootFileHandle(ctx context.Context) ([]byte, error) {
+// RenameWithFlags renames a file or directory like renameat2(2): with
+// RenameNoReplace an existing destination fails with ERR_EXIST, and with
+// RenameExchange source and destination swap places
+func (c *Client) RenameWithFlags(ctx context.Context, fromDirHandle []byte, fromName string, toDirHandle []byte, toName string, flags RenameFlags) error {
     // Create request
-    req := &api.GetRootHandleRequest{
-        Credentials: &api.Credentials{
-            Uid: 1000,
-            Gid: 1000,
-            Groups: []uint32{1000},
-        },
+    req := &api.RenameRequest{
+        FromDirectoryHandle: fromDirHandle,
+        FromName:            fromName,
+        ToDirectoryHandle:   toDirHandle,
+        ToName:              toName,
+        Credentials:         credentialsFromContext(ctx),
+        NoReplace:           flags&RenameNoReplace != 0,
+        Exchange:            flags&RenameExchange != 0,
     }
     
     // Create a context with timeout
-    callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+    callCtx, cancel := c.operationContext(ctx)
     defer cancel()
     
     // Call the RPC method with retry logic
-    var resp *api.GetRootHandleResponse
+    var resp *api.RenameResponse
     var err error
     
-    err = c.callWithRetry(callCtx, "GetRootFileHandle", func(retryCtx context.Context) error {
-        resp, err = c.nfsClient.GetRootHandle(retryCtx, req)
+    err = c.callWithRetry(callCtx, "Rename", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Rename(retryCtx, req)
         return err
     })
     
     if err != nil {
-        return nil, fmt.Errorf("Fail to get root: %w", err)
+        return fmt.Errorf("Rename RPC failed: %w", err)
     }
     
     // Check the status
     if resp.Status != api.Status_OK {
-        return nil, StatusToError("GetRootFileHandle", resp.Status)
+        return StatusToError("Rename", resp.Status)
     }
     
-    return resp.FileHandle, nil
+    c.renameChild(fromDirHandle, fromName, toDirHandle, toName, flags&RenameExchange != 0)
+    return nil
+}
+
+// Access checks whether the caller may access a file
+func (c *Client) Access(ctx context.Context, fileHandle []byte, mode uint32) error {
+    // Create request
+    req := &api.AccessRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Mode:        mode,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.AccessResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Access", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Access(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return fmt.Errorf("Access RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return StatusToError("Access", resp.Status)
+    }
+    
+    return nil
+}
+
+// CheckAccess asks which of the requested rights, a mask of api.AccessRight,
+// the caller holds on a file. Unlike Access it does not fail when a right is
+// missing; it returns the granted subset.
+func (c *Client) CheckAccess(ctx context.Context, fileHandle []byte, rights uint32) (uint32, error) {
+    // Create request
+    req := &api.AccessRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Access:      rights,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.AccessResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Access", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Access(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return 0, fmt.Errorf("Access RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return 0, StatusToError("Access", resp.Status)
+    }
+    
+    return resp.Granted & rights, nil
+}
+
+// FsStat retrieves capacity statistics for the file system containing fileHandle
+func (c *Client) FsStat(ctx context.Context, fileHandle []byte) (*api.FsStatResponse, error) {
+    // Create request
+    req := &api.FsStatRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.FsStatResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "FsStat", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.FsStat(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return nil, NewNFSError("FsStat", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("FsStat RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("FsStat", resp.Status)
+    }
+    
+    return resp, nil
+}
+
+// GetQuota retrieves the caller's quota usage on the export containing fileHandle
+func (c *Client) GetQuota(ctx context.Context, fileHandle []byte) (*api.GetQuotaResponse, error) {
+    // Create request
+    req := &api.GetQuotaRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.GetQuotaResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "GetQuota", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.GetQuota(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, fmt.Errorf("GetQuota RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("GetQuota", resp.Status)
+    }
+    
+    return resp, nil
+}
+
+// Checksum asks the server to hash length bytes of a file starting at offset
+// (length 0 = to end of file). Returns the digest and the number of bytes hashed
+func (c *Client) Checksum(ctx context.Context, fileHandle []byte, algorithm api.ChecksumAlgorithm, offset, length uint64) ([]byte, uint64, error) {
+    // Create request
+    req := &api.ChecksumRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Algorithm:   algorithm,
+        Offset:      offset,
+        Length:      length,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.ChecksumResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "Checksum", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Checksum(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, 0, fmt.Errorf("Checksum RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, 0, StatusToError("Checksum", resp.Status)
+    }
+    
+    return resp.Checksum, resp.BytesHashed, nil
+}
+
+// Allocate reserves space for length bytes of a file starting at offset,
+// extending it if needed. Returns the file's new attributes
+func (c *Client) Allocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error) {
+    c.forgetVersion(fileHandle)
+    
+    req := &api.AllocateRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Offset:      offset,
+        Length:      length,
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    var resp *api.AllocateResponse
+    var err error
+    err = c.callWithRetry(callCtx, "Allocate", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Allocate(retryCtx, req)
+        return err
+    })
+    if err != nil {
+        return nil, fmt.Errorf("Allocate RPC failed: %w", err)
+    }
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("Allocate", resp.Status)
+    }
+    return resp.Attributes, nil
+}
+
+// Deallocate punches a hole of length bytes in a file starting at offset.
+// Returns the file's new attributes
+func (c *Client) Deallocate(ctx context.Context, fileHandle []byte, offset, length uint64) (*api.FileAttributes, error) {
+    c.forgetVersion(fileHandle)
+    
+    req := &api.DeallocateRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Offset:      offset,
+        Length:      length,
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    var resp *api.DeallocateResponse
+    var err error
+    err = c.callWithRetry(callCtx, "Deallocate", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Deallocate(retryCtx, req)
+        return err
+    })
+    if err != nil {
+        return nil, fmt.Errorf("Deallocate RPC failed: %w", err)
+    }
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("Deallocate", resp.Status)
+    }
+    return resp.Attributes, nil
+}
+
+// Seek returns the offset of the first data or hole at or after offset,
+// and whether the range starting there runs to the end of the file
+func (c *Client) Seek(ctx context.Context, fileHandle []byte, offset uint64, what api.SeekContent) (uint64, bool, error) {
+    req := &api.SeekRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Offset:      offset,
+        What:        what,
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    var resp *api.SeekResponse
+    var err error
+    err = c.callWithRetry(callCtx, "Seek", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.Seek(retryCtx, req)
+        return err
+    })
+    if err != nil {
+        return 0, false, fmt.Errorf("Seek RPC failed: %w", err)
+    }
+    if resp.Status != api.Status_OK {
+        return 0, false, StatusToError("Seek", resp.Status)
+    }
+    return resp.Offset, resp.Eof, nil
+}
+
+// CopyRange asks the server to copy length bytes of srcHandle from srcOffset
+// into dstHandle at dstOffset (length 0 = to end of file). Returns the
+// number of bytes copied, which may be fewer, and whether the end of the
+// source was reached
+func (c *Client) CopyRange(ctx context.Context, srcHandle, dstHandle []byte, srcOffset, dstOffset, length uint64) (uint64, bool, error) {
+    c.forgetVersion(dstHandle)
+    
+    req := &api.CopyRangeRequest{
+        SourceHandle:      srcHandle,
+        DestinationHandle: dstHandle,
+        Credentials:       credentialsFromContext(ctx),
+        SourceOffset:      srcOffset,
+        DestinationOffset: dstOffset,
+        Length:            length,
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    var resp *api.CopyRangeResponse
+    var err error
+    err = c.callWithRetry(callCtx, "CopyRange", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.CopyRange(retryCtx, req)
+        return err
+    })
+    if err != nil {
+        return 0, false, fmt.Errorf("CopyRange RPC failed: %w", err)
+    }
+    if resp.Status != api.Status_OK {
+        return 0, false, StatusToError("CopyRange", resp.Status)
+    }
+    return resp.Count, resp.Eof, nil
+}
+
+// SnapshotHandle returns the handle of a file as it was in the snapshot
+// called snapshot, and its attributes there
+func (c *Client) SnapshotHandle(ctx context.Context, fileHandle []byte, snapshot string) ([]byte, *api.FileAttributes, error) {
+    req := &api.SnapshotHandleRequest{
+        FileHandle:  fileHandle,
+        Snapshot:    snapshot,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    var resp *api.SnapshotHandleResponse
+    var err error
+    err = c.callWithRetry(callCtx, "SnapshotHandle", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.SnapshotHandle(retryCtx, req)
+        return err
+    })
+    if err != nil {
+        return nil, nil, fmt.Errorf("SnapshotHandle RPC failed: %w", err)
+    }
+    if resp.Status != api.Status_OK {
+        return nil, nil, StatusToError("SnapshotHandle", resp.Status)
+    }
+    return resp.FileHandle, resp.Attributes, nil
+}
+
+// RemoveTree deletes the directory name in dirHandle and everything below it
+// in a single server-side call. progress, if not nil, is called with each
+// progress update. The call is not bounded by the client timeout, since
+// large trees can take a long time; cancel ctx to abort it
+func (c *Client) RemoveTree(ctx context.Context, dirHandle []byte, name string, concurrency int,
+    progress func(*api.RemoveTreeProgress)) error {
+    // Create request
+    req := &api.RemoveTreeRequest{
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+        Concurrency:     uint32(concurrency),
+    }
+    
+    stream, err := c.nfsClient.RemoveTree(ctx, req)
+    if err != nil {
+        return fmt.Errorf("RemoveTree RPC failed: %w", err)
+    }
+    
+    for {
+        update, err := stream.Recv()
+        if err != nil {
+            return fmt.Errorf("RemoveTree RPC failed: %w", err)
+        }
+        
+        if progress != nil {
+            progress(update)
+        }
+        
+        if update.Done {
+            // Some of the tree may be gone even if not all of it is
+            c.forgetChild(dirHandle, name)
+            if update.Status != api.Status_OK {
+                err := StatusToError("RemoveTree", update.Status)
+                if update.FailedPath != "" {
+                    return fmt.Errorf("%s: %w", update.FailedPath, err)
+                }
+                return err
+            }
+            return nil
+        }
+    }
+}
+
+// RemoveTreeInBackground moves the directory name in dirHandle out of the
+// namespace, leaving the server to delete it and everything below it. Only
+// the move is waited for; permissions deeper in the tree are checked as the
+// server deletes it, and a tree it cannot delete completely is put back.
+func (c *Client) RemoveTreeInBackground(ctx context.Context, dirHandle []byte, name string) (uint64, error) {
+    req := &api.RemoveTreeRequest{
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+        Background:      true,
+    }
+    
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    stream, err := c.nfsClient.RemoveTree(callCtx, req)
+    if err != nil {
+        return 0, fmt.Errorf("RemoveTree RPC failed: %w", err)
+    }
+    for {
+        update, err := stream.Recv()
+        if err != nil {
+            return 0, fmt.Errorf("RemoveTree RPC failed: %w", err)
+        }
+        if !update.Done {
+            continue
+        }
+        if update.Status != api.Status_OK {
+            return 0, StatusToError("RemoveTree", update.Status)
+        }
+        c.forgetChild(dirHandle, name)
+        return update.TrashId, nil
+    }
+}
+
+// CreateUnnamed creates a file with no name on behalf of the directory dirHandle.
+// The file can be written through the returned handle and published with LinkIntoPlace.
+func (c *Client) CreateUnnamed(ctx context.Context, dirHandle []byte, attrs *api.FileAttributes) ([]byte, *api.FileAttributes, error) {
+    // Create request
+    req := &api.CreateUnnamedRequest{
+        DirectoryHandle: dirHandle,
+        Credentials:     credentialsFromContext(ctx),
+        Attributes:      attrs,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.CreateUnnamedResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "CreateUnnamed", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.CreateUnnamed(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, nil, fmt.Errorf("CreateUnnamed RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, nil, StatusToError("CreateUnnamed", resp.Status)
+    }
+    
+    return resp.FileHandle, resp.Attributes, nil
+}
+
+// LinkIntoPlace gives the unnamed file fileHandle the name dirHandle/name.
+// Unless replace is set, it fails if the name already exists. The call is not
+// retried, since a retry after a lost reply would find the file already linked.
+func (c *Client) LinkIntoPlace(ctx context.Context, fileHandle []byte, dirHandle []byte, name string, replace bool) (*api.FileAttributes, error) {
+    // Create request
+    req := &api.LinkIntoPlaceRequest{
+        FileHandle:      fileHandle,
+        DirectoryHandle: dirHandle,
+        Name:            name,
+        Credentials:     credentialsFromContext(ctx),
+        Replace:         replace,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    resp, err := c.nfsClient.LinkIntoPlace(callCtx, req)
+    if err != nil {
+        return nil, fmt.Errorf("LinkIntoPlace RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("LinkIntoPlace", resp.Status)
+    }
+    
+    c.forgetChild(dirHandle, name)
+    c.rememberChild(dirHandle, name, fileHandle)
+    return resp.Attributes, nil
+}
+
+// EntryCount returns the number of entries in the directory dirHandle,
+// not counting "." and "..". The server counts them without listing the
+// directory, so this is much cheaper than ReadDir for large directories.
+func (c *Client) EntryCount(ctx context.Context, dirHandle []byte) (uint64, error) {
+    // Create request
+    req := &api.GetAttrRequest{
+        FileHandle:     dirHandle,
+        Credentials:    credentialsFromContext(ctx),
+        WantEntryCount: true,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.GetAttrResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "GetAttr", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.GetAttr(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return 0, fmt.Errorf("GetAttr RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return 0, StatusToError("GetAttr", resp.Status)
+    }
+    if resp.Attributes.GetType() != api.FileType_DIRECTORY {
+        return 0, ErrNotDir
+    }
+    
+    return resp.EntryCount, nil
+}
+
+// SetTimes sets the access and modification times of a file; a nil time
+// leaves that timestamp unchanged. Only the owner may set explicit times.
+func (c *Client) SetTimes(ctx context.Context, fileHandle []byte, atime, mtime *time.Time) (*api.FileAttributes, error) {
+    req := &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    if atime != nil {
+        req.Atime = &api.FileTime{Seconds: atime.Unix(), Nano: int32(atime.Nanosecond())}
+    }
+    if mtime != nil {
+        req.Mtime = &api.FileTime{Seconds: mtime.Unix(), Nano: int32(mtime.Nanosecond())}
+    }
+    
+    return c.setAttr(ctx, req)
+}
+
+// Touch sets the access and modification times of a file to the server's
+// current time. Anyone with write access may do this.
+func (c *Client) Touch(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error) {
+    return c.setAttr(ctx, &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        AtimeNow:    true,
+        MtimeNow:    true,
+    })
+}
+
+// Truncate changes the size of a file, discarding data past size or
+// extending the file with a hole. The caller needs write access.
+func (c *Client) Truncate(ctx context.Context, fileHandle []byte, size uint64) (*api.FileAttributes, error) {
+    return c.setAttr(ctx, &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Size:        &size,
+    })
+}
+
+// AttrChanges lists the attributes SetAttr changes; nil fields and unset
+// flags leave the attribute as it is
+type AttrChanges struct {
+    Mode *uint32
+    Uid  *uint32
+    Gid  *uint32
+    Size *uint64
+    
+    Atime *time.Time
+    Mtime *time.Time
+    
+    // Use the server's current time instead of Atime or Mtime
+    AtimeNow bool
+    MtimeNow bool
+    
+    // If set, the changes are only made if the file's ctime still equals
+    // this time; otherwise SetAttr fails with ErrNotSync
+    GuardCtime *time.Time
+}
+
+// SetAttr changes several attributes of a file in one request, under the
+// rules of chmod(2), chown(2), truncate(2) and utimensat(2)
+func (c *Client) SetAttr(ctx context.Context, fileHandle []byte, changes AttrChanges) (*api.FileAttributes, error) {
+    c.forgetVersion(fileHandle)
+    
+    req := &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Mode:        changes.Mode,
+        Uid:         changes.Uid,
+        Gid:         changes.Gid,
+        Size:        changes.Size,
+        AtimeNow:    changes.AtimeNow,
+        MtimeNow:    changes.MtimeNow,
+    }
+    if changes.Atime != nil {
+        req.Atime = &api.FileTime{Seconds: changes.Atime.Unix(), Nano: int32(changes.Atime.Nanosecond())}
+    }
+    if changes.Mtime != nil {
+        req.Mtime = &api.FileTime{Seconds: changes.Mtime.Unix(), Nano: int32(changes.Mtime.Nanosecond())}
+    }
+    if changes.GuardCtime != nil {
+        req.GuardCtime = &api.FileTime{Seconds: changes.GuardCtime.Unix(), Nano: int32(changes.GuardCtime.Nanosecond())}
+    }
+    
+    return c.setAttr(ctx, req)
+}
+
+// Chmod changes the permission, setuid, setgid and sticky bits of a file.
+// Only the owner may do this.
+func (c *Client) Chmod(ctx context.Context, fileHandle []byte, mode uint32) (*api.FileAttributes, error) {
+    return c.setAttr(ctx, &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+        Mode:        &mode,
+    })
+}
+
+// Chown changes the owner and group of a file; as with os.Chown, -1 leaves
+// that ID unchanged. Only root may change the owner.
+func (c *Client) Chown(ctx context.Context, fileHandle []byte, uid, gid int) (*api.FileAttributes, error) {
+    req := &api.SetAttrRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    if uid >= 0 {
+        u := uint32(uid)
+        req.Uid = &u
+    }
+    if gid >= 0 {
+        g := uint32(gid)
+        req.Gid = &g
+    }
+    
+    return c.setAttr(ctx, req)
+}
+
+// setAttr issues a SetAttr request
+func (c *Client) setAttr(ctx context.Context, req *api.SetAttrRequest) (*api.FileAttributes, error) {
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.SetAttrResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "SetAttr", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.SetAttr(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, fmt.Errorf("SetAttr RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("SetAttr", resp.Status)
+    }
+    
+    return resp.Attributes, nil
+}
+
+// PathConf retrieves the name and path limits enforced by the server
+func (c *Client) PathConf(ctx context.Context, fileHandle []byte) (*api.PathConfResponse, error) {
+    // Create request
+    req := &api.PathConfRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.PathConfResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "PathConf", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.PathConf(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, fmt.Errorf("PathConf RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("PathConf", resp.Status)
+    }
+    
+    return resp, nil
+}
+
+// FsInfo retrieves the server's transfer sizes and capabilities
+func (c *Client) FsInfo(ctx context.Context, fileHandle []byte) (*api.FsInfoResponse, error) {
+    // Create request
+    req := &api.FsInfoRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.FsInfoResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "FsInfo", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.FsInfo(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return nil, NewNFSError("FsInfo", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("FsInfo RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("FsInfo", resp.Status)
+    }
+    
+    return resp, nil
+}
+
+// BulkLookup looks up several names in one directory with a single RPC
+// Returns one result per name, in order; check each result's status
+func (c *Client) BulkLookup(ctx context.Context, dirHandle []byte, names []string) ([]*api.BulkLookupResult, error) {
+    // Create request
+    req := &api.BulkLookupRequest{
+        DirectoryHandle: dirHandle,
+        Names:           names,
+        Credentials:     credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.BulkLookupResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "BulkLookup", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.BulkLookup(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        // Older servers lack the RPC; callers fall back to Lookup
+        return nil, NewNFSError("BulkLookup", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("BulkLookup RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("BulkLookup", resp.Status)
+    }
+    if len(resp.Results) != len(names) {
+        return nil, fmt.Errorf("BulkLookup returned %d results for %d names", len(resp.Results), len(names))
+    }
+    
+    for i, result := range resp.Results {
+        if result.Status == api.Status_OK {
+            c.rememberChild(dirHandle, names[i], result.FileHandle)
+        }
+    }
+    
+    return resp.Results, nil
+}
+
+// AcquireFence issues a new fencing epoch for a file. Attach it to later
+// writes with WithFence; once anyone acquires a newer epoch, those writes
+// fail with ErrFenced
+func (c *Client) AcquireFence(ctx context.Context, fileHandle []byte) (uint64, error) {
+    // Create request
+    req := &api.AcquireFenceRequest{
+        FileHandle:  fileHandle,
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Not retried: a lost response would leave a newer epoch issued that
+    // the caller never saw, which is harmless but wasteful to repeat
+    resp, err := c.nfsClient.AcquireFence(callCtx, req)
+    if status.Code(err) == codes.Unimplemented {
+        return 0, NewNFSError("AcquireFence", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return 0, fmt.Errorf("AcquireFence RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return 0, StatusToError("AcquireFence", resp.Status)
+    }
+    
+    return resp.Epoch, nil
+}
+
+// GetRootFileHandle retrieves the root directory file handle from the server
+func (c *Client) GetRootFileHandle(ctx context.Context) ([]byte, error) {
+    // Create request
+    req := &api.GetRootHandleRequest{
+        Credentials: &api.Credentials{
+            Uid: 1000,
+            Gid: 1000,
+            Groups: []uint32{1000},
+        },
+        Export: c.config.Export,
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.GetRootHandleResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "GetRootFileHandle", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.GetRootHandle(retryCtx, req)
+        return err
+    })
+    
+    if err != nil {
+        return nil, fmt.Errorf("Fail to get root: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("GetRootFileHandle", resp.Status)
+    }
+    
+    c.rememberPath(resp.FileHandle, "/")
+    
+    return resp.FileHandle, nil
+}
+
+// ListExports lists the exports of the server this client may use
+func (c *Client) ListExports(ctx context.Context) ([]*api.ExportInfo, error) {
+    // Create request
+    req := &api.ListExportsRequest{
+        Credentials: credentialsFromContext(ctx),
+    }
+    
+    // Create a context with timeout
+    callCtx, cancel := c.operationContext(ctx)
+    defer cancel()
+    
+    // Call the RPC method with retry logic
+    var resp *api.ListExportsResponse
+    var err error
+    
+    err = c.callWithRetry(callCtx, "ListExports", func(retryCtx context.Context) error {
+        resp, err = c.nfsClient.ListExports(retryCtx, req)
+        return err
+    })
+    
+    if status.Code(err) == codes.Unimplemented {
+        return nil, NewNFSError("ListExports", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("ListExports RPC failed: %w", err)
+    }
+    
+    // Check the status
+    if resp.Status != api.Status_OK {
+        return nil, StatusToError("ListExports", resp.Status)
+    }
+    
+    return resp.Exports, nil
 }
 
 // LookupPath resolves a file path to a file handle, starting from the root
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+)
+
+// dialReplicas connects to every address in config.ReplicaAddresses.
+// A replica that cannot be reached is skipped so the primary stays usable.
+func dialReplicas(config *Config, dialOpts []grpc.DialOption, metrics *Metrics) []*Client {
+	var replicas []*Client
+	for _, addr := range config.ReplicaAddresses {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		conn, err := grpc.DialContext(ctx, addr, dialOpts...)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: failed to connect to replica %s: %v", addr, err)
+			continue
+		}
+		replicas = append(replicas, &Client{
+			conn:        conn,
+			nfsClient:   api.NewNFSServiceClient(conn),
+			config:      config,
+			handleCache: NewHandleCache(config.MaxCacheSize, config.CacheTTL),
+			metrics:     metrics,
+		})
+	}
+	return replicas
+}
+
+// defaultReplicaPaths is the number of handle paths kept for replica
+// fallback when Config.MaxCacheSize is not set
+const defaultReplicaPaths = 1000
+
+// replicaPaths maps handles to the paths they were resolved from, keeping
+// the max most recently used. The zero value is ready to use.
+type replicaPaths struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List               // Of *replicaPath, most recently used first
+	entries map[string]*list.Element // By string(handle)
+}
+
+// replicaPath is one handle and the path it was resolved from
+type replicaPath struct {
+	handle string
+	path   string
+}
+
+// store records that handle was resolved from p
+func (r *replicaPaths) store(handle []byte, p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = make(map[string]*list.Element)
+		r.order = list.New()
+	}
+	if elem, ok := r.entries[string(handle)]; ok {
+		elem.Value.(*replicaPath).path = p
+		r.order.MoveToFront(elem)
+		return
+	}
+	r.entries[string(handle)] = r.order.PushFront(&replicaPath{handle: string(handle), path: p})
+	limit := r.max
+	if limit <= 0 {
+		limit = defaultReplicaPaths
+	}
+	for r.order.Len() > limit {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*replicaPath).handle)
+	}
+}
+
+// load returns the path handle was resolved from
+func (r *replicaPaths) load(handle []byte) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elem, ok := r.entries[string(handle)]
+	if !ok {
+		return "", false
+	}
+	r.order.MoveToFront(elem)
+	return elem.Value.(*replicaPath).path, true
+}
+
+// remove forgets p and every path below it
+func (r *replicaPaths) remove(p string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.each(func(elem *list.Element, entry *replicaPath) {
+		if below(entry.path, p) {
+			r.order.Remove(elem)
+			delete(r.entries, entry.handle)
+		}
+	})
+}
+
+// rename moves the paths at and below from to to, or, if exchange is set,
+// swaps them with those at and below to. Without exchange, what was at to
+// is replaced.
+func (r *replicaPaths) rename(from string, to string, exchange bool) {
+	if !exchange {
+		r.remove(to)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.each(func(elem *list.Element, entry *replicaPath) {
+		switch {
+		case below(entry.path, from):
+			entry.path = to + entry.path[len(from):]
+		case exchange && below(entry.path, to):
+			entry.path = from + entry.path[len(to):]
+		}
+	})
+}
+
+// each calls fn for every entry; fn may remove the one it is given
+func (r *replicaPaths) each(fn func(elem *list.Element, entry *replicaPath)) {
+	if r.order == nil {
+		return
+	}
+	for elem := r.order.Front(); elem != nil; {
+		next := elem.Next()
+		fn(elem, elem.Value.(*replicaPath))
+		elem = next
+	}
+}
+
+// below reports whether p is dir or a path inside it
+func below(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// rememberPath records the path a handle was resolved from, so a read that
+// fails on the primary can be retried against a replica. Handles are
+// server-specific; only the path is meaningful to another server.
+func (c *Client) rememberPath(handle []byte, path string) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	c.handlePaths.store(handle, path)
+}
+
+// rememberChild records the path of name looked up in dirHandle, if the
+// path of dirHandle itself is known
+func (c *Client) rememberChild(dirHandle []byte, name string, handle []byte) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	if dir, ok := c.handlePaths.load(dirHandle); ok {
+		c.handlePaths.store(handle, path.Join(dir, name))
+	}
+}
+
+// forgetChild forgets the paths at and below name in dirHandle, which has
+// been removed or replaced
+func (c *Client) forgetChild(dirHandle []byte, name string) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	if dir, ok := c.handlePaths.load(dirHandle); ok {
+		c.handlePaths.remove(path.Join(dir, name))
+	}
+}
+
+// renameChild moves the recorded paths along with a rename. Where the path
+// of either directory is unknown, the paths involved are forgotten.
+func (c *Client) renameChild(fromDirHandle []byte, fromName string, toDirHandle []byte, toName string, exchange bool) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	fromDir, fromKnown := c.handlePaths.load(fromDirHandle)
+	toDir, toKnown := c.handlePaths.load(toDirHandle)
+	if fromKnown && toKnown {
+		c.handlePaths.rename(path.Join(fromDir, fromName), path.Join(toDir, toName), exchange)
+		return
+	}
+	c.forgetChild(fromDirHandle, fromName)
+	c.forgetChild(toDirHandle, toName)
+}
+
+// sameFile reports whether a replica's file has the size and modification
+// time, to the second, of the primary's, as copies made with rsync -a or
+// cp -p do. Handles and file IDs differ between servers; a path alone may
+// name another file on a replica that is behind.
+func sameFile(primary *api.FileAttributes, replica *api.FileAttributes) bool {
+	return replica.GetType() == primary.GetType() &&
+		replica.GetSize() == primary.GetSize() &&
+		replica.GetMtime().GetSeconds() == primary.GetMtime().GetSeconds()
+}
+
+// readFromReplica retries a failed read on each replica in turn, resolving
+// the file again by path. It returns primaryErr if no replica can serve it.
+func (c *Client) readFromReplica(ctx context.Context, fileHandle []byte, offset int64, count int, primaryErr error) ([]byte, bool, error) {
+	filePath, ok := c.handlePaths.load(fileHandle)
+	if !ok {
+		return nil, false, primaryErr
+	}
+	// The primary still knows what the file looks like, if not its data
+	want, err := c.GetAttr(ctx, fileHandle)
+	if err != nil {
+		return nil, false, primaryErr
+	}
+
+	for _, replica := range c.replicas {
+		handle, err := replica.LookupPath(ctx, filePath)
+		if err != nil {
+			log.Printf("Replica %s: lookup of %s failed: %v", replica.conn.Target(), filePath, err)
+			continue
+		}
+		attrs, err := replica.GetAttr(ctx, handle)
+		if err != nil {
+			log.Printf("Replica %s: getattr of %s failed: %v", replica.conn.Target(), filePath, err)
+			continue
+		}
+		if !sameFile(want, attrs) {
+			log.Printf("Replica %s: %s is not the file the primary has", replica.conn.Target(), filePath)
+			continue
+		}
+		data, eof, err := replica.Read(ctx, handle, offset, count)
+		if err != nil {
+			log.Printf("Replica %s: read of %s failed: %v", replica.conn.Target(), filePath, err)
+			continue
+		}
+		return data, eof, nil
+	}
+
+	return nil, false, fmt.Errorf("%w (no replica could serve %s)", primaryErr, filePath)
+}
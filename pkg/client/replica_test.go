@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// failingReadService resolves /log like growingFileService but cannot read it
+type failingReadService struct {
+	growingFileService
+}
+
+func (s *failingReadService) Lookup(ctx context.Context, req *api.LookupRequest) (*api.LookupResponse, error) {
+	if req.Name != "log" {
+		return &api.LookupResponse{Status: api.Status_ERR_NOENT}, nil
+	}
+	// Handles are server-specific, so the replica must not see this one
+	return &api.LookupResponse{Status: api.Status_OK, FileHandle: []byte("primary-log")}, nil
+}
+
+func (s *failingReadService) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadResponse, error) {
+	return &api.ReadResponse{Status: api.Status_ERR_IO}, nil
+}
+
+func (s *failingReadService) Rename(ctx context.Context, req *api.RenameRequest) (*api.RenameResponse, error) {
+	return &api.RenameResponse{Status: api.Status_OK}, nil
+}
+
+func (s *failingReadService) Remove(ctx context.Context, req *api.RemoveRequest) (*api.RemoveResponse, error) {
+	return &api.RemoveResponse{Status: api.Status_OK}, nil
+}
+
+func TestReadFallsBackToReplica(t *testing.T) {
+	// The primary knows the size of its /log, but cannot read it
+	primarySvc := &failingReadService{}
+	primarySvc.set("mirrored")
+	client := newServiceClient(t, primarySvc)
+
+	// Without replicas the I/O error is returned as is
+	handle, err := client.LookupPath(context.Background(), "/log")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	if _, _, err := client.Read(context.Background(), handle, 0, 10); err == nil {
+		t.Fatal("Expected read from primary to fail")
+	}
+
+	replicaSvc := &growingFileService{}
+	replicaSvc.set("mirrored")
+	client.replicas = []*Client{newServiceClient(t, replicaSvc)}
+
+	handle, err = client.LookupPath(context.Background(), "/log")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+	data, eof, err := client.Read(context.Background(), handle, 0, 100)
+	if err != nil {
+		t.Fatalf("Read with replica failed: %v", err)
+	}
+	if string(data) != "mirrored" || !eof {
+		t.Errorf("Got %q (eof=%v), want %q from the replica", data, eof, "mirrored")
+	}
+
+	// A handle the client never resolved by path cannot be redirected
+	if _, _, err := client.Read(context.Background(), []byte("unknown"), 0, 10); err == nil {
+		t.Error("Expected read of an unresolved handle to fail")
+	}
+
+	// Nor can a file the replica does not have
+	replicaSvc.set("")
+	client.replicas = []*Client{newServiceClient(t, &failingReadService{})}
+	if _, _, err := client.Read(context.Background(), handle, 0, 10); err == nil {
+		t.Error("Expected read to fail when every replica fails")
+	}
+}
+
+func TestReplicaPathsFollowChanges(t *testing.T) {
+	ctx := context.Background()
+	primarySvc := &failingReadService{}
+	primarySvc.set("mirrored")
+	client := newServiceClient(t, primarySvc)
+	replicaSvc := &growingFileService{}
+	replicaSvc.set("mirrored")
+	client.replicas = []*Client{newServiceClient(t, replicaSvc)}
+
+	root, err := client.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	handle, err := client.LookupPath(ctx, "/log")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+
+	// A replica whose /log is another file is not read
+	replicaSvc.set("other file")
+	if _, _, err := client.Read(ctx, handle, 0, 100); err == nil {
+		t.Error("Read was served from a replica file of another size")
+	}
+	replicaSvc.set("mirrored")
+
+	// Once renamed, the file is looked for under its new name, which the
+	// replica does not have, rather than under the old one
+	if err := client.Rename(ctx, root, "log", root, "old"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if p, ok := client.handlePaths.load(handle); !ok || p != "/old" {
+		t.Errorf("Path after Rename = %q, %v; want /old", p, ok)
+	}
+	if _, _, err := client.Read(ctx, handle, 0, 100); err == nil {
+		t.Error("Read after Rename was served from the file now at the old name")
+	}
+
+	// A removed file is forgotten
+	if err := client.Remove(ctx, root, "old"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, ok := client.handlePaths.load(handle); ok {
+		t.Error("Path of a removed file is still recorded")
+	}
+}
+
+func TestReplicaPaths(t *testing.T) {
+	paths := replicaPaths{max: 3}
+	paths.store([]byte("a"), "/dir/a")
+	paths.store([]byte("b"), "/dir/b")
+	paths.store([]byte("c"), "/dirty")
+	paths.load([]byte("a"))
+	paths.store([]byte("d"), "/d")
+
+	// The least recently used entry makes room
+	if _, ok := paths.load([]byte("b")); ok {
+		t.Error("Least recently used entry was kept")
+	}
+
+	// Renames move what is below the directory, and only that
+	paths.rename("/dir", "/moved", false)
+	if p, _ := paths.load([]byte("a")); p != "/moved/a" {
+		t.Errorf("Path below a renamed directory = %q, want /moved/a", p)
+	}
+	if p, _ := paths.load([]byte("c")); p != "/dirty" {
+		t.Errorf("Path beside a renamed directory = %q, want /dirty", p)
+	}
+	paths.rename("/moved/a", "/d", true)
+	if a, _ := paths.load([]byte("a")); a != "/d" {
+		t.Errorf("Path after an exchange = %q, want /d", a)
+	}
+	if d, _ := paths.load([]byte("d")); d != "/moved/a" {
+		t.Errorf("Path after an exchange = %q, want /moved/a", d)
+	}
+
+	// A rename over an existing name replaces it
+	paths.rename("/dirty", "/d", false)
+	if _, ok := paths.load([]byte("a")); ok {
+		t.Error("Path of a replaced file is still recorded")
+	}
+	paths.remove("/moved")
+	if _, ok := paths.load([]byte("d")); ok {
+		t.Error("Path below a removed directory is still recorded")
+	}
+	if p, _ := paths.load([]byte("c")); p != "/d" {
+		t.Errorf("Path of the renamed file = %q, want /d", p)
+	}
+}
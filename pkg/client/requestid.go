@@ -0,0 +1,23 @@
+package client
+
+import (
+	"context"
+
+	"github.com/example/nfsserver/pkg/logging"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDMetadataKey is the gRPC metadata key carrying the ID of a
+// request. The server logs the request under it, and replays its response
+// when the same ID arrives again, so a retry of a request the server already
+// carried out is not carried out twice.
+const RequestIDMetadataKey = "x-request-id"
+
+// withRequestID returns a context sending a new request ID, kept by every
+// attempt made with it, unless ctx already sends one
+func withRequestID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDMetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDMetadataKey, logging.NewRequestID())
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+// Load balancing policies for Config.LoadBalancing
+const (
+	// PickFirst sends every request to one server, moving to the next
+	// only when it fails
+	PickFirst = "pick_first"
+
+	// RoundRobin spreads requests over every server the address resolves to
+	RoundRobin = "round_robin"
+)
+
+// defaultPort is the port of dns:/// addresses naming none
+const defaultPort = "2049"
+
+// minResolveGap is the shortest time between lookups asked for because
+// connections failed, so a fleet going down does not flood the DNS server
+const minResolveGap = time.Second
+
+// balancingOptions returns the options resolving dns:/// server addresses
+// every config.ResolveInterval and spreading requests over the servers
+// found as config.LoadBalancing says
+func balancingOptions(config *Config) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithResolvers(&dnsBuilder{interval: config.ResolveInterval, lookup: net.DefaultResolver.LookupHost}),
+	}
+	if config.LoadBalancing != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, config.LoadBalancing)))
+	}
+	return opts
+}
+
+// ValidLoadBalancing returns an error unless policy names a load
+// balancing policy the client supports, for Config.LoadBalancing
+func ValidLoadBalancing(policy string) error {
+	switch policy {
+	case "", PickFirst, RoundRobin:
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing policy %q (want %s or %s)", policy, PickFirst, RoundRobin)
+}
+
+// dnsBuilder resolves dns:/// addresses to every address the name has, as
+// gRPC's own resolver does, but looks the name up again periodically as
+// well as when connections fail. Servers added behind the name, such as new
+// endpoints of a Kubernetes headless service, are used without waiting for
+// one in use to fail.
+type dnsBuilder struct {
+	interval time.Duration
+	lookup   func(ctx context.Context, host string) ([]string, error)
+}
+
+// Scheme returns the scheme the builder resolves, replacing gRPC's own
+// resolver for the connections given it
+func (b *dnsBuilder) Scheme() string {
+	return "dns"
+}
+
+// Build starts resolving target. Looking names up through a DNS server
+// named in the target's authority is not supported.
+func (b *dnsBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if target.URL.Host != "" {
+		return nil, fmt.Errorf("dns resolver: custom DNS server %q not supported", target.URL.Host)
+	}
+	host, port, err := net.SplitHostPort(target.Endpoint())
+	if err != nil {
+		host, port = target.Endpoint(), defaultPort
+	}
+	if host == "" {
+		return nil, fmt.Errorf("dns resolver: no host in %q", target.Endpoint())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &dnsResolver{
+		host:       host,
+		port:       port,
+		interval:   b.interval,
+		lookup:     b.lookup,
+		cc:         cc,
+		ctx:        ctx,
+		cancel:     cancel,
+		resolveNow: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go r.watch()
+	return r, nil
+}
+
+// dnsResolver keeps one connection's addresses for a name current
+type dnsResolver struct {
+	host     string
+	port     string
+	interval time.Duration
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	cc       resolver.ClientConn
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	resolveNow chan struct{}
+	done       chan struct{}
+}
+
+// ResolveNow asks for a lookup, as gRPC does when a connection fails
+func (r *dnsResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops resolving and waits for a lookup under way to end
+func (r *dnsResolver) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// watch looks the name up at once, then every interval and whenever asked
+// to, at most once per minResolveGap, until closed. A failed lookup keeps
+// the addresses already known.
+func (r *dnsResolver) watch() {
+	defer close(r.done)
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var last []string
+	for {
+		addrs, err := r.resolve()
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Warning: failed to resolve %s: %v", r.host, err)
+			r.cc.ReportError(err)
+		} else if !slices.Equal(addrs, last) {
+			if last != nil {
+				log.Printf("Servers behind %s changed: %v", r.host, addrs)
+			}
+			last = addrs
+			state := resolver.State{}
+			for _, addr := range addrs {
+				state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+			}
+			r.cc.UpdateState(state)
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(minResolveGap):
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-tick:
+		case <-r.resolveNow:
+		}
+	}
+}
+
+// resolve returns the sorted addresses the name resolves to, with the port
+func (r *dnsResolver) resolve() ([]string, error) {
+	if ip := net.ParseIP(r.host); ip != nil {
+		return []string{net.JoinHostPort(r.host, r.port)}, nil
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, 30*time.Second)
+	defer cancel()
+	hosts, err := r.lookup(ctx, r.host)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", r.host)
+	}
+	addrs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, r.port))
+	}
+	slices.Sort(addrs)
+	return addrs, nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fleetService counts the requests one server of a fleet answers
+type fleetService struct {
+	api.UnimplementedNFSServiceServer
+
+	calls atomic.Int32
+}
+
+func (s *fleetService) GetRootHandle(ctx context.Context, req *api.GetRootHandleRequest) (*api.GetRootHandleResponse, error) {
+	s.calls.Add(1)
+	return &api.GetRootHandleResponse{Status: api.Status_OK, FileHandle: []byte("root")}, nil
+}
+
+// fakeDNS answers lookups with a list of hosts that can change
+type fakeDNS struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (d *fakeDNS) set(hosts ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts = hosts
+}
+
+func (d *fakeDNS) lookup(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.hosts...), nil
+}
+
+func TestRoundRobinOverResolvedServers(t *testing.T) {
+	// Three servers, reached by IP address
+	services := make(map[string]*fleetService)
+	listeners := make(map[string]*bufconn.Listener)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		services[ip] = &fleetService{}
+		listeners[ip] = bufconn.Listen(1024 * 1024)
+		server := grpc.NewServer()
+		api.RegisterNFSServiceServer(server, services[ip])
+		go server.Serve(listeners[ip])
+		t.Cleanup(server.Stop)
+	}
+
+	// The name resolves to two of them at first
+	dns := &fakeDNS{}
+	dns.set("10.0.0.2", "10.0.0.1")
+
+	config := DefaultConfig()
+	config.ServerAddress = "dns:///nfs.example:2049"
+	config.ResolveInterval = 50 * time.Millisecond
+	config.LoadBalancing = RoundRobin
+	opts := append([]grpc.DialOption{
+		grpc.WithResolvers(&dnsBuilder{interval: config.ResolveInterval, lookup: dns.lookup}),
+	}, balancingOptions(config)...)
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listeners[strings.TrimSuffix(addr, ":2049")].DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(config.ServerAddress, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create connection: %v", err)
+	}
+	defer conn.Close()
+	c := &Client{conn: conn, nfsClient: api.NewNFSServiceClient(conn), config: config, handleCache: NewHandleCache(100, time.Minute)}
+
+	// Requests go to every server found, the newest once it is found
+	ctx := context.Background()
+	callUntil := func(ip string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for services[ip].calls.Load() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("No request reached %s", ip)
+			}
+			if _, err := c.GetRootFileHandle(ctx); err != nil {
+				t.Fatalf("GetRootFileHandle failed: %v", err)
+			}
+		}
+	}
+	callUntil("10.0.0.1")
+	callUntil("10.0.0.2")
+	if calls := services["10.0.0.3"].calls.Load(); calls != 0 {
+		t.Errorf("Server not behind the name yet got %d requests", calls)
+	}
+	dns.set("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	callUntil("10.0.0.3")
+}
+
+func TestValidLoadBalancing(t *testing.T) {
+	for policy, ok := range map[string]bool{"": true, PickFirst: true, RoundRobin: true, "least_request": false} {
+		if err := ValidLoadBalancing(policy); (err == nil) != ok {
+			t.Errorf("ValidLoadBalancing(%q) = %v", policy, err)
+		}
+	}
+}
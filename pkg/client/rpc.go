@@ -2,18 +2,39 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/example/nfsserver/pkg/api"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-// callWithRetry executes an RPC call with retry logic
+// maxHardRetryDelay bounds the backoff of a hard client retrying through a
+// server outage, so it notices soon after the server is back
+const maxHardRetryDelay = 30 * time.Second
+
+// operationContext returns the context bounding one operation and all its
+// retries: Timeout for each attempt allowed, on a soft client, or only the
+// caller's context on a hard one
+func (c *Client) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.config.Hard {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.config.Timeout)
+}
+
+// callWithRetry executes an RPC call with retry logic. Every attempt carries
+// the same request ID, by which the server recognizes retries, and the
+// client's ID, by which it tells the client from holders of delegations.
 func (c *Client) callWithRetry(ctx context.Context, operation string, fn func(context.Context) error) error {
 	var lastErr error
+	stalled := false
+	ctx = c.withClientID(withRequestID(ctx))
 	
-	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+	for attempt := 0; ; attempt++ {
 		// Create a context with timeout
 		callCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
 		
@@ -23,8 +44,16 @@ func (c *Client) callWithRetry(ctx context.Context, operation string, fn func(co
 		// Cancel the context
 		cancel()
 		
+		if err == nil && stalled {
+			log.Printf("nfs: server %s OK", c.config.ServerAddress)
+		}
+		
+		// A hard client keeps retrying while the server is unreachable or
+		// silent, for as long as the caller waits
+		hard := c.config.Hard && isOutage(err) && ctx.Err() == nil
+		
 		// If successful or not retryable, return the result
-		if err == nil || !isRetryableError(err) {
+		if err == nil || (!hard && !isRetryableError(err)) {
 			return err
 		}
 		
@@ -32,12 +61,27 @@ func (c *Client) callWithRetry(ctx context.Context, operation string, fn func(co
 		lastErr = err
 		
 		// If this was the last attempt, break
-		if attempt == c.config.MaxRetries {
-			break
+		if attempt >= c.config.MaxRetries {
+			if !hard {
+				break
+			}
+			if !stalled {
+				log.Printf("nfs: server %s not responding, still trying: %v", c.config.ServerAddress, err)
+				stalled = true
+			}
 		}
 		
-		// Calculate retry delay with exponential backoff
+		c.metrics.retried(c.target(), operation)
+		
+		// Calculate retry delay with exponential backoff, unless an
+		// overloaded server said how long to wait
 		delay := c.config.RetryDelay * time.Duration(float64(attempt+1)*c.config.BackoffFactor)
+		if hard {
+			delay = min(delay, maxHardRetryDelay)
+		}
+		if hint, ok := retryAfter(err); ok {
+			delay = hint
+		}
 		
 		// Wait for the retry delay or until the context is canceled
 		select {
@@ -52,6 +96,25 @@ func (c *Client) callWithRetry(ctx context.Context, operation string, fn func(co
 	return fmt.Errorf("operation %s failed after %d attempts: %w", operation, c.config.MaxRetries+1, lastErr)
 }
 
+// target returns the address of the server the client is connected to, as
+// metrics label it
+func (c *Client) target() string {
+	if c.conn == nil {
+		return c.config.ServerAddress
+	}
+	return c.conn.Target()
+}
+
+// isOutage reports whether err means the server could not be reached or
+// did not answer in time, the errors a hard client retries indefinitely
+func isOutage(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
 // isRetryableError checks if an error is retryable
 func isRetryableError(err error) bool {
 	// If it's a context error, it's not retryable
@@ -77,3 +140,18 @@ func isRetryableError(err error) bool {
 	// Default to not retryable
 	return false
 }
+
+// retryAfter returns the delay suggested by a server shedding load, if err
+// carries one
+func retryAfter(err error) (time.Duration, bool) {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+	for _, detail := range s.Details() {
+		if hint, ok := detail.(*api.RetryHint); ok {
+			return time.Duration(hint.RetryAfterMs) * time.Millisecond, true
+		}
+	}
+	return 0, false
+}
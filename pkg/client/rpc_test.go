@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallWithRetryHonorsRetryHint(t *testing.T) {
+	config := DefaultConfig()
+	config.RetryDelay = time.Minute
+	c := &Client{config: config}
+
+	busy, err := status.New(codes.ResourceExhausted, "request queue full").WithDetails(&api.RetryHint{
+		Status:       api.Status_ERR_DELAY,
+		RetryAfterMs: 20,
+	})
+	if err != nil {
+		t.Fatalf("WithDetails failed: %v", err)
+	}
+
+	attempts := 0
+	start := time.Now()
+	err = c.callWithRetry(context.Background(), "GetAttr", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return busy.Err()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("callWithRetry returned error: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", attempts)
+	}
+
+	// The server's 20ms hint replaces the configured one-minute backoff
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 10*time.Second {
+		t.Errorf("Retried after %v, want about 20ms", elapsed)
+	}
+}
+
+func TestCallWithRetryHardAndSoft(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRetries = 1
+	config.RetryDelay = time.Millisecond
+	down := status.Error(codes.Unavailable, "connection refused")
+
+	// The server comes back after five failed attempts
+	outage := func(attempts *int) func(context.Context) error {
+		return func(ctx context.Context) error {
+			*attempts++
+			if *attempts <= 5 {
+				return down
+			}
+			return nil
+		}
+	}
+
+	soft := &Client{config: config}
+	attempts := 0
+	if err := soft.callWithRetry(context.Background(), "GetAttr", outage(&attempts)); status.Code(errors.Unwrap(err)) != codes.Unavailable {
+		t.Errorf("Soft callWithRetry returned %v, want the Unavailable error", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Soft attempts = %d, want 2", attempts)
+	}
+
+	hardConfig := *config
+	hardConfig.Hard = true
+	hard := &Client{config: &hardConfig}
+	attempts = 0
+	if err := hard.callWithRetry(context.Background(), "GetAttr", outage(&attempts)); err != nil {
+		t.Errorf("Hard callWithRetry returned %v, want it to outlast the outage", err)
+	}
+	if attempts != 6 {
+		t.Errorf("Hard attempts = %d, want 6", attempts)
+	}
+
+	// Errors other than outages still fail a hard client
+	attempts = 0
+	err := hard.callWithRetry(context.Background(), "GetAttr", func(ctx context.Context) error {
+		attempts++
+		return status.Error(codes.PermissionDenied, "no")
+	})
+	if status.Code(err) != codes.PermissionDenied || attempts != 1 {
+		t.Errorf("Hard callWithRetry returned %v after %d attempts, want PermissionDenied after 1", err, attempts)
+	}
+
+	// And a hard client gives up when its caller does
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = hard.callWithRetry(ctx, "GetAttr", func(ctx context.Context) error {
+		return down
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Hard callWithRetry with an expiring context returned %v", err)
+	}
+}
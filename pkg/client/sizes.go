@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// transferSizes returns the largest Read and Write this client should
+// issue, the server's preferred sizes from FsInfo. They are asked for once;
+// servers without FsInfo get fileChunkSize, and so does every call until
+// FsInfo first succeeds. handle is any handle on the export.
+func (c *Client) transferSizes(ctx context.Context, handle []byte) (int, int) {
+	c.sizesMu.Lock()
+	defer c.sizesMu.Unlock()
+	if c.readSize > 0 {
+		return c.readSize, c.writeSize
+	}
+
+	info, err := c.FsInfo(ctx, handle)
+	if errors.Is(err, ErrNotImplemented) {
+		c.readSize, c.writeSize = fileChunkSize, fileChunkSize
+	}
+	if err != nil {
+		return fileChunkSize, fileChunkSize
+	}
+	c.readSize = min(preferredSize(info.Rtpref, info.Rtmax), maxTransferSize(c.config))
+	c.writeSize = min(preferredSize(info.Wtpref, info.Wtmax), maxTransferSize(c.config))
+	return c.readSize, c.writeSize
+}
+
+// preferredSize picks the transfer size from a preferred and a maximum
+// size, either of which may be unset
+func preferredSize(pref, max uint32) int {
+	size := fileChunkSize
+	if pref > 0 {
+		size = int(pref)
+	}
+	if max > 0 && size > int(max) {
+		size = int(max)
+	}
+	return size
+}
+
+// maxTransferSize returns the largest read or write config allows
+func maxTransferSize(config *Config) int {
+	if config.MaxTransferSize > 0 {
+		return config.MaxTransferSize
+	}
+	return nfs.MaxTransferSize
+}
+
+// transportOptions sizes gRPC's message limits to the largest transfer
+// config allows and applies its keepalive settings
+func transportOptions(config *Config) []grpc.DialOption {
+	limit := nfs.MessageLimit(maxTransferSize(config))
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(limit), grpc.MaxCallSendMsgSize(limit)),
+	}
+	if config.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                config.KeepaliveTime,
+			Timeout:             config.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package client
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// fileOwner returns the owner and group of the file at path
+func fileOwner(t *testing.T, path string) (uint32, uint32) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
@@ -0,0 +1,16 @@
+package client
+
+import (
+	"os"
+	"testing"
+)
+
+// fileOwner returns the owner and group of the file at path. Windows has
+// no POSIX owners, and the local backend shows every file owned by root.
+func fileOwner(t *testing.T, path string) (uint32, uint32) {
+	t.Helper()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return 0, 0
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TagMetadataKey is the gRPC metadata key carrying the request tag
+const TagMetadataKey = "x-nfs-tag"
+
+// tagKey is the context key for request tags
+type tagKey struct{}
+
+// WithTag returns a context whose operations carry tag (for example a job ID
+// or tool name) to the server, where it is recorded alongside each request so
+// operators can attribute load to workloads. An empty tag removes any tag.
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// TagFromContext returns the tag attached to ctx, if any
+func TagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(tagKey{}).(string)
+	return tag
+}
+
+// tagInterceptor returns a client interceptor that sends the context tag,
+// or defaultTag when the context has none, as gRPC metadata
+func tagInterceptor(defaultTag string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+		tag, ok := ctx.Value(tagKey{}).(string)
+		if !ok {
+			tag = defaultTag
+		}
+		if tag != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, TagMetadataKey, tag)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
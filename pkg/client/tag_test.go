@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTagInterceptor(t *testing.T) {
+	interceptor := tagInterceptor("default-tool")
+
+	var sent []string
+	invoker := func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		sent = md.Get(TagMetadataKey)
+		return nil
+	}
+
+	testCases := []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"Default tag", context.Background(), []string{"default-tool"}},
+		{"Context tag", WithTag(context.Background(), "job-7"), []string{"job-7"}},
+		{"Tag removed", WithTag(context.Background(), ""), nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sent = nil
+			if err := interceptor(tc.ctx, "/nfs.NFSService/GetAttr", nil, nil, nil, invoker); err != nil {
+				t.Fatalf("Interceptor returned error: %v", err)
+			}
+			if len(sent) != len(tc.want) || (len(sent) > 0 && sent[0] != tc.want[0]) {
+				t.Errorf("Sent tag %q, want %q", sent, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// defaultTailPollInterval is used when Config.TailPollInterval is not set
+const defaultTailPollInterval = time.Second
+
+// tailChunkSize is the largest read issued while following a file
+const tailChunkSize = 64 * 1024
+
+// TailFollow streams bytes appended to the file at path, starting at its
+// current end, until ctx is canceled or the returned reader is closed.
+func (c *Client) TailFollow(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.TailFollowFrom(ctx, path, -1)
+}
+
+// TailFollowFrom is like TailFollow but starts at offset; a negative offset
+// starts at the current end of the file. The size is polled with GetAttr.
+// If the file is truncated, following restarts at its new end; if it is
+// replaced (for example by log rotation), the new file is followed from the start.
+func (c *Client) TailFollowFrom(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return tailFollow(ctx, c, path, offset, c.config.TailPollInterval)
+}
+
+// tailFollow implements TailFollowFrom on top of any client, polling every
+// interval (0 = the default)
+func tailFollow(ctx context.Context, c NFSClient, path string, offset int64, interval time.Duration) (io.ReadCloser, error) {
+	handle, err := c.LookupPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		attrs, err := c.GetAttr(ctx, handle)
+		if err != nil {
+			return nil, err
+		}
+		offset = int64(attrs.Size)
+	}
+
+	if interval <= 0 {
+		interval = defaultTailPollInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	t := &tailer{client: c, path: path, handle: handle, offset: offset, interval: interval}
+
+	go func() {
+		pw.CloseWithError(t.run(ctx, pw))
+	}()
+
+	return &tailReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// tailer holds the state of one TailFollow stream
+type tailer struct {
+	client   NFSClient
+	path     string
+	handle   []byte
+	offset   int64
+	interval time.Duration
+}
+
+// run copies appended data to w until ctx is done or w is closed
+func (t *tailer) run(ctx context.Context, w io.Writer) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.poll(ctx, w); err != nil {
+			if ctx.Err() != nil {
+				return io.EOF
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return io.EOF
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll writes everything between the current offset and the end of file
+func (t *tailer) poll(ctx context.Context, w io.Writer) error {
+	attrs, err := t.client.GetAttr(ctx, t.handle)
+	if errors.Is(err, ErrInvalidHandle) || errors.Is(err, ErrNotExist) {
+		// The file was replaced; follow whatever is at the path now
+		handle, lookupErr := t.client.LookupPath(ctx, t.path)
+		if lookupErr != nil {
+			// Not recreated yet, try again on the next poll
+			return nil
+		}
+		t.handle = handle
+		t.offset = 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	size := int64(attrs.Size)
+	if size < t.offset {
+		// Truncated in place
+		t.offset = size
+	}
+
+	for t.offset < size {
+		count := size - t.offset
+		if count > tailChunkSize {
+			count = tailChunkSize
+		}
+
+		data, _, err := t.client.Read(ctx, t.handle, t.offset, int(count))
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			break
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		t.offset += int64(len(data))
+	}
+
+	return nil
+}
+
+// tailReader stops the follow goroutine when closed
+type tailReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close stops following and releases the stream
+func (r *tailReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
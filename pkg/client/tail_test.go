@@ -0,0 +1,167 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// growingFileService serves a single file at /log whose content can change
+type growingFileService struct {
+	api.UnimplementedNFSServiceServer
+
+	mu   sync.Mutex
+	data []byte
+}
+
+func (s *growingFileService) set(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = []byte(data)
+}
+
+func (s *growingFileService) GetRootHandle(ctx context.Context, req *api.GetRootHandleRequest) (*api.GetRootHandleResponse, error) {
+	return &api.GetRootHandleResponse{Status: api.Status_OK, FileHandle: []byte("root")}, nil
+}
+
+func (s *growingFileService) Lookup(ctx context.Context, req *api.LookupRequest) (*api.LookupResponse, error) {
+	if req.Name != "log" {
+		return &api.LookupResponse{Status: api.Status_ERR_NOENT}, nil
+	}
+	return &api.LookupResponse{Status: api.Status_OK, FileHandle: []byte("log")}, nil
+}
+
+func (s *growingFileService) GetAttr(ctx context.Context, req *api.GetAttrRequest) (*api.GetAttrResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &api.GetAttrResponse{
+		Status:     api.Status_OK,
+		Attributes: &api.FileAttributes{Type: api.FileType_REGULAR, Size: uint64(len(s.data))},
+	}, nil
+}
+
+func (s *growingFileService) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := req.Offset + uint64(req.Count)
+	if end > uint64(len(s.data)) {
+		end = uint64(len(s.data))
+	}
+	if req.Offset >= end {
+		return &api.ReadResponse{Status: api.Status_OK, Eof: true}, nil
+	}
+	return &api.ReadResponse{
+		Status: api.Status_OK,
+		Data:   append([]byte(nil), s.data[req.Offset:end]...),
+		Eof:    end == uint64(len(s.data)),
+	}, nil
+}
+
+// newServiceClient connects a Client to svc over an in-memory listener
+func newServiceClient(t *testing.T, svc api.NFSServiceServer) *Client {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	api.RegisterNFSServiceServer(server, svc)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Timeout = 5 * time.Second
+	config.TailPollInterval = 10 * time.Millisecond
+	return &Client{
+		conn:        conn,
+		nfsClient:   api.NewNFSServiceClient(conn),
+		config:      config,
+		handleCache: NewHandleCache(100, 5*time.Minute),
+	}
+}
+
+// readUntil reads from r until want has been received or the deadline passes
+func readUntil(t *testing.T, r io.Reader, want string) {
+	t.Helper()
+
+	got := make(chan []byte)
+	go func() {
+		var buf bytes.Buffer
+		chunk := make([]byte, 64)
+		for buf.Len() < len(want) {
+			n, err := r.Read(chunk)
+			buf.Write(chunk[:n])
+			if err != nil {
+				break
+			}
+		}
+		got <- buf.Bytes()
+	}()
+
+	select {
+	case data := <-got:
+		if string(data) != want {
+			t.Errorf("Got %q, want %q", data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	svc := &growingFileService{}
+	svc.set("old line\n")
+	client := newServiceClient(t, svc)
+	defer client.Close()
+
+	stream, err := client.TailFollow(context.Background(), "/log")
+	if err != nil {
+		t.Fatalf("TailFollow failed: %v", err)
+	}
+	defer stream.Close()
+
+	// Only data appended after the call is streamed
+	svc.set("old line\nnew line\n")
+	readUntil(t, stream, "new line\n")
+
+	// After truncation, following resumes from the new end of file
+	svc.set("")
+	time.Sleep(50 * time.Millisecond)
+	svc.set("fresh\n")
+	readUntil(t, stream, "fresh\n")
+
+	// Closing the stream ends it
+	stream.Close()
+	if _, err := stream.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected an error reading a closed stream")
+	}
+}
+
+func TestTailFollowFrom(t *testing.T) {
+	svc := &growingFileService{}
+	svc.set("0123456789")
+	client := newServiceClient(t, svc)
+	defer client.Close()
+
+	stream, err := client.TailFollowFrom(context.Background(), "/log", 6)
+	if err != nil {
+		t.Fatalf("TailFollowFrom failed: %v", err)
+	}
+	defer stream.Close()
+
+	readUntil(t, stream, "6789")
+}
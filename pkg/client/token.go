@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCredentials sends the bearer token in a file with every RPC, for
+// servers establishing identity from tokens (see server.TokenAuthenticator).
+// The file is read again whenever it changes, so tokens can be renewed
+// without restarting the client.
+type tokenCredentials struct {
+	file string
+
+	mu    sync.Mutex
+	token string
+	mtime time.Time
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (t *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := t.current()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+// Connections are not encrypted yet, so tokens are sent in the clear and
+// should be short-lived.
+func (t *tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// current returns the token in the file, reading it again if it changed
+func (t *tokenCredentials) current() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return "", fmt.Errorf("auth token: %w", err)
+	}
+	if t.token != "" && info.ModTime().Equal(t.mtime) {
+		return t.token, nil
+	}
+	data, err := os.ReadFile(t.file)
+	if err != nil {
+		return "", fmt.Errorf("auth token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("auth token: %s is empty", t.file)
+	}
+	t.token, t.mtime = token, info.ModTime()
+	return t.token, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// TraceRecord describes a single RPC captured by a TraceRecorder
+type TraceRecord struct {
+	// Start is the time the call was issued
+	Start time.Time `json:"start"`
+
+	// Op is the full gRPC method name (e.g. "/nfs.NFSService/Read")
+	Op string `json:"op"`
+
+	// ArgsHash is the SHA-256 of the marshaled request, used to compare runs
+	ArgsHash string `json:"args_hash"`
+
+	// Duration is the wall-clock time the call took, retries excluded
+	Duration time.Duration `json:"duration"`
+
+	// Status is the NFS status of the response, or the gRPC code on transport errors
+	Status string `json:"status"`
+
+	// Request is the marshaled request, kept so the call can be replayed
+	Request []byte `json:"request,omitempty"`
+}
+
+// TraceRecorder serializes every RPC issued through a client to a trace file.
+// Records are written as one JSON object per line.
+type TraceRecorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewTraceRecorder creates a recorder writing to w
+func NewTraceRecorder(w io.Writer) *TraceRecorder {
+	bw := bufio.NewWriter(w)
+	return &TraceRecorder{
+		w:   bw,
+		enc: json.NewEncoder(bw),
+	}
+}
+
+// OpenTraceFile creates (or truncates) a trace file and returns a recorder for it
+func OpenTraceFile(path string) (*TraceRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+
+	r := NewTraceRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// Record appends a record to the trace
+func (r *TraceRecorder) Record(rec TraceRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enc.Encode(&rec)
+}
+
+// UnaryClientInterceptor returns a gRPC interceptor that records each call
+func (r *TraceRecorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		rec := TraceRecord{
+			Start:    start,
+			Op:       method,
+			Duration: time.Since(start),
+			Status:   traceStatus(reply, err),
+		}
+
+		if msg, ok := req.(proto.Message); ok {
+			opts := proto.MarshalOptions{Deterministic: true}
+			if data, merr := opts.Marshal(msg); merr == nil {
+				sum := sha256.Sum256(data)
+				rec.ArgsHash = hex.EncodeToString(sum[:])
+				rec.Request = data
+			}
+		}
+
+		// Tracing must never fail the call itself
+		_ = r.Record(rec)
+
+		return err
+	}
+}
+
+// Flush writes any buffered records to the underlying writer
+func (r *TraceRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.w.Flush()
+}
+
+// Close flushes the trace and closes the underlying file, if any
+func (r *TraceRecorder) Close() error {
+	if err := r.Flush(); err != nil {
+		return err
+	}
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+// traceStatus extracts a printable status from an RPC result
+func traceStatus(reply interface{}, err error) string {
+	if err != nil {
+		return status.Code(err).String()
+	}
+
+	// All NFS responses carry a Status field as their first member
+	if msg, ok := reply.(proto.Message); ok {
+		m := msg.ProtoReflect()
+		if fd := m.Descriptor().Fields().ByName("status"); fd != nil && fd.Kind() == protoreflect.EnumKind {
+			if ev := fd.Enum().Values().ByNumber(m.Get(fd).Enum()); ev != nil {
+				return string(ev.Name())
+			}
+		}
+	}
+
+	return "OK"
+}
+
+// ReadTrace parses a trace written by a TraceRecorder
+func ReadTrace(r io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec TraceRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse trace record %d: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
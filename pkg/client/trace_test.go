@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+)
+
+func TestTraceRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewTraceRecorder(&buf)
+	interceptor := recorder.UnaryClientInterceptor()
+
+	// Fake invoker that answers every call with ERR_NOENT
+	invoker := func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		reply.(*api.GetAttrResponse).Status = api.Status_ERR_NOENT
+		return nil
+	}
+
+	req := &api.GetAttrRequest{FileHandle: []byte("handle-1")}
+	for i := 0; i < 2; i++ {
+		if err := interceptor(context.Background(), "/nfs.NFSService/GetAttr", req,
+			&api.GetAttrResponse{}, nil, invoker); err != nil {
+			t.Fatalf("Interceptor returned error: %v", err)
+		}
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	records, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatalf("ReadTrace failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	rec := records[0]
+	if rec.Op != "/nfs.NFSService/GetAttr" {
+		t.Errorf("Wrong op: %s", rec.Op)
+	}
+	if rec.Status != "ERR_NOENT" {
+		t.Errorf("Wrong status: %s", rec.Status)
+	}
+	if rec.ArgsHash == "" || rec.ArgsHash != records[1].ArgsHash {
+		t.Errorf("Identical requests should hash identically: %q vs %q", rec.ArgsHash, records[1].ArgsHash)
+	}
+	if len(rec.Request) == 0 {
+		t.Error("Request payload was not recorded")
+	}
+}
@@ -0,0 +1,366 @@
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// ByteRange is a range of bytes of a file
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// TransferState records how far a Download or Upload got, so an
+// interrupted transfer continues where it stopped instead of starting
+// over. It is meant to be saved between attempts, e.g. with Save.
+type TransferState struct {
+	// Remote file and the number of bytes to transfer
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+
+	// Verifier identifies the version of the source being transferred. A
+	// state whose verifier no longer matches the source is discarded.
+	Verifier uint64 `json:"verifier"`
+
+	// Handle is the remote file an upload writes to; if the file at Path
+	// was replaced since, the upload starts over
+	Handle []byte `json:"handle,omitempty"`
+
+	// Done lists the ranges already transferred, sorted and merged
+	Done []ByteRange `json:"done"`
+}
+
+// TransferOptions controls Download and Upload
+type TransferOptions struct {
+	// Progress, if set, is called after each chunk with the bytes
+	// transferred so far, including those of earlier attempts, and the
+	// total
+	Progress func(done, total int64)
+
+	// State resumes an interrupted transfer and records the progress of
+	// this one (nil = transfer everything). A state for another file or
+	// another version of it is reset.
+	State *TransferState
+
+	// Checkpoint, if set, is called with State after each chunk, e.g. to
+	// save it. Its error stops the transfer.
+	Checkpoint func(*TransferState) error
+
+	// Verifier identifies the version of the local data Upload sends, e.g.
+	// derived from its size and modification time (see FileVerifier)
+	Verifier uint64
+}
+
+// Completed returns the number of bytes already transferred
+func (s *TransferState) Completed() int64 {
+	var n int64
+	for _, r := range s.Done {
+		n += r.Length
+	}
+	return n
+}
+
+// missing returns the ranges still to be transferred
+func (s *TransferState) missing() []ByteRange {
+	var gaps []ByteRange
+	var offset int64
+	for _, r := range s.Done {
+		if r.Offset > offset {
+			gaps = append(gaps, ByteRange{Offset: offset, Length: r.Offset - offset})
+		}
+		offset = max(offset, r.Offset+r.Length)
+	}
+	if offset < s.Size {
+		gaps = append(gaps, ByteRange{Offset: offset, Length: s.Size - offset})
+	}
+	return gaps
+}
+
+// add records that length bytes from offset were transferred
+func (s *TransferState) add(offset, length int64) {
+	s.Done = append(s.Done, ByteRange{Offset: offset, Length: length})
+	slices.SortFunc(s.Done, func(a, b ByteRange) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	merged := s.Done[:1]
+	for _, r := range s.Done[1:] {
+		last := &merged[len(merged)-1]
+		if r.Offset <= last.Offset+last.Length {
+			last.Length = max(last.Length, r.Offset+r.Length-last.Offset)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	s.Done = merged
+}
+
+// resume starts the state over for the given transfer unless it already
+// describes it, and reports whether it did
+func (s *TransferState) resume(remotePath string, size int64, verifier uint64) bool {
+	if s.Path == remotePath && s.Size == size && s.Verifier == verifier {
+		return true
+	}
+	*s = TransferState{Path: remotePath, Size: size, Verifier: verifier}
+	return false
+}
+
+// LoadTransferState reads a state saved with Save. A missing file gives
+// an empty state.
+func LoadTransferState(file string) (*TransferState, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return &TransferState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s TransferState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("transfer state %s: %w", file, err)
+	}
+	return &s, nil
+}
+
+// Save writes the state to file, replacing it atomically
+func (s *TransferState) Save(file string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// FileVerifier derives an upload verifier from a local file's size and
+// modification time
+func FileVerifier(info os.FileInfo) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, info.Size())
+	binary.Write(h, binary.BigEndian, info.ModTime().UnixNano())
+	return h.Sum64()
+}
+
+// attrVerifier derives a download verifier from a remote file's
+// attributes, changing whenever its content may have. The change
+// attribute, where the server reports one, catches writes that leave size
+// and mtime as they were.
+func attrVerifier(attrs *api.FileAttributes) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, attrs.Fileid)
+	binary.Write(h, binary.BigEndian, attrs.Change)
+	binary.Write(h, binary.BigEndian, attrs.Size)
+	binary.Write(h, binary.BigEndian, attrs.GetMtime().GetSeconds())
+	binary.Write(h, binary.BigEndian, attrs.GetMtime().GetNano())
+	return h.Sum64()
+}
+
+// Download copies the file at remotePath to w, skipping the ranges
+// opts.State records as done. If the file changes while it is copied,
+// Download fails with ErrChanged and the next attempt starts over.
+func (c *Client) Download(ctx context.Context, remotePath string, w io.WriterAt, opts TransferOptions) error {
+	return download(ctx, c, remotePath, w, opts)
+}
+
+// download implements Download on top of any client
+func download(ctx context.Context, c NFSClient, remotePath string, w io.WriterAt, opts TransferOptions) error {
+	remotePath = path.Clean("/" + remotePath)
+	handle, err := c.LookupPath(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	attrs, err := c.GetAttr(ctx, handle)
+	if err != nil {
+		return err
+	}
+	if attrs.Type == api.FileType_DIRECTORY {
+		return NewNFSError("Download", api.Status_ERR_ISDIR, remotePath, ErrIsDir)
+	}
+
+	state := opts.State
+	if state == nil {
+		state = &TransferState{}
+	}
+	verifier := attrVerifier(attrs)
+	state.resume(remotePath, int64(attrs.Size), verifier)
+	readSize, _ := chunkSizes(ctx, c, handle)
+
+	t := &transfer{opts: opts, state: state}
+	for _, gap := range state.missing() {
+		for offset := gap.Offset; offset < gap.Offset+gap.Length; {
+			count := min(int64(readSize), gap.Offset+gap.Length-offset)
+			data, eof, err := c.Read(ctx, handle, offset, int(count))
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				if eof {
+					// Shorter than when the download started
+					return fmt.Errorf("%s: %w", remotePath, ErrChanged)
+				}
+				return fmt.Errorf("short read at offset %d", offset)
+			}
+			if _, err := w.WriteAt(data, offset); err != nil {
+				return err
+			}
+			if err := t.advance(offset, int64(len(data))); err != nil {
+				return err
+			}
+			offset += int64(len(data))
+		}
+	}
+
+	// A file changed meanwhile may have been copied partly old, partly
+	// new; its state no longer matches, so the next attempt starts over
+	attrs, err = c.GetAttr(ctx, handle)
+	if err != nil {
+		return err
+	}
+	if attrVerifier(attrs) != verifier {
+		return fmt.Errorf("%s: %w", remotePath, ErrChanged)
+	}
+	t.report()
+	return nil
+}
+
+// Upload writes size bytes from r to the file at remotePath, creating it
+// with mode or truncating it, with FILE_SYNC stability. The ranges
+// opts.State records as done are skipped if it was saved for the same
+// opts.Verifier and the remote file is still the one it was writing.
+func (c *Client) Upload(ctx context.Context, r io.ReaderAt, size int64, remotePath string, mode uint32, opts TransferOptions) error {
+	return upload(ctx, c, r, size, remotePath, mode, opts)
+}
+
+// upload implements Upload on top of any client
+func upload(ctx context.Context, c NFSClient, r io.ReaderAt, size int64, remotePath string, mode uint32, opts TransferOptions) error {
+	remotePath = path.Clean("/" + remotePath)
+	dir, name := path.Split(remotePath)
+	if name == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, remotePath)
+	}
+	dirHandle, err := c.LookupPath(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	state := opts.State
+	if state == nil {
+		state = &TransferState{}
+	}
+	var handle []byte
+	if state.resume(remotePath, size, opts.Verifier) && state.Handle != nil {
+		// Continue only in the file the earlier attempt wrote
+		existing, _, err := c.Lookup(ctx, dirHandle, name)
+		if err == nil && bytes.Equal(existing, state.Handle) {
+			handle = existing
+		}
+	}
+	if handle == nil {
+		handle, _, err = c.Create(ctx, dirHandle, name, &api.FileAttributes{Mode: mode}, api.CreateMode_UNCHECKED)
+		if err != nil {
+			return err
+		}
+		state.Handle = handle
+		state.Done = nil
+	}
+	_, writeSize := chunkSizes(ctx, c, handle)
+
+	// With no progress to report chunk by chunk, each range is streamed
+	if opts.Progress == nil && opts.Checkpoint == nil {
+		for _, gap := range state.missing() {
+			written, err := c.WriteStream(ctx, handle, gap.Offset, io.NewSectionReader(r, gap.Offset, gap.Length), gap.Length, 2) // 2 = FILE_SYNC
+			if written > 0 {
+				state.add(gap.Offset, written)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t := &transfer{opts: opts, state: state}
+	buf := make([]byte, writeSize)
+	for _, gap := range state.missing() {
+		for offset := gap.Offset; offset < gap.Offset+gap.Length; {
+			chunk := buf[:min(int64(writeSize), gap.Offset+gap.Length-offset)]
+			n, err := r.ReadAt(chunk, offset)
+			if n < len(chunk) {
+				if err == nil || err == io.EOF {
+					err = fmt.Errorf("source ended at %d bytes, want %d", offset+int64(n), size)
+				}
+				return err
+			}
+			written, err := c.Write(ctx, handle, offset, chunk, 2) // 2 = FILE_SYNC
+			if err != nil {
+				return err
+			}
+			if written == 0 {
+				return fmt.Errorf("short write at offset %d", offset)
+			}
+			if err := t.advance(offset, int64(written)); err != nil {
+				return err
+			}
+			offset += int64(written)
+		}
+	}
+	t.report()
+	return nil
+}
+
+// transfer reports the progress of a Download or Upload
+type transfer struct {
+	opts  TransferOptions
+	state *TransferState
+}
+
+// advance records a completed chunk and reports it
+func (t *transfer) advance(offset, length int64) error {
+	t.state.add(offset, length)
+	if t.opts.Checkpoint != nil {
+		if err := t.opts.Checkpoint(t.state); err != nil {
+			return err
+		}
+	}
+	t.report()
+	return nil
+}
+
+// report calls the progress callback
+func (t *transfer) report() {
+	if t.opts.Progress != nil {
+		t.opts.Progress(t.state.Completed(), t.state.Size)
+	}
+}
+
+// chunkSizes returns the read and write sizes to use with c
+func chunkSizes(ctx context.Context, c NFSClient, handle []byte) (int, int) {
+	if client, ok := c.(*Client); ok {
+		return client.transferSizes(ctx, handle)
+	}
+	return fileChunkSize, fileChunkSize
+}
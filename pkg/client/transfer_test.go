@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestTransferStateRanges(t *testing.T) {
+	s := &TransferState{Size: 100}
+	s.add(50, 10)
+	s.add(0, 20)
+	s.add(20, 10)
+	s.add(55, 10)
+	want := []ByteRange{{0, 30}, {50, 15}}
+	if !reflect.DeepEqual(s.Done, want) {
+		t.Errorf("Done = %v, want %v", s.Done, want)
+	}
+	if n := s.Completed(); n != 45 {
+		t.Errorf("Completed = %d, want 45", n)
+	}
+	if gaps := s.missing(); !reflect.DeepEqual(gaps, []ByteRange{{30, 20}, {65, 35}}) {
+		t.Errorf("missing = %v", gaps)
+	}
+
+	file := filepath.Join(t.TempDir(), "state")
+	if err := s.Save(file); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadTransferState(file)
+	if err != nil || !reflect.DeepEqual(loaded, s) {
+		t.Errorf("LoadTransferState returned %+v, %v; want %+v", loaded, err, s)
+	}
+	if empty, err := LoadTransferState(file + ".missing"); err != nil || empty.Completed() != 0 {
+		t.Errorf("LoadTransferState of a missing file returned %+v, %v", empty, err)
+	}
+}
+
+// newChunkedClient serves a temporary directory with a server moving 1000
+// bytes per RPC, so transfers take several chunks
+func newChunkedClient(t *testing.T) (*Client, string, context.Context) {
+	tempDir := t.TempDir()
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxReadSize = 1000
+	config.MaxWriteSize = 1000
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return newServiceClient(t, nfsServer), tempDir, WithCredentials(context.Background(), 0, 0)
+}
+
+// errInterrupted stands for a dropped connection in the middle of a transfer
+var errInterrupted = errors.New("interrupted")
+
+// interruptAfter returns a checkpoint failing once chunks chunks are done
+func interruptAfter(chunks int) func(*TransferState) error {
+	return func(*TransferState) error {
+		chunks--
+		if chunks == 0 {
+			return errInterrupted
+		}
+		return nil
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	c, tempDir, ctx := newChunkedClient(t)
+	data := bytes.Repeat([]byte("0123456789"), 1050)
+	if err := os.WriteFile(filepath.Join(tempDir, "big"), data, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatalf("Failed to create output file: %v", err)
+	}
+	defer out.Close()
+
+	state := &TransferState{}
+	err = c.Download(ctx, "/big", out, TransferOptions{State: state, Checkpoint: interruptAfter(3)})
+	if !errors.Is(err, errInterrupted) || state.Completed() != 3000 {
+		t.Fatalf("Interrupted download returned %v with %d bytes done", err, state.Completed())
+	}
+
+	// The second attempt only reads what is missing
+	var reports []int64
+	progress := func(done, total int64) {
+		if total != int64(len(data)) {
+			t.Errorf("Progress reported a total of %d, want %d", total, len(data))
+		}
+		reports = append(reports, done)
+	}
+	if err := c.Download(ctx, "/big", out, TransferOptions{State: state, Progress: progress}); err != nil {
+		t.Fatalf("Resumed download failed: %v", err)
+	}
+	if len(reports) == 0 || reports[0] != 4000 || reports[len(reports)-1] != int64(len(data)) {
+		t.Errorf("Resumed download reported %v, want 4000 up to %d", reports, len(data))
+	}
+	if got, err := os.ReadFile(out.Name()); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Downloaded %d bytes, %v; want the file", len(got), err)
+	}
+
+	// A state for an older version of the file is discarded
+	changed := append(bytes.Clone(data), "more"...)
+	if err := os.WriteFile(filepath.Join(tempDir, "big"), changed, 0644); err != nil {
+		t.Fatalf("Failed to change test file: %v", err)
+	}
+	reports = nil
+	if err := c.Download(ctx, "/big", out, TransferOptions{State: state, Progress: func(done, total int64) {
+		reports = append(reports, done)
+	}}); err != nil {
+		t.Fatalf("Download of the changed file failed: %v", err)
+	}
+	if len(reports) == 0 || reports[0] != 1000 {
+		t.Errorf("Download of the changed file reported %v, want to start over", reports)
+	}
+
+	// A file changed during the download fails it
+	err = c.Download(ctx, "/big", out, TransferOptions{Checkpoint: func(*TransferState) error {
+		return os.WriteFile(filepath.Join(tempDir, "big"), data, 0644)
+	}})
+	if !errors.Is(err, ErrChanged) {
+		t.Errorf("Download of a file changing meanwhile returned %v, want ErrChanged", err)
+	}
+}
+
+func TestUploadResumes(t *testing.T) {
+	c, tempDir, ctx := newChunkedClient(t)
+	data := bytes.Repeat([]byte("abcdefghij"), 1050)
+	source := bytes.NewReader(data)
+
+	state := &TransferState{}
+	opts := TransferOptions{State: state, Verifier: 7, Checkpoint: interruptAfter(4)}
+	if err := c.Upload(ctx, source, int64(len(data)), "/up", 0600, opts); !errors.Is(err, errInterrupted) {
+		t.Fatalf("Interrupted upload returned %v", err)
+	}
+	if state.Handle == nil || state.Completed() != 4000 {
+		t.Fatalf("Interrupted upload left %+v", state)
+	}
+
+	var first int64 = -1
+	opts = TransferOptions{State: state, Verifier: 7, Progress: func(done, total int64) {
+		if first < 0 {
+			first = done
+		}
+	}}
+	if err := c.Upload(ctx, source, int64(len(data)), "/up", 0600, opts); err != nil {
+		t.Fatalf("Resumed upload failed: %v", err)
+	}
+	if first != 5000 {
+		t.Errorf("Resumed upload first reported %d bytes, want 5000", first)
+	}
+	got, err := os.ReadFile(filepath.Join(tempDir, "up"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Uploaded %d bytes, %v; want the data", len(got), err)
+	}
+	if info, err := os.Stat(filepath.Join(tempDir, "up")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Uploaded file has mode %v, %v; want 0600", info.Mode(), err)
+	}
+
+	// A partial file replaced on the server is written again from the start
+	state.Done = state.Done[:0]
+	state.add(0, 4000)
+	if err := os.Rename(filepath.Join(tempDir, "up"), filepath.Join(tempDir, "old")); err != nil {
+		t.Fatalf("Failed to move upload away: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "up"), bytes.Repeat([]byte("x"), 4000), 0644); err != nil {
+		t.Fatalf("Failed to replace upload: %v", err)
+	}
+	if err := c.Upload(ctx, source, int64(len(data)), "/up", 0600, TransferOptions{State: state, Verifier: 7}); err != nil {
+		t.Fatalf("Upload over a replaced file failed: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(tempDir, "up")); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Upload over a replaced file wrote %d bytes, %v; want the data", len(got), err)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Watcher delivers the changes the server reports for a watched directory
+type Watcher struct {
+	events chan *api.ChangeEvent
+	cancel context.CancelFunc
+	err    error
+}
+
+// Events returns the reported changes. The channel is closed when the
+// watch ends, after which Err tells why.
+func (w *Watcher) Events() <-chan *api.ChangeEvent {
+	return w.events
+}
+
+// Err returns the error that broke the watch, or nil if it was closed or
+// its context ended. It must only be called once Events has been closed.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Close ends the watch
+func (w *Watcher) Close() {
+	w.cancel()
+}
+
+// Watch subscribes to the changes made through the server below the
+// directory dirHandle. Changes made to the exported tree by other means
+// are not reported. Events arrive in the order the server applied them;
+// a CHANGE_OVERFLOW event means some were dropped because the caller fell
+// behind. Servers that do not support watches report ErrNotImplemented.
+func (c *Client) Watch(ctx context.Context, dirHandle []byte, recursive bool) (*Watcher, error) {
+	req := &api.WatchRequest{
+		DirectoryHandle: dirHandle,
+		Recursive:       recursive,
+		Credentials:     credentialsFromContext(ctx),
+	}
+
+	// The stream lives until ctx is done or the watcher is closed
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := c.nfsClient.Watch(streamCtx, req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Watch RPC failed: %w", err)
+	}
+
+	// Bound the wait for the confirmation by the usual timeout
+	timer := time.AfterFunc(c.config.Timeout, cancel)
+	first, err := stream.Recv()
+	timer.Stop()
+	if status.Code(err) == codes.Unimplemented {
+		cancel()
+		return nil, NewNFSError("Watch", api.Status_ERR_NOTSUPP, "operation not supported", ErrNotImplemented)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("Watch RPC failed: %w", err)
+	}
+	if first.Status != api.Status_OK {
+		cancel()
+		return nil, StatusToError("Watch", first.Status)
+	}
+
+	w := &Watcher{
+		events: make(chan *api.ChangeEvent),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(w.events)
+		defer cancel()
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				// Closing the watcher or ending ctx is not an error
+				if err != io.EOF && streamCtx.Err() == nil {
+					w.err = fmt.Errorf("Watch stream failed: %w", err)
+				}
+				return
+			}
+			select {
+			case w.events <- event:
+			case <-streamCtx.Done():
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+func TestWatch(t *testing.T) {
+	c, _, ctx := newLocalClient(t)
+
+	rootHandle, err := c.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+
+	w, err := c.Watch(ctx, rootHandle, true)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	dirHandle, _, err := c.Mkdir(ctx, rootHandle, "obj", &api.FileAttributes{Mode: 0755})
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := c.Create(ctx, dirHandle, "main.o", nil, api.CreateMode_GUARDED); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	for _, want := range []string{"/obj", "/obj/main.o"} {
+		select {
+		case event := <-w.Events():
+			if event.Type != api.ChangeType_CHANGE_CREATE || event.Path != want {
+				t.Errorf("Event = %v %s, want CHANGE_CREATE %s", event.Type, event.Path, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No event for %s", want)
+		}
+	}
+
+	// Closing the watcher ends the stream without an error
+	w.Close()
+	select {
+	case _, open := <-w.Events():
+		if open {
+			t.Error("Unexpected event after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events not closed after Close")
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err after Close = %v, want nil", err)
+	}
+}
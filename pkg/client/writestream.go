@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WriteStream writes the first size bytes of r to the file at offset in
+// chunks of the server's preferred write size, all sent on one WriteStream
+// RPC. Against servers without WriteStream each chunk is sent as a Write.
+// The stream is not retried, and is bounded only by ctx.
+func (c *Client) WriteStream(ctx context.Context, fileHandle []byte, offset int64, r io.ReaderAt, size int64, stability int) (int64, error) {
+	if stability < 0 || stability > 2 {
+		stability = 0 // Default to UNSTABLE if invalid
+	}
+	_, chunkSize := c.transferSizes(ctx, fileHandle)
+	if c.noWriteStream.Load() {
+		return writeChunks(ctx, c, fileHandle, offset, r, size, stability, chunkSize)
+	}
+
+	// Cached blocks are of the version before this write
+	c.forgetVersion(fileHandle)
+
+	start := time.Now()
+	creds := credentialsOrDefault(ctx, &api.Credentials{Groups: []uint32{0}})
+	resp, err := c.sendWriteStream(ctx, fileHandle, offset, r, size, stability, chunkSize, creds)
+	c.metrics.observeStream(c.target(), "WriteStream", resp, err, time.Since(start))
+	if status.Code(err) == codes.Unimplemented {
+		c.noWriteStream.Store(true)
+		return writeChunks(ctx, c, fileHandle, offset, r, size, stability, chunkSize)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("WriteStream RPC failed: %w", err)
+	}
+	if resp.Count > 0 && resp.Stability == 0 {
+		c.pending.wrote(fileHandle, resp.Verifier, creds)
+	}
+	if resp.Status != api.Status_OK {
+		return int64(resp.Count), StatusToError("WriteStream", resp.Status)
+	}
+	if int64(resp.Count) < size {
+		return int64(resp.Count), fmt.Errorf("short write: %d of %d bytes", resp.Count, size)
+	}
+	return int64(resp.Count), nil
+}
+
+// sendWriteStream sends size bytes of r in chunks on a WriteStream and
+// returns the server's response
+func (c *Client) sendWriteStream(ctx context.Context, fileHandle []byte, offset int64, r io.ReaderAt, size int64, stability, chunkSize int, creds *api.Credentials) (*api.WriteStreamResponse, error) {
+	// Canceling abandons the stream on failure
+	ctx, cancel := context.WithCancel(withRequestID(ctx))
+	defer cancel()
+
+	stream, err := c.nfsClient.WriteStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, chunkSize)
+	for pos := int64(0); pos < size; {
+		chunk := buf[:min(int64(chunkSize), size-pos)]
+		n, err := r.ReadAt(chunk, pos)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("source ended at %d bytes, want %d", pos+int64(n), size)
+			}
+			return nil, err
+		}
+		req := &api.WriteStreamRequest{
+			Credentials: creds,
+			Offset:      uint64(offset + pos),
+			Data:        chunk,
+		}
+		if pos == 0 {
+			req.FileHandle = fileHandle
+			req.Stability = uint32(stability)
+			req.FenceEpoch = fenceFromContext(ctx)
+		}
+		if err := stream.Send(req); err == io.EOF {
+			// The server stopped reading; its response says why
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pos += int64(n)
+	}
+	return stream.CloseAndRecv()
+}
+
+// writeChunks writes the first size bytes of r to the file at offset with
+// a Write of at most chunkSize bytes each, for clients and servers without
+// WriteStream
+func writeChunks(ctx context.Context, c NFSClient, fileHandle []byte, offset int64, r io.ReaderAt, size int64, stability, chunkSize int) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var pos int64
+	for pos < size {
+		chunk := buf[:min(int64(chunkSize), size-pos)]
+		n, err := r.ReadAt(chunk, pos)
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("source ended at %d bytes, want %d", pos+int64(n), size)
+			}
+			return pos, err
+		}
+		written, err := c.Write(ctx, fileHandle, offset+pos, chunk, stability)
+		pos += int64(written)
+		if err != nil {
+			return pos, err
+		}
+		if written == 0 {
+			return pos, fmt.Errorf("short write at offset %d", offset+pos)
+		}
+	}
+	return pos, nil
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withoutWriteStream is a server predating WriteStream
+type withoutWriteStream struct {
+	api.NFSServiceServer
+}
+
+func (withoutWriteStream) WriteStream(api.NFSService_WriteStreamServer) error {
+	return status.Error(codes.Unimplemented, "unknown method WriteStream")
+}
+
+func TestWriteStream(t *testing.T) {
+	tempDir := t.TempDir()
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxWriteSize = 8
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := WithCredentials(context.Background(), 0, 0)
+	data := []byte("streamed in chunks of eight bytes")
+
+	for _, tc := range []struct {
+		name string
+		svc  api.NFSServiceServer
+	}{
+		{"Stream", nfsServer},
+		{"Fallback", withoutWriteStream{nfsServer}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newServiceClient(t, tc.svc)
+			c.readSize, c.writeSize = 8, 8
+			root, err := c.GetRootFileHandle(ctx)
+			if err != nil {
+				t.Fatalf("GetRootFileHandle failed: %v", err)
+			}
+			handle, _, err := c.Create(ctx, root, tc.name, &api.FileAttributes{Mode: 0644}, api.CreateMode_UNCHECKED)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+
+			// Every chunk lands at its offset, past the first byte
+			written, err := c.WriteStream(ctx, handle, 1, bytes.NewReader(data), int64(len(data)), 2)
+			if err != nil || written != int64(len(data)) {
+				t.Fatalf("WriteStream = %d, %v, want %d bytes", written, err, len(data))
+			}
+			got, err := os.ReadFile(filepath.Join(tempDir, tc.name))
+			if err != nil || !bytes.Equal(got, append([]byte{0}, data...)) {
+				t.Errorf("File holds %q (%v), want %q after a zero byte", got, err, data)
+			}
+			if fallback := c.noWriteStream.Load(); fallback != (tc.name == "Fallback") {
+				t.Errorf("Fell back to Write = %v", fallback)
+			}
+		})
+	}
+
+	// The stream stops at the first chunk the server refuses, reporting
+	// what came before it
+	c := newServiceClient(t, nfsServer)
+	c.readSize, c.writeSize = 8, 8
+	root, err := c.GetRootFileHandle(ctx)
+	if err != nil {
+		t.Fatalf("GetRootFileHandle failed: %v", err)
+	}
+	dir, _, err := c.Mkdir(ctx, root, "dir", &api.FileAttributes{Mode: 0755})
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	written, err := c.WriteStream(ctx, dir, 0, strings.NewReader("a directory"), 11, 2)
+	if err == nil || written != 0 {
+		t.Errorf("WriteStream to a directory = %d, %v, want an error", written, err)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// Client registers the settings of a command talking to an NFS server on
+// s. They fill cfg, which supplies the defaults, and keyFile, the file
+// holding the encryption key, which the command loads itself.
+func Client(s *Set, cfg *client.Config, keyFile *string) {
+	s.String(&cfg.ServerAddress, "server", "NFS server address")
+	s.String(&cfg.Export, "export", "Export to use on servers with several (empty = the server's default)")
+	s.Duration(&cfg.Timeout, "timeout", "Timeout for each RPC, e.g. 30s")
+	s.Int(&cfg.MaxRetries, "max-retries", "Most times a failed RPC is retried")
+	s.Duration(&cfg.RetryDelay, "retry-delay", "Delay before the first retry, doubled for each further one")
+	s.Duration(&cfg.CloseTimeout, "close-timeout", "How long closing the client spends committing unstable writes")
+	s.Duration(&cfg.CacheTTL, "cache-ttl", "How long resolved file handles are cached")
+	s.List(&cfg.ReplicaAddresses, "replicas", "Comma-separated mirror servers to read from when the primary reports an I/O error")
+	s.Bool(&cfg.SortedReadDir, "sorted-readdir", "List directories sorted by name from a snapshot taken when the listing starts")
+	s.String(&cfg.AuthTokenFile, "auth-token-file", "File holding the bearer token sent to servers establishing identity from tokens, reread when it changes")
+	s.Size(&cfg.MaxTransferSize, "max-transfer", "Largest read or write sent in one RPC, below the server's sizes (0 = 64MiB)")
+	Keepalive(s, &cfg.KeepaliveTime, &cfg.KeepaliveTimeout)
+	Balancing(s, &cfg.ResolveInterval, &cfg.LoadBalancing)
+	Encryption(s, keyFile, &cfg.EncryptNames)
+	DiskCache(s, &cfg.DiskCacheDir, &cfg.DiskCacheSize)
+
+	s.Check(func() error {
+		if cfg.ServerAddress == "" {
+			return errors.New("-server must not be empty")
+		}
+		if cfg.Timeout <= 0 {
+			return errors.New("-timeout must be positive")
+		}
+		return errors.Join(
+			AtLeast("max-retries", cfg.MaxRetries, 0),
+			AtLeast("max-transfer", cfg.MaxTransferSize, 0),
+			SizeAtMost("max-transfer", cfg.MaxTransferSize, nfs.MaxTransferSize, "the largest transfer supported"),
+		)
+	})
+}
+
+// minClientKeepalive is the shortest keepalive interval gRPC clients use;
+// shorter ones are silently raised to it
+const minClientKeepalive = 10 * time.Second
+
+// Keepalive registers the keepalive settings shared by Client and the FUSE
+// client's settings
+func Keepalive(s *Set, keepaliveTime *time.Duration, keepaliveTimeout *time.Duration) {
+	s.Duration(keepaliveTime, "keepalive", "Ping the server after this long without activity to detect a dead connection (0 = no pings; the server disconnects clients pinging more often than its -keepalive-min-time)")
+	s.Duration(keepaliveTimeout, "keepalive-timeout", "Close the connection if a keepalive ping goes unanswered this long")
+
+	s.Check(func() error {
+		return keepaliveConsistent("keepalive", *keepaliveTime, *keepaliveTimeout, minClientKeepalive)
+	})
+}
+
+// Balancing registers the settings spreading requests over the servers a
+// dns:/// address resolves to, shared by Client and the FUSE client's
+// settings
+func Balancing(s *Set, resolveInterval *time.Duration, loadBalancing *string) {
+	s.Duration(resolveInterval, "resolve-interval", "How often a dns:///name:port -server is looked up again to find servers added or removed (0 = only when a connection fails)")
+	s.String(loadBalancing, "lb", "How requests are spread over the servers -server resolves to: pick_first or round_robin")
+
+	s.Check(func() error {
+		if *resolveInterval < 0 {
+			return fmt.Errorf("-resolve-interval must not be negative, got %v", *resolveInterval)
+		}
+		if err := client.ValidLoadBalancing(*loadBalancing); err != nil {
+			return fmt.Errorf("-lb: %w", err)
+		}
+		return nil
+	})
+}
+
+// Encryption registers the client-side encryption settings shared by
+// Client and the FUSE client's settings
+func Encryption(s *Set, keyFile *string, encryptNames *bool) {
+	s.String(keyFile, "key-file", "Encrypt file contents on the client with the 32-byte key in this file")
+	s.Bool(encryptNames, "encrypt-names", "Encrypt file names too (requires -key-file)")
+
+	s.Check(func() error {
+		if *encryptNames && *keyFile == "" {
+			return errors.New("-encrypt-names requires -key-file")
+		}
+		return nil
+	})
+}
+
+// DiskCache registers the on-disk read cache settings shared by Client and
+// the FUSE client's settings
+func DiskCache(s *Set, dir *string, size *int) {
+	s.String(dir, "disk-cache", "Keep recently read blocks in this directory, shared by clients on the host and kept across remounts (empty = disabled)")
+	s.Size(size, "disk-cache-size", "Most bytes the disk cache holds before the least recently used blocks are evicted")
+
+	s.Check(func() error {
+		return AtLeast("disk-cache-size", *size, 1)
+	})
+}
@@ -0,0 +1,319 @@
+// Package config defines, loads and validates the settings of the server,
+// client and FUSE commands. Every setting can be given, in increasing order
+// of precedence, in a configuration file, in an environment variable or as
+// a command-line flag; anything left unset keeps its default.
+//
+// A configuration file holds one "name = value" line per setting, named
+// like the flag without its dash. Blank lines and lines starting with # are
+// ignored. The environment variable of a setting is the command's prefix
+// followed by the upper-cased name with dashes turned into underscores, so
+// -max-read of the server is NFS_SERVER_MAX_READ.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// setting is one registered configuration value
+type setting struct {
+	name  string
+	kind  string // Shown in usage, e.g. "duration"
+	usage string
+	value flag.Value
+	def   string // Default, as it would be written in a file
+}
+
+// Set is the collection of settings one command accepts
+type Set struct {
+	name     string
+	prefix   string
+	flags    *flag.FlagSet
+	settings []*setting
+	byName   map[string]*setting
+	checks   []func() error
+
+	// Configuration file, from -config or the environment
+	file string
+
+	// Print the effective settings instead of running (-print-config)
+	print bool
+
+	// Header printed before the settings in usage (default "Usage of <name>:")
+	Header string
+}
+
+// NewSet returns an empty set for the command called name, whose
+// environment variables start with envPrefix. Every set accepts -config,
+// naming the configuration file (<envPrefix>_CONFIG does the same), and
+// -print-config.
+func NewSet(name string, envPrefix string) *Set {
+	s := &Set{
+		name:   name,
+		prefix: envPrefix,
+		flags:  flag.NewFlagSet(name, flag.ContinueOnError),
+		byName: make(map[string]*setting),
+	}
+	s.flags.SetOutput(io.Discard)
+	s.flags.StringVar(&s.file, "config", "", "")
+	s.flags.BoolVar(&s.print, "print-config", false, "")
+	return s
+}
+
+// add registers a setting whose current value is its default
+func (s *Set) add(name string, kind string, value flag.Value, usage string) {
+	if _, dup := s.byName[name]; dup || name == "config" || name == "print-config" {
+		panic("config: setting " + name + " registered twice")
+	}
+	st := &setting{name: name, kind: kind, usage: usage, value: value, def: value.String()}
+	s.settings = append(s.settings, st)
+	s.byName[name] = st
+	s.flags.Var(value, name, usage)
+}
+
+// String registers a string setting defaulting to *p
+func (s *Set) String(p *string, name string, usage string) {
+	s.add(name, "string", stringValue{p}, usage)
+}
+
+// Int registers an integer setting defaulting to *p
+func (s *Set) Int(p *int, name string, usage string) {
+	s.add(name, "int", intValue{p}, usage)
+}
+
+// Uint32 registers an unsigned 32-bit setting, such as a uid, defaulting to *p
+func (s *Set) Uint32(p *uint32, name string, usage string) {
+	s.add(name, "uint32", uint32Value{p}, usage)
+}
+
+// Float64 registers a floating-point setting defaulting to *p
+func (s *Set) Float64(p *float64, name string, usage string) {
+	s.add(name, "float", floatValue{p}, usage)
+}
+
+// Bool registers a boolean setting defaulting to *p. On the command line
+// it may be given without a value to mean true.
+func (s *Set) Bool(p *bool, name string, usage string) {
+	s.add(name, "bool", boolValue{p}, usage)
+}
+
+// Duration registers a duration setting defaulting to *p (see ParseDuration)
+func (s *Set) Duration(p *time.Duration, name string, usage string) {
+	s.add(name, "duration", durationValue{p}, usage)
+}
+
+// Size registers a byte count setting defaulting to *p (see ParseSize)
+func (s *Set) Size(p *int, name string, usage string) {
+	s.add(name, "size", sizeValue{p}, usage)
+}
+
+// List registers a comma-separated list setting defaulting to *p
+func (s *Set) List(p *[]string, name string, usage string) {
+	s.add(name, "list", listValue{p}, usage)
+}
+
+// Check adds a validation run by Load once every source has been applied
+func (s *Set) Check(check func() error) {
+	s.checks = append(s.checks, check)
+}
+
+// EnvName returns the environment variable of the setting called name
+func (s *Set) EnvName(name string) string {
+	return s.prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load parses the command line in args, then fills every setting it did not
+// give from the environment or, failing that, the configuration file, and
+// runs the checks. Errors name the source of the offending value. -help
+// returns flag.ErrHelp after printing usage to stderr, and -print-config
+// does the same after writing the settings to stdout with WriteFile.
+func (s *Set) Load(args []string) error {
+	if err := s.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			s.Usage(os.Stderr)
+			return err
+		}
+		return s.flagError(err)
+	}
+	fromFlags := make(map[string]bool)
+	s.flags.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+
+	// The file first, so the environment overrides it
+	file := s.file
+	if file == "" {
+		file = os.Getenv(s.EnvName("config"))
+	}
+	if file != "" {
+		if err := s.loadFile(file, fromFlags); err != nil {
+			return err
+		}
+	}
+
+	for _, st := range s.settings {
+		if fromFlags[st.name] {
+			continue
+		}
+		env := s.EnvName(st.name)
+		if value, ok := os.LookupEnv(env); ok {
+			if err := st.value.Set(value); err != nil {
+				return fmt.Errorf("%s: invalid value %q: %v", env, value, err)
+			}
+		}
+	}
+
+	for _, check := range s.checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	if s.print {
+		if err := s.WriteFile(os.Stdout); err != nil {
+			return err
+		}
+		return flag.ErrHelp
+	}
+	return nil
+}
+
+// flagError points at -help for flags that do not exist
+func (s *Set) flagError(err error) error {
+	if name, ok := strings.CutPrefix(err.Error(), "flag provided but not defined: "); ok {
+		return fmt.Errorf("unknown flag %s (see %s -help)", name, s.name)
+	}
+	return err
+}
+
+// loadFile applies the settings in the configuration file at path, except
+// those given on the command line
+func (s *Set) loadFile(path string, skip map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("configuration file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: want name = value", path, line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		st, known := s.byName[name]
+		if !known {
+			return fmt.Errorf("%s:%d: unknown setting %q (see %s -help)", path, line, name, s.name)
+		}
+		if skip[name] {
+			continue
+		}
+		if err := st.value.Set(unquote(value)); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, line, value, name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("configuration file: %w", err)
+	}
+	return nil
+}
+
+// unquote strips one pair of double quotes, so empty strings and values
+// with leading spaces can be written
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Args returns the command-line arguments left after the flags
+func (s *Set) Args() []string {
+	return s.flags.Args()
+}
+
+// Usage writes the settings with their kinds, defaults and environment
+// variables to w
+func (s *Set) Usage(w io.Writer) {
+	header := s.Header
+	if header == "" {
+		header = fmt.Sprintf("Usage of %s:", s.name)
+	}
+	fmt.Fprintln(w, header)
+	fmt.Fprintf(w, "  -config file\n    \tRead settings from file, one name = value per line (env %s)\n", s.EnvName("config"))
+	fmt.Fprintf(w, "  -print-config\n    \tPrint the effective settings as a configuration file and exit\n")
+	for _, st := range s.settings {
+		kind := " " + st.kind
+		if st.kind == "bool" {
+			kind = ""
+		}
+		fmt.Fprintf(w, "  -%s%s\n    \t%s (", st.name, kind, st.usage)
+		if st.def != "" && st.def != "0" && st.def != "false" && st.def != "0s" {
+			fmt.Fprintf(w, "default %s; ", st.def)
+		}
+		fmt.Fprintf(w, "env %s)\n", s.EnvName(st.name))
+	}
+}
+
+// WriteFile writes every setting with its current value to w in the
+// configuration file format, each preceded by its description
+func (s *Set) WriteFile(w io.Writer) error {
+	for i, st := range s.settings {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		value := st.value.String()
+		if value == "" || strings.TrimSpace(value) != value {
+			value = `"` + value + `"`
+		}
+		if _, err := fmt.Fprintf(w, "# %s (%s)\n%s = %s\n", st.usage, st.kind, st.name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtLeast returns an error unless the setting called name, whose value is
+// value, is at least min
+func AtLeast(name string, value int, min int) error {
+	if value < min {
+		return fmt.Errorf("-%s must be at least %d, got %d", name, min, value)
+	}
+	return nil
+}
+
+// SizeAtMost returns an error unless the size setting called name is at
+// most max bytes, saying why with reason
+func SizeAtMost(name string, value int, max int, reason string) error {
+	if value > max {
+		return fmt.Errorf("-%s must be at most %s %s, got %s", name, FormatSize(int64(max)), reason, FormatSize(int64(value)))
+	}
+	return nil
+}
+
+// keepaliveConsistent returns an error unless the keepalive interval of the
+// setting called name is zero, meaning disabled, or at least min, and its
+// timeout is positive and shorter than the interval
+func keepaliveConsistent(name string, interval time.Duration, timeout time.Duration, min time.Duration) error {
+	if interval == 0 {
+		return nil
+	}
+	if interval < min {
+		return fmt.Errorf("-%s must be 0 or at least %v, got %v", name, min, interval)
+	}
+	if timeout <= 0 || timeout >= interval {
+		return fmt.Errorf("-%s-timeout must be positive and shorter than -%s %v, got %v", name, name, interval, timeout)
+	}
+	return nil
+}
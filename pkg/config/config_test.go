@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs/cachingproxy"
+	"github.com/example/nfsserver/pkg/logging"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"4096", 4096, false},
+		{"64KiB", 64 << 10, false},
+		{"1MiB", 1 << 20, false},
+		{"1m", 1 << 20, false},
+		{"2 GiB", 2 << 30, false},
+		{"1MB", 1000 * 1000, false},
+		{"10B", 10, false},
+		{"", 0, true},
+		{"1.5MiB", 0, true},
+		{"-1", 0, true},
+		{"12XB", 0, true},
+		{"99999999999TiB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	for _, n := range []int64{0, 1000, 4096, 1 << 20, 3<<30 + 1<<20} {
+		if got, err := ParseSize(FormatSize(n)); err != nil || got != n {
+			t.Errorf("ParseSize(FormatSize(%d)) = %d, %v", n, got, err)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30s", 30 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"45", 45 * time.Second, false}, // Old integer flags meant seconds
+		{"-5s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// writeConfig writes content to a configuration file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nfs.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write configuration file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, `
+# Settings for the test
+max-read = 256KiB
+max-write = 512KiB
+timeout = 10s
+warm = /a, /b
+export-name = "  spaced  "
+`)
+	t.Setenv("NFS_SERVER_MAX_WRITE", "128KiB")
+	t.Setenv("NFS_SERVER_TIMEOUT", "20s")
+
+	cfg := server.DefaultConfig()
+	root := "/srv/export"
+	s := NewSet("nfs-server", "NFS_SERVER")
+	Server(s, cfg, &root, new(string))
+	if err := s.Load([]string{"-config", file, "-timeout", "1m", "extra"}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.MaxReadSize != 256<<10 {
+		t.Errorf("max-read from the file is %d, want %d", cfg.MaxReadSize, 256<<10)
+	}
+	if cfg.MaxWriteSize != 128<<10 {
+		t.Errorf("max-write from the environment is %d, want %d", cfg.MaxWriteSize, 128<<10)
+	}
+	if cfg.RequestTimeout != time.Minute {
+		t.Errorf("timeout from the command line is %v, want 1m", cfg.RequestTimeout)
+	}
+	if len(cfg.WarmPaths) != 2 || cfg.WarmPaths[0] != "/a" || cfg.WarmPaths[1] != "/b" {
+		t.Errorf("warm is %q, want [/a /b]", cfg.WarmPaths)
+	}
+	if cfg.ExportName != "  spaced  " {
+		t.Errorf("export-name is %q, want the quoted value", cfg.ExportName)
+	}
+	if cfg.MaxConcurrent != 100 || root != "/srv/export" {
+		t.Errorf("Unset settings changed to %d, %q", cfg.MaxConcurrent, root)
+	}
+	if args := s.Args(); len(args) != 1 || args[0] != "extra" {
+		t.Errorf("Args returned %q, want [extra]", args)
+	}
+
+	// The file can also be named by the environment
+	t.Setenv("NFS_SERVER_CONFIG", file)
+	cfg = server.DefaultConfig()
+	s = NewSet("nfs-server", "NFS_SERVER")
+	Server(s, cfg, &root, new(string))
+	if err := s.Load(nil); err != nil || cfg.MaxReadSize != 256<<10 {
+		t.Errorf("Load with NFS_SERVER_CONFIG returned %v, max-read %d", err, cfg.MaxReadSize)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"Bad size on the command line", "", nil, []string{"-max-read", "lots"}, "want a size like"},
+		{"Unknown flag", "", nil, []string{"-max-reed", "1"}, "unknown flag -max-reed"},
+		{"Bad value in the file", "\n\ntimeout = forever\n", nil, nil, "nfs.conf:3: invalid value \"forever\" for timeout"},
+		{"Unknown setting in the file", "max-reed = 1MiB\n", nil, nil, "nfs.conf:1: unknown setting \"max-reed\""},
+		{"Line without a value", "verify-writes\n", nil, nil, "nfs.conf:1: want name = value"},
+		{"Bad value in the environment", "", map[string]string{"NFS_SERVER_ANON_UID": "-1"}, nil, "NFS_SERVER_ANON_UID: invalid value"},
+		{"Read size above the transfer limit", "", nil, []string{"-max-read", "128MiB"}, "-max-read must be at most 64MiB"},
+		{"Keepalive too frequent", "", nil, []string{"-keepalive", "100ms"}, "-keepalive must be 0 or at least 1s"},
+		{"Keepalive timeout past the next ping", "", nil, []string{"-keepalive", "10s", "-keepalive-timeout", "1m"}, "-keepalive-timeout must be positive and shorter than -keepalive 10s"},
+		{"No keepalive minimum", "", nil, []string{"-keepalive-min-time", "0"}, "-keepalive-min-time must be positive"},
+		{"No workers", "", nil, []string{"-max-concurrent", "0"}, "-max-concurrent must be at least 1, got 0"},
+		{"Shared address", "", nil, []string{"-admin-listen", ":2049"}, "-admin-listen and -listen are both :2049"},
+		{"Missing file", "", nil, []string{"-config", "/nonexistent/nfs.conf"}, "configuration file"},
+		{"Bad client", "", nil, []string{"-allowed-clients", "10.0.0.0/8,nowhere"}, "-allowed-clients"},
+		{"Bad rule", "", nil, []string{"-access", "allow 10.0.0.0/8,permit all"}, "-access"},
+		{"Unknown backend", "", nil, []string{"-backend", "tmpfs"}, "-backend must be local, memfs or proxy"},
+		{"Proxy without upstream", "", nil, []string{"-backend", "proxy"}, "-backend proxy needs -upstream"},
+		{"Upstream on disk", "", nil, []string{"-upstream", "origin:2049"}, "-upstream needs -backend proxy"},
+		{"Seed on disk", "", nil, []string{"-seed", "./fixture"}, "-seed needs -backend memfs"},
+		{"Unknown log level", "", nil, []string{"-log-level", "chatty"}, "unknown log level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			root := "/srv/export"
+			s := NewSet("nfs-server", "NFS_SERVER")
+			Server(s, server.DefaultConfig(), &root, new(string))
+			backend, seed := "local", ""
+			Backend(s, &backend, &seed)
+			upstream, proxyOptions := client.Config{DiskCacheSize: 1}, cachingproxy.DefaultOptions()
+			Proxy(s, &backend, &upstream, &proxyOptions)
+			logOptions := logging.DefaultOptions()
+			Logging(s, &logOptions)
+			err := s.Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load returned %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteFileRoundTrip(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.MaxReadSize = 512 << 10
+	cfg.WarmPaths = []string{"/home", "/srv"}
+	cfg.RequestTimeout = 90 * time.Second
+	root := "/srv/export"
+	s := NewSet("nfs-server", "NFS_SERVER")
+	Server(s, cfg, &root, new(string))
+
+	var buf bytes.Buffer
+	if err := s.WriteFile(&buf); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	var usage bytes.Buffer
+	s.Usage(&usage)
+	if !strings.Contains(usage.String(), "NFS_SERVER_MAX_READ") {
+		t.Errorf("Usage does not name the environment variables:\n%s", usage.String())
+	}
+
+	// Loading the written file into defaults reproduces the settings
+	loaded := server.DefaultConfig()
+	loadedRoot := ""
+	s = NewSet("nfs-server", "NFS_SERVER")
+	Server(s, loaded, &loadedRoot, new(string))
+	if err := s.Load([]string{"-config", writeConfig(t, buf.String())}); err != nil {
+		t.Fatalf("Load of the written file failed: %v\n%s", err, buf.String())
+	}
+	if loaded.MaxReadSize != cfg.MaxReadSize || loaded.RequestTimeout != cfg.RequestTimeout ||
+		strings.Join(loaded.WarmPaths, ",") != "/home,/srv" || loadedRoot != root || loaded.ExportName != "" {
+		t.Errorf("Loaded settings differ from the written ones:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// Export is one line of an exports file:
+//
+//	name root [ro|rw] [root_squash|no_root_squash] [clients=addr,prefix,...]
+//	    [allow=addr|prefix|all ...] [deny=addr|prefix|all ...]
+//	    [hide=pattern,...] [hide_dotfiles|show_dotfiles] [lower=dir,...]
+//
+// allow= and deny= options are access rules, applied in the order given.
+// hide= patterns name the entries hidden from every user but root.
+// lower= directories are served read-only under root, the topmost first,
+// which then holds only the changes made to them.
+// Options left out follow the server's settings. Blank lines and lines
+// starting with # are ignored.
+type Export struct {
+	Name           string
+	Root           string
+	ReadOnly       bool
+	RootSquash     bool
+	AllowedClients []string
+	AccessRules    []string
+	HiddenNames    []string
+	HideDotfiles   bool
+	Lowers         []string
+}
+
+// LoadExports reads the exports file at path; base supplies the options
+// lines leave out
+func LoadExports(path string, base *server.Config) ([]Export, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exports, err := ParseExports(f, base)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return exports, nil
+}
+
+// ParseExports parses an exports file; base supplies the options lines
+// leave out
+func ParseExports(r io.Reader, base *server.Config) ([]Export, error) {
+	var exports []Export
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want \"name root [options]\"", lineNo)
+		}
+		e := Export{
+			Name:           fields[0],
+			Root:           fields[1],
+			ReadOnly:       base.ReadOnly,
+			RootSquash:     base.EnableRootSquash,
+			AllowedClients: base.AllowedClients,
+			AccessRules:    base.AccessRules,
+			HiddenNames:    base.HiddenNames,
+			HideDotfiles:   base.HideDotfiles,
+		}
+		if !validExportName(e.Name) {
+			return nil, fmt.Errorf("line %d: export name %q may only hold letters, digits, '.', '_' and '-'", lineNo, e.Name)
+		}
+		if seen[e.Name] {
+			return nil, fmt.Errorf("line %d: export %q defined twice", lineNo, e.Name)
+		}
+		seen[e.Name] = true
+		var rules []string
+		for _, opt := range fields[2:] {
+			switch {
+			case opt == "ro":
+				e.ReadOnly = true
+			case opt == "rw":
+				e.ReadOnly = false
+			case opt == "root_squash":
+				e.RootSquash = true
+			case opt == "no_root_squash":
+				e.RootSquash = false
+			case strings.HasPrefix(opt, "clients="):
+				e.AllowedClients = strings.Split(strings.TrimPrefix(opt, "clients="), ",")
+				if _, err := server.ParseClients(e.AllowedClients); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+			case strings.HasPrefix(opt, "allow="), strings.HasPrefix(opt, "deny="):
+				verb, clients, _ := strings.Cut(opt, "=")
+				rule := verb + " " + clients
+				if _, err := server.ParseAccessRules([]string{rule}); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				rules = append(rules, rule)
+			case strings.HasPrefix(opt, "hide="):
+				e.HiddenNames = strings.Split(strings.TrimPrefix(opt, "hide="), ",")
+				if err := server.CheckHiddenNames(e.HiddenNames); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+			case opt == "hide_dotfiles":
+				e.HideDotfiles = true
+			case opt == "show_dotfiles":
+				e.HideDotfiles = false
+			case strings.HasPrefix(opt, "lower="):
+				e.Lowers = strings.Split(strings.TrimPrefix(opt, "lower="), ",")
+				if slices.Contains(e.Lowers, "") {
+					return nil, fmt.Errorf("line %d: empty lower directory", lineNo)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown export option %q", lineNo, opt)
+			}
+		}
+		if rules != nil {
+			e.AccessRules = rules
+		}
+		exports = append(exports, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// validExportName reports whether name is fit to name an export and the
+// files kept for it
+func validExportName(name string) bool {
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return name != "" && name != "." && name != ".."
+}
+
+// ServerConfig returns the configuration of the export, derived from base:
+// its options replace base's, and its persistent files sit beside base's
+// under the export's name. Directories to warm apply to the default export
+// only.
+func (e Export) ServerConfig(base *server.Config) *server.Config {
+	cfg := *base
+	cfg.ExportName = e.Name
+	cfg.ReadOnly = e.ReadOnly
+	cfg.EnableRootSquash = e.RootSquash
+	cfg.AllowedClients = e.AllowedClients
+	cfg.AccessRules = e.AccessRules
+	cfg.HiddenNames = e.HiddenNames
+	cfg.HideDotfiles = e.HideDotfiles
+	cfg.WarmPaths = nil
+	cfg.Shadow = nil
+	if base.IndexDir != "" {
+		cfg.IndexDir = filepath.Join(base.IndexDir, e.Name)
+	}
+	if base.HandleDumpFile != "" {
+		cfg.HandleDumpFile = base.HandleDumpFile + "." + e.Name
+	}
+	if base.UsageFile != "" {
+		cfg.UsageFile = base.UsageFile + "." + e.Name
+	}
+	if base.RequestCacheFile != "" {
+		cfg.RequestCacheFile = base.RequestCacheFile + "." + e.Name
+	}
+	return &cfg
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/server"
+)
+
+func TestParseExports(t *testing.T) {
+	base := server.DefaultConfig()
+	base.EnableRootSquash = true
+	base.IndexDir = "/var/lib/nfs/index"
+	base.UsageFile = "/var/lib/nfs/usage"
+	base.RequestCacheFile = "/var/lib/nfs/requests"
+	base.WarmPaths = []string{"/home"}
+
+	file := `# name root options
+home /srv/home
+media  /srv/media  ro no_root_squash clients=10.0.0.0/8,192.168.1.7
+lab /srv/lab deny=10.1.2.3 allow=10.1.0.0/16 deny=all
+scratch /srv/scratch hide=lost+found,*.tmp hide_dotfiles
+tenant-a /srv/tenants/a lower=/srv/base/2026,/srv/base/common
+
+`
+	exports, err := ParseExports(strings.NewReader(file), base)
+	if err != nil {
+		t.Fatalf("ParseExports failed: %v", err)
+	}
+	want := []Export{
+		{Name: "home", Root: "/srv/home", RootSquash: true},
+		{Name: "media", Root: "/srv/media", ReadOnly: true, AllowedClients: []string{"10.0.0.0/8", "192.168.1.7"}},
+		{Name: "lab", Root: "/srv/lab", RootSquash: true, AccessRules: []string{"deny 10.1.2.3", "allow 10.1.0.0/16", "deny all"}},
+		{Name: "scratch", Root: "/srv/scratch", RootSquash: true, HiddenNames: []string{"lost+found", "*.tmp"}, HideDotfiles: true},
+		{Name: "tenant-a", Root: "/srv/tenants/a", RootSquash: true, Lowers: []string{"/srv/base/2026", "/srv/base/common"}},
+	}
+	if !reflect.DeepEqual(exports, want) {
+		t.Fatalf("ParseExports = %+v, want %+v", exports, want)
+	}
+
+	cfg := exports[1].ServerConfig(base)
+	if cfg.ExportName != "media" || !cfg.ReadOnly || cfg.EnableRootSquash {
+		t.Errorf("ServerConfig did not apply the export's options: %+v", cfg)
+	}
+	if cfg.IndexDir != "/var/lib/nfs/index/media" || cfg.UsageFile != "/var/lib/nfs/usage.media" || cfg.HandleDumpFile != "" ||
+		cfg.RequestCacheFile != "/var/lib/nfs/requests.media" {
+		t.Errorf("ServerConfig persistence = %q, %q, %q, %q", cfg.IndexDir, cfg.UsageFile, cfg.HandleDumpFile, cfg.RequestCacheFile)
+	}
+	if cfg.WarmPaths != nil || base.ExportName != "" {
+		t.Errorf("ServerConfig kept the warm paths or changed the base")
+	}
+	if cfg := exports[3].ServerConfig(base); len(cfg.HiddenNames) != 2 || !cfg.HideDotfiles {
+		t.Errorf("ServerConfig hides %q, dotfiles %v", cfg.HiddenNames, cfg.HideDotfiles)
+	}
+
+	bad := []struct {
+		file    string
+		wantErr string
+	}{
+		{"home", "want"},
+		{"../up /srv", "export name"},
+		{"a /srv/a\na /srv/b", "defined twice"},
+		{"a /srv/a rx", "unknown export option"},
+		{"a /srv/a clients=nowhere", "neither an IP address"},
+		{"a /srv/a deny=nowhere", "neither an IP address"},
+		{"a /srv/a hide=[z-", "invalid hidden name pattern"},
+		{"a /srv/a lower=/srv/base,", "empty lower directory"},
+	}
+	for _, tt := range bad {
+		_, err := ParseExports(strings.NewReader(tt.file), base)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseExports(%q) returned %v, want an error containing %q", tt.file, err, tt.wantErr)
+		}
+	}
+}
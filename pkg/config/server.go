@@ -0,0 +1,266 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs/cachingproxy"
+	"github.com/example/nfsserver/pkg/fs/quota"
+	"github.com/example/nfsserver/pkg/logging"
+	"github.com/example/nfsserver/pkg/nfs"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// Server registers the settings of the NFS server on s. They fill cfg,
+// which supplies the defaults, root, the directory to export, and exports,
+// the file listing further exports.
+func Server(s *Set, cfg *server.Config, root *string, exports *string) {
+	s.String(&cfg.ListenAddress, "listen", "Network address to listen on")
+	s.String(root, "root", "Root directory to export")
+	s.Int(&cfg.MaxConcurrent, "max-concurrent", "Maximum concurrent requests")
+	s.Size(&cfg.MaxReadSize, "max-read", "Maximum read size, e.g. 1MiB")
+	s.Size(&cfg.MaxWriteSize, "max-write", "Maximum write size, e.g. 1MiB")
+	s.Duration(&cfg.KeepaliveTime, "keepalive", "Ping clients idle this long to detect dead connections (0 = gRPC's default of 2h)")
+	s.Duration(&cfg.KeepaliveTimeout, "keepalive-timeout", "Close a connection whose keepalive ping goes unanswered this long")
+	s.Duration(&cfg.KeepaliveMinTime, "keepalive-min-time", "Shortest interval at which clients may ping; more frequent pings disconnect them")
+	s.Bool(&cfg.EnableRootSquash, "root-squash", "Enable root squashing")
+	s.Uint32(&cfg.AnonUID, "anon-uid", "Anonymous user ID")
+	s.Uint32(&cfg.AnonGID, "anon-gid", "Anonymous group ID")
+	s.Duration(&cfg.RequestTimeout, "timeout", "Request timeout, e.g. 30s (a bare number is seconds)")
+	s.String(&cfg.AdminListenAddress, "admin-listen", "Network address for the admin service (disabled if empty)")
+	s.String(&cfg.ProfileListenAddress, "profile-listen", "Network address for the pprof endpoints (disabled if empty; keep it private)")
+	s.String(&cfg.MetricsListenAddress, "metrics-listen", "Network address serving Prometheus metrics at /metrics (disabled if empty)")
+	s.Int(&cfg.CaptureBufferSize, "capture-size", "Number of sanitized request summaries to keep for debugging (0 = disabled)")
+	s.Int(&cfg.MaxNameLength, "max-name-length", "Longest file name accepted, in bytes")
+	s.Int(&cfg.MaxPathLength, "max-path-length", "Longest path accepted, in bytes")
+	s.Int(&cfg.MaxPathDepth, "max-path-depth", "Deepest directory nesting accepted (0 = unlimited)")
+	s.Bool(&cfg.CheckOnStartup, "check", "Check and repair the export index before serving")
+	s.String(&cfg.IndexDir, "index-dir", "Directory the export index is saved in across restarts (empty = rebuilt as handles are used)")
+	s.String(&cfg.HandleDumpFile, "handle-dump", "File the path and handle of every indexed file is dumped to for disaster recovery (empty = disabled)")
+	s.String(&cfg.HandleKeyFile, "handle-key-file", "File holding the key file handles are signed with, created if missing (empty = random key; handles do not survive a restart)")
+	s.Duration(&cfg.HandleLifetime, "handle-lifetime", "How long handles stay valid, between one and two lifetimes from when they are issued (0 = forever)")
+	s.String(&cfg.RevocationFile, "revocation-file", "File keeping the handles revoked with nfsadmin across restarts (empty = memory only)")
+	s.List(&cfg.WarmPaths, "warm", "Comma-separated directories to resolve before serving, so they are cached after a restart")
+	s.Bool(&cfg.PooledBuffers, "pooled-buffers", "Reuse read buffers and responses across requests to reduce allocations")
+	s.Bool(&cfg.AllowAnonymous, "allow-anonymous", "Serve requests without credentials read-only as anon-uid/anon-gid")
+	s.String(&cfg.AuthKeyFile, "auth-key-file", "File holding the key bearer tokens are signed with, created if missing; requests are served as the identity in their token (empty = credentials are trusted)")
+	s.Int(&cfg.MaxDirDelegations, "max-dir-delegations", "Most directory listings clients may cache until recalled (0 = disabled)")
+	s.Int(&cfg.MaxFileDelegations, "max-file-delegations", "Most files clients may cache until recalled (0 = disabled)")
+	s.Int(&cfg.MaxDirSnapshots, "max-dir-snapshots", "Most sorted directory listings kept for clients paging through them (0 = rebuilt for every page)")
+	s.Int(&cfg.MaxQueued, "max-queued", "Most requests waiting for a worker before clients are told to back off (0 = unlimited)")
+	s.Bool(&cfg.VerifyWrites, "verify-writes", "Read FILE_SYNC writes back and compare checksums before acknowledging them")
+	s.Int(&cfg.MaxRequestsPerSecond, "max-request-rate", "Most requests per second before clients are told to back off (0 = unlimited)")
+	s.String(&cfg.ExportName, "export-name", "Name of the export in usage reports (default the root path)")
+	s.String(&cfg.UsageFile, "usage-file", "File keeping per-uid bandwidth accounting across restarts (empty = memory only)")
+	s.Int(&cfg.RequestCacheSize, "request-cache-size", "Most responses kept for replay to clients retrying requests (0 = disabled)")
+	s.String(&cfg.RequestCacheFile, "request-cache-file", "File journaling the responses to exclusive creates, removes and renames, so retries after a restart are not carried out twice (empty = memory only)")
+	s.Bool(&cfg.ReadOnly, "read-only", "Refuse requests modifying the export")
+	s.List(&cfg.AllowedClients, "allowed-clients", "Comma-separated IP addresses and CIDR prefixes of the clients allowed (empty = all)")
+	s.List(&cfg.AccessRules, "access", "Comma-separated rules such as \"allow 10.0.0.0/8,deny all\", the first matching a client deciding, before -allowed-clients")
+	s.List(&cfg.HiddenNames, "hide", "Comma-separated patterns such as \"lost+found,*.tmp\" of names hidden from every user but root")
+	s.Bool(&cfg.HideDotfiles, "hide-dotfiles", "Hide names starting with a dot from every user but root")
+	s.Duration(&cfg.LockLease, "lock-lease", "How long a client's byte-range locks outlive its last lock call or renewal (0 = forever)")
+	s.Duration(&cfg.LockGracePeriod, "lock-grace", "How long after a restart clients have to reclaim their locks before others are granted (0 = none)")
+	s.String(&cfg.LockClientsFile, "lock-clients-file", "File keeping the clients holding locks across restarts, so they can reclaim them (empty = memory only)")
+	s.String(exports, "exports", "File listing further exports, one \"name root [ro|rw] [root_squash|no_root_squash] [clients=...] [hide=...]\" per line")
+
+	s.Check(func() error {
+		if *root == "" {
+			return errors.New("-root must name the directory to export")
+		}
+		if cfg.ListenAddress == "" {
+			return errors.New("-listen must not be empty")
+		}
+		return errors.Join(
+			AtLeast("max-concurrent", cfg.MaxConcurrent, 1),
+			AtLeast("max-read", cfg.MaxReadSize, 1),
+			SizeAtMost("max-read", cfg.MaxReadSize, nfs.MaxTransferSize, "the largest transfer supported"),
+			AtLeast("max-write", cfg.MaxWriteSize, 1),
+			SizeAtMost("max-write", cfg.MaxWriteSize, nfs.MaxTransferSize, "the largest transfer supported"),
+			keepaliveConsistent("keepalive", cfg.KeepaliveTime, cfg.KeepaliveTimeout, time.Second),
+			positive("keepalive-min-time", cfg.KeepaliveMinTime),
+			AtLeast("capture-size", cfg.CaptureBufferSize, 0),
+			AtLeast("max-name-length", cfg.MaxNameLength, 1),
+			AtLeast("max-path-length", cfg.MaxPathLength, 1),
+			AtLeast("max-path-depth", cfg.MaxPathDepth, 0),
+			AtLeast("max-dir-delegations", cfg.MaxDirDelegations, 0),
+			AtLeast("max-file-delegations", cfg.MaxFileDelegations, 0),
+			AtLeast("max-dir-snapshots", cfg.MaxDirSnapshots, 0),
+			AtLeast("max-queued", cfg.MaxQueued, 0),
+			AtLeast("max-request-rate", cfg.MaxRequestsPerSecond, 0),
+			AtLeast("request-cache-size", cfg.RequestCacheSize, 0),
+			lifetimeValid("handle-lifetime", cfg.HandleLifetime),
+			lifetimeValid("lock-lease", cfg.LockLease),
+			lifetimeValid("lock-grace", cfg.LockGracePeriod),
+			addressesDiffer(cfg),
+			clientsValid("allowed-clients", cfg.AllowedClients),
+			rulesValid("access", cfg.AccessRules),
+			hiddenValid("hide", cfg.HiddenNames),
+		)
+	})
+}
+
+// Backend registers the settings choosing the file system exported on s:
+// backend, "local" for the root directory or "memfs" for memory, and seed,
+// the directory loaded into memory
+func Backend(s *Set, backend *string, seed *string) {
+	s.String(backend, "backend", "File system exported: local for -root, memfs to serve from memory without touching the disk, or proxy to serve the export of -upstream through a cache")
+	s.String(seed, "seed", "Directory copied into memory on startup with -backend memfs (empty = start empty)")
+
+	s.Check(func() error {
+		switch *backend {
+		case "local":
+			if *seed != "" {
+				return errors.New("-seed needs -backend memfs")
+			}
+		case "memfs", "proxy":
+		default:
+			return fmt.Errorf("-backend must be local, memfs or proxy, got %q", *backend)
+		}
+		return nil
+	})
+}
+
+// Proxy registers the settings of -backend proxy on s: upstream, the
+// configuration of the client of the server proxied, and opts
+func Proxy(s *Set, backend *string, upstream *client.Config, opts *cachingproxy.Options) {
+	s.String(&upstream.ServerAddress, "upstream", "Address of the NFS server whose export -backend proxy serves")
+	s.String(&upstream.Export, "upstream-export", "Export of -upstream to serve (empty = its default)")
+	s.Uint32(&opts.UID, "upstream-uid", "User ID requests are sent to -upstream as, which must be allowed whatever the proxy's clients are")
+	s.Uint32(&opts.GID, "upstream-gid", "Group ID requests are sent to -upstream as")
+	s.String(&upstream.DiskCacheDir, "proxy-cache", "Keep blocks read from -upstream in this directory, kept across restarts (empty = read again every time)")
+	s.Size(&upstream.DiskCacheSize, "proxy-cache-size", "Most bytes -proxy-cache holds before the least recently used blocks are evicted")
+	s.Duration(&opts.AttrTTL, "proxy-attr-ttl", "How long attributes and listings read from -upstream are served before they are read again")
+	s.Bool(&opts.WriteBack, "proxy-write-back", "Acknowledge writes once buffered in memory, sending them to -upstream on commit or within -proxy-flush-interval (default: sent before they are acknowledged)")
+	s.Duration(&opts.FlushInterval, "proxy-flush-interval", "Longest writes buffered by -proxy-write-back wait before they are sent (0 = until committed)")
+	s.Size(&opts.MaxDirty, "proxy-max-dirty", "Most bytes of writes -proxy-write-back buffers before sending them all")
+
+	s.Check(func() error {
+		if *backend == "proxy" && upstream.ServerAddress == "" {
+			return errors.New("-backend proxy needs -upstream")
+		}
+		if *backend != "proxy" && upstream.ServerAddress != "" {
+			return errors.New("-upstream needs -backend proxy")
+		}
+		if opts.AttrTTL < 0 || opts.FlushInterval < 0 {
+			return errors.New("-proxy-attr-ttl and -proxy-flush-interval must not be negative")
+		}
+		return errors.Join(
+			AtLeast("proxy-cache-size", upstream.DiskCacheSize, 1),
+			AtLeast("proxy-max-dirty", opts.MaxDirty, 1))
+	})
+}
+
+// Shadow registers the setting of shadow reads on s: root, the directory
+// of a second backend the reads from the export are repeated on and
+// compared with. The export in cfg must then be read-only.
+func Shadow(s *Set, cfg *server.Config, root *string) {
+	s.String(root, "shadow-root", "Directory of a second copy of the export, such as an s3fs mount being migrated to, every read is repeated on and compared with, logging mismatches (needs -read-only)")
+
+	s.Check(func() error {
+		if *root != "" && !cfg.ReadOnly {
+			return errors.New("-shadow-root needs -read-only, as writes would make the copies differ")
+		}
+		return nil
+	})
+}
+
+// AtRest registers the setting of encryption at rest on s: keyFile, the
+// file holding the master key file contents are stored encrypted with
+func AtRest(s *Set, keyFile *string) {
+	s.String(keyFile, "at-rest-key-file", "Store file contents encrypted with keys derived from the 32-byte key in this file (empty = stored as written)")
+}
+
+// Quota registers the settings of disk quotas on s, filling limits, which
+// apply to each export alone
+func Quota(s *Set, limits *quota.Limits) {
+	s.Size(&limits.Bytes, "quota-bytes", "Most bytes the regular files of each export may hold; writes beyond fail with ERR_NOSPC (0 = unlimited)")
+	s.Int(&limits.Files, "quota-files", "Most files, directories and symlinks each export may hold (0 = unlimited)")
+	s.Size(&limits.UserBytes, "user-quota-bytes", "Most bytes each user may own in an export; writes beyond fail with ERR_DQUOT (0 = unlimited)")
+	s.Int(&limits.UserFiles, "user-quota-files", "Most files each user may own in an export (0 = unlimited)")
+
+	s.Check(func() error {
+		return errors.Join(
+			AtLeast("quota-bytes", limits.Bytes, 0),
+			AtLeast("quota-files", limits.Files, 0),
+			AtLeast("user-quota-bytes", limits.UserBytes, 0),
+			AtLeast("user-quota-files", limits.UserFiles, 0))
+	})
+}
+
+// Logging registers the settings of the log on s, filling opts
+func Logging(s *Set, opts *logging.Options) {
+	s.String(&opts.Level, "log-level", "Least severe messages logged: debug, info, warn or error (debug logs every request)")
+	s.String(&opts.Format, "log-format", "Log line format: text or json")
+
+	s.Check(func() error {
+		_, err := logging.New(io.Discard, *opts)
+		return err
+	})
+}
+
+// positive returns an error unless the duration setting called name is
+// above zero
+func positive(name string, value time.Duration) error {
+	if value <= 0 {
+		return fmt.Errorf("-%s must be positive, got %v", name, value)
+	}
+	return nil
+}
+
+// lifetimeValid returns an error unless the lifetime setting called name
+// is zero or at least a second, the resolution of handle expiry and of the
+// lock leases and grace periods sent to clients
+func lifetimeValid(name string, value time.Duration) error {
+	if value != 0 && value < time.Second {
+		return fmt.Errorf("-%s must be 0 or at least 1s, got %v", name, value)
+	}
+	return nil
+}
+
+// clientsValid returns an error unless every entry of the client list
+// setting called name is an IP address or CIDR prefix
+func clientsValid(name string, clients []string) error {
+	if _, err := server.ParseClients(clients); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	return nil
+}
+
+// rulesValid returns an error unless every entry of the access rule
+// setting called name is a valid rule
+func rulesValid(name string, rules []string) error {
+	if _, err := server.ParseAccessRules(rules); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	return nil
+}
+
+// hiddenValid returns an error unless every pattern of the hidden name
+// setting called name is valid
+func hiddenValid(name string, patterns []string) error {
+	if err := server.CheckHiddenNames(patterns); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	return nil
+}
+
+// addressesDiffer rejects configurations serving two services on one address
+func addressesDiffer(cfg *server.Config) error {
+	if cfg.AdminListenAddress != "" && cfg.AdminListenAddress == cfg.ListenAddress {
+		return fmt.Errorf("-admin-listen and -listen are both %s", cfg.ListenAddress)
+	}
+	if cfg.ProfileListenAddress != "" &&
+		(cfg.ProfileListenAddress == cfg.ListenAddress || cfg.ProfileListenAddress == cfg.AdminListenAddress) {
+		return fmt.Errorf("-profile-listen %s is already used by another service", cfg.ProfileListenAddress)
+	}
+	if cfg.MetricsListenAddress != "" &&
+		(cfg.MetricsListenAddress == cfg.ListenAddress || cfg.MetricsListenAddress == cfg.AdminListenAddress ||
+			cfg.MetricsListenAddress == cfg.ProfileListenAddress) {
+		return fmt.Errorf("-metrics-listen %s is already used by another service", cfg.MetricsListenAddress)
+	}
+	return nil
+}
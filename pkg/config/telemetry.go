@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/example/nfsserver/pkg/telemetry"
+)
+
+// Telemetry registers the settings of OpenTelemetry tracing on s, filling
+// opts. The server and FUSE client share them.
+func Telemetry(s *Set, opts *telemetry.Options) {
+	s.String(&opts.Exporter, "trace-exporter", "Export OpenTelemetry spans of every NFS operation: otlp (configured by OTEL_EXPORTER_OTLP_*) or stdout (empty = disabled)")
+	s.Float64(&opts.SampleRatio, "trace-sample-ratio", "Fraction of the traces started here that are recorded, from 0 to 1")
+
+	s.Check(func() error {
+		switch opts.Exporter {
+		case "", "otlp", "stdout":
+		default:
+			return fmt.Errorf("-trace-exporter must be otlp or stdout, got %q", opts.Exporter)
+		}
+		if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+			return fmt.Errorf("-trace-sample-ratio must be from 0 to 1, got %v", opts.SampleRatio)
+		}
+		return nil
+	})
+}
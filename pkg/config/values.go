@@ -0,0 +1,169 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the suffixes ParseSize accepts, longest first so "KiB" is
+// not read as "K" followed by garbage
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count such as "4096", "64KiB", "1MiB" or "2GB".
+// The IEC suffixes and their one-letter abbreviations are powers of 1024,
+// the SI ones powers of 1000; suffixes are case-insensitive.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	number, factor := s, int64(1)
+	for _, unit := range sizeUnits {
+		if len(s) > len(unit.suffix) && strings.EqualFold(s[len(s)-len(unit.suffix):], unit.suffix) {
+			number, factor = strings.TrimSpace(s[:len(s)-len(unit.suffix)]), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want a size like 4096, 64KiB or 1MiB")
+	}
+	if n > 0 && factor > (1<<62)/n {
+		return 0, fmt.Errorf("size too large")
+	}
+	return n * factor, nil
+}
+
+// FormatSize formats n with the largest IEC suffix that divides it exactly,
+// so that ParseSize(FormatSize(n)) == n
+func FormatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if n != 0 && n%unit.factor == 0 {
+			return fmt.Sprintf("%d%s", n/unit.factor, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ParseDuration parses a non-negative duration such as "30s" or "1m30s".
+// A bare number is taken as seconds, which is what the integer flags this
+// package replaced meant.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("want a duration like 500ms, 30s or 1m30s")
+	}
+	return d, nil
+}
+
+// The flag.Value implementations behind each kind of setting
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("want an integer")
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type uint32Value struct{ p *uint32 }
+
+func (v uint32Value) Set(s string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return fmt.Errorf("want a number from 0 to %d", uint32(1<<32-1))
+	}
+	*v.p = uint32(n)
+	return nil
+}
+func (v uint32Value) String() string { return strconv.FormatUint(uint64(*v.p), 10) }
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("want a number")
+	}
+	*v.p = f
+	return nil
+}
+func (v floatValue) String() string { return strconv.FormatFloat(*v.p, 'g', -1, 64) }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("want true or false")
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string   { return strconv.FormatBool(*v.p) }
+func (v boolValue) IsBoolFlag() bool { return true }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) String() string { return v.p.String() }
+
+type sizeValue struct{ p *int }
+
+func (v sizeValue) Set(s string) error {
+	n, err := ParseSize(s)
+	if err != nil {
+		return err
+	}
+	if int64(int(n)) != n {
+		return fmt.Errorf("size too large")
+	}
+	*v.p = int(n)
+	return nil
+}
+func (v sizeValue) String() string { return FormatSize(int64(*v.p)) }
+
+// listValue is a comma-separated list; empty items are dropped
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v.p = items
+	return nil
+}
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
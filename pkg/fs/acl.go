@@ -0,0 +1,109 @@
+package fs
+
+import (
+    "slices"
+)
+
+// ACLTag is the kind of a POSIX access control list entry
+type ACLTag uint32
+
+const (
+    ACLUserObj  ACLTag = iota // The file's owner
+    ACLUser                   // The user named by the entry's ID
+    ACLGroupObj               // The file's group
+    ACLGroup                  // The group named by the entry's ID
+    ACLMask                   // Most the user and group entries but the owner's may grant
+    ACLOther                  // Everyone else
+)
+
+// ACLEntry grants Perm, read (4), write (2) and execute (1) bits, to those
+// its Tag and ID name
+type ACLEntry struct {
+    Tag  ACLTag
+    ID   uint32 // User or group ID, for ACLUser and ACLGroup
+    Perm FileMode
+}
+
+// ACL is a POSIX access control list. Its ACLUserObj, ACLGroupObj and
+// ACLOther entries mirror the file's mode bits, with the ACLMask entry in
+// place of the group bits where there is one.
+type ACL []ACLEntry
+
+// Validate checks that acl is one a file can have: a single entry each
+// for the owner, group and others, a mask if any user or group is named,
+// and no name twice. It fails with ErrInvalidName otherwise.
+func (acl ACL) Validate() error {
+    var count [ACLOther + 1]int
+    var users, groups []uint32
+    for _, entry := range acl {
+        if entry.Tag > ACLOther || entry.Perm&^7 != 0 {
+            return ErrInvalidName
+        }
+        count[entry.Tag]++
+        switch entry.Tag {
+        case ACLUser:
+            if slices.Contains(users, entry.ID) {
+                return ErrInvalidName
+            }
+            users = append(users, entry.ID)
+        case ACLGroup:
+            if slices.Contains(groups, entry.ID) {
+                return ErrInvalidName
+            }
+            groups = append(groups, entry.ID)
+        }
+    }
+    if count[ACLUserObj] != 1 || count[ACLGroupObj] != 1 || count[ACLOther] != 1 || count[ACLMask] > 1 {
+        return ErrInvalidName
+    }
+    if (count[ACLUser] > 0 || count[ACLGroup] > 0) && count[ACLMask] == 0 {
+        return ErrInvalidName
+    }
+    return nil
+}
+
+// Allows reports whether acl, on a file owned by owner and group, grants
+// creds all of the permission bits in want. Entries are checked as the
+// kernel checks them: the owner's entry, then one naming the user, then
+// those of any group the user is in, and else the entry for others. Only
+// the owner's and others' entries escape the mask.
+func (acl ACL) Allows(owner uint32, group uint32, creds Credentials, want FileMode) bool {
+    var ownerPerm, other FileMode
+    mask := FileMode(7)
+    for _, entry := range acl {
+        switch entry.Tag {
+        case ACLUserObj:
+            ownerPerm = entry.Perm
+        case ACLMask:
+            mask = entry.Perm
+        case ACLOther:
+            other = entry.Perm
+        }
+    }
+    if creds.UID == owner {
+        return ownerPerm&want == want
+    }
+    for _, entry := range acl {
+        if entry.Tag == ACLUser && entry.ID == creds.UID {
+            return entry.Perm&mask&want == want
+        }
+    }
+    
+    // Any one of the user's groups may grant the bits
+    inGroup := func(gid uint32) bool {
+        return creds.GID == gid || slices.Contains(creds.Groups, gid)
+    }
+    matched := false
+    for _, entry := range acl {
+        if (entry.Tag == ACLGroupObj && inGroup(group)) || (entry.Tag == ACLGroup && inGroup(entry.ID)) {
+            if entry.Perm&mask&want == want {
+                return true
+            }
+            matched = true
+        }
+    }
+    if matched {
+        return false
+    }
+    return other&want == want
+}
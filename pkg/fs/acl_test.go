@@ -0,0 +1,48 @@
+package fs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestACLAllows(t *testing.T) {
+	acl := ACL{
+		{Tag: ACLUserObj, Perm: 7},
+		{Tag: ACLUser, ID: 10, Perm: 7},
+		{Tag: ACLGroupObj, Perm: 4},
+		{Tag: ACLGroup, ID: 30, Perm: 6},
+		{Tag: ACLMask, Perm: 6},
+		{Tag: ACLOther, Perm: 1},
+	}
+	if err := acl.Validate(); err != nil {
+		t.Fatalf("Validate = %v", err)
+	}
+
+	// The file is owned by 1:2
+	testCases := []struct {
+		name  string
+		creds Credentials
+		want  FileMode
+		allow bool
+	}{
+		{"Owner beyond the mask", Credentials{UID: 1}, 7, true},
+		{"Named user within the mask", Credentials{UID: 10, GID: 99}, 6, true},
+		{"Named user beyond the mask", Credentials{UID: 10, GID: 99}, 1, false},
+		{"Owning group", Credentials{UID: 20, GID: 2}, 4, true},
+		{"Named group in the supplementary groups", Credentials{UID: 20, GID: 2, Groups: []uint32{30}}, 6, true},
+		{"Group class does not fall back to others", Credentials{UID: 20, GID: 2}, 1, false},
+		{"Other", Credentials{UID: 20, GID: 99}, 1, true},
+	}
+	for _, tc := range testCases {
+		if got := acl.Allows(1, 2, tc.creds, tc.want); got != tc.allow {
+			t.Errorf("%s: Allows = %v, want %v", tc.name, got, tc.allow)
+		}
+	}
+
+	// Named entries need a mask, and names may not repeat
+	for _, invalid := range []ACL{acl[:4], append(ACL{{Tag: ACLUser, ID: 10, Perm: 4}}, acl...), {{Tag: ACLUserObj, Perm: 8}}} {
+		if err := invalid.Validate(); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Validate of %v = %v, want ErrInvalidName", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,924 @@
+// Package cachingproxy serves the export of another NFS server, keeping
+// what it reads from it close at hand, so one server can act as an edge
+// cache for another.
+package cachingproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+const (
+	// fileSystemIDMask tells the handles of a proxy from those of other
+	// exports ("prxy")
+	fileSystemIDMask = 0x70727879
+
+	// maxCoalesce is the largest write adjacent buffered writes are merged
+	// into, below the write size of any server
+	maxCoalesce = 64 << 10
+
+	// Write stabilities of the upstream client
+	unstable = 0
+	fileSync = 2
+)
+
+// Options tune a CachingProxyFileSystem.
+type Options struct {
+	// AttrTTL is how long attributes, handles and listings read from the
+	// upstream server are trusted before they are read again
+	AttrTTL time.Duration
+
+	// WriteBack acknowledges writes once they are buffered in memory,
+	// sending them upstream when the file is committed, read or changed
+	// otherwise, or within FlushInterval. Without it every write is sent
+	// upstream before it is acknowledged.
+	WriteBack bool
+
+	// FlushInterval is the longest buffered writes wait (0 = until they
+	// must be sent)
+	FlushInterval time.Duration
+
+	// MaxDirty bounds the bytes of buffered writes; a write beyond it sends
+	// them all first
+	MaxDirty int
+
+	// UID and GID are the identity requests are sent upstream as, which
+	// must be allowed to do whatever the proxy's clients may (default root,
+	// which servers squashing root treat as nobody)
+	UID uint32
+	GID uint32
+}
+
+// DefaultOptions returns the options of a write-through proxy.
+func DefaultOptions() Options {
+	return Options{
+		AttrTTL:       3 * time.Second,
+		FlushInterval: 5 * time.Second,
+		MaxDirty:      64 << 20,
+	}
+}
+
+// CachingProxyFileSystem is a file system whose files are those of an
+// export of another NFS server. Attributes, handles and listings read from
+// it are kept in memory for Options.AttrTTL; file data read from it is kept
+// in the disk cache of the upstream client, if it has one, and read again
+// once attributes read since show the file changed.
+//
+// Requests reach the upstream server as Options.UID and Options.GID;
+// the permissions of the proxy's clients are checked by the proxy against
+// the attributes it holds.
+type CachingProxyFileSystem struct {
+	upstream client.NFSClient
+	opts     Options
+	root     []byte
+	fsid     uint32
+
+	mu         sync.Mutex
+	entries    map[string]*entry     // By path
+	listings   map[string]*listing   // By directory path
+	paths      map[string]string     // The path of each upstream handle seen
+	changes    map[string]*change    // By upstream handle
+	dirty      map[string]*dirtyFile // Buffered writes, by upstream handle
+	dirtyBytes int
+
+	// Held while buffered writes are sent upstream, so readers of a file
+	// being flushed wait for its data to be there
+	flushMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// entry is what the proxy knows of a path
+type entry struct {
+	handle  []byte
+	info    fs.FileInfo
+	expires time.Time
+}
+
+// listing is a directory's entries, "." and ".." left out
+type listing struct {
+	entries []fs.DirEntry
+	expires time.Time
+}
+
+// change maps the change attribute of an upstream file to the one the
+// proxy presents, which also moves on with each buffered write
+type change struct {
+	upstream  uint64
+	presented uint64
+}
+
+// write is a buffered write
+type write struct {
+	offset int64
+	data   []byte
+}
+
+// dirtyFile holds the writes to a file not yet sent upstream, in the order
+// they were made
+type dirtyFile struct {
+	writes   []write
+	inFlight int // How many of writes are being sent
+	bytes    int
+	end      int64 // Where the last byte written ends
+	modified time.Time
+}
+
+// NewCachingProxyFileSystem serves the export upstream is configured with.
+// The proxy owns upstream, which Close closes.
+func NewCachingProxyFileSystem(ctx context.Context, upstream client.NFSClient, opts Options) (*CachingProxyFileSystem, error) {
+	root, err := upstream.GetRootFileHandle(ctx)
+	if err != nil {
+		return nil, upstreamError("init", "/", err)
+	}
+	h := fnv.New32a()
+	h.Write(root)
+	c := &CachingProxyFileSystem{
+		upstream: upstream,
+		opts:     opts,
+		root:     root,
+		fsid:     h.Sum32() ^ fileSystemIDMask,
+		entries:  make(map[string]*entry),
+		listings: make(map[string]*listing),
+		paths:    make(map[string]string),
+		changes:  make(map[string]*change),
+		dirty:    make(map[string]*dirtyFile),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if _, err := c.lookup(ctx, "/"); err != nil {
+		return nil, err
+	}
+	go c.flusher()
+	return c, nil
+}
+
+// Close sends the buffered writes upstream and closes the upstream client.
+func (c *CachingProxyFileSystem) Close() error {
+	close(c.stop)
+	<-c.done
+	return errors.Join(c.flushAll(context.Background()), c.upstream.Close())
+}
+
+// flusher sends buffered writes upstream every FlushInterval until Close
+func (c *CachingProxyFileSystem) flusher() {
+	defer close(c.done)
+	if !c.opts.WriteBack || c.opts.FlushInterval <= 0 {
+		<-c.stop
+		return
+	}
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.flushAll(context.Background()); err != nil {
+				slog.Warn("Sending buffered writes upstream failed; retrying later", "err", err)
+			}
+		}
+	}
+}
+
+// as returns ctx carrying the identity requests are sent upstream as
+func (c *CachingProxyFileSystem) as(ctx context.Context) context.Context {
+	return client.WithCredentials(ctx, c.opts.UID, c.opts.GID)
+}
+
+// statusErrors are the errors upstream statuses are reported as
+var statusErrors = []error{
+	fs.ErrNotExist, fs.ErrPermission, fs.ErrExist, fs.ErrIsDir, fs.ErrNotDir,
+	fs.ErrNotEmpty, fs.ErrInvalidName, fs.ErrNameTooLong, fs.ErrNoSpace,
+	fs.ErrQuota, fs.ErrReadOnly, fs.ErrBadCookie, fs.ErrStale, fs.ErrNotSupported,
+}
+
+// upstreamError reports a failed upstream request with the status the
+// upstream server answered it with, or as an I/O error if it got no answer
+func upstreamError(op, p string, err error) error {
+	var nfsErr *client.NFSError
+	if errors.As(err, &nfsErr) && nfsErr.Status != api.Status_OK {
+		cause := fs.ErrIO
+		for _, e := range statusErrors {
+			if fs.Classify(e) == nfsErr.Status {
+				cause = e
+				break
+			}
+		}
+		return fs.NewStatusError(op, p, nfsErr.Status, fmt.Errorf("%w: %v", cause, err))
+	}
+	return fs.NewError(op, p, fmt.Errorf("%w: %w", fs.ErrIO, err))
+}
+
+// within reports whether p is prefix or below it
+func within(p, prefix string) bool {
+	return p == prefix || strings.HasPrefix(p, prefix+"/") || prefix == "/"
+}
+
+// remember caches the upstream handle and attributes of p
+func (c *CachingProxyFileSystem) remember(p string, handle []byte, attrs *api.FileAttributes) entry {
+	e := &entry{
+		handle:  handle,
+		info:    nfs.ProtoAttributesToFSInfo(attrs),
+		expires: time.Now().Add(c.opts.AttrTTL),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[p] = e
+	c.paths[string(handle)] = p
+	return *e
+}
+
+// lookup returns what p names, from the cache while it is fresh and
+// otherwise looked up again in its directory
+func (c *CachingProxyFileSystem) lookup(ctx context.Context, p string) (entry, error) {
+	p = path.Clean("/" + p)
+	c.mu.Lock()
+	e, ok := c.entries[p]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return *e, nil
+	}
+
+	if p == "/" {
+		attrs, err := c.upstream.GetAttr(c.as(ctx), c.root)
+		if err != nil {
+			return entry{}, upstreamError("lookup", p, err)
+		}
+		return c.remember(p, c.root, attrs), nil
+	}
+	dir, err := c.lookup(ctx, path.Dir(p))
+	if err != nil {
+		return entry{}, err
+	}
+	if dir.info.Type != fs.FileTypeDirectory {
+		return entry{}, fs.NewError("lookup", p, fs.ErrNotDir)
+	}
+	handle, attrs, err := c.upstream.Lookup(c.as(ctx), dir.handle, path.Base(p))
+	if err != nil {
+		c.mu.Lock()
+		delete(c.entries, p)
+		c.mu.Unlock()
+		return entry{}, upstreamError("lookup", p, err)
+	}
+	return c.remember(p, handle, attrs), nil
+}
+
+// present returns the attributes of e as the proxy shows them: with the
+// writes buffered for it applied and a change attribute that moves on
+// with them as well as with the upstream file's. c.mu must be held.
+func (c *CachingProxyFileSystem) present(e entry) fs.FileInfo {
+	info := e.info
+	key := string(e.handle)
+	st, ok := c.changes[key]
+	if !ok {
+		st = &change{upstream: info.ChangeID, presented: info.ChangeID}
+		c.changes[key] = st
+	} else if info.ChangeID != st.upstream {
+		st.upstream = info.ChangeID
+		st.presented = max(st.presented+1, info.ChangeID)
+	}
+	info.ChangeID = st.presented
+	if d := c.dirty[key]; d != nil {
+		info.Size = max(info.Size, d.end)
+		info.ModifyTime, info.ChangeTime = d.modified, d.modified
+	}
+	return info
+}
+
+// attrs returns the attributes of e as the proxy shows them
+func (c *CachingProxyFileSystem) attrs(e entry) fs.FileInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.present(e)
+}
+
+// changed forgets what the proxy knows of paths and of the listings of
+// their directories, after they were changed
+func (c *CachingProxyFileSystem) changed(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range paths {
+		delete(c.entries, p)
+		delete(c.listings, p)
+		delete(c.listings, path.Dir(p))
+	}
+}
+
+// moved updates the cache after the tree at from was moved to to, or the
+// two swapped, and forgets the trees removed if neither
+func (c *CachingProxyFileSystem) moved(from, to string, exchange bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, p := range c.paths {
+		switch {
+		case from != "" && within(p, from):
+			c.paths[key] = to + strings.TrimPrefix(p, from)
+		case to != "" && within(p, to) && exchange:
+			c.paths[key] = from + strings.TrimPrefix(p, to)
+		case to != "" && within(p, to):
+			delete(c.paths, key)
+		}
+	}
+	for _, tree := range []string{from, to} {
+		if tree == "" {
+			continue
+		}
+		for p := range c.entries {
+			if within(p, tree) {
+				delete(c.entries, p)
+			}
+		}
+		for p := range c.listings {
+			if within(p, tree) {
+				delete(c.listings, p)
+			}
+		}
+		delete(c.listings, path.Dir(tree))
+	}
+}
+
+func (c *CachingProxyFileSystem) GetAttr(ctx context.Context, p string) (fs.FileInfo, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return fs.FileInfo{}, err
+	}
+	return c.attrs(e), nil
+}
+
+// attrChanges returns the upstream changes attr asks for
+func attrChanges(attr fs.FileAttr) client.AttrChanges {
+	changes := client.AttrChanges{
+		Uid:   attr.Uid,
+		Gid:   attr.Gid,
+		Atime: attr.AccessTime,
+		Mtime: attr.ModifyTime,
+	}
+	if attr.Mode != nil {
+		mode := uint32(*attr.Mode)
+		changes.Mode = &mode
+	}
+	if attr.Size != nil {
+		size := uint64(*attr.Size)
+		changes.Size = &size
+	}
+	return changes
+}
+
+// protoAttributes returns the attributes new files are created with
+func protoAttributes(attr fs.FileAttr) *api.FileAttributes {
+	attrs := &api.FileAttributes{}
+	if attr.Mode != nil {
+		attrs.Mode = uint32(*attr.Mode)
+	}
+	if attr.Uid != nil {
+		attrs.Uid = *attr.Uid
+	}
+	if attr.Gid != nil {
+		attrs.Gid = *attr.Gid
+	}
+	return attrs
+}
+
+// SetAttr sends the writes buffered for the file first, so they cannot
+// undo the change
+func (c *CachingProxyFileSystem) SetAttr(ctx context.Context, p string, attr fs.FileAttr) (fs.FileInfo, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return fs.FileInfo{}, err
+	}
+	if err := c.flush(ctx, e.handle); err != nil {
+		return fs.FileInfo{}, err
+	}
+	attrs, err := c.upstream.SetAttr(c.as(ctx), e.handle, attrChanges(attr))
+	if err != nil {
+		c.changed(path.Clean("/" + p))
+		return fs.FileInfo{}, upstreamError("SetAttr", p, err)
+	}
+	return c.attrs(c.remember(path.Clean("/"+p), e.handle, attrs)), nil
+}
+
+func (c *CachingProxyFileSystem) Lookup(ctx context.Context, dir string, name string) (string, fs.FileInfo, error) {
+	p := path.Join("/", dir, name)
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, c.attrs(e), nil
+}
+
+// Access checks the permission bits the proxy holds, rather than asking
+// upstream on every write
+func (c *CachingProxyFileSystem) Access(ctx context.Context, p string, mode fs.FileMode, creds fs.Credentials) error {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return err
+	}
+	info := c.attrs(e)
+	perm := info.Mode & 7
+	if info.Uid == creds.UID {
+		perm = (info.Mode >> 6) & 7
+	} else if info.Gid == creds.GID {
+		perm = (info.Mode >> 3) & 7
+	} else {
+		for _, g := range creds.Groups {
+			if g == info.Gid {
+				perm = (info.Mode >> 3) & 7
+			}
+		}
+	}
+	if mode&7&perm != mode&7 {
+		return fs.NewError("Access", p, fs.ErrPermission)
+	}
+	return nil
+}
+
+// Read sends the writes buffered for the file first, and reads through the
+// disk cache of the upstream client
+func (c *CachingProxyFileSystem) Read(ctx context.Context, p string, offset int64, length int) ([]byte, bool, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := c.flush(ctx, e.handle); err != nil {
+		return nil, false, err
+	}
+	data, eof, err := c.upstream.Read(c.as(ctx), e.handle, offset, length)
+	if err != nil {
+		return nil, false, upstreamError("Read", p, err)
+	}
+	return data, eof, nil
+}
+
+// Write buffers the write with Options.WriteBack, sending it upstream at
+// once only if sync is set; otherwise it is sent upstream before Write
+// returns, stable if sync is set.
+func (c *CachingProxyFileSystem) Write(ctx context.Context, p string, offset int64, data []byte, sync bool) (int, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	if e.info.Type == fs.FileTypeDirectory {
+		return 0, fs.NewError("Write", p, fs.ErrIsDir)
+	}
+	if c.opts.WriteBack {
+		c.buffer(e, offset, data)
+		if sync {
+			if err := c.flush(ctx, e.handle); err != nil {
+				return 0, err
+			}
+		}
+		if c.overDirty() {
+			if err := c.flushAll(ctx); err != nil {
+				return 0, err
+			}
+		}
+		return len(data), nil
+	}
+
+	stability := unstable
+	if sync {
+		stability = fileSync
+	}
+	n, err := c.upstream.Write(c.as(ctx), e.handle, offset, data, stability)
+	c.changed(path.Clean("/" + p))
+	if err != nil {
+		return 0, upstreamError("Write", p, err)
+	}
+	return n, nil
+}
+
+// buffer keeps a write to the file of e until it is flushed, merged into
+// the one before it if that ends where it starts
+func (c *CachingProxyFileSystem) buffer(e entry, offset int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.present(e)
+	key := string(e.handle)
+	d := c.dirty[key]
+	if d == nil {
+		d = &dirtyFile{}
+		c.dirty[key] = d
+	}
+	merged := false
+	if n := len(d.writes); n > d.inFlight {
+		last := &d.writes[n-1]
+		if last.offset+int64(len(last.data)) == offset && len(last.data)+len(data) <= maxCoalesce {
+			last.data = append(last.data, data...)
+			merged = true
+		}
+	}
+	if !merged {
+		d.writes = append(d.writes, write{offset: offset, data: bytes.Clone(data)})
+	}
+	d.bytes += len(data)
+	c.dirtyBytes += len(data)
+	d.end = max(d.end, offset+int64(len(data)))
+	d.modified = time.Now()
+	c.changes[key].presented++
+}
+
+// overDirty reports whether more writes are buffered than allowed
+func (c *CachingProxyFileSystem) overDirty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirtyBytes > c.opts.MaxDirty
+}
+
+// flush sends the writes buffered for the file of handle upstream and
+// commits them. Writes that fail stay buffered, to be sent again.
+func (c *CachingProxyFileSystem) flush(ctx context.Context, handle []byte) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+	key := string(handle)
+	c.mu.Lock()
+	d := c.dirty[key]
+	if d == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	d.inFlight = len(d.writes)
+	writes := d.writes[:d.inFlight]
+	c.mu.Unlock()
+
+	var err error
+	for _, w := range writes {
+		if _, err = c.upstream.Write(c.as(ctx), handle, w.offset, w.data, unstable); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = c.upstream.Commit(c.as(ctx), handle, 0, 0)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d.inFlight = 0
+	p := c.paths[key]
+	if err != nil {
+		return upstreamError("flush", p, err)
+	}
+	for _, w := range writes {
+		d.bytes -= len(w.data)
+		c.dirtyBytes -= len(w.data)
+	}
+	d.writes = d.writes[len(writes):]
+	d.end = 0
+	for _, w := range d.writes {
+		d.end = max(d.end, w.offset+int64(len(w.data)))
+	}
+	if len(d.writes) == 0 {
+		delete(c.dirty, key)
+	}
+	delete(c.entries, p)
+	return nil
+}
+
+// flushAll sends all buffered writes upstream
+func (c *CachingProxyFileSystem) flushAll(ctx context.Context) error {
+	c.mu.Lock()
+	var handles [][]byte
+	for key := range c.dirty {
+		handles = append(handles, []byte(key))
+	}
+	c.mu.Unlock()
+	var errs []error
+	for _, handle := range handles {
+		errs = append(errs, c.flush(ctx, handle))
+	}
+	return errors.Join(errs...)
+}
+
+// Create sets the size and times asked for with a SetAttr of the new file,
+// as the upstream client only passes on its mode and owner
+func (c *CachingProxyFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+	d, err := c.lookup(ctx, dir)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	p := path.Join("/", dir, name)
+	mode := api.CreateMode_UNCHECKED
+	if excl {
+		mode = api.CreateMode_GUARDED
+	}
+	handle, attrs, err := c.upstream.Create(c.as(ctx), d.handle, name, protoAttributes(attr), mode)
+	c.changed(p)
+	if err != nil {
+		return "", fs.FileInfo{}, upstreamError("Create", p, err)
+	}
+	c.remember(p, handle, attrs)
+	if attr.Size != nil || attr.AccessTime != nil || attr.ModifyTime != nil {
+		info, err := c.SetAttr(ctx, p, fs.FileAttr{Size: attr.Size, AccessTime: attr.AccessTime, ModifyTime: attr.ModifyTime})
+		return p, info, err
+	}
+	info, err := c.GetAttr(ctx, p)
+	return p, info, err
+}
+
+// Remove sends the writes buffered for the file first, as other links may
+// still name it
+func (c *CachingProxyFileSystem) Remove(ctx context.Context, p string) error {
+	p = path.Clean("/" + p)
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return err
+	}
+	if e.info.Type == fs.FileTypeDirectory {
+		return fs.NewError("Remove", p, fs.ErrIsDir)
+	}
+	if err := c.flush(ctx, e.handle); err != nil {
+		return err
+	}
+	d, err := c.lookup(ctx, path.Dir(p))
+	if err != nil {
+		return err
+	}
+	err = c.upstream.Remove(c.as(ctx), d.handle, path.Base(p))
+	c.moved("", p, false)
+	if err != nil {
+		return upstreamError("Remove", p, err)
+	}
+	return nil
+}
+
+func (c *CachingProxyFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	d, err := c.lookup(ctx, dir)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	p := path.Join("/", dir, name)
+	handle, attrs, err := c.upstream.Mkdir(c.as(ctx), d.handle, name, protoAttributes(attr))
+	c.changed(p)
+	if err != nil {
+		return "", fs.FileInfo{}, upstreamError("Mkdir", p, err)
+	}
+	return p, c.attrs(c.remember(p, handle, attrs)), nil
+}
+
+func (c *CachingProxyFileSystem) Rmdir(ctx context.Context, p string) error {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return fs.NewError("Rmdir", p, fs.ErrPermission)
+	}
+	d, err := c.lookup(ctx, path.Dir(p))
+	if err != nil {
+		return err
+	}
+	err = c.upstream.Rmdir(c.as(ctx), d.handle, path.Base(p))
+	c.moved("", p, false)
+	if err != nil {
+		return upstreamError("Rmdir", p, err)
+	}
+	return nil
+}
+
+// list returns the entries of dir, from the cache while it is fresh. The
+// handles and attributes listed are cached too.
+func (c *CachingProxyFileSystem) list(ctx context.Context, dir string) ([]fs.DirEntry, error) {
+	c.mu.Lock()
+	l, ok := c.listings[dir]
+	c.mu.Unlock()
+	if ok && time.Now().Before(l.expires) {
+		return l.entries, nil
+	}
+
+	d, err := c.lookup(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if d.info.Type != fs.FileTypeDirectory {
+		return nil, fs.NewError("ReadDir", dir, fs.ErrNotDir)
+	}
+	upstreamEntries, err := c.upstream.ReadDirPlus(c.as(ctx), d.handle)
+	if err != nil {
+		return nil, upstreamError("ReadDir", dir, err)
+	}
+	var entries []fs.DirEntry
+	for _, ue := range upstreamEntries {
+		if ue.Name == "." || ue.Name == ".." {
+			continue
+		}
+		entry := fs.DirEntry{Name: ue.Name, FileId: ue.FileId}
+		if len(ue.FileHandle) > 0 && ue.Attributes != nil {
+			e := c.remember(path.Join(dir, ue.Name), ue.FileHandle, ue.Attributes)
+			info := c.attrs(e)
+			entry.Attributes = &info
+		}
+		entries = append(entries, entry)
+	}
+	fs.SetNameCookies(entries)
+
+	c.mu.Lock()
+	c.listings[dir] = &listing{entries: entries, expires: time.Now().Add(c.opts.AttrTTL)}
+	c.mu.Unlock()
+	return entries, nil
+}
+
+func (c *CachingProxyFileSystem) EntryCount(ctx context.Context, dir string) (uint64, error) {
+	entries, err := c.list(ctx, path.Clean("/"+dir))
+	return uint64(len(entries)), err
+}
+
+func (c *CachingProxyFileSystem) IsEmpty(ctx context.Context, dir string) (bool, error) {
+	count, err := c.EntryCount(ctx, dir)
+	return count == 0, err
+}
+
+// readDir lists dir, "." and ".." first and the rest in the order of
+// their name cookies
+func (c *CachingProxyFileSystem) readDir(ctx context.Context, dir string, cookie int64, count int, plus bool) ([]fs.DirEntry, int64, error) {
+	dir = path.Clean("/" + dir)
+	children, err := c.list(ctx, dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	self, err := c.GetAttr(ctx, dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	up, err := c.GetAttr(ctx, path.Dir(dir))
+	if err != nil {
+		return nil, 0, err
+	}
+	all := append([]fs.DirEntry{
+		{Name: ".", FileId: self.FileId, Cookie: 1},
+		{Name: "..", FileId: up.FileId, Cookie: 2},
+	}, children...)
+	if plus {
+		all[0].Attributes, all[1].Attributes = &self, &up
+	}
+
+	var entries []fs.DirEntry
+	for _, entry := range all {
+		if entry.Cookie <= cookie || len(entries) >= count {
+			continue
+		}
+		if !plus {
+			entry.Attributes = nil
+		} else if entry.Attributes == nil {
+			info, err := c.GetAttr(ctx, path.Join(dir, entry.Name))
+			if err != nil {
+				continue
+			}
+			entry.Attributes = &info
+		}
+		entries = append(entries, entry)
+	}
+	next := cookie
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Cookie
+	}
+	return entries, next, nil
+}
+
+func (c *CachingProxyFileSystem) ReadDir(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return c.readDir(ctx, dir, cookie, count, false)
+}
+
+func (c *CachingProxyFileSystem) ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return c.readDir(ctx, dir, cookie, count, true)
+}
+
+func (c *CachingProxyFileSystem) Rename(ctx context.Context, oldPath string, newPath string, flags fs.RenameFlags) error {
+	oldPath, newPath = path.Clean("/"+oldPath), path.Clean("/"+newPath)
+	from, err := c.lookup(ctx, path.Dir(oldPath))
+	if err != nil {
+		return err
+	}
+	to, err := c.lookup(ctx, path.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	err = c.upstream.RenameWithFlags(c.as(ctx), from.handle, path.Base(oldPath), to.handle, path.Base(newPath), client.RenameFlags(flags))
+	if err != nil {
+		c.changed(oldPath, newPath)
+		return upstreamError("Rename", oldPath, err)
+	}
+	c.moved(oldPath, newPath, flags&fs.RenameExchange != 0)
+	return nil
+}
+
+func (c *CachingProxyFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	d, err := c.lookup(ctx, dir)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	p := path.Join("/", dir, name)
+	handle, attrs, err := c.upstream.Symlink(c.as(ctx), d.handle, name, target)
+	c.changed(p)
+	if err != nil {
+		return "", fs.FileInfo{}, upstreamError("Symlink", p, err)
+	}
+	return p, c.attrs(c.remember(p, handle, attrs)), nil
+}
+
+func (c *CachingProxyFileSystem) Readlink(ctx context.Context, p string) (string, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	target, err := c.upstream.Readlink(c.as(ctx), e.handle)
+	if err != nil {
+		return "", upstreamError("Readlink", p, err)
+	}
+	return target, nil
+}
+
+func (c *CachingProxyFileSystem) Link(ctx context.Context, p string, dir string, name string) (string, fs.FileInfo, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	d, err := c.lookup(ctx, dir)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	newPath := path.Join("/", dir, name)
+	attrs, err := c.upstream.Link(c.as(ctx), e.handle, d.handle, name)
+	c.changed(path.Clean("/"+p), newPath)
+	if err != nil {
+		return "", fs.FileInfo{}, upstreamError("Link", newPath, err)
+	}
+	return newPath, c.attrs(c.remember(newPath, e.handle, attrs)), nil
+}
+
+func (c *CachingProxyFileSystem) StatFS(ctx context.Context) (fs.FSStat, error) {
+	stat, err := c.upstream.FsStat(c.as(ctx), c.root)
+	if err != nil {
+		return fs.FSStat{}, upstreamError("StatFS", "/", err)
+	}
+	return fs.FSStat{
+		TotalBytes:    stat.TotalBytes,
+		FreeBytes:     stat.FreeBytes,
+		AvailBytes:    stat.AvailBytes,
+		TotalFiles:    stat.TotalFiles,
+		FreeFiles:     stat.FreeFiles,
+		NameMaxLength: stat.NameMax,
+	}, nil
+}
+
+// FileHandleToPath finds the path a handle was last seen at. The proxy
+// only knows the paths of the files it has seen since it started; the
+// handles of others are stale.
+func (c *CachingProxyFileSystem) FileHandleToPath(ctx context.Context, fh []byte) (string, error) {
+	h, err := fs.DeserializeFileHandle(fh)
+	if err != nil || h.FileSystemID != c.fsid {
+		return "", fs.NewError("FileHandleToPath", "", fs.ErrInvalidHandle)
+	}
+	handle := fh[h.Size():]
+	c.mu.Lock()
+	p, ok := c.paths[string(handle)]
+	c.mu.Unlock()
+	if !ok {
+		return "", fs.NewError("FileHandleToPath", "", fs.ErrStale)
+	}
+	if e, err := c.lookup(ctx, p); err != nil || !bytes.Equal(e.handle, handle) {
+		return "", fs.NewError("FileHandleToPath", p, fs.ErrStale)
+	}
+	return p, nil
+}
+
+// PathToFileHandle returns a FileHandle carrying the proxy's file system
+// ID and the file ID, followed by the upstream handle
+func (c *CachingProxyFileSystem) PathToFileHandle(ctx context.Context, p string) ([]byte, error) {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	h := fs.FileHandle{FileSystemID: c.fsid, Inode: e.info.FileId}
+	return append(h.Serialize(), e.handle...), nil
+}
+
+// Commit sends the writes buffered for the file, committed, or commits
+// those sent unstable
+func (c *CachingProxyFileSystem) Commit(ctx context.Context, p string, offset int64, count int64) error {
+	e, err := c.lookup(ctx, p)
+	if err != nil {
+		return err
+	}
+	if c.opts.WriteBack {
+		return c.flush(ctx, e.handle)
+	}
+	if count < 0 || count > math.MaxUint32 {
+		count = 0
+	}
+	if _, err := c.upstream.Commit(c.as(ctx), e.handle, uint64(offset), uint32(count)); err != nil {
+		return upstreamError("Commit", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package cachingproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+	"github.com/example/nfsserver/pkg/server"
+)
+
+// newTestProxy returns a proxy with opts of a server exporting a memfs
+// holding /dir/file, and the memfs
+func newTestProxy(t *testing.T, opts Options) (*CachingProxyFileSystem, *memfs.MemFileSystem) {
+	t.Helper()
+	ctx := context.Background()
+	memFS := memfs.NewMemFileSystem()
+	dirMode, fileMode := fs.FileMode(0755), fs.FileMode(0644)
+	if _, _, err := memFS.Mkdir(ctx, "/", "dir", fs.FileAttr{Mode: &dirMode}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := memFS.Create(ctx, "/dir", "file", fs.FileAttr{Mode: &fileMode}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := memFS.Write(ctx, "/dir/file", 0, []byte("upstream"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	serverConfig := server.DefaultConfig()
+	serverConfig.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(serverConfig, memFS)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go nfsServer.Serve(lis)
+
+	config := client.DefaultConfig()
+	config.ServerAddress = lis.Addr().String()
+	config.Timeout = 5 * time.Second
+	config.DiskCacheDir = t.TempDir()
+	upstream, err := client.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	proxyFS, err := NewCachingProxyFileSystem(ctx, upstream, opts)
+	if err != nil {
+		t.Fatalf("NewCachingProxyFileSystem failed: %v", err)
+	}
+	t.Cleanup(func() { proxyFS.Close() })
+	return proxyFS, memFS
+}
+
+func TestWriteThrough(t *testing.T) {
+	proxyFS, memFS := newTestProxy(t, DefaultOptions())
+	ctx := context.Background()
+
+	if data, _, err := proxyFS.Read(ctx, "/dir/file", 0, 100); err != nil || string(data) != "upstream" {
+		t.Errorf("Read = %q, %v; want \"upstream\"", data, err)
+	}
+	if _, _, err := proxyFS.Create(ctx, "/dir", "new", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := proxyFS.Write(ctx, "/dir/new", 0, []byte("through"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _, err := memFS.Read(ctx, "/dir/new", 0, 100); err != nil || string(data) != "through" {
+		t.Errorf("Upstream file = %q, %v; want the write", data, err)
+	}
+	if info, err := proxyFS.GetAttr(ctx, "/dir/new"); err != nil || info.Size != 7 {
+		t.Errorf("GetAttr after Write = %+v, %v; want size 7", info, err)
+	}
+	entries, _, err := proxyFS.ReadDirPlus(ctx, "/dir", 0, 10)
+	if err != nil || len(entries) != 4 {
+		t.Errorf("ReadDirPlus = %d entries, %v; want ., .., file and new", len(entries), err)
+	}
+
+	// Handles follow files renamed through the proxy
+	handle, err := proxyFS.PathToFileHandle(ctx, "/dir/new")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := proxyFS.Rename(ctx, "/dir", "/moved", 0); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if p, err := proxyFS.FileHandleToPath(ctx, handle); err != nil || p != "/moved/new" {
+		t.Errorf("FileHandleToPath after Rename = %q, %v; want /moved/new", p, err)
+	}
+	if _, err := proxyFS.GetAttr(ctx, "/dir/new"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetAttr of the old name = %v, want ErrNotExist", err)
+	}
+
+	if err := proxyFS.Remove(ctx, "/moved/new"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := proxyFS.FileHandleToPath(ctx, handle); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("FileHandleToPath of a removed file = %v, want ErrStale", err)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	opts := DefaultOptions()
+	opts.AttrTTL = time.Hour
+	opts.WriteBack = true
+	opts.FlushInterval = 0
+	proxyFS, memFS := newTestProxy(t, opts)
+	ctx := context.Background()
+	before, err := proxyFS.GetAttr(ctx, "/dir/file")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+
+	// Writes stay in the proxy, which shows them in the attributes
+	for _, w := range []string{" and", " back"} {
+		info, _ := proxyFS.GetAttr(ctx, "/dir/file")
+		if _, err := proxyFS.Write(ctx, "/dir/file", info.Size, []byte(w), false); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if data, _, err := memFS.Read(ctx, "/dir/file", 0, 100); err != nil || string(data) != "upstream" {
+		t.Errorf("Upstream file = %q, %v; want it unchanged until flushed", data, err)
+	}
+	after, err := proxyFS.GetAttr(ctx, "/dir/file")
+	if err != nil || after.Size != int64(len("upstream and back")) || after.ChangeID <= before.ChangeID {
+		t.Errorf("GetAttr of a written file = size %d, change %d, %v; want size 17 and change above %d",
+			after.Size, after.ChangeID, err, before.ChangeID)
+	}
+
+	// Reading sends them
+	if data, _, err := proxyFS.Read(ctx, "/dir/file", 0, 100); err != nil || string(data) != "upstream and back" {
+		t.Errorf("Read = %q, %v; want the writes", data, err)
+	}
+	if data, _, err := memFS.Read(ctx, "/dir/file", 0, 100); err != nil || string(data) != "upstream and back" {
+		t.Errorf("Upstream file after Read = %q, %v; want the writes", data, err)
+	}
+	flushed, err := proxyFS.GetAttr(ctx, "/dir/file")
+	if err != nil || flushed.ChangeID < after.ChangeID {
+		t.Errorf("Change attribute went from %d to %d, %v", after.ChangeID, flushed.ChangeID, err)
+	}
+
+	// Attributes are kept for AttrTTL, and data read is of the version
+	// they show
+	if _, err := memFS.Write(ctx, "/dir/file", 0, []byte("UP"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if info, err := proxyFS.GetAttr(ctx, "/dir/file"); err != nil || info.ChangeID != flushed.ChangeID {
+		t.Errorf("GetAttr = %+v, %v; want the cached attributes", info, err)
+	}
+	if data, _, err := proxyFS.Read(ctx, "/dir/file", 0, 2); err != nil || !bytes.Equal(data, []byte("up")) {
+		t.Errorf("Read of cached data = %q, %v; want \"up\"", data, err)
+	}
+
+	// Commit and Close send what is left
+	if _, err := proxyFS.Write(ctx, "/dir/file", 0, []byte("u"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := proxyFS.Commit(ctx, "/dir/file", 0, 0); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if data, _, err := memFS.Read(ctx, "/dir/file", 0, 2); err != nil || string(data) != "uP" {
+		t.Errorf("Upstream file after Commit = %q, %v; want \"uP\"", data, err)
+	}
+}
@@ -0,0 +1,46 @@
+package fs
+
+import (
+    "hash/fnv"
+    "sort"
+)
+
+// firstNameCookie is the lowest cookie NameCookie returns. 0 starts a
+// listing, and 1 and 2 belong to "." and "..".
+const firstNameCookie = 3
+
+// NameCookie returns the cookie of the directory entry called name: a
+// hash of the name, so an entry keeps its cookie however the directory
+// changes around it and a listing continued after entries were added or
+// removed neither skips nor repeats the others.
+func NameCookie(name string) int64 {
+    h := fnv.New64a()
+    h.Write([]byte(name))
+    // Leave room above the hash for collisions to be moved into
+    cookie := int64(h.Sum64() >> 2)
+    if cookie < firstNameCookie {
+        cookie += firstNameCookie
+    }
+    return cookie
+}
+
+// SetNameCookies gives entries, which must not include "." and "..", their
+// NameCookie and sorts them by it, as listings must be for a cookie to
+// resume them. Names whose hashes collide take the next free cookie in
+// name order.
+func SetNameCookies(entries []DirEntry) {
+    for i := range entries {
+        entries[i].Cookie = NameCookie(entries[i].Name)
+    }
+    sort.Slice(entries, func(i, j int) bool {
+        if entries[i].Cookie != entries[j].Cookie {
+            return entries[i].Cookie < entries[j].Cookie
+        }
+        return entries[i].Name < entries[j].Name
+    })
+    for i := 1; i < len(entries); i++ {
+        if entries[i].Cookie <= entries[i-1].Cookie {
+            entries[i].Cookie = entries[i-1].Cookie + 1
+        }
+    }
+}
@@ -0,0 +1,565 @@
+// Package crypt wraps a file system to store the contents of its files
+// encrypted, while clients see them in plaintext.
+package crypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// Layout of stored files. A file starts with a header holding a random
+// file ID, from which the file's key is derived with the master key, and a
+// check value telling whether the master key is the one the file was
+// written with. The data follows in blocks of blockSize plaintext bytes,
+// each sealed with AES-256-GCM under a fresh random nonce and stored as
+// nonce, ciphertext, tag; the last block may be shorter. Blocks are bound
+// to their position, so they cannot be reordered. An empty file has no
+// header.
+const (
+	magic       = "NFSR"
+	version     = 1
+	keySize     = 32
+	fileIDSize  = 16
+	checkSize   = 16
+	nonceSize   = 12
+	tagSize     = 16
+	blockSize   = 4096
+	overhead    = nonceSize + tagSize
+	storedBlock = blockSize + overhead
+	headerSize  = 4 + 4 + fileIDSize + checkSize // magic, version, 3 reserved bytes, file ID, check
+
+	// Blocks read or written in one call to the wrapped file system
+	batchBlocks = 256
+
+	// Locks serializing the read-modify-write of blocks, chosen by path
+	lockStripes = 64
+)
+
+// Labels for the keys derived from the master key
+const (
+	fileKeyLabel = "nfs at-rest file key"
+	checkLabel   = "nfs at-rest key check"
+)
+
+// errCorrupt is returned for stored data that does not decrypt
+var errCorrupt = fmt.Errorf("%w: encrypted data failed authentication", fs.ErrIO)
+
+// CryptFileSystem encrypts the contents of regular files before they reach
+// the file system it wraps and decrypts them on the way back. Sizes seen
+// through it are those of the plaintext; names and attributes are stored
+// as they are. Files stored before it was used, without encryption, cannot
+// be read through it.
+type CryptFileSystem struct {
+	fs.FileSystem
+
+	fileKeys []byte // Key the file keys are derived from
+
+	locks [lockStripes]sync.Mutex
+}
+
+// NewCryptFileSystem wraps inner so file contents are stored encrypted
+// with keys derived from the 32-byte masterKey
+func NewCryptFileSystem(inner fs.FileSystem, masterKey []byte) (*CryptFileSystem, error) {
+	if len(masterKey) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(masterKey))
+	}
+	return &CryptFileSystem{FileSystem: inner, fileKeys: deriveKey(masterKey, []byte(fileKeyLabel))}, nil
+}
+
+// deriveKey derives an independent key for one purpose from key
+func deriveKey(key []byte, label []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(label)
+	return mac.Sum(nil)
+}
+
+// plainSize is the plaintext size of a file of stored bytes
+func plainSize(stored int64) int64 {
+	if stored <= headerSize {
+		return 0
+	}
+	n := stored - headerSize
+	size := n / storedBlock * blockSize
+	if rem := n % storedBlock; rem > overhead {
+		size += rem - overhead
+	}
+	return size
+}
+
+// storedSize is the size of a file holding size plaintext bytes
+func storedSize(size int64) int64 {
+	if size == 0 {
+		return 0
+	}
+	stored := headerSize + size/blockSize*storedBlock
+	if rem := size % blockSize; rem > 0 {
+		stored += rem + overhead
+	}
+	return stored
+}
+
+// blockOffset is where block index is stored
+func blockOffset(index int64) int64 {
+	return headerSize + index*storedBlock
+}
+
+// blockAAD binds a block to its position in the file
+func blockAAD(index int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(index))
+}
+
+// plainInfo rewrites the size of a regular file to that of its plaintext
+func plainInfo(info fs.FileInfo) fs.FileInfo {
+	if info.Type == fs.FileTypeRegular {
+		info.Size = plainSize(info.Size)
+	}
+	return info
+}
+
+// lockFile serializes changes to the blocks of the file at p
+func (c *CryptFileSystem) lockFile(p string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(path.Clean("/" + p)))
+	mu := &c.locks[h.Sum32()%lockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// fileKey returns the key of the file whose ID is fileID, and the check
+// value stored with it
+func (c *CryptFileSystem) fileKey(fileID []byte) (cipher.AEAD, []byte, error) {
+	raw := deriveKey(c.fileKeys, fileID)
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, deriveKey(raw, []byte(checkLabel))[:checkSize], nil
+}
+
+// readKey returns the key of the file at p from its header, or nil if the
+// file is empty
+func (c *CryptFileSystem) readKey(ctx context.Context, op string, p string) (cipher.AEAD, error) {
+	header, _, err := c.FileSystem.Read(ctx, p, 0, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 {
+		return nil, nil
+	}
+	if len(header) < headerSize || string(header[:len(magic)]) != magic {
+		return nil, fs.NewError(op, p, fmt.Errorf("%w: file is not encrypted", fs.ErrIO))
+	}
+	if header[len(magic)] != version {
+		return nil, fs.NewError(op, p, fmt.Errorf("%w: unsupported encryption version %d", fs.ErrIO, header[len(magic)]))
+	}
+	fileID := header[8 : 8+fileIDSize]
+	key, check, err := c.fileKey(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(check, header[8+fileIDSize:]) {
+		return nil, fs.NewError(op, p, fmt.Errorf("%w: file was encrypted with another key", fs.ErrIO))
+	}
+	return key, nil
+}
+
+// newKey writes a header with a new file ID to the empty file at p and
+// returns its key
+func (c *CryptFileSystem) newKey(ctx context.Context, p string) (cipher.AEAD, error) {
+	header := make([]byte, 8, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	fileID := make([]byte, fileIDSize)
+	if _, err := io.ReadFull(rand.Reader, fileID); err != nil {
+		return nil, err
+	}
+	key, check, err := c.fileKey(fileID)
+	if err != nil {
+		return nil, err
+	}
+	header = append(append(header, fileID...), check...)
+	if _, err := c.FileSystem.Write(ctx, p, 0, header, false); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// readBlocks reads and decrypts up to count blocks starting at block
+// first. eof is set if the file ends within them.
+func (c *CryptFileSystem) readBlocks(ctx context.Context, p string, key cipher.AEAD, first int64, count int) ([]byte, bool, error) {
+	want := count * storedBlock
+	stored := make([]byte, 0, want)
+	eof := false
+	for len(stored) < want {
+		chunk, chunkEOF, err := c.FileSystem.Read(ctx, p, blockOffset(first)+int64(len(stored)), want-len(stored))
+		if err != nil {
+			return nil, false, err
+		}
+		stored = append(stored, chunk...)
+		if chunkEOF || len(chunk) == 0 {
+			eof = true
+			break
+		}
+	}
+
+	plain := make([]byte, 0, count*blockSize)
+	for index := first; len(stored) > 0; index++ {
+		n := min(storedBlock, len(stored))
+		if n <= overhead {
+			return nil, false, fs.NewError("Read", p, errCorrupt)
+		}
+		var err error
+		plain, err = key.Open(plain, stored[:nonceSize], stored[nonceSize:n], blockAAD(index))
+		if err != nil {
+			return nil, false, fs.NewError("Read", p, errCorrupt)
+		}
+		stored = stored[n:]
+	}
+	return plain, eof, nil
+}
+
+// sealBlocks encrypts plain as consecutive blocks starting at block first
+func sealBlocks(key cipher.AEAD, first int64, plain []byte) ([]byte, error) {
+	blocks := (len(plain) + blockSize - 1) / blockSize
+	stored := make([]byte, 0, len(plain)+blocks*overhead)
+	for index := first; len(plain) > 0; index++ {
+		n := min(blockSize, len(plain))
+		nonce := make([]byte, nonceSize)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		stored = append(stored, nonce...)
+		stored = key.Seal(stored, nonce, plain[:n], blockAAD(index))
+		plain = plain[n:]
+	}
+	return stored, nil
+}
+
+func (c *CryptFileSystem) Read(ctx context.Context, p string, offset int64, length int) ([]byte, bool, error) {
+	if offset < 0 || length <= 0 {
+		return c.FileSystem.Read(ctx, p, offset, length)
+	}
+	key, err := c.readKey(ctx, "Read", p)
+	if err != nil || key == nil {
+		return nil, err == nil, err
+	}
+
+	first := offset / blockSize
+	last := (offset + int64(length) - 1) / blockSize
+	var data []byte
+	eof := false
+	for index := first; index <= last && !eof; index += batchBlocks {
+		var blocks []byte
+		blocks, eof, err = c.readBlocks(ctx, p, key, index, int(min(last-index+1, batchBlocks)))
+		if err != nil {
+			return nil, false, err
+		}
+		data = append(data, blocks...)
+	}
+
+	start := int(offset - first*blockSize)
+	if start >= len(data) {
+		return nil, true, nil
+	}
+	end := min(start+length, len(data))
+	return data[start:end], eof && end == len(data), nil
+}
+
+// ReadInto is Read into buf, so the server does not read the stored
+// ciphertext through the wrapped file system
+func (c *CryptFileSystem) ReadInto(ctx context.Context, p string, offset int64, buf []byte) (int, bool, error) {
+	data, eof, err := c.Read(ctx, p, offset, len(buf))
+	return copy(buf, data), eof, err
+}
+
+// prepareWrite returns the key of the file at p, writing a header to an
+// empty file, and the file's plaintext size. The caller holds
+// c.lockFile(p).
+func (c *CryptFileSystem) prepareWrite(ctx context.Context, op string, p string) (cipher.AEAD, int64, error) {
+	info, err := c.FileSystem.GetAttr(ctx, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	if info.Type != fs.FileTypeRegular {
+		return nil, 0, fs.NewError(op, p, fs.ErrIsDir)
+	}
+	if info.Size == 0 {
+		key, err := c.newKey(ctx, p)
+		return key, 0, err
+	}
+	key, err := c.readKey(ctx, op, p)
+	return key, plainSize(info.Size), err
+}
+
+// writeLocked writes data at offset to the file at p whose plaintext is
+// size bytes long, filling any gap after the end with zeros. The caller
+// holds c.lockFile(p).
+func (c *CryptFileSystem) writeLocked(ctx context.Context, p string, key cipher.AEAD, size, offset int64, data []byte, sync bool) error {
+	start := min(offset, size)
+	end := offset + int64(len(data))
+	first := start / blockSize
+	last := (end - 1) / blockSize
+
+	plainEnd := max(end, min(size, (last+1)*blockSize))
+	plain := make([]byte, plainEnd-first*blockSize)
+
+	// Keep the existing bytes of the blocks written only in part
+	if start%blockSize != 0 {
+		head, _, err := c.readBlocks(ctx, p, key, first, 1)
+		if err != nil {
+			return err
+		}
+		copy(plain, head)
+	}
+	if end%blockSize != 0 && end < size && (last != first || start%blockSize == 0) {
+		tail, _, err := c.readBlocks(ctx, p, key, last, 1)
+		if err != nil {
+			return err
+		}
+		copy(plain[(last-first)*blockSize:], tail)
+	}
+	copy(plain[offset-first*blockSize:], data)
+
+	for index := first; len(plain) > 0; index += batchBlocks {
+		n := min(batchBlocks*blockSize, len(plain))
+		stored, err := sealBlocks(key, index, plain[:n])
+		if err != nil {
+			return err
+		}
+		written, err := c.FileSystem.Write(ctx, p, blockOffset(index), stored, sync)
+		if err != nil {
+			return err
+		}
+		if written != len(stored) {
+			return fs.NewError("Write", p, fmt.Errorf("%w: short write at block %d", fs.ErrIO, index))
+		}
+		plain = plain[n:]
+	}
+	return nil
+}
+
+func (c *CryptFileSystem) Write(ctx context.Context, p string, offset int64, data []byte, sync bool) (int, error) {
+	if offset < 0 || len(data) == 0 {
+		return c.FileSystem.Write(ctx, p, offset, data, sync)
+	}
+	unlock := c.lockFile(p)
+	defer unlock()
+
+	key, size, err := c.prepareWrite(ctx, "Write", p)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.writeLocked(ctx, p, key, size, offset, data, sync); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// resize changes the plaintext size of the file at p, re-encrypting the
+// block cut in two when it shrinks
+func (c *CryptFileSystem) resize(ctx context.Context, p string, size int64) (fs.FileInfo, error) {
+	unlock := c.lockFile(p)
+	defer unlock()
+
+	if size > 0 {
+		key, current, err := c.prepareWrite(ctx, "SetAttr", p)
+		if err != nil {
+			return fs.FileInfo{}, err
+		}
+		// Grow by writing zeros, which must be encrypted like any other data
+		for current < size {
+			n := min(size-current, batchBlocks*blockSize)
+			if err := c.writeLocked(ctx, p, key, current, current, make([]byte, n), false); err != nil {
+				return fs.FileInfo{}, err
+			}
+			current += n
+		}
+		if rem := size % blockSize; size < current && rem != 0 {
+			index := size / blockSize
+			block, _, err := c.readBlocks(ctx, p, key, index, 1)
+			if err != nil {
+				return fs.FileInfo{}, err
+			}
+			stored, err := sealBlocks(key, index, block[:rem])
+			if err != nil {
+				return fs.FileInfo{}, err
+			}
+			if _, err := c.FileSystem.Write(ctx, p, blockOffset(index), stored, false); err != nil {
+				return fs.FileInfo{}, err
+			}
+		}
+	}
+
+	stored := storedSize(size)
+	info, err := c.FileSystem.SetAttr(ctx, p, fs.FileAttr{Size: &stored})
+	return plainInfo(info), err
+}
+
+// SetAttr applies attr to the file at p. A size change is made after the
+// other changes.
+func (c *CryptFileSystem) SetAttr(ctx context.Context, p string, attr fs.FileAttr) (fs.FileInfo, error) {
+	if attr.Size == nil {
+		info, err := c.FileSystem.SetAttr(ctx, p, attr)
+		return plainInfo(info), err
+	}
+	size := *attr.Size
+	attr.Size = nil
+	if attr != (fs.FileAttr{}) {
+		if _, err := c.FileSystem.SetAttr(ctx, p, attr); err != nil {
+			return fs.FileInfo{}, err
+		}
+	}
+	return c.resize(ctx, p, size)
+}
+
+func (c *CryptFileSystem) GetAttr(ctx context.Context, p string) (fs.FileInfo, error) {
+	info, err := c.FileSystem.GetAttr(ctx, p)
+	return plainInfo(info), err
+}
+
+func (c *CryptFileSystem) Lookup(ctx context.Context, dir string, name string) (string, fs.FileInfo, error) {
+	p, info, err := c.FileSystem.Lookup(ctx, dir, name)
+	return p, plainInfo(info), err
+}
+
+// Create creates a file; a size in attr is set after it is created
+func (c *CryptFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+	size := attr.Size
+	attr.Size = nil
+	p, info, err := c.FileSystem.Create(ctx, dir, name, attr, excl)
+	if err != nil || size == nil {
+		return p, plainInfo(info), err
+	}
+	info, err = c.resize(ctx, p, *size)
+	return p, info, err
+}
+
+func (c *CryptFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	p, info, err := c.FileSystem.Mkdir(ctx, dir, name, attr)
+	return p, plainInfo(info), err
+}
+
+func (c *CryptFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	p, info, err := c.FileSystem.Symlink(ctx, dir, name, target, attr)
+	return p, plainInfo(info), err
+}
+
+func (c *CryptFileSystem) Link(ctx context.Context, p string, dir string, name string) (string, fs.FileInfo, error) {
+	linkPath, info, err := c.FileSystem.Link(ctx, p, dir, name)
+	return linkPath, plainInfo(info), err
+}
+
+func (c *CryptFileSystem) ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	entries, next, err := c.FileSystem.ReadDirPlus(ctx, dir, cookie, count)
+	for i := range entries {
+		if entries[i].Attributes != nil {
+			info := plainInfo(*entries[i].Attributes)
+			entries[i].Attributes = &info
+		}
+	}
+	return entries, next, err
+}
+
+// CreateUnnamed creates an unnamed file, if the wrapped file system can
+func (c *CryptFileSystem) CreateUnnamed(ctx context.Context, dir string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	creator, ok := fs.As[fs.UnnamedCreator](c.FileSystem)
+	if !ok {
+		return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, fs.ErrNotSupported)
+	}
+	p, info, err := creator.CreateUnnamed(ctx, dir, attr)
+	return p, plainInfo(info), err
+}
+
+// LinkUnnamed gives an unnamed file its name
+func (c *CryptFileSystem) LinkUnnamed(ctx context.Context, p string, dir string, name string, replace bool) (string, fs.FileInfo, error) {
+	creator, ok := fs.As[fs.UnnamedCreator](c.FileSystem)
+	if !ok {
+		return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", p, fs.ErrNotSupported)
+	}
+	linkedPath, info, err := creator.LinkUnnamed(ctx, p, dir, name, replace)
+	return linkedPath, plainInfo(info), err
+}
+
+// Commit flushes the whole file, as blocks do not line up with the range
+func (c *CryptFileSystem) Commit(ctx context.Context, p string, offset int64, count int64) error {
+	return c.FileSystem.Commit(ctx, p, 0, 0)
+}
+
+// Allocate, Deallocate and Seek are not supported: stored offsets are not
+// those of the plaintext, and a hole punched in the stored data would fail
+// to decrypt rather than read as zeros. They are implemented only so they
+// are not reached through Unwrap.
+func (c *CryptFileSystem) Allocate(ctx context.Context, p string, offset int64, length int64) error {
+	return fs.NewError("Allocate", p, fs.ErrNotSupported)
+}
+
+func (c *CryptFileSystem) Deallocate(ctx context.Context, p string, offset int64, length int64) error {
+	return fs.NewError("Deallocate", p, fs.ErrNotSupported)
+}
+
+func (c *CryptFileSystem) Seek(ctx context.Context, p string, offset int64, what fs.SeekContent) (int64, error) {
+	return 0, fs.NewError("Seek", p, fs.ErrNotSupported)
+}
+
+// CopyRange copies the plaintext between files, decrypting it with the
+// key of src and encrypting it with that of dst: each file's data is
+// encrypted with a key of its own, so the stored data cannot be copied as
+// it is.
+func (c *CryptFileSystem) CopyRange(ctx context.Context, src string, srcOffset int64, dst string, dstOffset int64, length int64) (int64, error) {
+	if length == 0 {
+		info, err := c.GetAttr(ctx, src)
+		if err != nil {
+			return 0, err
+		}
+		length = info.Size - srcOffset
+	}
+	if length <= 0 {
+		return 0, nil
+	}
+	if src == dst && srcOffset < dstOffset+length && dstOffset < srcOffset+length {
+		return 0, fs.NewError("CopyRange", dst, fs.ErrInvalidName)
+	}
+
+	var copied int64
+	for copied < length {
+		count := min(int64(batchBlocks*blockSize), length-copied)
+		data, eof, err := c.Read(ctx, src, srcOffset+copied, int(count))
+		if err != nil {
+			return copied, err
+		}
+		if len(data) > 0 {
+			n, err := c.Write(ctx, dst, dstOffset+copied, data, false)
+			copied += int64(n)
+			if err != nil {
+				return copied, err
+			}
+		}
+		if eof || len(data) == 0 {
+			break
+		}
+	}
+	return copied, nil
+}
+
+// Unwrap returns the file system the contents are stored in. Optional
+// interfaces reading file contents are implemented by the wrapper itself,
+// so they are not reached through it.
+func (c *CryptFileSystem) Unwrap() fs.FileSystem {
+	return c.FileSystem
+}
@@ -0,0 +1,103 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+)
+
+func TestEncryptAtRest(t *testing.T) {
+	ctx := context.Background()
+	memFS := memfs.NewMemFileSystem()
+	key := bytes.Repeat([]byte{7}, keySize)
+	cryptFS, err := NewCryptFileSystem(memFS, key)
+	if err != nil {
+		t.Fatalf("NewCryptFileSystem failed: %v", err)
+	}
+	if _, _, err := cryptFS.Create(ctx, "/", "file", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Writes at any offset read back, across block boundaries and with a
+	// gap filled with zeros
+	want := make([]byte, 3*blockSize+100)
+	for i := range want {
+		want[i] = byte(i % 251)
+	}
+	writes := []struct{ start, end int }{{0, 10}, {blockSize - 5, 2*blockSize + 7}, {10, blockSize - 5}, {2*blockSize + 7, len(want)}}
+	for _, w := range writes {
+		if n, err := cryptFS.Write(ctx, "/file", int64(w.start), want[w.start:w.end], false); err != nil || n != w.end-w.start {
+			t.Fatalf("Write of [%d, %d) = %d, %v", w.start, w.end, n, err)
+		}
+	}
+	if data, eof, err := cryptFS.Read(ctx, "/file", 0, len(want)+10); err != nil || !eof || !bytes.Equal(data, want) {
+		t.Errorf("Read of the whole file = %d bytes, eof %v, %v; want the data written", len(data), eof, err)
+	}
+	if data, _, err := cryptFS.Read(ctx, "/file", blockSize-3, 6); err != nil || !bytes.Equal(data, want[blockSize-3:blockSize+3]) {
+		t.Errorf("Read across a block boundary = %v, %v", data, err)
+	}
+	buf := make([]byte, 50)
+	if n, _, err := cryptFS.ReadInto(ctx, "/file", 100, buf); err != nil || n != 50 || !bytes.Equal(buf, want[100:150]) {
+		t.Errorf("ReadInto = %d, %v; want the plaintext", n, err)
+	}
+
+	// Only ciphertext is stored, and sizes are those of the plaintext
+	stored, _, err := memFS.Read(ctx, "/file", 0, 2*len(want))
+	if err != nil {
+		t.Fatalf("Read of the stored file failed: %v", err)
+	}
+	if bytes.Contains(stored, want[200:232]) || int64(len(stored)) != storedSize(int64(len(want))) {
+		t.Errorf("Stored %d bytes, want %d bytes of ciphertext", len(stored), storedSize(int64(len(want))))
+	}
+	if info, err := cryptFS.GetAttr(ctx, "/file"); err != nil || info.Size != int64(len(want)) {
+		t.Errorf("GetAttr = size %d, %v; want %d", info.Size, err, len(want))
+	}
+	entries, _, err := cryptFS.ReadDirPlus(ctx, "/", 0, 10)
+	if err != nil {
+		t.Fatalf("ReadDirPlus failed: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name == "file" && entry.Attributes.Size != int64(len(want)) {
+			t.Errorf("ReadDirPlus size = %d, want %d", entry.Attributes.Size, len(want))
+		}
+	}
+
+	// Truncating re-encrypts the block cut in two
+	size := int64(blockSize + 10)
+	if info, err := cryptFS.SetAttr(ctx, "/file", fs.FileAttr{Size: &size}); err != nil || info.Size != size {
+		t.Fatalf("SetAttr of the size = %d, %v; want %d", info.Size, err, size)
+	}
+	if data, eof, err := cryptFS.Read(ctx, "/file", 0, 2*blockSize); err != nil || !eof || !bytes.Equal(data, want[:size]) {
+		t.Errorf("Read after truncating = %d bytes, eof %v, %v", len(data), eof, err)
+	}
+	size = 2 * blockSize
+	if _, err := cryptFS.SetAttr(ctx, "/file", fs.FileAttr{Size: &size}); err != nil {
+		t.Fatalf("SetAttr growing the file failed: %v", err)
+	}
+	if data, _, err := cryptFS.Read(ctx, "/file", blockSize, blockSize); err != nil || !bytes.Equal(data[10:], make([]byte, blockSize-10)) {
+		t.Errorf("Read of the grown part = %v, want zeros", err)
+	}
+
+	// Another key cannot read the file, nor can changed data be read
+	otherFS, err := NewCryptFileSystem(memFS, bytes.Repeat([]byte{8}, keySize))
+	if err != nil {
+		t.Fatalf("NewCryptFileSystem failed: %v", err)
+	}
+	if _, _, err := otherFS.Read(ctx, "/file", 0, 10); !errors.Is(err, fs.ErrIO) {
+		t.Errorf("Read with another key = %v, want ErrIO", err)
+	}
+	stored, _, err = memFS.Read(ctx, "/file", blockOffset(1)+nonceSize, 1)
+	if err != nil {
+		t.Fatalf("Read of the stored file failed: %v", err)
+	}
+	if _, err := memFS.Write(ctx, "/file", blockOffset(1)+nonceSize, []byte{stored[0] ^ 1}, false); err != nil {
+		t.Fatalf("Write to the stored file failed: %v", err)
+	}
+	if _, _, err := cryptFS.Read(ctx, "/file", blockSize, 10); !errors.Is(err, fs.ErrIO) {
+		t.Errorf("Read of a tampered block = %v, want ErrIO", err)
+	}
+}
@@ -2,8 +2,13 @@
 package fs
 
 import (
+    "context"
     "errors"
     "fmt"
+    "os"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/api"
 )
 
 // Common filesystem errors that map to NFS error codes
@@ -16,18 +21,77 @@ var (
     ErrNotDir = errors.New("not a directory")
     ErrNotEmpty = errors.New("directory not empty")
     ErrInvalidName = errors.New("invalid name")
+    ErrNameTooLong = errors.New("file name too long")
     ErrInvalidHandle = errors.New("invalid file handle")
     ErrNoSpace = errors.New("no space left on device")
+    ErrQuota = errors.New("disk quota exceeded")
     ErrReadOnly = errors.New("read-only filesystem")
     ErrBadCookie = errors.New("invalid directory cookie")
     ErrStale = errors.New("stale file handle")
     ErrNotSupported = errors.New("operation not supported")
+    ErrNoData = errors.New("no data past offset")
 )
 
+// sentinelStatus gives the NFS status of each of the errors above
+var sentinelStatus = []struct {
+    err    error
+    status api.Status
+}{
+    {ErrNotExist, api.Status_ERR_NOENT},
+    {ErrPermission, api.Status_ERR_ACCES},
+    {ErrExist, api.Status_ERR_EXIST},
+    {ErrIO, api.Status_ERR_IO},
+    {ErrIsDir, api.Status_ERR_ISDIR},
+    {ErrNotDir, api.Status_ERR_NOTDIR},
+    {ErrInvalidName, api.Status_ERR_INVAL},
+    {ErrNameTooLong, api.Status_ERR_NAMETOOLONG},
+    {ErrInvalidHandle, api.Status_ERR_BADHANDLE},
+    {ErrNoSpace, api.Status_ERR_NOSPC},
+    {ErrQuota, api.Status_ERR_DQUOT},
+    {ErrReadOnly, api.Status_ERR_ROFS},
+    {ErrBadCookie, api.Status_ERR_BAD_COOKIE},
+    {ErrStale, api.Status_ERR_STALE},
+    {ErrNotSupported, api.Status_ERR_NOTSUPP},
+    {ErrNotEmpty, api.Status_ERR_NOTEMPTY},
+    {ErrNoData, api.Status_ERR_NXIO},
+}
+
+// errnoStatus gives the NFS status of the system call errors backends pass
+// on
+var errnoStatus = map[syscall.Errno]api.Status{
+    syscall.EPERM:        api.Status_ERR_PERM,
+    syscall.ENOENT:       api.Status_ERR_NOENT,
+    syscall.EIO:          api.Status_ERR_IO,
+    syscall.ENXIO:        api.Status_ERR_NXIO,
+    syscall.EACCES:       api.Status_ERR_ACCES,
+    syscall.EEXIST:       api.Status_ERR_EXIST,
+    syscall.EXDEV:        api.Status_ERR_XDEV,
+    syscall.ENODEV:       api.Status_ERR_NODEV,
+    syscall.ENOTDIR:      api.Status_ERR_NOTDIR,
+    syscall.EISDIR:       api.Status_ERR_ISDIR,
+    syscall.EINVAL:       api.Status_ERR_INVAL,
+    syscall.EFBIG:        api.Status_ERR_FBIG,
+    syscall.ENOSPC:       api.Status_ERR_NOSPC,
+    syscall.EROFS:        api.Status_ERR_ROFS,
+    syscall.ENAMETOOLONG: api.Status_ERR_NAMETOOLONG,
+    syscall.ENOTEMPTY:    api.Status_ERR_NOTEMPTY,
+    syscall.EDQUOT:       api.Status_ERR_DQUOT,
+    syscall.ESTALE:       api.Status_ERR_STALE,
+    syscall.EOPNOTSUPP:   api.Status_ERR_NOTSUPP,
+}
+
 // FSError represents a filesystem error with additional context.
 type FSError struct {
     Op string
     Path string
+
+    // Inode is the inode number of the file, where the backend knows it
+    Inode uint64
+
+    // Status is the NFS status the error is to be answered with, or OK
+    // where it is not known
+    Status api.Status
+
     Err error
 }
 
@@ -44,11 +108,72 @@ func (e *FSError) Unwrap() error {
     return e.Err
 }
 
-// NewError creates a new FSError.
+// NewError creates a new FSError, with the status of the error it wraps.
 func NewError(op, path string, err error) error {
     return &FSError{
-        Op:   op,
-        Path: path,
-        Err:  err,
+        Op:     op,
+        Path:   path,
+        Status: Classify(err),
+        Err:    err,
     }
-}
\ No newline at end of file
+}
+
+// NewInodeError creates a new FSError about the file with the given inode,
+// such as the file of a handle that no longer resolves.
+func NewInodeError(op, path string, inode uint64, err error) error {
+    return &FSError{
+        Op:     op,
+        Path:   path,
+        Inode:  inode,
+        Status: Classify(err),
+        Err:    err,
+    }
+}
+
+// NewStatusError creates a new FSError answered with status, for errors
+// whose status cannot be told from the error itself.
+func NewStatusError(op, path string, status api.Status, err error) error {
+    return &FSError{
+        Op:     op,
+        Path:   path,
+        Status: status,
+        Err:    err,
+    }
+}
+
+// Classify returns the NFS status of err: that of the outermost FSError
+// with a status, else that of the error, errno or context error it wraps.
+// It returns OK for errors it does not know.
+func Classify(err error) api.Status {
+    for e := err; e != nil; e = errors.Unwrap(e) {
+        if fsErr, ok := e.(*FSError); ok && fsErr.Status != api.Status_OK {
+            return fsErr.Status
+        }
+    }
+
+    // A deadline or cancellation cut the operation short; the client may
+    // try again
+    if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+        return api.Status_ERR_JUKEBOX
+    }
+    for _, s := range sentinelStatus {
+        if errors.Is(err, s.err) {
+            return s.status
+        }
+    }
+
+    // Map standard Go errors
+    if errors.Is(err, os.ErrPermission) {
+        return api.Status_ERR_PERM
+    } else if errors.Is(err, os.ErrNotExist) {
+        return api.Status_ERR_NOENT
+    } else if errors.Is(err, os.ErrExist) {
+        return api.Status_ERR_EXIST
+    }
+
+    var errno syscall.Errno
+    if errors.As(err, &errno) {
+        return errnoStatus[errno]
+    }
+    return api.Status_OK
+}
@@ -0,0 +1,44 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want api.Status
+	}{
+		{"Sentinel", NewError("Lookup", "/a", ErrNotExist), api.Status_ERR_NOENT},
+		{"Errno", NewError("Write", "/a", &os.PathError{Op: "write", Path: "/a", Err: syscall.EDQUOT}), api.Status_ERR_DQUOT},
+		{"Status given", NewStatusError("GetAttr", "/a", api.Status_ERR_SERVERFAULT, errors.New("no stat")), api.Status_ERR_SERVERFAULT},
+		{"Status under other wrapping", fmt.Errorf("retry: %w", NewStatusError("Read", "/a", api.Status_ERR_STALE, ErrIO)), api.Status_ERR_STALE},
+		{"Outer status wins over inner", NewStatusError("Rename", "/a", api.Status_ERR_XDEV, NewError("Rename", "/b", ErrExist)), api.Status_ERR_XDEV},
+		{"Deadline", NewError("ReadDir", "/", context.DeadlineExceeded), api.Status_ERR_JUKEBOX},
+		{"Unknown", NewError("Read", "/a", errors.New("something odd")), api.Status_OK},
+		{"Unknown errno", syscall.ELOOP, api.Status_OK},
+		{"Nil", nil, api.Status_OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	// The status is kept with the error, along with the inode
+	var fsErr *FSError
+	err := NewInodeError("FileHandleToPath", "", 42, ErrStale)
+	if !errors.As(err, &fsErr) || fsErr.Status != api.Status_ERR_STALE || fsErr.Inode != 42 {
+		t.Errorf("NewInodeError = %#v, want ERR_STALE for inode 42", err)
+	}
+}
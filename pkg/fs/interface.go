@@ -50,9 +50,18 @@ type FileSystem interface {
     // Returns an error if the directory doesn't exist, is not empty, or cannot be removed.
     Rmdir(ctx context.Context, path string) error
     
+    // EntryCount returns the number of entries in a directory, not counting
+    // "." and "..". Implementations should avoid reading each entry's attributes.
+    EntryCount(ctx context.Context, dir string) (uint64, error)
+    
+    // IsEmpty reports whether a directory has no entries. Implementations
+    // should stop at the first entry rather than reading the whole directory.
+    IsEmpty(ctx context.Context, dir string) (bool, error)
+    
     // ReadDir reads the contents of a directory.
     // cookie can be used for pagination, and should be the cookie of the last entry
-    // from a previous call.
+    // from a previous call. An entry's cookie should not change as others
+    // are added or removed; see SetNameCookies.
     // count specifies the maximum number of entries to return.
     // Returns directory entries, the next cookie to use, and any error.
     ReadDir(ctx context.Context, dir string, cookie int64, count int) ([]DirEntry, int64, error)
@@ -61,9 +70,10 @@ type FileSystem interface {
     // This can be significantly more efficient than separate Lookup calls for each entry.
     ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]DirEntry, int64, error)
     
-    // Rename renames a file or directory.
+    // Rename renames a file or directory, replacing newPath unless flags
+    // say otherwise. RenameNoReplace and RenameExchange cannot be combined.
     // Returns an error if the source doesn't exist or the operation fails.
-    Rename(ctx context.Context, oldPath string, newPath string) error
+    Rename(ctx context.Context, oldPath string, newPath string, flags RenameFlags) error
     
     // Symlink creates a symbolic link.
     // Returns the path to the new symlink and its attributes.
@@ -73,20 +83,187 @@ type FileSystem interface {
     // Returns the target path and any error.
     Readlink(ctx context.Context, path string) (string, error)
     
+    // Link creates a hard link dir/name to the existing file at path.
+    // Returns the path of the new link and the file's updated attributes.
+    // Directories cannot be linked.
+    Link(ctx context.Context, path string, dir string, name string) (string, FileInfo, error)
+    
     // StatFS retrieves file system statistics.
     // Returns information about total space, free space, etc.
     StatFS(ctx context.Context) (FSStat, error)
     
     // FileHandleToPath converts a file handle to a file system path.
+    // Resolving a handle may search the tree; implementations must give up
+    // with the context's error once it is done, rather than report the
+    // handle stale.
     // Returns the path and any error.
-    FileHandleToPath(fh []byte) (string, error)
+    FileHandleToPath(ctx context.Context, fh []byte) (string, error)
     
     // PathToFileHandle converts a file system path to a file handle.
     // Returns the file handle and any error.
-    PathToFileHandle(path string) ([]byte, error)
+    PathToFileHandle(ctx context.Context, path string) ([]byte, error)
+
+    // Commit ensures that count bytes of the file starting at offset have
+    // been flushed to stable storage; count 0 means through the end of the
+    // file. Implementations may flush more than the range.
+    Commit(ctx context.Context, path string, offset int64, count int64) error
+}
+
+// QuotaReporter is implemented by file systems that enforce quotas.
+type QuotaReporter interface {
+    // Quota returns the usage charged to uid on the file system containing path.
+    Quota(ctx context.Context, path string, uid uint32) (QuotaUsage, error)
+}
+
+// ExportQuotaReporter is implemented by file systems that enforce a quota on
+// everything they hold, whoever owns it.
+type ExportQuotaReporter interface {
+    // ExportQuota returns the usage of the whole file system against the
+    // limits enforced on it.
+    ExportQuota(ctx context.Context) (QuotaUsage, error)
+}
+
+// UnnamedCreator is implemented by file systems that can create files with
+// no directory entry, in the manner of O_TMPFILE.
+type UnnamedCreator interface {
+    // CreateUnnamed creates an anonymous file on behalf of directory dir.
+    // The returned path is internal and only useful for obtaining a handle.
+    CreateUnnamed(ctx context.Context, dir string, attr FileAttr) (string, FileInfo, error)
+    
+    // LinkUnnamed atomically publishes an unnamed file as dir/name. If replace
+    // is false and the name exists, it fails with ErrExist.
+    LinkUnnamed(ctx context.Context, path string, dir string, name string, replace bool) (string, FileInfo, error)
+}
+
+// Checker is implemented by file systems that keep an index of the stored
+// tree, such as inode to path mappings, that can diverge from it.
+type Checker interface {
+    // Check compares the index with the tree. With repair set, divergences
+    // are fixed and orphaned entries removed.
+    Check(ctx context.Context, repair bool) (CheckReport, error)
+}
+
+// IndexPersister is implemented by file systems whose index can be saved and
+// loaded again, so handles resolve without rebuilding it after a restart.
+type IndexPersister interface {
+    // LoadIndex merges an index saved in dir into the current one.
+    LoadIndex(dir string) error
+    
+    // SaveIndex writes the index to dir, skipping parts unchanged since
+    // the last save or load.
+    SaveIndex(dir string) error
+}
+
+// HandleLister is implemented by file systems that can enumerate the
+// handles of the files they have indexed, for disaster recovery dumps.
+type HandleLister interface {
+    // ListHandles calls fn with the path and handle of every indexed file
+    // until it returns false.
+    ListHandles(ctx context.Context, fn func(path string, handle []byte) bool) error
+}
+
+// BufferReader is implemented by file systems that can read into a buffer
+// supplied by the caller, letting the server reuse read buffers.
+type BufferReader interface {
+    // ReadInto reads up to len(buf) bytes at offset into buf. It returns the
+    // number of bytes read and whether the end of the file was reached.
+    ReadInto(ctx context.Context, path string, offset int64, buf []byte) (int, bool, error)
+}
+
+// Trasher is implemented by file systems with a private area entries can be
+// moved into, out of the namespace, to be deleted later. Paths inside it are
+// ordinary paths for every other method but appear in no listing.
+type Trasher interface {
+    // Trash atomically moves path into the trash area, returning its path
+    // there.
+    Trash(ctx context.Context, path string) (string, error)
+    
+    // Trashed returns the paths of the entries in the trash area, such as
+    // those left by an earlier run.
+    Trashed(ctx context.Context) ([]string, error)
+}
+
+// SnapshotsDir is the directory below the root that a Snapshotter's
+// snapshots are reached through
+const SnapshotsDir = "/.snapshots"
+
+// Snapshotter is implemented by file systems that can take read-only,
+// point-in-time copies of their tree. Each snapshot is reached at
+// /.snapshots/<name>, where every method that would change it fails with
+// ErrReadOnly.
+type Snapshotter interface {
+    // Snapshot copies the tree as it is now into a snapshot called name.
+    // It fails with ErrExist if the name is taken.
+    Snapshot(ctx context.Context, name string) (SnapshotInfo, error)
+    
+    // ListSnapshots returns the snapshots, oldest first.
+    ListSnapshots(ctx context.Context) ([]SnapshotInfo, error)
+    
+    // DeleteSnapshot removes the snapshot called name.
+    DeleteSnapshot(ctx context.Context, name string) error
+}
+
+// Allocator is implemented by file systems that keep files sparse, with
+// ranges never written taking no space, and can tell those ranges apart.
+type Allocator interface {
+    // Allocate reserves space for length bytes of the file at path from
+    // offset, so writing them cannot fail for want of it. A range past the
+    // end of the file extends it.
+    Allocate(ctx context.Context, path string, offset int64, length int64) error
+    
+    // Deallocate releases the space of length bytes of the file at path
+    // from offset, which then read as zeros. The file keeps its size.
+    Deallocate(ctx context.Context, path string, offset int64, length int64) error
+    
+    // Seek returns the offset of the first byte of data, or of a hole, as
+    // what says, at or after offset in the file at path. Every file ends
+    // in a hole at its size; seeking data from there fails with ErrNoData.
+    Seek(ctx context.Context, path string, offset int64, what SeekContent) (int64, error)
+}
+
+// RangeCopier is implemented by file systems that copy data from one file
+// to another themselves, sparing the server a read and a write of it.
+type RangeCopier interface {
+    // CopyRange copies length bytes of the file at src from srcOffset to
+    // the file at dst at dstOffset, which grows if the range ends past its
+    // end. Length 0 copies to the end of src. It returns the number of
+    // bytes copied, fewer only if src ends first.
+    CopyRange(ctx context.Context, src string, srcOffset int64, dst string, dstOffset int64, length int64) (int64, error)
+}
+
+// ACLSetter is implemented by file systems that keep POSIX access control
+// lists, which they report in FileInfo.ACL and enforce in Access.
+type ACLSetter interface {
+    // SetACL replaces the access control list of the file at path, also
+    // setting its mode bits to match. An empty acl removes it, leaving the
+    // mode bits alone. It fails with ErrInvalidName if acl does not
+    // Validate.
+    SetACL(ctx context.Context, path string, acl ACL) error
+}
+
+// Unwrapper is implemented by file systems that wrap another, adding to its
+// behavior, such as to enforce quotas. The optional interfaces above that
+// the wrapper does not implement itself are reached through it with As.
+type Unwrapper interface {
+    // Unwrap returns the wrapped file system.
+    Unwrap() FileSystem
+}
 
-    // Commit ensures that all data for the specified file has been flushed to stable storage
-    Commit(ctx context.Context, path string) error
+// As returns fileSystem as a T, an optional interface, if it implements it,
+// or else the first file system it wraps that does.
+func As[T any](fileSystem FileSystem) (T, bool) {
+    for fileSystem != nil {
+        if t, ok := fileSystem.(T); ok {
+            return t, true
+        }
+        unwrapper, ok := fileSystem.(Unwrapper)
+        if !ok {
+            break
+        }
+        fileSystem = unwrapper.Unwrap()
+    }
+    var zero T
+    return zero, false
 }
 
 // Credentials represents the authentication information for a user.
@@ -0,0 +1,137 @@
+package local
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// aclXattr holds a file's POSIX access ACL in the kernel's format: a
+// little-endian version, then the tag, permission bits and ID of each
+// entry. The kernel keeps it and the mode bits in step.
+const aclXattr = "system.posix_acl_access"
+
+const (
+    aclVersion   = 2
+    aclEntrySize = 8
+    aclNoID      = 0xffffffff // ID of entries that name nobody
+)
+
+// aclTags are the kernel's tags for the fs.ACLTag values, in order
+var aclTags = [...]uint16{0x01, 0x02, 0x04, 0x08, 0x10, 0x20}
+
+// readACL returns the access ACL of fullPath, nil if it has none or the
+// file system keeps none
+func readACL(fullPath string) (fs.ACL, error) {
+    buf := make([]byte, 4+8*aclEntrySize)
+    n, err := getxattr(fullPath, aclXattr, buf)
+    if err == syscall.ERANGE {
+        // More named users and groups than fit; ask for the size
+        if n, err = getxattr(fullPath, aclXattr, nil); err == nil {
+            buf = make([]byte, n)
+            n, err = getxattr(fullPath, aclXattr, buf)
+        }
+    }
+    if noXattr(err) || errors.Is(err, errors.ErrUnsupported) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    return decodeACL(buf[:n])
+}
+
+// decodeACL parses an ACL in the format of aclXattr
+func decodeACL(buf []byte) (fs.ACL, error) {
+    if len(buf) < 4 || (len(buf)-4)%aclEntrySize != 0 || binary.LittleEndian.Uint32(buf) != aclVersion {
+        return nil, fs.ErrIO
+    }
+    acl := make(fs.ACL, 0, (len(buf)-4)/aclEntrySize)
+    for b := buf[4:]; len(b) > 0; b = b[aclEntrySize:] {
+        tag := -1
+        for t, kernelTag := range aclTags {
+            if kernelTag == binary.LittleEndian.Uint16(b) {
+                tag = t
+            }
+        }
+        if tag < 0 {
+            return nil, fs.ErrIO
+        }
+        entry := fs.ACLEntry{
+            Tag:  fs.ACLTag(tag),
+            Perm: fs.FileMode(binary.LittleEndian.Uint16(b[2:]) & 7),
+        }
+        if entry.Tag == fs.ACLUser || entry.Tag == fs.ACLGroup {
+            entry.ID = binary.LittleEndian.Uint32(b[4:])
+        }
+        acl = append(acl, entry)
+    }
+    return acl, nil
+}
+
+// encodeACL formats acl, which must Validate, as aclXattr holds it
+func encodeACL(acl fs.ACL) []byte {
+    buf := binary.LittleEndian.AppendUint32(nil, aclVersion)
+    for _, entry := range acl {
+        id := uint32(aclNoID)
+        if entry.Tag == fs.ACLUser || entry.Tag == fs.ACLGroup {
+            id = entry.ID
+        }
+        buf = binary.LittleEndian.AppendUint16(buf, aclTags[entry.Tag])
+        buf = binary.LittleEndian.AppendUint16(buf, uint16(entry.Perm))
+        buf = binary.LittleEndian.AppendUint32(buf, id)
+    }
+    return buf
+}
+
+// copyACL gives the file at dst the access ACL of the file at src, if it
+// has one. Like ownership, it is copied where it can be and otherwise
+// left behind.
+func copyACL(src string, dst string) {
+    if acl, err := readACL(src); err == nil && acl != nil {
+        setxattr(dst, aclXattr, encodeACL(acl))
+    }
+}
+
+// SetACL replaces the access ACL of the file at path. The kernel sets the
+// mode bits to match, and drops an ACL the mode bits alone express.
+func (l *LocalFileSystem) SetACL(ctx context.Context, path string, acl fs.ACL) error {
+    if err := readOnly("SetACL", path); err != nil {
+        return err
+    }
+    if len(acl) > 0 {
+        if err := acl.Validate(); err != nil {
+            return fs.NewError("SetACL", path, err)
+        }
+    }
+    
+    fullPath, err := l.resolveFollow(path)
+    if err != nil {
+        return fs.NewError("SetACL", path, err)
+    }
+    
+    // Snapshots sharing the file keep its old ACL
+    l.snapshotMu.RLock()
+    defer l.snapshotMu.RUnlock()
+    if err := l.unshare(fullPath); err != nil {
+        return fs.NewError("SetACL", path, mapOSError(err))
+    }
+    
+    if len(acl) == 0 {
+        err = removexattr(fullPath, aclXattr)
+        if noXattr(err) {
+            err = nil
+        }
+    } else {
+        err = setxattr(fullPath, aclXattr, encodeACL(acl))
+    }
+    if errors.Is(err, errors.ErrUnsupported) {
+        return fs.NewError("SetACL", path, fs.ErrNotSupported)
+    } else if err != nil {
+        return fs.NewError("SetACL", path, mapOSError(err))
+    }
+    l.bumpChangeID(fullPath)
+    return nil
+}
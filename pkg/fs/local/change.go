@@ -0,0 +1,111 @@
+package local
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// changeIDXattr is the extended attribute holding a file's change counter,
+// so the counter survives server restarts
+const changeIDXattr = "user.nfs.change"
+
+// changeEpochName is the file in the staging directory recording the epoch
+// of the last run, the floor of counters kept in memory
+const changeEpochName = "change-epoch"
+
+// loadChangeEpoch sets the floor of the change counters kept in memory for
+// files without extended attributes. Those counters are lost on restart;
+// starting them past both the previous run's epoch and the current time
+// keeps the attribute increasing, as every value handed out before was
+// derived from an earlier ctime or epoch. Clients see such files change
+// once per restart.
+func (l *LocalFileSystem) loadChangeEpoch() {
+    epoch := uint64(time.Now().UnixNano())
+    if data, err := os.ReadFile(l.changeEpochPath()); err == nil {
+        if last, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err == nil {
+            epoch = max(epoch, last+1)
+        }
+    }
+    l.changeEpoch = epoch
+}
+
+// recordChangeEpoch records the epoch once a counter is first kept in
+// memory, so exports whose files all hold their counters never get a
+// staging directory for it. It is best effort: a read-only export falls
+// back to the clock alone.
+func (l *LocalFileSystem) recordChangeEpoch() {
+    l.changeEpochOnce.Do(func() {
+        epochPath := l.changeEpochPath()
+        if err := os.MkdirAll(filepath.Dir(epochPath), 0700); err == nil {
+            os.WriteFile(epochPath, strconv.AppendUint(nil, l.changeEpoch, 10), 0600)
+        }
+    })
+}
+
+// changeEpochPath returns the OS path of the file recording the epoch
+func (l *LocalFileSystem) changeEpochPath() string {
+    return filepath.Join(l.rootPath, stagingDirName, changeEpochName)
+}
+
+// changeID returns the change attribute of a file: the larger of its stored
+// counter, which every mutation made through this file system increments,
+// and its ctime in nanoseconds, which also moves when the tree is modified
+// directly. Unlike mtime, it changes even when several updates land within
+// the timestamp granularity of the underlying file system.
+func (l *LocalFileSystem) changeID(fullPath string, stat *fileStat) uint64 {
+    ctime := uint64(stat.Ctime.UnixNano())
+    return max(l.storedChangeID(fullPath, stat), ctime)
+}
+
+// storedChangeID reads the counter from the file's extended attribute, or
+// from memory where extended attributes are unavailable, starting at the
+// run's epoch
+func (l *LocalFileSystem) storedChangeID(fullPath string, stat *fileStat) uint64 {
+    if !stat.Symlink {
+        buf := make([]byte, 20)
+        n, err := getxattr(fullPath, changeIDXattr, buf)
+        if err == nil {
+            if v, err := strconv.ParseUint(string(buf[:n]), 10, 64); err == nil {
+                return v
+            }
+        } else if noXattr(err) {
+            // Never bumped; its ctime is the attribute
+            return 0
+        }
+    }
+    if v, ok := l.changeIDs.Load(stat.Ino); ok {
+        return v.(uint64)
+    }
+    l.recordChangeEpoch()
+    return l.changeEpoch
+}
+
+// bumpChangeID advances the change attribute of the file at fullPath. It is
+// called after every mutation of the file's data, attributes or, for
+// directories, entries. Failures are ignored: ctime still moves.
+func (l *LocalFileSystem) bumpChangeID(fullPath string) {
+    l.changeMu.Lock()
+    defer l.changeMu.Unlock()
+
+    info, err := l.lstat(fullPath)
+    if err != nil {
+        return
+    }
+    stat, ok := statOf(fullPath, info)
+    if !ok {
+        return
+    }
+    next := l.changeID(fullPath, stat) + 1
+
+    // User extended attributes are not allowed on symlinks
+    if !stat.Symlink {
+        value := strconv.AppendUint(nil, next, 10)
+        if err := setxattr(fullPath, changeIDXattr, value); err == nil {
+            return
+        }
+    }
+    l.changeIDs.Store(stat.Ino, next)
+}
@@ -0,0 +1,131 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestChangeID(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	lfs, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	ctx := context.Background()
+
+	changeID := func(path string) uint64 {
+		t.Helper()
+		info, err := lfs.GetAttr(ctx, path)
+		if err != nil {
+			t.Fatalf("GetAttr(%s) failed: %v", path, err)
+		}
+		if info.ChangeID == 0 {
+			t.Fatalf("GetAttr(%s) reported no change attribute", path)
+		}
+		return info.ChangeID
+	}
+
+	// Every mutation moves the counter, even several within one clock tick
+	last := changeID("/file.txt")
+	for i := 0; i < 3; i++ {
+		if _, err := lfs.Write(ctx, "/file.txt", 0, []byte("DATA"), false); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if id := changeID("/file.txt"); id <= last {
+			t.Fatalf("Change attribute after write %d = %d, want > %d", i, id, last)
+		} else {
+			last = id
+		}
+	}
+
+	mtime := time.Unix(1700000000, 0)
+	if _, err := lfs.SetAttr(ctx, "/file.txt", fs.FileAttr{ModifyTime: &mtime}); err != nil {
+		t.Fatalf("SetAttr failed: %v", err)
+	}
+	if id := changeID("/file.txt"); id <= last {
+		t.Errorf("Change attribute after SetAttr = %d, want > %d", id, last)
+	} else {
+		last = id
+	}
+
+	// Directory entries changing moves the directory's counter
+	dirID := changeID("/")
+	if _, _, err := lfs.Create(ctx, "/", "new.txt", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if id := changeID("/"); id <= dirID {
+		t.Errorf("Directory change attribute after Create = %d, want > %d", id, dirID)
+	} else {
+		dirID = id
+	}
+	if err := lfs.Rename(ctx, "/new.txt", "/renamed.txt", 0); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if id := changeID("/"); id <= dirID {
+		t.Errorf("Directory change attribute after Rename = %d, want > %d", id, dirID)
+	}
+
+	// The counter survives a restart
+	restarted, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to recreate filesystem: %v", err)
+	}
+	info, err := restarted.GetAttr(ctx, "/file.txt")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if info.ChangeID < last {
+		t.Errorf("Change attribute after restart = %d, want >= %d", info.ChangeID, last)
+	}
+}
+
+func TestChangeIDWithoutXattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Symlink("target", filepath.Join(tempDir, "link")); err != nil {
+		t.Fatalf("Failed to create test symlink: %v", err)
+	}
+
+	lfs, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	ctx := context.Background()
+	changeID := func(lfs *LocalFileSystem) uint64 {
+		t.Helper()
+		info, err := lfs.GetAttr(ctx, "/link")
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		return info.ChangeID
+	}
+
+	// Symlinks cannot hold the counter, so it is kept in memory
+	first := changeID(lfs)
+	lfs.bumpChangeID(filepath.Join(tempDir, "link"))
+	lfs.bumpChangeID(filepath.Join(tempDir, "link"))
+	last := changeID(lfs)
+	if last != first+2 {
+		t.Errorf("Change attribute after two changes = %d, want %d", last, first+2)
+	}
+
+	// A restart loses the counter but starts past it, at the next epoch
+	restarted, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to recreate filesystem: %v", err)
+	}
+	if restarted.changeEpoch <= lfs.changeEpoch {
+		t.Errorf("Epoch after restart = %d, want > %d", restarted.changeEpoch, lfs.changeEpoch)
+	}
+	if id := changeID(restarted); id <= last {
+		t.Errorf("Change attribute after restart = %d, want > %d", id, last)
+	}
+}
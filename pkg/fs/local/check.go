@@ -0,0 +1,122 @@
+package local
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// stagingOrphanAge is how long an unnamed file may go unmodified before a
+// check treats it as abandoned by its client
+const stagingOrphanAge = time.Hour
+
+// Check compares the inode to path index with the export tree. Index entries
+// for deleted files are stale and entries whose path now holds a different
+// inode have moved; with repair set they are dropped, with their inodes
+// moved to a new generation, or corrected, entries missing from the index
+// are added, and abandoned unnamed files are removed.
+func (l *LocalFileSystem) Check(ctx context.Context, repair bool) (fs.CheckReport, error) {
+    report := fs.CheckReport{Repaired: repair}
+    
+    // Map every inode in the tree to the first path it was found at
+    tree := make(map[uint64]string)
+    snapshotsPath := l.snapshotsPath()
+    err := filepath.WalkDir(l.rootPath, func(fullPath string, d os.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        
+        // Files in snapshots are not indexed
+        if fullPath == snapshotsPath {
+            return filepath.SkipDir
+        }
+        
+        rel, err := filepath.Rel(l.rootPath, fullPath)
+        if err != nil {
+            return err
+        }
+        path := filepath.Join("/", rel)
+        
+        // Dangling symlinks have no inode of their own in the index
+        info, err := os.Stat(fullPath)
+        if err != nil {
+            return nil
+        }
+        stat, ok := statOf(fullPath, info)
+        if !ok {
+            return nil
+        }
+        
+        report.Scanned++
+        if _, seen := tree[stat.Ino]; !seen {
+            tree[stat.Ino] = path
+        }
+        
+        // Unnamed files nobody has touched for a while were abandoned
+        if isStagingPath(path) && !isTrashPath(path) && !d.IsDir() && path != "/"+stagingDirName+"/"+changeEpochName && time.Since(info.ModTime()) > stagingOrphanAge {
+            report.Orphans = append(report.Orphans, path)
+        }
+        
+        return nil
+    })
+    if err != nil {
+        return report, fs.NewError("Check", "/", mapOSError(err))
+    }
+    
+    // Compare the index with the tree
+    l.inodeMap.Range(func(inode uint64, path string) bool {
+        report.Indexed++
+        
+        treePath, ok := tree[inode]
+        if !ok {
+            report.Stale++
+            if repair {
+                l.inodeMap.Delete(inode)
+                l.inodeMap.bumpGeneration(inode)
+            }
+            return true
+        }
+        
+        // Hard links give an inode several valid paths, so only an entry
+        // whose path no longer holds the inode has moved
+        if current, err := l.getInode(path); err != nil || current != inode {
+            report.Moved++
+            if repair {
+                l.updateInodeMap(treePath, inode)
+            }
+        }
+        return true
+    })
+    
+    for inode, path := range tree {
+        if _, ok := l.inodeMap.Load(inode); !ok {
+            report.Added++
+            if repair {
+                l.updateInodeMap(path, inode)
+            }
+        }
+    }
+    
+    sort.Strings(report.Orphans)
+    if repair {
+        for _, path := range report.Orphans {
+            inode, err := l.getInode(path)
+            if err == nil {
+                l.inodeMap.Delete(inode)
+                l.inodeMap.bumpGeneration(inode)
+            }
+            if fullPath, err := l.resolvePath(path); err == nil {
+                os.Remove(fullPath)
+            }
+        }
+    }
+    
+    return report, nil
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package local
+
+import (
+    "os"
+
+    "golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst with permissions perm as a reflink of src, sharing
+// its blocks until either is written, with the FICLONE ioctl. File systems
+// without reflinks, or src and dst on different ones, make it fail, and no
+// dst is left behind.
+func cloneFile(src string, dst string, perm os.FileMode) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    
+    out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+    if err != nil {
+        return err
+    }
+    if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+        out.Close()
+        os.Remove(dst)
+        return &os.LinkError{Op: "ficlone", Old: src, New: dst, Err: err}
+    }
+    return out.Close()
+}
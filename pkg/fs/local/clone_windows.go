@@ -0,0 +1,15 @@
+//go:build windows
+
+package local
+
+import (
+    "os"
+    "syscall"
+)
+
+// cloneFile would create dst as a reflink of src. Only ReFS has block
+// cloning on Windows, and it is not used, so files are always copied or
+// hard-linked instead.
+func cloneFile(src string, dst string, perm os.FileMode) error {
+    return &os.LinkError{Op: "clone", Old: src, New: dst, Err: syscall.EWINDOWS}
+}
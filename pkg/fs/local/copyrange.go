@@ -0,0 +1,88 @@
+package local
+
+import (
+    "context"
+    "io"
+    "os"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// CopyRange copies a range of one file into another within the export,
+// letting the kernel share the blocks where the file system can
+func (l *LocalFileSystem) CopyRange(ctx context.Context, src string, srcOffset int64, dst string, dstOffset int64, length int64) (int64, error) {
+    if err := readOnly("CopyRange", dst); err != nil {
+        return 0, err
+    }
+    if srcOffset < 0 || dstOffset < 0 || length < 0 {
+        return 0, fs.NewError("CopyRange", dst, fs.ErrInvalidName)
+    }
+
+    srcPath, err := l.resolveFollow(src)
+    if err != nil {
+        return 0, fs.NewError("CopyRange", src, err)
+    }
+    dstPath, err := l.resolveFollow(dst)
+    if err != nil {
+        return 0, fs.NewError("CopyRange", dst, err)
+    }
+
+    // Snapshots sharing the destination keep its old contents
+    l.snapshotMu.RLock()
+    defer l.snapshotMu.RUnlock()
+    if err := l.unshare(dstPath); err != nil {
+        return 0, fs.NewError("CopyRange", dst, mapOSError(err))
+    }
+
+    in, err := l.openFile(srcPath, os.O_RDONLY, 0)
+    if err != nil {
+        return 0, fs.NewError("CopyRange", src, mapOSError(err))
+    }
+    defer in.Close()
+    inInfo, err := in.Stat()
+    if err != nil {
+        return 0, fs.NewError("CopyRange", src, mapOSError(err))
+    }
+    if inInfo.IsDir() {
+        return 0, fs.NewError("CopyRange", src, fs.ErrIsDir)
+    }
+
+    out, err := l.openFile(dstPath, os.O_WRONLY, 0)
+    if err != nil {
+        return 0, fs.NewError("CopyRange", dst, mapOSError(err))
+    }
+    defer out.Close()
+    outInfo, err := out.Stat()
+    if err != nil {
+        return 0, fs.NewError("CopyRange", dst, mapOSError(err))
+    }
+
+    // Only what src holds is copied
+    remaining := max(inInfo.Size()-srcOffset, 0)
+    if length == 0 || length > remaining {
+        length = remaining
+    }
+    if length == 0 {
+        return 0, nil
+    }
+
+    // A range copied over itself has no defined result
+    if os.SameFile(inInfo, outInfo) && srcOffset < dstOffset+length && dstOffset < srcOffset+length {
+        return 0, fs.NewError("CopyRange", dst, fs.ErrInvalidName)
+    }
+
+    copied, err := copyRange(out, in, srcOffset, dstOffset, length)
+    if copied > 0 {
+        l.bumpChangeID(dstPath)
+    }
+    if err != nil {
+        return copied, fs.NewError("CopyRange", dst, mapOSError(err))
+    }
+    return copied, nil
+}
+
+// copyBytes copies length bytes of in from srcOffset to out at dstOffset
+// through the server's memory, where the kernel cannot copy them itself
+func copyBytes(out *os.File, in *os.File, srcOffset int64, dstOffset int64, length int64) (int64, error) {
+    return io.Copy(io.NewOffsetWriter(out, dstOffset), io.NewSectionReader(in, srcOffset, length))
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package local
+
+import (
+    "errors"
+    "os"
+
+    "golang.org/x/sys/unix"
+)
+
+// copyRange copies length bytes of in from srcOffset to out at dstOffset
+// with copy_file_range(2), which file systems with reflinks carry out by
+// sharing the blocks. Where the kernel cannot copy between the two files,
+// as across file systems before Linux 5.3, the bytes are copied here.
+func copyRange(out *os.File, in *os.File, srcOffset int64, dstOffset int64, length int64) (int64, error) {
+    var copied int64
+    for copied < length {
+        n, err := unix.CopyFileRange(int(in.Fd()), &srcOffset, int(out.Fd()), &dstOffset, int(min(length-copied, 1<<30)), 0)
+        if err != nil {
+            if copied == 0 && (errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL)) {
+                return copyBytes(out, in, srcOffset, dstOffset, length)
+            }
+            return copied, &os.PathError{Op: "copy_file_range", Path: out.Name(), Err: err}
+        }
+        if n == 0 {
+            break // The source ended meanwhile
+        }
+        copied += int64(n)
+    }
+    return copied, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package local
+
+import (
+    "os"
+)
+
+// copyRange copies length bytes of in from srcOffset to out at dstOffset.
+// FreeBSD's copy_file_range(2) is not used, so the bytes are always copied
+// here.
+func copyRange(out *os.File, in *os.File, srcOffset int64, dstOffset int64, length int64) (int64, error) {
+    return copyBytes(out, in, srcOffset, dstOffset, length)
+}
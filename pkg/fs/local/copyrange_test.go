@@ -0,0 +1,57 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestCopyRange(t *testing.T) {
+	localFS, tempDir, cleanup := setupTestFS(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	createTestFile(t, tempDir, "src", "0123456789")
+	createTestFile(t, tempDir, "dst", "abc")
+	if _, err := localFS.Snapshot(ctx, "before"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	before, _ := localFS.GetAttr(ctx, "/dst")
+
+	// Length 0 copies to the end of the source, growing the destination
+	if n, err := localFS.CopyRange(ctx, "/src", 4, "/dst", 2, 0); err != nil || n != 6 {
+		t.Errorf("CopyRange = %d, %v; want 6", n, err)
+	}
+	if data, _, err := localFS.Read(ctx, "/dst", 0, 100); err != nil || string(data) != "ab456789" {
+		t.Errorf("Destination = %q, %v; want \"ab456789\"", data, err)
+	}
+	if after, err := localFS.GetAttr(ctx, "/dst"); err != nil || after.ChangeID == before.ChangeID {
+		t.Errorf("Change attribute stayed %d, %v", after.ChangeID, err)
+	}
+	if data, _, err := localFS.Read(ctx, "/.snapshots/before/dst", 0, 100); err != nil || string(data) != "abc" {
+		t.Errorf("Snapshot of the destination = %q, %v; want \"abc\"", data, err)
+	}
+
+	// Ranges past the end of the source copy what there is
+	if n, err := localFS.CopyRange(ctx, "/src", 8, "/dst", 0, 100); err != nil || n != 2 {
+		t.Errorf("CopyRange past the end = %d, %v; want 2", n, err)
+	}
+	if n, err := localFS.CopyRange(ctx, "/src", 20, "/dst", 0, 100); err != nil || n != 0 {
+		t.Errorf("CopyRange from beyond the end = %d, %v; want 0", n, err)
+	}
+
+	if _, err := localFS.CopyRange(ctx, "/src", 0, "/src", 5, 6); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("CopyRange over itself = %v, want ErrInvalidName", err)
+	}
+	if n, err := localFS.CopyRange(ctx, "/src", 0, "/src", 5, 5); err != nil || n != 5 {
+		t.Errorf("CopyRange within a file = %d, %v; want 5", n, err)
+	}
+	if _, err := localFS.CopyRange(ctx, "/src", 0, "/.snapshots/before/dst", 0, 0); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("CopyRange into a snapshot = %v, want ErrReadOnly", err)
+	}
+	if _, err := localFS.CopyRange(ctx, "/", 0, "/dst", 0, 0); !errors.Is(err, fs.ErrIsDir) {
+		t.Errorf("CopyRange from a directory = %v, want ErrIsDir", err)
+	}
+}
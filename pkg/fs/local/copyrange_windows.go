@@ -0,0 +1,14 @@
+//go:build windows
+
+package local
+
+import (
+    "os"
+)
+
+// copyRange copies length bytes of in from srcOffset to out at dstOffset.
+// FSCTL_DUPLICATE_EXTENTS_TO_FILE, which clones blocks on ReFS, is not
+// used, so the bytes are always copied here.
+func copyRange(out *os.File, in *os.File, srcOffset int64, dstOffset int64, length int64) (int64, error) {
+    return copyBytes(out, in, srcOffset, dstOffset, length)
+}
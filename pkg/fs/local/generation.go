@@ -0,0 +1,225 @@
+package local
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+const (
+    // generationsFile holds the generation numbers saved in an index
+    // directory
+    generationsFile = "generations"
+    
+    // generationsMagic starts a saved generations file
+    generationsMagic = "NFSGEN1\n"
+    
+    // initialGeneration is the generation of an inode never seen to be
+    // reused
+    initialGeneration = 1
+)
+
+// generationTable holds the generation numbers of inodes. Only inodes whose
+// generation was bumped from the initial one are recorded: a file that was
+// removed, or whose inode turned up under an unrelated name, so handles of
+// the old file do not resolve to whatever file gets the inode next.
+//
+// The table also keeps the creation time of the file each inode was last
+// seen holding, where the file system records one. A file removed and
+// recreated behind the server's back can get its old inode and even its
+// old name, which only its creation time tells apart.
+type generationTable struct {
+    mu     sync.RWMutex
+    gens   map[uint64]uint32
+    births map[uint64]int64
+    dirty  bool
+}
+
+// Generation returns the current generation of inode
+func (x *inodeIndex) Generation(inode uint64) uint32 {
+    x.generations.mu.RLock()
+    gen, ok := x.generations.gens[inode]
+    x.generations.mu.RUnlock()
+    if !ok {
+        return initialGeneration
+    }
+    return gen
+}
+
+// bumpGeneration moves inode to its next generation, invalidating every
+// handle issued for it so far
+func (x *inodeIndex) bumpGeneration(inode uint64) {
+    t := &x.generations
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    x.bumpLocked(inode)
+}
+
+// checkBirth records birth as the creation time of the file holding inode.
+// If another was recorded, the inode now holds a new file: its generation
+// is bumped, once however many callers notice at the same time. A zero
+// birth, from a file system not recording creation times, is ignored.
+func (x *inodeIndex) checkBirth(inode uint64, birth int64) {
+    if birth == 0 {
+        return
+    }
+    t := &x.generations
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    recorded, ok := t.births[inode]
+    if ok && recorded == birth {
+        return
+    }
+    if ok {
+        x.bumpLocked(inode)
+    }
+    if t.births == nil {
+        t.births = make(map[uint64]int64)
+    }
+    t.births[inode] = birth
+}
+
+// bumpLocked moves inode to its next generation and forgets the creation
+// time of its file; x.generations.mu must be held
+func (x *inodeIndex) bumpLocked(inode uint64) {
+    t := &x.generations
+    delete(t.births, inode)
+    gen, ok := t.gens[inode]
+    if !ok {
+        gen = initialGeneration
+    }
+    gen++
+    if gen == 0 {
+        gen = initialGeneration
+    }
+    t.set(inode, gen)
+    x.journal.generation(inode, gen)
+}
+
+// set records gen for inode; t.mu must be held
+func (t *generationTable) set(inode uint64, gen uint32) {
+    if t.gens == nil {
+        t.gens = make(map[uint64]uint32)
+    }
+    t.gens[inode] = gen
+    t.dirty = true
+}
+
+// saveGenerations writes the generation table to dir if it changed since
+// it was last saved or loaded
+func (x *inodeIndex) saveGenerations(dir string) error {
+    t := &x.generations
+    t.mu.Lock()
+    if !t.dirty {
+        t.mu.Unlock()
+        return nil
+    }
+    data := []byte(generationsMagic)
+    for inode, gen := range t.gens {
+        data = binary.AppendUvarint(data, inode)
+        data = binary.AppendUvarint(data, uint64(gen))
+    }
+    t.dirty = false
+    t.mu.Unlock()
+    
+    if err := writeShard(filepath.Join(dir, generationsFile), data); err != nil {
+        t.mu.Lock()
+        t.dirty = true
+        t.mu.Unlock()
+        return err
+    }
+    return nil
+}
+
+// loadGenerations merges the generation table saved in dir. Saved
+// generations only replace lower ones, as generations never go back.
+func (x *inodeIndex) loadGenerations(dir string) error {
+    file := filepath.Join(dir, generationsFile)
+    f, err := os.Open(file)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    
+    r := bufio.NewReader(f)
+    magic := make([]byte, len(generationsMagic))
+    if _, err := io.ReadFull(r, magic); err != nil || string(magic) != generationsMagic {
+        return errors.New(file + ": not a generations file")
+    }
+    t := &x.generations
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for {
+        inode, err := binary.ReadUvarint(r)
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return errors.New(file + ": truncated or corrupt entry")
+        }
+        gen, err := binary.ReadUvarint(r)
+        if err != nil || gen > 1<<32-1 {
+            return errors.New(file + ": truncated or corrupt entry")
+        }
+        if current, ok := t.gens[inode]; !ok || current < uint32(gen) {
+            t.set(inode, uint32(gen))
+        }
+    }
+}
+
+// observed records that inode was found at path. If the index has the inode
+// at another path that no longer holds it, the file there was removed or
+// moved behind the server's back and the inode may now be a different file,
+// so its generation is bumped and older handles go stale.
+func (l *LocalFileSystem) observed(path string, inode uint64) {
+    if isSnapshotPath(path) {
+        return
+    }
+    if indexed, ok := l.inodeMap.Load(inode); ok && indexed != path && !l.holds(indexed, inode) {
+        // Operations that move or remove names update the index right
+        // after the change; wait for those in progress
+        l.namespaceMu.Lock()
+        if indexed, ok := l.inodeMap.Load(inode); ok && indexed != path && !l.holds(indexed, inode) {
+            l.inodeMap.bumpGeneration(inode)
+        }
+        l.namespaceMu.Unlock()
+    }
+    l.updateInodeMap(path, inode)
+}
+
+// checkBirth bumps the generation of inode if the file at path, which
+// holds it, was created after the file last seen holding it. Creation times
+// are only remembered while the server runs, so a file replaced while it
+// was down goes unnoticed.
+func (l *LocalFileSystem) checkBirth(path string, inode uint64) {
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return
+    }
+    ino, birth, ok := birthTime(fullPath)
+    if !ok || ino != inode {
+        return
+    }
+    l.inodeMap.checkBirth(inode, birth)
+}
+
+// holds reports whether the file at path is inode
+func (l *LocalFileSystem) holds(path string, inode uint64) bool {
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return false
+    }
+    info, err := l.lstat(fullPath)
+    if err != nil {
+        return false
+    }
+    stat, ok := statOf(fullPath, info)
+    return ok && stat.Ino == inode
+}
@@ -0,0 +1,228 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestGenerations(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	localFS, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	handleOf := func(name string) []byte {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil && !errors.Is(err, os.ErrExist) {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		handle, err := localFS.PathToFileHandle(ctx, "/"+name)
+		if err != nil {
+			t.Fatalf("PathToFileHandle failed: %v", err)
+		}
+		return handle
+	}
+	generation := func(handle []byte) uint32 {
+		fh, err := fs.DeserializeFileHandle(handle)
+		if err != nil {
+			t.Fatalf("DeserializeFileHandle failed: %v", err)
+		}
+		return fh.Generation
+	}
+
+	// An inode turning up under another name, its old one gone, may be
+	// a new file
+	moved := handleOf("moved")
+	if err := os.Rename(filepath.Join(tempDir, "moved"), filepath.Join(tempDir, "elsewhere")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := localFS.GetAttr(ctx, "/elsewhere"); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if _, err := localFS.FileHandleToPath(ctx, moved); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Handle of an inode seen under a new name resolves: %v", err)
+	}
+	current := handleOf("elsewhere")
+	if generation(current) != generation(moved)+1 {
+		t.Errorf("Generation %d after the inode moved, want %d", generation(current), generation(moved)+1)
+	}
+	if path, err := localFS.FileHandleToPath(ctx, current); err != nil || path != "/elsewhere" {
+		t.Errorf("New handle resolves to %q, %v", path, err)
+	}
+
+	// Another link to the same file is not a new file
+	linked := handleOf("linked")
+	if err := os.Link(filepath.Join(tempDir, "linked"), filepath.Join(tempDir, "alias")); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if alias := handleOf("alias"); generation(alias) != generation(linked) {
+		t.Errorf("Generation changed from %d to %d for a second link", generation(linked), generation(alias))
+	}
+	if _, err := localFS.FileHandleToPath(ctx, linked); err != nil {
+		t.Errorf("Handle of a linked file no longer resolves: %v", err)
+	}
+
+	// A file replaced behind the server's back is not mistaken for the
+	// new one
+	replaced := handleOf("replaced")
+	handleOf("replacement")
+	if err := os.Rename(filepath.Join(tempDir, "replacement"), filepath.Join(tempDir, "replaced")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := localFS.FileHandleToPath(ctx, replaced); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Handle of a replaced file resolves: %v", err)
+	}
+
+	// Removing a file moves its inode on, whatever reuses it next
+	removed := handleOf("removed")
+	fh, _ := fs.DeserializeFileHandle(removed)
+	if err := localFS.Remove(ctx, "/removed"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if gen := localFS.getGeneration(fh.Inode); gen != fh.Generation+1 {
+		t.Errorf("Generation %d after Remove, want %d", gen, fh.Generation+1)
+	}
+	localFS.updateInodeMap("/reused", fh.Inode)
+	if _, err := localFS.FileHandleToPath(ctx, removed); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Handle of a removed file resolves once its inode is indexed again: %v", err)
+	}
+}
+
+func TestGenerationsPersist(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	indexDir := t.TempDir()
+	localFS, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := localFS.LoadIndex(indexDir); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+
+	// One removal is saved, the other only journaled
+	var inodes []uint64
+	for _, name := range []string{"saved", "journaled"} {
+		if _, _, err := localFS.Create(ctx, "/", name, fs.FileAttr{}, true); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		inode, err := localFS.getInode("/" + name)
+		if err != nil {
+			t.Fatalf("getInode failed: %v", err)
+		}
+		inodes = append(inodes, inode)
+	}
+	if err := localFS.Remove(ctx, "/saved"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := localFS.SaveIndex(indexDir); err != nil {
+		t.Fatalf("SaveIndex failed: %v", err)
+	}
+	if err := localFS.Remove(ctx, "/journaled"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	restarted, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := restarted.LoadIndex(indexDir); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	for _, inode := range inodes {
+		if gen := restarted.getGeneration(inode); gen != initialGeneration+1 {
+			t.Errorf("Inode %d has generation %d after a restart, want %d", inode, gen, initialGeneration+1)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(indexDir, generationsFile)); err != nil {
+		t.Errorf("Generations not saved: %v", err)
+	}
+}
+
+func TestGenerationOnRecreate(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	localFS, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	file := filepath.Join(tempDir, "recreated")
+	if err := os.WriteFile(file, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	old, err := localFS.PathToFileHandle(ctx, "/recreated")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	fh, _ := fs.DeserializeFileHandle(old)
+
+	// Remove and recreate the file behind the server's back until the
+	// new file gets the old inode
+	reused := false
+	for i := 0; i < 100 && !reused; i++ {
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+		if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+			t.Fatalf("Failed to recreate file: %v", err)
+		}
+		inode, err := localFS.getInode("/recreated")
+		if err != nil {
+			t.Fatalf("getInode failed: %v", err)
+		}
+		reused = inode == fh.Inode
+	}
+	if !reused {
+		t.Skip("File system did not reuse the inode")
+	}
+	if _, _, ok := birthTime(file); !ok {
+		t.Skip("File system does not record creation times")
+	}
+
+	if _, err := localFS.FileHandleToPath(ctx, old); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Handle of the removed file resolves to the new one: %v", err)
+	}
+	current, err := localFS.PathToFileHandle(ctx, "/recreated")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if path, err := localFS.FileHandleToPath(ctx, current); err != nil || path != "/recreated" {
+		t.Errorf("Handle of the new file resolves to %q, %v", path, err)
+	}
+}
+
+func TestCheckBirthConcurrent(t *testing.T) {
+	localFS, err := NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	const inode = 12345
+	localFS.inodeMap.checkBirth(inode, 1)
+
+	// Every caller noticing the same new file bumps the generation once
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			localFS.inodeMap.checkBirth(inode, 2)
+		}()
+	}
+	wg.Wait()
+	if gen := localFS.getGeneration(inode); gen != initialGeneration+1 {
+		t.Errorf("Generation %d after one reuse, want %d", gen, initialGeneration+1)
+	}
+
+	// Unknown creation times change nothing
+	localFS.inodeMap.checkBirth(inode, 0)
+	if gen := localFS.getGeneration(inode); gen != initialGeneration+1 {
+		t.Errorf("Generation %d after a file with no creation time, want %d", gen, initialGeneration+1)
+	}
+}
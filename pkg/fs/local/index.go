@@ -0,0 +1,428 @@
+package local
+
+import (
+    "bufio"
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+const (
+    // indexShards is the number of independently locked parts of the
+    // inode index
+    indexShards = 64
+
+    // indexRangeBits sets the size of the inode ranges dealt out to the
+    // shards in turn: 1024 consecutive inodes share a shard, so a shard's
+    // file changes when files created together do, while concurrent
+    // lookups across the export spread over every shard
+    indexRangeBits = 10
+
+    // indexMagic starts every saved shard
+    indexMagic = "NFSIDX1\n"
+    
+    // indexCompleteFile marks an index directory whose index was built
+    // from the whole tree
+    indexCompleteFile = "complete"
+)
+
+// inodeIndex maps inode numbers to paths. It is split into shards by inode
+// range, each with its own lock, so resolving handles does not serialize
+// on one map, and each shard is saved on its own, so a save only rewrites
+// the shards that changed.
+type inodeIndex struct {
+    shards [indexShards]indexShard
+    
+    // Generation numbers of the inodes, saved and journaled with the paths
+    generations generationTable
+    
+    // Changes are appended here once the index was loaded from a
+    // directory, so they survive a crash before the next save
+    journal *indexJournal
+}
+
+// indexShard is one part of an inodeIndex
+type indexShard struct {
+    mu    sync.RWMutex
+    paths map[uint64]string
+
+    // Changed since last saved or loaded
+    dirty bool
+}
+
+// shard returns the shard holding inode
+func (x *inodeIndex) shard(inode uint64) *indexShard {
+    return &x.shards[(inode>>indexRangeBits)%indexShards]
+}
+
+// Load returns the path recorded for inode
+func (x *inodeIndex) Load(inode uint64) (string, bool) {
+    sh := x.shard(inode)
+    sh.mu.RLock()
+    path, ok := sh.paths[inode]
+    sh.mu.RUnlock()
+    return path, ok
+}
+
+// Store records path for inode. Recording the path already held takes only
+// the shard's read lock, as most calls do.
+func (x *inodeIndex) Store(inode uint64, path string) {
+    sh := x.shard(inode)
+    sh.mu.RLock()
+    current, ok := sh.paths[inode]
+    sh.mu.RUnlock()
+    if ok && current == path {
+        return
+    }
+
+    sh.mu.Lock()
+    if sh.paths == nil {
+        sh.paths = make(map[uint64]string)
+    }
+    sh.paths[inode] = path
+    sh.dirty = true
+    x.journal.store(inode, path)
+    sh.mu.Unlock()
+}
+
+// storeIfAbsent records path for inode unless the inode already has one,
+// as for another link to an indexed file
+func (x *inodeIndex) storeIfAbsent(inode uint64, path string) {
+    if _, ok := x.Load(inode); ok {
+        return
+    }
+    sh := x.shard(inode)
+    sh.mu.Lock()
+    if _, ok := sh.paths[inode]; !ok {
+        if sh.paths == nil {
+            sh.paths = make(map[uint64]string)
+        }
+        sh.paths[inode] = path
+        sh.dirty = true
+        x.journal.store(inode, path)
+    }
+    sh.mu.Unlock()
+}
+
+// Delete forgets inode
+func (x *inodeIndex) Delete(inode uint64) {
+    sh := x.shard(inode)
+    sh.mu.Lock()
+    if _, ok := sh.paths[inode]; ok {
+        delete(sh.paths, inode)
+        sh.dirty = true
+        x.journal.delete(inode)
+    }
+    sh.mu.Unlock()
+}
+
+// CompareAndDelete forgets inode if it is recorded at path
+func (x *inodeIndex) CompareAndDelete(inode uint64, path string) bool {
+    sh := x.shard(inode)
+    sh.mu.Lock()
+    defer sh.mu.Unlock()
+    if current, ok := sh.paths[inode]; !ok || current != path {
+        return false
+    }
+    delete(sh.paths, inode)
+    sh.dirty = true
+    x.journal.delete(inode)
+    return true
+}
+
+// renameTree moves every entry below the directory oldPath to the same
+// place below newPath, after the directory was renamed
+func (x *inodeIndex) renameTree(oldPath string, newPath string) {
+    if oldPath == "/" {
+        return
+    }
+    prefix := oldPath + "/"
+    for i := range x.shards {
+        sh := &x.shards[i]
+        sh.mu.Lock()
+        for inode, path := range sh.paths {
+            if rest, ok := strings.CutPrefix(path, prefix); ok {
+                moved := newPath + "/" + rest
+                sh.paths[inode] = moved
+                sh.dirty = true
+                x.journal.store(inode, moved)
+            }
+        }
+        sh.mu.Unlock()
+    }
+}
+
+// swapTrees moves the entries below a to b and those below b to a, after
+// the directories were exchanged
+func (x *inodeIndex) swapTrees(a string, b string) {
+    if a == "/" || b == "/" {
+        return
+    }
+    for i := range x.shards {
+        sh := &x.shards[i]
+        sh.mu.Lock()
+        for inode, path := range sh.paths {
+            moved := ""
+            if rest, ok := strings.CutPrefix(path, a+"/"); ok {
+                moved = b + "/" + rest
+            } else if rest, ok := strings.CutPrefix(path, b+"/"); ok {
+                moved = a + "/" + rest
+            } else {
+                continue
+            }
+            sh.paths[inode] = moved
+            sh.dirty = true
+            x.journal.store(inode, moved)
+        }
+        sh.mu.Unlock()
+    }
+}
+
+// Range calls fn for every entry until it returns false. Each shard is
+// copied first, so fn may modify the index.
+func (x *inodeIndex) Range(fn func(inode uint64, path string) bool) {
+    for i := range x.shards {
+        sh := &x.shards[i]
+        sh.mu.RLock()
+        entries := make(map[uint64]string, len(sh.paths))
+        for inode, path := range sh.paths {
+            entries[inode] = path
+        }
+        sh.mu.RUnlock()
+
+        for inode, path := range entries {
+            if !fn(inode, path) {
+                return
+            }
+        }
+    }
+}
+
+// Len returns the number of entries
+func (x *inodeIndex) Len() int {
+    n := 0
+    for i := range x.shards {
+        sh := &x.shards[i]
+        sh.mu.RLock()
+        n += len(sh.paths)
+        sh.mu.RUnlock()
+    }
+    return n
+}
+
+// LoadIndex merges the inode index saved in dir by SaveIndex into the
+// current one, replays the changes journaled since that save and journals
+// every later change to dir, so handles issued before a restart or crash
+// resolve without walking the tree and handles of files removed before it
+// stay stale. An index is built from the tree the
+// first time dir is used. Entries are trusted as saved: after the export
+// was changed while the server was down, run Check with repair.
+func (l *LocalFileSystem) LoadIndex(dir string) error {
+    if err := l.inodeMap.load(dir); err != nil {
+        return fs.NewError("LoadIndex", dir, err)
+    }
+    replayed, err := l.inodeMap.replayJournal(dir)
+    if err != nil {
+        return fs.NewError("LoadIndex", dir, err)
+    }
+    slog.Info("Loaded inode index", "entries", l.inodeMap.Len(), "dir", dir, "journaled", replayed)
+    
+    // Unnamed files were discarded on startup
+    l.inodeMap.Range(func(inode uint64, path string) bool {
+        if isStagingPath(path) {
+            l.inodeMap.Delete(inode)
+            l.inodeMap.bumpGeneration(inode)
+        }
+        return true
+    })
+    
+    if _, err := os.Stat(filepath.Join(dir, indexCompleteFile)); err == nil {
+        l.indexed.Store(true)
+    } else if err := l.buildIndex(context.Background()); err != nil {
+        return fs.NewError("LoadIndex", dir, err)
+    }
+    
+    journal, err := openJournal(dir)
+    if err != nil {
+        return fs.NewError("LoadIndex", dir, err)
+    }
+    l.inodeMap.journal = journal
+    return l.SaveIndex(dir)
+}
+
+// SaveIndex writes the shards of the inode index that changed since they
+// were last saved or loaded to dir, and drops the journaled changes the
+// save covers
+func (l *LocalFileSystem) SaveIndex(dir string) error {
+    journal := l.inodeMap.journal
+    if journal != nil && journal.dir != dir {
+        journal = nil
+    }
+    if err := journal.rotate(); err != nil {
+        return fs.NewError("SaveIndex", dir, err)
+    }
+    if _, err := l.inodeMap.save(dir); err != nil {
+        return fs.NewError("SaveIndex", dir, err)
+    }
+    if err := journal.dropRotated(); err != nil {
+        return fs.NewError("SaveIndex", dir, err)
+    }
+    
+    // Mark an index holding the whole tree, so it is not built again
+    if l.indexed.Load() {
+        marker := filepath.Join(dir, indexCompleteFile)
+        if _, err := os.Stat(marker); errors.Is(err, os.ErrNotExist) {
+            if err := writeShard(marker, nil); err != nil {
+                return fs.NewError("SaveIndex", dir, err)
+            }
+        }
+    }
+    return nil
+}
+
+// shardFile is the file shard i is saved in under dir
+func shardFile(dir string, i int) string {
+    return filepath.Join(dir, fmt.Sprintf("inodes-%02d.idx", i))
+}
+
+// save writes every shard changed since it was last saved or loaded to
+// its file in dir, and the generation table if it changed, and returns how
+// many shards it wrote
+func (x *inodeIndex) save(dir string) (int, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return 0, err
+    }
+    written := 0
+    for i := range x.shards {
+        sh := &x.shards[i]
+        sh.mu.Lock()
+        if !sh.dirty {
+            sh.mu.Unlock()
+            continue
+        }
+        data := encodeShard(sh.paths)
+        sh.dirty = false
+        sh.mu.Unlock()
+
+        if err := writeShard(shardFile(dir, i), data); err != nil {
+            // Try again on the next save
+            sh.mu.Lock()
+            sh.dirty = true
+            sh.mu.Unlock()
+            return written, err
+        }
+        written++
+    }
+    return written, x.saveGenerations(dir)
+}
+
+// load merges the shards and generations saved in dir into the index. A
+// missing directory or shard file is an empty shard.
+func (x *inodeIndex) load(dir string) error {
+    for i := range x.shards {
+        f, err := os.Open(shardFile(dir, i))
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        err = x.loadShard(bufio.NewReader(f), i)
+        f.Close()
+        if err != nil {
+            return fmt.Errorf("%s: %w", shardFile(dir, i), err)
+        }
+    }
+    return x.loadGenerations(dir)
+}
+
+// loadShard reads one saved shard from r. The shard stays clean unless the
+// file held entries that belong elsewhere, as after a change of layout.
+func (x *inodeIndex) loadShard(r *bufio.Reader, i int) error {
+    magic := make([]byte, len(indexMagic))
+    if _, err := io.ReadFull(r, magic); err != nil || string(magic) != indexMagic {
+        return errors.New("not an inode index shard")
+    }
+    own := &x.shards[i]
+    for {
+        inode, err := binary.ReadUvarint(r)
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        length, err := binary.ReadUvarint(r)
+        if err != nil || length > maxIndexedPath {
+            return errors.New("truncated or corrupt entry")
+        }
+        path := make([]byte, length)
+        if _, err := io.ReadFull(r, path); err != nil {
+            return errors.New("truncated or corrupt entry")
+        }
+
+        sh := x.shard(inode)
+        sh.mu.Lock()
+        if sh.paths == nil {
+            sh.paths = make(map[uint64]string)
+        }
+        sh.paths[inode] = string(path)
+        if sh != own {
+            sh.dirty = true
+        }
+        sh.mu.Unlock()
+
+        // Rewrite the file without the entry that moved
+        if sh != own {
+            own.mu.Lock()
+            own.dirty = true
+            own.mu.Unlock()
+        }
+    }
+}
+
+// maxIndexedPath bounds the path length accepted from a saved shard
+const maxIndexedPath = 1 << 16
+
+// encodeShard serializes the entries of a shard
+func encodeShard(paths map[uint64]string) []byte {
+    data := []byte(indexMagic)
+    for inode, path := range paths {
+        data = binary.AppendUvarint(data, inode)
+        data = binary.AppendUvarint(data, uint64(len(path)))
+        data = append(data, path...)
+    }
+    return data
+}
+
+// writeShard replaces file with data through a temporary file, so a crash
+// leaves either the old shard or the new one
+func writeShard(file string, data []byte) error {
+    tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), file)
+}
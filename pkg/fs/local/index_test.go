@@ -0,0 +1,328 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestInodeIndexShards(t *testing.T) {
+	var index inodeIndex
+
+	// Consecutive inodes share a shard, the next range moves on
+	if index.shard(5) != index.shard(1<<indexRangeBits-1) || index.shard(5) == index.shard(1<<indexRangeBits) {
+		t.Errorf("Inodes are not sharded by range")
+	}
+
+	index.Store(1, "/a")
+	index.Store(2, "/b")
+	if path, ok := index.Load(1); !ok || path != "/a" {
+		t.Errorf("Load(1) = %q, %v; want /a", path, ok)
+	}
+	if index.CompareAndDelete(2, "/other") {
+		t.Errorf("CompareAndDelete removed an entry with a different path")
+	}
+	if !index.CompareAndDelete(2, "/b") {
+		t.Errorf("CompareAndDelete kept a matching entry")
+	}
+	index.Delete(1)
+	if n := index.Len(); n != 0 {
+		t.Errorf("Len = %d after removing everything, want 0", n)
+	}
+
+	// Concurrent stores in every shard are all kept
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				inode := uint64(w*10000 + i)
+				index.Store(inode, fmt.Sprintf("/f%d", inode))
+				index.Load(inode)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n := index.Len(); n != 80000 {
+		t.Errorf("Len = %d after concurrent stores, want 80000", n)
+	}
+}
+
+func TestInodeIndexSaveLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "index")
+	var index inodeIndex
+	for inode := uint64(0); inode < 5000; inode++ {
+		index.Store(inode, fmt.Sprintf("/dir/file %d\n", inode))
+	}
+
+	written, err := index.save(dir)
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if written != 5 {
+		t.Errorf("First save wrote %d shards, want the 5 in use", written)
+	}
+
+	// Only the shard that changed is written again, including a removal
+	index.Store(7, "/renamed")
+	index.Store(8, "/dir/file 8\n") // Unchanged
+	index.Delete(1<<indexRangeBits + 1)
+	if written, err := index.save(dir); err != nil || written != 2 {
+		t.Errorf("Second save wrote %d shards, %v; want 2", written, err)
+	}
+	if written, err := index.save(dir); err != nil || written != 0 {
+		t.Errorf("Save without changes wrote %d shards, %v; want none", written, err)
+	}
+
+	var loaded inodeIndex
+	if err := loaded.load(dir); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if n := loaded.Len(); n != 4999 {
+		t.Errorf("Loaded %d entries, want 4999", n)
+	}
+	if path, ok := loaded.Load(7); !ok || path != "/renamed" {
+		t.Errorf("Loaded inode 7 at %q, %v; want /renamed", path, ok)
+	}
+	if path, ok := loaded.Load(4999); !ok || path != "/dir/file 4999\n" {
+		t.Errorf("Loaded inode 4999 at %q, %v", path, ok)
+	}
+	if written, err := loaded.save(dir); err != nil || written != 0 {
+		t.Errorf("Save after load wrote %d shards, %v; want none", written, err)
+	}
+
+	// Missing directories load as empty, damaged shards fail
+	var empty inodeIndex
+	if err := empty.load(filepath.Join(dir, "missing")); err != nil || empty.Len() != 0 {
+		t.Errorf("load of a missing directory returned %v with %d entries", err, empty.Len())
+	}
+	if err := os.WriteFile(shardFile(dir, 3), []byte("garbage"), 0600); err != nil {
+		t.Fatalf("Failed to damage shard: %v", err)
+	}
+	if err := empty.load(dir); err == nil {
+		t.Errorf("load of a damaged shard succeeded")
+	}
+}
+
+func TestLoadIndexResolvesWithoutWalk(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	indexDir := t.TempDir()
+
+	fs, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	handle, err := fs.PathToFileHandle(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := fs.SaveIndex(indexDir); err != nil {
+		t.Fatalf("SaveIndex failed: %v", err)
+	}
+
+	// A restarted server resolves the handle from the saved index, even
+	// with a done context that would stop a walk
+	restarted, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := restarted.LoadIndex(indexDir); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if path, err := restarted.FileHandleToPath(ctx, handle); err != nil || path != "/file" {
+		t.Errorf("FileHandleToPath returned %q, %v; want /file", path, err)
+	}
+}
+
+func TestIndexFollowsChanges(t *testing.T) {
+	ctx := context.Background()
+	localFS, err := NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := localFS.buildIndex(ctx); err != nil {
+		t.Fatalf("buildIndex failed: %v", err)
+	}
+
+	mustCreate := func(dir, name string) []byte {
+		t.Helper()
+		path, _, err := localFS.Create(ctx, dir, name, fs.FileAttr{}, true)
+		if err != nil {
+			t.Fatalf("Create %s failed: %v", name, err)
+		}
+		handle, err := localFS.PathToFileHandle(ctx, path)
+		if err != nil {
+			t.Fatalf("PathToFileHandle failed: %v", err)
+		}
+		return handle
+	}
+	resolve := func(handle []byte) (string, error) {
+		return localFS.FileHandleToPath(ctx, handle)
+	}
+
+	if _, _, err := localFS.Mkdir(ctx, "/", "a", fs.FileAttr{}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := localFS.Mkdir(ctx, "/a", "b", fs.FileAttr{}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	nested := mustCreate("/a/b", "file")
+	target := mustCreate("/", "target")
+	source := mustCreate("/", "source")
+	linked := mustCreate("/", "linked")
+	if _, _, err := localFS.Link(ctx, "/linked", "/", "alias"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+
+	// Files inside a renamed directory follow it
+	if err := localFS.Rename(ctx, "/a", "/c", 0); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if path, err := resolve(nested); err != nil || path != "/c/b/file" {
+		t.Errorf("Nested file resolves to %q, %v after renaming its directory", path, err)
+	}
+
+	// A file replaced by a rename is gone
+	if err := localFS.Rename(ctx, "/source", "/target", 0); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if path, err := resolve(source); err != nil || path != "/target" {
+		t.Errorf("Renamed file resolves to %q, %v; want /target", path, err)
+	}
+	if _, err := resolve(target); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Replaced file resolves: %v", err)
+	}
+
+	// Removing the indexed name of a linked file finds the other one
+	if err := localFS.Remove(ctx, "/linked"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if path, err := resolve(linked); err != nil || path != "/alias" {
+		t.Errorf("Linked file resolves to %q, %v; want /alias", path, err)
+	}
+
+	if err := localFS.Remove(ctx, "/c/b/file"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := resolve(nested); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Removed file resolves: %v", err)
+	}
+	dir, err := localFS.PathToFileHandle(ctx, "/c/b")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := localFS.Rmdir(ctx, "/c/b"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if _, err := resolve(dir); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("Removed directory resolves: %v", err)
+	}
+}
+
+func TestIndexJournal(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	indexDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "old"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// The first load indexes the tree and marks the index complete
+	localFS, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := localFS.LoadIndex(indexDir); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(indexDir, indexCompleteFile)); err != nil {
+		t.Errorf("Index not marked complete: %v", err)
+	}
+	old, err := localFS.PathToFileHandle(ctx, "/old")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+
+	// Changes after the last save are only in the journal
+	if _, _, err := localFS.Create(ctx, "/", "new", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created, err := localFS.PathToFileHandle(ctx, "/new")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := localFS.Rename(ctx, "/old", "/renamed", 0); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	// A crash in the middle of a record leaves part of it
+	journal, err := os.OpenFile(filepath.Join(indexDir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	journal.Write([]byte{journalStore, 0x80})
+	journal.Close()
+
+	// The crashed server's changes resolve without walking the tree
+	restarted, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	if err := restarted.LoadIndex(indexDir); err != nil {
+		t.Fatalf("LoadIndex failed: %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	for handle, want := range map[*[]byte]string{&old: "/renamed", &created: "/new"} {
+		if path, err := restarted.FileHandleToPath(cancelled, *handle); err != nil || path != want {
+			t.Errorf("FileHandleToPath returned %q, %v; want %s", path, err, want)
+		}
+	}
+
+	// The load saved the replayed changes, leaving an empty journal
+	if info, err := os.Stat(filepath.Join(indexDir, journalFile)); err != nil || info.Size() != int64(len(journalMagic)) {
+		t.Errorf("Journal after load: %v, %v", info.Size(), err)
+	}
+	if _, err := os.Stat(filepath.Join(indexDir, journalFile+".old")); !os.IsNotExist(err) {
+		t.Errorf("Rotated journal left behind: %v", err)
+	}
+}
+
+// benchmarkIndexLookup resolves inodes from an index of entries entries on
+// every CPU, storing one in every storeEvery as handles for new files do
+func benchmarkIndexLookup(b *testing.B, entries int, storeEvery int) {
+	var index inodeIndex
+	for inode := 0; inode < entries; inode++ {
+		index.Store(uint64(inode), "/export/some/directory/file")
+	}
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		inode := uint64(0)
+		for i := 0; pb.Next(); i++ {
+			// Step through the index in a scattered order
+			inode = (inode + 7919) % uint64(entries)
+			if storeEvery > 0 && i%storeEvery == 0 {
+				index.Store(inode, "/export/some/directory/moved")
+			} else if _, ok := index.Load(inode); !ok {
+				b.Fatalf("inode %d missing", inode)
+			}
+		}
+	})
+}
+
+func BenchmarkIndexLookup1M(b *testing.B)           { benchmarkIndexLookup(b, 1_000_000, 0) }
+func BenchmarkIndexLookup4M(b *testing.B)           { benchmarkIndexLookup(b, 4_000_000, 0) }
+func BenchmarkIndexLookupWithStores4M(b *testing.B) { benchmarkIndexLookup(b, 4_000_000, 100) }
@@ -0,0 +1,225 @@
+package local
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+const (
+    // journalFile holds the index changes made since the last save, and
+    // journalFile+".old" those of a save still in progress
+    journalFile = "inodes.journal"
+    
+    // journalMagic starts every journal
+    journalMagic = "NFSJNL1\n"
+    
+    // Journal record types
+    journalStore      = 'S'
+    journalDelete     = 'D'
+    journalGeneration = 'G'
+)
+
+// indexJournal appends the changes to an inode index to a file, so a
+// crash between two saves loses none of them. Records are written while
+// the shard of their inode is locked, so the changes to one inode are
+// journaled in the order they were made.
+type indexJournal struct {
+    dir string
+    
+    mu sync.Mutex
+    f  *os.File
+    
+    // The last write error, logged once
+    err error
+}
+
+// openJournal opens the journal in dir for appending
+func openJournal(dir string) (*indexJournal, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    f, err := createJournal(filepath.Join(dir, journalFile))
+    if err != nil {
+        return nil, err
+    }
+    return &indexJournal{dir: dir, f: f}, nil
+}
+
+// createJournal opens file for appending, starting it if it is empty
+func createJournal(file string) (*os.File, error) {
+    f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil {
+        return nil, err
+    }
+    info, err := f.Stat()
+    if err == nil && info.Size() == 0 {
+        _, err = f.WriteString(journalMagic)
+    }
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+// store journals that inode is at path. A nil journal does nothing.
+func (j *indexJournal) store(inode uint64, path string) {
+    if j == nil {
+        return
+    }
+    record := binary.AppendUvarint([]byte{journalStore}, inode)
+    record = binary.AppendUvarint(record, uint64(len(path)))
+    j.write(append(record, path...))
+}
+
+// delete journals that inode was forgotten. A nil journal does nothing.
+func (j *indexJournal) delete(inode uint64) {
+    if j == nil {
+        return
+    }
+    j.write(binary.AppendUvarint([]byte{journalDelete}, inode))
+}
+
+// generation journals that inode moved to generation gen. A nil journal
+// does nothing.
+func (j *indexJournal) generation(inode uint64, gen uint32) {
+    if j == nil {
+        return
+    }
+    record := binary.AppendUvarint([]byte{journalGeneration}, inode)
+    j.write(binary.AppendUvarint(record, uint64(gen)))
+}
+
+// write appends one record with a single write, so a crash leaves at most
+// the last record incomplete
+func (j *indexJournal) write(record []byte) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if _, err := j.f.Write(record); err != nil && j.err == nil {
+        // The next save still writes the change
+        slog.Error("Failed to journal index change", "err", err)
+        j.err = err
+    }
+}
+
+// rotate moves the journal aside before a save, so changes made during the
+// save go to a new one. If an earlier save failed, the journal it moved
+// aside is still there and the current one is kept as well.
+func (j *indexJournal) rotate() error {
+    if j == nil {
+        return nil
+    }
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    current := filepath.Join(j.dir, journalFile)
+    rotated := current + ".old"
+    if _, err := os.Stat(rotated); err == nil {
+        return nil
+    }
+    if err := os.Rename(current, rotated); err != nil {
+        return err
+    }
+    f, err := createJournal(current)
+    if err != nil {
+        return err
+    }
+    j.f.Close()
+    j.f = f
+    j.err = nil
+    return nil
+}
+
+// dropRotated removes the journal moved aside by rotate once the save it
+// made way for succeeded
+func (j *indexJournal) dropRotated() error {
+    if j == nil {
+        return nil
+    }
+    err := os.Remove(filepath.Join(j.dir, journalFile+".old"))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    return err
+}
+
+// replayJournal applies the journals in dir, the one moved aside by an
+// unfinished save first, and returns the number of changes applied. An
+// incomplete last record, left by a crash while it was written, is cut off.
+func (x *inodeIndex) replayJournal(dir string) (int, error) {
+    replayed := 0
+    for _, name := range []string{journalFile + ".old", journalFile} {
+        file := filepath.Join(dir, name)
+        data, err := os.ReadFile(file)
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            return replayed, err
+        }
+        if len(data) == 0 {
+            continue
+        }
+        if len(data) < len(journalMagic) || string(data[:len(journalMagic)]) != journalMagic {
+            return replayed, fmt.Errorf("%s: not an inode index journal", file)
+        }
+        
+        n, valid := x.replayRecords(data[len(journalMagic):])
+        replayed += n
+        if end := int64(len(journalMagic) + valid); end < int64(len(data)) {
+            slog.Warn("Discarding incomplete record at the end of the index journal", "file", file)
+            if err := os.Truncate(file, end); err != nil {
+                return replayed, err
+            }
+        }
+    }
+    return replayed, nil
+}
+
+// replayRecords applies the complete records in data and returns how many
+// there were and how many bytes they took
+func (x *inodeIndex) replayRecords(data []byte) (int, int) {
+    applied, offset := 0, 0
+    for offset < len(data) {
+        kind := data[offset]
+        pos := offset + 1
+        inode, n := binary.Uvarint(data[pos:])
+        if n <= 0 {
+            break
+        }
+        pos += n
+        
+        switch kind {
+        case journalStore:
+            length, n := binary.Uvarint(data[pos:])
+            if n <= 0 || length > maxIndexedPath || uint64(len(data)-pos-n) < length {
+                return applied, offset
+            }
+            pos += n
+            x.Store(inode, string(data[pos:pos+int(length)]))
+            pos += int(length)
+        case journalDelete:
+            x.Delete(inode)
+        case journalGeneration:
+            gen, n := binary.Uvarint(data[pos:])
+            if n <= 0 || gen > 1<<32-1 {
+                return applied, offset
+            }
+            pos += n
+            x.generations.mu.Lock()
+            if current, ok := x.generations.gens[inode]; !ok || current < uint32(gen) {
+                x.generations.set(inode, uint32(gen))
+            }
+            x.generations.mu.Unlock()
+        default:
+            return applied, offset
+        }
+        applied++
+        offset = pos
+    }
+    return applied, offset
+}
@@ -3,15 +3,20 @@ package local
 
 import (
     "context"
+    "errors"
     "fmt"
     "os"
     "path/filepath"
+    "sort"
     "strings"
     "sync"
+    "sync/atomic"
     "syscall"
     "io"
-    "log"
+    "log/slog"
+    "time"
 
+    "github.com/example/nfsserver/pkg/api"
     "github.com/example/nfsserver/pkg/fs"
 )
 
@@ -24,11 +29,36 @@ type LocalFileSystem struct {
     // fsID is a unique identifier for this filesystem instance
     fsID uint32
     
-    // inodeMap maintains a mapping from inode numbers to paths
-    inodeMap sync.Map // map[uint64]string
-    
-    // generationMap tracks the generation number for each inode
-    generationMap sync.Map // map[uint64]uint32
+    // inodeMap maintains a mapping from inode numbers to paths. Once
+    // indexed is set it holds every file in the tree, kept current by the
+    // operations that add, move and remove names; buildMu serializes
+    // building it.
+    inodeMap inodeIndex
+    indexed  atomic.Bool
+    buildMu  sync.Mutex
+    
+    // namespaceMu is held shared by operations that move or remove names
+    // until the index reflects the change, and exclusively while an inode
+    // found under an unexpected name is checked for reuse
+    namespaceMu sync.RWMutex
+    
+    // changeIDs holds change counters for files that cannot store them in
+    // an extended attribute; changeMu serializes counter updates.
+    // changeEpoch is the floor of those counters in this run.
+    changeIDs       sync.Map // map[uint64]uint64
+    changeMu        sync.Mutex
+    changeEpoch     uint64
+    changeEpochOnce sync.Once
+    
+    // snapshotMu is held shared by operations that change files in place,
+    // and exclusively while a snapshot is taken or deleted. snapshotLinks
+    // holds the paths in snapshots of the live files they share, by inode,
+    // once found; snapshotLinkMu guards it. shared is clear while no live
+    // file can be shared with a snapshot.
+    snapshotMu     sync.RWMutex
+    snapshotLinkMu sync.Mutex
+    snapshotLinks  map[uint64][]string
+    shared         atomic.Bool
 }
 
 // NewLocalFileSystem creates a new local filesystem implementation.
@@ -52,10 +82,21 @@ func NewLocalFileSystem(rootPath string) (*LocalFileSystem, error) {
     // Generate a filesystem ID based on the root path
     fsID := generateFsID(absPath)
     
-    return &LocalFileSystem{
+    l := &LocalFileSystem{
         rootPath: absPath,
         fsID:     fsID,
-    }, nil
+    }
+    
+    // Unnamed files that were never linked into place are abandoned
+    if err := l.clearStaging(); err != nil {
+        return nil, fs.NewError("init", rootPath, mapOSError(err))
+    }
+    l.loadChangeEpoch()
+    if _, err := os.Stat(l.snapshotsPath()); err == nil {
+        l.shared.Store(true)
+    }
+    
+    return l, nil
 }
 
 // generateFsID creates a filesystem ID from a path
@@ -67,26 +108,6 @@ func generateFsID(path string) uint32 {
     return h
 }
 
-// resolvePath converts a path relative to the filesystem to an absolute OS path
-// with security checks to prevent directory traversal
-func (l *LocalFileSystem) resolvePath(path string) (string, error) {
-    // Remove leading slash if present for consistency
-    path = strings.TrimPrefix(path, "/")
-    
-    // Clean the path to remove any '..' components
-    cleanPath := filepath.Clean(path)
-    
-    // Join with the root path
-    fullPath := filepath.Join(l.rootPath, cleanPath)
-    
-    // Verify the path is still under the root path (prevent directory traversal)
-    if !strings.HasPrefix(fullPath, l.rootPath) {
-        return "", fs.ErrInvalidName
-    }
-    
-    return fullPath, nil
-}
-
 // getInode retrieves the inode number for a file
 func (l *LocalFileSystem) getInode(path string) (uint64, error) {
     fullPath, err := l.resolvePath(path)
@@ -94,74 +115,36 @@ func (l *LocalFileSystem) getInode(path string) (uint64, error) {
         return 0, err
     }
     
-    info, err := os.Stat(fullPath)
+    info, err := l.lstat(fullPath)
     if err != nil {
         return 0, mapOSError(err)
     }
     
-    stat, ok := info.Sys().(*syscall.Stat_t)
+    stat, ok := statOf(fullPath, info)
     if !ok {
-        return 0, fmt.Errorf("unable to get system information for file")
+        return 0, fs.NewStatusError("getInode", path, api.Status_ERR_SERVERFAULT, errors.New("unable to get system information for file"))
     }
     
     return stat.Ino, nil
 }
 
-// getGeneration gets or creates a generation number for an inode
+// getGeneration returns the current generation number of an inode
 func (l *LocalFileSystem) getGeneration(inode uint64) uint32 {
-    if gen, ok := l.generationMap.Load(inode); ok {
-        return gen.(uint32)
-    }
-    
-    // For simplicity, we start with generation 1
-    l.generationMap.Store(inode, uint32(1))
-    return 1
+    return l.inodeMap.Generation(inode)
 }
 
-// updateInodeMap adds or updates the inode to path mapping
+// updateInodeMap adds or updates the inode to path mapping. Files in
+// snapshots have handles of their own and are not indexed.
 func (l *LocalFileSystem) updateInodeMap(path string, inode uint64) {
+    if isSnapshotPath(path) {
+        return
+    }
     l.inodeMap.Store(inode, path)
 }
 
 // lookupPathByInode finds a path by inode number
 func (l *LocalFileSystem) lookupPathByInode(inode uint64) (string, bool) {
-    log.Printf("lookupPathByInode: 查找 inode=%d 的路径", inode)
-    
-    // 输出当前 inodeMap 的内容以便调试
-    log.Printf("当前 inodeMap 内容:")
-    count := 0
-    l.inodeMap.Range(func(key, value interface{}) bool {
-        count++
-        if count <= 10 { // 限制输出数量，避免日志过大
-            log.Printf("  - inode=%d -> path=%s", key, value)
-        }
-        return true
-    })
-    log.Printf("inodeMap 共有 %d 条记录", count)
-    
-    if path, ok := l.inodeMap.Load(inode); ok {
-        pathStr := path.(string)
-        log.Printf("lookupPathByInode: 找到路径: %s", pathStr)
-        return pathStr, true
-    }
-    
-    log.Printf("lookupPathByInode: 找不到 inode=%d 的路径", inode)
-    return "", false
-}
-
-// openFile safely opens a file with proper error mapping
-func (l *LocalFileSystem) openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
-    fullPath, err := l.resolvePath(path)
-    if err != nil {
-        return nil, fs.NewError("open", path, err)
-    }
-    
-    file, err := os.OpenFile(fullPath, flag, perm)
-    if err != nil {
-        return nil, fs.NewError("open", path, mapOSError(err))
-    }
-    
-    return file, nil
+    return l.inodeMap.Load(inode)
 }
 
 // getFileInfo gets the os.FileInfo for a path
@@ -171,7 +154,7 @@ func (l *LocalFileSystem) getFileInfo(path string) (os.FileInfo, error) {
         return nil, err
     }
     
-    info, err := os.Stat(fullPath)
+    info, err := l.lstat(fullPath)
     if err != nil {
         return nil, mapOSError(err)
     }
@@ -179,16 +162,36 @@ func (l *LocalFileSystem) getFileInfo(path string) (os.FileInfo, error) {
     return info, nil
 }
 
+// lstat describes fullPath without following a final symbolic link, so
+// links get handles and attributes of their own. The export root may
+// itself be reached through a link and is always followed. The file's
+// platform status is attached, so statOf still works once it is gone.
+func (l *LocalFileSystem) lstat(fullPath string) (os.FileInfo, error) {
+    stat := os.Lstat
+    if fullPath == l.rootPath {
+        stat = os.Stat
+    }
+    info, err := stat(fullPath)
+    if err != nil {
+        return nil, err
+    }
+    return withFileStat(fullPath, info), nil
+}
+
 // convertFileInfo converts os.FileInfo to fs.FileInfo
 func (l *LocalFileSystem) convertFileInfo(path string, osInfo os.FileInfo) (fs.FileInfo, error) {
     if osInfo == nil {
-        return fs.FileInfo{}, fmt.Errorf("nil FileInfo")
+        return fs.FileInfo{}, fs.NewStatusError("convertFileInfo", path, api.Status_ERR_SERVERFAULT, errors.New("nil FileInfo"))
     }
     
     // Get system-specific info
-    stat, ok := osInfo.Sys().(*syscall.Stat_t)
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return fs.FileInfo{}, fs.NewError("convertFileInfo", path, err)
+    }
+    stat, ok := statOf(fullPath, osInfo)
     if !ok {
-        return fs.FileInfo{}, fmt.Errorf("unable to get system information")
+        return fs.FileInfo{}, fs.NewStatusError("convertFileInfo", path, api.Status_ERR_SERVERFAULT, errors.New("unable to get system information"))
     }
     
     // Determine file type
@@ -225,33 +228,42 @@ func (l *LocalFileSystem) convertFileInfo(path string, osInfo os.FileInfo) (fs.F
         fsMode |= fs.ModeSticky
     }
     
-    // Use ModTime for all time fields for simplicity and cross-platform compatibility
     modTime := osInfo.ModTime()
     
     // Create FileInfo
     fsInfo := fs.FileInfo{
         Type:       fileType,
+        FileId:     stat.Ino,
         Mode:       fsMode,
         Size:       osInfo.Size(),
         Uid:        stat.Uid,
         Gid:        stat.Gid,
-        Nlink:      uint32(stat.Nlink),
-        Rdev:       uint64(stat.Rdev),
+        Nlink:      stat.Nlink,
+        Rdev:       stat.Rdev,
         BlockSize:  uint32(512), // Default block size
         Blocks:     uint64((osInfo.Size() + 511) / 512), // Approximate blocks from size
         ModifyTime: modTime,
-        AccessTime: modTime, // Use ModTime as a fallback
-        ChangeTime: modTime, // Use ModTime as a fallback
+        AccessTime: stat.Atime,
+        ChangeTime: stat.Ctime,
+        ChangeID:   l.changeID(fullPath, stat),
+    }
+    
+    // Links cannot have ACLs, and reading one would follow the link
+    if !stat.Symlink {
+        fsInfo.ACL, _ = readACL(fullPath)
     }
     
     // Update the inode map
-    l.updateInodeMap(path, stat.Ino)
+    l.observed(path, stat.Ino)
     
     return fsInfo, nil
 }
 
 // mapOSError maps os errors to fs errors
 func mapOSError(err error) error {
+    if mapped := platformError(err); mapped != nil {
+        return mapped
+    }
     if os.IsNotExist(err) {
         return fs.ErrNotExist
     } else if os.IsPermission(err) {
@@ -261,15 +273,26 @@ func mapOSError(err error) error {
     }
     
     // Handle more specific errors
+    var errno error
     if pathErr, ok := err.(*os.PathError); ok {
-        switch pathErr.Err {
-        case syscall.ENOTEMPTY:
-            return fs.ErrNotEmpty
-        case syscall.EINVAL:
-            return fs.ErrInvalidName
-        case syscall.ENOSPC:
-            return fs.ErrNoSpace
-        }
+        errno = pathErr.Err
+    } else if linkErr, ok := err.(*os.LinkError); ok {
+        errno = linkErr.Err
+    }
+    switch errno {
+    case syscall.ENOTEMPTY:
+        return fs.ErrNotEmpty
+    case syscall.EINVAL:
+        return fs.ErrInvalidName
+    case syscall.ENOSPC:
+        return fs.ErrNoSpace
+    case syscall.ENOSYS:
+        return fs.ErrNotSupported
+    }
+    
+    // Other errors keep their errno where it tells their status
+    if fs.Classify(err) != api.Status_OK {
+        return err
     }
     
     // Default to IO error
@@ -293,104 +316,197 @@ func (l *LocalFileSystem) GetAttr(ctx context.Context, path string) (fs.FileInfo
     return info, nil
 }
 
-func (l *LocalFileSystem) FileHandleToPath(fh []byte) (string, error) {
-    log.Printf("FileHandleToPath received handle: %x (length: %d)", fh, len(fh))
-    
+func (l *LocalFileSystem) FileHandleToPath(ctx context.Context, fh []byte) (string, error) {
     handle, err := fs.DeserializeFileHandle(fh)
     if err != nil {
-        log.Printf("DeserializeFileHandle error: %v", err)
+        slog.DebugContext(ctx, "Malformed file handle", "handle", fmt.Sprintf("%x", fh), "err", err)
         return "", fs.NewError("FileHandleToPath", "", fs.ErrInvalidHandle)
     }
     
-    log.Printf("Deserialized handle: FS=%d, Inode=%d, Gen=%d", 
-        handle.FileSystemID, handle.Inode, handle.Generation)
-    
     // Verify filesystem ID
     if handle.FileSystemID != l.fsID {
-        return "", fs.NewError("FileHandleToPath", "", fs.ErrStale)
+        return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+    }
+    
+    // Handles of files in snapshots carry their paths
+    if handle.Generation == snapshotGeneration && len(fh) > 16 {
+        return l.snapshotFile(handle, string(fh[16:]))
     }
     
     // First try to find in the mapping table
     if path, ok := l.lookupPathByInode(handle.Inode); ok {
-        return path, nil
+        return l.checkHandle(handle, path)
     }
     
-    // If not in the mapping table, try dynamic lookup
-    log.Printf("No record in mapping table, attempting dynamic lookup for inode=%d", handle.Inode)
-    path, err := l.findPathByInode(handle.Inode)
-    if err != nil {
-        log.Printf("Dynamic lookup failed: %v", err)
-        return "", fs.NewError("FileHandleToPath", "", fs.ErrStale)
+    // Every file is in a complete index, so a miss means the file is gone
+    if !l.indexed.Load() {
+        if err := l.buildIndex(ctx); err != nil {
+            slog.WarnContext(ctx, "Building the inode index failed", "err", err)
+            if ctxErr := ctx.Err(); ctxErr != nil {
+                return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, ctxErr)
+            }
+            return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+        }
+        if path, ok := l.lookupPathByInode(handle.Inode); ok {
+            return l.checkHandle(handle, path)
+        }
     }
     
-    // After finding the path, update the mapping table
-    log.Printf("Dynamic lookup successful: inode=%d -> path=%s", handle.Inode, path)
-    l.updateInodeMap(path, handle.Inode)
+    return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+}
+
+// checkHandle returns path, where the index has the file of handle, if the
+// handle is of the file's current generation and the file is still there.
+// A file gone from its indexed path was removed or moved behind the
+// server's back, so the inode gets a new generation and the handle is
+// stale. So does an inode found holding a file created after the one it was
+// last seen holding, even at the same path.
+func (l *LocalFileSystem) checkHandle(handle *fs.FileHandle, path string) (string, error) {
+    if handle.Generation != l.getGeneration(handle.Inode) {
+        return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+    }
+    if l.holds(path, handle.Inode) {
+        // The inode may hold a new file by the same name
+        l.checkBirth(path, handle.Inode)
+        if handle.Generation != l.getGeneration(handle.Inode) {
+            return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+        }
+        return path, nil
+    }
     
-    return path, nil
+    // Operations that move or remove names update the index right after
+    // the change; wait for those in progress
+    l.namespaceMu.Lock()
+    defer l.namespaceMu.Unlock()
+    if path, ok := l.lookupPathByInode(handle.Inode); ok && l.holds(path, handle.Inode) {
+        l.checkBirth(path, handle.Inode)
+        if handle.Generation != l.getGeneration(handle.Inode) {
+            return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
+        }
+        return path, nil
+    }
+    if handle.Generation == l.getGeneration(handle.Inode) {
+        l.inodeMap.bumpGeneration(handle.Inode)
+    }
+    l.inodeMap.CompareAndDelete(handle.Inode, path)
+    return "", fs.NewInodeError("FileHandleToPath", "", handle.Inode, fs.ErrStale)
 }
 
-// Add dynamic lookup method
-func (l *LocalFileSystem) findPathByInode(targetInode uint64) (string, error) {
-    var result string
-    var found bool
+// buildIndex indexes every file in the tree, keeping the paths already
+// recorded, unless the index is already complete. It gives up when ctx is
+// done and is tried again on the next miss.
+func (l *LocalFileSystem) buildIndex(ctx context.Context) error {
+    l.buildMu.Lock()
+    defer l.buildMu.Unlock()
+    if l.indexed.Load() {
+        return nil
+    }
     
-    err := filepath.Walk(l.rootPath, func(path string, info os.FileInfo, err error) error {
+    start := time.Now()
+    err := l.walkInodes(ctx, func(inode uint64, path string) bool {
+        l.inodeMap.storeIfAbsent(inode, path)
+        return true
+    })
+    if err != nil {
+        return err
+    }
+    l.indexed.Store(true)
+    slog.InfoContext(ctx, "Indexed export", "files", l.inodeMap.Len(), "root", l.rootPath, "duration", time.Since(start))
+    return nil
+}
+
+// walkInodes calls fn with the inode and path of every file in the tree
+// until it returns false, giving up when ctx is done. Unreadable
+// directories are skipped, and so are snapshots, whose files are not
+// indexed.
+func (l *LocalFileSystem) walkInodes(ctx context.Context, fn func(inode uint64, path string) bool) error {
+    snapshotsPath := l.snapshotsPath()
+    return filepath.WalkDir(l.rootPath, func(fullPath string, d os.DirEntry, err error) error {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return ctxErr
+        }
         if err != nil {
             return nil // Continue traversal
         }
+        if fullPath == snapshotsPath {
+            return filepath.SkipDir
+        }
         
-        stat, ok := info.Sys().(*syscall.Stat_t)
+        info, err := l.lstat(fullPath)
+        if err != nil {
+            return nil
+        }
+        stat, ok := statOf(fullPath, info)
         if !ok {
             return nil
         }
         
-        if stat.Ino == targetInode {
-            // Found matching inode
-            relPath, err := filepath.Rel(l.rootPath, path)
-            if err != nil {
-                return nil
-            }
-            
-            // Handle root directory
-            if relPath == "." {
-                result = "/"
-            } else {
-                result = "/" + relPath
-            }
-            
-            found = true
-            return filepath.SkipAll // Stop traversal after finding
+        relPath, err := filepath.Rel(l.rootPath, fullPath)
+        if err != nil {
+            return nil
+        }
+        if !fn(stat.Ino, filepath.Join("/", relPath)) {
+            return filepath.SkipAll
         }
-        
         return nil
     })
-    
-    if err != nil {
-        return "", err
+}
+
+// unlinked updates the index after the name path of the file described by
+// info was removed or replaced. Removing the last link moves the inode to
+// a new generation, so handles of the file stay stale once the inode is
+// reused. If the index had the file at path but it has further links, the
+// tree is searched for one of them, so its handle keeps resolving; links
+// only snapshots still hold count as none.
+func (l *LocalFileSystem) unlinked(ctx context.Context, info os.FileInfo, path string) {
+    stat, ok := l.statAt(path, info)
+    if !ok {
+        return
     }
-    
-    if !found {
-        return "", fmt.Errorf("could not find file with inode=%d", targetInode)
+    lastLink := info.IsDir() || stat.Nlink <= 1
+    if lastLink {
+        l.inodeMap.bumpGeneration(stat.Ino)
+    }
+    if !l.inodeMap.CompareAndDelete(stat.Ino, path) || lastLink {
+        return
+    }
+    found := false
+    l.walkInodes(ctx, func(inode uint64, foundPath string) bool {
+        if inode != stat.Ino {
+            return true
+        }
+        l.inodeMap.storeIfAbsent(stat.Ino, foundPath)
+        found = true
+        return false
+    })
+    if !found && ctx.Err() == nil {
+        l.inodeMap.bumpGeneration(stat.Ino)
+    }
+}
+
+// indexCreated records the new file at path described by info
+func (l *LocalFileSystem) indexCreated(path string, info os.FileInfo) {
+    if stat, ok := l.statAt(path, info); ok {
+        l.observed(path, stat.Ino)
     }
-    
-    return result, nil
 }
 
 // PathToFileHandle converts a file system path to a file handle.
-func (l *LocalFileSystem) PathToFileHandle(path string) ([]byte, error) {
+func (l *LocalFileSystem) PathToFileHandle(ctx context.Context, path string) ([]byte, error) {
     // Get inode for the path
     inode, err := l.getInode(path)
     if err != nil {
         return nil, fs.NewError("PathToFileHandle", path, err)
     }
+    if isSnapshotPath(path) {
+        return l.snapshotHandle(path, inode), nil
+    }
     
-    // Get or create generation number
+    // Update inode map, which may find the inode reused
+    l.observed(path, inode)
+    l.checkBirth(path, inode)
     generation := l.getGeneration(inode)
     
-    // Update inode map
-    l.updateInodeMap(path, inode)
-    
     // Create and serialize the file handle
     handle := &fs.FileHandle{
         FileSystemID: l.fsID,
@@ -401,14 +517,56 @@ func (l *LocalFileSystem) PathToFileHandle(path string) ([]byte, error) {
     return handle.Serialize(), nil
 }
 
+// ListHandles calls fn with the path and handle of every file in the inode
+// index until it returns false or ctx is done.
+func (l *LocalFileSystem) ListHandles(ctx context.Context, fn func(path string, handle []byte) bool) error {
+    var err error
+    l.inodeMap.Range(func(inode uint64, path string) bool {
+        if err = ctx.Err(); err != nil {
+            return false
+        }
+        handle := &fs.FileHandle{
+            FileSystemID: l.fsID,
+            Inode:        inode,
+            Generation:   l.getGeneration(inode),
+        }
+        return fn(path, handle.Serialize())
+    })
+    if err != nil {
+        return fs.NewError("ListHandles", "/", err)
+    }
+    return nil
+}
+
 // SetAttr modifies attributes for the file at the specified path.
 func (l *LocalFileSystem) SetAttr(ctx context.Context, path string, attr fs.FileAttr) (fs.FileInfo, error) {
+    if err := readOnly("SetAttr", path); err != nil {
+        return fs.FileInfo{}, err
+    }
+    
     // Resolve and validate path
-    fullPath, err := l.resolvePath(path)
+    fullPath, err := l.resolveFollow(path)
     if err != nil {
         return fs.FileInfo{}, fs.NewError("SetAttr", path, err)
     }
     
+    // Snapshots sharing the file keep its old attributes
+    l.snapshotMu.RLock()
+    defer l.snapshotMu.RUnlock()
+    if err := l.unshare(fullPath); err != nil {
+        return fs.FileInfo{}, fs.NewError("SetAttr", path, mapOSError(err))
+    }
+    
+    // Times-only updates (touch, rsync preserving mtimes) are by far the most
+    // common; they need a single utimensat and no stat beforehand
+    if attr.Mode == nil && attr.Uid == nil && attr.Gid == nil && attr.Size == nil {
+        if err := setFileTimes(fullPath, attr.AccessTime, attr.ModifyTime); err != nil {
+            return fs.FileInfo{}, fs.NewError("SetAttr", path, mapOSError(err))
+        }
+        l.bumpChangeID(fullPath)
+        return l.GetAttr(ctx, path)
+    }
+    
     // Get current file info
     fileInfo, err := os.Stat(fullPath)
     if err != nil {
@@ -419,7 +577,7 @@ func (l *LocalFileSystem) SetAttr(ctx context.Context, path string, attr fs.File
     
     // Change mode if specified
     if attr.Mode != nil {
-        err = os.Chmod(fullPath, os.FileMode(*attr.Mode))
+        err = chmod(fullPath, uint32(*attr.Mode&07777))
         if err != nil {
             return fs.FileInfo{}, fs.NewError("SetAttr", path, mapOSError(err))
         }
@@ -428,9 +586,9 @@ func (l *LocalFileSystem) SetAttr(ctx context.Context, path string, attr fs.File
     // Change ownership if specified
     if attr.Uid != nil || attr.Gid != nil {
         // Get current ownership if only one is specified
-        stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+        stat, ok := statOf(fullPath, fileInfo)
         if !ok {
-            return fs.FileInfo{}, fs.NewError("SetAttr", path, fmt.Errorf("unable to get file system info"))
+            return fs.FileInfo{}, fs.NewStatusError("SetAttr", path, api.Status_ERR_SERVERFAULT, errors.New("unable to get file system info"))
         }
         
         uid := int(stat.Uid)
@@ -459,24 +617,12 @@ func (l *LocalFileSystem) SetAttr(ctx context.Context, path string, attr fs.File
     }
     
     // Change access/modification times if specified
-    if attr.AccessTime != nil || attr.ModifyTime != nil {
-        atime := fileInfo.ModTime() // Use current by default
-        mtime := fileInfo.ModTime()
-        
-        if attr.AccessTime != nil {
-            atime = *attr.AccessTime
-        }
-        
-        if attr.ModifyTime != nil {
-            mtime = *attr.ModifyTime
-        }
-        
-        err = os.Chtimes(fullPath, atime, mtime)
-        if err != nil {
-            return fs.FileInfo{}, fs.NewError("SetAttr", path, mapOSError(err))
-        }
+    if err := setFileTimes(fullPath, attr.AccessTime, attr.ModifyTime); err != nil {
+        return fs.FileInfo{}, fs.NewError("SetAttr", path, mapOSError(err))
     }
     
+    l.bumpChangeID(fullPath)
+    
     // Get updated file info
     newFileInfo, err := os.Stat(fullPath)
     if err != nil {
@@ -495,7 +641,7 @@ func (l *LocalFileSystem) SetAttr(ctx context.Context, path string, attr fs.File
 // Lookup finds a file by name within a directory.
 func (l *LocalFileSystem) Lookup(ctx context.Context, dir string, name string) (string, fs.FileInfo, error) {
     // Ensure dir is actually a directory
-    dirPath, err := l.resolvePath(dir)
+    dirPath, err := l.resolveFollow(dir)
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Lookup", dir, err)
     }
@@ -512,6 +658,12 @@ func (l *LocalFileSystem) Lookup(ctx context.Context, dir string, name string) (
     // Create the full path for the target file/directory
     targetName := filepath.Join(dir, name)
     
+    // The staging area for unnamed files is not part of the namespace,
+    // though the trees in its trash are walked to delete them
+    if isStagingPath(targetName) && !isTrashPath(targetName) {
+        return "", fs.FileInfo{}, fs.NewError("Lookup", targetName, fs.ErrNotExist)
+    }
+    
     // Get file info for the target
     fileInfo, err := l.getFileInfo(targetName)
     if err != nil {
@@ -530,7 +682,7 @@ func (l *LocalFileSystem) Lookup(ctx context.Context, dir string, name string) (
 // Read reads data from a file at the specified offset.
 func (l *LocalFileSystem) Read(ctx context.Context, path string, offset int64, length int) ([]byte, bool, error) {
     // Resolve and validate path
-    fullPath, err := l.resolvePath(path)
+    fullPath, err := l.resolveFollow(path)
     if err != nil {
         return nil, false, fs.NewError("Read", path, err)
     }
@@ -553,7 +705,7 @@ func (l *LocalFileSystem) Read(ctx context.Context, path string, offset int64, l
     }
     
     // Open the file for reading
-    file, err := os.Open(fullPath)
+    file, err := l.openFile(fullPath, os.O_RDONLY, 0)
     if err != nil {
         return nil, false, fs.NewError("Read", path, mapOSError(err))
     }
@@ -592,14 +744,65 @@ func (l *LocalFileSystem) Read(ctx context.Context, path string, offset int64, l
     return buffer, eof, nil
 }
 
+// ReadInto reads up to len(buf) bytes at offset into buf, so the caller can
+// reuse buffers across reads.
+func (l *LocalFileSystem) ReadInto(ctx context.Context, path string, offset int64, buf []byte) (int, bool, error) {
+    // Resolve and validate path
+    fullPath, err := l.resolveFollow(path)
+    if err != nil {
+        return 0, false, fs.NewError("Read", path, err)
+    }
+    
+    file, err := l.openFile(fullPath, os.O_RDONLY, 0)
+    if err != nil {
+        return 0, false, fs.NewError("Read", path, mapOSError(err))
+    }
+    defer file.Close()
+    
+    fileInfo, err := file.Stat()
+    if err != nil {
+        return 0, false, fs.NewError("Read", path, mapOSError(err))
+    }
+    if fileInfo.IsDir() {
+        return 0, false, fs.NewError("Read", path, fs.ErrIsDir)
+    }
+    
+    fileSize := fileInfo.Size()
+    if offset >= fileSize {
+        return 0, true, nil
+    }
+    if remaining := fileSize - offset; int64(len(buf)) > remaining {
+        buf = buf[:remaining]
+    }
+    
+    bytesRead, err := file.ReadAt(buf, offset)
+    eof := offset+int64(bytesRead) >= fileSize
+    if err != nil && err != io.EOF {
+        return bytesRead, eof, fs.NewError("Read", path, mapOSError(err))
+    }
+    
+    return bytesRead, eof, nil
+}
+
 // Write writes data to a file at the specified offset.
 func (l *LocalFileSystem) Write(ctx context.Context, path string, offset int64, data []byte, sync bool) (int, error) {
+    if err := readOnly("Write", path); err != nil {
+        return 0, err
+    }
+    
     // Resolve and validate path
-    fullPath, err := l.resolvePath(path)
+    fullPath, err := l.resolveFollow(path)
     if err != nil {
         return 0, fs.NewError("Write", path, err)
     }
     
+    // Snapshots sharing the file keep its old contents
+    l.snapshotMu.RLock()
+    defer l.snapshotMu.RUnlock()
+    if err := l.unshare(fullPath); err != nil {
+        return 0, fs.NewError("Write", path, mapOSError(err))
+    }
+    
     // Get file info to check if it's a regular file
     fileInfo, err := os.Stat(fullPath)
     if err != nil {
@@ -611,7 +814,7 @@ func (l *LocalFileSystem) Write(ctx context.Context, path string, offset int64,
     }
     
     // Open file for writing
-    file, err := os.OpenFile(fullPath, os.O_RDWR, 0)
+    file, err := l.openFile(fullPath, os.O_RDWR, 0)
     if err != nil {
         return 0, fs.NewError("Write", path, mapOSError(err))
     }
@@ -628,6 +831,9 @@ func (l *LocalFileSystem) Write(ctx context.Context, path string, offset int64,
     if err != nil {
         return 0, fs.NewError("Write", path, mapOSError(err))
     }
+    if bytesWritten > 0 {
+        l.bumpChangeID(fullPath)
+    }
     
     // Sync to disk if requested
     if sync && bytesWritten > 0 {
@@ -642,8 +848,15 @@ func (l *LocalFileSystem) Write(ctx context.Context, path string, offset int64,
 
 // Access checks if the given credentials can access the file with the requested permission.
 func (l *LocalFileSystem) Access(ctx context.Context, path string, mode fs.FileMode, creds fs.Credentials) error {
+    // Nothing in a snapshot can be written, whatever its mode says
+    if mode&2 != 0 {
+        if err := readOnly("Access", path); err != nil {
+            return err
+        }
+    }
+    
     // Resolve and validate path
-    fullPath, err := l.resolvePath(path)
+    fullPath, err := l.resolveFollow(path)
     if err != nil {
         return fs.NewError("Access", path, err)
     }
@@ -655,14 +868,27 @@ func (l *LocalFileSystem) Access(ctx context.Context, path string, mode fs.FileM
     }
     
     // Get system-specific information
-    stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+    stat, ok := statOf(fullPath, fileInfo)
     if !ok {
-        return fs.NewError("Access", path, fmt.Errorf("unable to get system information"))
+        return fs.NewStatusError("Access", path, api.Status_ERR_SERVERFAULT, errors.New("unable to get system information"))
     }
     
     // Convert file mode to a permission mask
     requiredPerm := mode & 7 // Keep only the rwx bits
     
+    // An ACL, where there is one, decides on its own; its entries for the
+    // owner, group class and others are the mode bits
+    acl, err := readACL(fullPath)
+    if err != nil {
+        return fs.NewError("Access", path, mapOSError(err))
+    }
+    if acl != nil {
+        if !acl.Allows(stat.Uid, stat.Gid, creds, requiredPerm) {
+            return fs.NewError("Access", path, fs.ErrPermission)
+        }
+        return nil
+    }
+    
     // Check if user is owner, in group, or other
     var checkPerm fs.FileMode
     fileMode := fs.FileMode(fileInfo.Mode() & 0777) // Get permission bits
@@ -698,8 +924,12 @@ func containsGroup(groups []uint32, gid uint32) bool {
 
 // Create creates a new file in the specified directory.
 func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+    if err := readOnly("Create", filepath.Join(dir, name)); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
     // Resolve parent directory path
-    parentPath, err := l.resolvePath(dir)
+    parentPath, err := l.resolveFollow(dir)
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Create", dir, err)
     }
@@ -714,8 +944,12 @@ func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, a
         return "", fs.FileInfo{}, fs.NewError("Create", dir, fs.ErrNotDir)
     }
     
-    // Create full path for new file
-    newFilePath := filepath.Join(parentPath, name)
+    // Create full path for new file. An existing link of that name is
+    // followed by the open, so where it leads is checked too.
+    newFilePath, err := l.resolveFollow(filepath.Join(dir, name))
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Create", filepath.Join(dir, name), err)
+    }
     
     // Check for exclusive create
     if excl {
@@ -735,8 +969,14 @@ func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, a
         perm = os.FileMode(*attr.Mode)
     }
     
-    // Create the file
-    file, err := os.OpenFile(newFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+    // Create the file; snapshots sharing a file it truncates keep theirs
+    l.snapshotMu.RLock()
+    err = l.unshare(newFilePath)
+    var file *os.File
+    if err == nil {
+        file, err = l.openFile(newFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+    }
+    l.snapshotMu.RUnlock()
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Create", filepath.Join(dir, name), mapOSError(err))
     }
@@ -751,6 +991,10 @@ func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, a
         }
     }
     
+    // The file may have existed and been truncated
+    l.bumpChangeID(newFilePath)
+    l.bumpChangeID(parentPath)
+    
     // Get information about the new file
     newFileInfo, err := os.Stat(newFilePath)
     if err != nil {
@@ -759,6 +1003,7 @@ func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, a
     
     // Convert to fs.FileInfo
     newFileRelPath := filepath.Join(dir, name)
+    l.indexCreated(newFileRelPath, newFileInfo)
     fsInfo, err := l.convertFileInfo(newFileRelPath, newFileInfo)
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Create", newFileRelPath, err)
@@ -769,14 +1014,19 @@ func (l *LocalFileSystem) Create(ctx context.Context, dir string, name string, a
 
 // Remove removes the specified file.
 func (l *LocalFileSystem) Remove(ctx context.Context, path string) error {
+    if err := readOnly("Remove", path); err != nil {
+        return err
+    }
+    
     // Resolve and validate path
     fullPath, err := l.resolvePath(path)
     if err != nil {
         return fs.NewError("Remove", path, err)
     }
     
-    // Check if path exists
-    fileInfo, err := os.Stat(fullPath)
+    // Check if path exists; a symlink is removed even if it points to a
+    // directory
+    fileInfo, err := l.lstat(fullPath)
     if err != nil {
         return fs.NewError("Remove", path, mapOSError(err))
     }
@@ -787,10 +1037,17 @@ func (l *LocalFileSystem) Remove(ctx context.Context, path string) error {
     }
     
     // Remove the file
+    l.namespaceMu.RLock()
+    defer l.namespaceMu.RUnlock()
     err = os.Remove(fullPath)
     if err != nil {
         return fs.NewError("Remove", path, mapOSError(err))
     }
+    l.bumpChangeID(filepath.Dir(fullPath))
+    
+    // The handle stops resolving to the removed name. A file with other
+    // links lives on and is found again through one of the others.
+    l.unlinked(ctx, fileInfo, filepath.Join("/", path))
     
     return nil
 }
@@ -798,7 +1055,7 @@ func (l *LocalFileSystem) Remove(ctx context.Context, path string) error {
 // ReadDir reads the contents of a directory.
 func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
     // Resolve and validate path
-    fullPath, err := l.resolvePath(dir)
+    fullPath, err := l.resolveFollow(dir)
     if err != nil {
         return nil, 0, fs.NewError("ReadDir", dir, err)
     }
@@ -817,9 +1074,9 @@ func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64,
     var allEntries []fs.DirEntry
     
     // Get inode for current directory
-    currentDirStat, ok := fileInfo.Sys().(*syscall.Stat_t)
+    currentDirStat, ok := statOf(fullPath, fileInfo)
     if !ok {
-        return nil, 0, fs.NewError("ReadDir", dir, fmt.Errorf("unable to get system information"))
+        return nil, 0, fs.NewStatusError("ReadDir", dir, api.Status_ERR_SERVERFAULT, errors.New("unable to get system information"))
     }
     
     // Add "." entry (current directory)
@@ -829,12 +1086,17 @@ func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64,
         Cookie: 1,
     })
     
-    // Add ".." entry (parent directory)
+    // Add ".." entry (parent directory). Clients cannot leave the export,
+    // so the root's ".." is the root itself, as its attributes say.
     parentPath := filepath.Dir(fullPath)
-    parentInfo, err := os.Stat(parentPath)
+    if fullPath == l.snapshotsPath() {
+        parentPath = l.rootPath
+    }
     var parentIno uint64
-    if err == nil {
-        if parentStat, ok := parentInfo.Sys().(*syscall.Stat_t); ok {
+    if fullPath == l.rootPath {
+        parentIno = currentDirStat.Ino
+    } else if parentInfo, err := os.Stat(parentPath); err == nil {
+        if parentStat, ok := statOf(parentPath, parentInfo); ok {
             parentIno = parentStat.Ino
         }
     }
@@ -855,13 +1117,26 @@ func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64,
         return nil, 0, fs.NewError("ReadDir", dir, mapOSError(err))
     }
     
+    // Hide the staging area, and anything /.snapshots hides, before
+    // cookies are assigned
+    if fullPath == l.rootPath {
+        visible := entries[:0]
+        for _, entry := range entries {
+            if entry.Name() != stagingDirName && entry.Name() != snapshotsName {
+                visible = append(visible, entry)
+            }
+        }
+        entries = visible
+    }
+    
     // Add regular entries
-    for i, entry := range entries {
+    regular := make([]fs.DirEntry, 0, len(entries))
+    for _, entry := range entries {
         // Generate a unique file ID (using inode number if possible)
         var fileId uint64
         info, err := entry.Info()
         if err == nil {
-            if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+            if stat, ok := statOf(filepath.Join(fullPath, entry.Name()), info); ok {
                 fileId = stat.Ino
             }
         }
@@ -875,44 +1150,36 @@ func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64,
             fileId = h
         }
         
-        // Set the cookie for this entry (i+3 because "." is 1 and ".." is 2)
-        nextCookie := int64(i + 3)
-        
-        allEntries = append(allEntries, fs.DirEntry{
+        regular = append(regular, fs.DirEntry{
             Name:       entry.Name(),
             FileId:     fileId,
-            Cookie:     nextCookie,
             Attributes: nil, // No attributes in basic ReadDir
         })
     }
     
-    // Handle pagination using cookie
-    var result []fs.DirEntry
-    if cookie == 0 {
-        // First page, include all entries up to count
-        result = allEntries
-    } else {
-        // Find entries after the cookie
-        for _, entry := range allEntries {
-            if entry.Cookie > cookie {
-                result = append(result, entry)
-            }
-        }
-    }
+    // Cookies are hashes of the names rather than positions, so a listing
+    // resumed after entries were added or removed carries on where it was
+    fs.SetNameCookies(regular)
+    allEntries = append(allEntries, regular...)
+    
+    // Handle pagination using cookie: entries are in cookie order, so the
+    // page starts at the first entry past it, whether or not the entry the
+    // cookie belonged to is still there
+    start := sort.Search(len(allEntries), func(i int) bool {
+        return allEntries[i].Cookie > cookie
+    })
+    result := allEntries[start:]
     
     // Limit number of entries if count is specified
     if count > 0 && count < len(result) {
         result = result[:count]
     }
     
-    // Calculate next cookie value
-    var nextCookie int64
+    // The next page follows the last entry of this one, or the cookie
+    // again past the end
+    nextCookie := cookie
     if len(result) > 0 {
-        // Next cookie is the cookie of the last entry plus 1
-        nextCookie = result[len(result)-1].Cookie + 1
-    } else {
-        // No entries, use a cookie that indicates end of directory
-        nextCookie = int64(len(allEntries) + 1)
+        nextCookie = result[len(result)-1].Cookie
     }
     
     // Update the inode map for "." and ".."
@@ -930,8 +1197,12 @@ func (l *LocalFileSystem) ReadDir(ctx context.Context, dir string, cookie int64,
 
 // Mkdir creates a new directory.
 func (l *LocalFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+    if err := readOnly("Mkdir", filepath.Join(dir, name)); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
     // Resolve parent directory path
-    parentPath, err := l.resolvePath(dir)
+    parentPath, err := l.resolveFollow(dir)
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Mkdir", dir, err)
     }
@@ -961,6 +1232,8 @@ func (l *LocalFileSystem) Mkdir(ctx context.Context, dir string, name string, at
         return "", fs.FileInfo{}, fs.NewError("Mkdir", filepath.Join(dir, name), mapOSError(err))
     }
     
+    l.bumpChangeID(parentPath)
+    
     // Get information about the new directory
     newDirInfo, err := os.Stat(newDirPath)
     if err != nil {
@@ -969,6 +1242,7 @@ func (l *LocalFileSystem) Mkdir(ctx context.Context, dir string, name string, at
     
     // Convert to fs.FileInfo
     newDirRelPath := filepath.Join(dir, name)
+    l.indexCreated(newDirRelPath, newDirInfo)
     fsInfo, err := l.convertFileInfo(newDirRelPath, newDirInfo)
     if err != nil {
         return "", fs.FileInfo{}, fs.NewError("Mkdir", newDirRelPath, err)
@@ -979,14 +1253,18 @@ func (l *LocalFileSystem) Mkdir(ctx context.Context, dir string, name string, at
 
 // Rmdir removes the specified directory.
 func (l *LocalFileSystem) Rmdir(ctx context.Context, path string) error {
+    if err := readOnly("Rmdir", path); err != nil {
+        return err
+    }
+    
     // Resolve and validate path
     fullPath, err := l.resolvePath(path)
     if err != nil {
         return fs.NewError("Rmdir", path, err)
     }
     
-    // Check if path exists and is a directory
-    fileInfo, err := os.Stat(fullPath)
+    // Check if path exists and is a directory, not a symlink to one
+    fileInfo, err := l.lstat(fullPath)
     if err != nil {
         return fs.NewError("Rmdir", path, mapOSError(err))
     }
@@ -996,31 +1274,146 @@ func (l *LocalFileSystem) Rmdir(ctx context.Context, path string) error {
     }
     
     // Check if directory is empty
-    entries, err := os.ReadDir(fullPath)
+    empty, err := l.IsEmpty(ctx, path)
     if err != nil {
-        return fs.NewError("Rmdir", path, mapOSError(err))
+        return fs.NewError("Rmdir", path, err)
     }
     
-    if len(entries) > 0 {
+    if !empty {
         return fs.NewError("Rmdir", path, fs.ErrNotEmpty)
     }
     
     // Remove the directory
+    l.namespaceMu.RLock()
+    defer l.namespaceMu.RUnlock()
     err = os.Remove(fullPath)
     if err != nil {
         return fs.NewError("Rmdir", path, mapOSError(err))
     }
+    l.bumpChangeID(filepath.Dir(fullPath))
+    l.unlinked(ctx, fileInfo, filepath.Join("/", path))
     
     return nil
 }
 
+// openDir opens a directory for reading names
+func (l *LocalFileSystem) openDir(op string, dir string) (*os.File, string, error) {
+    fullPath, err := l.resolveFollow(dir)
+    if err != nil {
+        return nil, "", fs.NewError(op, dir, err)
+    }
+    
+    f, err := l.openFile(fullPath, os.O_RDONLY, 0)
+    if err != nil {
+        return nil, "", fs.NewError(op, dir, mapOSError(err))
+    }
+    
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return nil, "", fs.NewError(op, dir, mapOSError(err))
+    }
+    if !info.IsDir() {
+        f.Close()
+        return nil, "", fs.NewError(op, dir, fs.ErrNotDir)
+    }
+    
+    return f, fullPath, nil
+}
+
+// EntryCount counts the entries in a directory. Only names are read, so no
+// entry is stat'ed and nothing is sorted.
+func (l *LocalFileSystem) EntryCount(ctx context.Context, dir string) (uint64, error) {
+    f, fullPath, err := l.openDir("EntryCount", dir)
+    if err != nil {
+        return 0, err
+    }
+    defer f.Close()
+    
+    var count uint64
+    for {
+        names, err := f.Readdirnames(1024)
+        for _, name := range names {
+            if fullPath == l.rootPath && (name == stagingDirName || name == snapshotsName) {
+                continue
+            }
+            count++
+        }
+        if err == io.EOF {
+            return count, nil
+        }
+        if err != nil {
+            return 0, fs.NewError("EntryCount", dir, mapOSError(err))
+        }
+        if ctx.Err() != nil {
+            return 0, ctx.Err()
+        }
+    }
+}
+
+// IsEmpty reports whether a directory has no entries, reading at most a
+// couple of names
+func (l *LocalFileSystem) IsEmpty(ctx context.Context, dir string) (bool, error) {
+    f, fullPath, err := l.openDir("IsEmpty", dir)
+    if err != nil {
+        return false, err
+    }
+    defer f.Close()
+    
+    for {
+        names, err := f.Readdirnames(2)
+        for _, name := range names {
+            if fullPath == l.rootPath && (name == stagingDirName || name == snapshotsName) {
+                continue
+            }
+            return false, nil
+        }
+        if err == io.EOF {
+            return true, nil
+        }
+        if err != nil {
+            return false, fs.NewError("IsEmpty", dir, mapOSError(err))
+        }
+    }
+}
+
 // ReadDirPlus is like ReadDir, but also returns file attributes for each entry.
+// Entries removed between listing the directory and reading their attributes
+// are left out.
 func (l *LocalFileSystem) ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
-    return nil, 0, fs.NewError("ReadDirPlus", dir, fs.ErrNotSupported)
+    entries, nextCookie, err := l.ReadDir(ctx, dir, cookie, count)
+    if err != nil {
+        return nil, 0, err
+    }
+    
+    result := entries[:0]
+    for _, entry := range entries {
+        // ".." of the root is the root itself
+        entryPath := filepath.Join(dir, entry.Name)
+        osInfo, err := l.getFileInfo(entryPath)
+        if err != nil {
+            continue
+        }
+        info, err := l.convertFileInfo(entryPath, osInfo)
+        if err != nil {
+            continue
+        }
+        entry.Attributes = &info
+        result = append(result, entry)
+    }
+    
+    return result, nextCookie, nil
 }
 
 // Rename renames a file or directory.
-func (l *LocalFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+func (l *LocalFileSystem) Rename(ctx context.Context, oldPath string, newPath string, flags fs.RenameFlags) error {
+    if flags&fs.RenameNoReplace != 0 && flags&fs.RenameExchange != 0 {
+        return fs.NewError("Rename", oldPath, fs.ErrInvalidName)
+    }
+    if err := readOnly("Rename", oldPath, newPath); err != nil {
+        return err
+    }
+    
     // Resolve and validate both paths
     oldFullPath, err := l.resolvePath(oldPath)
     if err != nil {
@@ -1038,6 +1431,16 @@ func (l *LocalFileSystem) Rename(ctx context.Context, oldPath string, newPath st
         return fs.NewError("Rename", oldPath, mapOSError(err))
     }
     
+    // The renamed file and any file it replaces, for the index
+    oldInfo, err := l.lstat(oldFullPath)
+    if err != nil {
+        return fs.NewError("Rename", oldPath, mapOSError(err))
+    }
+    replacedInfo, _ := l.lstat(newFullPath)
+    if flags&fs.RenameExchange != 0 && replacedInfo == nil {
+        return fs.NewError("Rename", newPath, fs.ErrNotExist)
+    }
+    
     // Check if destination parent directory exists
     newParent := filepath.Dir(newFullPath)
     parentInfo, err := os.Stat(newParent)
@@ -1050,40 +1453,262 @@ func (l *LocalFileSystem) Rename(ctx context.Context, oldPath string, newPath st
     }
     
     // Perform the rename operation
-    err = os.Rename(oldFullPath, newFullPath)
+    l.namespaceMu.RLock()
+    defer l.namespaceMu.RUnlock()
+    err = renameFlags(oldFullPath, newFullPath, flags)
     if err != nil {
         return fs.NewError("Rename", oldPath+" to "+newPath, mapOSError(err))
     }
+    l.bumpChangeID(newFullPath)
+    l.bumpChangeID(filepath.Dir(oldFullPath))
+    if newParent != filepath.Dir(oldFullPath) {
+        l.bumpChangeID(newParent)
+    }
+    if flags&fs.RenameExchange != 0 {
+        l.bumpChangeID(oldFullPath)
+        l.indexExchanged(filepath.Join("/", oldPath), filepath.Join("/", newPath), oldInfo, replacedInfo)
+        return nil
+    }
+    l.indexRenamed(ctx, filepath.Join("/", oldPath), filepath.Join("/", newPath), oldInfo, replacedInfo)
     
     return nil
 }
 
-// Symlink creates a symbolic link.
+// indexRenamed updates the index after the file described by oldInfo was
+// renamed from oldPath to newPath, replacing the file described by
+// replacedInfo if there was one. Handles of files inside a renamed
+// directory follow it.
+func (l *LocalFileSystem) indexRenamed(ctx context.Context, oldPath string, newPath string, oldInfo os.FileInfo, replacedInfo os.FileInfo) {
+    stat, ok := l.statAt(oldPath, oldInfo)
+    if !ok {
+        return
+    }
+    if replacedInfo != nil {
+        if replaced, ok := l.statAt(newPath, replacedInfo); ok && replaced.Ino != stat.Ino {
+            l.unlinked(ctx, replacedInfo, newPath)
+        }
+    }
+    l.updateInodeMap(newPath, stat.Ino)
+    if oldInfo.IsDir() {
+        l.inodeMap.renameTree(oldPath, newPath)
+    }
+}
+
+// indexExchanged updates the index after the files described by oldInfo
+// and newInfo swapped places
+func (l *LocalFileSystem) indexExchanged(oldPath string, newPath string, oldInfo os.FileInfo, newInfo os.FileInfo) {
+    oldStat, ok := l.statAt(oldPath, oldInfo)
+    if !ok {
+        return
+    }
+    newStat, ok := l.statAt(newPath, newInfo)
+    if !ok {
+        return
+    }
+    l.updateInodeMap(newPath, oldStat.Ino)
+    l.updateInodeMap(oldPath, newStat.Ino)
+    l.inodeMap.swapTrees(oldPath, newPath)
+}
+
+// Symlink creates a symbolic link. The server follows links when it opens
+// files, so the target must stay inside the export: absolute targets and
+// relative ones that climb above the root are refused.
 func (l *LocalFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
-    return "", fs.FileInfo{}, fs.NewError("Symlink", filepath.Join(dir, name), fs.ErrNotSupported)
+    linkRelPath := filepath.Join(dir, name)
+    if err := readOnly("Symlink", linkRelPath); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
+    // Resolve parent directory path
+    parentPath, err := l.resolveFollow(dir)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", dir, err)
+    }
+    
+    parentInfo, err := os.Stat(parentPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", dir, mapOSError(err))
+    }
+    
+    if !parentInfo.IsDir() {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", dir, fs.ErrNotDir)
+    }
+    
+    if isStagingPath(linkRelPath) {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, fs.ErrInvalidName)
+    }
+    
+    if !l.symlinkTargetInside(parentPath, target) {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, fs.ErrInvalidName)
+    }
+    
+    linkPath := filepath.Join(parentPath, name)
+    if err := os.Symlink(target, linkPath); err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, mapOSError(err))
+    }
+    
+    // The link belongs to its creator, not to the server process
+    if attr.Uid != nil || attr.Gid != nil {
+        uid, gid := -1, -1
+        if attr.Uid != nil {
+            uid = int(*attr.Uid)
+        }
+        if attr.Gid != nil {
+            gid = int(*attr.Gid)
+        }
+        if err := os.Lchown(linkPath, uid, gid); err != nil {
+            os.Remove(linkPath)
+            return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, mapOSError(err))
+        }
+    }
+    
+    l.bumpChangeID(parentPath)
+    
+    linkInfo, err := os.Lstat(linkPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, mapOSError(err))
+    }
+    l.indexCreated(linkRelPath, linkInfo)
+    
+    fsInfo, err := l.convertFileInfo(linkRelPath, linkInfo)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Symlink", linkRelPath, err)
+    }
+    
+    return linkRelPath, fsInfo, nil
+}
+
+// symlinkTargetInside reports whether a link in parentPath pointing at
+// target resolves, lexically, to somewhere under the export root.
+func (l *LocalFileSystem) symlinkTargetInside(parentPath string, target string) bool {
+    if target == "" || filepath.IsAbs(target) {
+        return false
+    }
+    resolved := filepath.Join(parentPath, target)
+    return resolved == l.rootPath || strings.HasPrefix(resolved, l.rootPath+string(filepath.Separator))
+}
+
+// Link creates a hard link dir/name to the file at path.
+func (l *LocalFileSystem) Link(ctx context.Context, path string, dir string, name string) (string, fs.FileInfo, error) {
+    linkRelPath := filepath.Join(dir, name)
+    
+    // A link out of a snapshot would let the file be changed
+    if err := readOnly("Link", path, linkRelPath); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
+    // Resolve and validate the existing file
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", path, err)
+    }
+    
+    fileInfo, err := l.lstat(fullPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", path, mapOSError(err))
+    }
+    
+    if fileInfo.IsDir() {
+        return "", fs.FileInfo{}, fs.NewError("Link", path, fs.ErrIsDir)
+    }
+    
+    // Resolve parent directory path
+    parentPath, err := l.resolveFollow(dir)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", dir, err)
+    }
+    
+    parentInfo, err := os.Stat(parentPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", dir, mapOSError(err))
+    }
+    
+    if !parentInfo.IsDir() {
+        return "", fs.FileInfo{}, fs.NewError("Link", dir, fs.ErrNotDir)
+    }
+    
+    // Unnamed files are published with LinkUnnamed, not linked
+    if isStagingPath(path) || isStagingPath(linkRelPath) {
+        return "", fs.FileInfo{}, fs.NewError("Link", linkRelPath, fs.ErrInvalidName)
+    }
+    
+    linkPath := filepath.Join(parentPath, name)
+    if err := os.Link(fullPath, linkPath); err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", linkRelPath, mapOSError(err))
+    }
+    
+    // The link count is part of the file's attributes
+    l.bumpChangeID(fullPath)
+    l.bumpChangeID(parentPath)
+    
+    linkInfo, err := os.Lstat(linkPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", linkRelPath, mapOSError(err))
+    }
+    
+    // The file keeps its indexed name if it has one
+    if stat, ok := statOf(linkPath, linkInfo); ok {
+        l.inodeMap.storeIfAbsent(stat.Ino, linkRelPath)
+    }
+    
+    fsInfo, err := l.convertFileInfo(linkRelPath, linkInfo)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("Link", linkRelPath, err)
+    }
+    
+    return linkRelPath, fsInfo, nil
 }
 
 // Readlink reads the target of a symbolic link.
 func (l *LocalFileSystem) Readlink(ctx context.Context, path string) (string, error) {
-    return "", fs.NewError("Readlink", path, fs.ErrNotSupported)
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return "", fs.NewError("Readlink", path, err)
+    }
+    
+    info, err := l.lstat(fullPath)
+    if err != nil {
+        return "", fs.NewError("Readlink", path, mapOSError(err))
+    }
+    
+    if info.Mode()&os.ModeSymlink == 0 {
+        return "", fs.NewError("Readlink", path, fs.ErrInvalidName)
+    }
+    
+    target, err := os.Readlink(fullPath)
+    if err != nil {
+        return "", fs.NewError("Readlink", path, mapOSError(err))
+    }
+    
+    return target, nil
 }
 
 // StatFS retrieves file system statistics.
 func (l *LocalFileSystem) StatFS(ctx context.Context) (fs.FSStat, error) {
-    return fs.FSStat{}, fs.NewError("StatFS", "", fs.ErrNotSupported)
+    st, err := statFS(l.rootPath)
+    if err != nil {
+        return fs.FSStat{}, fs.NewError("StatFS", "/", mapOSError(err))
+    }
+    return st, nil
 }
 
 
-// Commit ensures that all data for the specified file has been flushed to stable storage
-func (l *LocalFileSystem) Commit(ctx context.Context, path string) error {
+// Commit flushes the file to stable storage. The whole file is synced
+// whatever the range, which the interface allows.
+func (l *LocalFileSystem) Commit(ctx context.Context, path string, offset int64, count int64) error {
+    if offset < 0 || count < 0 {
+        return fs.NewError("Commit", path, fs.ErrInvalidName)
+    }
+    
     // Resolve and validate path
-    fullPath, err := l.resolvePath(path)
+    fullPath, err := l.resolveFollow(path)
     if err != nil {
         return fs.NewError("Commit", path, err)
     }
     
-    // Open the file for syncing
-    file, err := os.OpenFile(fullPath, os.O_RDWR, 0)
+    // Open the file for syncing; fsync works on a read-only descriptor, so
+    // files without write permission can be committed too
+    file, err := l.openFile(fullPath, os.O_RDONLY, 0)
     if err != nil {
         return fs.NewError("Commit", path, mapOSError(err))
     }
@@ -7,7 +7,7 @@ import (
     "os"
     "path/filepath"
     "testing"
-    "syscall"
+    "time"
     
     "github.com/example/nfsserver/pkg/fs"
 )
@@ -213,7 +213,7 @@ func TestLocalFileSystem_FileHandles(t *testing.T) {
         relPath := "/" + file
         
         // Test path to handle conversion
-        handle, err := localFS.PathToFileHandle(relPath)
+        handle, err := localFS.PathToFileHandle(context.Background(), relPath)
         if err != nil {
             t.Errorf("PathToFileHandle failed for %s: %v", relPath, err)
             continue
@@ -237,7 +237,7 @@ func TestLocalFileSystem_FileHandles(t *testing.T) {
         }
         
         // Test handle to path conversion
-        resolvedPath, err := localFS.FileHandleToPath(handle)
+        resolvedPath, err := localFS.FileHandleToPath(context.Background(), handle)
         if err != nil {
             t.Errorf("FileHandleToPath failed for %s: %v", relPath, err)
             continue
@@ -249,7 +249,7 @@ func TestLocalFileSystem_FileHandles(t *testing.T) {
     }
     
     // Test invalid handle
-    _, err := localFS.FileHandleToPath([]byte("invalid-handle"))
+    _, err := localFS.FileHandleToPath(context.Background(), []byte("invalid-handle"))
     if err == nil {
         t.Error("FileHandleToPath should fail with invalid handle")
     }
@@ -693,6 +693,167 @@ func TestReadDir(t *testing.T) {
     }
 }
 
+// TestReadDirPlus tests that ReadDirPlus returns the attributes of each entry
+func TestReadDirPlus(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+
+    testDir := createTestDir(t, tempDir, "readdirplus-test")
+    _ = createTestDir(t, testDir, "subdir")
+    _ = createTestFile(t, testDir, "file.txt", "content")
+
+    entries, _, err := localFS.ReadDirPlus(context.Background(), "/readdirplus-test", 0, 0)
+    if err != nil {
+        t.Fatalf("ReadDirPlus failed: %v", err)
+    }
+
+    want := map[string]struct {
+        fileType fs.FileType
+        size     int64
+    }{
+        ".":        {fs.FileTypeDirectory, -1},
+        "..":       {fs.FileTypeDirectory, -1},
+        "subdir":   {fs.FileTypeDirectory, -1},
+        "file.txt": {fs.FileTypeRegular, int64(len("content"))},
+    }
+    if len(entries) != len(want) {
+        t.Fatalf("Wrong number of entries: got %d, want %d", len(entries), len(want))
+    }
+    for _, entry := range entries {
+        w, ok := want[entry.Name]
+        if !ok {
+            t.Errorf("Unexpected entry %q", entry.Name)
+            continue
+        }
+        if entry.Attributes == nil {
+            t.Errorf("Entry %q has no attributes", entry.Name)
+            continue
+        }
+        if entry.Attributes.Type != w.fileType {
+            t.Errorf("Entry %q has type %v, want %v", entry.Name, entry.Attributes.Type, w.fileType)
+        }
+        if w.size >= 0 && entry.Attributes.Size != w.size {
+            t.Errorf("Entry %q has size %d, want %d", entry.Name, entry.Attributes.Size, w.size)
+        }
+    }
+
+    // The root's ".." is the root itself
+    rootEntries, _, err := localFS.ReadDirPlus(context.Background(), "/", 0, 2)
+    if err != nil {
+        t.Fatalf("ReadDirPlus of root failed: %v", err)
+    }
+    if len(rootEntries) != 2 || rootEntries[1].Attributes == nil || rootEntries[1].Attributes.Type != fs.FileTypeDirectory {
+        t.Errorf("Root \"..\" entry missing or without attributes: %+v", rootEntries)
+    }
+}
+
+// TestSymlink tests Symlink and Readlink, including targets that would
+// lead out of the export
+func TestSymlink(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    
+    subDir := createTestDir(t, tempDir, "sub")
+    _ = createTestFile(t, subDir, "file.txt", "content")
+    
+    uid, gid := uint32(1234), uint32(5678)
+    linkPath, info, err := localFS.Symlink(context.Background(), "/", "link", "sub/file.txt", fs.FileAttr{Uid: &uid, Gid: &gid})
+    if err != nil {
+        t.Fatalf("Symlink failed: %v", err)
+    }
+    if linkPath != "/link" || info.Type != fs.FileTypeSymlink {
+        t.Errorf("Symlink returned %q of type %v, want /link of type symlink", linkPath, info.Type)
+    }
+    if info.Uid != uid || info.Gid != gid {
+        t.Errorf("Link owned by %d:%d, want %d:%d", info.Uid, info.Gid, uid, gid)
+    }
+    
+    target, err := localFS.Readlink(context.Background(), "/link")
+    if err != nil || target != "sub/file.txt" {
+        t.Errorf("Readlink returned %q, %v; want sub/file.txt", target, err)
+    }
+    
+    // The link has attributes and a handle of its own
+    attrs, err := localFS.GetAttr(context.Background(), "/link")
+    if err != nil || attrs.Type != fs.FileTypeSymlink {
+        t.Errorf("GetAttr returned type %v, %v; want symlink", attrs.Type, err)
+    }
+    handle, err := localFS.PathToFileHandle(context.Background(), "/link")
+    if err != nil {
+        t.Fatalf("PathToFileHandle failed: %v", err)
+    }
+    if path, err := localFS.FileHandleToPath(context.Background(), handle); err != nil || path != "/link" {
+        t.Errorf("Link handle resolves to %q, %v", path, err)
+    }
+    
+    if _, err := localFS.Readlink(context.Background(), "/sub/file.txt"); !errors.Is(err, fs.ErrInvalidName) {
+        t.Errorf("Readlink of a file returned %v, want ErrInvalidName", err)
+    }
+    
+    refused := []struct {
+        dir    string
+        target string
+    }{
+        {"/", "/etc/passwd"},
+        {"/", "../outside"},
+        {"/sub", "../../outside"},
+        {"/sub", "x/../../.."},
+        {"/", ""},
+    }
+    for _, tt := range refused {
+        _, _, err := localFS.Symlink(context.Background(), tt.dir, "bad", tt.target, fs.FileAttr{})
+        if !errors.Is(err, fs.ErrInvalidName) {
+            t.Errorf("Symlink in %s to %q returned %v, want ErrInvalidName", tt.dir, tt.target, err)
+        }
+    }
+    
+    // Climbing back down into the export is fine
+    if _, _, err := localFS.Symlink(context.Background(), "/sub", "up", "../sub/file.txt", fs.FileAttr{}); err != nil {
+        t.Errorf("Symlink to a target inside the export failed: %v", err)
+    }
+}
+
+// TestLink tests Link and that handles survive removing one of the names
+func TestLink(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    
+    subDir := createTestDir(t, tempDir, "sub")
+    _ = createTestFile(t, tempDir, "file.txt", "content")
+    
+    handle, err := localFS.PathToFileHandle(context.Background(), "/file.txt")
+    if err != nil {
+        t.Fatalf("PathToFileHandle failed: %v", err)
+    }
+    
+    linkPath, info, err := localFS.Link(context.Background(), "/file.txt", "/sub", "other.txt")
+    if err != nil {
+        t.Fatalf("Link failed: %v", err)
+    }
+    if linkPath != "/sub/other.txt" || info.Nlink != 2 || info.Size != int64(len("content")) {
+        t.Errorf("Link returned %q with nlink %d and size %d", linkPath, info.Nlink, info.Size)
+    }
+    if data, err := os.ReadFile(filepath.Join(subDir, "other.txt")); err != nil || string(data) != "content" {
+        t.Errorf("Link reads %q, %v", data, err)
+    }
+    
+    if _, _, err := localFS.Link(context.Background(), "/file.txt", "/sub", "other.txt"); !errors.Is(err, fs.ErrExist) {
+        t.Errorf("Link over an existing name returned %v, want ErrExist", err)
+    }
+    if _, _, err := localFS.Link(context.Background(), "/sub", "/", "dirlink"); !errors.Is(err, fs.ErrIsDir) {
+        t.Errorf("Link of a directory returned %v, want ErrIsDir", err)
+    }
+    
+    // The handle follows the file to its remaining name
+    if err := localFS.Remove(context.Background(), "/file.txt"); err != nil {
+        t.Fatalf("Remove failed: %v", err)
+    }
+    path, err := localFS.FileHandleToPath(context.Background(), handle)
+    if err != nil || path != "/sub/other.txt" {
+        t.Errorf("Handle resolves to %q, %v after removing the first name", path, err)
+    }
+}
+
 // TestMkdir tests the Mkdir method
 func TestMkdir(t *testing.T) {
     localFS, tempDir, cleanup := setupTestFS(t)
@@ -804,6 +965,63 @@ func TestRmdir(t *testing.T) {
     }
 }
 
+// TestEntryCount tests the EntryCount and IsEmpty methods
+func TestEntryCount(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    
+    ctx := context.Background()
+    createTestDir(t, tempDir, "empty-dir")
+    fullDir := createTestDir(t, tempDir, "full-dir")
+    for _, name := range []string{"a", "b", "c"} {
+        createTestFile(t, fullDir, name, "content")
+    }
+    createTestDir(t, fullDir, "sub")
+    
+    testCases := []struct {
+        path      string
+        wantCount uint64
+    }{
+        {"/empty-dir", 0},
+        {"/full-dir", 4},
+        {"/", 2},
+    }
+    
+    for _, tc := range testCases {
+        count, err := localFS.EntryCount(ctx, tc.path)
+        if err != nil {
+            t.Fatalf("EntryCount(%s) failed: %v", tc.path, err)
+        }
+        if count != tc.wantCount {
+            t.Errorf("EntryCount(%s) = %d, want %d", tc.path, count, tc.wantCount)
+        }
+        
+        empty, err := localFS.IsEmpty(ctx, tc.path)
+        if err != nil {
+            t.Fatalf("IsEmpty(%s) failed: %v", tc.path, err)
+        }
+        if empty != (tc.wantCount == 0) {
+            t.Errorf("IsEmpty(%s) = %v, want %v", tc.path, empty, tc.wantCount == 0)
+        }
+    }
+    
+    // The staging area for unnamed files is not counted
+    if _, _, err := localFS.CreateUnnamed(ctx, "/", fs.FileAttr{}); err != nil {
+        t.Fatalf("CreateUnnamed failed: %v", err)
+    }
+    if count, err := localFS.EntryCount(ctx, "/"); err != nil || count != 2 {
+        t.Errorf("EntryCount(/) with staged file = %d, %v; want 2", count, err)
+    }
+    
+    // Files are not directories
+    if _, err := localFS.EntryCount(ctx, "/full-dir/a"); !errors.Is(err, fs.ErrNotDir) {
+        t.Errorf("EntryCount should fail with NotDir error for file, got: %v", err)
+    }
+    if _, err := localFS.IsEmpty(ctx, "/missing"); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("IsEmpty should fail with NotExist error for missing path, got: %v", err)
+    }
+}
+
 // TestSetAttr tests the SetAttr method
 func TestSetAttr(t *testing.T) {
     localFS, tempDir, cleanup := setupTestFS(t)
@@ -826,6 +1044,18 @@ func TestSetAttr(t *testing.T) {
         t.Errorf("Mode not set correctly: got %o, want %o", info.Mode&0777, mode)
     }
     
+    // Setuid, setgid and sticky bits are kept
+    mode = fs.ModeSetUID | fs.ModeSetGID | fs.ModeSticky | 0750
+    info, err = localFS.SetAttr(context.Background(), relPath, fs.FileAttr{
+        Mode: &mode,
+    })
+    if err != nil {
+        t.Fatalf("SetAttr failed for special bits: %v", err)
+    }
+    if info.Mode != mode {
+        t.Errorf("Special bits not set correctly: got %o, want %o", info.Mode, mode)
+    }
+    
     // Test truncating file
     size := int64(5)
     info, err = localFS.SetAttr(context.Background(), relPath, fs.FileAttr{
@@ -856,6 +1086,54 @@ func TestSetAttr(t *testing.T) {
     }
 }
 
+// TestSetAttrTimesOnly tests that a times-only SetAttr changes nothing else
+func TestSetAttrTimesOnly(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    
+    testFile := createTestFile(t, tempDir, "times.txt", "test content")
+    atime := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+    mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+    if err := os.Chtimes(testFile, atime, atime); err != nil {
+        t.Fatalf("Failed to set initial times: %v", err)
+    }
+    
+    // Setting only mtime leaves atime alone
+    info, err := localFS.SetAttr(context.Background(), "/times.txt", fs.FileAttr{
+        ModifyTime: &mtime,
+    })
+    if err != nil {
+        t.Fatalf("SetAttr failed for mtime: %v", err)
+    }
+    
+    if !info.ModifyTime.Equal(mtime) {
+        t.Errorf("Mtime not set correctly: got %v, want %v", info.ModifyTime, mtime)
+    }
+    if !info.AccessTime.Equal(atime) {
+        t.Errorf("Atime changed: got %v, want %v", info.AccessTime, atime)
+    }
+    if info.Size != int64(len("test content")) || info.Mode&0777 != 0644 {
+        t.Errorf("Times-only SetAttr changed size or mode: size %d, mode %o", info.Size, info.Mode&0777)
+    }
+    
+    // And the other way round
+    info, err = localFS.SetAttr(context.Background(), "/times.txt", fs.FileAttr{
+        AccessTime: &mtime,
+    })
+    if err != nil {
+        t.Fatalf("SetAttr failed for atime: %v", err)
+    }
+    if !info.AccessTime.Equal(mtime) || !info.ModifyTime.Equal(mtime) {
+        t.Errorf("Unexpected times: atime %v, mtime %v", info.AccessTime, info.ModifyTime)
+    }
+    
+    // Missing files are still reported
+    _, err = localFS.SetAttr(context.Background(), "/nonexistent.txt", fs.FileAttr{ModifyTime: &mtime})
+    if !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("SetAttr should fail with NotExist error, got: %v", err)
+    }
+}
+
 // TestCreate tests the Create method
 func TestCreate(t *testing.T) {
     localFS, tempDir, cleanup := setupTestFS(t)
@@ -943,7 +1221,7 @@ func TestRename(t *testing.T) {
     targetPath := "/renamed.txt"
     
     // Test renaming file
-    err := localFS.Rename(context.Background(), sourcePath, targetPath)
+    err := localFS.Rename(context.Background(), sourcePath, targetPath, 0)
     if err != nil {
         t.Fatalf("Rename failed: %v", err)
     }
@@ -960,18 +1238,81 @@ func TestRename(t *testing.T) {
     }
     
     // Test renaming non-existent source
-    err = localFS.Rename(context.Background(), "/nonexistent.txt", "/something.txt")
+    err = localFS.Rename(context.Background(), "/nonexistent.txt", "/something.txt", 0)
     if err == nil {
         t.Error("Rename should fail with non-existent source")
     }
     
     // Test renaming to non-existent parent directory
-    err = localFS.Rename(context.Background(), targetPath, "/nonexistent/file.txt")
+    err = localFS.Rename(context.Background(), targetPath, "/nonexistent/file.txt", 0)
     if err == nil {
         t.Error("Rename should fail with non-existent target parent directory")
     }
 }
 
+// TestRenameFlags tests Rename with renameat2 flags
+func TestRenameFlags(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    ctx := context.Background()
+    
+    createTestFile(t, tempDir, "a.txt", "a")
+    createTestFile(t, tempDir, "b.txt", "b")
+    content := func(name string) string {
+        data, _ := os.ReadFile(filepath.Join(tempDir, name))
+        return string(data)
+    }
+    
+    // No-replace refuses an existing target and renames onto a new one
+    err := localFS.Rename(ctx, "/a.txt", "/b.txt", fs.RenameNoReplace)
+    if !errors.Is(err, fs.ErrExist) || content("b.txt") != "b" {
+        t.Errorf("No-replace rename onto an existing file returned %v, target holds %q", err, content("b.txt"))
+    }
+    if err := localFS.Rename(ctx, "/a.txt", "/c.txt", fs.RenameNoReplace); err != nil || content("c.txt") != "a" {
+        t.Errorf("No-replace rename onto a new name returned %v", err)
+    }
+    
+    // Exchange swaps the files, and their handles follow them
+    handle, err := localFS.PathToFileHandle(ctx, "/c.txt")
+    if err != nil {
+        t.Fatalf("PathToFileHandle failed: %v", err)
+    }
+    if err := localFS.Rename(ctx, "/c.txt", "/b.txt", fs.RenameExchange); err != nil {
+        t.Fatalf("Exchange failed: %v", err)
+    }
+    if content("b.txt") != "a" || content("c.txt") != "b" {
+        t.Errorf("Exchange left b.txt %q and c.txt %q", content("b.txt"), content("c.txt"))
+    }
+    if path, err := localFS.FileHandleToPath(ctx, handle); err != nil || path != "/b.txt" {
+        t.Errorf("Handle of the exchanged file resolves to %q, %v; want /b.txt", path, err)
+    }
+    
+    // Exchanged directories take their contents with them
+    for _, dir := range []string{"x", "y"} {
+        if err := os.MkdirAll(filepath.Join(tempDir, dir, "in-"+dir), 0755); err != nil {
+            t.Fatalf("Failed to create directory: %v", err)
+        }
+    }
+    inner, err := localFS.PathToFileHandle(ctx, "/x/in-x")
+    if err != nil {
+        t.Fatalf("PathToFileHandle failed: %v", err)
+    }
+    if err := localFS.Rename(ctx, "/x", "/y", fs.RenameExchange); err != nil {
+        t.Fatalf("Exchange of directories failed: %v", err)
+    }
+    if path, err := localFS.FileHandleToPath(ctx, inner); err != nil || path != "/y/in-x" {
+        t.Errorf("Handle inside an exchanged directory resolves to %q, %v; want /y/in-x", path, err)
+    }
+    
+    // Exchange needs both files, and the flags exclude each other
+    if err := localFS.Rename(ctx, "/b.txt", "/missing", fs.RenameExchange); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("Exchange with a missing target returned %v, want ErrNotExist", err)
+    }
+    if err := localFS.Rename(ctx, "/b.txt", "/c.txt", fs.RenameExchange|fs.RenameNoReplace); !errors.Is(err, fs.ErrInvalidName) {
+        t.Errorf("Rename with both flags returned %v, want ErrInvalidName", err)
+    }
+}
+
 // TestAccess tests the Access method
 func TestAccess(t *testing.T) {
     localFS, tempDir, cleanup := setupTestFS(t)
@@ -990,9 +1331,9 @@ func TestAccess(t *testing.T) {
         t.Fatalf("Failed to stat test file: %v", err)
     }
     
-    sysstat, ok := stat.Sys().(*syscall.Stat_t)
+    sysstat, ok := statOf(testFile, stat)
     if !ok {
-        t.Skip("Unable to get syscall.Stat_t, skipping test")
+        t.Skip("Unable to get the file's owner, skipping test")
     }
     
     // Define test credentials
@@ -1025,4 +1366,70 @@ func TestAccess(t *testing.T) {
     if err == nil {
         t.Error("Access should fail for non-existent file")
     }
-}
\ No newline at end of file
+}
+// TestCheck tests that Check finds and repairs index divergences
+func TestCheck(t *testing.T) {
+    localFS, tempDir, cleanup := setupTestFS(t)
+    defer cleanup()
+    
+    ctx := context.Background()
+    createTestFile(t, tempDir, "kept.txt", "kept")
+    createTestFile(t, tempDir, "deleted.txt", "deleted")
+    createTestFile(t, tempDir, "moved.txt", "moved")
+    createTestDir(t, tempDir, "dir")
+    
+    // Index the files
+    for _, path := range []string{"/kept.txt", "/deleted.txt", "/moved.txt"} {
+        if _, err := localFS.GetAttr(ctx, path); err != nil {
+            t.Fatalf("GetAttr(%s) failed: %v", path, err)
+        }
+    }
+    
+    // An unnamed file abandoned long ago, created before anything is deleted
+    // so that it cannot reuse a stale inode
+    orphan, _, err := localFS.CreateUnnamed(ctx, "/", fs.FileAttr{})
+    if err != nil {
+        t.Fatalf("CreateUnnamed failed: %v", err)
+    }
+    old := time.Now().Add(-2 * stagingOrphanAge)
+    os.Chtimes(filepath.Join(tempDir, orphan), old, old)
+    
+    // Change the tree behind the server's back
+    os.Remove(filepath.Join(tempDir, "deleted.txt"))
+    os.Rename(filepath.Join(tempDir, "moved.txt"), filepath.Join(tempDir, "dir", "moved.txt"))
+    
+    report, err := localFS.Check(ctx, false)
+    if err != nil {
+        t.Fatalf("Check failed: %v", err)
+    }
+    if report.Stale != 1 || report.Moved != 1 || len(report.Orphans) != 1 || report.Repaired {
+        t.Errorf("Unexpected report: %+v", report)
+    }
+    if len(report.Orphans) == 1 && report.Orphans[0] != orphan {
+        t.Errorf("Wrong orphan: got %s, want %s", report.Orphans[0], orphan)
+    }
+    
+    // Repair, after which a second check finds nothing
+    if _, err := localFS.Check(ctx, true); err != nil {
+        t.Fatalf("Check with repair failed: %v", err)
+    }
+    report, err = localFS.Check(ctx, false)
+    if err != nil {
+        t.Fatalf("Check failed: %v", err)
+    }
+    if report.Stale != 0 || report.Moved != 0 || report.Added != 0 || len(report.Orphans) != 0 {
+        t.Errorf("Divergences remain after repair: %+v", report)
+    }
+    if _, err := os.Stat(filepath.Join(tempDir, orphan)); !os.IsNotExist(err) {
+        t.Errorf("Orphan not removed: %v", err)
+    }
+    
+    // The moved file resolves to its new path
+    inode, err := localFS.getInode("/dir/moved.txt")
+    if err != nil {
+        t.Fatalf("getInode failed: %v", err)
+    }
+    if path, ok := localFS.inodeMap.Load(inode); !ok || path != "/dir/moved.txt" {
+        t.Errorf("Index not repaired for moved file: %v", path)
+    }
+}
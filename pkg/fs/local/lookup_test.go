@@ -1,58 +1,91 @@
 package local
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
-	"syscall"
+
+	"github.com/example/nfsserver/pkg/fs"
 )
 
-func TestFindPathByInode(t *testing.T) {
-	// Create temporary directory
-	tempDir, err := os.MkdirTemp("", "nfs-test-")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
+func TestBuildIndex(t *testing.T) {
+	tempDir := t.TempDir()
 
 	// Create test filesystem
-	fs, err := NewLocalFileSystem(tempDir)
+	localFS, err := NewLocalFileSystem(tempDir)
 	if err != nil {
 		t.Fatalf("Failed to create filesystem: %v", err)
 	}
 
-	// Create test file
-	testFilePath := filepath.Join(tempDir, "testfile.txt")
+	// Create a test file behind the server's back
+	testFilePath := filepath.Join(tempDir, "dir", "testfile.txt")
+	if err := os.MkdirAll(filepath.Dir(testFilePath), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
 	if err := os.WriteFile(testFilePath, []byte("test content"), 0644); err != nil {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
+	handleOf := func(path string) []byte {
+		fileInfo, err := os.Lstat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		stat, _ := statOf(path, fileInfo)
+		handle := &fs.FileHandle{
+			FileSystemID: localFS.fsID,
+			Inode:        stat.Ino,
+			Generation:   1,
+		}
+		return handle.Serialize()
+	}
 
-	// Get inode of test file
-	fileInfo, err := os.Stat(testFilePath)
-	if err != nil {
-		t.Fatalf("Failed to stat test file: %v", err)
+	// The first unknown handle indexes the whole tree
+	path, err := localFS.FileHandleToPath(context.Background(), handleOf(testFilePath))
+	if err != nil || path != "/dir/testfile.txt" {
+		t.Fatalf("FileHandleToPath returned %q, %v; want /dir/testfile.txt", path, err)
 	}
-	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
-	if !ok {
-		t.Skip("Could not get syscall.Stat_t from FileInfo")
+	if !localFS.indexed.Load() || localFS.inodeMap.Len() != 3 {
+		t.Errorf("Index has %d entries, complete %v; want the 3 files, complete", localFS.inodeMap.Len(), localFS.indexed.Load())
 	}
-	inode := stat.Ino
 
-	// Test findPathByInode
-	path, err := fs.findPathByInode(inode)
+	// Later misses are stale without walking the tree again
+	other := filepath.Join(tempDir, "other")
+	if err := os.WriteFile(other, nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := localFS.FileHandleToPath(context.Background(), handleOf(other)); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("FileHandleToPath of an unindexed file returned %v, want ErrStale", err)
+	}
+}
+
+func TestFileHandleToPathDeadline(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fs, err := NewLocalFileSystem(tempDir)
 	if err != nil {
-		t.Fatalf("findPathByInode failed: %v", err)
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	handle, err := fs.PathToFileHandle(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
 	}
 
-	// Check result
-	expectedPath := "/testfile.txt"
-	if path != expectedPath {
-		t.Errorf("Path mismatch: got %q, want %q", path, expectedPath)
+	// Forget the mapping so the handle needs the index built to resolve
+	fs.inodeMap = inodeIndex{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fs.FileHandleToPath(ctx, handle); !errors.Is(err, context.Canceled) {
+		t.Errorf("FileHandleToPath with a done context returned %v, want context.Canceled", err)
 	}
 
-	// Test with nonexistent inode
-	_, err = fs.findPathByInode(999999999)
-	if err == nil {
-		t.Error("Expected error for nonexistent inode, got nil")
+	path, err := fs.FileHandleToPath(context.Background(), handle)
+	if err != nil || path != "/file" {
+		t.Errorf("FileHandleToPath returned %q, %v; want /file", path, err)
 	}
-}
\ No newline at end of file
+}
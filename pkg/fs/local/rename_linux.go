@@ -0,0 +1,30 @@
+//go:build linux
+
+package local
+
+import (
+    "os"
+
+    "golang.org/x/sys/unix"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// renameFlags renames oldPath to newPath like os.Rename, passing flags on
+// to renameat2(2)
+func renameFlags(oldPath string, newPath string, flags fs.RenameFlags) error {
+    if flags == 0 {
+        return os.Rename(oldPath, newPath)
+    }
+    var sysFlags uint
+    if flags&fs.RenameNoReplace != 0 {
+        sysFlags |= unix.RENAME_NOREPLACE
+    }
+    if flags&fs.RenameExchange != 0 {
+        sysFlags |= unix.RENAME_EXCHANGE
+    }
+    if err := unix.Renameat2(unix.AT_FDCWD, oldPath, unix.AT_FDCWD, newPath, sysFlags); err != nil {
+        return &os.LinkError{Op: "renameat2", Old: oldPath, New: newPath, Err: err}
+    }
+    return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !windows
+
+package local
+
+import (
+    "os"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// renameFlags renames oldPath to newPath like os.Rename. There is no
+// portable renameat2(2), so renames that must not replace the destination
+// or that exchange two files are refused.
+func renameFlags(oldPath string, newPath string, flags fs.RenameFlags) error {
+    if flags == 0 {
+        return os.Rename(oldPath, newPath)
+    }
+    return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EOPNOTSUPP}
+}
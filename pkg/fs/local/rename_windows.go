@@ -0,0 +1,36 @@
+//go:build windows
+
+package local
+
+import (
+    "os"
+    "syscall"
+
+    "golang.org/x/sys/windows"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// renameFlags renames oldPath to newPath like os.Rename. MoveFileEx
+// without MOVEFILE_REPLACE_EXISTING refuses to replace the destination,
+// atomically like RENAME_NOREPLACE; Windows cannot exchange two files.
+func renameFlags(oldPath string, newPath string, flags fs.RenameFlags) error {
+    if flags == 0 {
+        return os.Rename(oldPath, newPath)
+    }
+    if flags&fs.RenameExchange != 0 {
+        return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EWINDOWS}
+    }
+    from, err := windows.UTF16PtrFromString(oldPath)
+    if err != nil {
+        return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+    }
+    to, err := windows.UTF16PtrFromString(newPath)
+    if err != nil {
+        return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+    }
+    if err := windows.MoveFileEx(from, to, 0); err != nil {
+        return &os.LinkError{Op: "MoveFileEx", Old: oldPath, New: newPath, Err: err}
+    }
+    return nil
+}
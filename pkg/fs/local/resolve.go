@@ -0,0 +1,137 @@
+package local
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// maxSymlinks bounds the symbolic links followed resolving one path, as
+// the kernel's own limit does
+const maxSymlinks = 40
+
+// joinPath converts a path relative to the filesystem to an absolute OS
+// path, lexically: components are not looked at, so one that is a symbolic
+// link may still lead elsewhere. resolvePath and resolveFollow check them.
+func (l *LocalFileSystem) joinPath(path string) (string, error) {
+    // Remove leading slash if present for consistency
+    path = strings.TrimPrefix(path, "/")
+
+    // Clean the path to remove any '..' components
+    cleanPath := filepath.Clean(path)
+
+    // Join with the root path; snapshots are kept in the staging area
+    fullPath := filepath.Join(l.rootPath, cleanPath)
+    if cleanPath == snapshotsName || strings.HasPrefix(cleanPath, snapshotsName+string(filepath.Separator)) {
+        fullPath = filepath.Join(l.snapshotsPath(), cleanPath[len(snapshotsName):])
+    }
+
+    // Verify the path is still under the root path (prevent directory traversal)
+    if !l.within(fullPath) {
+        return "", fs.ErrInvalidName
+    }
+
+    return fullPath, nil
+}
+
+// within reports whether fullPath is the root or lexically below it
+func (l *LocalFileSystem) within(fullPath string) bool {
+    return fullPath == l.rootPath || strings.HasPrefix(fullPath, strings.TrimSuffix(l.rootPath, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// resolvePath converts a path relative to the filesystem to an absolute OS
+// path naming the same file without leaving the export. Symbolic links
+// among the directories on the way are followed where they stay inside
+// the root; the final component is left as it is, for operations on links
+// themselves.
+func (l *LocalFileSystem) resolvePath(path string) (string, error) {
+    fullPath, err := l.joinPath(path)
+    if err != nil {
+        return "", err
+    }
+    return l.beneath(fullPath, false)
+}
+
+// resolveFollow is resolvePath for operations that go through a final
+// symbolic link to the file it names, which must be inside the root too
+func (l *LocalFileSystem) resolveFollow(path string) (string, error) {
+    fullPath, err := l.joinPath(path)
+    if err != nil {
+        return "", err
+    }
+    return l.beneath(fullPath, true)
+}
+
+// beneath resolves fullPath, which joinPath returned, one component at a
+// time from the root. A symbolic link is replaced by its target, which
+// must be relative and must not climb above the root; one that leaves the
+// export fails with ErrPermission, as a client has no business with what
+// is outside it. The final component is only resolved if follow is set.
+// Components that do not exist end the walk: the rest is appended as it
+// is, so operations creating them see the name they asked for.
+func (l *LocalFileSystem) beneath(fullPath string, follow bool) (string, error) {
+    rel, err := filepath.Rel(l.rootPath, fullPath)
+    if err != nil || rel == "." {
+        return fullPath, err
+    }
+    pending := strings.Split(rel, string(filepath.Separator))
+    resolved := l.rootPath
+    links := 0
+    for len(pending) > 0 {
+        name := pending[0]
+        pending = pending[1:]
+        switch name {
+        case "", ".":
+            continue
+        case "..":
+            if resolved == l.rootPath {
+                return "", fs.ErrPermission
+            }
+            resolved = filepath.Dir(resolved)
+            continue
+        }
+        next := filepath.Join(resolved, name)
+        if len(pending) == 0 && !follow {
+            return next, nil
+        }
+        info, err := os.Lstat(next)
+        if os.IsNotExist(err) {
+            rest := filepath.Join(append([]string{next}, pending...)...)
+            if !l.within(rest) {
+                return "", fs.ErrPermission
+            }
+            return rest, nil
+        } else if err != nil {
+            return "", err
+        }
+        if info.Mode()&os.ModeSymlink == 0 {
+            resolved = next
+            continue
+        }
+        if links++; links > maxSymlinks {
+            return "", &os.PathError{Op: "resolve", Path: fullPath, Err: syscall.ELOOP}
+        }
+        target, err := os.Readlink(next)
+        if err != nil {
+            return "", err
+        }
+        if target == "" || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+            return "", fs.ErrPermission
+        }
+        pending = append(strings.Split(filepath.Clean(target), string(filepath.Separator)), pending...)
+    }
+    return resolved, nil
+}
+
+// openFile opens fullPath, which resolveFollow returned. Where the kernel
+// can confine the lookup itself, a component swapped for a symbolic link
+// since the path was resolved still cannot lead out of the export.
+func (l *LocalFileSystem) openFile(fullPath string, flag int, perm os.FileMode) (*os.File, error) {
+    if file, ok, err := l.openBeneath(fullPath, flag, perm); ok {
+        return file, err
+    }
+    return os.OpenFile(fullPath, flag, perm)
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package local
+
+import (
+    "os"
+    "path/filepath"
+    "sync/atomic"
+
+    "golang.org/x/sys/unix"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// noOpenat2 is set once openat2(2) is found missing, before Linux 5.6
+var noOpenat2 atomic.Bool
+
+// openBeneath opens fullPath, below the root, with openat2(2) and
+// RESOLVE_BENEATH, so the kernel itself refuses any component, symbolic
+// links included, that would lead out of the export. It reports false
+// where openat2 cannot be used, leaving the open to the caller.
+func (l *LocalFileSystem) openBeneath(fullPath string, flag int, perm os.FileMode) (*os.File, bool, error) {
+    if noOpenat2.Load() {
+        return nil, false, nil
+    }
+    rel, err := filepath.Rel(l.rootPath, fullPath)
+    if err != nil {
+        return nil, false, nil
+    }
+    root, err := unix.Open(l.rootPath, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+    if err != nil {
+        return nil, true, &os.PathError{Op: "open", Path: l.rootPath, Err: err}
+    }
+    defer unix.Close(root)
+    
+    how := unix.OpenHow{
+        Flags:   uint64(flag | unix.O_CLOEXEC),
+        Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+    }
+    if flag&(unix.O_CREAT|unix.O_TMPFILE) != 0 {
+        how.Mode = uint64(modeBits(perm))
+    }
+    fd, err := unix.Openat2(root, rel, &how)
+    switch err {
+    case nil:
+        return os.NewFile(uintptr(fd), fullPath), true, nil
+    case unix.ENOSYS:
+        noOpenat2.Store(true)
+        return nil, false, nil
+    case unix.EAGAIN:
+        // A rename raced the lookup; the caller's checked open is as good
+        return nil, false, nil
+    case unix.EXDEV:
+        return nil, true, fs.ErrPermission
+    }
+    return nil, true, &os.PathError{Op: "openat2", Path: fullPath, Err: err}
+}
@@ -0,0 +1,89 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestSymlinkEscape(t *testing.T) {
+	localFS, tempDir, cleanup := setupTestFS(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	createTestDir(t, tempDir, "dir")
+	createTestFile(t, tempDir, "dir/file", "inside")
+	rel, err := filepath.Rel(filepath.Join(tempDir, "dir"), outside)
+	if err != nil {
+		t.Fatalf("Rel failed: %v", err)
+	}
+	// Links made on the server side, which Symlink would have refused
+	for name, target := range map[string]string{
+		"abs":      outside,
+		"abs-file": secret,
+		"dir/up":   rel,
+		"loop":     "loop",
+		"in":       "dir",
+		"dir/back": "../dir/file",
+	} {
+		if err := os.Symlink(target, filepath.Join(tempDir, name)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	// Links leading out of the export are not followed, whether they are
+	// directories on the way or the file itself
+	for _, path := range []string{"/abs/secret", "/abs-file", "/dir/up/secret"} {
+		if _, _, err := localFS.Read(ctx, path, 0, 100); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("Read of %s = %v, want ErrPermission", path, err)
+		}
+	}
+	if _, _, err := localFS.Lookup(ctx, "/abs", "secret"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Lookup through a link out = %v, want ErrPermission", err)
+	}
+	if _, _, err := localFS.ReadDir(ctx, "/dir/up", 0, 100); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("ReadDir through a link out = %v, want ErrPermission", err)
+	}
+	if _, _, err := localFS.Create(ctx, "/abs", "new", fs.FileAttr{}, true); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Create through a link out = %v, want ErrPermission", err)
+	}
+	if _, _, err := localFS.Create(ctx, "/", "abs-file", fs.FileAttr{}, false); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Create over a link out = %v, want ErrPermission", err)
+	}
+	size := int64(0)
+	if _, err := localFS.SetAttr(ctx, "/abs-file", fs.FileAttr{Size: &size}); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("SetAttr through a link out = %v, want ErrPermission", err)
+	}
+	if data, err := os.ReadFile(secret); err != nil || string(data) != "secret" {
+		t.Errorf("File outside the export = %q, %v; want it untouched", data, err)
+	}
+	if _, _, err := localFS.Read(ctx, "/loop", 0, 100); err == nil {
+		t.Error("Read of a link loop succeeded")
+	}
+
+	// The links themselves are still files of the export, and links that
+	// stay inside it are followed
+	if target, err := localFS.Readlink(ctx, "/abs-file"); err != nil || target != secret {
+		t.Errorf("Readlink = %q, %v; want %q", target, err, secret)
+	}
+	if _, err := localFS.GetAttr(ctx, "/abs"); err != nil {
+		t.Errorf("GetAttr of a link out failed: %v", err)
+	}
+	for _, path := range []string{"/in/file", "/dir/back", "/in/back"} {
+		if data, _, err := localFS.Read(ctx, path, 0, 100); err != nil || string(data) != "inside" {
+			t.Errorf("Read of %s = %q, %v; want \"inside\"", path, data, err)
+		}
+	}
+	if _, _, err := localFS.Create(ctx, "/in", "new", fs.FileAttr{}, true); err != nil {
+		t.Errorf("Create through a link inside failed: %v", err)
+	}
+}
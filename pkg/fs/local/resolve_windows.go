@@ -0,0 +1,13 @@
+//go:build windows
+
+package local
+
+import (
+    "os"
+)
+
+// openBeneath reports false: Windows cannot confine a lookup to a
+// directory, so files are opened by the paths resolvePath checked
+func (l *LocalFileSystem) openBeneath(fullPath string, flag int, perm os.FileMode) (*os.File, bool, error) {
+    return nil, false, nil
+}
@@ -0,0 +1,519 @@
+package local
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// snapshotsName is the pseudo-directory under the export root that
+// snapshots are reached through. It is left out of the root's listing, and
+// hides a real entry by that name.
+const snapshotsName = ".snapshots"
+
+// snapshotsDirName is the directory in the staging area holding one tree
+// per snapshot. Being on the same file system as the export lets their
+// files share data with the live tree through hard links or reflinks.
+const snapshotsDirName = "snapshots"
+
+// snapshotGeneration is the generation in handles of files in snapshots,
+// which no live file has; their paths follow it
+const snapshotGeneration = 0
+
+// isSnapshotPath reports whether path refers to /.snapshots or lies inside it
+func isSnapshotPath(path string) bool {
+    clean := filepath.ToSlash(filepath.Clean("/" + path))
+    return clean == "/"+snapshotsName || strings.HasPrefix(clean, "/"+snapshotsName+"/")
+}
+
+// readOnly returns the error of op changing paths if any is in a snapshot
+func readOnly(op string, paths ...string) error {
+    for _, path := range paths {
+        if isSnapshotPath(path) {
+            return fs.NewError(op, path, fs.ErrReadOnly)
+        }
+    }
+    return nil
+}
+
+// validSnapshotName reports whether name can name a snapshot's directory
+func validSnapshotName(name string) bool {
+    return name != "" && name != "." && name != ".." && len(name) <= 255 && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// snapshotsPath returns the OS path of the directory holding the snapshots
+func (l *LocalFileSystem) snapshotsPath() string {
+    return filepath.Join(l.rootPath, stagingDirName, snapshotsDirName)
+}
+
+// stagingTemp returns an unused OS path in the staging area
+func (l *LocalFileSystem) stagingTemp() (string, error) {
+    stagingPath := filepath.Join(l.rootPath, stagingDirName)
+    if err := os.MkdirAll(stagingPath, 0700); err != nil {
+        return "", err
+    }
+    suffix := make([]byte, 12)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", fs.ErrIO
+    }
+    return filepath.Join(stagingPath, hex.EncodeToString(suffix)), nil
+}
+
+// Snapshot copies the live tree into a new snapshot called name, reached
+// at /.snapshots/name. Directories and symbolic links are copied. Regular
+// files are cloned where the file system has reflinks, and hard-linked
+// otherwise; a live file sharing its inode with snapshots is copied for
+// them before it is next written or has its attributes set. Writes wait
+// for the snapshot, but names may move while the tree is walked. The tree
+// is built in the staging area and renamed into place, so a snapshot that
+// fails leaves nothing behind.
+func (l *LocalFileSystem) Snapshot(ctx context.Context, name string) (fs.SnapshotInfo, error) {
+    if !validSnapshotName(name) {
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, fs.ErrInvalidName)
+    }
+
+    l.snapshotMu.Lock()
+    defer l.snapshotMu.Unlock()
+
+    target := filepath.Join(l.snapshotsPath(), name)
+    if _, err := os.Lstat(target); err == nil {
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, fs.ErrExist)
+    }
+    if err := os.MkdirAll(l.snapshotsPath(), 0755); err != nil {
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, mapOSError(err))
+    }
+    building, err := l.stagingTemp()
+    if err != nil {
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, mapOSError(err))
+    }
+
+    info, linked, err := l.copyTree(ctx, building)
+    if err != nil {
+        removeTree(building)
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, mapOSError(err))
+    }
+    if err := os.Rename(building, target); err != nil {
+        removeTree(building)
+        return fs.SnapshotInfo{}, fs.NewError("Snapshot", name, mapOSError(err))
+    }
+
+    // The live files it links to must be copied before they change
+    l.snapshotLinkMu.Lock()
+    if l.snapshotLinks != nil {
+        for inode, rels := range linked {
+            for _, rel := range rels {
+                l.snapshotLinks[inode] = append(l.snapshotLinks[inode], filepath.Join(target, rel))
+            }
+        }
+    }
+    if len(linked) > 0 {
+        l.shared.Store(true)
+    }
+    l.snapshotLinkMu.Unlock()
+
+    info.Name = name
+    if osInfo, err := l.lstat(target); err == nil {
+        if stat, ok := statOf(target, osInfo); ok {
+            info.CreateTime = stat.Ctime
+        }
+    }
+    return info, nil
+}
+
+// copyTree copies the live tree to the OS path dst for a snapshot. It
+// returns the snapshot's description and the paths, relative to dst, of
+// the files it hard-linked, by inode.
+func (l *LocalFileSystem) copyTree(ctx context.Context, dst string) (fs.SnapshotInfo, map[uint64][]string, error) {
+    info := fs.SnapshotInfo{Cloned: true}
+    linked := make(map[uint64][]string)
+    clones := make(map[uint64]string)
+    clone := true
+
+    // Directories are filled before they get their modes and times
+    type copiedDir struct {
+        path   string
+        source string
+        info   os.FileInfo
+    }
+    var dirs []copiedDir
+
+    err := filepath.WalkDir(l.rootPath, func(fullPath string, d os.DirEntry, err error) error {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return ctxErr
+        }
+        if os.IsNotExist(err) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(l.rootPath, fullPath)
+        if err != nil {
+            return err
+        }
+        if rel == stagingDirName {
+            return filepath.SkipDir
+        }
+
+        // Entries removed since their directory was read are left out
+        osInfo, err := l.lstat(fullPath)
+        if os.IsNotExist(err) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        stat, ok := statOf(fullPath, osInfo)
+        if !ok {
+            return fs.ErrIO
+        }
+        target := filepath.Join(dst, rel)
+        mode := osInfo.Mode()
+
+        switch {
+        case mode.IsDir():
+            if err := os.Mkdir(target, 0700); err != nil {
+                return err
+            }
+            dirs = append(dirs, copiedDir{target, fullPath, osInfo})
+        case mode&os.ModeSymlink != 0:
+            link, err := os.Readlink(fullPath)
+            if err != nil {
+                return err
+            }
+            if err := os.Symlink(link, target); err != nil {
+                return err
+            }
+        case mode.IsRegular() && clone && clones[stat.Ino] != "":
+            // Names of one file stay one file
+            if err := os.Link(clones[stat.Ino], target); err != nil {
+                return err
+            }
+        case mode.IsRegular() && clone:
+            if err := cloneFile(fullPath, target, 0600); err == nil {
+                if err := copyAttributes(target, osInfo, stat); err != nil {
+                    return err
+                }
+                copyACL(fullPath, target)
+                if stat.Nlink > 1 {
+                    clones[stat.Ino] = target
+                }
+                break
+            }
+            // Reflinks are not supported here; share the rest by linking
+            clone = false
+            fallthrough
+        default:
+            if err := os.Link(fullPath, target); os.IsNotExist(err) {
+                return nil
+            } else if err != nil {
+                return err
+            }
+            if mode.IsRegular() {
+                info.Cloned = false
+            }
+            linked[stat.Ino] = append(linked[stat.Ino], rel)
+        }
+
+        // Owners are kept where the server may set them; linked files
+        // share theirs
+        if mode.IsDir() || mode&os.ModeSymlink != 0 {
+            os.Lchown(target, int(stat.Uid), int(stat.Gid))
+        }
+        info.Files++
+        return nil
+    })
+    if err != nil {
+        return fs.SnapshotInfo{}, nil, err
+    }
+
+    for i := len(dirs) - 1; i >= 0; i-- {
+        dir := dirs[i]
+        if err := chmod(dir.path, modeBits(dir.info.Mode())); err != nil {
+            return fs.SnapshotInfo{}, nil, err
+        }
+        copyACL(dir.source, dir.path)
+        atime, mtime := fileTimes(dir.path, dir.info)
+        if err := setFileTimes(dir.path, &atime, &mtime); err != nil {
+            return fs.SnapshotInfo{}, nil, err
+        }
+    }
+    return info, linked, nil
+}
+
+// fileTimes returns the access and modification times described by info
+func fileTimes(fullPath string, info os.FileInfo) (atime, mtime time.Time) {
+    mtime = info.ModTime()
+    atime = mtime
+    if stat, ok := statOf(fullPath, info); ok {
+        atime = stat.Atime
+    }
+    return atime, mtime
+}
+
+// copyAttributes gives the file at fullPath the mode, owner and times of
+// the file described by info and stat
+func copyAttributes(fullPath string, info os.FileInfo, stat *fileStat) error {
+    if err := chmod(fullPath, modeBits(info.Mode())); err != nil {
+        return err
+    }
+    os.Lchown(fullPath, int(stat.Uid), int(stat.Gid))
+    mtime := info.ModTime()
+    return setFileTimes(fullPath, &stat.Atime, &mtime)
+}
+
+// modeBits returns the twelve permission bits of mode as chmod takes them
+func modeBits(mode os.FileMode) uint32 {
+    bits := uint32(mode.Perm())
+    if mode&os.ModeSetuid != 0 {
+        bits |= 04000
+    }
+    if mode&os.ModeSetgid != 0 {
+        bits |= 02000
+    }
+    if mode&os.ModeSticky != 0 {
+        bits |= 01000
+    }
+    return bits
+}
+
+// removeTree removes the tree at fullPath, making its directories writable
+// first, as a snapshot keeps the modes of those it copied
+func removeTree(fullPath string) error {
+    filepath.WalkDir(fullPath, func(path string, d os.DirEntry, err error) error {
+        if err == nil && d.IsDir() {
+            os.Chmod(path, 0700)
+        }
+        return nil
+    })
+    return os.RemoveAll(fullPath)
+}
+
+// ListSnapshots returns the snapshots, oldest first. Only their names and
+// creation times are known without walking them.
+func (l *LocalFileSystem) ListSnapshots(ctx context.Context) ([]fs.SnapshotInfo, error) {
+    entries, err := os.ReadDir(l.snapshotsPath())
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fs.NewError("ListSnapshots", "/"+snapshotsName, mapOSError(err))
+    }
+
+    snapshots := make([]fs.SnapshotInfo, 0, len(entries))
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            continue
+        }
+        snapshot := fs.SnapshotInfo{Name: entry.Name()}
+        fullPath := filepath.Join(l.snapshotsPath(), entry.Name())
+        if info, err := l.lstat(fullPath); err == nil {
+            if stat, ok := statOf(fullPath, info); ok {
+                // Nothing changes a snapshot's root after it is renamed
+                // into place
+                snapshot.CreateTime = stat.Ctime
+            }
+        }
+        snapshots = append(snapshots, snapshot)
+    }
+    sort.SliceStable(snapshots, func(i, j int) bool {
+        return snapshots[i].CreateTime.Before(snapshots[j].CreateTime)
+    })
+    return snapshots, nil
+}
+
+// DeleteSnapshot removes the snapshot called name. It is moved out of
+// /.snapshots at once; if removing its files then fails, the rest are
+// removed from the staging area when the server next starts.
+func (l *LocalFileSystem) DeleteSnapshot(ctx context.Context, name string) error {
+    if !validSnapshotName(name) {
+        return fs.NewError("DeleteSnapshot", name, fs.ErrInvalidName)
+    }
+
+    l.snapshotMu.Lock()
+    defer l.snapshotMu.Unlock()
+
+    target := filepath.Join(l.snapshotsPath(), name)
+    info, err := os.Lstat(target)
+    if err != nil {
+        return fs.NewError("DeleteSnapshot", name, mapOSError(err))
+    }
+    if !info.IsDir() {
+        return fs.NewError("DeleteSnapshot", name, fs.ErrNotExist)
+    }
+    doomed, err := l.stagingTemp()
+    if err != nil {
+        return fs.NewError("DeleteSnapshot", name, mapOSError(err))
+    }
+    if err := os.Rename(target, doomed); err != nil {
+        return fs.NewError("DeleteSnapshot", name, mapOSError(err))
+    }
+
+    // The links left are found again when next needed
+    l.snapshotLinkMu.Lock()
+    l.snapshotLinks = nil
+    l.shared.Store(true)
+    l.snapshotLinkMu.Unlock()
+
+    if err := removeTree(doomed); err != nil {
+        return fs.NewError("DeleteSnapshot", name, mapOSError(err))
+    }
+    return nil
+}
+
+// sharedLinks returns the OS paths in snapshots of the live files they
+// share, by inode, walking the snapshots on first use. snapshotLinkMu must
+// be held.
+func (l *LocalFileSystem) sharedLinks() map[uint64][]string {
+    if l.snapshotLinks != nil {
+        return l.snapshotLinks
+    }
+    links := make(map[uint64][]string)
+    filepath.WalkDir(l.snapshotsPath(), func(fullPath string, d os.DirEntry, err error) error {
+        if err != nil || d.IsDir() || d.Type()&os.ModeSymlink != 0 {
+            return nil
+        }
+        info, err := l.lstat(fullPath)
+        if err != nil {
+            return nil
+        }
+        if stat, ok := statOf(fullPath, info); ok && stat.Nlink > 1 {
+            links[stat.Ino] = append(links[stat.Ino], fullPath)
+        }
+        return nil
+    })
+    l.snapshotLinks = links
+    return links
+}
+
+// unshare gives the snapshots a copy of their own of the live file at
+// fullPath, if they share its inode, before the file is changed in place.
+// The live file keeps its inode, so its handles stay valid. Callers hold
+// snapshotMu shared.
+func (l *LocalFileSystem) unshare(fullPath string) error {
+    if !l.shared.Load() {
+        return nil
+    }
+    info, err := os.Stat(fullPath)
+    if err != nil {
+        return nil // The change itself fails
+    }
+    stat, ok := statOf(fullPath, info)
+    if !ok || info.IsDir() || stat.Nlink <= 1 {
+        return nil
+    }
+
+    l.snapshotLinkMu.Lock()
+    defer l.snapshotLinkMu.Unlock()
+    links := l.sharedLinks()
+    if len(links) == 0 {
+        l.shared.Store(false)
+    }
+    paths := links[stat.Ino]
+    if len(paths) == 0 {
+        return nil
+    }
+
+    copyPath, err := l.stagingTemp()
+    if err != nil {
+        return err
+    }
+    defer os.Remove(copyPath)
+    if err := copyFile(fullPath, copyPath, info, stat); err != nil {
+        return err
+    }
+
+    // Each link is replaced atomically, so readers of a snapshot see the
+    // shared file or the copy
+    for _, path := range paths {
+        linkPath, err := l.stagingTemp()
+        if err != nil {
+            return err
+        }
+        if err := os.Link(copyPath, linkPath); err != nil {
+            return err
+        }
+        if err := withWritableDir(filepath.Dir(path), func() error { return os.Rename(linkPath, path) }); err != nil {
+            os.Remove(linkPath)
+            return err
+        }
+    }
+    delete(links, stat.Ino)
+    return nil
+}
+
+// copyFile copies the regular file at src, described by info and stat, to
+// a new file at dst with the same attributes, cloning it if it can
+func copyFile(src string, dst string, info os.FileInfo, stat *fileStat) error {
+    if err := cloneFile(src, dst, 0600); err != nil {
+        in, err := os.Open(src)
+        if err != nil {
+            return err
+        }
+        defer in.Close()
+        out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+        if err != nil {
+            return err
+        }
+        if _, err := io.Copy(out, in); err != nil {
+            out.Close()
+            return err
+        }
+        if err := out.Close(); err != nil {
+            return err
+        }
+    }
+    if err := copyAttributes(dst, info, stat); err != nil {
+        return err
+    }
+    copyACL(src, dst)
+    return nil
+}
+
+// withWritableDir calls fn with the directory at fullPath writable by its
+// owner, restoring its mode afterwards. Snapshots keep the modes of the
+// directories they copied, which need not let the server change them.
+func withWritableDir(fullPath string, fn func() error) error {
+    info, err := os.Stat(fullPath)
+    if err != nil || info.Mode().Perm()&0200 != 0 {
+        return fn()
+    }
+    if err := chmod(fullPath, modeBits(info.Mode())|0200); err != nil {
+        return err
+    }
+    defer chmod(fullPath, modeBits(info.Mode()))
+    return fn()
+}
+
+// snapshotHandle returns the handle of the file with inode at path in a
+// snapshot: the inode, which the file may share with the live tree,
+// followed by the path, which tells them apart. Snapshots never change, so
+// the handle resolves until the snapshot is deleted.
+func (l *LocalFileSystem) snapshotHandle(path string, inode uint64) []byte {
+    handle := &fs.FileHandle{
+        FileSystemID: l.fsID,
+        Inode:        inode,
+        Generation:   snapshotGeneration,
+    }
+    return append(handle.Serialize(), filepath.ToSlash(filepath.Join("/", path))...)
+}
+
+// snapshotFile returns the path of the file in a snapshot that a handle
+// made by snapshotHandle refers to, if it is still there
+func (l *LocalFileSystem) snapshotFile(handle *fs.FileHandle, path string) (string, error) {
+    if !isSnapshotPath(path) || filepath.ToSlash(filepath.Clean(path)) != path {
+        return "", fs.NewError("FileHandleToPath", "", fs.ErrInvalidHandle)
+    }
+    if inode, err := l.getInode(path); err != nil || inode != handle.Inode {
+        return "", fs.NewInodeError("FileHandleToPath", path, handle.Inode, fs.ErrStale)
+    }
+    return path, nil
+}
@@ -0,0 +1,175 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestSnapshot(t *testing.T) {
+	localFS, tempDir, cleanup := setupTestFS(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	createTestDir(t, tempDir, "dir")
+	createTestFile(t, tempDir, "dir/file", "before")
+	if err := os.Symlink("dir/file", filepath.Join(tempDir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	liveHandle, err := localFS.PathToFileHandle(ctx, "/dir/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+
+	info, err := localFS.Snapshot(ctx, "first")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info.Name != "first" || info.Files != 4 || info.CreateTime.IsZero() {
+		t.Errorf("Snapshot = %+v, want first with 4 entries", info)
+	}
+	if _, err := localFS.Snapshot(ctx, "first"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Snapshot of a taken name = %v, want ErrExist", err)
+	}
+	if _, err := localFS.Snapshot(ctx, "a/b"); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Snapshot of a path = %v, want ErrInvalidName", err)
+	}
+
+	// The live file changes without the snapshot, and keeps its handle
+	if _, err := localFS.Write(ctx, "/dir/file", 0, []byte("after!"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	mode := fs.FileMode(0600)
+	if _, err := localFS.SetAttr(ctx, "/dir/file", fs.FileAttr{Mode: &mode}); err != nil {
+		t.Fatalf("SetAttr failed: %v", err)
+	}
+	if data, _, err := localFS.Read(ctx, "/.snapshots/first/dir/file", 0, 100); err != nil || string(data) != "before" {
+		t.Errorf("Read of the snapshot = %q, %v; want \"before\"", data, err)
+	}
+	if attr, err := localFS.GetAttr(ctx, "/.snapshots/first/dir/file"); err != nil || attr.Mode&0777 != 0644 {
+		t.Errorf("Snapshot mode = %o, %v; want 644", attr.Mode, err)
+	}
+	if p, err := localFS.FileHandleToPath(ctx, liveHandle); err != nil || p != "/dir/file" {
+		t.Errorf("FileHandleToPath of the live file = %q, %v; want /dir/file", p, err)
+	}
+	if target, err := localFS.Readlink(ctx, "/.snapshots/first/link"); err != nil || target != "dir/file" {
+		t.Errorf("Readlink in the snapshot = %q, %v", target, err)
+	}
+
+	// Nothing in it can be changed
+	if _, err := localFS.Write(ctx, "/.snapshots/first/dir/file", 0, []byte("x"), false); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Write in a snapshot = %v, want ErrReadOnly", err)
+	}
+	if _, _, err := localFS.Create(ctx, "/.snapshots/first", "new", fs.FileAttr{}, true); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Create in a snapshot = %v, want ErrReadOnly", err)
+	}
+	if err := localFS.Rename(ctx, "/.snapshots/first/dir", "/moved", 0); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Rename out of a snapshot = %v, want ErrReadOnly", err)
+	}
+	if _, _, err := localFS.Link(ctx, "/.snapshots/first/dir/file", "/", "linked"); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Link out of a snapshot = %v, want ErrReadOnly", err)
+	}
+	if _, _, err := localFS.Mkdir(ctx, "/", ".snapshots", fs.FileAttr{}); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Mkdir of /.snapshots = %v, want ErrReadOnly", err)
+	}
+	if err := localFS.Access(ctx, "/.snapshots/first/dir/file", 2, fs.Credentials{}); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Access for writing in a snapshot = %v, want ErrReadOnly", err)
+	}
+
+	// /.snapshots is looked up but not listed
+	if _, _, err := localFS.Lookup(ctx, "/", ".snapshots"); err != nil {
+		t.Errorf("Lookup of /.snapshots failed: %v", err)
+	}
+	entries, _, err := localFS.ReadDir(ctx, "/", 0, 100)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name == ".snapshots" || entry.Name == stagingDirName {
+			t.Errorf("ReadDir of the root lists %s", entry.Name)
+		}
+	}
+	entries, _, err = localFS.ReadDir(ctx, "/.snapshots", 0, 100)
+	if err != nil || len(entries) != 3 || entries[2].Name != "first" {
+		t.Errorf("ReadDir of /.snapshots = %v, %v; want ., .. and first", entries, err)
+	}
+
+	// Files in snapshots have handles of their own, and are not indexed
+	handle, err := localFS.PathToFileHandle(ctx, "/.snapshots/first/dir/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle in a snapshot failed: %v", err)
+	}
+	if p, err := localFS.FileHandleToPath(ctx, handle); err != nil || p != "/.snapshots/first/dir/file" {
+		t.Errorf("FileHandleToPath in a snapshot = %q, %v", p, err)
+	}
+	if report, err := localFS.Check(ctx, false); err != nil || report.Stale+report.Moved != 0 || len(report.Orphans) != 0 {
+		t.Errorf("Check with a snapshot = %+v, %v; want no divergences", report, err)
+	}
+
+	// Snapshots outlive the server
+	reopened, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("NewLocalFileSystem failed: %v", err)
+	}
+	snapshots, err := reopened.ListSnapshots(ctx)
+	if err != nil || len(snapshots) != 1 || snapshots[0].Name != "first" {
+		t.Errorf("ListSnapshots = %+v, %v; want first", snapshots, err)
+	}
+
+	if err := localFS.DeleteSnapshot(ctx, "first"); err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if err := localFS.DeleteSnapshot(ctx, "first"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DeleteSnapshot of a deleted snapshot = %v, want ErrNotExist", err)
+	}
+	if _, err := localFS.FileHandleToPath(ctx, handle); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("FileHandleToPath in a deleted snapshot = %v, want ErrStale", err)
+	}
+	if data, _, err := localFS.Read(ctx, "/dir/file", 0, 100); err != nil || string(data) != "after!" {
+		t.Errorf("Live file after DeleteSnapshot = %q, %v", data, err)
+	}
+}
+
+func TestSnapshotSharedLinks(t *testing.T) {
+	localFS, tempDir, cleanup := setupTestFS(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// A file with two names stays one file in each snapshot
+	createTestFile(t, tempDir, "a", "shared")
+	if err := os.Link(filepath.Join(tempDir, "a"), filepath.Join(tempDir, "b")); err != nil {
+		t.Fatalf("Failed to link: %v", err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if _, err := localFS.Snapshot(ctx, name); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+	}
+
+	// A server started later finds the shared files too
+	reopened, err := NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("NewLocalFileSystem failed: %v", err)
+	}
+	size := int64(2)
+	if _, err := reopened.SetAttr(ctx, "/b", fs.FileAttr{Size: &size}); err != nil {
+		t.Fatalf("SetAttr failed: %v", err)
+	}
+	for _, path := range []string{"/.snapshots/one/a", "/.snapshots/one/b", "/.snapshots/two/b"} {
+		if data, _, err := reopened.Read(ctx, path, 0, 100); err != nil || string(data) != "shared" {
+			t.Errorf("Read of %s = %q, %v; want \"shared\"", path, data, err)
+		}
+	}
+	if data, _, err := reopened.Read(ctx, "/a", 0, 100); err != nil || string(data) != "sh" {
+		t.Errorf("Read of the live file = %q, %v; want \"sh\"", data, err)
+	}
+	a, _ := reopened.GetAttr(ctx, "/.snapshots/one/a")
+	b, _ := reopened.GetAttr(ctx, "/.snapshots/one/b")
+	if a.FileId != b.FileId {
+		t.Errorf("Links in a snapshot became separate files %d and %d", a.FileId, b.FileId)
+	}
+}
@@ -0,0 +1,90 @@
+package local
+
+import (
+    "context"
+    "os"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// Allocate reserves the space of a range of a file with fallocate(2),
+// extending the file if the range goes past its end
+func (l *LocalFileSystem) Allocate(ctx context.Context, path string, offset int64, length int64) error {
+    return l.fallocate("Allocate", path, offset, length, false)
+}
+
+// Deallocate punches a hole in a file with fallocate(2), keeping its size
+func (l *LocalFileSystem) Deallocate(ctx context.Context, path string, offset int64, length int64) error {
+    return l.fallocate("Deallocate", path, offset, length, true)
+}
+
+// fallocate allocates, or if punch is set deallocates, length bytes of the
+// file at path from offset
+func (l *LocalFileSystem) fallocate(op string, path string, offset int64, length int64, punch bool) error {
+    if err := readOnly(op, path); err != nil {
+        return err
+    }
+    if offset < 0 || length <= 0 {
+        return fs.NewError(op, path, fs.ErrInvalidName)
+    }
+    
+    fullPath, err := l.resolveFollow(path)
+    if err != nil {
+        return fs.NewError(op, path, err)
+    }
+    
+    // Snapshots sharing the file keep its old blocks and size
+    l.snapshotMu.RLock()
+    defer l.snapshotMu.RUnlock()
+    if err := l.unshare(fullPath); err != nil {
+        return fs.NewError(op, path, mapOSError(err))
+    }
+    
+    file, err := l.openFile(fullPath, os.O_RDWR, 0)
+    if err != nil {
+        return fs.NewError(op, path, mapOSError(err))
+    }
+    defer file.Close()
+    
+    if err := allocateRange(file, offset, length, punch); err != nil {
+        return fs.NewError(op, path, mapOSError(err))
+    }
+    l.bumpChangeID(fullPath)
+    return nil
+}
+
+// Seek finds the next data or hole with lseek(2), which treats the whole
+// file as data where the file system cannot tell
+func (l *LocalFileSystem) Seek(ctx context.Context, path string, offset int64, what fs.SeekContent) (int64, error) {
+    if offset < 0 {
+        return 0, fs.NewError("Seek", path, fs.ErrInvalidName)
+    }
+    
+    fullPath, err := l.resolveFollow(path)
+    if err != nil {
+        return 0, fs.NewError("Seek", path, err)
+    }
+    
+    file, err := l.openFile(fullPath, os.O_RDONLY, 0)
+    if err != nil {
+        return 0, fs.NewError("Seek", path, mapOSError(err))
+    }
+    defer file.Close()
+    
+    info, err := file.Stat()
+    if err != nil {
+        return 0, fs.NewError("Seek", path, mapOSError(err))
+    }
+    if info.IsDir() {
+        return 0, fs.NewError("Seek", path, fs.ErrIsDir)
+    }
+    
+    next, err := seekContent(file, offset, what)
+    if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+        return 0, fs.NewError("Seek", path, fs.ErrNoData)
+    } else if err != nil {
+        return 0, fs.NewError("Seek", path, mapOSError(err))
+    }
+    return next, nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package local
+
+import (
+    "os"
+
+    "golang.org/x/sys/unix"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// allocateRange allocates length bytes of file from offset, or punches a
+// hole there, keeping the file's size, if punch is set
+func allocateRange(file *os.File, offset int64, length int64, punch bool) error {
+    var mode uint32
+    if punch {
+        mode = unix.FALLOC_FL_PUNCH_HOLE | unix.FALLOC_FL_KEEP_SIZE
+    }
+    if err := unix.Fallocate(int(file.Fd()), mode, offset, length); err != nil {
+        return &os.PathError{Op: "fallocate", Path: file.Name(), Err: err}
+    }
+    return nil
+}
+
+// seekContent returns the offset of the next data or hole in file at or
+// after offset, failing with ENXIO where there is none
+func seekContent(file *os.File, offset int64, what fs.SeekContent) (int64, error) {
+    whence := unix.SEEK_DATA
+    if what == fs.SeekHole {
+        whence = unix.SEEK_HOLE
+    }
+    return file.Seek(offset, whence)
+}
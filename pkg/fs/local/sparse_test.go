@@ -0,0 +1,81 @@
+//go:build linux
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestSparse(t *testing.T) {
+	localFS, tempDir, cleanup := setupTestFS(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	createTestFile(t, tempDir, "file", "")
+	if err := localFS.Allocate(ctx, "/file", 0, 8192); err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if info, err := localFS.GetAttr(ctx, "/file"); err != nil || info.Size != 8192 {
+		t.Errorf("Size after Allocate = %d, %v; want 8192", info.Size, err)
+	}
+	data := bytes.Repeat([]byte("x"), 4096)
+	if _, err := localFS.Write(ctx, "/file", 0, data, false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := localFS.Write(ctx, "/file", 65536, data, false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A snapshot keeps the data punched out of the live file
+	if _, err := localFS.Snapshot(ctx, "before"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	before, _ := localFS.GetAttr(ctx, "/file")
+	if err := localFS.Deallocate(ctx, "/file", 0, 8192); err != nil {
+		t.Fatalf("Deallocate failed: %v", err)
+	}
+	after, err := localFS.GetAttr(ctx, "/file")
+	if err != nil || after.Size != 69632 || after.ChangeID == before.ChangeID {
+		t.Errorf("GetAttr after Deallocate = size %d, change %d, %v; want the size kept and a new change", after.Size, after.ChangeID, err)
+	}
+	if got, _, err := localFS.Read(ctx, "/file", 0, 4096); err != nil || !bytes.Equal(got, make([]byte, 4096)) {
+		t.Errorf("Read of the hole = %q..., %v; want zeros", got[:min(len(got), 8)], err)
+	}
+	if got, _, err := localFS.Read(ctx, "/.snapshots/before/file", 0, 4096); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Read of the snapshot = %q..., %v; want the old data", got[:min(len(got), 8)], err)
+	}
+
+	seeks := []struct {
+		offset int64
+		what   fs.SeekContent
+		want   int64
+	}{
+		{0, fs.SeekData, 65536},
+		{0, fs.SeekHole, 0},
+		{65536, fs.SeekHole, 69632},
+		{66000, fs.SeekData, 66000},
+	}
+	for _, s := range seeks {
+		if got, err := localFS.Seek(ctx, "/file", s.offset, s.what); err != nil || got != s.want {
+			t.Errorf("Seek(%d, %d) = %d, %v; want %d", s.offset, s.what, got, err, s.want)
+		}
+	}
+	if _, err := localFS.Seek(ctx, "/file", 69632, fs.SeekData); !errors.Is(err, fs.ErrNoData) {
+		t.Errorf("Seek for data past the end = %v, want ErrNoData", err)
+	}
+
+	if err := localFS.Deallocate(ctx, "/file", 0, 0); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Deallocate of nothing = %v, want ErrInvalidName", err)
+	}
+	if err := localFS.Allocate(ctx, "/.snapshots/before/file", 0, 8192); !errors.Is(err, fs.ErrReadOnly) {
+		t.Errorf("Allocate in a snapshot = %v, want ErrReadOnly", err)
+	}
+	if _, err := localFS.Seek(ctx, "/", 0, fs.SeekData); !errors.Is(err, fs.ErrIsDir) {
+		t.Errorf("Seek in a directory = %v, want ErrIsDir", err)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package local
+
+import (
+    "os"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// allocateRange fails: NTFS sparse files need FSCTL_SET_SPARSE and
+// FSCTL_SET_ZERO_DATA, which are not used, so ranges cannot be allocated
+// or deallocated
+func allocateRange(file *os.File, offset int64, length int64, punch bool) error {
+    return &os.PathError{Op: "fallocate", Path: file.Name(), Err: syscall.EWINDOWS}
+}
+
+// seekContent treats file as data up to its size, followed by a hole, as
+// lseek(2) does on file systems that do not track holes
+func seekContent(file *os.File, offset int64, what fs.SeekContent) (int64, error) {
+    info, err := file.Stat()
+    if err != nil {
+        return 0, err
+    }
+    if offset >= info.Size() && (what == fs.SeekData || offset > info.Size()) {
+        return 0, &os.PathError{Op: "seek", Path: file.Name(), Err: syscall.ENXIO}
+    }
+    if what == fs.SeekHole {
+        return info.Size(), nil
+    }
+    return offset, nil
+}
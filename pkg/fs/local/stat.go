@@ -0,0 +1,30 @@
+package local
+
+import (
+    "os"
+    "time"
+)
+
+// fileStat is the part of a file's status the local file system needs
+// beyond os.FileInfo. Each platform fills it in from its own stat call:
+// statOf returns it for a file, and withFileStat attaches it to an
+// os.FileInfo so it can still be read once the file is gone.
+type fileStat struct {
+    Ino     uint64    // Inode number, or NTFS file ID
+    Nlink   uint32    // Number of hard links
+    Uid     uint32    // Owner
+    Gid     uint32    // Group
+    Rdev    uint64    // Device number of device files
+    Atime   time.Time // Last access time
+    Ctime   time.Time // Last status change time
+    Symlink bool      // The file is a symbolic link
+}
+
+// statAt is statOf for the file at path, relative to the export root
+func (l *LocalFileSystem) statAt(path string, info os.FileInfo) (*fileStat, bool) {
+    fullPath, err := l.resolvePath(path)
+    if err != nil {
+        return nil, false
+    }
+    return statOf(fullPath, info)
+}
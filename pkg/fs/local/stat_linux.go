@@ -0,0 +1,121 @@
+//go:build linux
+
+package local
+
+import (
+    "os"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// statOf returns the status of the file at fullPath described by info.
+// Here it is part of every os.FileInfo, so fullPath is not needed.
+func statOf(fullPath string, info os.FileInfo) (*fileStat, bool) {
+    st, ok := info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return nil, false
+    }
+    return &fileStat{
+        Ino:     st.Ino,
+        Nlink:   uint32(st.Nlink),
+        Uid:     st.Uid,
+        Gid:     st.Gid,
+        Rdev:    uint64(st.Rdev),
+        Atime:   time.Unix(st.Atim.Unix()),
+        Ctime:   time.Unix(st.Ctim.Unix()),
+        Symlink: st.Mode&syscall.S_IFMT == syscall.S_IFLNK,
+    }, true
+}
+
+// withFileStat returns info with the status of fullPath attached. os.Lstat
+// already carries it.
+func withFileStat(fullPath string, info os.FileInfo) os.FileInfo {
+    return info
+}
+
+// birthTime returns the inode and creation time in nanoseconds of the file
+// at fullPath, if the file system records one
+func birthTime(fullPath string) (uint64, int64, bool) {
+    var stx unix.Statx_t
+    err := unix.Statx(unix.AT_FDCWD, fullPath, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_INO|unix.STATX_BTIME, &stx)
+    if err != nil || stx.Mask&unix.STATX_BTIME == 0 {
+        return 0, 0, false
+    }
+    return stx.Ino, stx.Btime.Sec*1e9 + int64(stx.Btime.Nsec), true
+}
+
+// getxattr and setxattr read and write an extended attribute of fullPath
+func getxattr(fullPath string, name string, buf []byte) (int, error) {
+    return syscall.Getxattr(fullPath, name, buf)
+}
+
+func setxattr(fullPath string, name string, value []byte) error {
+    return syscall.Setxattr(fullPath, name, value, 0)
+}
+
+// removexattr removes an extended attribute of fullPath
+func removexattr(fullPath string, name string) error {
+    return syscall.Removexattr(fullPath, name)
+}
+
+// noXattr reports whether getxattr failed because the file lacks the
+// attribute, rather than because it cannot have one
+func noXattr(err error) bool {
+    return err == syscall.ENODATA
+}
+
+// chmod sets all twelve mode bits of fullPath. os.Chmod would drop the
+// setuid, setgid and sticky bits, which os.FileMode keeps elsewhere.
+func chmod(fullPath string, mode uint32) error {
+    return syscall.Chmod(fullPath, mode)
+}
+
+// utimeOmit tells utimensat to leave a timestamp unchanged (UTIME_OMIT)
+const utimeOmit = (1 << 30) - 2
+
+// setFileTimes sets whichever of atime and mtime are non-nil, leaving the
+// other untouched, in a single utimensat call
+func setFileTimes(fullPath string, atime, mtime *time.Time) error {
+    if atime == nil && mtime == nil {
+        return nil
+    }
+
+    times := []syscall.Timespec{{Nsec: utimeOmit}, {Nsec: utimeOmit}}
+    if atime != nil {
+        times[0] = syscall.NsecToTimespec(atime.UnixNano())
+    }
+    if mtime != nil {
+        times[1] = syscall.NsecToTimespec(mtime.UnixNano())
+    }
+
+    return syscall.UtimesNano(fullPath, times)
+}
+
+// statFS returns the usage and name limit of the file system holding
+// fullPath
+func statFS(fullPath string) (fs.FSStat, error) {
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(fullPath, &st); err != nil {
+        return fs.FSStat{}, err
+    }
+
+    blockSize := uint64(st.Bsize)
+    return fs.FSStat{
+        TotalBytes:    st.Blocks * blockSize,
+        FreeBytes:     st.Bfree * blockSize,
+        AvailBytes:    st.Bavail * blockSize,
+        TotalFiles:    st.Files,
+        FreeFiles:     st.Ffree,
+        NameMaxLength: uint32(st.Namelen),
+    }, nil
+}
+
+// platformError maps errors the generic checks in mapOSError get wrong
+// on this platform, returning nil for the rest. On Linux they need no help.
+func platformError(err error) error {
+    return nil
+}
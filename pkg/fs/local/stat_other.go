@@ -0,0 +1,100 @@
+//go:build !linux && !windows
+
+package local
+
+import (
+    "os"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// statOf returns the status of the file at fullPath described by info. The
+// stat structure os.FileInfo carries names its times differently on every
+// BSD, so the status is read again from fullPath.
+func statOf(fullPath string, info os.FileInfo) (*fileStat, bool) {
+    var st unix.Stat_t
+    var err error
+    if info.Mode()&os.ModeSymlink != 0 {
+        err = unix.Lstat(fullPath, &st)
+    } else {
+        err = unix.Stat(fullPath, &st)
+    }
+    if err != nil {
+        return nil, false
+    }
+    return &fileStat{
+        Ino:     uint64(st.Ino),
+        Nlink:   uint32(st.Nlink),
+        Uid:     st.Uid,
+        Gid:     st.Gid,
+        Rdev:    uint64(st.Rdev),
+        Atime:   time.Unix(st.Atim.Unix()),
+        Ctime:   time.Unix(st.Ctim.Unix()),
+        Symlink: st.Mode&unix.S_IFMT == unix.S_IFLNK,
+    }, true
+}
+
+// withFileStat returns info with the status of fullPath attached. os.Lstat
+// already carries it.
+func withFileStat(fullPath string, info os.FileInfo) os.FileInfo {
+    return info
+}
+
+// birthTime reports false: creation times are not read here, so recreated
+// files are told apart by their inode numbers alone
+func birthTime(fullPath string) (uint64, int64, bool) {
+    return 0, 0, false
+}
+
+// getxattr, setxattr and removexattr fail: the extended attribute calls
+// differ on every BSD and are not used, so change counters are kept in
+// memory and files have no POSIX ACLs
+func getxattr(fullPath string, name string, buf []byte) (int, error) {
+    return 0, syscall.ENOTSUP
+}
+
+func setxattr(fullPath string, name string, value []byte) error {
+    return syscall.ENOTSUP
+}
+
+func removexattr(fullPath string, name string) error {
+    return syscall.ENOTSUP
+}
+
+// noXattr reports whether getxattr failed because the file lacks the
+// attribute; here it never does, as no file can have one
+func noXattr(err error) bool {
+    return false
+}
+
+// chmod sets all twelve mode bits of fullPath. os.Chmod would drop the
+// setuid, setgid and sticky bits, which os.FileMode keeps elsewhere.
+func chmod(fullPath string, mode uint32) error {
+    return syscall.Chmod(fullPath, mode)
+}
+
+// setFileTimes sets whichever of atime and mtime are non-nil, leaving the
+// other untouched. Not every BSD has UTIME_OMIT; os.Chtimes leaves a zero
+// time alone where it cannot omit it.
+func setFileTimes(fullPath string, atime, mtime *time.Time) error {
+    if atime == nil && mtime == nil {
+        return nil
+    }
+
+    var a, m time.Time
+    if atime != nil {
+        a = *atime
+    }
+    if mtime != nil {
+        m = *mtime
+    }
+    return os.Chtimes(fullPath, a, m)
+}
+
+// platformError maps errors the generic checks in mapOSError get wrong
+// on this platform, returning nil for the rest
+func platformError(err error) error {
+    return nil
+}
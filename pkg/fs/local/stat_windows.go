@@ -0,0 +1,244 @@
+//go:build windows
+
+package local
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "syscall"
+    "time"
+    "unsafe"
+
+    "golang.org/x/sys/windows"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// statInfo is an os.FileInfo with the file's status attached. On Windows
+// os.FileInfo carries no file ID or link count, which can only be read from
+// an open handle, so withFileStat reads them while the file still exists.
+type statInfo struct {
+    os.FileInfo
+    stat *fileStat
+}
+
+// Sys returns the attached status
+func (i statInfo) Sys() any {
+    return i.stat
+}
+
+// statOf returns the status of the file at fullPath described by info:
+// the one attached by withFileStat, or else read from fullPath
+func statOf(fullPath string, info os.FileInfo) (*fileStat, bool) {
+    if stat, ok := info.Sys().(*fileStat); ok {
+        return stat, true
+    }
+    stat, err := readFileStat(fullPath, info.Mode()&os.ModeSymlink == 0)
+    return stat, err == nil
+}
+
+// withFileStat returns info with the status of fullPath attached, or info
+// unchanged if the status cannot be read
+func withFileStat(fullPath string, info os.FileInfo) os.FileInfo {
+    if _, ok := info.Sys().(*fileStat); ok {
+        return info
+    }
+    stat, err := readFileStat(fullPath, info.Mode()&os.ModeSymlink == 0)
+    if err != nil {
+        return info
+    }
+    return statInfo{FileInfo: info, stat: stat}
+}
+
+// fileBasicInfo is FILE_BASIC_INFO, which golang.org/x/sys/windows lacks.
+// Times count 100ns intervals since 1601, like FILETIME.
+type fileBasicInfo struct {
+    CreationTime   int64
+    LastAccessTime int64
+    LastWriteTime  int64
+    ChangeTime     int64
+    FileAttributes uint32
+    _              uint32
+}
+
+// openHandle opens fullPath, which may be a directory, for access.
+// Symbolic links and other reparse points are followed only if follow is
+// set.
+func openHandle(fullPath string, access uint32, follow bool) (windows.Handle, error) {
+    name, err := windows.UTF16PtrFromString(fullPath)
+    if err != nil {
+        return windows.InvalidHandle, err
+    }
+    flags := uint32(windows.FILE_FLAG_BACKUP_SEMANTICS)
+    if !follow {
+        flags |= windows.FILE_FLAG_OPEN_REPARSE_POINT
+    }
+    share := uint32(windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE)
+    return windows.CreateFile(name, access, share, nil, windows.OPEN_EXISTING, flags, 0)
+}
+
+// fileInfo reads the identity and times of fullPath from a handle. The
+// file ID stands in for the inode number: on NTFS it includes a sequence
+// number that changes when the MFT record is reused, so a recreated file
+// gets a new ID even where Unix would hand out the old inode.
+func fileInfo(fullPath string, follow bool) (windows.ByHandleFileInformation, fileBasicInfo, error) {
+    var info windows.ByHandleFileInformation
+    var basic fileBasicInfo
+    h, err := openHandle(fullPath, windows.FILE_READ_ATTRIBUTES, follow)
+    if err != nil {
+        return info, basic, err
+    }
+    defer windows.CloseHandle(h)
+    if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+        return info, basic, err
+    }
+
+    // FAT and some network file systems have no change time; the write
+    // time stands in for it
+    err = windows.GetFileInformationByHandleEx(h, windows.FileBasicInfo, (*byte)(unsafe.Pointer(&basic)), uint32(unsafe.Sizeof(basic)))
+    if err != nil || basic.ChangeTime == 0 {
+        basic.ChangeTime = int64(info.LastWriteTime.HighDateTime)<<32 | int64(info.LastWriteTime.LowDateTime)
+    }
+    return info, basic, nil
+}
+
+// readFileStat reads the status of fullPath. Windows has no POSIX owners,
+// so files appear owned by root.
+func readFileStat(fullPath string, follow bool) (*fileStat, error) {
+    info, basic, err := fileInfo(fullPath, follow)
+    if err != nil {
+        return nil, err
+    }
+    ctime := windows.Filetime{LowDateTime: uint32(basic.ChangeTime), HighDateTime: uint32(basic.ChangeTime >> 32)}
+    return &fileStat{
+        Ino:     uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow),
+        Nlink:   info.NumberOfLinks,
+        Atime:   time.Unix(0, info.LastAccessTime.Nanoseconds()),
+        Ctime:   time.Unix(0, ctime.Nanoseconds()),
+        Symlink: !follow && info.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0,
+    }, nil
+}
+
+// birthTime returns the file ID and creation time in nanoseconds of the
+// file at fullPath. Every Windows file system records creation times, so
+// they tell recreated files apart even where file IDs are reused, as on
+// FAT, whose IDs are derived from the directory entry's position.
+func birthTime(fullPath string) (uint64, int64, bool) {
+    info, _, err := fileInfo(fullPath, false)
+    if err != nil {
+        return 0, 0, false
+    }
+    ino := uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)
+    return ino, info.CreationTime.Nanoseconds(), true
+}
+
+// getxattr, setxattr and removexattr fail: Windows has no user extended
+// attributes, so change counters are kept in memory and files have no
+// POSIX ACLs. NTFS alternate data streams would hold counters, but writing
+// one moves the file's mtime.
+func getxattr(fullPath string, name string, buf []byte) (int, error) {
+    return 0, errors.ErrUnsupported
+}
+
+func setxattr(fullPath string, name string, value []byte) error {
+    return errors.ErrUnsupported
+}
+
+func removexattr(fullPath string, name string) error {
+    return errors.ErrUnsupported
+}
+
+// noXattr reports whether getxattr failed because the file lacks the
+// attribute; here it never does, as no file can have one
+func noXattr(err error) bool {
+    return false
+}
+
+// chmod sets the mode of fullPath. Windows only keeps the owner's write
+// bit, as the read-only attribute; the other bits are ignored.
+func chmod(fullPath string, mode uint32) error {
+    return os.Chmod(fullPath, os.FileMode(mode&0777))
+}
+
+// setFileTimes sets whichever of atime and mtime are non-nil, leaving the
+// other untouched, in a single SetFileTime call
+func setFileTimes(fullPath string, atime, mtime *time.Time) error {
+    if atime == nil && mtime == nil {
+        return nil
+    }
+
+    h, err := openHandle(fullPath, windows.FILE_WRITE_ATTRIBUTES, true)
+    if err != nil {
+        return &os.PathError{Op: "SetFileTime", Path: fullPath, Err: err}
+    }
+    defer windows.CloseHandle(h)
+
+    var a, m *windows.Filetime
+    if atime != nil {
+        ft := windows.NsecToFiletime(atime.UnixNano())
+        a = &ft
+    }
+    if mtime != nil {
+        ft := windows.NsecToFiletime(mtime.UnixNano())
+        m = &ft
+    }
+    if err := windows.SetFileTime(h, nil, a, m); err != nil {
+        return &os.PathError{Op: "SetFileTime", Path: fullPath, Err: err}
+    }
+    return nil
+}
+
+// statFS returns the usage and name limit of the volume holding fullPath.
+// Windows does not count files, so the file counts are left zero.
+func statFS(fullPath string) (fs.FSStat, error) {
+    name, err := windows.UTF16PtrFromString(fullPath)
+    if err != nil {
+        return fs.FSStat{}, err
+    }
+    var avail, total, free uint64
+    if err := windows.GetDiskFreeSpaceEx(name, &avail, &total, &free); err != nil {
+        return fs.FSStat{}, &os.PathError{Op: "GetDiskFreeSpaceEx", Path: fullPath, Err: err}
+    }
+
+    nameMax := uint32(255)
+    if root, err := windows.UTF16PtrFromString(volumeRoot(fullPath)); err == nil {
+        var maxComponent uint32
+        if windows.GetVolumeInformation(root, nil, 0, nil, &maxComponent, nil, nil, 0) == nil {
+            nameMax = maxComponent
+        }
+    }
+    return fs.FSStat{
+        TotalBytes:    total,
+        FreeBytes:     free,
+        AvailBytes:    avail,
+        NameMaxLength: nameMax,
+    }, nil
+}
+
+// volumeRoot returns the root of the volume holding fullPath, with the
+// trailing separator GetVolumeInformation requires
+func volumeRoot(fullPath string) string {
+    return filepath.VolumeName(fullPath) + `\`
+}
+
+// platformError maps the Windows errors the generic checks in mapOSError
+// get wrong, returning nil for the rest. os.IsExist, for one, is true for
+// ERROR_DIR_NOT_EMPTY.
+func platformError(err error) error {
+    var errno syscall.Errno
+    if !errors.As(err, &errno) {
+        return nil
+    }
+    switch errno {
+    case windows.ERROR_DIR_NOT_EMPTY:
+        return fs.ErrNotEmpty
+    case windows.ERROR_DISK_FULL, windows.ERROR_HANDLE_DISK_FULL:
+        return fs.ErrNoSpace
+    case windows.ERROR_NOT_SUPPORTED, syscall.EWINDOWS:
+        return fs.ErrNotSupported
+    case windows.ERROR_INVALID_NAME:
+        return fs.ErrInvalidName
+    }
+    return nil
+}
@@ -0,0 +1,32 @@
+//go:build darwin || dragonfly || freebsd
+
+package local
+
+import (
+    "golang.org/x/sys/unix"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// maxNameLength is the longest file name the BSDs allow, NAME_MAX. Only
+// FreeBSD reports a per-file-system limit, which is the same.
+const maxNameLength = 255
+
+// statFS returns the usage and name limit of the file system holding
+// fullPath
+func statFS(fullPath string) (fs.FSStat, error) {
+    var st unix.Statfs_t
+    if err := unix.Statfs(fullPath, &st); err != nil {
+        return fs.FSStat{}, err
+    }
+
+    blockSize := uint64(st.Bsize)
+    return fs.FSStat{
+        TotalBytes:    uint64(st.Blocks) * blockSize,
+        FreeBytes:     uint64(st.Bfree) * blockSize,
+        AvailBytes:    uint64(max(st.Bavail, 0)) * blockSize,
+        TotalFiles:    uint64(st.Files),
+        FreeFiles:     uint64(max(st.Ffree, 0)),
+        NameMaxLength: maxNameLength,
+    }, nil
+}
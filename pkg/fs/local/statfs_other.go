@@ -0,0 +1,16 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd
+
+package local
+
+import (
+    "os"
+    "syscall"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// statFS fails: the remaining platforms report usage through statvfs(2),
+// which is not used
+func statFS(fullPath string) (fs.FSStat, error) {
+    return fs.FSStat{}, &os.PathError{Op: "statfs", Path: fullPath, Err: syscall.EOPNOTSUPP}
+}
@@ -0,0 +1,64 @@
+package local
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// trashDirName is the directory in the staging area that entries waiting
+// to be deleted are moved into. Being inside the staging area keeps it out
+// of listings and lookups from the export root, and on the same file
+// system as the export, so moving a tree into it is a single rename.
+const trashDirName = "trash"
+
+// isTrashPath reports whether path lies inside the trash directory
+func isTrashPath(path string) bool {
+    clean := filepath.ToSlash(filepath.Clean("/" + path))
+    return strings.HasPrefix(clean, "/"+stagingDirName+"/"+trashDirName+"/")
+}
+
+// Trash moves path into the trash directory under a random name. Handles
+// of the entry and of everything below it follow it there.
+func (l *LocalFileSystem) Trash(ctx context.Context, path string) (string, error) {
+    clean := filepath.Clean("/" + path)
+    if clean == "/" || isStagingPath(clean) {
+        return "", fs.NewError("Trash", path, fs.ErrInvalidName)
+    }
+    
+    trashPath := filepath.Join(l.rootPath, stagingDirName, trashDirName)
+    if err := os.MkdirAll(trashPath, 0700); err != nil {
+        return "", fs.NewError("Trash", path, mapOSError(err))
+    }
+    
+    suffix := make([]byte, 12)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", fs.NewError("Trash", path, fs.ErrIO)
+    }
+    target := "/" + stagingDirName + "/" + trashDirName + "/" + hex.EncodeToString(suffix)
+    if err := l.Rename(ctx, clean, target, fs.RenameNoReplace); err != nil {
+        return "", err
+    }
+    return target, nil
+}
+
+// Trashed lists the entries in the trash directory
+func (l *LocalFileSystem) Trashed(ctx context.Context) ([]string, error) {
+    entries, err := os.ReadDir(filepath.Join(l.rootPath, stagingDirName, trashDirName))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fs.NewError("Trashed", "/", mapOSError(err))
+    }
+    paths := make([]string, 0, len(entries))
+    for _, entry := range entries {
+        paths = append(paths, "/"+stagingDirName+"/"+trashDirName+"/"+entry.Name())
+    }
+    return paths, nil
+}
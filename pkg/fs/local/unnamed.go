@@ -0,0 +1,145 @@
+package local
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/example/nfsserver/pkg/fs"
+)
+
+// stagingDirName is the hidden directory under the export root that holds
+// unnamed files until they are linked into place. Keeping it on the same
+// file system as the export makes the final link or rename atomic.
+const stagingDirName = ".nfs-staging"
+
+// isStagingPath reports whether path refers to the staging area or lies inside it
+func isStagingPath(path string) bool {
+    clean := filepath.ToSlash(filepath.Clean("/" + path))
+    return clean == "/"+stagingDirName || strings.HasPrefix(clean, "/"+stagingDirName+"/")
+}
+
+// clearStaging discards unnamed files left over from a previous run, and
+// the remains of snapshots that failed or were being deleted. The trash is
+// kept: it may hold trees too large to delete before serving, and is left
+// for whoever deletes it in the background. So are the change epoch and the
+// snapshots.
+func (l *LocalFileSystem) clearStaging() error {
+    stagingPath := filepath.Join(l.rootPath, stagingDirName)
+    entries, err := os.ReadDir(stagingPath)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if entry.Name() == trashDirName || entry.Name() == changeEpochName || entry.Name() == snapshotsDirName {
+            continue
+        }
+        if err := removeTree(filepath.Join(stagingPath, entry.Name())); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// CreateUnnamed creates an anonymous file, like O_TMPFILE. The file is not
+// visible in any directory until LinkUnnamed gives it a name.
+func (l *LocalFileSystem) CreateUnnamed(ctx context.Context, dir string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+    if err := readOnly("CreateUnnamed", dir); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
+    // The directory must exist, even though the file is staged elsewhere
+    info, err := l.GetAttr(ctx, dir)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, err)
+    }
+    if info.Type != fs.FileTypeDirectory {
+        return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, fs.ErrNotDir)
+    }
+    
+    stagingPath := filepath.Join(l.rootPath, stagingDirName)
+    if err := os.MkdirAll(stagingPath, 0700); err != nil {
+        return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, mapOSError(err))
+    }
+    
+    suffix := make([]byte, 12)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, fs.ErrIO)
+    }
+    
+    return l.Create(ctx, "/"+stagingDirName, hex.EncodeToString(suffix), attr, true)
+}
+
+// LinkUnnamed gives a file created by CreateUnnamed the name dir/name.
+// Unless replace is set, it fails with ErrExist if the name is taken.
+func (l *LocalFileSystem) LinkUnnamed(ctx context.Context, path string, dir string, name string, replace bool) (string, fs.FileInfo, error) {
+    if !isStagingPath(path) || isTrashPath(path) || filepath.Clean("/"+path) == "/"+stagingDirName {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", path, fs.ErrInvalidName)
+    }
+    if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", name, fs.ErrInvalidName)
+    }
+    
+    targetPath := filepath.Join(dir, name)
+    if isStagingPath(targetPath) {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", targetPath, fs.ErrInvalidName)
+    }
+    if err := readOnly("LinkUnnamed", targetPath); err != nil {
+        return "", fs.FileInfo{}, err
+    }
+    
+    fullSource, err := l.resolvePath(path)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", path, err)
+    }
+    fullTarget, err := l.resolvePath(targetPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", targetPath, err)
+    }
+    
+    inode, err := l.getInode(path)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", path, err)
+    }
+    
+    // A replaced file no longer has the name
+    replacedInfo, _ := l.lstat(fullTarget)
+    
+    l.namespaceMu.RLock()
+    if replace {
+        err = os.Rename(fullSource, fullTarget)
+    } else {
+        // link(2) fails atomically if the name exists
+        if err = os.Link(fullSource, fullTarget); err == nil {
+            os.Remove(fullSource)
+        }
+    }
+    if err != nil {
+        l.namespaceMu.RUnlock()
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", targetPath, mapOSError(err))
+    }
+    
+    // Existing handles must now resolve to the new name
+    if replacedInfo != nil && replace {
+        if stat, ok := statOf(fullTarget, replacedInfo); ok && stat.Ino != inode {
+            l.unlinked(ctx, replacedInfo, filepath.Join("/", targetPath))
+        }
+    }
+    l.updateInodeMap(targetPath, inode)
+    l.namespaceMu.RUnlock()
+    l.bumpChangeID(fullTarget)
+    l.bumpChangeID(filepath.Dir(fullTarget))
+    
+    info, err := l.GetAttr(ctx, targetPath)
+    if err != nil {
+        return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", targetPath, err)
+    }
+    
+    return targetPath, info, nil
+}
@@ -0,0 +1,655 @@
+// Package memfs implements a file system kept in memory, for tests, for
+// programs embedding the server and for serving fixtures without touching
+// the local disk.
+package memfs
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// fileSystemID is the file system ID in the handles of a MemFileSystem
+const fileSystemID = 0x6d656d66
+
+// memNode is a file, directory or symlink of a MemFileSystem
+type memNode struct {
+	info     fs.FileInfo
+	ino      uint64
+	gen      uint32
+	parent   *memNode
+	name     string
+	links    []memName // Names besides parent/name, for hard links
+	data     []byte
+	target   string
+	children map[string]*memNode
+}
+
+// memName is an entry naming a node in a directory
+type memName struct {
+	dir  *memNode
+	name string
+}
+
+// MemFileSystem is a file system kept in memory. A node records the path
+// it is known by, the first of its names, and any hard links to it.
+type MemFileSystem struct {
+	mu      sync.Mutex
+	root    *memNode
+	byIno   map[uint64]*memNode
+	nextIno uint64
+	change  uint64
+}
+
+// NewMemFileSystem returns an empty file system, its root owned by root
+func NewMemFileSystem() *MemFileSystem {
+	m := &MemFileSystem{byIno: make(map[uint64]*memNode), nextIno: 1}
+	m.root = m.newNode(fs.FileTypeDirectory, 0755, fs.FileAttr{})
+	return m
+}
+
+// newNode creates an unlinked node
+func (m *MemFileSystem) newNode(typ fs.FileType, mode fs.FileMode, attr fs.FileAttr) *memNode {
+	now := time.Now()
+	n := &memNode{ino: m.nextIno, gen: 1}
+	m.nextIno++
+	n.info = fs.FileInfo{Type: typ, Mode: mode, Nlink: 1, BlockSize: 4096,
+		AccessTime: now, ModifyTime: now, ChangeTime: now, CreateTime: now}
+	if attr.Mode != nil {
+		n.info.Mode = *attr.Mode & 07777
+	}
+	if attr.Uid != nil {
+		n.info.Uid = *attr.Uid
+	}
+	if attr.Gid != nil {
+		n.info.Gid = *attr.Gid
+	}
+	if typ == fs.FileTypeDirectory {
+		n.children = make(map[string]*memNode)
+		n.info.Nlink = 2
+	}
+	m.touch(n)
+	m.byIno[n.ino] = n
+	return n
+}
+
+// touch records a change to n
+func (m *MemFileSystem) touch(n *memNode) {
+	m.change++
+	n.info.ChangeID = m.change
+	n.info.ChangeTime = time.Now()
+}
+
+// stat returns the attributes of n
+func (m *MemFileSystem) stat(n *memNode) fs.FileInfo {
+	info := n.info
+	info.FileId = n.ino
+	switch info.Type {
+	case fs.FileTypeRegular:
+		info.Size = int64(len(n.data))
+	case fs.FileTypeSymlink:
+		info.Size = int64(len(n.target))
+	case fs.FileTypeDirectory:
+		info.Size = 4096
+	}
+	if n.children == nil {
+		info.Nlink = uint32(1 + len(n.links))
+	}
+	info.Blocks = uint64(info.Size+511) / 512
+	return info
+}
+
+// walk returns the node at p
+func (m *MemFileSystem) walk(p string) (*memNode, error) {
+	n := m.root
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if n.children == nil {
+			return nil, fs.ErrNotDir
+		}
+		child, ok := n.children[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// entry returns the node at p, with the directory and name it was found
+// under; the root has none
+func (m *MemFileSystem) entry(p string) (*memNode, string, *memNode, error) {
+	dirPath, name := path.Split(path.Clean("/" + p))
+	if name == "" {
+		return nil, "", m.root, nil
+	}
+	d, err := m.dir(dirPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	n, ok := d.children[name]
+	if !ok {
+		return nil, "", nil, fs.ErrNotExist
+	}
+	return d, name, n, nil
+}
+
+// dir returns the directory at p
+func (m *MemFileSystem) dir(p string) (*memNode, error) {
+	n, err := m.walk(p)
+	if err != nil {
+		return nil, err
+	}
+	if n.children == nil {
+		return nil, fs.ErrNotDir
+	}
+	return n, nil
+}
+
+// pathOf returns the path of n from the root
+func pathOf(n *memNode) string {
+	var names []string
+	for ; n.parent != nil; n = n.parent {
+		names = append(names, n.name)
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+// link enters n in dir under name, as a hard link if it already has a
+// name
+func (m *MemFileSystem) link(dir *memNode, name string, n *memNode) {
+	dir.children[name] = n
+	if n.parent == nil {
+		n.parent, n.name = dir, name
+	} else {
+		n.links = append(n.links, memName{dir, name})
+	}
+	if n.children != nil {
+		dir.info.Nlink++
+	}
+	dir.info.ModifyTime = time.Now()
+	m.touch(dir)
+}
+
+// detach removes the entry name from dir and returns the node it named.
+// A node losing the name it is known by is known by its next one.
+func (m *MemFileSystem) detach(dir *memNode, name string) *memNode {
+	n := dir.children[name]
+	delete(dir.children, name)
+	if n.children != nil {
+		dir.info.Nlink--
+	}
+	dir.info.ModifyTime = time.Now()
+	m.touch(dir)
+
+	if n.parent == dir && n.name == name {
+		n.parent, n.name = nil, ""
+		if len(n.links) > 0 {
+			n.parent, n.name = n.links[0].dir, n.links[0].name
+			n.links = n.links[1:]
+		}
+	} else {
+		n.links = slices.DeleteFunc(n.links, func(l memName) bool {
+			return l.dir == dir && l.name == name
+		})
+	}
+	return n
+}
+
+// unlink removes the entry name from dir, forgetting the node it named
+// once no name is left
+func (m *MemFileSystem) unlink(dir *memNode, name string) {
+	n := m.detach(dir, name)
+	if n.parent == nil {
+		delete(m.byIno, n.ino)
+	} else {
+		m.touch(n)
+	}
+}
+
+// add creates a node named name in the directory at dir
+func (m *MemFileSystem) add(op string, dir string, name string, typ fs.FileType, attr fs.FileAttr) (*memNode, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return nil, fs.NewError(op, dir, fs.ErrInvalidName)
+	}
+	d, err := m.dir(dir)
+	if err != nil {
+		return nil, fs.NewError(op, dir, err)
+	}
+	if _, ok := d.children[name]; ok {
+		return nil, fs.NewError(op, path.Join(dir, name), fs.ErrExist)
+	}
+	mode := fs.FileMode(0644)
+	if typ == fs.FileTypeDirectory {
+		mode = 0755
+	} else if typ == fs.FileTypeSymlink {
+		mode = 0777
+	}
+	n := m.newNode(typ, mode, attr)
+	m.link(d, name, n)
+	return n, nil
+}
+
+func (m *MemFileSystem) GetAttr(ctx context.Context, p string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return fs.FileInfo{}, fs.NewError("GetAttr", p, err)
+	}
+	return m.stat(n), nil
+}
+
+func (m *MemFileSystem) SetAttr(ctx context.Context, p string, attr fs.FileAttr) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return fs.FileInfo{}, fs.NewError("SetAttr", p, err)
+	}
+	if attr.Size != nil {
+		if n.info.Type != fs.FileTypeRegular {
+			return fs.FileInfo{}, fs.NewError("SetAttr", p, fs.ErrIsDir)
+		}
+		size := int(*attr.Size)
+		if size <= len(n.data) {
+			n.data = n.data[:size]
+		} else {
+			n.data = append(n.data, make([]byte, size-len(n.data))...)
+		}
+		n.info.ModifyTime = time.Now()
+	}
+	if attr.Mode != nil {
+		n.info.Mode = *attr.Mode & 07777
+	}
+	if attr.Uid != nil {
+		n.info.Uid = *attr.Uid
+	}
+	if attr.Gid != nil {
+		n.info.Gid = *attr.Gid
+	}
+	if attr.AccessTime != nil {
+		n.info.AccessTime = *attr.AccessTime
+	}
+	if attr.ModifyTime != nil {
+		n.info.ModifyTime = *attr.ModifyTime
+	}
+	m.touch(n)
+	return m.stat(n), nil
+}
+
+func (m *MemFileSystem) Lookup(ctx context.Context, dir string, name string) (string, fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := path.Join("/", dir, name)
+	n, err := m.walk(p)
+	if err != nil {
+		return "", fs.FileInfo{}, fs.NewError("Lookup", p, err)
+	}
+	return pathOf(n), m.stat(n), nil
+}
+
+func (m *MemFileSystem) Access(ctx context.Context, p string, mode fs.FileMode, creds fs.Credentials) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return fs.NewError("Access", p, err)
+	}
+	perm := n.info.Mode & 7
+	if n.info.Uid == creds.UID {
+		perm = (n.info.Mode >> 6) & 7
+	} else if n.info.Gid == creds.GID {
+		perm = (n.info.Mode >> 3) & 7
+	} else {
+		for _, g := range creds.Groups {
+			if g == n.info.Gid {
+				perm = (n.info.Mode >> 3) & 7
+			}
+		}
+	}
+	if mode&7&perm != mode&7 {
+		return fs.NewError("Access", p, fs.ErrPermission)
+	}
+	return nil
+}
+
+func (m *MemFileSystem) Read(ctx context.Context, p string, offset int64, length int) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return nil, false, fs.NewError("Read", p, err)
+	}
+	if n.info.Type == fs.FileTypeDirectory {
+		return nil, false, fs.NewError("Read", p, fs.ErrIsDir)
+	}
+	if offset >= int64(len(n.data)) {
+		return []byte{}, true, nil
+	}
+	end := min(offset+int64(length), int64(len(n.data)))
+	return append([]byte(nil), n.data[offset:end]...), end == int64(len(n.data)), nil
+}
+
+func (m *MemFileSystem) Write(ctx context.Context, p string, offset int64, data []byte, sync bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return 0, fs.NewError("Write", p, err)
+	}
+	if n.info.Type == fs.FileTypeDirectory {
+		return 0, fs.NewError("Write", p, fs.ErrIsDir)
+	}
+	if end := offset + int64(len(data)); end > int64(len(n.data)) {
+		n.data = append(n.data, make([]byte, end-int64(len(n.data)))...)
+	}
+	copy(n.data[offset:], data)
+	n.info.ModifyTime = time.Now()
+	m.touch(n)
+	return len(data), nil
+}
+
+func (m *MemFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !excl {
+		if n, err := m.walk(path.Join("/", dir, name)); err == nil {
+			if n.info.Type == fs.FileTypeDirectory {
+				return "", fs.FileInfo{}, fs.NewError("Create", pathOf(n), fs.ErrIsDir)
+			}
+			n.data = nil
+			m.touch(n)
+			return pathOf(n), m.stat(n), nil
+		}
+	}
+	n, err := m.add("Create", dir, name, fs.FileTypeRegular, attr)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return pathOf(n), m.stat(n), nil
+}
+
+func (m *MemFileSystem) Remove(ctx context.Context, p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, name, n, err := m.entry(p)
+	if err != nil {
+		return fs.NewError("Remove", p, err)
+	}
+	if n.children != nil {
+		return fs.NewError("Remove", p, fs.ErrIsDir)
+	}
+	m.unlink(d, name)
+	return nil
+}
+
+func (m *MemFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.add("Mkdir", dir, name, fs.FileTypeDirectory, attr)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return pathOf(n), m.stat(n), nil
+}
+
+func (m *MemFileSystem) Rmdir(ctx context.Context, p string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, name, n, err := m.entry(p)
+	if err != nil {
+		return fs.NewError("Rmdir", p, err)
+	}
+	if n.children == nil {
+		return fs.NewError("Rmdir", p, fs.ErrNotDir)
+	}
+	if n == m.root {
+		return fs.NewError("Rmdir", p, fs.ErrPermission)
+	}
+	if len(n.children) > 0 {
+		return fs.NewError("Rmdir", p, fs.ErrNotEmpty)
+	}
+	m.unlink(d, name)
+	return nil
+}
+
+func (m *MemFileSystem) EntryCount(ctx context.Context, dir string) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.dir(dir)
+	if err != nil {
+		return 0, fs.NewError("EntryCount", dir, err)
+	}
+	return uint64(len(n.children)), nil
+}
+
+func (m *MemFileSystem) IsEmpty(ctx context.Context, dir string) (bool, error) {
+	count, err := m.EntryCount(ctx, dir)
+	return count == 0, err
+}
+
+// readDir lists dir, "." and ".." first and the rest in the order of
+// their name cookies
+func (m *MemFileSystem) readDir(op string, dir string, cookie int64, count int, plus bool) ([]fs.DirEntry, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, err := m.dir(dir)
+	if err != nil {
+		return nil, 0, fs.NewError(op, dir, err)
+	}
+	parent := d.parent
+	if parent == nil {
+		parent = d
+	}
+	children := make([]fs.DirEntry, 0, len(d.children))
+	for name, n := range d.children {
+		children = append(children, fs.DirEntry{Name: name, FileId: n.ino})
+	}
+	fs.SetNameCookies(children)
+	all := append([]fs.DirEntry{
+		{Name: ".", FileId: d.ino, Cookie: 1},
+		{Name: "..", FileId: parent.ino, Cookie: 2},
+	}, children...)
+
+	var entries []fs.DirEntry
+	for _, entry := range all {
+		if entry.Cookie <= cookie || len(entries) >= count {
+			continue
+		}
+		if plus {
+			n := d
+			switch entry.Name {
+			case ".":
+			case "..":
+				n = parent
+			default:
+				n = d.children[entry.Name]
+			}
+			info := m.stat(n)
+			entry.Attributes = &info
+		}
+		entries = append(entries, entry)
+	}
+	next := cookie
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Cookie
+	}
+	return entries, next, nil
+}
+
+func (m *MemFileSystem) ReadDir(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return m.readDir("ReadDir", dir, cookie, count, false)
+}
+
+func (m *MemFileSystem) ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return m.readDir("ReadDirPlus", dir, cookie, count, true)
+}
+
+func (m *MemFileSystem) Rename(ctx context.Context, oldPath string, newPath string, flags fs.RenameFlags) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if flags&fs.RenameNoReplace != 0 && flags&fs.RenameExchange != 0 {
+		return fs.NewError("Rename", oldPath, fs.ErrInvalidName)
+	}
+	srcDir, srcName, n, err := m.entry(oldPath)
+	if err != nil {
+		return fs.NewError("Rename", oldPath, err)
+	}
+	dirPath, name := path.Split(path.Clean("/" + newPath))
+	if name == "" {
+		return fs.NewError("Rename", newPath, fs.ErrInvalidName)
+	}
+	d, err := m.dir(dirPath)
+	if err != nil {
+		return fs.NewError("Rename", newPath, err)
+	}
+	for a := d; a != nil; a = a.parent {
+		if a == n {
+			return fs.NewError("Rename", newPath, fs.ErrInvalidName)
+		}
+	}
+	target, exists := d.children[name]
+
+	if flags&fs.RenameExchange != 0 {
+		if !exists {
+			return fs.NewError("Rename", newPath, fs.ErrNotExist)
+		}
+		if target == n {
+			return nil
+		}
+		for a := srcDir; a != nil; a = a.parent {
+			if a == target {
+				return fs.NewError("Rename", oldPath, fs.ErrInvalidName)
+			}
+		}
+		m.detach(srcDir, srcName)
+		m.detach(d, name)
+		m.link(srcDir, srcName, target)
+		m.link(d, name, n)
+		m.touch(target)
+		m.touch(n)
+		return nil
+	}
+
+	if exists {
+		switch {
+		case target == n:
+			return nil
+		case flags&fs.RenameNoReplace != 0:
+			return fs.NewError("Rename", newPath, fs.ErrExist)
+		case target.children != nil && n.children == nil:
+			return fs.NewError("Rename", newPath, fs.ErrIsDir)
+		case target.children == nil && n.children != nil:
+			return fs.NewError("Rename", newPath, fs.ErrNotDir)
+		case len(target.children) > 0:
+			return fs.NewError("Rename", newPath, fs.ErrNotEmpty)
+		}
+		m.unlink(d, name)
+	}
+	m.detach(srcDir, srcName)
+	m.link(d, name, n)
+	m.touch(n)
+	return nil
+}
+
+func (m *MemFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.add("Symlink", dir, name, fs.FileTypeSymlink, attr)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	n.target = target
+	return pathOf(n), m.stat(n), nil
+}
+
+func (m *MemFileSystem) Readlink(ctx context.Context, p string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return "", fs.NewError("Readlink", p, err)
+	}
+	if n.info.Type != fs.FileTypeSymlink {
+		return "", fs.NewError("Readlink", p, fs.ErrInvalidName)
+	}
+	return n.target, nil
+}
+
+func (m *MemFileSystem) Link(ctx context.Context, p string, dir string, name string) (string, fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return "", fs.FileInfo{}, fs.NewError("Link", p, err)
+	}
+	if n.children != nil {
+		return "", fs.FileInfo{}, fs.NewError("Link", p, fs.ErrIsDir)
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", fs.FileInfo{}, fs.NewError("Link", dir, fs.ErrInvalidName)
+	}
+	d, err := m.dir(dir)
+	if err != nil {
+		return "", fs.FileInfo{}, fs.NewError("Link", dir, err)
+	}
+	if _, ok := d.children[name]; ok {
+		return "", fs.FileInfo{}, fs.NewError("Link", path.Join(dir, name), fs.ErrExist)
+	}
+	m.link(d, name, n)
+	m.touch(n)
+	return path.Join(pathOf(d), name), m.stat(n), nil
+}
+
+func (m *MemFileSystem) StatFS(ctx context.Context) (fs.FSStat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var used uint64
+	for _, n := range m.byIno {
+		used += uint64(len(n.data))
+	}
+	const total = 1 << 30
+	free := total - min(used, total)
+	return fs.FSStat{TotalBytes: total, FreeBytes: free, AvailBytes: free,
+		TotalFiles: 1 << 20, FreeFiles: 1<<20 - uint64(len(m.byIno)), NameMaxLength: 255}, nil
+}
+
+func (m *MemFileSystem) FileHandleToPath(ctx context.Context, fh []byte) (string, error) {
+	h, err := fs.DeserializeFileHandle(fh)
+	if err != nil || h.FileSystemID != fileSystemID {
+		return "", fs.NewError("FileHandleToPath", "", fs.ErrInvalidHandle)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.byIno[h.Inode]
+	if !ok || n.gen != h.Generation {
+		return "", fs.NewInodeError("FileHandleToPath", "", h.Inode, fs.ErrStale)
+	}
+	return pathOf(n), nil
+}
+
+func (m *MemFileSystem) PathToFileHandle(ctx context.Context, p string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.walk(p)
+	if err != nil {
+		return nil, fs.NewError("PathToFileHandle", p, err)
+	}
+	h := fs.FileHandle{FileSystemID: fileSystemID, Inode: n.ino, Generation: n.gen}
+	return h.Serialize(), nil
+}
+
+func (m *MemFileSystem) Commit(ctx context.Context, p string, offset int64, count int64) error {
+	return nil // Nothing is more stable than memory here
+}
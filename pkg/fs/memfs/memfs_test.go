@@ -0,0 +1,119 @@
+package memfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestHardLinks(t *testing.T) {
+	memFS := NewMemFileSystem()
+	ctx := context.Background()
+	if _, _, err := memFS.Mkdir(ctx, "/", "dir", fs.FileAttr{}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := memFS.Create(ctx, "/", "file", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	handle, err := memFS.PathToFileHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+
+	// Both names are the same file
+	linkPath, info, err := memFS.Link(ctx, "/file", "/dir", "link")
+	if err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if linkPath != "/dir/link" || info.Nlink != 2 {
+		t.Errorf("Link = %s, nlink %d; want /dir/link, 2", linkPath, info.Nlink)
+	}
+	if _, err := memFS.Write(ctx, "/dir/link", 0, []byte("shared"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _, err := memFS.Read(ctx, "/file", 0, 10); err != nil || string(data) != "shared" {
+		t.Errorf("Read through the other name = %q, %v; want \"shared\"", data, err)
+	}
+	if _, _, err := memFS.Link(ctx, "/dir", "/", "dirlink"); !errors.Is(err, fs.ErrIsDir) {
+		t.Errorf("Link of a directory = %v, want ErrIsDir", err)
+	}
+	if _, _, err := memFS.Link(ctx, "/file", "/dir", "link"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Link over an existing name = %v, want ErrExist", err)
+	}
+
+	// Removing the name a handle resolves to leaves it resolving to the other
+	if err := memFS.Remove(ctx, "/file"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if p, err := memFS.FileHandleToPath(ctx, handle); err != nil || p != "/dir/link" {
+		t.Errorf("FileHandleToPath after removing a name = %q, %v; want /dir/link", p, err)
+	}
+	if info, err := memFS.GetAttr(ctx, "/dir/link"); err != nil || info.Nlink != 1 {
+		t.Errorf("GetAttr = nlink %d, %v; want 1", info.Nlink, err)
+	}
+
+	// Removing the last name forgets the file
+	if err := memFS.Remove(ctx, "/dir/link"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := memFS.FileHandleToPath(ctx, handle); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("FileHandleToPath of a removed file = %v, want ErrStale", err)
+	}
+}
+
+func TestRenameExchange(t *testing.T) {
+	memFS := NewMemFileSystem()
+	ctx := context.Background()
+	if _, _, err := memFS.Mkdir(ctx, "/", "dir", fs.FileAttr{}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, _, err := memFS.Create(ctx, "/dir", "file", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, _, err := memFS.Symlink(ctx, "/", "link", "target", fs.FileAttr{}); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	if err := memFS.Rename(ctx, "/dir/file", "/link", fs.RenameExchange); err != nil {
+		t.Fatalf("Rename with RenameExchange failed: %v", err)
+	}
+	if info, err := memFS.GetAttr(ctx, "/link"); err != nil || info.Type != fs.FileTypeRegular {
+		t.Errorf("/link = %v, %v; want the regular file", info.Type, err)
+	}
+	if target, err := memFS.Readlink(ctx, "/dir/file"); err != nil || target != "target" {
+		t.Errorf("Readlink(/dir/file) = %q, %v; want the symlink", target, err)
+	}
+
+	if err := memFS.Rename(ctx, "/dir", "/dir/file", fs.RenameExchange); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Exchanging a directory with its entry = %v, want ErrInvalidName", err)
+	}
+	if err := memFS.Rename(ctx, "/link", "/missing", fs.RenameExchange); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Exchanging with a missing file = %v, want ErrNotExist", err)
+	}
+}
+
+func TestStatFS(t *testing.T) {
+	memFS := NewMemFileSystem()
+	ctx := context.Background()
+	before, err := memFS.StatFS(ctx)
+	if err != nil {
+		t.Fatalf("StatFS failed: %v", err)
+	}
+	if _, _, err := memFS.Create(ctx, "/", "file", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := memFS.Write(ctx, "/file", 0, make([]byte, 4096), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	after, err := memFS.StatFS(ctx)
+	if err != nil {
+		t.Fatalf("StatFS failed: %v", err)
+	}
+	if after.FreeBytes != before.FreeBytes-4096 || after.FreeFiles != before.FreeFiles-1 {
+		t.Errorf("StatFS after a 4096-byte file = %d bytes, %d files free; want %d, %d",
+			after.FreeBytes, after.FreeFiles, before.FreeBytes-4096, before.FreeFiles-1)
+	}
+}
@@ -0,0 +1,134 @@
+package memfs
+
+import (
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// LoadDir copies the tree under dir on the local disk into the file system,
+// with its modes, owners and times, so a fixture can be served without the
+// disk being touched again. Files already present are replaced. Hard links
+// within the tree stay links; devices, sockets and pipes are refused.
+func (m *MemFileSystem) LoadDir(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Directories are modified as their entries are added, so their times
+	// are set once the walk is done
+	type dirTime struct {
+		n     *memNode
+		mtime time.Time
+	}
+	var dirs []dirTime
+
+	// Files with several names, by host inode, so later names link to them
+	linked := make(map[hostInode]*memNode)
+	err := filepath.WalkDir(dir, func(hostPath string, entry iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, hostPath)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		var n *memNode
+		if rel == "." {
+			n = m.root
+		} else {
+			ino, hasLinks := hostLinks(info)
+			n, err = m.seedNode(hostPath, filepath.ToSlash(rel), info, linked[ino])
+			if err != nil {
+				return err
+			}
+			if hasLinks && info.Mode().IsRegular() {
+				linked[ino] = n
+			}
+		}
+		n.info.Mode = fs.FileMode(info.Mode().Perm())
+		if info.Mode()&os.ModeSetuid != 0 {
+			n.info.Mode |= 04000
+		}
+		if info.Mode()&os.ModeSetgid != 0 {
+			n.info.Mode |= 02000
+		}
+		if info.Mode()&os.ModeSticky != 0 {
+			n.info.Mode |= 01000
+		}
+		n.info.ModifyTime = info.ModTime()
+		if uid, gid, atime, ok := hostStat(info); ok {
+			n.info.Uid, n.info.Gid = uid, gid
+			n.info.AccessTime = atime
+		}
+		if n.children != nil {
+			dirs = append(dirs, dirTime{n, info.ModTime()})
+		}
+		return nil
+	})
+	for _, d := range dirs {
+		d.n.info.ModifyTime = d.mtime
+	}
+	return err
+}
+
+// seedNode creates the node at rel, the path from the fixture's root of the
+// file at hostPath, replacing anything already there. A regular file is
+// entered as a link to linkTo, if set, another name of the same file.
+func (m *MemFileSystem) seedNode(hostPath string, rel string, info iofs.FileInfo, linkTo *memNode) (*memNode, error) {
+	dir, name := path.Split("/" + rel)
+	if d, _, existing, err := m.entry("/" + rel); err == nil {
+		if existing.children != nil && info.IsDir() {
+			return existing, nil
+		}
+		if len(existing.children) > 0 {
+			return nil, fmt.Errorf("seeding %s: a non-empty directory is in the way", hostPath)
+		}
+		m.unlink(d, name)
+	}
+
+	switch {
+	case linkTo != nil && info.Mode().IsRegular():
+		d, err := m.dir(dir)
+		if err != nil {
+			return nil, err
+		}
+		m.link(d, name, linkTo)
+		return linkTo, nil
+	case info.IsDir():
+		return m.add("LoadDir", dir, name, fs.FileTypeDirectory, fs.FileAttr{})
+	case info.Mode().IsRegular():
+		data, err := os.ReadFile(hostPath)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.add("LoadDir", dir, name, fs.FileTypeRegular, fs.FileAttr{})
+		if err != nil {
+			return nil, err
+		}
+		n.data = data
+		return n, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.add("LoadDir", dir, name, fs.FileTypeSymlink, fs.FileAttr{})
+		if err != nil {
+			return nil, err
+		}
+		n.target = target
+		return n, nil
+	default:
+		return nil, fmt.Errorf("seeding %s: %v files are not supported", hostPath, info.Mode().Type())
+	}
+}
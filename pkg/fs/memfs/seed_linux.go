@@ -0,0 +1,34 @@
+//go:build linux
+
+package memfs
+
+import (
+	iofs "io/fs"
+	"syscall"
+	"time"
+)
+
+// hostInode identifies a file on the local disk
+type hostInode struct {
+	dev uint64
+	ino uint64
+}
+
+// hostStat returns the owner and access time of a file on the local disk
+func hostStat(info iofs.FileInfo) (uid uint32, gid uint32, atime time.Time, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, time.Time{}, false
+	}
+	return st.Uid, st.Gid, time.Unix(st.Atim.Unix()), true
+}
+
+// hostLinks returns the inode of a file on the local disk, and whether it
+// has other names
+func hostLinks(info iofs.FileInfo) (hostInode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return hostInode{}, false
+	}
+	return hostInode{dev: uint64(st.Dev), ino: st.Ino}, st.Nlink > 1
+}
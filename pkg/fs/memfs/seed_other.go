@@ -0,0 +1,23 @@
+//go:build !linux
+
+package memfs
+
+import (
+	iofs "io/fs"
+	"time"
+)
+
+// hostInode identifies a file on the local disk; outside Linux nothing does
+type hostInode struct{}
+
+// hostStat reports nothing outside Linux: files loaded from the local
+// disk keep the owner and access time they were created with
+func hostStat(info iofs.FileInfo) (uid uint32, gid uint32, atime time.Time, ok bool) {
+	return 0, 0, time.Time{}, false
+}
+
+// hostLinks reports no links outside Linux, so hard links loaded from the
+// local disk become separate copies
+func hostLinks(info iofs.FileInfo) (hostInode, bool) {
+	return hostInode{}, false
+}
@@ -0,0 +1,95 @@
+package memfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+func TestLoadDir(t *testing.T) {
+	fixture := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(fixture, "docs", "empty"), 0750); err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(fixture, "docs", "readme.txt"), []byte("fixture data"), 0640); err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	if err := os.Symlink("docs/readme.txt", filepath.Join(fixture, "link")); err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	if err := os.Link(filepath.Join(fixture, "docs", "readme.txt"), filepath.Join(fixture, "hardlink")); err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, p := range []string{"docs/readme.txt", "docs"} {
+		if err := os.Chtimes(filepath.Join(fixture, p), mtime, mtime); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+
+	memFS := NewMemFileSystem()
+	if err := memFS.LoadDir(fixture); err != nil {
+		t.Fatalf("LoadDir failed: %v", err)
+	}
+	ctx := context.Background()
+
+	data, eof, err := memFS.Read(ctx, "/docs/readme.txt", 0, 100)
+	if err != nil || !eof || string(data) != "fixture data" {
+		t.Errorf("Read = %q, %v, %v, want the fixture's contents", data, eof, err)
+	}
+	info, err := memFS.GetAttr(ctx, "/docs/readme.txt")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if info.Mode != 0640 || !info.ModifyTime.Equal(mtime) || info.Uid != uint32(os.Getuid()) {
+		t.Errorf("File attributes = mode %o, mtime %v, uid %d, want 0640, %v, %d", info.Mode, info.ModifyTime, info.Uid, mtime, os.Getuid())
+	}
+
+	// Directory times survive their entries being added
+	info, err = memFS.GetAttr(ctx, "/docs")
+	if err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if info.Type != fs.FileTypeDirectory || info.Mode != 0750 || !info.ModifyTime.Equal(mtime) {
+		t.Errorf("Directory attributes = type %v, mode %o, mtime %v, want a directory, 0750, %v", info.Type, info.Mode, info.ModifyTime, mtime)
+	}
+	if empty, err := memFS.IsEmpty(ctx, "/docs/empty"); err != nil || !empty {
+		t.Errorf("IsEmpty(/docs/empty) = %v, %v, want an empty directory", empty, err)
+	}
+	if target, err := memFS.Readlink(ctx, "/link"); err != nil || target != "docs/readme.txt" {
+		t.Errorf("Readlink = %q, %v, want docs/readme.txt", target, err)
+	}
+	if info, err := memFS.GetAttr(ctx, "/hardlink"); err != nil || info.Nlink != 2 {
+		t.Errorf("GetAttr(/hardlink) = nlink %d, %v, want the fixture's hard link", info.Nlink, err)
+	}
+
+	// Changes stay in memory
+	if _, err := memFS.Write(ctx, "/docs/readme.txt", 0, []byte("changed"), true); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := memFS.Remove(ctx, "/link"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(fixture, "docs", "readme.txt")); err != nil || string(data) != "fixture data" {
+		t.Errorf("Fixture file now holds %q, %v, want it untouched", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(fixture, "link")); err != nil {
+		t.Errorf("Fixture symlink gone: %v", err)
+	}
+
+	// Loading again restores the fixture over the changes
+	if err := memFS.LoadDir(fixture); err != nil {
+		t.Fatalf("Second LoadDir failed: %v", err)
+	}
+	if data, _, err := memFS.Read(ctx, "/docs/readme.txt", 0, 100); err != nil || string(data) != "fixture data" {
+		t.Errorf("Read after reloading = %q, %v, want the fixture's contents", data, err)
+	}
+
+	if err := NewMemFileSystem().LoadDir(filepath.Join(fixture, "missing")); err == nil {
+		t.Error("LoadDir of a missing directory succeeded")
+	}
+}
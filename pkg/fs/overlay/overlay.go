@@ -0,0 +1,764 @@
+// Package overlay layers a writable file system over read-only ones, as
+// overlayfs does, so a read-only tree can be served to several tenants,
+// each seeing only their own changes to it.
+package overlay
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+const (
+	// upper is the layer changes are made in
+	upper = 0
+
+	// whiteoutPrefix starts the name of an entry of the upper layer that
+	// hides the entry named by the rest of it in the layers below
+	whiteoutPrefix = ".wh."
+
+	// opaqueName is an entry of a directory of the upper layer that hides
+	// the directories at its path in the layers below
+	opaqueName = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+	// fileSystemIDMask tells the handles of an overlay from those of its
+	// upper layer ("ovly")
+	fileSystemIDMask = 0x6f766c79
+
+	// copyChunk is how much of a file is read at a time while copying it
+	// up
+	copyChunk = 1 << 20
+
+	// listBatch is how many entries are listed at a time from a layer
+	listBatch = 1024
+)
+
+// OverlayFileSystem merges layers of file systems into one tree. Lookups
+// find the topmost layer holding a name; directories found in several
+// layers list the entries of all of them. Every change is made in the
+// upper layer: files of the layers below are copied up before they are
+// changed, and names removed from them are hidden by whiteouts, entries
+// called ".wh.<name>". Names starting with ".wh." cannot be used.
+type OverlayFileSystem struct {
+	layers []fs.FileSystem // The upper layer first, then the lower ones
+	fsid   uint32
+
+	// Held while the tree is changed, so copy-ups and whiteouts of a name
+	// do not race
+	mu sync.Mutex
+
+	// The upper handle of each lower file copied up, by its overlay handle,
+	// so handles given out before the copy still find it. Guarded by mu.
+	origins map[string][]byte
+}
+
+// node is what a path names in the overlay
+type node struct {
+	layers []int       // The layers holding it, the topmost first; several only for directories
+	info   fs.FileInfo // Its attributes in the topmost layer
+}
+
+// NewOverlayFileSystem layers upper over lowers, the first of which is the
+// topmost. Only upper is changed.
+func NewOverlayFileSystem(ctx context.Context, upper fs.FileSystem, lowers ...fs.FileSystem) (*OverlayFileSystem, error) {
+	rootHandle, err := upper.PathToFileHandle(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	h, err := fs.DeserializeFileHandle(rootHandle)
+	if err != nil {
+		return nil, fs.NewError("init", "/", err)
+	}
+	return &OverlayFileSystem{
+		layers:  append([]fs.FileSystem{upper}, lowers...),
+		fsid:    h.FileSystemID ^ fileSystemIDMask,
+		origins: make(map[string][]byte),
+	}, nil
+}
+
+// isWhiteout reports whether name is reserved for whiteouts
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(name, whiteoutPrefix)
+}
+
+// fileID returns the file ID of the file with id in layer, unique across
+// layers as long as IDs fit in 56 bits
+func fileID(layer int, id uint64) uint64 {
+	return id ^ uint64(layer)<<56
+}
+
+// attrs returns the attributes of n, as the overlay presents them
+func (n node) attrs() fs.FileInfo {
+	info := n.info
+	info.FileId = fileID(n.layers[0], info.FileId)
+	return info
+}
+
+// exists reports whether the entry p exists in layer
+func (o *OverlayFileSystem) exists(ctx context.Context, layer int, p string) bool {
+	_, err := o.layers[layer].GetAttr(ctx, p)
+	return err == nil
+}
+
+// resolve finds what p names, merging the layers
+func (o *OverlayFileSystem) resolve(ctx context.Context, p string) (node, error) {
+	p = path.Clean("/" + p)
+	root, err := o.layers[upper].GetAttr(ctx, "/")
+	if err != nil {
+		return node{}, err
+	}
+	n := node{info: root}
+	for layer := range o.layers {
+		n.layers = append(n.layers, layer)
+	}
+	if p == "/" {
+		return n, nil
+	}
+
+	dir := "/"
+	for _, name := range strings.Split(p[1:], "/") {
+		if n.info.Type != fs.FileTypeDirectory {
+			return node{}, fs.NewError("resolve", p, fs.ErrNotDir)
+		}
+		if isWhiteout(name) {
+			return node{}, fs.NewError("resolve", p, fs.ErrNotExist)
+		}
+		if n, err = o.child(ctx, n, dir, name); err != nil {
+			return node{}, err
+		}
+		dir = path.Join(dir, name)
+	}
+	return n, nil
+}
+
+// child finds the entry name of the merged directory d at dir. A file
+// hides the entries of the layers below it, as does a whiteout, and an
+// opaque directory, or one made over a whiteout, the directories below it.
+func (o *OverlayFileSystem) child(ctx context.Context, d node, dir string, name string) (node, error) {
+	p := path.Join(dir, name)
+	var n node
+	for _, layer := range d.layers {
+		info, err := o.layers[layer].GetAttr(ctx, p)
+		if errors.Is(err, fs.ErrNotExist) {
+			if o.exists(ctx, layer, path.Join(dir, whiteoutPrefix+name)) {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			return node{}, err
+		}
+		if len(n.layers) > 0 && info.Type != fs.FileTypeDirectory {
+			break
+		}
+		if len(n.layers) == 0 {
+			n.info = info
+		}
+		n.layers = append(n.layers, layer)
+		if info.Type != fs.FileTypeDirectory || o.exists(ctx, layer, path.Join(p, opaqueName)) ||
+			o.exists(ctx, layer, path.Join(dir, whiteoutPrefix+name)) {
+			break
+		}
+	}
+	if len(n.layers) == 0 {
+		return node{}, fs.NewError("Lookup", p, fs.ErrNotExist)
+	}
+	return n, nil
+}
+
+// copyUpLocked makes sure the file at p is in the upper layer, copying it
+// and its directories there from the layer holding it; o.mu must be held
+func (o *OverlayFileSystem) copyUpLocked(ctx context.Context, p string) (node, error) {
+	p = path.Clean("/" + p)
+	n, err := o.resolve(ctx, p)
+	if err != nil || n.layers[0] == upper {
+		return n, err
+	}
+	dir, name := path.Dir(p), path.Base(p)
+	if _, err := o.copyUpLocked(ctx, dir); err != nil {
+		return node{}, err
+	}
+
+	layer := o.layers[n.layers[0]]
+	info := n.info
+	// Written before its mode is set, in case that forbids writing
+	mode := info.Mode | 0200
+	attr := fs.FileAttr{Mode: &mode, Uid: &info.Uid, Gid: &info.Gid}
+	switch info.Type {
+	case fs.FileTypeDirectory:
+		_, _, err = o.layers[upper].Mkdir(ctx, dir, name, attr)
+	case fs.FileTypeSymlink:
+		var target string
+		if target, err = layer.Readlink(ctx, p); err == nil {
+			_, _, err = o.layers[upper].Symlink(ctx, dir, name, target, attr)
+		}
+	case fs.FileTypeRegular:
+		if _, _, err = o.layers[upper].Create(ctx, dir, name, attr, true); err == nil {
+			if err = o.copyData(ctx, layer, p); err != nil {
+				o.layers[upper].Remove(ctx, p)
+			}
+		}
+	default:
+		err = fs.NewError("copy up", p, fs.ErrNotSupported)
+	}
+	if err != nil {
+		return node{}, err
+	}
+	if info.Type != fs.FileTypeSymlink {
+		if _, err := o.layers[upper].SetAttr(ctx, p, fs.FileAttr{Mode: &info.Mode,
+			AccessTime: &info.AccessTime, ModifyTime: &info.ModifyTime}); err != nil {
+			return node{}, err
+		}
+	}
+
+	if lowerHandle, err := layer.PathToFileHandle(ctx, p); err == nil {
+		if upperHandle, err := o.layers[upper].PathToFileHandle(ctx, p); err == nil {
+			o.origins[string(o.handle(n.layers[0], lowerHandle))] = upperHandle
+		}
+	}
+	return o.resolve(ctx, p)
+}
+
+// copyData copies the contents of the file at p in layer to the upper one
+func (o *OverlayFileSystem) copyData(ctx context.Context, layer fs.FileSystem, p string) error {
+	for offset := int64(0); ; {
+		data, eof, err := layer.Read(ctx, p, offset, copyChunk)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			if _, err := o.layers[upper].Write(ctx, p, offset, data, false); err != nil {
+				return err
+			}
+			offset += int64(len(data))
+		}
+		if eof || len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// copyUp is copyUpLocked for files about to be changed in place
+func (o *OverlayFileSystem) copyUp(ctx context.Context, p string) (node, error) {
+	n, err := o.resolve(ctx, p)
+	if err != nil || n.layers[0] == upper {
+		return n, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.copyUpLocked(ctx, p)
+}
+
+// parentLocked copies the directory an entry is to be made in up, checking
+// the entry's name; o.mu must be held
+func (o *OverlayFileSystem) parentLocked(ctx context.Context, op string, dir string, name string) error {
+	if isWhiteout(name) {
+		return fs.NewError(op, path.Join(dir, name), fs.ErrInvalidName)
+	}
+	d, err := o.copyUpLocked(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if d.info.Type != fs.FileTypeDirectory {
+		return fs.NewError(op, dir, fs.ErrNotDir)
+	}
+	return nil
+}
+
+// hideLocked hides what the layers below still hold at p once the upper
+// layer holds nothing there; o.mu must be held
+func (o *OverlayFileSystem) hideLocked(ctx context.Context, p string) error {
+	if _, err := o.resolve(ctx, p); err != nil {
+		return nil
+	}
+	_, _, err := o.layers[upper].Create(ctx, path.Dir(p), whiteoutPrefix+path.Base(p), fs.FileAttr{}, false)
+	return err
+}
+
+// opaqueLocked hides the directories the layers below hold at p, where the
+// upper layer has just made one; o.mu must be held
+func (o *OverlayFileSystem) opaqueLocked(ctx context.Context, p string) error {
+	n, err := o.resolve(ctx, p)
+	if err != nil || len(n.layers) == 1 {
+		return err
+	}
+	_, _, err = o.layers[upper].Create(ctx, p, opaqueName, fs.FileAttr{}, false)
+	return err
+}
+
+// clearLocked removes the whiteouts from the directory at p of the upper
+// layer, so it can be removed or replaced; o.mu must be held
+func (o *OverlayFileSystem) clearLocked(ctx context.Context, p string) error {
+	entries, err := o.readAll(ctx, upper, p, false)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if isWhiteout(entry.Name) {
+			if err := o.layers[upper].Remove(ctx, path.Join(p, entry.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (o *OverlayFileSystem) GetAttr(ctx context.Context, p string) (fs.FileInfo, error) {
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return fs.FileInfo{}, err
+	}
+	return n.attrs(), nil
+}
+
+func (o *OverlayFileSystem) SetAttr(ctx context.Context, p string, attr fs.FileAttr) (fs.FileInfo, error) {
+	if _, err := o.copyUp(ctx, p); err != nil {
+		return fs.FileInfo{}, err
+	}
+	return o.layers[upper].SetAttr(ctx, p, attr)
+}
+
+func (o *OverlayFileSystem) Lookup(ctx context.Context, dir string, name string) (string, fs.FileInfo, error) {
+	p := path.Join(dir, name)
+	info, err := o.GetAttr(ctx, p)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+func (o *OverlayFileSystem) Access(ctx context.Context, p string, mode fs.FileMode, creds fs.Credentials) error {
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	return o.layers[n.layers[0]].Access(ctx, p, mode, creds)
+}
+
+func (o *OverlayFileSystem) Read(ctx context.Context, p string, offset int64, length int) ([]byte, bool, error) {
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return nil, false, err
+	}
+	return o.layers[n.layers[0]].Read(ctx, p, offset, length)
+}
+
+func (o *OverlayFileSystem) Write(ctx context.Context, p string, offset int64, data []byte, sync bool) (int, error) {
+	if _, err := o.copyUp(ctx, p); err != nil {
+		return 0, err
+	}
+	return o.layers[upper].Write(ctx, p, offset, data, sync)
+}
+
+func (o *OverlayFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.parentLocked(ctx, "Create", dir, name); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	p := path.Join(dir, name)
+	if _, err := o.resolve(ctx, p); err == nil {
+		if excl {
+			return "", fs.FileInfo{}, fs.NewError("Create", p, fs.ErrExist)
+		}
+		// Truncated in the upper layer
+		if _, err := o.copyUpLocked(ctx, p); err != nil {
+			return "", fs.FileInfo{}, err
+		}
+	}
+	return o.layers[upper].Create(ctx, dir, name, attr, excl)
+}
+
+func (o *OverlayFileSystem) Remove(ctx context.Context, p string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	if n.info.Type == fs.FileTypeDirectory {
+		return fs.NewError("Remove", p, fs.ErrIsDir)
+	}
+	if _, err := o.copyUpLocked(ctx, path.Dir(p)); err != nil {
+		return err
+	}
+	if n.layers[0] == upper {
+		if err := o.layers[upper].Remove(ctx, p); err != nil {
+			return err
+		}
+	}
+	return o.hideLocked(ctx, p)
+}
+
+func (o *OverlayFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.parentLocked(ctx, "Mkdir", dir, name); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	p := path.Join(dir, name)
+	if _, err := o.resolve(ctx, p); err == nil {
+		return "", fs.FileInfo{}, fs.NewError("Mkdir", p, fs.ErrExist)
+	}
+	p, info, err := o.layers[upper].Mkdir(ctx, dir, name, attr)
+	if err != nil {
+		return p, info, err
+	}
+	if err := o.opaqueLocked(ctx, p); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+func (o *OverlayFileSystem) Rmdir(ctx context.Context, p string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	if n.info.Type != fs.FileTypeDirectory {
+		return fs.NewError("Rmdir", p, fs.ErrNotDir)
+	}
+	if entries, err := o.list(ctx, n, p, false); err != nil {
+		return err
+	} else if len(entries) > 0 {
+		return fs.NewError("Rmdir", p, fs.ErrNotEmpty)
+	}
+	if _, err := o.copyUpLocked(ctx, path.Dir(p)); err != nil {
+		return err
+	}
+	if n.layers[0] == upper {
+		if err := o.clearLocked(ctx, p); err != nil {
+			return err
+		}
+		if err := o.layers[upper].Rmdir(ctx, p); err != nil {
+			return err
+		}
+	}
+	return o.hideLocked(ctx, p)
+}
+
+// readAll lists the whole directory at dir of layer, without "." and ".."
+func (o *OverlayFileSystem) readAll(ctx context.Context, layer int, dir string, plus bool) ([]fs.DirEntry, error) {
+	readDir := o.layers[layer].ReadDir
+	if plus {
+		readDir = o.layers[layer].ReadDirPlus
+	}
+	var all []fs.DirEntry
+	for cookie := int64(0); ; {
+		entries, next, err := readDir(ctx, dir, cookie, listBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Name != "." && entry.Name != ".." {
+				all = append(all, entry)
+			}
+		}
+		if len(entries) == 0 || next == cookie {
+			return all, nil
+		}
+		cookie = next
+	}
+}
+
+// list returns the entries of the merged directory d at dir, without "."
+// and "..", in no particular order; with plus set they carry their
+// attributes
+func (o *OverlayFileSystem) list(ctx context.Context, d node, dir string, plus bool) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	hidden := make(map[string]bool)
+	var merged []fs.DirEntry
+	for _, layer := range d.layers {
+		entries, err := o.readAll(ctx, layer, dir, plus)
+		if err != nil {
+			return nil, err
+		}
+		var whiteouts []string
+		for _, entry := range entries {
+			if isWhiteout(entry.Name) {
+				if entry.Name != opaqueName {
+					whiteouts = append(whiteouts, strings.TrimPrefix(entry.Name, whiteoutPrefix))
+				}
+				continue
+			}
+			if seen[entry.Name] || hidden[entry.Name] {
+				continue
+			}
+			seen[entry.Name] = true
+			entry.FileId = fileID(layer, entry.FileId)
+			if entry.Attributes != nil {
+				info := *entry.Attributes
+				info.FileId = entry.FileId
+				entry.Attributes = &info
+			}
+			merged = append(merged, entry)
+		}
+		// A whiteout hides the entries of the layers below its own
+		for _, name := range whiteouts {
+			hidden[name] = true
+		}
+	}
+	return merged, nil
+}
+
+func (o *OverlayFileSystem) EntryCount(ctx context.Context, dir string) (uint64, error) {
+	n, err := o.resolve(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	if n.info.Type != fs.FileTypeDirectory {
+		return 0, fs.NewError("EntryCount", dir, fs.ErrNotDir)
+	}
+	entries, err := o.list(ctx, n, dir, false)
+	return uint64(len(entries)), err
+}
+
+func (o *OverlayFileSystem) IsEmpty(ctx context.Context, dir string) (bool, error) {
+	count, err := o.EntryCount(ctx, dir)
+	return count == 0, err
+}
+
+// readDir lists dir, "." and ".." first and the rest in the order of
+// their name cookies. Each page merges the whole directory again.
+func (o *OverlayFileSystem) readDir(ctx context.Context, op string, dir string, cookie int64, count int, plus bool) ([]fs.DirEntry, int64, error) {
+	dir = path.Clean("/" + dir)
+	d, err := o.resolve(ctx, dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.info.Type != fs.FileTypeDirectory {
+		return nil, 0, fs.NewError(op, dir, fs.ErrNotDir)
+	}
+	parent, err := o.resolve(ctx, path.Dir(dir))
+	if err != nil {
+		return nil, 0, err
+	}
+	children, err := o.list(ctx, d, dir, plus)
+	if err != nil {
+		return nil, 0, err
+	}
+	fs.SetNameCookies(children)
+	self, up := d.attrs(), parent.attrs()
+	all := append([]fs.DirEntry{
+		{Name: ".", FileId: self.FileId, Cookie: 1},
+		{Name: "..", FileId: up.FileId, Cookie: 2},
+	}, children...)
+	if plus {
+		all[0].Attributes, all[1].Attributes = &self, &up
+	}
+
+	var entries []fs.DirEntry
+	for _, entry := range all {
+		if entry.Cookie <= cookie || len(entries) >= count {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	next := cookie
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Cookie
+	}
+	return entries, next, nil
+}
+
+func (o *OverlayFileSystem) ReadDir(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return o.readDir(ctx, "ReadDir", dir, cookie, count, false)
+}
+
+func (o *OverlayFileSystem) ReadDirPlus(ctx context.Context, dir string, cookie int64, count int) ([]fs.DirEntry, int64, error) {
+	return o.readDir(ctx, "ReadDirPlus", dir, cookie, count, true)
+}
+
+// Rename renames within the upper layer, copying the source up first.
+// Directories the layers below hold cannot be renamed, as their entries
+// would have to be copied up too; they fail with ERR_XDEV, as they do on
+// overlayfs, for clients to copy them instead.
+func (o *OverlayFileSystem) Rename(ctx context.Context, oldPath string, newPath string, flags fs.RenameFlags) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	oldPath, newPath = path.Clean("/"+oldPath), path.Clean("/"+newPath)
+	if isWhiteout(path.Base(newPath)) {
+		return fs.NewError("Rename", newPath, fs.ErrInvalidName)
+	}
+	src, err := o.resolve(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	dst, dstErr := o.resolve(ctx, newPath)
+	if dstErr != nil && !errors.Is(dstErr, fs.ErrNotExist) {
+		return dstErr
+	}
+	merged := func(n node) bool {
+		return n.info.Type == fs.FileTypeDirectory && (len(n.layers) > 1 || n.layers[0] != upper)
+	}
+	if merged(src) || (flags&fs.RenameExchange != 0 && dstErr == nil && merged(dst)) {
+		return fs.NewStatusError("Rename", oldPath, api.Status_ERR_XDEV, fs.ErrNotSupported)
+	}
+
+	if flags&fs.RenameExchange != 0 {
+		if dstErr != nil {
+			return dstErr
+		}
+		if _, err := o.copyUpLocked(ctx, oldPath); err != nil {
+			return err
+		}
+		if _, err := o.copyUpLocked(ctx, newPath); err != nil {
+			return err
+		}
+		return o.layers[upper].Rename(ctx, oldPath, newPath, flags)
+	}
+
+	if dstErr == nil {
+		switch {
+		case oldPath == newPath:
+			return nil
+		case flags&fs.RenameNoReplace != 0:
+			return fs.NewError("Rename", newPath, fs.ErrExist)
+		case dst.info.Type == fs.FileTypeDirectory && src.info.Type != fs.FileTypeDirectory:
+			return fs.NewError("Rename", newPath, fs.ErrIsDir)
+		case dst.info.Type != fs.FileTypeDirectory && src.info.Type == fs.FileTypeDirectory:
+			return fs.NewError("Rename", newPath, fs.ErrNotDir)
+		case dst.info.Type == fs.FileTypeDirectory:
+			if entries, err := o.list(ctx, dst, newPath, false); err != nil {
+				return err
+			} else if len(entries) > 0 {
+				return fs.NewError("Rename", newPath, fs.ErrNotEmpty)
+			}
+			if dst.layers[0] == upper {
+				if err := o.clearLocked(ctx, newPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if _, err := o.copyUpLocked(ctx, oldPath); err != nil {
+		return err
+	}
+	if err := o.parentLocked(ctx, "Rename", path.Dir(newPath), path.Base(newPath)); err != nil {
+		return err
+	}
+	if err := o.layers[upper].Rename(ctx, oldPath, newPath, flags); err != nil {
+		return err
+	}
+	if src.info.Type == fs.FileTypeDirectory {
+		if err := o.opaqueLocked(ctx, newPath); err != nil {
+			return err
+		}
+	}
+	return o.hideLocked(ctx, oldPath)
+}
+
+func (o *OverlayFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.parentLocked(ctx, "Symlink", dir, name); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	if _, err := o.resolve(ctx, path.Join(dir, name)); err == nil {
+		return "", fs.FileInfo{}, fs.NewError("Symlink", path.Join(dir, name), fs.ErrExist)
+	}
+	return o.layers[upper].Symlink(ctx, dir, name, target, attr)
+}
+
+func (o *OverlayFileSystem) Readlink(ctx context.Context, p string) (string, error) {
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	return o.layers[n.layers[0]].Readlink(ctx, p)
+}
+
+func (o *OverlayFileSystem) Link(ctx context.Context, p string, dir string, name string) (string, fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	if n.info.Type == fs.FileTypeDirectory {
+		return "", fs.FileInfo{}, fs.NewError("Link", p, fs.ErrIsDir)
+	}
+	if err := o.parentLocked(ctx, "Link", dir, name); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	if _, err := o.resolve(ctx, path.Join(dir, name)); err == nil {
+		return "", fs.FileInfo{}, fs.NewError("Link", path.Join(dir, name), fs.ErrExist)
+	}
+	if _, err := o.copyUpLocked(ctx, p); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return o.layers[upper].Link(ctx, p, dir, name)
+}
+
+// StatFS reports the upper layer, which all changes take space in
+func (o *OverlayFileSystem) StatFS(ctx context.Context) (fs.FSStat, error) {
+	return o.layers[upper].StatFS(ctx)
+}
+
+// handle returns the overlay handle of the file whose handle in layer is
+// inner: a FileHandle carrying the overlay's file system ID and the layer,
+// followed by inner
+func (o *OverlayFileSystem) handle(layer int, inner []byte) []byte {
+	h := fs.FileHandle{FileSystemID: o.fsid, Inode: uint64(layer)}
+	return append(h.Serialize(), inner...)
+}
+
+// FileHandleToPath finds the file of a handle in the layer it was made in.
+// Handles of lower files follow them when they are copied up, but only
+// until the overlay is restarted; after that, like handles of lower files
+// since hidden, they are stale.
+func (o *OverlayFileSystem) FileHandleToPath(ctx context.Context, fh []byte) (string, error) {
+	var h fs.FileHandle
+	header, err := fs.DeserializeFileHandle(fh)
+	if err == nil {
+		h = *header
+	}
+	if err != nil || h.FileSystemID != o.fsid || h.Inode >= uint64(len(o.layers)) {
+		return "", fs.NewError("FileHandleToPath", "", fs.ErrInvalidHandle)
+	}
+	layer, inner := int(h.Inode), fh[h.Size():]
+
+	o.mu.Lock()
+	upperHandle, copied := o.origins[string(fh)]
+	o.mu.Unlock()
+	if copied {
+		layer, inner = upper, upperHandle
+	}
+	p, err := o.layers[layer].FileHandleToPath(ctx, inner)
+	if err != nil {
+		return "", err
+	}
+	if n, err := o.resolve(ctx, p); err != nil || n.layers[0] != layer {
+		return "", fs.NewError("FileHandleToPath", p, fs.ErrStale)
+	}
+	return p, nil
+}
+
+func (o *OverlayFileSystem) PathToFileHandle(ctx context.Context, p string) ([]byte, error) {
+	n, err := o.resolve(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := o.layers[n.layers[0]].PathToFileHandle(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return o.handle(n.layers[0], inner), nil
+}
+
+// Commit flushes the file if it is in the upper layer; the others are not
+// written to
+func (o *OverlayFileSystem) Commit(ctx context.Context, p string, offset int64, count int64) error {
+	n, err := o.resolve(ctx, p)
+	if err != nil || n.layers[0] != upper {
+		return err
+	}
+	return o.layers[upper].Commit(ctx, p, offset, count)
+}
@@ -0,0 +1,182 @@
+package overlay
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+)
+
+// newTestOverlay returns an overlay of an empty upper layer over a base
+// holding /dir/a, /dir/b, /dir/sub/c and /file
+func newTestOverlay(t *testing.T) (*OverlayFileSystem, *memfs.MemFileSystem, *memfs.MemFileSystem) {
+	t.Helper()
+	ctx := context.Background()
+	base := memfs.NewMemFileSystem()
+	for _, dir := range [][2]string{{"/", "dir"}, {"/dir", "sub"}} {
+		if _, _, err := base.Mkdir(ctx, dir[0], dir[1], fs.FileAttr{}); err != nil {
+			t.Fatalf("Mkdir failed: %v", err)
+		}
+	}
+	for _, file := range [][2]string{{"/dir", "a"}, {"/dir", "b"}, {"/dir/sub", "c"}, {"/", "file"}} {
+		if _, _, err := base.Create(ctx, file[0], file[1], fs.FileAttr{}, true); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	if _, err := base.Write(ctx, "/file", 0, []byte("base"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	upperFS := memfs.NewMemFileSystem()
+	overlayFS, err := NewOverlayFileSystem(ctx, upperFS, base)
+	if err != nil {
+		t.Fatalf("NewOverlayFileSystem failed: %v", err)
+	}
+	return overlayFS, upperFS, base
+}
+
+// names lists dir in the overlay
+func names(t *testing.T, overlayFS *OverlayFileSystem, dir string) []string {
+	t.Helper()
+	entries, _, err := overlayFS.ReadDirPlus(context.Background(), dir, 0, 100)
+	if err != nil {
+		t.Fatalf("ReadDirPlus of %s failed: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name != "." && entry.Name != ".." {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCopyUp(t *testing.T) {
+	overlayFS, upperFS, base := newTestOverlay(t)
+	ctx := context.Background()
+	handle, err := overlayFS.PathToFileHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+
+	if _, err := overlayFS.Write(ctx, "/file", 4, []byte(" changed"), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _, err := overlayFS.Read(ctx, "/file", 0, 100); err != nil || string(data) != "base changed" {
+		t.Errorf("Read after Write = %q, %v; want \"base changed\"", data, err)
+	}
+	if data, _, err := base.Read(ctx, "/file", 0, 100); err != nil || string(data) != "base" {
+		t.Errorf("Lower layer changed to %q, %v", data, err)
+	}
+	if _, err := upperFS.GetAttr(ctx, "/file"); err != nil {
+		t.Errorf("File not copied up: %v", err)
+	}
+
+	// A handle made before the copy finds the copy
+	if p, err := overlayFS.FileHandleToPath(ctx, handle); err != nil || p != "/file" {
+		t.Errorf("FileHandleToPath of a copied-up file = %q, %v; want /file", p, err)
+	}
+
+	// Its directories are copied up with it, and merged with the lower
+	// ones
+	if _, _, err := overlayFS.Create(ctx, "/dir/sub", "new", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if got := names(t, overlayFS, "/dir/sub"); len(got) != 2 || got[0] != "c" || got[1] != "new" {
+		t.Errorf("/dir/sub = %v, want [c new]", got)
+	}
+}
+
+func TestWhiteouts(t *testing.T) {
+	overlayFS, _, base := newTestOverlay(t)
+	ctx := context.Background()
+	handle, err := overlayFS.PathToFileHandle(ctx, "/dir/a")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+
+	// Removing a lower file hides it
+	if err := overlayFS.Remove(ctx, "/dir/a"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := overlayFS.GetAttr(ctx, "/dir/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetAttr of a removed file = %v, want ErrNotExist", err)
+	}
+	if got := names(t, overlayFS, "/dir"); len(got) != 2 || got[0] != "b" || got[1] != "sub" {
+		t.Errorf("/dir = %v, want [b sub]", got)
+	}
+	if _, err := base.GetAttr(ctx, "/dir/a"); err != nil {
+		t.Errorf("Lower file removed: %v", err)
+	}
+	if _, err := overlayFS.FileHandleToPath(ctx, handle); !errors.Is(err, fs.ErrStale) {
+		t.Errorf("FileHandleToPath of a removed lower file = %v, want ErrStale", err)
+	}
+	if _, err := overlayFS.GetAttr(ctx, "/dir/.wh.a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Whiteout visible: %v", err)
+	}
+	if _, _, err := overlayFS.Create(ctx, "/dir", ".wh.b", fs.FileAttr{}, true); !errors.Is(err, fs.ErrInvalidName) {
+		t.Errorf("Create of a whiteout name = %v, want ErrInvalidName", err)
+	}
+
+	// A file made in its place is new
+	if _, _, err := overlayFS.Create(ctx, "/dir", "a", fs.FileAttr{}, true); err != nil {
+		t.Fatalf("Create over a whiteout failed: %v", err)
+	}
+	if info, err := overlayFS.GetAttr(ctx, "/dir/a"); err != nil || info.Size != 0 {
+		t.Errorf("GetAttr of the new file = %+v, %v", info, err)
+	}
+
+	// A removed directory comes back empty
+	if err := overlayFS.Rmdir(ctx, "/dir/sub"); !errors.Is(err, fs.ErrNotEmpty) {
+		t.Errorf("Rmdir of a lower directory with entries = %v, want ErrNotEmpty", err)
+	}
+	if err := overlayFS.Remove(ctx, "/dir/sub/c"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if empty, err := overlayFS.IsEmpty(ctx, "/dir/sub"); err != nil || !empty {
+		t.Errorf("IsEmpty = %v, %v; want true", empty, err)
+	}
+	if err := overlayFS.Rmdir(ctx, "/dir/sub"); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if _, _, err := overlayFS.Mkdir(ctx, "/dir", "sub", fs.FileAttr{}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if got := names(t, overlayFS, "/dir/sub"); len(got) != 0 {
+		t.Errorf("Recreated directory = %v, want it empty", got)
+	}
+}
+
+func TestRename(t *testing.T) {
+	overlayFS, _, _ := newTestOverlay(t)
+	ctx := context.Background()
+
+	if err := overlayFS.Rename(ctx, "/dir/a", "/moved", 0); err != nil {
+		t.Fatalf("Rename of a lower file failed: %v", err)
+	}
+	if _, err := overlayFS.GetAttr(ctx, "/dir/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Renamed file still at its old name: %v", err)
+	}
+	if _, err := overlayFS.GetAttr(ctx, "/moved"); err != nil {
+		t.Errorf("Renamed file not at its new name: %v", err)
+	}
+	if err := overlayFS.Rename(ctx, "/dir/b", "/file", fs.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Rename with RenameNoReplace over a lower file = %v, want ErrExist", err)
+	}
+	if err := overlayFS.Rename(ctx, "/dir/b", "/file", 0); err != nil {
+		t.Fatalf("Rename over a lower file failed: %v", err)
+	}
+	if info, err := overlayFS.GetAttr(ctx, "/file"); err != nil || info.Size != 0 {
+		t.Errorf("Replaced file = %+v, %v; want the empty one renamed over it", info, err)
+	}
+
+	// Lower directories would have to be copied whole
+	if err := overlayFS.Rename(ctx, "/dir/sub", "/sub", 0); fs.Classify(err) != api.Status_ERR_XDEV {
+		t.Errorf("Rename of a lower directory = %v, want ERR_XDEV", err)
+	}
+}
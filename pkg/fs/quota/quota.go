@@ -0,0 +1,544 @@
+// Package quota wraps a file system to limit the bytes and files stored in
+// it, in all and by each user, as disk quotas do.
+package quota
+
+import (
+	"context"
+	"hash/fnv"
+	"path"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// scanBatch is how many entries are listed at a time while counting what a
+// file system holds
+const scanBatch = 1024
+
+// Limits are the most a file system may hold; zero means unlimited
+type Limits struct {
+	Bytes     int // Bytes in regular files, in all
+	Files     int // Files, directories and symlinks, in all
+	UserBytes int // Bytes charged to each user
+	UserFiles int // Files charged to each user
+}
+
+// usage is what the file system, or a user, holds
+type usage struct {
+	bytes uint64
+	files uint64
+}
+
+// delta is a change in what a user holds
+type delta struct {
+	uid   uint32
+	bytes int64
+	files int64
+}
+
+// QuotaFileSystem enforces Limits on the file system it wraps. Every file
+// is charged to its owner: its size if it is a regular file, and one file
+// however many links it has. Changes that would take the whole file system
+// over its limits fail with fs.ErrNoSpace, and those that would take a
+// user over theirs with fs.ErrQuota. Only changes made through the wrapper
+// are counted.
+type QuotaFileSystem struct {
+	fs.FileSystem
+	limits Limits
+
+	mu    sync.Mutex
+	total usage
+	users map[uint32]*usage
+
+	// Held, by hash of the path, while a file's size is read and changed,
+	// so concurrent writes extending it are charged once
+	files [64]sync.Mutex
+}
+
+// NewQuotaFileSystem wraps inner, enforcing limits on it. What inner holds
+// already is counted first, which lists its whole tree.
+func NewQuotaFileSystem(ctx context.Context, inner fs.FileSystem, limits Limits) (*QuotaFileSystem, error) {
+	q := &QuotaFileSystem{
+		FileSystem: inner,
+		limits:     limits,
+		users:      make(map[uint32]*usage),
+	}
+	linked := make(map[uint64]bool)
+	if err := q.scan(ctx, "/", linked); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// scan counts what the tree under dir holds. Files with several links,
+// recorded in linked by file ID, are counted once.
+func (q *QuotaFileSystem) scan(ctx context.Context, dir string, linked map[uint64]bool) error {
+	cookie := int64(0)
+	for {
+		entries, next, err := q.FileSystem.ReadDirPlus(ctx, dir, cookie, scanBatch)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			p := path.Join(dir, entry.Name)
+			info := entry.Attributes
+			if info == nil {
+				attrs, err := q.FileSystem.GetAttr(ctx, p)
+				if err != nil {
+					continue // Removed meanwhile
+				}
+				info = &attrs
+			}
+			if info.Type == fs.FileTypeDirectory {
+				if err := q.scan(ctx, p, linked); err != nil {
+					return err
+				}
+			} else if info.Nlink > 1 {
+				if linked[info.FileId] {
+					continue
+				}
+				linked[info.FileId] = true
+			}
+			q.applyLocked([]delta{{info.Uid, charged(*info), 1}})
+		}
+		if next == cookie {
+			return nil
+		}
+		cookie = next
+	}
+}
+
+// charged returns the bytes a file is charged for
+func charged(info fs.FileInfo) int64 {
+	if info.Type != fs.FileTypeRegular {
+		return 0
+	}
+	return info.Size
+}
+
+// lastLink reports whether removing a name of the file described by info
+// removes the file
+func lastLink(info fs.FileInfo) bool {
+	return info.Type == fs.FileTypeDirectory || info.Nlink <= 1
+}
+
+// lockFile serializes changes to the size of the file at p
+func (q *QuotaFileSystem) lockFile(p string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(path.Clean("/" + p)))
+	mu := &q.files[h.Sum32()%uint32(len(q.files))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// exceeds reports whether adding n to used goes past limit
+func exceeds(used uint64, n int64, limit int) bool {
+	return limit > 0 && n > 0 && used+uint64(n) > uint64(limit)
+}
+
+// charge applies deltas together, unless they take the file system or a
+// user over a limit and add to what it holds
+func (q *QuotaFileSystem) charge(deltas ...delta) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var bytes, files int64
+	for _, d := range deltas {
+		bytes += d.bytes
+		files += d.files
+	}
+	if exceeds(q.total.bytes, bytes, q.limits.Bytes) || exceeds(q.total.files, files, q.limits.Files) {
+		return fs.ErrNoSpace
+	}
+	for _, d := range deltas {
+		var userBytes, userFiles int64
+		for _, other := range deltas {
+			if other.uid == d.uid {
+				userBytes += other.bytes
+				userFiles += other.files
+			}
+		}
+		user := q.users[d.uid]
+		if user == nil {
+			user = &usage{}
+		}
+		if exceeds(user.bytes, userBytes, q.limits.UserBytes) || exceeds(user.files, userFiles, q.limits.UserFiles) {
+			return fs.ErrQuota
+		}
+	}
+	q.applyLocked(deltas)
+	return nil
+}
+
+// force applies deltas whatever the limits
+func (q *QuotaFileSystem) force(deltas ...delta) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.applyLocked(deltas)
+}
+
+// refund takes back deltas charged earlier
+func (q *QuotaFileSystem) refund(deltas ...delta) {
+	for i := range deltas {
+		deltas[i].bytes, deltas[i].files = -deltas[i].bytes, -deltas[i].files
+	}
+	q.force(deltas...)
+}
+
+// applyLocked applies deltas. What a user holds never drops below zero,
+// as files stored by other means may be removed through the wrapper.
+func (q *QuotaFileSystem) applyLocked(deltas []delta) {
+	for _, d := range deltas {
+		user := q.users[d.uid]
+		if user == nil {
+			user = &usage{}
+			q.users[d.uid] = user
+		}
+		user.add(d.bytes, d.files)
+		q.total.add(d.bytes, d.files)
+		if *user == (usage{}) {
+			delete(q.users, d.uid)
+		}
+	}
+}
+
+// add adds bytes and files, stopping at zero
+func (u *usage) add(bytes int64, files int64) {
+	u.bytes = uint64(max(int64(u.bytes)+bytes, 0))
+	u.files = uint64(max(int64(u.files)+files, 0))
+}
+
+// Unwrap returns the file system quotas are enforced on
+func (q *QuotaFileSystem) Unwrap() fs.FileSystem {
+	return q.FileSystem
+}
+
+func (q *QuotaFileSystem) Write(ctx context.Context, p string, offset int64, data []byte, sync bool) (int, error) {
+	unlock := q.lockFile(p)
+	defer unlock()
+
+	info, err := q.FileSystem.GetAttr(ctx, p)
+	if err != nil {
+		return q.FileSystem.Write(ctx, p, offset, data, sync)
+	}
+	growth := max(offset+int64(len(data))-info.Size, 0)
+	if err := q.charge(delta{info.Uid, growth, 0}); err != nil {
+		return 0, fs.NewError("Write", p, err)
+	}
+	n, err := q.FileSystem.Write(ctx, p, offset, data, sync)
+	if grown := max(offset+int64(n)-info.Size, 0); grown < growth {
+		q.refund(delta{info.Uid, growth - grown, 0})
+	}
+	return n, err
+}
+
+func (q *QuotaFileSystem) SetAttr(ctx context.Context, p string, attr fs.FileAttr) (fs.FileInfo, error) {
+	if attr.Size == nil && attr.Uid == nil {
+		return q.FileSystem.SetAttr(ctx, p, attr)
+	}
+	unlock := q.lockFile(p)
+	defer unlock()
+
+	before, err := q.FileSystem.GetAttr(ctx, p)
+	if err != nil {
+		return q.FileSystem.SetAttr(ctx, p, attr)
+	}
+	after := before
+	if attr.Size != nil {
+		after.Size = int64(*attr.Size)
+	}
+	if attr.Uid != nil {
+		after.Uid = *attr.Uid
+	}
+
+	// A new owner is charged for the file instead of the old one
+	deltas := []delta{{before.Uid, -charged(before), -1}, {after.Uid, charged(after), 1}}
+	if err := q.charge(deltas...); err != nil {
+		return fs.FileInfo{}, fs.NewError("SetAttr", p, err)
+	}
+	info, err := q.FileSystem.SetAttr(ctx, p, attr)
+	if err != nil {
+		q.refund(deltas...)
+	}
+	return info, err
+}
+
+// added charges the owner for a file just created at p, removing it
+// again with remove if that takes it or the file system over a limit
+func (q *QuotaFileSystem) added(op string, p string, info fs.FileInfo, remove func(context.Context, string) error) error {
+	if err := q.charge(delta{info.Uid, charged(info), 1}); err != nil {
+		remove(context.Background(), p)
+		return fs.NewError(op, p, err)
+	}
+	return nil
+}
+
+func (q *QuotaFileSystem) Create(ctx context.Context, dir string, name string, attr fs.FileAttr, excl bool) (string, fs.FileInfo, error) {
+	unlock := q.lockFile(path.Join(dir, name))
+	defer unlock()
+
+	before, err := q.FileSystem.GetAttr(ctx, path.Join(dir, name))
+	existed := err == nil
+	p, info, err := q.FileSystem.Create(ctx, dir, name, attr, excl)
+	if err != nil {
+		return p, info, err
+	}
+	if existed {
+		// Truncated rather than created
+		q.force(delta{before.Uid, -charged(before), -1}, delta{info.Uid, charged(info), 1})
+		return p, info, nil
+	}
+	if err := q.added("Create", p, info, q.FileSystem.Remove); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+func (q *QuotaFileSystem) Mkdir(ctx context.Context, dir string, name string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	p, info, err := q.FileSystem.Mkdir(ctx, dir, name, attr)
+	if err != nil {
+		return p, info, err
+	}
+	if err := q.added("Mkdir", p, info, q.FileSystem.Rmdir); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+func (q *QuotaFileSystem) Symlink(ctx context.Context, dir string, name string, target string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	p, info, err := q.FileSystem.Symlink(ctx, dir, name, target, attr)
+	if err != nil {
+		return p, info, err
+	}
+	if err := q.added("Symlink", p, info, q.FileSystem.Remove); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+// removed refunds the owner of the file described by info once its last
+// name is gone
+func (q *QuotaFileSystem) removed(info fs.FileInfo) {
+	if lastLink(info) {
+		q.refund(delta{info.Uid, charged(info), 1})
+	}
+}
+
+func (q *QuotaFileSystem) Remove(ctx context.Context, p string) error {
+	unlock := q.lockFile(p)
+	defer unlock()
+
+	before, statErr := q.FileSystem.GetAttr(ctx, p)
+	if err := q.FileSystem.Remove(ctx, p); err != nil {
+		return err
+	}
+	if statErr == nil {
+		q.removed(before)
+	}
+	return nil
+}
+
+func (q *QuotaFileSystem) Rmdir(ctx context.Context, p string) error {
+	before, statErr := q.FileSystem.GetAttr(ctx, p)
+	if err := q.FileSystem.Rmdir(ctx, p); err != nil {
+		return err
+	}
+	if statErr == nil {
+		q.removed(before)
+	}
+	return nil
+}
+
+func (q *QuotaFileSystem) Rename(ctx context.Context, oldPath string, newPath string, flags fs.RenameFlags) error {
+	unlock := q.lockFile(newPath)
+	defer unlock()
+
+	// A file replaced by the rename is removed
+	var replaced *fs.FileInfo
+	if flags&fs.RenameExchange == 0 {
+		source, err := q.FileSystem.GetAttr(ctx, oldPath)
+		if target, err2 := q.FileSystem.GetAttr(ctx, newPath); err == nil && err2 == nil && target.FileId != source.FileId {
+			replaced = &target
+		}
+	}
+	if err := q.FileSystem.Rename(ctx, oldPath, newPath, flags); err != nil {
+		return err
+	}
+	if replaced != nil {
+		q.removed(*replaced)
+	}
+	return nil
+}
+
+func (q *QuotaFileSystem) StatFS(ctx context.Context) (fs.FSStat, error) {
+	stat, err := q.FileSystem.StatFS(ctx)
+	if err != nil {
+		return stat, err
+	}
+	q.mu.Lock()
+	total := q.total
+	q.mu.Unlock()
+
+	if limit := uint64(q.limits.Bytes); limit > 0 {
+		free := limit - min(total.bytes, limit)
+		stat.TotalBytes = min(stat.TotalBytes, limit)
+		stat.FreeBytes = min(stat.FreeBytes, free)
+		stat.AvailBytes = min(stat.AvailBytes, free)
+	}
+	if limit := uint64(q.limits.Files); limit > 0 {
+		stat.TotalFiles = min(stat.TotalFiles, limit)
+		stat.FreeFiles = min(stat.FreeFiles, limit-min(total.files, limit))
+	}
+	return stat, nil
+}
+
+// Quota returns what uid holds and the limits of each user
+func (q *QuotaFileSystem) Quota(ctx context.Context, p string, uid uint32) (fs.QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var user usage
+	if u := q.users[uid]; u != nil {
+		user = *u
+	}
+	return fs.QuotaUsage{
+		BytesUsed:  user.bytes,
+		BytesLimit: uint64(q.limits.UserBytes),
+		FilesUsed:  user.files,
+		FilesLimit: uint64(q.limits.UserFiles),
+	}, nil
+}
+
+// ExportQuota returns what the file system holds and its limits
+func (q *QuotaFileSystem) ExportQuota(ctx context.Context) (fs.QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return fs.QuotaUsage{
+		BytesUsed:  q.total.bytes,
+		BytesLimit: uint64(q.limits.Bytes),
+		FilesUsed:  q.total.files,
+		FilesLimit: uint64(q.limits.Files),
+	}, nil
+}
+
+// CreateUnnamed creates an unnamed file, charged to its owner like any
+// other, if the wrapped file system can
+func (q *QuotaFileSystem) CreateUnnamed(ctx context.Context, dir string, attr fs.FileAttr) (string, fs.FileInfo, error) {
+	creator, ok := fs.As[fs.UnnamedCreator](q.FileSystem)
+	if !ok {
+		return "", fs.FileInfo{}, fs.NewError("CreateUnnamed", dir, fs.ErrNotSupported)
+	}
+	p, info, err := creator.CreateUnnamed(ctx, dir, attr)
+	if err != nil {
+		return p, info, err
+	}
+	if err := q.added("CreateUnnamed", p, info, q.FileSystem.Remove); err != nil {
+		return "", fs.FileInfo{}, err
+	}
+	return p, info, nil
+}
+
+// LinkUnnamed gives an unnamed file its name, refunding any file it
+// replaces
+func (q *QuotaFileSystem) LinkUnnamed(ctx context.Context, p string, dir string, name string, replace bool) (string, fs.FileInfo, error) {
+	creator, ok := fs.As[fs.UnnamedCreator](q.FileSystem)
+	if !ok {
+		return "", fs.FileInfo{}, fs.NewError("LinkUnnamed", p, fs.ErrNotSupported)
+	}
+	unlock := q.lockFile(path.Join(dir, name))
+	defer unlock()
+
+	replaced, statErr := q.FileSystem.GetAttr(ctx, path.Join(dir, name))
+	linkedPath, info, err := creator.LinkUnnamed(ctx, p, dir, name, replace)
+	if err != nil {
+		return linkedPath, info, err
+	}
+	if statErr == nil && replace {
+		q.removed(replaced)
+	}
+	return linkedPath, info, nil
+}
+
+// Allocate reserves a range of a file, charging its owner for the growth
+// of a file it extends, if the wrapped file system can
+func (q *QuotaFileSystem) Allocate(ctx context.Context, p string, offset int64, length int64) error {
+	allocator, ok := fs.As[fs.Allocator](q.FileSystem)
+	if !ok {
+		return fs.NewError("Allocate", p, fs.ErrNotSupported)
+	}
+	unlock := q.lockFile(p)
+	defer unlock()
+
+	info, err := q.FileSystem.GetAttr(ctx, p)
+	if err != nil {
+		return allocator.Allocate(ctx, p, offset, length)
+	}
+	growth := max(offset+length-info.Size, 0)
+	if err := q.charge(delta{info.Uid, growth, 0}); err != nil {
+		return fs.NewError("Allocate", p, err)
+	}
+	if err := allocator.Allocate(ctx, p, offset, length); err != nil {
+		q.refund(delta{info.Uid, growth, 0})
+		return err
+	}
+	return nil
+}
+
+// Deallocate punches a hole in a file. Files are charged for their size,
+// which stays the same.
+func (q *QuotaFileSystem) Deallocate(ctx context.Context, p string, offset int64, length int64) error {
+	allocator, ok := fs.As[fs.Allocator](q.FileSystem)
+	if !ok {
+		return fs.NewError("Deallocate", p, fs.ErrNotSupported)
+	}
+	return allocator.Deallocate(ctx, p, offset, length)
+}
+
+// Seek finds data or a hole in a file, if the wrapped file system can
+func (q *QuotaFileSystem) Seek(ctx context.Context, p string, offset int64, what fs.SeekContent) (int64, error) {
+	allocator, ok := fs.As[fs.Allocator](q.FileSystem)
+	if !ok {
+		return 0, fs.NewError("Seek", p, fs.ErrNotSupported)
+	}
+	return allocator.Seek(ctx, p, offset, what)
+}
+
+// CopyRange copies a range between files, charging the owner of dst for
+// its growth, if the wrapped file system can
+func (q *QuotaFileSystem) CopyRange(ctx context.Context, src string, srcOffset int64, dst string, dstOffset int64, length int64) (int64, error) {
+	copier, ok := fs.As[fs.RangeCopier](q.FileSystem)
+	if !ok {
+		return 0, fs.NewError("CopyRange", dst, fs.ErrNotSupported)
+	}
+	unlock := q.lockFile(dst)
+	defer unlock()
+
+	info, err := q.FileSystem.GetAttr(ctx, dst)
+	if err != nil {
+		return copier.CopyRange(ctx, src, srcOffset, dst, dstOffset, length)
+	}
+	srcInfo, err := q.FileSystem.GetAttr(ctx, src)
+	if err != nil {
+		return copier.CopyRange(ctx, src, srcOffset, dst, dstOffset, length)
+	}
+	count := max(srcInfo.Size-srcOffset, 0)
+	if length != 0 {
+		count = min(count, length)
+	}
+	growth := max(dstOffset+count-info.Size, 0)
+	if err := q.charge(delta{info.Uid, growth, 0}); err != nil {
+		return 0, fs.NewError("CopyRange", dst, err)
+	}
+	n, err := copier.CopyRange(ctx, src, srcOffset, dst, dstOffset, length)
+	if grown := max(dstOffset+n-info.Size, 0); grown < growth {
+		q.refund(delta{info.Uid, growth - grown, 0})
+	}
+	return n, err
+}
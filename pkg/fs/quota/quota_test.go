@@ -0,0 +1,94 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	memFS := memfs.NewMemFileSystem()
+	alice, bob := uint32(1000), uint32(1001)
+	if _, _, err := memFS.Create(ctx, "/", "existing", fs.FileAttr{Uid: &alice}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := memFS.Write(ctx, "/existing", 0, make([]byte, 100), false); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	quotaFS, err := NewQuotaFileSystem(ctx, memFS, Limits{Bytes: 1000, Files: 10, UserFiles: 2})
+	if err != nil {
+		t.Fatalf("NewQuotaFileSystem failed: %v", err)
+	}
+
+	// What the file system held already is counted
+	if usage, err := quotaFS.Quota(ctx, "/", alice); err != nil || usage.BytesUsed != 100 || usage.FilesUsed != 1 {
+		t.Errorf("Quota of the existing file's owner = %+v, %v; want 100 bytes, 1 file", usage, err)
+	}
+
+	// A write taking the export over its byte limit fails with ERR_NOSPC
+	if _, _, err := quotaFS.Create(ctx, "/", "big", fs.FileAttr{Uid: &bob}, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := quotaFS.Write(ctx, "/big", 0, make([]byte, 900), false); err != nil {
+		t.Fatalf("Write within the quota failed: %v", err)
+	}
+	if _, err := quotaFS.Write(ctx, "/big", 900, make([]byte, 1), false); fs.Classify(err) != api.Status_ERR_NOSPC {
+		t.Errorf("Write beyond the export quota = %v, want ERR_NOSPC", err)
+	}
+	if _, err := quotaFS.Write(ctx, "/big", 0, make([]byte, 900), false); err != nil {
+		t.Errorf("Overwrite within the file failed: %v", err)
+	}
+
+	// StatFS shows the export as only as large as its quota
+	stat, err := quotaFS.StatFS(ctx)
+	if err != nil {
+		t.Fatalf("StatFS failed: %v", err)
+	}
+	if stat.TotalBytes != 1000 || stat.FreeBytes != 0 || stat.TotalFiles != 10 || stat.FreeFiles != 8 {
+		t.Errorf("StatFS = %d/%d bytes, %d/%d files free; want 0/1000, 8/10",
+			stat.FreeBytes, stat.TotalBytes, stat.FreeFiles, stat.TotalFiles)
+	}
+
+	// Removing a file refunds its owner
+	if err := quotaFS.Remove(ctx, "/big"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if usage, err := quotaFS.ExportQuota(ctx); err != nil || usage.BytesUsed != 100 || usage.FilesUsed != 1 || usage.BytesLimit != 1000 {
+		t.Errorf("ExportQuota after Remove = %+v, %v; want 100 of 1000 bytes, 1 file", usage, err)
+	}
+
+	// A user over their own file limit gets ERR_DQUOT, and nothing is left
+	// behind
+	if _, _, err := quotaFS.Mkdir(ctx, "/", "dir", fs.FileAttr{Uid: &alice}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	_, _, err = quotaFS.Create(ctx, "/dir", "file", fs.FileAttr{Uid: &alice}, true)
+	if !errors.Is(err, fs.ErrQuota) || fs.Classify(err) != api.Status_ERR_DQUOT {
+		t.Errorf("Create beyond the user quota = %v, want ERR_DQUOT", err)
+	}
+	if _, err := memFS.GetAttr(ctx, "/dir/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("File refused by the quota = %v, want it removed", err)
+	}
+	if _, _, err := quotaFS.Create(ctx, "/dir", "file", fs.FileAttr{Uid: &bob}, true); err != nil {
+		t.Errorf("Create by another user failed: %v", err)
+	}
+
+	// Giving a file away charges its new owner
+	if _, err := quotaFS.SetAttr(ctx, "/dir/file", fs.FileAttr{Uid: &alice}); !errors.Is(err, fs.ErrQuota) {
+		t.Errorf("Chown to a user at their quota = %v, want ErrQuota", err)
+	}
+
+	// Servers find the interfaces of the wrapped file system through it
+	if _, ok := fs.As[fs.ExportQuotaReporter](quotaFS); !ok {
+		t.Error("QuotaFileSystem is not an ExportQuotaReporter")
+	}
+	if _, ok := fs.As[*memfs.MemFileSystem](quotaFS); !ok {
+		t.Error("fs.As does not reach the wrapped file system")
+	}
+}
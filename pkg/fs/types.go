@@ -60,11 +60,34 @@ const (
     ModeSticky FileMode = 01000
 )
 
+// RenameFlags modify Rename like the flags of renameat2(2).
+type RenameFlags uint32
+
+const (
+    // RenameNoReplace fails with ErrExist instead of replacing the target
+    RenameNoReplace RenameFlags = 1 << iota
+    // RenameExchange atomically swaps the source and the target, which
+    // must both exist
+    RenameExchange
+)
+
+// SeekContent selects what Allocator.Seek looks for
+type SeekContent uint32
+
+const (
+    SeekData SeekContent = iota // Bytes written, or allocated
+    SeekHole                    // Bytes never written, which read as zeros
+)
+
 // FileInfo contains information about a file.
 type FileInfo struct {
     // Type is the file type
     Type FileType
     
+    // FileId identifies the file within the file system, such as its
+    // inode number, and matches the FileId of its directory entries
+    FileId uint64
+    
     // Mode contains the permission bits
     Mode FileMode
     
@@ -100,6 +123,14 @@ type FileInfo struct {
     
     // CreateTime is the time of creation (may not be available on all filesystems)
     CreateTime time.Time
+    
+    // ChangeID increases with every change to the file's data or attributes,
+    // or to a directory's entries (0 if the file system cannot track it)
+    ChangeID uint64
+    
+    // ACL is the file's access control list, nil if it has none beyond its
+    // mode bits or the file system keeps none
+    ACL ACL
 }
 
 // FileAttr contains attributes to set on a file.
@@ -158,4 +189,61 @@ type FSStat struct {
     
     // NameMaxLength is the maximum length of a file name
     NameMaxLength uint32
-}
\ No newline at end of file
+}
+// QuotaUsage reports usage against the limits enforced for a user.
+// A limit of zero means unlimited.
+type QuotaUsage struct {
+    // BytesUsed is the number of bytes charged to the user
+    BytesUsed uint64
+    
+    // BytesLimit is the maximum number of bytes
+    BytesLimit uint64
+    
+    // FilesUsed is the number of files charged to the user
+    FilesUsed uint64
+    
+    // FilesLimit is the maximum number of files
+    FilesLimit uint64
+}
+
+// CheckReport summarizes a consistency check of a file system's index
+// against the stored tree.
+type CheckReport struct {
+    // Scanned is the number of entries found in the tree
+    Scanned uint64
+    
+    // Indexed is the number of index entries before the check
+    Indexed uint64
+    
+    // Stale counts index entries for files that no longer exist
+    Stale uint64
+    
+    // Moved counts index entries that point at the wrong path
+    Moved uint64
+    
+    // Added counts tree entries that were missing from the index
+    Added uint64
+    
+    // Orphans lists entries that cannot be reached through the namespace
+    Orphans []string
+    
+    // Repaired reports whether divergences were fixed
+    Repaired bool
+}
+
+// SnapshotInfo describes a read-only, point-in-time copy of a file system.
+// Files and Cloned are only known when the snapshot is taken.
+type SnapshotInfo struct {
+    // Name is the snapshot's name, and its directory under /.snapshots
+    Name string
+    
+    // CreateTime is when the snapshot was taken
+    CreateTime time.Time
+    
+    // Files is the number of entries copied into the snapshot
+    Files uint64
+    
+    // Cloned reports whether file contents were copied as reflinks rather
+    // than shared through hard links
+    Cloned bool
+}
@@ -0,0 +1,74 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// rightsClient grants a fixed set of rights and records what was asked
+type rightsClient struct {
+	client.NFSClient
+
+	granted   uint32
+	requested uint32
+}
+
+func (c *rightsClient) CheckAccess(ctx context.Context, fileHandle []byte, rights uint32) (uint32, error) {
+	c.requested = rights
+	return rights & c.granted, nil
+}
+
+func (c *rightsClient) Access(ctx context.Context, fileHandle []byte, mode uint32) error {
+	return nil
+}
+
+func TestAccessMapsMaskToRights(t *testing.T) {
+	const (
+		read    = uint32(api.AccessRight_ACCESS_READ)
+		lookup  = uint32(api.AccessRight_ACCESS_LOOKUP)
+		write   = uint32(api.AccessRight_ACCESS_MODIFY | api.AccessRight_ACCESS_EXTEND)
+		execute = uint32(api.AccessRight_ACCESS_EXECUTE)
+	)
+	tests := []struct {
+		name          string
+		dir           bool
+		mask          uint32
+		granted       uint32
+		wantRequested uint32
+		wantErr       error
+	}{
+		{"File read", false, 4, read, read, nil},
+		{"File read write denied", false, 6, read, read | write, syscall.EACCES},
+		{"File execute", false, 1, execute, execute, nil},
+		{"Directory search", true, 1, lookup, lookup, nil},
+		{"Directory search denied", true, 1, execute, lookup, syscall.EACCES},
+		{"Directory write", true, 3, lookup | write, lookup | write, nil},
+		{"Existence", false, 0, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nfsClient := &rightsClient{granted: tt.granted}
+			nfs := NewNFSFS(nfsClient, []byte("root"), DefaultOptions())
+			req := &fuse.AccessRequest{Mask: tt.mask}
+
+			var err error
+			if tt.dir {
+				err = (&Dir{fs: nfs, handle: []byte("dir"), path: "/dir"}).Access(context.Background(), req)
+			} else {
+				err = (&File{fs: nfs, handle: []byte("file"), path: "/file"}).Access(context.Background(), req)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Access returned %v, want %v", err, tt.wantErr)
+			}
+			if nfsClient.requested != tt.wantRequested {
+				t.Errorf("Requested rights %#x, want %#x", nfsClient.requested, tt.wantRequested)
+			}
+		})
+	}
+}
@@ -1,4 +1,110 @@
 package fuse
 
-// This file would contain attribute-related operations like getattr, setattr, etc.
-// For the prototype, we're just using basic Attr methods directly in file.go and dir.go
+import (
+	"os"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// fillAttr copies NFS attributes into a FUSE attribute structure
+func fillAttr(attr *fuse.Attr, attrs *api.FileAttributes, valid time.Duration) {
+	mode := osMode(attrs.Mode)
+	switch attrs.Type {
+	case api.FileType_DIRECTORY:
+		mode |= os.ModeDir
+	case api.FileType_SYMLINK:
+		mode |= os.ModeSymlink
+	case api.FileType_BLOCK:
+		mode |= os.ModeDevice
+	case api.FileType_CHAR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case api.FileType_FIFO:
+		mode |= os.ModeNamedPipe
+	case api.FileType_SOCKET:
+		mode |= os.ModeSocket
+	}
+
+	attr.Valid = valid
+	attr.Inode = attrs.Fileid
+	attr.Mode = mode
+	attr.Size = attrs.Size
+	attr.Nlink = attrs.Nlink
+	attr.Uid = attrs.Uid
+	attr.Gid = attrs.Gid
+	attr.BlockSize = attrs.Blksize
+	attr.Blocks = attrs.Used / 512
+	attr.Atime = protoTime(attrs.Atime)
+	attr.Mtime = protoTime(attrs.Mtime)
+	attr.Ctime = protoTime(attrs.Ctime)
+}
+
+// Unix setuid, setgid and sticky bits, as used on the wire
+const (
+	unixSetuid = 04000
+	unixSetgid = 02000
+	unixSticky = 01000
+)
+
+// direntType returns the dirent type of a file with attrs
+func direntType(attrs *api.FileAttributes) fuse.DirentType {
+	if attrs == nil {
+		return fuse.DT_Unknown
+	}
+	switch attrs.Type {
+	case api.FileType_REGULAR:
+		return fuse.DT_File
+	case api.FileType_DIRECTORY:
+		return fuse.DT_Dir
+	case api.FileType_SYMLINK:
+		return fuse.DT_Link
+	case api.FileType_BLOCK:
+		return fuse.DT_Block
+	case api.FileType_CHAR:
+		return fuse.DT_Char
+	case api.FileType_FIFO:
+		return fuse.DT_FIFO
+	case api.FileType_SOCKET:
+		return fuse.DT_Socket
+	}
+	return fuse.DT_Unknown
+}
+
+// osMode converts the permission and special bits of a Unix mode
+func osMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&unixSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&unixSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&unixSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// unixMode is the inverse of osMode
+func unixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= unixSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= unixSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= unixSticky
+	}
+	return mode
+}
+
+// protoTime converts an NFS timestamp to time.Time
+func protoTime(t *api.FileTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return time.Unix(t.Seconds, int64(t.Nano))
+}
@@ -4,11 +4,15 @@ import (
 	"os"
 	"time"
 	"context"
+	"errors"
 	"log"
+	"sync/atomic"
+	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
 )
 
 // Dir represents a directory in the filesystem
@@ -16,6 +20,9 @@ type Dir struct {
 	fs     *NFSFS        // Reference to the file system
 	handle []byte        // NFS file handle for this directory
 	path   string        // Path for logging/debugging
+	
+	// Attributes returned by the Lookup that created the node, used once
+	prefetched atomic.Pointer[api.FileAttributes]
 }
 
 // Attr sets the attributes of the directory
@@ -26,35 +33,81 @@ func (d *Dir) Attr(ctx context.Context, attr *fuse.Attr) error {
 	// Default attributes in case of failure
 	attr.Mode = os.ModeDir | 0755
 	attr.Mtime = time.Now()
+	attr.Valid = d.fs.options.AttrTimeout
+	
+	attrs := d.prefetched.Swap(nil)
+	if attrs == nil {
+		var err error
+		attrs, err = d.fs.client.GetAttr(ctx, d.handle)
+		if err != nil {
+			log.Printf("GetAttr failed for %s, using defaults: %v", d.path, err)
+			return nil
+		}
+	}
+	fillAttr(attr, attrs, d.fs.options.AttrTimeout)
 	
 	return nil
 }
 
+// Access checks whether the caller may access the directory
+func (d *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return d.fs.access(ctx, d.handle, d.path, true, req)
+}
+
+// Setattr changes the directory's attributes
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	_, err := d.fs.setattr(ctx, d.handle, d.path, req, resp)
+	return err
+}
+
 // Lookup looks up a specific entry in the directory
-func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	name := req.Name
 	log.Printf("Looking up %s in directory %s", name, d.path)
 	
-	// Use NFS client to lookup the file
-	fileHandle, attrs, err := d.fs.client.Lookup(ctx, d.handle, name)
+	// A name the server would refuse cannot exist
+	if err := d.fs.checkName(ctx, name); err != nil {
+		return nil, err
+	}
+	
+	// Use NFS client to lookup the file; lookups of sibling entries
+	// arriving together are batched into one RPC
+	fileHandle, attrs, err := d.fs.lookups.lookup(ctx, d.handle, name)
 	if err != nil {
 		log.Printf("Lookup failed: %v", err)
-		return nil, fuse.ENOENT
+		return nil, failed(ctx, fuse.ENOENT)
 	}
 	
-	// Determine if it's a file or directory
+	// Let the kernel cache this name resolution
+	resp.EntryValid = d.fs.options.EntryTimeout
+	
+	// Determine if it's a file or directory. The attributes from the lookup
+	// answer the Attr call that immediately follows, saving a GetAttr.
 	if attrs.Type == api.FileType_DIRECTORY {
-		return &Dir{
+		dir := &Dir{
 			fs:     d.fs,
 			handle: fileHandle,
 			path:   d.path + "/" + name,
-		}, nil
+		}
+		dir.prefetched.Store(attrs)
+		return dir, nil
+	} else if attrs.Type == api.FileType_SYMLINK {
+		link := &Symlink{
+			fs:     d.fs,
+			handle: fileHandle,
+			path:   d.path + "/" + name,
+		}
+		link.prefetched.Store(attrs)
+		return link, nil
 	} else {
-		return &File{
+		file := &File{
 			fs:     d.fs,
 			handle: fileHandle,
 			path:   d.path + "/" + name,
 			size:   int64(attrs.Size),
-		}, nil
+		}
+		file.prefetched.Store(attrs)
+		return file, nil
 	}
 }
 
@@ -62,28 +115,34 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	log.Printf("Reading directory: %s", d.path)
 	
-	// Use NFS client to read directory
-	entries, err := d.fs.client.ReadDir(ctx, d.handle)
+	// A delegated listing is exact until recalled and costs no round trip,
+	// so it is preferred when delegations are in use
+	if !d.fs.listings.active() && !d.fs.noReadDirPlus.Load() {
+		entries, err := d.fs.client.ReadDirPlus(ctx, d.handle)
+		if err == nil {
+			return d.plusDirents(entries), nil
+		}
+		if !errors.Is(err, client.ErrNotImplemented) {
+			log.Printf("ReadDirPlus failed: %v", err)
+			return nil, failed(ctx, fuse.EIO)
+		}
+		log.Printf("Server does not support ReadDirPlus; entries will be looked up one by one")
+		d.fs.noReadDirPlus.Store(true)
+	}
+	
+	// Use NFS client to read directory, unless a delegated listing is cached
+	entries, err := d.fs.listings.readDir(ctx, d.handle)
 	if err != nil {
 		log.Printf("ReadDir failed: %v", err)
-		return nil, fuse.EIO
+		return nil, failed(ctx, fuse.EIO)
 	}
 	
-	// Convert NFS entries to FUSE dirents
+	// Convert NFS entries to FUSE dirents; plain entries carry no type
 	result := make([]fuse.Dirent, 0, len(entries))
 	for _, entry := range entries {
-		var direntType fuse.DirentType
-		
-		// Try to determine the entry type
-		// In NFS, entry itself doesn't contain type,
-		// but we could do a Lookup to get attributes
-		// For simplicity, we'll just set it to DT_Unknown
-		direntType = fuse.DT_Unknown
-		
-		// Add the entry
 		result = append(result, fuse.Dirent{
 			Name:  entry.Name,
-			Type:  direntType,
+			Type:  fuse.DT_Unknown,
 			Inode: entry.FileId,
 		})
 	}
@@ -92,12 +151,34 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	return result, nil
 }
 
+// plusDirents converts ReadDirPlus entries to FUSE dirents and keeps their
+// lookup results for the Lookups the kernel sends next, e.g. for `ls -l`
+func (d *Dir) plusDirents(entries []*api.DirEntryPlus) []fuse.Dirent {
+	d.fs.lookups.prime(d.handle, entries, d.fs.options.AttrTimeout)
+	
+	result := make([]fuse.Dirent, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, fuse.Dirent{
+			Name:  entry.Name,
+			Type:  direntType(entry.Attributes),
+			Inode: entry.FileId,
+		})
+	}
+	
+	log.Printf("Returning %d directory entries", len(result))
+	return result
+}
+
 
 // Create implements the Create method for FUSE directories
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
     log.Printf("Creating file %s in directory %s (flags: %v, mode: %v)", 
         req.Name, d.path, req.Flags, req.Mode)
     
+    if err := d.fs.checkNewName(ctx, d.path, req.Name); err != nil {
+        return nil, nil, err
+    }
+    
     // Convert FUSE mode to NFS attributes
     // TODO:忽略传入的权限，总是使用0666
     attrs := &api.FileAttributes{
@@ -107,9 +188,10 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
     // Use NFS client to create the file
     // Use GUARDED mode to prevent overwrite if exists
     fileHandle, fileAttrs, err := d.fs.client.Create(ctx, d.handle, req.Name, attrs, api.CreateMode_GUARDED)
+    d.fs.changedDir(d.handle)
     if err != nil {
         log.Printf("Create failed: %v", err)
-        return nil, nil, fuse.EIO
+        return nil, nil, failed(ctx, fuse.EIO)
     }
     
     // Get file size from attributes or default to 0
@@ -128,16 +210,42 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
     
     // Set appropriate flags in the response
     resp.Flags |= fuse.OpenDirectIO
+    resp.EntryValid = d.fs.options.EntryTimeout
     
     // Return both node and handle (they're the same in our implementation)
     return file, file, nil
 }
 
+// Remove unlinks a file or other entry (unlink), or removes an empty
+// directory (rmdir)
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+    log.Printf("Removing %s/%s", d.path, req.Name)
+    
+    ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+    var err error
+    if req.Dir {
+        err = d.fs.client.Rmdir(ctx, d.handle, req.Name)
+    } else {
+        err = d.fs.client.Remove(ctx, d.handle, req.Name)
+    }
+    d.fs.changedDir(d.handle)
+    if err != nil {
+        log.Printf("Remove failed: %v", err)
+        return toErrno(err)
+    }
+    
+    return nil
+}
+
 // Mkdir implements the Mkdir method for FUSE directories
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
     log.Printf("Creating directory %s in directory %s (mode: %o)", 
         req.Name, d.path, req.Mode)
     
+    if err := d.fs.checkNewName(ctx, d.path, req.Name); err != nil {
+        return nil, err
+    }
+    
     // Always use full permissions for directories, ignoring potential umask effects
     attrs := &api.FileAttributes{
         Mode: 0777, // rwxrwxrwx
@@ -145,9 +253,10 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
     
     // Use NFS client to create the directory
     dirHandle, _, err := d.fs.client.Mkdir(ctx, d.handle, req.Name, attrs)
+    d.fs.changedDir(d.handle)
     if err != nil {
         log.Printf("Mkdir failed: %v", err)
-        return nil, fuse.EIO
+        return nil, failed(ctx, fuse.EIO)
     }
     
     // Create directory node
@@ -158,4 +267,87 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
     }
     
     return dir, nil
-}
\ No newline at end of file
+}
+
+// Symlink implements the Symlink method for FUSE directories
+func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+    log.Printf("Creating symlink %s -> %s in directory %s", req.NewName, req.Target, d.path)
+    
+    if err := d.fs.checkNewName(ctx, d.path, req.NewName); err != nil {
+        return nil, err
+    }
+    
+    ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+    linkHandle, attrs, err := d.fs.client.Symlink(ctx, d.handle, req.NewName, req.Target)
+    d.fs.changedDir(d.handle)
+    if err != nil {
+        log.Printf("Symlink failed: %v", err)
+        return nil, toErrno(err)
+    }
+    
+    link := &Symlink{
+        fs:     d.fs,
+        handle: linkHandle,
+        path:   d.path + "/" + req.NewName,
+    }
+    link.prefetched.Store(attrs)
+    
+    return link, nil
+}
+
+// Link implements the Link method for FUSE directories. The existing node
+// is returned so the kernel sees both names as the same inode.
+func (d *Dir) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs.Node, error) {
+    var handle []byte
+    var prefetched *atomic.Pointer[api.FileAttributes]
+    switch n := old.(type) {
+    case *File:
+        handle, prefetched = n.handle, &n.prefetched
+    case *Symlink:
+        handle, prefetched = n.handle, &n.prefetched
+    default:
+        return nil, fuse.Errno(syscall.EPERM)
+    }
+    log.Printf("Linking %s in directory %s", req.NewName, d.path)
+    
+    if err := d.fs.checkNewName(ctx, d.path, req.NewName); err != nil {
+        return nil, err
+    }
+    
+    ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+    attrs, err := d.fs.client.Link(ctx, handle, d.handle, req.NewName)
+    d.fs.changedDir(d.handle)
+    if err != nil {
+        log.Printf("Link failed: %v", err)
+        return nil, toErrno(err)
+    }
+    
+    // The link count went up; answer the Attr that follows with it
+    prefetched.Store(attrs)
+    
+    return old, nil
+}
+
+// Rename implements the Rename method for FUSE directories
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+    target, ok := newDir.(*Dir)
+    if !ok {
+        return fuse.EIO
+    }
+    log.Printf("Renaming %s/%s to %s/%s", d.path, req.OldName, target.path, req.NewName)
+    
+    if err := d.fs.checkNewName(ctx, target.path, req.NewName); err != nil {
+        return err
+    }
+    
+    ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+    err := d.fs.client.Rename(ctx, d.handle, req.OldName, target.handle, req.NewName)
+    d.fs.changedDir(d.handle)
+    d.fs.changedDir(target.handle)
+    if err != nil {
+        log.Printf("Rename failed: %v", err)
+        return toErrno(err)
+    }
+    
+    return nil
+}
@@ -0,0 +1,114 @@
+package fuse
+
+import (
+	"context"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// listingClient lists a directory holding a file and a subdirectory, with
+// or without ReadDirPlus, and counts lookups
+type listingClient struct {
+	countingClient
+
+	plus bool
+}
+
+func (c *listingClient) ReadDirPlus(ctx context.Context, dirHandle []byte) ([]*api.DirEntryPlus, error) {
+	if !c.plus {
+		return nil, client.NewNFSError("ReadDirPlus", api.Status_ERR_NOTSUPP, "operation not supported", client.ErrNotImplemented)
+	}
+	return []*api.DirEntryPlus{
+		{Name: ".", FileId: 1, FileHandle: dirHandle, Attributes: &api.FileAttributes{Type: api.FileType_DIRECTORY}},
+		{Name: "file", FileId: 2, FileHandle: []byte("file"), Attributes: &api.FileAttributes{Type: api.FileType_REGULAR, Size: 42}},
+		{Name: "sub", FileId: 3, FileHandle: []byte("sub"), Attributes: &api.FileAttributes{Type: api.FileType_DIRECTORY}},
+	}, nil
+}
+
+func (c *listingClient) ReadDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry, error) {
+	return []*api.DirEntry{{Name: "."}, {Name: "file"}, {Name: "sub"}}, nil
+}
+
+func (c *listingClient) PathConf(ctx context.Context, fileHandle []byte) (*api.PathConfResponse, error) {
+	return &api.PathConfResponse{}, nil
+}
+
+func (c *listingClient) Remove(ctx context.Context, dirHandle []byte, name string) error {
+	return nil
+}
+
+func TestReadDirAllPrimesLookups(t *testing.T) {
+	tests := []struct {
+		name      string
+		plus      bool
+		wantTypes map[string]fuse.DirentType
+		wantRPCs  int32
+	}{
+		{"ReadDirPlus", true, map[string]fuse.DirentType{".": fuse.DT_Dir, "file": fuse.DT_File, "sub": fuse.DT_Dir}, 0},
+		{"Plain ReadDir", false, map[string]fuse.DirentType{".": fuse.DT_Unknown, "file": fuse.DT_Unknown, "sub": fuse.DT_Unknown}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nfsClient := &listingClient{plus: tt.plus}
+			options := DefaultOptions()
+			options.LookupBatchWindow = 0
+			d := &Dir{fs: NewNFSFS(nfsClient, []byte("root"), options), handle: []byte("root"), path: "/"}
+
+			dirents, err := d.ReadDirAll(context.Background())
+			if err != nil {
+				t.Fatalf("ReadDirAll failed: %v", err)
+			}
+			if len(dirents) != len(tt.wantTypes) {
+				t.Fatalf("ReadDirAll returned %d entries, want %d", len(dirents), len(tt.wantTypes))
+			}
+			for _, dirent := range dirents {
+				if dirent.Type != tt.wantTypes[dirent.Name] {
+					t.Errorf("Entry %q has type %v, want %v", dirent.Name, dirent.Type, tt.wantTypes[dirent.Name])
+				}
+			}
+
+			// The kernel looks up each entry after listing it
+			for _, name := range []string{"file", "sub"} {
+				if _, err := d.Lookup(context.Background(), &fuse.LookupRequest{Name: name}, &fuse.LookupResponse{}); err != nil {
+					t.Fatalf("Lookup of %s failed: %v", name, err)
+				}
+			}
+			if got := nfsClient.lookups.Load(); got != tt.wantRPCs {
+				t.Errorf("Lookups sent %d RPCs, want %d", got, tt.wantRPCs)
+			}
+
+			// A primed result is used once; later lookups ask the server
+			if _, err := d.Lookup(context.Background(), &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{}); err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			if got := nfsClient.lookups.Load(); got != tt.wantRPCs+1 {
+				t.Errorf("Repeated lookup sent %d RPCs in total, want %d", got, tt.wantRPCs+1)
+			}
+		})
+	}
+}
+
+func TestChangedDirDropsPrimedLookups(t *testing.T) {
+	nfsClient := &listingClient{plus: true}
+	options := DefaultOptions()
+	options.LookupBatchWindow = 0
+	d := &Dir{fs: NewNFSFS(nfsClient, []byte("root"), options), handle: []byte("root"), path: "/"}
+
+	if _, err := d.ReadDirAll(context.Background()); err != nil {
+		t.Fatalf("ReadDirAll failed: %v", err)
+	}
+	if err := d.Remove(context.Background(), &fuse.RemoveRequest{Name: "file"}); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	// The listing predates the removal, so it must not answer the lookup
+	if _, err := d.Lookup(context.Background(), &fuse.LookupRequest{Name: "file"}, &fuse.LookupResponse{}); err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if got := nfsClient.lookups.Load(); got != 1 {
+		t.Errorf("Lookup after Remove sent %d RPCs, want 1", got)
+	}
+}
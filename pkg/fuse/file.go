@@ -3,10 +3,14 @@ package fuse
 import (
 	"time"
 	"context"
+	"errors"
 	"log"
+	"sync/atomic"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
 )
 
 // File represents a file in the filesystem
@@ -15,6 +19,20 @@ type File struct {
 	handle []byte  // NFS file handle for this file
 	path   string  // Path for logging/debugging
 	size   int64   // File size
+	
+	// Attributes returned by the Lookup that created the node, used once
+	prefetched atomic.Pointer[api.FileAttributes]
+	
+	// Change attribute of the data in the kernel page cache (0 = none)
+	cachedChange atomic.Uint64
+	
+	// Delegation the data in the kernel page cache was read under (nil =
+	// none)
+	cachedDeleg atomic.Pointer[client.FileDelegation]
+	
+	// Set once a lock has been taken through the file, so closes release
+	// locks only then
+	locked atomic.Bool
 }
 
 // Attr sets the attributes of the file
@@ -26,10 +44,37 @@ func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
 	attr.Mode = 0644
 	attr.Size = uint64(f.size)
 	attr.Mtime = time.Now()
+	attr.Valid = f.fs.options.AttrTimeout
+	
+	attrs := f.prefetched.Swap(nil)
+	if attrs == nil {
+		var err error
+		attrs, err = f.fs.client.GetAttr(ctx, f.handle)
+		if err != nil {
+			log.Printf("GetAttr failed for %s, using defaults: %v", f.path, err)
+			return nil
+		}
+	}
+	fillAttr(attr, attrs, f.fs.options.AttrTimeout)
+	f.size = int64(attrs.Size)
 	
 	return nil
 }
 
+// Access checks whether the caller may access the file
+func (f *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return f.fs.access(ctx, f.handle, f.path, false, req)
+}
+
+// Setattr changes the file's attributes
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	attrs, err := f.fs.setattr(ctx, f.handle, f.path, req, resp)
+	if attrs != nil {
+		f.size = int64(attrs.Size)
+	}
+	return err
+}
+
 // ReadAll reads all content from the file
 func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
 	log.Printf("Reading file: %s (size: %d bytes)", f.path, f.size)
@@ -38,7 +83,7 @@ func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
 	data, _, err := f.fs.client.Read(ctx, f.handle, 0, int(f.size))
 	if err != nil {
 		log.Printf("Read failed: %v", err)
-		return nil, fuse.EIO
+		return nil, failed(ctx, fuse.EIO)
 	}
 	
 	return data, nil
@@ -48,23 +93,79 @@ func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
     log.Printf("Opening file: %s (flags: %v)", f.path, req.Flags)
     
-    // Set direct IO flag to avoid kernel caching
-    // This ensures we don't have to implement Fsync() method
+    if f.fs.options.FileDelegations && req.Flags.IsReadOnly() && !f.fs.noFileDelegations.Load() {
+        // While the file is delegated no other client can change it, so
+        // the cached pages stay valid without asking the server. Pages
+        // cached before a delegation was granted may be stale.
+        if deleg := f.cachedDeleg.Load(); deleg != nil && deleg.Valid() {
+            resp.Flags |= fuse.OpenKeepCache
+            return f, nil
+        }
+        deleg, err := f.fs.client.DelegateFile(ctx, f.handle, false)
+        if err == nil {
+            f.cachedDeleg.Store(deleg)
+            f.size = int64(deleg.Attributes.Size)
+            return f, nil
+        }
+        if errors.Is(err, client.ErrNotImplemented) {
+            log.Printf("Server does not support file delegations; file contents will be revalidated on open")
+            f.fs.noFileDelegations.Store(true)
+        }
+    }
+    
+    if f.fs.options.CacheData && req.Flags.IsReadOnly() {
+        // The change attribute moves on every write, unlike mtime, which
+        // may not between writes in the same second. Keep the cached pages
+        // only if it still matches the one they were read under.
+        attrs, err := f.fs.client.GetAttr(ctx, f.handle)
+        if err == nil && attrs.Change != 0 {
+            if f.cachedChange.Swap(attrs.Change) == attrs.Change {
+                resp.Flags |= fuse.OpenKeepCache
+            }
+            f.size = int64(attrs.Size)
+            return f, nil
+        }
+    }
+    
+    // Set direct IO flag to avoid kernel caching, so writes reach the
+    // server as they are made rather than when pages are written back
     resp.Flags |= fuse.OpenDirectIO
     
     // Return the file as its own handle
     return f, nil
 }
 
+// Forget returns the file's delegation once the kernel drops the node, and
+// with it the cached pages the delegation kept valid
+func (f *File) Forget() {
+    if deleg := f.cachedDeleg.Swap(nil); deleg != nil {
+        deleg.Return()
+    }
+}
+
 // Read implements the Read method for FUSE files
 func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
     log.Printf("Reading file: %s (offset: %d, size: %d)", f.path, req.Offset, req.Size)
     
-    // Use NFS client to read the file at the requested offset
-    data, _, err := f.fs.client.Read(ctx, f.handle, req.Offset, req.Size)
-    if err != nil {
-        log.Printf("Read failed: %v", err)
-        return fuse.EIO
+    // Use NFS client to read the file at the requested offset, in as many
+    // RPCs as the server's read size needs
+    readSize, _ := f.fs.transferSizes(ctx)
+    var data []byte
+    for len(data) < req.Size {
+        count := min(req.Size-len(data), readSize)
+        chunk, eof, err := f.fs.client.Read(ctx, f.handle, req.Offset+int64(len(data)), count)
+        if err != nil {
+            log.Printf("Read failed: %v", err)
+            return failed(ctx, fuse.EIO)
+        }
+        if data == nil {
+            data = chunk
+        } else {
+            data = append(data, chunk...)
+        }
+        if eof || len(chunk) == 0 {
+            break
+        }
     }
     
     resp.Data = data
@@ -75,21 +176,42 @@ func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadR
 func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
     log.Printf("Writing to file: %s (offset: %d, size: %d bytes)", f.path, req.Offset, len(req.Data))
     
-    // Use NFS client to write the data
+    // Use NFS client to write the data, in as many RPCs as the server's
+    // write size needs
     // Use FILE_SYNC stability level (2) for safety
-    count, err := f.fs.client.Write(ctx, f.handle, req.Offset, req.Data, 2)
-    if err != nil {
-        log.Printf("Write failed: %v", err)
-        return fuse.EIO
-    }
-    
-    // Update response with bytes written
-    resp.Size = count
-    
-    // Update file size if needed
-    newSize := req.Offset + int64(count)
-    if newSize > f.size {
-        f.size = newSize
+    _, writeSize := f.fs.transferSizes(ctx)
+    for written := 0; written < len(req.Data) || written == 0; {
+        chunk := req.Data[written:min(written+writeSize, len(req.Data))]
+        offset := req.Offset + int64(written)
+        var count int
+        var err error
+        if req.FileFlags&fuse.OpenAppend != 0 {
+            // Our idea of the file size may be stale when other clients append
+            // too, so let the server choose the offset
+            offset, count, err = f.fs.client.Append(ctx, f.handle, chunk, 2)
+        } else {
+            count, err = f.fs.client.Write(ctx, f.handle, offset, chunk, 2)
+        }
+        if err != nil {
+            log.Printf("Write failed: %v", err)
+            if written > 0 {
+                break
+            }
+            return failed(ctx, fuse.EIO)
+        }
+        written += count
+        
+        // Update response with bytes written
+        resp.Size = written
+        
+        // Update file size if needed
+        newSize := offset + int64(count)
+        if newSize > f.size {
+            f.size = newSize
+        }
+        if count < len(chunk) || len(req.Data) == 0 {
+            break
+        }
     }
     
     return nil
@@ -99,6 +221,25 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.Wri
 func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
     log.Printf("Flushing file: %s", f.path)
     // In our implementation, writes are already synced to the server
-    // with FILE_SYNC stability, so we don't need additional action here
+    // with FILE_SYNC stability, so we don't need additional action here.
+    // Closing any descriptor releases the closing process's fcntl locks.
+    return f.releaseLocks(ctx, req.LockOwner)
+}
+
+// Fsync implements the Fsync method for FUSE files by asking the server to
+// commit the file to stable storage
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+    log.Printf("Syncing file: %s", f.path)
+    
+    _, err := f.fs.client.Commit(ctx, f.handle, 0, 0)
+    if errors.Is(err, client.ErrNotImplemented) {
+        // Every write this mount made was FILE_SYNC already
+        return nil
+    }
+    if err != nil {
+        log.Printf("Commit failed: %v", err)
+        return toErrno(err)
+    }
+    
     return nil
 }
\ No newline at end of file
@@ -0,0 +1,259 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// changingClient reports a file whose change attribute is set by the test
+type changingClient struct {
+	client.NFSClient
+
+	change atomic.Uint64
+}
+
+func (c *changingClient) GetAttr(ctx context.Context, fileHandle []byte) (*api.FileAttributes, error) {
+	return &api.FileAttributes{Type: api.FileType_REGULAR, Size: 4, Change: c.change.Load()}, nil
+}
+
+func TestOpenKeepsCacheWhileUnchanged(t *testing.T) {
+	nfsClient := &changingClient{}
+	nfsClient.change.Store(7)
+	options := DefaultOptions()
+	options.CacheData = true
+	f := &File{fs: NewNFSFS(nfsClient, []byte("root"), options), handle: []byte("file"), path: "/file"}
+
+	open := func(flags fuse.OpenFlags) fuse.OpenResponseFlags {
+		t.Helper()
+		resp := &fuse.OpenResponse{}
+		if _, err := f.Open(context.Background(), &fuse.OpenRequest{Flags: flags}, resp); err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		return resp.Flags
+	}
+
+	// First open: nothing cached yet, but the page cache is used
+	if flags := open(fuse.OpenReadOnly); flags&(fuse.OpenKeepCache|fuse.OpenDirectIO) != 0 {
+		t.Errorf("First open flags = %v, want neither keep-cache nor direct I/O", flags)
+	}
+	if flags := open(fuse.OpenReadOnly); flags&fuse.OpenKeepCache == 0 {
+		t.Errorf("Reopen of an unchanged file flags = %v, want keep-cache", flags)
+	}
+
+	// Any change on the server, however quick, drops the cache
+	nfsClient.change.Store(8)
+	if flags := open(fuse.OpenReadOnly); flags&fuse.OpenKeepCache != 0 {
+		t.Errorf("Reopen of a changed file flags = %v, want no keep-cache", flags)
+	}
+
+	// Writers always bypass the cache
+	if flags := open(fuse.OpenReadWrite); flags&fuse.OpenDirectIO == 0 {
+		t.Errorf("Open for writing flags = %v, want direct I/O", flags)
+	}
+
+	// As do servers without change attributes
+	nfsClient.change.Store(0)
+	if flags := open(fuse.OpenReadOnly); flags&fuse.OpenDirectIO == 0 {
+		t.Errorf("Open without change attribute flags = %v, want direct I/O", flags)
+	}
+}
+
+// settingClient records the changes sent by Setattr
+type settingClient struct {
+	client.NFSClient
+
+	changes client.AttrChanges
+}
+
+func (c *settingClient) SetAttr(ctx context.Context, fileHandle []byte, changes client.AttrChanges) (*api.FileAttributes, error) {
+	c.changes = changes
+	attrs := &api.FileAttributes{Type: api.FileType_REGULAR, Size: 0, Uid: 1000}
+	if changes.Mode != nil {
+		attrs.Mode = *changes.Mode
+	}
+	return attrs, nil
+}
+
+func TestSetattrSendsOneRequest(t *testing.T) {
+	nfsClient := &settingClient{}
+	f := &File{fs: NewNFSFS(nfsClient, []byte("root"), DefaultOptions()), handle: []byte("file"), path: "/file", size: 4}
+
+	// chmod u+s plus truncate and touch, as the kernel may combine them
+	req := &fuse.SetattrRequest{
+		Valid: fuse.SetattrMode | fuse.SetattrSize | fuse.SetattrAtimeNow | fuse.SetattrMtimeNow,
+		Mode:  os.ModeSetuid | 0750,
+		Size:  0,
+	}
+	req.Header.Uid = 1000
+	resp := &fuse.SetattrResponse{}
+	if err := f.Setattr(context.Background(), req, resp); err != nil {
+		t.Fatalf("Setattr failed: %v", err)
+	}
+
+	changes := nfsClient.changes
+	if changes.Mode == nil || *changes.Mode != 04750 {
+		t.Errorf("Mode sent = %v, want 4750", changes.Mode)
+	}
+	if changes.Size == nil || *changes.Size != 0 {
+		t.Errorf("Size sent = %v, want 0", changes.Size)
+	}
+	if !changes.AtimeNow || !changes.MtimeNow {
+		t.Error("Times were not left to the server's clock")
+	}
+	if changes.Uid != nil || changes.Gid != nil {
+		t.Errorf("Owner sent although not changed: %v:%v", changes.Uid, changes.Gid)
+	}
+
+	// The kernel gets the new attributes back
+	if resp.Attr.Mode != os.ModeSetuid|0750 {
+		t.Errorf("Returned mode = %v, want %v", resp.Attr.Mode, os.ModeSetuid|0750)
+	}
+	if f.size != 0 {
+		t.Errorf("File size after truncate = %d, want 0", f.size)
+	}
+}
+
+// committingClient answers Commit with a fixed error and counts the calls
+type committingClient struct {
+	client.NFSClient
+
+	commits atomic.Int32
+	err     error
+}
+
+func (c *committingClient) Commit(ctx context.Context, fileHandle []byte, offset uint64, count uint32) (uint64, error) {
+	c.commits.Add(1)
+	return 1, c.err
+}
+
+func TestFsyncCommits(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Committed", nil, nil},
+		{"Server without Commit", client.NewNFSError("Commit", api.Status_ERR_NOTSUPP, "operation not supported", client.ErrNotImplemented), nil},
+		{"Commit failed", client.NewNFSError("Commit", api.Status_ERR_IO, "I/O error", nil), fuse.EIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nfsClient := &committingClient{err: tt.err}
+			f := &File{fs: NewNFSFS(nfsClient, []byte("root"), DefaultOptions()), handle: []byte("file"), path: "/file"}
+
+			if err := f.Fsync(context.Background(), &fuse.FsyncRequest{}); err != tt.want {
+				t.Errorf("Fsync returned %v, want %v", err, tt.want)
+			}
+			if got := nfsClient.commits.Load(); got != 1 {
+				t.Errorf("Fsync sent %d commits, want 1", got)
+			}
+		})
+	}
+}
+
+// memoryClient holds one file in memory and takes small reads and writes,
+// recording how many bytes each carried
+type memoryClient struct {
+	client.NFSClient
+
+	data   []byte
+	counts []int
+}
+
+func (c *memoryClient) FsInfo(ctx context.Context, fileHandle []byte) (*api.FsInfoResponse, error) {
+	return &api.FsInfoResponse{Rtmax: 4, Wtmax: 3}, nil
+}
+
+func (c *memoryClient) Read(ctx context.Context, fileHandle []byte, offset int64, count int) ([]byte, bool, error) {
+	c.counts = append(c.counts, count)
+	end := min(int(offset)+count, len(c.data))
+	return append([]byte(nil), c.data[offset:end]...), end == len(c.data), nil
+}
+
+func (c *memoryClient) Write(ctx context.Context, fileHandle []byte, offset int64, data []byte, stability int) (int, error) {
+	c.counts = append(c.counts, len(data))
+	if end := int(offset) + len(data); end > len(c.data) {
+		c.data = append(c.data, make([]byte, end-len(c.data))...)
+	}
+	copy(c.data[offset:], data)
+	return len(data), nil
+}
+
+func TestReadWriteSplitByTransferSizes(t *testing.T) {
+	nfsClient := &memoryClient{}
+	f := &File{fs: NewNFSFS(nfsClient, []byte("root"), DefaultOptions()), handle: []byte("file"), path: "/file"}
+	ctx := context.Background()
+
+	var wresp fuse.WriteResponse
+	if err := f.Write(ctx, &fuse.WriteRequest{Offset: 0, Data: []byte("abcdefgh")}, &wresp); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if wresp.Size != 8 || string(nfsClient.data) != "abcdefgh" {
+		t.Errorf("Write reported %d bytes, stored %q", wresp.Size, nfsClient.data)
+	}
+	if want := []int{3, 3, 2}; !slices.Equal(nfsClient.counts, want) {
+		t.Errorf("Write sent %v bytes per RPC, want %v", nfsClient.counts, want)
+	}
+
+	// A read past the end stops at EOF
+	nfsClient.counts = nil
+	var rresp fuse.ReadResponse
+	if err := f.Read(ctx, &fuse.ReadRequest{Offset: 1, Size: 16}, &rresp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(rresp.Data) != "bcdefgh" {
+		t.Errorf("Read returned %q, want %q", rresp.Data, "bcdefgh")
+	}
+	if want := []int{4, 4}; !slices.Equal(nfsClient.counts, want) {
+		t.Errorf("Read asked for %v bytes per RPC, want %v", nfsClient.counts, want)
+	}
+}
+
+// hungClient never answers reads, failing them once their context is
+// canceled as a gRPC client does
+type hungClient struct {
+	memoryClient
+
+	started chan struct{}
+}
+
+func (c *hungClient) Read(ctx context.Context, fileHandle []byte, offset int64, count int) ([]byte, bool, error) {
+	close(c.started)
+	<-ctx.Done()
+	return nil, false, fmt.Errorf("Read RPC failed: %w", status.FromContextError(ctx.Err()).Err())
+}
+
+func TestInterruptCancelsRead(t *testing.T) {
+	nfsClient := &hungClient{started: make(chan struct{})}
+	f := &File{fs: NewNFSFS(nfsClient, []byte("root"), DefaultOptions()), handle: []byte("file"), path: "/file"}
+
+	// The FUSE server cancels the request's context on an interrupt
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-nfsClient.started
+		cancel()
+	}()
+	err := f.Read(ctx, &fuse.ReadRequest{Size: 4}, &fuse.ReadResponse{})
+	if err != fuse.Errno(syscall.EINTR) {
+		t.Errorf("Interrupted Read = %v, want EINTR", err)
+	}
+
+	// The same failure without an interrupt is an I/O error
+	if err := failed(context.Background(), fuse.EIO); err != fuse.EIO {
+		t.Errorf("failed without an interrupt = %v, want EIO", err)
+	}
+	if err := toErrno(fmt.Errorf("Readlink RPC failed: %w", status.Error(codes.Canceled, "canceled"))); err != fuse.Errno(syscall.EINTR) {
+		t.Errorf("toErrno of a canceled RPC = %v, want EINTR", err)
+	}
+}
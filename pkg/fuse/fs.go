@@ -1,8 +1,22 @@
 package fuse
 
 import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/example/nfsserver/pkg/api"
 	"github.com/example/nfsserver/pkg/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // NFSFS implements the FUSE filesystem interface
@@ -12,13 +26,39 @@ type NFSFS struct {
 	
 	// Root directory handle
 	rootHandle []byte
+	
+	// Kernel cache timeouts and other mount-wide options
+	options Options
+	
+	// Name and path limits reported by the server, fetched on first use
+	limitsOnce sync.Once
+	limits     *api.PathConfResponse
+	
+	// Read and write sizes reported by the server, fetched on first use
+	infoOnce sync.Once
+	info     *api.FsInfoResponse
+	
+	// Coalesces Lookups in the same directory into BulkLookup calls
+	lookups *lookupBatcher
+	
+	// Directory listings held under delegation
+	listings *listingCache
+	
+	// Set once the server turns out not to support ReadDirPlus
+	noReadDirPlus atomic.Bool
+	
+	// Set once the server turns out not to support file delegations
+	noFileDelegations atomic.Bool
 }
 
 // NewNFSFS creates a new NFS filesystem
-func NewNFSFS(nfsClient client.NFSClient, rootHandle []byte) *NFSFS {
+func NewNFSFS(nfsClient client.NFSClient, rootHandle []byte, options Options) *NFSFS {
 	return &NFSFS{
 		client:     nfsClient,
 		rootHandle: rootHandle,
+		options:    options,
+		lookups:    newLookupBatcher(nfsClient, options.LookupBatchWindow),
+		listings:   newListingCache(nfsClient, options.DirDelegations, options.Metrics),
 	}
 }
 
@@ -29,4 +69,265 @@ func (nfs *NFSFS) Root() (fs.Node, error) {
 		handle: nfs.rootHandle,
 		path:   "/",
 	}, nil
-}
\ No newline at end of file
+}
+
+// statfsBlockSize is the block size capacities are reported in to statfs(2)
+const statfsBlockSize = 4096
+
+// Statfs answers statfs(2), and so df, with the capacity of the export's
+// file system as reported by the server's FsStat. Servers without FsStat
+// leave the figures at zero, as they were before.
+func (nfs *NFSFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	stat, err := nfs.client.FsStat(ctx, nfs.rootHandle)
+	if errors.Is(err, client.ErrNotImplemented) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("FsStat failed: %v", err)
+		return toErrno(err)
+	}
+
+	resp.Bsize = statfsBlockSize
+	resp.Frsize = statfsBlockSize
+	resp.Blocks = stat.TotalBytes / statfsBlockSize
+	resp.Bfree = stat.FreeBytes / statfsBlockSize
+	resp.Bavail = stat.AvailBytes / statfsBlockSize
+	resp.Files = stat.TotalFiles
+	resp.Ffree = stat.FreeFiles
+
+	// PathConf knows how much longer names grow on the way to the server
+	resp.Namelen = stat.NameMax
+	if limits := nfs.pathConf(ctx); limits != nil && limits.NameMax > 0 {
+		resp.Namelen = limits.NameMax
+	}
+	return nil
+}
+
+// changedDir forgets what is cached about the entries of a directory after
+// this mount added, removed or renamed one
+func (nfs *NFSFS) changedDir(handle []byte) {
+	nfs.listings.invalidate(handle)
+	nfs.lookups.forget(handle)
+}
+
+// access answers an access(2) call for the node with the given handle.
+// The check runs on the server with the caller's uid/gid, so server-side
+// root squashing applies just as it does for the real operation. The mask
+// is turned into the matching rights; execute permission on a directory is
+// the right to look names up in it.
+func (nfs *NFSFS) access(ctx context.Context, handle []byte, path string, isDir bool, req *fuse.AccessRequest) error {
+	log.Printf("Checking access for %s (mask: %o, uid: %d)", path, req.Mask, req.Uid)
+
+	var rights uint32
+	if req.Mask&4 != 0 {
+		rights |= uint32(api.AccessRight_ACCESS_READ)
+	}
+	if req.Mask&2 != 0 {
+		rights |= uint32(api.AccessRight_ACCESS_MODIFY | api.AccessRight_ACCESS_EXTEND)
+	}
+	if req.Mask&1 != 0 {
+		if isDir {
+			rights |= uint32(api.AccessRight_ACCESS_LOOKUP)
+		} else {
+			rights |= uint32(api.AccessRight_ACCESS_EXECUTE)
+		}
+	}
+
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	if rights == 0 {
+		// F_OK: only existence is in question
+		if err := nfs.client.Access(ctx, handle, 0); err != nil {
+			log.Printf("Access check failed for %s: %v", path, err)
+			return toErrno(err)
+		}
+		return nil
+	}
+	granted, err := nfs.client.CheckAccess(ctx, handle, rights)
+	if err != nil {
+		log.Printf("Access check failed for %s: %v", path, err)
+		return toErrno(err)
+	}
+	if granted != rights {
+		log.Printf("Access denied for %s: granted %#x of %#x", path, granted, rights)
+		return syscall.EACCES
+	}
+
+	return nil
+}
+
+// setattr applies a setattr(2) from the kernel. chmod, chown, truncate and
+// utimes all become a single SetAttr request; the server applies the
+// permission rules. It returns the attributes after the change.
+func (nfs *NFSFS) setattr(ctx context.Context, handle []byte, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) (*api.FileAttributes, error) {
+	var changes client.AttrChanges
+	changed := false
+	if req.Valid.Mode() {
+		mode := unixMode(req.Mode)
+		changes.Mode = &mode
+		changed = true
+	}
+	if req.Valid.Uid() {
+		changes.Uid = &req.Uid
+		changed = true
+	}
+	if req.Valid.Gid() {
+		changes.Gid = &req.Gid
+		changed = true
+	}
+	if req.Valid.Size() {
+		changes.Size = &req.Size
+		changed = true
+	}
+	// Let the server pick the current time, which also lets non-owners touch
+	if req.Valid.AtimeNow() {
+		changes.AtimeNow = true
+		changed = true
+	} else if req.Valid.Atime() {
+		changes.Atime = &req.Atime
+		changed = true
+	}
+	if req.Valid.MtimeNow() {
+		changes.MtimeNow = true
+		changed = true
+	} else if req.Valid.Mtime() {
+		changes.Mtime = &req.Mtime
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	log.Printf("Setting attributes for %s (valid: %v)", path, req.Valid)
+
+	ctx = client.WithCredentials(ctx, req.Header.Uid, req.Header.Gid)
+	attrs, err := nfs.client.SetAttr(ctx, handle, changes)
+	if err != nil {
+		log.Printf("Setattr failed for %s: %v", path, err)
+		return nil, toErrno(err)
+	}
+
+	fillAttr(&resp.Attr, attrs, nfs.options.AttrTimeout)
+	return attrs, nil
+}
+
+// pathConf returns the server's name and path limits, fetched on first use,
+// or nil if the server does not report them
+func (nfs *NFSFS) pathConf(ctx context.Context) *api.PathConfResponse {
+	nfs.limitsOnce.Do(func() {
+		limits, err := nfs.client.PathConf(ctx, nfs.rootHandle)
+		if err != nil {
+			// Leave enforcement to the server
+			log.Printf("PathConf failed, not checking names locally: %v", err)
+			return
+		}
+		nfs.limits = limits
+	})
+	return nfs.limits
+}
+
+// transferSizes returns the largest read and write to send the server in
+// one RPC, fetched on first use. Sizes the server does not report are
+// unbounded, leaving requests as the kernel made them.
+func (nfs *NFSFS) transferSizes(ctx context.Context) (int, int) {
+	nfs.infoOnce.Do(func() {
+		info, err := nfs.client.FsInfo(ctx, nfs.rootHandle)
+		if err != nil {
+			log.Printf("FsInfo failed, not splitting reads and writes: %v", err)
+			return
+		}
+		nfs.info = info
+	})
+	read, write := math.MaxInt32, math.MaxInt32
+	if nfs.info != nil && nfs.info.Rtmax > 0 {
+		read = int(nfs.info.Rtmax)
+	}
+	if nfs.info != nil && nfs.info.Wtmax > 0 {
+		write = int(nfs.info.Wtmax)
+	}
+	return read, write
+}
+
+// checkName rejects, without a server round trip, names longer than the
+// server accepts
+func (nfs *NFSFS) checkName(ctx context.Context, name string) error {
+	limits := nfs.pathConf(ctx)
+	if limits != nil && limits.NameMax > 0 && len(name) > int(limits.NameMax) {
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	}
+	return nil
+}
+
+// checkNewName is like checkName but also applies the path length and
+// depth limits to a new entry called name in dirPath
+func (nfs *NFSFS) checkNewName(ctx context.Context, dirPath string, name string) error {
+	if err := nfs.checkName(ctx, name); err != nil {
+		return err
+	}
+	limits := nfs.pathConf(ctx)
+	if limits == nil {
+		return nil
+	}
+
+	newPath := path.Join("/", dirPath, name)
+	if limits.PathMax > 0 && len(newPath) > int(limits.PathMax) {
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	}
+	if limits.MaxDepth > 0 && strings.Count(newPath, "/") > int(limits.MaxDepth) {
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	}
+	return nil
+}
+
+// toErrno maps an NFS client error to the errno returned to the kernel
+func toErrno(err error) error {
+	var nfsErr *client.NFSError
+	switch {
+	case errors.As(err, &nfsErr) && nfsErr.Status == api.Status_ERR_PERM:
+		return fuse.Errno(syscall.EPERM)
+	case errors.As(err, &nfsErr) && nfsErr.Status == api.Status_ERR_INVAL:
+		return fuse.Errno(syscall.EINVAL)
+	case errors.As(err, &nfsErr) && nfsErr.Status == api.Status_ERR_ROFS:
+		return fuse.Errno(syscall.EROFS)
+	case errors.As(err, &nfsErr) && nfsErr.Status == api.Status_ERR_XDEV:
+		return fuse.Errno(syscall.EXDEV)
+	case errors.Is(err, client.ErrPermission):
+		return fuse.Errno(syscall.EACCES)
+	case errors.Is(err, client.ErrNotExist):
+		return fuse.ENOENT
+	case errors.Is(err, client.ErrExist):
+		return fuse.EEXIST
+	case errors.Is(err, client.ErrNameTooLong):
+		return fuse.Errno(syscall.ENAMETOOLONG)
+	case errors.Is(err, client.ErrInvalidHandle):
+		return fuse.Errno(syscall.ESTALE)
+	case errors.Is(err, client.ErrIsDir):
+		return fuse.Errno(syscall.EISDIR)
+	case errors.Is(err, client.ErrNotDir):
+		return fuse.Errno(syscall.ENOTDIR)
+	case errors.Is(err, client.ErrNotEmpty):
+		return fuse.Errno(syscall.ENOTEMPTY)
+	case errors.Is(err, client.ErrLocked):
+		return fuse.Errno(syscall.EAGAIN)
+	case errors.Is(err, client.ErrDeadlock):
+		return fuse.Errno(syscall.EDEADLK)
+	case errors.Is(err, client.ErrNotImplemented):
+		return fuse.Errno(syscall.ENOSYS)
+	case errors.Is(err, context.Canceled), status.Code(err) == codes.Canceled:
+		// An interrupted operation, e.g. one a hard mount was retrying
+		return fuse.Errno(syscall.EINTR)
+	default:
+		return fuse.EIO
+	}
+}
+
+// failed returns errno for an operation whose RPC failed, or EINTR if the
+// kernel interrupted it. The FUSE server cancels a request's context when
+// the kernel sends an interrupt for it, as on Ctrl-C, which cancels the
+// RPCs made with it, and the server's work for them, rather than leaving
+// them to time out.
+func failed(ctx context.Context, errno fuse.Errno) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return errno
+}
@@ -0,0 +1,257 @@
+package fuse
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+	"google.golang.org/grpc"
+)
+
+// serveExport serves exportDir over gRPC and returns the server address
+func serveExport(t *testing.T, exportDir string) string {
+	t.Helper()
+
+	localFS, err := local.NewLocalFileSystem(exportDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, localFS)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	api.RegisterNFSServiceServer(grpcServer, nfsServer)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	return lis.Addr().String()
+}
+
+// mountExport serves exportDir over gRPC and mounts it with FUSE, skipping
+// the test where FUSE mounts are not possible. It returns the mount point.
+func mountExport(t *testing.T, exportDir string) string {
+	t.Helper()
+
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("FUSE is not available")
+	}
+
+	clientConfig := client.DefaultConfig()
+	clientConfig.ServerAddress = serveExport(t, exportDir)
+	nfsClient, err := client.NewClient(clientConfig)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { nfsClient.Close() })
+
+	rootHandle, err := nfsClient.GetRootFileHandle(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	mountPoint := t.TempDir()
+	conn, err := fuse.Mount(mountPoint, fuse.FSName("nfs-fuse"), fuse.Subtype("nfs"))
+	if err != nil {
+		t.Skipf("Cannot mount FUSE file system: %v", err)
+	}
+	go fs.Serve(conn, NewNFSFS(nfsClient, rootHandle, Options{}))
+	t.Cleanup(func() {
+		fuse.Unmount(mountPoint)
+		conn.Close()
+	})
+
+	return mountPoint
+}
+
+// TestRsyncPreservesTimes copies a tree with rsync -a through the mount and
+// checks that modification times survive, so a second run transfers nothing
+func TestRsyncPreservesTimes(t *testing.T) {
+	rsync, err := exec.LookPath("rsync")
+	if err != nil {
+		t.Skip("rsync is not installed")
+	}
+
+	exportDir := t.TempDir()
+	mountPoint := mountExport(t, exportDir)
+
+	// Build a source tree with old modification times
+	srcDir := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string]string{
+		"a.txt":          "alpha",
+		"sub/b.txt":      "bravo",
+		"sub/deep/c.txt": "charlie",
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+	}
+
+	dest := filepath.Join(mountPoint, "backup") + "/"
+	if out, err := exec.Command(rsync, "-rt", srcDir+"/", dest).CombinedOutput(); err != nil {
+		t.Fatalf("rsync failed: %v\n%s", err, out)
+	}
+
+	// Contents and times arrived on the server
+	for name, content := range files {
+		path := filepath.Join(exportDir, "backup", name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("Wrong content for %s: %q", name, data)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("Wrong mtime for %s: got %v, want %v", name, info.ModTime(), mtime)
+		}
+	}
+
+	// With times preserved, a second run finds nothing to transfer
+	out, err := exec.Command(rsync, "-rt", "--itemize-changes", srcDir+"/", dest).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Second rsync failed: %v\n%s", err, out)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if strings.HasPrefix(line, ">f") {
+			t.Errorf("Second rsync transferred a file: %s", line)
+		}
+	}
+}
+
+// TestUtimesThroughMount sets times on a file through the mount, as touch -d does
+func TestUtimesThroughMount(t *testing.T) {
+	exportDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(exportDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	mountPoint := mountExport(t, exportDir)
+
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(mountPoint, "file.txt"), mtime, mtime); err != nil {
+		t.Fatalf("Chtimes through mount failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(exportDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Wrong mtime: got %v, want %v", info.ModTime(), mtime)
+	}
+	if info.Size() != int64(len("content")) {
+		t.Errorf("Times-only change altered the size: %d", info.Size())
+	}
+}
+
+// TestChmodTruncateThroughMount changes mode and size through the mount
+func TestChmodTruncateThroughMount(t *testing.T) {
+	exportDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(exportDir, "run.sh"), []byte("#!/bin/sh\necho hi\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	mountPoint := mountExport(t, exportDir)
+
+	if err := os.Chmod(filepath.Join(mountPoint, "run.sh"), 0755|os.ModeSetgid); err != nil {
+		t.Fatalf("Chmod through mount failed: %v", err)
+	}
+	if err := os.Truncate(filepath.Join(mountPoint, "run.sh"), 3); err != nil {
+		t.Fatalf("Truncate through mount failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(exportDir, "run.sh"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode() != 0755|os.ModeSetgid {
+		t.Errorf("Wrong mode: got %v, want %v", info.Mode(), 0755|os.ModeSetgid)
+	}
+	if info.Size() != 3 {
+		t.Errorf("Wrong size: got %d, want 3", info.Size())
+	}
+}
+
+// TestConcurrentMounts mounts two exports in one process and unmounts them
+// independently
+func TestConcurrentMounts(t *testing.T) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("FUSE is not available")
+	}
+
+	exports := []string{t.TempDir(), t.TempDir()}
+	mounts := make([]*MountedFS, len(exports))
+	for i, exportDir := range exports {
+		mount, err := Mount(MountOptions{
+			MountPoint:   t.TempDir(),
+			ServerAddr:   serveExport(t, exportDir),
+			CacheTimeout: time.Minute,
+		})
+		if err != nil {
+			t.Skipf("Cannot mount FUSE file system: %v", err)
+		}
+		t.Cleanup(func() { mount.Close() })
+		mounts[i] = mount
+	}
+
+	// Each mount reaches its own export
+	for i, mount := range mounts {
+		name := filepath.Join(mount.MountPoint(), "id.txt")
+		if err := os.WriteFile(name, []byte{'0' + byte(i)}, 0644); err != nil {
+			t.Fatalf("Write through mount %d failed: %v", i, err)
+		}
+	}
+	for i, exportDir := range exports {
+		data, err := os.ReadFile(filepath.Join(exportDir, "id.txt"))
+		if err != nil {
+			t.Fatalf("Failed to read export %d: %v", i, err)
+		}
+		if string(data) != string(rune('0'+i)) {
+			t.Errorf("Export %d contains %q", i, data)
+		}
+	}
+
+	// Closing one mount leaves the other serving
+	if err := mounts[0].Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := mounts[0].Wait(); err != nil {
+		t.Errorf("Wait after Close returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mounts[1].MountPoint(), "id.txt")); err != nil {
+		t.Errorf("Second mount stopped serving: %v", err)
+	}
+	if err := mounts[1].Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// maxDelegatedListings bounds the directory listings cached at once; each
+// holds a delegation, and so a stream, open on the server
+const maxDelegatedListings = 1024
+
+// listingCache answers ReadDir from listings held under a directory
+// delegation. Build tools poll the same directories over and over; while
+// the server has not recalled the delegation, the cached listing is exact.
+type listingCache struct {
+	client  client.NFSClient
+	enabled bool
+	metrics *client.Metrics
+
+	mu      sync.Mutex
+	entries map[string]*delegatedListing // keyed by directory handle
+
+	// Set once the server turns out not to support delegations
+	unsupported atomic.Bool
+}
+
+// delegatedListing is a directory listing and the delegation covering it
+type delegatedListing struct {
+	deleg   *client.DirDelegation
+	entries []*api.DirEntry
+}
+
+// newListingCache creates a cache; when disabled every call reads through
+func newListingCache(nfsClient client.NFSClient, enabled bool, metrics *client.Metrics) *listingCache {
+	return &listingCache{
+		client:  nfsClient,
+		enabled: enabled,
+		metrics: metrics,
+		entries: make(map[string]*delegatedListing),
+	}
+}
+
+// active reports whether listings are being cached under delegations
+func (c *listingCache) active() bool {
+	return c.enabled && !c.unsupported.Load()
+}
+
+// readDir returns the entries of dirHandle, from the cache when a
+// delegation for it is still held
+func (c *listingCache) readDir(ctx context.Context, dirHandle []byte) ([]*api.DirEntry, error) {
+	if !c.enabled || c.unsupported.Load() {
+		return c.client.ReadDir(ctx, dirHandle)
+	}
+
+	key := string(dirHandle)
+	c.mu.Lock()
+	cached := c.entries[key]
+	c.mu.Unlock()
+	hit := cached != nil && cached.deleg.Valid()
+	c.metrics.CacheLookup(client.CacheListing, hit)
+	if hit {
+		return cached.entries, nil
+	}
+
+	// Take the delegation before reading, so a change made after the read
+	// is guaranteed to recall it
+	deleg, err := c.client.DelegateDirectory(ctx, dirHandle)
+	if err != nil {
+		if errors.Is(err, client.ErrNotImplemented) {
+			log.Printf("Server does not support directory delegations; listings will not be cached")
+			c.unsupported.Store(true)
+		}
+		return c.client.ReadDir(ctx, dirHandle)
+	}
+
+	entries, err := c.client.ReadDir(ctx, dirHandle)
+	if err != nil {
+		deleg.Return()
+		return nil, err
+	}
+
+	listing := &delegatedListing{deleg: deleg, entries: entries}
+	c.store(key, listing)
+	go func() {
+		<-deleg.Recalled()
+		c.drop(key, listing)
+	}()
+
+	return entries, nil
+}
+
+// store caches listing under key, making room if the cache is full
+func (c *listingCache) store(key string, listing *delegatedListing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[key]; ok {
+		old.deleg.Return()
+	} else if len(c.entries) >= maxDelegatedListings {
+		for victim, old := range c.entries {
+			old.deleg.Return()
+			delete(c.entries, victim)
+			break
+		}
+	}
+	c.entries[key] = listing
+}
+
+// drop removes listing from the cache if it is still the one held for key
+func (c *listingCache) drop(key string, listing *delegatedListing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries[key] == listing {
+		delete(c.entries, key)
+	}
+}
+
+// invalidate forgets the listing of dirHandle after this mount changed it.
+// The server recalls the delegation too, but that may arrive after the
+// next ReadDir.
+func (c *listingCache) invalidate(dirHandle []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := string(dirHandle)
+	if listing, ok := c.entries[key]; ok {
+		listing.deleg.Return()
+		delete(c.entries, key)
+	}
+}
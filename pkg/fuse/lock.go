@@ -0,0 +1,106 @@
+package fuse
+
+import (
+	"context"
+	"log"
+	"math"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// fileLock returns the server lock for a kernel lock request's range and
+// owner. The kernel's ranges are inclusive and end at math.MaxInt64 for
+// locks running through the end of the file.
+func fileLock(owner fuse.LockOwner, lock fuse.FileLock) client.FileLock {
+	l := client.FileLock{
+		Owner:     uint64(owner),
+		Offset:    lock.Start,
+		Exclusive: lock.Type == fuse.LockWrite,
+	}
+	if lock.End < math.MaxInt64 {
+		l.Length = lock.End - lock.Start + 1
+	}
+	return l
+}
+
+// Lock takes a flock or fcntl lock on the file without waiting, failing
+// with EAGAIN if another owner, on this mount or another, holds a
+// conflicting one
+func (f *File) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	log.Printf("Locking file: %s (owner: %v, range: %d-%d)", f.path, req.LockOwner, req.Lock.Start, req.Lock.End)
+
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	if err := f.fs.client.Lock(ctx, f.handle, fileLock(req.LockOwner, req.Lock), false); err != nil {
+		return toErrno(err)
+	}
+	f.locked.Store(true)
+	return nil
+}
+
+// LockWait takes a lock like Lock, waiting for conflicting locks to be
+// released. An interrupt, such as a signal to the process waiting, ends
+// the wait with EINTR.
+func (f *File) LockWait(ctx context.Context, req *fuse.LockWaitRequest) error {
+	log.Printf("Waiting to lock file: %s (owner: %v, range: %d-%d)", f.path, req.LockOwner, req.Lock.Start, req.Lock.End)
+
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	if err := f.fs.client.Lock(ctx, f.handle, fileLock(req.LockOwner, req.Lock), true); err != nil {
+		return toErrno(err)
+	}
+	f.locked.Store(true)
+	return nil
+}
+
+// Unlock releases an owner's locks over a range
+func (f *File) Unlock(ctx context.Context, req *fuse.UnlockRequest) error {
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	if err := f.fs.client.Unlock(ctx, f.handle, fileLock(req.LockOwner, req.Lock)); err != nil {
+		return toErrno(err)
+	}
+	return nil
+}
+
+// QueryLock reports a lock that would keep the one described from being
+// taken, as for F_GETLK, leaving resp unlocked if there is none
+func (f *File) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	ctx = client.WithCredentials(ctx, req.Uid, req.Gid)
+	holder, err := f.fs.client.TestLock(ctx, f.handle, fileLock(req.LockOwner, req.Lock))
+	if err != nil {
+		return toErrno(err)
+	}
+
+	resp.Lock = fuse.FileLock{Type: fuse.LockUnlock}
+	if holder != nil {
+		resp.Lock = fuse.FileLock{Start: holder.Offset, End: math.MaxInt64, Type: fuse.LockRead}
+		if holder.Length != 0 {
+			resp.Lock.End = holder.Offset + holder.Length - 1
+		}
+		if holder.Exclusive {
+			resp.Lock.Type = fuse.LockWrite
+		}
+	}
+	return nil
+}
+
+// releaseLocks releases every lock owner holds on the file, once any lock
+// has been taken through it
+func (f *File) releaseLocks(ctx context.Context, owner fuse.LockOwner) error {
+	if !f.locked.Load() {
+		return nil
+	}
+	if err := f.fs.client.Unlock(ctx, f.handle, client.FileLock{Owner: uint64(owner)}); err != nil {
+		log.Printf("Unlock failed for %s: %v", f.path, err)
+		return toErrno(err)
+	}
+	return nil
+}
+
+// Release releases the flock locks taken through the file descriptor
+// being closed for the last time; fcntl locks are released by Flush
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock == 0 {
+		return nil
+	}
+	return f.releaseLocks(ctx, req.LockOwner)
+}
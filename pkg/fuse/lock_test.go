@@ -0,0 +1,90 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"math"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// lockingClient holds one lock at a time, refusing others with ErrLocked,
+// and records the unlocks it is sent
+type lockingClient struct {
+	client.NFSClient
+
+	held    *client.FileLock
+	unlocks []client.FileLock
+}
+
+func (c *lockingClient) Lock(ctx context.Context, fileHandle []byte, lock client.FileLock, wait bool) error {
+	if c.held != nil && c.held.Owner != lock.Owner {
+		return client.NewNFSError("Lock", api.Status_ERR_DENIED, "conflicting lock held", client.ErrLocked)
+	}
+	c.held = &lock
+	return nil
+}
+
+func (c *lockingClient) Unlock(ctx context.Context, fileHandle []byte, lock client.FileLock) error {
+	c.unlocks = append(c.unlocks, lock)
+	if c.held != nil && c.held.Owner == lock.Owner {
+		c.held = nil
+	}
+	return nil
+}
+
+func (c *lockingClient) TestLock(ctx context.Context, fileHandle []byte, lock client.FileLock) (*client.FileLock, error) {
+	if c.held != nil && c.held.Owner != lock.Owner {
+		return c.held, nil
+	}
+	return nil, nil
+}
+
+func TestFileLocks(t *testing.T) {
+	nfsClient := &lockingClient{}
+	f := &File{fs: NewNFSFS(nfsClient, []byte("root"), DefaultOptions()), handle: []byte("file"), path: "/file"}
+	ctx := context.Background()
+
+	// Closing before any lock was taken sends no unlock
+	if err := f.Flush(ctx, &fuse.FlushRequest{LockOwner: 1}); err != nil || len(nfsClient.unlocks) != 0 {
+		t.Fatalf("Flush = %v, sent %v; want no unlocks", err, nfsClient.unlocks)
+	}
+
+	// The kernel's inclusive ranges become offsets and lengths
+	lock := &fuse.LockRequest{LockOwner: 1, Lock: fuse.FileLock{Start: 10, End: 19, Type: fuse.LockWrite}}
+	if err := f.Lock(ctx, lock); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if want := (client.FileLock{Owner: 1, Offset: 10, Length: 10, Exclusive: true}); *nfsClient.held != want {
+		t.Errorf("Lock sent %+v, want %+v", *nfsClient.held, want)
+	}
+
+	// Another owner is refused, and told who holds the range
+	other := &fuse.LockRequest{LockOwner: 2, Lock: fuse.FileLock{Start: 0, End: math.MaxInt64, Type: fuse.LockRead}}
+	if err := f.Lock(ctx, other); !errors.Is(err, fuse.Errno(syscall.EAGAIN)) {
+		t.Errorf("Conflicting Lock = %v, want EAGAIN", err)
+	}
+	var resp fuse.QueryLockResponse
+	if err := f.QueryLock(ctx, &fuse.QueryLockRequest{LockOwner: 2, Lock: other.Lock}, &resp); err != nil {
+		t.Fatalf("QueryLock failed: %v", err)
+	}
+	if resp.Lock.Start != 10 || resp.Lock.End != 19 || resp.Lock.Type != fuse.LockWrite {
+		t.Errorf("QueryLock = %+v, want a write lock on 10-19", resp.Lock)
+	}
+
+	// Closing releases the owner's fcntl locks
+	if err := f.Flush(ctx, &fuse.FlushRequest{LockOwner: 1}); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if len(nfsClient.unlocks) != 1 || nfsClient.unlocks[0] != (client.FileLock{Owner: 1}) {
+		t.Errorf("Flush sent unlocks %v, want the whole file for owner 1", nfsClient.unlocks)
+	}
+	resp = fuse.QueryLockResponse{}
+	if err := f.QueryLock(ctx, &fuse.QueryLockRequest{LockOwner: 2, Lock: other.Lock}, &resp); err != nil || resp.Lock.Type != fuse.LockUnlock {
+		t.Errorf("QueryLock after close = %+v, %v; want unlocked", resp.Lock, err)
+	}
+}
@@ -0,0 +1,228 @@
+package fuse
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxLookupBatch bounds the names sent in one BulkLookup; a batch that
+// reaches it is sent without waiting for the window to close
+const maxLookupBatch = 256
+
+// maxPrimedLookups bounds the lookup results kept from directory listings
+const maxPrimedLookups = 16384
+
+// lookupBatcher coalesces Lookups in the same directory that arrive within
+// a short window into one BulkLookup RPC. A listing such as `ls -l` stats
+// every entry back to back, which would otherwise cost a round trip each.
+type lookupBatcher struct {
+	client client.NFSClient
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*lookupBatch // keyed by directory handle
+
+	// Results learned from ReadDirPlus, each used for one Lookup
+	primed map[primedName]primedLookup
+
+	// Set once the server turns out not to support BulkLookup
+	unsupported atomic.Bool
+}
+
+// lookupBatch collects the names looked up in one directory
+type lookupBatch struct {
+	dirHandle []byte
+	names     []string
+	results   map[string]lookupResult
+
+	// Span of the lookup that opened the batch, which the RPC is traced
+	// under
+	span trace.SpanContext
+
+	// Closed once results is filled in
+	done chan struct{}
+}
+
+// lookupResult is the outcome of looking up one name
+type lookupResult struct {
+	handle []byte
+	attrs  *api.FileAttributes
+	err    error
+}
+
+// primedName identifies a name in a directory
+type primedName struct {
+	dir  string // directory handle
+	name string
+}
+
+// primedLookup is a lookup result that came with a directory listing
+type primedLookup struct {
+	handle  []byte
+	attrs   *api.FileAttributes
+	expires time.Time
+}
+
+// newLookupBatcher creates a batcher; a window of zero disables batching
+func newLookupBatcher(nfsClient client.NFSClient, window time.Duration) *lookupBatcher {
+	return &lookupBatcher{
+		client:  nfsClient,
+		window:  window,
+		pending: make(map[string]*lookupBatch),
+		primed:  make(map[primedName]primedLookup),
+	}
+}
+
+// prime records the entries of a ReadDirPlus listing of dirHandle, so the
+// Lookup the kernel sends for each of them right after the listing is
+// answered without an RPC. Each result answers one Lookup within ttl.
+func (b *lookupBatcher) prime(dirHandle []byte, entries []*api.DirEntryPlus, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	expires := time.Now().Add(ttl)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.primed)+len(entries) > maxPrimedLookups {
+		clear(b.primed)
+	}
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." || entry.Attributes == nil {
+			continue
+		}
+		b.primed[primedName{string(dirHandle), entry.Name}] = primedLookup{
+			handle:  entry.FileHandle,
+			attrs:   entry.Attributes,
+			expires: expires,
+		}
+	}
+}
+
+// forget drops the primed results for dirHandle after this mount changed it
+func (b *lookupBatcher) forget(dirHandle []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range b.primed {
+		if key.dir == string(dirHandle) {
+			delete(b.primed, key)
+		}
+	}
+}
+
+// takePrimed returns and removes the primed result for name, if still fresh
+func (b *lookupBatcher) takePrimed(dirHandle []byte, name string) (primedLookup, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := primedName{string(dirHandle), name}
+	p, ok := b.primed[key]
+	if !ok {
+		return primedLookup{}, false
+	}
+	delete(b.primed, key)
+	return p, time.Now().Before(p.expires)
+}
+
+// lookup resolves name in dirHandle, joining the directory's current batch
+func (b *lookupBatcher) lookup(ctx context.Context, dirHandle []byte, name string) ([]byte, *api.FileAttributes, error) {
+	if p, ok := b.takePrimed(dirHandle, name); ok {
+		return p.handle, p.attrs, nil
+	}
+	if b.window <= 0 || b.unsupported.Load() {
+		return b.client.Lookup(ctx, dirHandle, name)
+	}
+
+	key := string(dirHandle)
+
+	b.mu.Lock()
+	batch := b.pending[key]
+	if batch == nil {
+		batch = &lookupBatch{dirHandle: dirHandle, done: make(chan struct{}), span: trace.SpanContextFromContext(ctx)}
+		b.pending[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+	if !batch.has(name) {
+		batch.names = append(batch.names, name)
+	}
+	full := len(batch.names) >= maxLookupBatch
+	b.mu.Unlock()
+
+	if full {
+		b.flush(key, batch)
+	}
+
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	r := batch.results[name]
+	return r.handle, r.attrs, r.err
+}
+
+// flush sends batch unless it has already been sent
+func (b *lookupBatcher) flush(key string, batch *lookupBatch) {
+	b.mu.Lock()
+	if b.pending[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	// The batch is shared by several callers, so it is not bound to any
+	// one of their contexts; the client timeout still applies
+	ctx := trace.ContextWithSpanContext(context.Background(), batch.span)
+	batch.results = b.run(ctx, batch.dirHandle, batch.names)
+	close(batch.done)
+}
+
+// run looks up names, with one RPC where possible
+func (b *lookupBatcher) run(ctx context.Context, dirHandle []byte, names []string) map[string]lookupResult {
+	results := make(map[string]lookupResult, len(names))
+
+	if len(names) > 1 && !b.unsupported.Load() {
+		bulk, err := b.client.BulkLookup(ctx, dirHandle, names)
+		if err == nil {
+			for i, r := range bulk {
+				results[names[i]] = lookupResult{
+					handle: r.FileHandle,
+					attrs:  r.Attributes,
+					err:    client.StatusToError("Lookup", r.Status),
+				}
+			}
+			return results
+		}
+		if errors.Is(err, client.ErrNotImplemented) {
+			log.Printf("Server does not support BulkLookup, looking up names one at a time")
+			b.unsupported.Store(true)
+		} else {
+			log.Printf("BulkLookup of %d names failed, retrying individually: %v", len(names), err)
+		}
+	}
+
+	for _, name := range names {
+		handle, attrs, err := b.client.Lookup(ctx, dirHandle, name)
+		results[name] = lookupResult{handle: handle, attrs: attrs, err: err}
+	}
+	return results
+}
+
+// has reports whether name is already part of the batch
+func (batch *lookupBatch) has(name string) bool {
+	for _, n := range batch.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
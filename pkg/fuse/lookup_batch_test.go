@@ -0,0 +1,111 @@
+package fuse
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// countingClient answers lookups for any name except "missing" and counts RPCs
+type countingClient struct {
+	client.NFSClient
+
+	bulk        bool
+	lookups     atomic.Int32
+	bulkLookups atomic.Int32
+}
+
+func (c *countingClient) Lookup(ctx context.Context, dirHandle []byte, name string) ([]byte, *api.FileAttributes, error) {
+	c.lookups.Add(1)
+	if name == "missing" {
+		return nil, nil, client.StatusToError("Lookup", api.Status_ERR_NOENT)
+	}
+	return []byte(name), &api.FileAttributes{Type: api.FileType_REGULAR}, nil
+}
+
+func (c *countingClient) BulkLookup(ctx context.Context, dirHandle []byte, names []string) ([]*api.BulkLookupResult, error) {
+	c.bulkLookups.Add(1)
+	if !c.bulk {
+		return nil, client.NewNFSError("BulkLookup", api.Status_ERR_NOTSUPP, "operation not supported", client.ErrNotImplemented)
+	}
+	results := make([]*api.BulkLookupResult, len(names))
+	for i, name := range names {
+		if name == "missing" {
+			results[i] = &api.BulkLookupResult{Status: api.Status_ERR_NOENT}
+			continue
+		}
+		results[i] = &api.BulkLookupResult{
+			Status:     api.Status_OK,
+			FileHandle: []byte(name),
+			Attributes: &api.FileAttributes{Type: api.FileType_REGULAR},
+		}
+	}
+	return results, nil
+}
+
+// lookupAll looks up names concurrently and returns the handle or error of each
+func lookupAll(t *testing.T, b *lookupBatcher, names []string) []error {
+	t.Helper()
+
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			handle, _, err := b.lookup(context.Background(), []byte("dir"), name)
+			if err == nil && string(handle) != name {
+				t.Errorf("Lookup(%s) returned handle %q", name, handle)
+			}
+			errs[i] = err
+		}(i, name)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestLookupBatcher(t *testing.T) {
+	names := []string{"a", "b", "c", "missing", "a"}
+
+	c := &countingClient{bulk: true}
+	b := newLookupBatcher(c, 50*time.Millisecond)
+	errs := lookupAll(t, b, names)
+
+	if got := c.bulkLookups.Load(); got != 1 {
+		t.Errorf("Sent %d BulkLookups, want 1", got)
+	}
+	if got := c.lookups.Load(); got != 0 {
+		t.Errorf("Sent %d single Lookups, want 0", got)
+	}
+	for i, err := range errs {
+		if (names[i] == "missing") != (err != nil) {
+			t.Errorf("Lookup(%s) error = %v", names[i], err)
+		}
+	}
+
+	// Against a server without BulkLookup, names are looked up one by one
+	// and batching is switched off for good
+	c = &countingClient{bulk: false}
+	b = newLookupBatcher(c, 50*time.Millisecond)
+	lookupAll(t, b, names[:3])
+	lookupAll(t, b, names[:3])
+	if got := c.bulkLookups.Load(); got != 1 {
+		t.Errorf("Sent %d BulkLookups to a server without support, want 1", got)
+	}
+	if got := c.lookups.Load(); got != 6 {
+		t.Errorf("Sent %d single Lookups, want 6", got)
+	}
+
+	// A zero window disables batching
+	c = &countingClient{bulk: true}
+	b = newLookupBatcher(c, 0)
+	lookupAll(t, b, names[:3])
+	if got := c.bulkLookups.Load(); got != 0 {
+		t.Errorf("Sent %d BulkLookups with batching disabled, want 0", got)
+	}
+}
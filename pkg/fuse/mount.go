@@ -2,12 +2,12 @@ package fuse
 
 import (
 	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 	"context"
 	"log"
+	"path"
+	"strings"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -18,38 +18,168 @@ import (
 type MountOptions struct {
 	MountPoint   string
 	ServerAddr   string  // NFS server address
+	Export       string  // Export to mount (empty = the server's default)
 	ReadOnly     bool
-	CacheTimeout time.Duration
+	CacheTimeout time.Duration // TTL of the NFS client's handle cache
+	Timeout      time.Duration // Timeout of each RPC (0 = 30s)
 	Debug        bool
+
+	// Hard mounts block operations through server outages until the
+	// server answers or the caller is interrupted; soft mounts, the
+	// default, fail them with EIO once the client's retries run out
+	Hard bool
+
+	// Keepalive pings to notice a vanished server (see client.Config;
+	// KeepaliveTime 0 = no pings, KeepaliveTimeout 0 = 20s)
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+
+	// How dns:/// server addresses are re-resolved and balanced over
+	// (see client.Config; ResolveInterval 0 = 30s)
+	ResolveInterval time.Duration
+	LoadBalancing   string
+
+	// Kernel entry and attribute cache timeouts, independent of
+	// CacheTimeout. See Options for the consistency tradeoffs.
+	EntryTimeout time.Duration
+	AttrTimeout  time.Duration
+
+	// How long Lookups wait to be batched together (0 = no batching)
+	LookupBatchWindow time.Duration
+
+	// Cache read-only file contents in the kernel, validated on open
+	CacheData bool
+
+	// Cache directory listings while the server delegates them
+	DirDelegations bool
+
+	// Cache read-only file contents while the server delegates the file
+	FileDelegations bool
+
+	// List directories sorted by name from a server-side snapshot
+	SortedReadDir bool
+
+	// Servers exporting a mirrored copy, used for reads the primary
+	// fails with an I/O error
+	Replicas []string
+
+	// Master key encrypting file contents on the client (nil = none)
+	EncryptionKey []byte
+
+	// Encrypt file names as well as contents
+	EncryptNames bool
+
+	// File holding the bearer token proving the mount's identity to
+	// servers that require one (empty = none)
+	AuthTokenFile string
+
+	// Directory keeping recently read blocks across mounts (empty = none)
+	// and its size limit in bytes (0 = client.DefaultConfig's)
+	DiskCacheDir  string
+	DiskCacheSize int
+
+	// Collects the mount's RPC counts and cache hit rates (nil = the
+	// client keeps its own)
+	Metrics *client.Metrics
+
+	// Snapshot of the export to mount instead of its live tree, as named
+	// by nfsadmin snapshot (empty = the live tree). Snapshot mounts are
+	// always read-only.
+	Snapshot string
+}
+
+// snapshotsDir is where servers with snapshots show them, below the root
+const snapshotsDir = "/.snapshots"
+
+// mountRoot returns the handle of the directory mounted: the export's
+// root, or the root of the snapshot called snapshot in it
+func mountRoot(ctx context.Context, nfsClient client.NFSClient, snapshot string) ([]byte, error) {
+	if snapshot == "" {
+		return nfsClient.GetRootFileHandle(ctx)
+	}
+	if snapshot == "." || snapshot == ".." || strings.Contains(snapshot, "/") {
+		return nil, fmt.Errorf("invalid snapshot name %q", snapshot)
+	}
+	handle, err := nfsClient.LookupPath(ctx, path.Join(snapshotsDir, snapshot))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %q: %w", snapshot, err)
+	}
+	return handle, nil
 }
 
-// Mount mounts the NFS filesystem at the specified mount point
-func Mount(options MountOptions) error {
+// MountedFS is an NFS file system mounted and served in the background.
+// Several can be mounted in one process; each has its own client and FUSE
+// connection.
+type MountedFS struct {
+	mountPoint string
+
+	// Closed once serving has stopped and the connections are closed
+	done     chan struct{}
+	serveErr error
+
+	closeMu sync.Mutex
+}
+
+// Mount connects to the NFS server and mounts it at options.MountPoint. It
+// returns once the file system is mounted; requests are served until it is
+// unmounted, by Close or externally.
+func Mount(options MountOptions) (*MountedFS, error) {
 	// Create NFS client
-	config := &client.Config{
-		ServerAddress: options.ServerAddr,
-		Timeout:       30 * time.Second,
-		MaxRetries:    3,
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	config := client.DefaultConfig()
+	config.ServerAddress = options.ServerAddr
+	config.Export = options.Export
+	config.Timeout = timeout
+	config.Hard = options.Hard
+	config.KeepaliveTime = options.KeepaliveTime
+	if options.KeepaliveTimeout > 0 {
+		config.KeepaliveTimeout = options.KeepaliveTimeout
+	}
+	if options.ResolveInterval > 0 {
+		config.ResolveInterval = options.ResolveInterval
 	}
+	config.LoadBalancing = options.LoadBalancing
+	config.CacheTTL = options.CacheTimeout
+	config.ReplicaAddresses = options.Replicas
+	config.EncryptionKey = options.EncryptionKey
+	config.EncryptNames = options.EncryptNames
+	config.DiskCacheDir = options.DiskCacheDir
+	config.SortedReadDir = options.SortedReadDir
+	config.AuthTokenFile = options.AuthTokenFile
+	if options.DiskCacheSize > 0 {
+		config.DiskCacheSize = options.DiskCacheSize
+	}
+	config.Metrics = options.Metrics
 	
 	log.Printf("Connecting to NFS server at %s", options.ServerAddr)
 	nfsClient, err := client.NewClient(config)
 	if err != nil {
-		return fmt.Errorf("failed to connect to NFS server: %w", err)
+		return nil, fmt.Errorf("failed to connect to NFS server: %w", err)
 	}
 	
 	// Get root handle
 	log.Println("Getting root directory handle")
-	rootHandle, err := nfsClient.GetRootFileHandle(context.Background())
+	rootHandle, err := mountRoot(context.Background(), nfsClient, options.Snapshot)
 	if err != nil {
 		nfsClient.Close()
-		return fmt.Errorf("failed to get root handle: %w", err)
+		return nil, fmt.Errorf("failed to get root handle: %w", err)
+	}
+	if options.Snapshot != "" {
+		// Snapshots cannot change; the kernel need not ask
+		options.ReadOnly = true
 	}
 	
 	// Mount options
 	mountOpts := []fuse.MountOption{
 		fuse.FSName("nfs-fuse"),
 		fuse.Subtype("nfs"),
+		// Send flock and fcntl locks to the server, so they hold across
+		// mounts, rather than keeping them in this kernel
+		fuse.LockingFlock(),
+		fuse.LockingPOSIX(),
 	}
 
 	if options.ReadOnly {
@@ -57,54 +187,88 @@ func Mount(options MountOptions) error {
 	}
 
 	if options.Debug {
+		// The debug hook is process-wide, so it logs every mount
 		fuse.Debug = func(msg interface{}) {
 			fmt.Printf("FUSE: %v\n", msg)
 		}
 		mountOpts = append(mountOpts, fuse.AllowOther())
 	}
 
-	// Mount the filesystem
+	// Mount the filesystem; this returns once the kernel has accepted it
 	log.Printf("Mounting FUSE filesystem at %s", options.MountPoint)
 	c, err := fuse.Mount(options.MountPoint, mountOpts...)
 	if err != nil {
 		nfsClient.Close()
-		return fmt.Errorf("failed to mount: %w", err)
+		return nil, fmt.Errorf("failed to mount: %w", err)
 	}
-	defer c.Close()
 
 	// Create the filesystem
-	nfsFS := NewNFSFS(nfsClient, rootHandle)
+	nfsFS := NewNFSFS(nfsClient, rootHandle, Options{
+		EntryTimeout:      options.EntryTimeout,
+		AttrTimeout:       options.AttrTimeout,
+		LookupBatchWindow: options.LookupBatchWindow,
+		CacheData:         options.CacheData,
+		DirDelegations:    options.DirDelegations,
+		FileDelegations:   options.FileDelegations,
+		Metrics:           options.Metrics,
+	})
+
+	m := &MountedFS{
+		mountPoint: options.MountPoint,
+		done:       make(chan struct{}),
+	}
 
 	// Serve the filesystem until unmounted
 	go func() {
-		log.Println("Starting FUSE server")
-		if err := fs.Serve(c, nfsFS); err != nil {
-			log.Printf("Error serving filesystem: %v", err)
+		defer close(m.done)
+		log.Printf("Serving FUSE filesystem at %s", m.mountPoint)
+		server := fs.New(c, &fs.Config{WithContext: traceRequest})
+		if err := server.Serve(nfsFS); err != nil {
+			log.Printf("Error serving filesystem at %s: %v", m.mountPoint, err)
+			m.serveErr = err
+		}
+		c.Close()
+		if err := nfsClient.Close(); err != nil {
+			log.Printf("Error closing NFS connection for %s: %v", m.mountPoint, err)
+			if m.serveErr == nil {
+				m.serveErr = err
+			}
 		}
+		log.Printf("Filesystem at %s unmounted, NFS connection closed", m.mountPoint)
 	}()
-	
-	// 删除c.Ready和c.MountError的检查，
-	// 等待一小段时间确保挂载成功
-	log.Println("Waiting for mount to be ready...")
-	time.Sleep(1 * time.Second)
-
-	// Wait for SIGINT or SIGTERM to unmount
-	log.Println("FUSE filesystem mounted successfully")
-	log.Println("Press Ctrl+C to unmount")
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-
-	// Unmount
-	log.Println("Unmounting filesystem...")
-	if err := Unmount(options.MountPoint); err != nil {
-		log.Printf("Warning: failed to unmount cleanly: %v", err)
+
+	return m, nil
+}
+
+// MountPoint returns the directory the file system is mounted on
+func (m *MountedFS) MountPoint() string {
+	return m.mountPoint
+}
+
+// Wait blocks until the file system has been unmounted and its connections
+// closed, and returns the error that stopped serving, if any
+func (m *MountedFS) Wait() error {
+	<-m.done
+	return m.serveErr
+}
+
+// Close unmounts the file system and waits for serving to stop. It fails,
+// leaving the mount in place, if the kernel refuses to unmount it, for
+// example because files on it are still open.
+func (m *MountedFS) Close() error {
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	default:
 	}
-	
-	// Close NFS client
-	nfsClient.Close()
-	log.Println("NFS connection closed")
 
+	if err := Unmount(m.mountPoint); err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", m.mountPoint, err)
+	}
+	<-m.done
 	return nil
 }
 
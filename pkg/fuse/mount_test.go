@@ -0,0 +1,42 @@
+package fuse
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// rootClient resolves paths to handles naming them
+type rootClient struct {
+	client.NFSClient
+}
+
+func (c *rootClient) GetRootFileHandle(ctx context.Context) ([]byte, error) {
+	return []byte("/"), nil
+}
+
+func (c *rootClient) LookupPath(ctx context.Context, path string) ([]byte, error) {
+	return []byte(path), nil
+}
+
+func TestMountRoot(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		snapshot string
+		want     string
+	}{
+		{"", "/"},
+		{"before-upgrade", "/.snapshots/before-upgrade"},
+	} {
+		handle, err := mountRoot(ctx, &rootClient{}, test.snapshot)
+		if err != nil || string(handle) != test.want {
+			t.Errorf("mountRoot(%q) = %q, %v; want %q", test.snapshot, handle, err, test.want)
+		}
+	}
+	for _, snapshot := range []string{"..", "a/b"} {
+		if handle, err := mountRoot(ctx, &rootClient{}, snapshot); err == nil {
+			t.Errorf("mountRoot(%q) = %q, want an error", snapshot, handle)
+		}
+	}
+}
@@ -1,6 +1,62 @@
 package fuse
 
+import (
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+)
+
 // Options contains configuration options for the FUSE filesystem
 type Options struct {
-	// Additional options would be defined here
+	// EntryTimeout is how long the kernel may cache a name -> node
+	// resolution returned by Lookup. Longer values save Lookup round trips
+	// but files created, removed or renamed by other clients may stay
+	// invisible (or appear to still exist) for up to this long. Zero
+	// disables entry caching.
+	EntryTimeout time.Duration
+
+	// AttrTimeout is how long the kernel may cache file attributes (size,
+	// mode, times). Longer values save GetAttr round trips but changes made
+	// by other clients, such as a file growing, are seen late. Zero makes
+	// every stat(2) go to the server.
+	AttrTimeout time.Duration
+
+	// LookupBatchWindow is how long a Lookup waits for others in the same
+	// directory so they can be sent together in one BulkLookup. It adds up
+	// to this much latency to a lone Lookup. Zero disables batching.
+	LookupBatchWindow time.Duration
+
+	// CacheData lets the kernel cache the contents of files opened read-only.
+	// The cache is kept across opens only while the server's change
+	// attribute for the file is unchanged, so a reopen always sees writes
+	// made elsewhere, however close together in time. Files opened for
+	// writing always bypass the cache, as do all files on servers that do
+	// not report change attributes.
+	CacheData bool
+
+	// DirDelegations caches directory listings for as long as the server
+	// delegates them. The server recalls a delegation as soon as an entry
+	// is added, removed or renamed, so repeated listings of unchanged
+	// directories cost no round trips yet stay accurate.
+	DirDelegations bool
+
+	// FileDelegations keeps the kernel's cache of a file opened read-only
+	// for as long as the server delegates the file. No other client can
+	// change the file meanwhile, so reopening it needs no round trip to
+	// check for changes; the server recalls the delegation before another
+	// client writes, and the next open drops the cache.
+	FileDelegations bool
+
+	// Metrics counts hits and misses of the listing cache (nil = not
+	// counted), usually the client's own (see client.Config.Metrics)
+	Metrics *client.Metrics
+}
+
+// DefaultOptions returns the options used when none are specified
+func DefaultOptions() Options {
+	return Options{
+		EntryTimeout:      1 * time.Minute,
+		AttrTimeout:       1 * time.Minute,
+		LookupBatchWindow: 2 * time.Millisecond,
+	}
 }
@@ -0,0 +1,71 @@
+package fuse
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/config"
+)
+
+// Settings registers the settings of the FUSE client on s. They fill
+// options, which supply the defaults, and keyFile as for config.Client.
+// They live here rather than in package config so the server, which uses
+// config, does not depend on FUSE.
+func Settings(s *config.Set, options *MountOptions, keyFile *string) {
+	s.String(&options.MountPoint, "mount", "Mount point for NFS filesystem")
+	s.String(&options.ServerAddr, "server", "NFS server address")
+	s.String(&options.Export, "export", "Export to mount on servers with several (empty = the server's default)")
+	s.Bool(&options.ReadOnly, "readonly", "Mount filesystem as read-only")
+	s.String(&options.Snapshot, "snapshot", "Mount this snapshot of the export, from nfsadmin snapshot, read-only instead of the live tree")
+	s.Bool(&options.Debug, "debug", "Enable debug logging")
+	s.Duration(&options.Timeout, "timeout", "Timeout for each RPC, e.g. 30s")
+	s.Duration(&options.CacheTimeout, "cache-ttl", "How long resolved file handles are cached")
+	s.Duration(&options.EntryTimeout, "entry-timeout", "How long the kernel caches name lookups (0 = no caching)")
+	s.Duration(&options.AttrTimeout, "attr-timeout", "How long the kernel caches file attributes (0 = no caching)")
+	s.Duration(&options.LookupBatchWindow, "lookup-batch-window", "How long lookups in one directory wait to be sent together (0 = no batching)")
+	s.Bool(&options.CacheData, "cache-data", "Cache read-only file contents in the kernel, revalidated on each open")
+	s.Bool(&options.DirDelegations, "dir-delegations", "Cache directory listings until the server recalls them")
+	s.Bool(&options.FileDelegations, "file-delegations", "Cache read-only file contents in the kernel until the server recalls the file")
+	s.Bool(&options.SortedReadDir, "sorted-readdir", "List directories sorted by name from a snapshot taken when the listing starts")
+	s.String(&options.AuthTokenFile, "auth-token-file", "File holding the bearer token sent to servers establishing identity from tokens, reread when it changes")
+	s.List(&options.Replicas, "replicas", "Comma-separated mirror servers to read from when the primary reports an I/O error")
+	options.KeepaliveTimeout = cmp.Or(options.KeepaliveTimeout, client.DefaultConfig().KeepaliveTimeout)
+	config.Keepalive(s, &options.KeepaliveTime, &options.KeepaliveTimeout)
+	options.ResolveInterval = cmp.Or(options.ResolveInterval, client.DefaultConfig().ResolveInterval)
+	config.Balancing(s, &options.ResolveInterval, &options.LoadBalancing)
+	config.Encryption(s, keyFile, &options.EncryptNames)
+	options.DiskCacheSize = cmp.Or(options.DiskCacheSize, client.DefaultConfig().DiskCacheSize)
+	config.DiskCache(s, &options.DiskCacheDir, &options.DiskCacheSize)
+
+	mountOptions := []string{"soft"}
+	if options.Hard {
+		mountOptions = []string{"hard"}
+	}
+	s.List(&mountOptions, "o", "Comma-separated mount options: hard (block through server outages until interrupted) or soft (fail with EIO once retries run out)")
+
+	s.Check(func() error {
+		if options.MountPoint == "" {
+			return errors.New("-mount must name the mount point")
+		}
+		if options.ServerAddr == "" {
+			return errors.New("-server must not be empty")
+		}
+		if options.Snapshot == "." || options.Snapshot == ".." || strings.Contains(options.Snapshot, "/") {
+			return fmt.Errorf("-snapshot must name a snapshot, got %q", options.Snapshot)
+		}
+		for _, option := range mountOptions {
+			switch option {
+			case "hard":
+				options.Hard = true
+			case "soft":
+				options.Hard = false
+			default:
+				return fmt.Errorf("-o: unknown mount option %q (want hard or soft)", option)
+			}
+		}
+		return nil
+	})
+}
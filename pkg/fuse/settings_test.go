@@ -0,0 +1,65 @@
+package fuse
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/config"
+)
+
+func TestSettings(t *testing.T) {
+	var options MountOptions
+	var keyFile string
+	s := config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-encrypt-names"}); err == nil ||
+		!strings.Contains(err.Error(), "-encrypt-names requires -key-file") {
+		t.Errorf("Load returned %v, want the key file to be required", err)
+	}
+
+	options = MountOptions{}
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-server", "nfs:2049"}); err == nil || !strings.Contains(err.Error(), "-mount") {
+		t.Errorf("Load without a mount point returned %v", err)
+	}
+
+	options = MountOptions{}
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-o", "hard"}); err != nil || !options.Hard {
+		t.Errorf("Load with -o hard returned %v, hard %v", err, options.Hard)
+	}
+
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-o", "hard,intr"}); err == nil ||
+		!strings.Contains(err.Error(), `unknown mount option "intr"`) {
+		t.Errorf("Load with an unknown mount option returned %v", err)
+	}
+
+	// gRPC clients cannot ping more often than every 10s
+	options = MountOptions{}
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-keepalive", "5s"}); err == nil ||
+		!strings.Contains(err.Error(), "-keepalive must be 0 or at least 10s") {
+		t.Errorf("Load with a 5s keepalive returned %v", err)
+	}
+	options = MountOptions{}
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-keepalive", "30s"}); err != nil ||
+		options.KeepaliveTime != 30*time.Second || options.KeepaliveTimeout != 20*time.Second {
+		t.Errorf("Load with -keepalive 30s returned %v, keepalive %v/%v", err, options.KeepaliveTime, options.KeepaliveTimeout)
+	}
+
+	options = MountOptions{}
+	s = config.NewSet("nfs-fuse", "NFS_FUSE")
+	Settings(s, &options, &keyFile)
+	if err := s.Load([]string{"-mount", "/mnt/nfs", "-server", "nfs:2049", "-snapshot", "../live"}); err == nil ||
+		!strings.Contains(err.Error(), "-snapshot must name a snapshot") {
+		t.Errorf("Load with a snapshot path returned %v", err)
+	}
+}
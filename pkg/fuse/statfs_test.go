@@ -0,0 +1,64 @@
+package fuse
+
+import (
+	"context"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/client"
+)
+
+// statfsClient reports fixed capacities, or none if the server lacks FsStat
+type statfsClient struct {
+	client.NFSClient
+
+	unsupported bool
+}
+
+func (c *statfsClient) FsStat(ctx context.Context, fileHandle []byte) (*api.FsStatResponse, error) {
+	if c.unsupported {
+		return nil, client.NewNFSError("FsStat", api.Status_ERR_NOTSUPP, "operation not supported", client.ErrNotImplemented)
+	}
+	return &api.FsStatResponse{
+		TotalBytes: 100 * statfsBlockSize,
+		FreeBytes:  40 * statfsBlockSize,
+		AvailBytes: 30 * statfsBlockSize,
+		TotalFiles: 1000,
+		FreeFiles:  900,
+		NameMax:    255,
+	}, nil
+}
+
+func (c *statfsClient) PathConf(ctx context.Context, fileHandle []byte) (*api.PathConfResponse, error) {
+	// As the encrypting client reports it
+	return &api.PathConfResponse{NameMax: 143}, nil
+}
+
+func TestStatfs(t *testing.T) {
+	nfs := NewNFSFS(&statfsClient{}, []byte("root"), DefaultOptions())
+	var resp fuse.StatfsResponse
+	if err := nfs.Statfs(context.Background(), &fuse.StatfsRequest{}, &resp); err != nil {
+		t.Fatalf("Statfs failed: %v", err)
+	}
+	want := fuse.StatfsResponse{
+		Blocks:  100,
+		Bfree:   40,
+		Bavail:  30,
+		Files:   1000,
+		Ffree:   900,
+		Bsize:   statfsBlockSize,
+		Namelen: 143,
+		Frsize:  statfsBlockSize,
+	}
+	if resp != want {
+		t.Errorf("Statfs returned %v, want %v", &resp, &want)
+	}
+
+	// Without FsStat df shows an empty file system rather than failing
+	nfs = NewNFSFS(&statfsClient{unsupported: true}, []byte("root"), DefaultOptions())
+	resp = fuse.StatfsResponse{}
+	if err := nfs.Statfs(context.Background(), &fuse.StatfsRequest{}, &resp); err != nil {
+		t.Errorf("Statfs without FsStat returned %v, want nil", err)
+	}
+}
@@ -0,0 +1,55 @@
+package fuse
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// Symlink represents a symbolic link in the filesystem
+type Symlink struct {
+	fs     *NFSFS // Reference to the file system
+	handle []byte // NFS file handle for this link
+	path   string // Path for logging/debugging
+
+	// Attributes returned by the Lookup that created the node, used once
+	prefetched atomic.Pointer[api.FileAttributes]
+}
+
+// Attr sets the attributes of the link
+func (l *Symlink) Attr(ctx context.Context, attr *fuse.Attr) error {
+	log.Printf("Getting attributes for symlink: %s", l.path)
+
+	// Default attributes in case of failure
+	attr.Mode = os.ModeSymlink | 0777
+	attr.Mtime = time.Now()
+	attr.Valid = l.fs.options.AttrTimeout
+
+	attrs := l.prefetched.Swap(nil)
+	if attrs == nil {
+		var err error
+		attrs, err = l.fs.client.GetAttr(ctx, l.handle)
+		if err != nil {
+			log.Printf("GetAttr failed for %s, using defaults: %v", l.path, err)
+			return nil
+		}
+	}
+	fillAttr(attr, attrs, l.fs.options.AttrTimeout)
+
+	return nil
+}
+
+// Readlink returns the target of the link
+func (l *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	target, err := l.fs.client.Readlink(ctx, l.handle)
+	if err != nil {
+		log.Printf("Readlink failed for %s: %v", l.path, err)
+		return "", toErrno(err)
+	}
+	return target, nil
+}
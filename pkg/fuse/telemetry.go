@@ -0,0 +1,34 @@
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"bazil.org/fuse"
+	"github.com/example/nfsserver/pkg/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// traceRequest starts a span for a FUSE request, ended once the request is
+// answered, so the RPCs made to serve it are its children. Names are left
+// out, as they may be encrypted on their way to the server.
+func traceRequest(ctx context.Context, req fuse.Request) context.Context {
+	hdr := req.Hdr()
+	attrs := []attribute.KeyValue{
+		attribute.Int64("fuse.node", int64(hdr.Node)),
+		attribute.Int64("fuse.pid", int64(hdr.Pid)),
+	}
+	switch r := req.(type) {
+	case *fuse.ReadRequest:
+		attrs = append(attrs, attribute.Int64("fuse.offset", r.Offset), attribute.Int("fuse.size", r.Size))
+	case *fuse.WriteRequest:
+		attrs = append(attrs, attribute.Int64("fuse.offset", r.Offset), attribute.Int("fuse.size", len(r.Data)))
+	}
+
+	// The server cancels a request's context once it is answered
+	op := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", req), "*fuse."), "Request")
+	ctx, span := telemetry.StartSpan(ctx, "fuse."+op, attrs...)
+	context.AfterFunc(ctx, func() { span.End() })
+	return ctx
+}
@@ -59,18 +59,56 @@ func FSInfoToProtoAttributes(info fs.FileInfo) *api.FileAttributes {
 		Used:      info.Blocks * 512, // Block size is typically 512 bytes
 		RdevMajor: uint32(info.Rdev >> 32),
 		RdevMinor: uint32(info.Rdev & 0xFFFFFFFF),
-		Fileid:    uint64(info.Size),  // This should actually come from inode
+		Fileid:    info.FileId,
 		Atime:     atime,
 		Mtime:     mtime,
 		Ctime:     ctime,
 		Blksize:   info.BlockSize,
 		Blocks:    uint32(info.Blocks),
+		Change:    info.ChangeID,
+		Acl:       FSACLToProto(info.ACL),
 	}
 }
 
+// FSACLToProto converts a filesystem ACL to an NFS Acl, nil if acl is
+func FSACLToProto(acl fs.ACL) *api.Acl {
+	if acl == nil {
+		return nil
+	}
+	result := &api.Acl{Entries: make([]*api.AclEntry, len(acl))}
+	for i, entry := range acl {
+		result.Entries[i] = &api.AclEntry{
+			Tag:  api.AclTag(entry.Tag),
+			Id:   entry.ID,
+			Perm: uint32(entry.Perm),
+		}
+	}
+	return result
+}
+
+// ProtoACLToFS converts an NFS Acl to a filesystem ACL, nil if acl is, and
+// empty but not nil if it has no entries
+func ProtoACLToFS(acl *api.Acl) fs.ACL {
+	if acl == nil {
+		return nil
+	}
+	result := make(fs.ACL, len(acl.Entries))
+	for i, entry := range acl.Entries {
+		result[i] = fs.ACLEntry{
+			Tag:  fs.ACLTag(entry.Tag),
+			ID:   entry.Id,
+			Perm: fs.FileMode(entry.Perm),
+		}
+	}
+	return result
+}
+
 // ProtoAttributesToFSAttr converts NFS FileAttributes to filesystem FileAttr
 func ProtoAttributesToFSAttr(attr *api.FileAttributes) fs.FileAttr {
 	result := fs.FileAttr{}
+	if attr == nil {
+		return result
+	}
 
 	// Only set the fields that are present in the request
 	if attr.Mode != 0 {
@@ -123,4 +161,55 @@ func ProtoCredsToFSCreds(creds *api.Credentials) fs.Credentials {
 		GID:    creds.Gid,
 		Groups: creds.Groups,
 	}
-}
\ No newline at end of file
+}
+
+// ProtoAttributesToFSInfo converts NFS FileAttributes to filesystem
+// FileInfo, the reverse of FSInfoToProtoAttributes
+func ProtoAttributesToFSInfo(attr *api.FileAttributes) fs.FileInfo {
+	if attr == nil {
+		return fs.FileInfo{}
+	}
+
+	// Convert file type
+	var fileType fs.FileType
+	switch attr.Type {
+	case api.FileType_DIRECTORY:
+		fileType = fs.FileTypeDirectory
+	case api.FileType_SYMLINK:
+		fileType = fs.FileTypeSymlink
+	case api.FileType_BLOCK:
+		fileType = fs.FileTypeBlock
+	case api.FileType_CHAR:
+		fileType = fs.FileTypeChar
+	case api.FileType_FIFO:
+		fileType = fs.FileTypeFIFO
+	case api.FileType_SOCKET:
+		fileType = fs.FileTypeSocket
+	default:
+		fileType = fs.FileTypeRegular
+	}
+
+	fileTime := func(t *api.FileTime) time.Time {
+		if t == nil {
+			return time.Time{}
+		}
+		return time.Unix(t.Seconds, int64(t.Nano))
+	}
+	return fs.FileInfo{
+		Type:       fileType,
+		FileId:     attr.Fileid,
+		Mode:       fs.FileMode(attr.Mode),
+		Size:       int64(attr.Size),
+		Uid:        attr.Uid,
+		Gid:        attr.Gid,
+		Nlink:      attr.Nlink,
+		Rdev:       uint64(attr.RdevMajor)<<32 | uint64(attr.RdevMinor),
+		BlockSize:  attr.Blksize,
+		Blocks:     uint64(attr.Blocks),
+		AccessTime: fileTime(attr.Atime),
+		ModifyTime: fileTime(attr.Mtime),
+		ChangeTime: fileTime(attr.Ctime),
+		ChangeID:   attr.Change,
+		ACL:        ProtoACLToFS(attr.Acl),
+	}
+}
@@ -2,123 +2,95 @@
 package nfs
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os"
-	"syscall"
+	"log/slog"
+	"time"
 
 	"github.com/example/nfsserver/pkg/api"
 	"github.com/example/nfsserver/pkg/fs"
 )
 
-// MapErrorToStatus converts a Go error to an NFS status code
+// MapErrorToStatus converts a Go error to an NFS status code: the status
+// the file system gave the error, or the status of an NFSError. Errors of
+// neither kind are logged and answered with ERR_IO.
 func MapErrorToStatus(err error) api.Status {
 	if err == nil {
 		return api.Status_OK
 	}
-
-	// Map filesystem errors to NFS status codes
-	if errors.Is(err, fs.ErrNotExist) {
-		return api.Status_ERR_NOENT
-	} else if errors.Is(err, fs.ErrPermission) {
-		return api.Status_ERR_ACCES
-	} else if errors.Is(err, fs.ErrExist) {
-		return api.Status_ERR_EXIST
-	} else if errors.Is(err, fs.ErrIO) {
-		return api.Status_ERR_IO
-	} else if errors.Is(err, fs.ErrIsDir) {
-		return api.Status_ERR_ISDIR
-	} else if errors.Is(err, fs.ErrNotDir) {
-		return api.Status_ERR_NOTDIR
-	} else if errors.Is(err, fs.ErrInvalidName) {
-		return api.Status_ERR_INVAL
-	} else if errors.Is(err, fs.ErrInvalidHandle) {
-		return api.Status_ERR_BADHANDLE
-	} else if errors.Is(err, fs.ErrNoSpace) {
-		return api.Status_ERR_NOSPC
-	} else if errors.Is(err, fs.ErrReadOnly) {
-		return api.Status_ERR_ROFS
-	} else if errors.Is(err, fs.ErrBadCookie) {
-		return api.Status_ERR_BAD_COOKIE
-	} else if errors.Is(err, fs.ErrStale) {
-		return api.Status_ERR_STALE
-	} else if errors.Is(err, fs.ErrNotSupported) {
-		return api.Status_ERR_NOTSUPP
-	} else if errors.Is(err, fs.ErrNotEmpty) {
-		return api.Status_ERR_NOTEMPTY
+	status, ok := statusOf(err)
+	if !ok {
+		LogUnknownError(err)
 	}
+	return status
+}
 
-	// Map standard Go errors
-	if errors.Is(err, os.ErrPermission) {
-		return api.Status_ERR_PERM
-	} else if errors.Is(err, os.ErrNotExist) {
-		return api.Status_ERR_NOENT
-	} else if errors.Is(err, os.ErrExist) {
-		return api.Status_ERR_EXIST
+// statusOf returns the status MapErrorToStatus answers err with, and
+// whether err is of a kind that has one
+func statusOf(err error) (api.Status, bool) {
+	if status := fs.Classify(err); status != api.Status_OK {
+		return status, true
 	}
-
-	// Map syscall errors
-	var errno syscall.Errno
-	if errors.As(err, &errno) {
-		switch errno {
-		case syscall.EPERM:
-			return api.Status_ERR_PERM
-		case syscall.ENOENT:
-			return api.Status_ERR_NOENT
-		case syscall.EIO:
-			return api.Status_ERR_IO
-		case syscall.ENXIO:
-			return api.Status_ERR_NXIO
-		case syscall.EACCES:
-			return api.Status_ERR_ACCES
-		case syscall.EEXIST:
-			return api.Status_ERR_EXIST
-		case syscall.ENODEV:
-			return api.Status_ERR_NODEV
-		case syscall.ENOTDIR:
-			return api.Status_ERR_NOTDIR
-		case syscall.EISDIR:
-			return api.Status_ERR_ISDIR
-		case syscall.EINVAL:
-			return api.Status_ERR_INVAL
-		case syscall.EFBIG:
-			return api.Status_ERR_FBIG
-		case syscall.ENOSPC:
-			return api.Status_ERR_NOSPC
-		case syscall.EROFS:
-			return api.Status_ERR_ROFS
-		case syscall.ENAMETOOLONG:
-			return api.Status_ERR_NAMETOOLONG
-		case syscall.ENOTEMPTY:
-			return api.Status_ERR_NOTEMPTY
-		}
+	var nfsErr *NFSError
+	if errors.As(err, &nfsErr) {
+		return nfsErr.Status, true
 	}
-
-	// Default for unrecognized errors
-	LogUnknownError(err)
-	return api.Status_ERR_IO
+	return api.Status_ERR_IO, false
 }
 
 // LogUnknownError logs detailed information about unrecognized errors
 func LogUnknownError(err error) {
-	log.Printf("Unknown error type: %T, message: %v", err, err)
+	slog.Warn("Unknown error type", "type", fmt.Sprintf("%T", err), "err", err)
 }
 
-// LogRequest logs information about a received NFS request
-func LogRequest(op string, reqID string, clientAddr string) {
-	log.Printf("NFS request: %s, ID: %s, Client: %s", op, reqID, clientAddr)
+// LogRequest logs a received NFS request at debug level, under the request
+// ID ctx carries. The tag identifies the workload that issued it and may be
+// empty.
+func LogRequest(ctx context.Context, op string, clientAddr string, tag string) {
+	if tag != "" {
+		slog.DebugContext(ctx, "NFS request", "op", op, "client", clientAddr, "tag", tag)
+		return
+	}
+	slog.DebugContext(ctx, "NFS request", "op", op, "client", clientAddr)
 }
 
-// LogResponse logs information about an NFS response
-func LogResponse(op string, reqID string, status api.Status, duration string) {
-	log.Printf("NFS response: %s, ID: %s, Status: %s, Duration: %s", 
-		op, reqID, status.String(), duration)
+// LogResponse logs the status an NFS request was answered with at debug
+// level
+func LogResponse(ctx context.Context, op string, status api.Status, duration time.Duration) {
+	slog.DebugContext(ctx, "NFS response", "op", op, "status", status.String(), "duration", duration)
 }
 
-// LogError logs an error with its context
-func LogError(op string, reqID string, err error) {
-	log.Printf("NFS error: %s, ID: %s, Error: %v", op, reqID, err)
+// LogError logs an error serving an NFS request. Errors the client caused,
+// such as a missing file, are logged at debug level; failures of the
+// server at error level.
+func LogError(ctx context.Context, op string, err error) {
+	level := slog.LevelDebug
+	switch status, _ := statusOf(err); status {
+	case api.Status_ERR_IO, api.Status_ERR_SERVERFAULT:
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "NFS error", append(ErrorAttrs(err), "op", op, "err", err)...)
+}
+
+// ErrorAttrs returns log attributes classifying err: its NFS status and,
+// for file system errors, the failed file system operation and the path
+// and inode it failed on, where known
+func ErrorAttrs(err error) []any {
+	status, _ := statusOf(err)
+	attrs := []any{"status", status.String()}
+	var fsErr *fs.FSError
+	if !errors.As(err, &fsErr) {
+		return attrs
+	}
+	attrs = append(attrs, "fs_op", fsErr.Op)
+	if fsErr.Path != "" {
+		attrs = append(attrs, "path", fsErr.Path)
+	}
+	if fsErr.Inode != 0 {
+		attrs = append(attrs, "inode", fsErr.Inode)
+	}
+	return attrs
 }
 
 // NFSError represents an error with NFS status code
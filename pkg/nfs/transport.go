@@ -0,0 +1,22 @@
+package nfs
+
+const (
+	// MaxTransferSize is the largest read or write size servers and
+	// clients support
+	MaxTransferSize = 64 << 20
+
+	// MessageOverhead is the room a read response or write request needs
+	// beyond its data, for the handle, attributes and other fields
+	MessageOverhead = 64 << 10
+
+	// defaultMessageLimit is gRPC's default limit on received messages
+	defaultMessageLimit = 4 << 20
+)
+
+// MessageLimit returns the gRPC message size limit that carries reads and
+// writes of transferSize bytes. It is never below gRPC's default, so
+// directory listings and other messages not bounded by the transfer size
+// are not limited further.
+func MessageLimit(transferSize int) int {
+	return max(transferSize+MessageOverhead, defaultMessageLimit)
+}
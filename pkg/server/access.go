@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// accessRights maps each right of an NFSv3-style Access request to the
+// permission bits it needs on a directory and on anything else. A zero
+// mode means the right does not apply to that kind of object.
+var accessRights = []struct {
+	right    api.AccessRight
+	dirMode  fs.FileMode
+	fileMode fs.FileMode
+}{
+	{api.AccessRight_ACCESS_READ, 4, 4},
+	{api.AccessRight_ACCESS_LOOKUP, 1, 0},
+	{api.AccessRight_ACCESS_MODIFY, 2, 2},
+	{api.AccessRight_ACCESS_EXTEND, 2, 2},
+	// Removing an entry needs write and search permission on the directory
+	{api.AccessRight_ACCESS_DELETE, 3, 0},
+	{api.AccessRight_ACCESS_EXECUTE, 0, 1},
+}
+
+// grantedAccess returns the subset of the requested rights that creds hold
+// on path. Each distinct permission check is made once.
+func (s *NFSServer) grantedAccess(ctx context.Context, path string, isDir bool, requested uint32, creds fs.Credentials) uint32 {
+	allowed := make(map[fs.FileMode]bool)
+	var granted uint32
+	for _, r := range accessRights {
+		if requested&uint32(r.right) == 0 {
+			continue
+		}
+		mode := r.fileMode
+		if isDir {
+			mode = r.dirMode
+		}
+		if mode == 0 {
+			continue
+		}
+		ok, checked := allowed[mode]
+		if !checked {
+			ok = s.fileSystem.Access(ctx, path, mode, creds) == nil
+			allowed[mode] = ok
+		}
+		if ok {
+			granted |= uint32(r.right)
+		}
+	}
+	return granted
+}
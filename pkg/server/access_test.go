@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestAccess(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a file readable by everyone but writable only by its owner
+	testFilePath := filepath.Join(tempDir, "testfile.txt")
+	if err := os.WriteFile(testFilePath, []byte("test content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chmod(testFilePath, 0644); err != nil {
+		t.Fatalf("Failed to chmod test file: %v", err)
+	}
+	ownerUID, _ := fileOwner(t, testFilePath)
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server without root squashing so the owner can be root
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	fileHandle, err := server.issueHandle(context.Background(), "/testfile.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	otherUID := ownerUID + 1000
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		uid        uint32
+		mode       uint32
+		wantStatus api.Status
+	}{
+		{"Owner read", ownerUID, 4, api.Status_OK},
+		{"Owner write", ownerUID, 2, api.Status_OK},
+		{"Other read", otherUID, 4, api.Status_OK},
+		{"Other write", otherUID, 2, api.Status_ERR_ACCES},
+		{"Other execute", otherUID, 1, api.Status_ERR_ACCES},
+		{"Existence only", otherUID, 0, api.Status_OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &api.AccessRequest{
+				FileHandle: fileHandle,
+				Credentials: &api.Credentials{
+					Uid: tc.uid,
+					Gid: tc.uid,
+				},
+				Mode: tc.mode,
+			}
+
+			resp, err := server.Access(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Access failed: %v", err)
+			}
+
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Unexpected status: got %v, want %v", resp.Status, tc.wantStatus)
+			}
+			if resp.Attributes == nil {
+				t.Error("Expected attributes in response")
+			}
+		})
+	}
+
+	// Invalid handle
+	resp, err := server.Access(context.Background(), &api.AccessRequest{
+		FileHandle: []byte{1, 2, 3},
+		Mode:       4,
+	})
+	if err != nil {
+		t.Fatalf("Access with invalid handle failed unexpectedly: %v", err)
+	}
+	if resp.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("Expected ERR_BADHANDLE status for invalid handle, got: %v", resp.Status)
+	}
+
+	// Root squashing maps root to the anonymous user, which may not write
+	policy := *server.policy()
+	policy.rootSquash = true
+	server.setPolicy(policy)
+	if ownerUID != 0 {
+		t.Skip("Root squash check requires the test file to be owned by root")
+	}
+	resp, err = server.Access(context.Background(), &api.AccessRequest{
+		FileHandle:  fileHandle,
+		Credentials: &api.Credentials{Uid: 0, Gid: 0},
+		Mode:        2,
+	})
+	if err != nil {
+		t.Fatalf("Access failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_ACCES {
+		t.Errorf("Expected squashed root to be denied write access, got %v", resp.Status)
+	}
+}
+
+func TestAccessRights(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "script.sh"), nil, 0754); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "dropbox"), 0733); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	// Mkdir is subject to the umask
+	if err := os.Chmod(filepath.Join(tempDir, "dropbox"), 0733); err != nil {
+		t.Fatalf("Failed to chmod test directory: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	const all = uint32(api.AccessRight_ACCESS_READ | api.AccessRight_ACCESS_LOOKUP | api.AccessRight_ACCESS_MODIFY |
+		api.AccessRight_ACCESS_EXTEND | api.AccessRight_ACCESS_DELETE | api.AccessRight_ACCESS_EXECUTE)
+	const (
+		read    = uint32(api.AccessRight_ACCESS_READ)
+		lookup  = uint32(api.AccessRight_ACCESS_LOOKUP)
+		write   = uint32(api.AccessRight_ACCESS_MODIFY | api.AccessRight_ACCESS_EXTEND)
+		remove  = uint32(api.AccessRight_ACCESS_DELETE)
+		execute = uint32(api.AccessRight_ACCESS_EXECUTE)
+	)
+	owner := &api.Credentials{Uid: 0, Gid: 0}
+	other := &api.Credentials{Uid: 12345, Gid: 12345}
+
+	tests := []struct {
+		name        string
+		path        string
+		creds       *api.Credentials
+		access      uint32
+		wantGranted uint32
+	}{
+		// Directory-only rights are never granted on a file, and execute
+		// is never granted on a directory
+		{"File owner", "/script.sh", owner, all, read | write | execute},
+		{"File other", "/script.sh", other, all, read},
+		{"Directory owner", "/dropbox", owner, all, read | lookup | write | remove},
+		{"Directory other", "/dropbox", other, all, lookup | write | remove},
+		{"Subset only", "/script.sh", owner, read | lookup, read},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := server.issueHandle(context.Background(), tt.path)
+			if err != nil {
+				t.Fatalf("Failed to get handle: %v", err)
+			}
+			resp, err := server.Access(context.Background(), &api.AccessRequest{
+				FileHandle:  handle,
+				Credentials: tt.creds,
+				Access:      tt.access,
+			})
+			if err != nil || resp.Status != api.Status_OK {
+				t.Fatalf("Access returned %v, %v; want OK", resp.GetStatus(), err)
+			}
+			if resp.Granted != tt.wantGranted {
+				t.Errorf("Granted %#x, want %#x", resp.Granted, tt.wantGranted)
+			}
+			if resp.Attributes == nil {
+				t.Error("Expected attributes in response")
+			}
+		})
+	}
+}
+
+func TestAccessACL(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "file")
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatalf("Failed to chmod test file: %v", err)
+	}
+	ownerUID, ownerGID := fileOwner(t, path)
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handle, err := server.issueHandle(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	owner := &api.Credentials{Uid: ownerUID, Gid: ownerGID}
+	named, other := ownerUID+1000, ownerUID+1001
+
+	// The named user may read and write, but the mask holds it to reading
+	acl := &api.Acl{Entries: []*api.AclEntry{
+		{Tag: api.AclTag_ACL_USER_OBJ, Perm: 6},
+		{Tag: api.AclTag_ACL_USER, Id: named, Perm: 6},
+		{Tag: api.AclTag_ACL_GROUP_OBJ, Perm: 0},
+		{Tag: api.AclTag_ACL_MASK, Perm: 4},
+		{Tag: api.AclTag_ACL_OTHER, Perm: 0},
+	}}
+	resp, err := server.SetAttr(context.Background(), &api.SetAttrRequest{FileHandle: handle, Credentials: &api.Credentials{Uid: other, Gid: other}, Acl: acl})
+	if err != nil || resp.Status != api.Status_ERR_PERM {
+		t.Errorf("SetAttr of the ACL by another user = %v, %v; want ERR_PERM", resp.GetStatus(), err)
+	}
+	resp, err = server.SetAttr(context.Background(), &api.SetAttrRequest{FileHandle: handle, Credentials: owner, Acl: acl})
+	if err != nil {
+		t.Fatalf("SetAttr failed: %v", err)
+	}
+	if resp.Status == api.Status_ERR_NOTSUPP {
+		t.Skip("The file system keeps no ACLs")
+	}
+	if resp.Status != api.Status_OK || len(resp.Attributes.GetAcl().GetEntries()) != 5 || resp.Attributes.Mode&0777 != 0640 {
+		t.Fatalf("SetAttr of the ACL = %v, mode %o, ACL %v; want OK, 640 and the ACL",
+			resp.Status, resp.Attributes.GetMode(), resp.Attributes.GetAcl())
+	}
+	invalid := &api.Acl{Entries: acl.Entries[:2]}
+	if resp, err := server.SetAttr(context.Background(), &api.SetAttrRequest{FileHandle: handle, Credentials: owner, Acl: invalid}); err != nil || resp.Status != api.Status_ERR_INVAL {
+		t.Errorf("SetAttr of an incomplete ACL = %v, %v; want ERR_INVAL", resp.GetStatus(), err)
+	}
+
+	testCases := []struct {
+		name       string
+		uid        uint32
+		mode       uint32
+		wantStatus api.Status
+	}{
+		{"Named user read", named, 4, api.Status_OK},
+		{"Named user write beyond the mask", named, 2, api.Status_ERR_ACCES},
+		{"Other read", other, 4, api.Status_ERR_ACCES},
+		{"Owner write", ownerUID, 2, api.Status_OK},
+	}
+	for _, tc := range testCases {
+		resp, err := server.Access(context.Background(), &api.AccessRequest{
+			FileHandle:  handle,
+			Credentials: &api.Credentials{Uid: tc.uid, Gid: tc.uid},
+			Mode:        tc.mode,
+		})
+		if err != nil {
+			t.Fatalf("%s: Access failed: %v", tc.name, err)
+		}
+		if resp.Status != tc.wantStatus {
+			t.Errorf("%s: status = %v, want %v", tc.name, resp.Status, tc.wantStatus)
+		}
+	}
+
+	// An ACL without entries removes it
+	resp, err = server.SetAttr(context.Background(), &api.SetAttrRequest{FileHandle: handle, Credentials: owner, Acl: &api.Acl{}})
+	if err != nil || resp.Status != api.Status_OK || resp.Attributes.GetAcl() != nil {
+		t.Errorf("SetAttr removing the ACL = %v, %v, ACL %v; want OK and none", resp.GetStatus(), err, resp.GetAttributes().GetAcl())
+	}
+	if resp, err := server.Access(context.Background(), &api.AccessRequest{FileHandle: handle, Credentials: &api.Credentials{Uid: named, Gid: named}, Mode: 4}); err != nil || resp.Status != api.Status_ERR_ACCES {
+		t.Errorf("Access of the named user without the ACL = %v, %v; want ERR_ACCES", resp.GetStatus(), err)
+	}
+}
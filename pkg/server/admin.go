@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServer implements the AdminService on behalf of an NFSServer.
+// It is a separate type so the admin and NFS services can be served on
+// different listeners.
+type adminServer struct {
+	api.UnimplementedAdminServiceServer
+
+	nfs *NFSServer
+}
+
+// startAdmin starts the admin gRPC service on the configured admin address
+func (s *NFSServer) startAdmin() error {
+	lis, err := net.Listen("tcp", s.config.AdminListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on admin address: %w", err)
+	}
+
+	grpcServer := grpc.NewServer()
+	api.RegisterAdminServiceServer(grpcServer, &adminServer{nfs: s})
+
+	go func() {
+		slog.Info("Admin service starting", "addr", s.config.AdminListenAddress)
+		if err := grpcServer.Serve(lis); err != nil {
+			slog.Error("Admin service stopped", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// GetCaptures implements the GetCaptures admin RPC
+func (a *adminServer) GetCaptures(ctx context.Context, req *api.GetCapturesRequest) (*api.GetCapturesResponse, error) {
+	if a.nfs.captures == nil {
+		return &api.GetCapturesResponse{}, nil
+	}
+
+	entries, total := a.nfs.captures.snapshot(int(req.MaxEntries))
+	return &api.GetCapturesResponse{
+		Entries:       entries,
+		TotalCaptured: total,
+		Capacity:      uint32(len(a.nfs.captures.entries)),
+	}, nil
+}
+
+// SetDebugTarget implements the SetDebugTarget admin RPC
+func (a *adminServer) SetDebugTarget(ctx context.Context, req *api.SetDebugTargetRequest) (*api.SetDebugTargetResponse, error) {
+	if req.DurationSeconds == 0 || (len(req.FileHandle) == 0 && req.PathPrefix == "") {
+		a.nfs.debug.clear()
+		slog.InfoContext(ctx, "Debug logging disabled")
+		return &api.SetDebugTargetResponse{}, nil
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	expires := a.nfs.debug.set(req.FileHandle, req.PathPrefix, duration, int(req.MaxLinesPerSecond))
+	slog.InfoContext(ctx, "Debug logging enabled",
+		"handle", fmt.Sprintf("%x", req.FileHandle), "prefix", req.PathPrefix, "until", expires.Format(time.RFC3339))
+
+	return &api.SetDebugTargetResponse{
+		Expires: &api.FileTime{Seconds: expires.Unix(), Nano: int32(expires.Nanosecond())},
+	}, nil
+}
+
+// CheckExport implements the CheckExport admin RPC
+func (a *adminServer) CheckExport(ctx context.Context, req *api.CheckExportRequest) (*api.CheckExportResponse, error) {
+	start := time.Now()
+	report, err := a.nfs.checkExport(ctx, req.Repair)
+	if errors.Is(err, errCheckNotSupported) {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &api.CheckExportResponse{
+		Scanned:       report.Scanned,
+		Indexed:       report.Indexed,
+		Stale:         report.Stale,
+		Moved:         report.Moved,
+		Added:         report.Added,
+		Orphans:       report.Orphans,
+		Repaired:      report.Repaired,
+		DurationNanos: int64(time.Since(start)),
+	}, nil
+}
+
+// CaptureTrace implements the CaptureTrace admin RPC
+func (a *adminServer) CaptureTrace(req *api.CaptureTraceRequest, stream api.AdminService_CaptureTraceServer) error {
+	w := bufio.NewWriterSize(&traceSender{stream: stream}, traceChunkSize)
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	err := captureTrace(stream.Context(), duration, w)
+	if errors.Is(err, errTraceActive) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if err := w.Flush(); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// SetExportPolicy implements the SetExportPolicy admin RPC
+func (a *adminServer) SetExportPolicy(ctx context.Context, req *api.SetExportPolicyRequest) (*api.SetExportPolicyResponse, error) {
+	epoch, scrubbed := a.nfs.setPolicy(exportPolicy{
+		rootSquash:     req.RootSquash,
+		anonUID:        req.AnonUid,
+		anonGID:        req.AnonGid,
+		allowAnonymous: req.AllowAnonymous,
+	})
+	return &api.SetExportPolicyResponse{Epoch: epoch, Scrubbed: uint32(scrubbed)}, nil
+}
+
+// GetMetrics implements the GetMetrics admin RPC
+func (a *adminServer) GetMetrics(ctx context.Context, req *api.GetMetricsRequest) (*api.GetMetricsResponse, error) {
+	metrics := append(a.nfs.verifyStats.metrics(), a.nfs.shadow.metrics()...)
+	return &api.GetMetricsResponse{Metrics: metrics}, nil
+}
+
+// GetUsage implements the GetUsage admin RPC
+func (a *adminServer) GetUsage(ctx context.Context, req *api.GetUsageRequest) (*api.GetUsageResponse, error) {
+	for _, day := range []string{req.FromDay, req.ToDay} {
+		if _, err := time.Parse(usageDayFormat, day); day != "" && err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid day %q, want YYYY-MM-DD", day)
+		}
+	}
+	return &api.GetUsageResponse{
+		Export:  a.nfs.usage.export,
+		Records: a.nfs.usage.report(req.FromDay, req.ToDay),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"path"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// readOnlyMethods are the NFS operations that do not modify the export:
+// those an anonymous client may call, and all a read-only export serves
+var readOnlyMethods = map[string]bool{
+	"GetAttr":           true,
+	"Lookup":            true,
+	"BulkLookup":        true,
+	"DelegateDirectory": true,
+	"Delegate":          true,
+	"Read":              true,
+	"ReadDir":           true,
+	"ReadDirPlus":       true,
+	"GetRootHandle":     true,
+	"Access":            true,
+	"FsStat":            true,
+	"PathConf":          true,
+	"FsInfo":            true,
+	"GetQuota":          true,
+	"Checksum":          true,
+	"Watch":             true,
+	"Readlink":          true,
+	"ListExports":       true,
+	"Seek":              true,
+}
+
+// credentialed is implemented by every request carrying caller credentials
+type credentialed interface {
+	GetCredentials() *api.Credentials
+}
+
+// mapAnonymous gives a request sent without credentials the configured
+// anonymous identity. With AllowAnonymous off the request is left alone.
+// Anonymous access is read-only, so other methods are refused.
+func (s *NFSServer) mapAnonymous(method string, req interface{}) error {
+	policy := s.policy()
+	if !policy.allowAnonymous {
+		return nil
+	}
+	c, ok := req.(credentialed)
+	if !ok || c.GetCredentials() != nil {
+		return nil
+	}
+
+	name := path.Base(method)
+	if !readOnlyMethods[name] {
+		return status.Errorf(codes.PermissionDenied, "%s requires credentials; anonymous access is read-only", name)
+	}
+
+	m := req.(proto.Message).ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("credentials")
+	if fd == nil {
+		return nil
+	}
+	m.Set(fd, protoreflect.ValueOfMessage((&api.Credentials{
+		Uid:    policy.anonUID,
+		Gid:    policy.anonGID,
+		Groups: []uint32{policy.anonGID},
+	}).ProtoReflect()))
+	return nil
+}
+
+// anonymousInterceptor applies mapAnonymous to unary requests
+func (s *NFSServer) anonymousInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := s.mapAnonymous(info.FullMethod, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// anonymousStreamInterceptor applies mapAnonymous to messages received on
+// streaming calls
+func (s *NFSServer) anonymousStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	if !s.policy().allowAnonymous {
+		return handler(srv, ss)
+	}
+	return handler(srv, &anonymousStream{ServerStream: ss, server: s, method: info.FullMethod})
+}
+
+// anonymousStream maps credentials on each message as it is received
+type anonymousStream struct {
+	grpc.ServerStream
+	server *NFSServer
+	method string
+}
+
+func (a *anonymousStream) RecvMsg(m interface{}) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return a.server.mapAnonymous(a.method, m)
+}
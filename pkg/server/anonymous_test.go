@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAnonymousAccess(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The export root must be searchable by the anonymous user
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to chmod temp dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "public.txt"), []byte("dataset"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "private.txt"), []byte("secret"), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.AllowAnonymous = true
+	server, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	call := func(method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+		info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/" + method}
+		return server.anonymousInterceptor(context.Background(), req, info, handler)
+	}
+	read := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return server.Read(ctx, req.(*api.ReadRequest))
+	}
+
+	publicHandle, _ := server.issueHandle(context.Background(), "/public.txt")
+	privateHandle, _ := server.issueHandle(context.Background(), "/private.txt")
+
+	// Credential-less reads are served as the anonymous user
+	req := &api.ReadRequest{FileHandle: publicHandle, Count: 100}
+	resp, err := call("Read", req, read)
+	if err != nil {
+		t.Fatalf("Anonymous read failed: %v", err)
+	}
+	if got := resp.(*api.ReadResponse); got.Status != api.Status_OK || string(got.Data) != "dataset" {
+		t.Errorf("Got %v %q, want OK %q", got.Status, got.Data, "dataset")
+	}
+	if req.Credentials.GetUid() != config.AnonUID || req.Credentials.GetGid() != config.AnonGID {
+		t.Errorf("Request credentials = %v, want anonymous identity", req.Credentials)
+	}
+
+	// The anonymous user gets no more than other users would
+	resp, err = call("Read", &api.ReadRequest{FileHandle: privateHandle, Count: 100}, read)
+	if err != nil {
+		t.Fatalf("Anonymous read failed: %v", err)
+	}
+	if got := resp.(*api.ReadResponse).Status; got != api.Status_ERR_ACCES {
+		t.Errorf("Reading a private file: got %v, want ERR_ACCES", got)
+	}
+
+	// Anonymous access is read-only
+	_, err = call("Write", &api.WriteRequest{FileHandle: publicHandle, Data: []byte("x")},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Error("Anonymous write reached the handler")
+			return nil, nil
+		})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Anonymous write: got %v, want PermissionDenied", err)
+	}
+
+	// Requests with credentials are not touched
+	creds := &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{1000}}
+	req = &api.ReadRequest{FileHandle: publicHandle, Count: 100, Credentials: creds}
+	if _, err := call("Read", req, read); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if req.Credentials != creds {
+		t.Error("Credentials of an authenticated request were replaced")
+	}
+
+	// With anonymous access disabled nothing is mapped
+	policy := *server.policy()
+	policy.allowAnonymous = false
+	server.setPolicy(policy)
+	req = &api.ReadRequest{FileHandle: publicHandle, Count: 100}
+	if _, err := call("Read", req, read); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if req.Credentials != nil {
+		t.Errorf("Credentials = %v, want none when anonymous access is disabled", req.Credentials)
+	}
+}
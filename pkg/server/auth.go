@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Authenticator establishes who sent a request, from what the transport
+// carries: gRPC metadata, or the peer's certificate (see peer.FromContext).
+// asserted are the credentials the request claims, which an Authenticator
+// may accept, narrow or ignore.
+type Authenticator interface {
+	// Authenticate returns the identity requests are served as, or nil if
+	// the request proves none. An error rejects the request.
+	Authenticate(ctx context.Context, asserted *api.Credentials) (*api.Credentials, error)
+}
+
+// LoadAuthKey reads the key tokens are signed with from file, creating the
+// file with a new random key if it does not exist
+func LoadAuthKey(file string) ([]byte, error) {
+	return loadKey(file, "auth")
+}
+
+// tokenClaims is the payload of a token
+type tokenClaims struct {
+	UID     uint32   `json:"uid"`
+	GID     uint32   `json:"gid"`
+	Groups  []uint32 `json:"groups,omitempty"`
+	Expires int64    `json:"exp,omitempty"`
+}
+
+// tokenHeader is the JOSE header of every token, which is all
+// TokenAuthenticator accepts
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// MintToken returns a token for creds signed with key, valid for ttl
+// (0 = does not expire)
+func MintToken(key []byte, creds *api.Credentials, ttl time.Duration) (string, error) {
+	claims := tokenClaims{UID: creds.GetUid(), GID: creds.GetGid(), Groups: creds.GetGroups()}
+	if ttl != 0 {
+		claims.Expires = time.Now().Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + signToken(key, signed), nil
+}
+
+// signToken returns the signature of the header and payload in signed
+func signToken(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TokenAuthenticator serves requests as the identity in the bearer token
+// they carry in their "authorization" metadata: a JWT signed with HS256
+// under a key shared with whoever mints tokens, holding uid, gid, groups
+// and an optional expiry. The credentials in requests are ignored.
+type TokenAuthenticator struct {
+	key []byte
+}
+
+// NewTokenAuthenticator creates an authenticator accepting tokens signed
+// with key
+func NewTokenAuthenticator(key []byte) *TokenAuthenticator {
+	return &TokenAuthenticator{key: key}
+}
+
+// Authenticate implements Authenticator
+func (t *TokenAuthenticator) Authenticate(ctx context.Context, _ *api.Credentials) (*api.Credentials, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, errors.New("authorization is not a bearer token")
+	}
+	claims, err := t.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	groups := claims.Groups
+	if len(groups) == 0 {
+		groups = []uint32{claims.GID}
+	}
+	return &api.Credentials{Uid: claims.UID, Gid: claims.GID, Groups: groups}, nil
+}
+
+// verify checks the signature and expiry of token and returns its claims
+func (t *TokenAuthenticator) verify(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, errors.New("malformed token")
+	}
+	want := signToken(t.key, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) {
+		return nil, errors.New("bad token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	if claims.Expires != 0 && time.Now().Unix() >= claims.Expires {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// authenticate replaces the credentials of req with the identity the
+// authenticator establishes. Requests proving no identity are refused,
+// unless anonymous access is allowed, when they are left to mapAnonymous.
+func (s *NFSServer) authenticate(ctx context.Context, req interface{}) error {
+	c, ok := req.(credentialed)
+	if !ok {
+		return nil
+	}
+	creds, err := s.authenticator.Authenticate(ctx, c.GetCredentials())
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	if creds == nil && !s.policy().allowAnonymous {
+		return status.Error(codes.Unauthenticated, "request carries no identity")
+	}
+
+	m := req.(proto.Message).ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("credentials")
+	if fd == nil {
+		return nil
+	}
+	if creds == nil {
+		m.Clear(fd)
+	} else {
+		m.Set(fd, protoreflect.ValueOfMessage(creds.ProtoReflect()))
+	}
+	return nil
+}
+
+// authInterceptor applies authenticate to unary requests
+func (s *NFSServer) authInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := s.authenticate(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStreamInterceptor applies authenticate to messages received on
+// streaming calls
+func (s *NFSServer) authStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	return handler(srv, &authStream{ServerStream: ss, server: s})
+}
+
+// authStream authenticates each message as it is received
+type authStream struct {
+	grpc.ServerStream
+	server *NFSServer
+}
+
+func (a *authStream) RecvMsg(m interface{}) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return a.server.authenticate(a.Context(), m)
+}
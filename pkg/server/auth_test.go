@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	keyFile := filepath.Join(tempDir, "auth.key")
+	config := DefaultConfig()
+	config.AuthKeyFile = keyFile
+	server, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	key, err := LoadAuthKey(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+
+	// Records the credentials requests reach handlers with
+	var served *api.Credentials
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		served = req.(*api.GetAttrRequest).Credentials
+		return &api.GetAttrResponse{Status: api.Status_OK}, nil
+	}
+	call := func(token string, creds *api.Credentials) error {
+		served = nil
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/GetAttr"}
+		_, err := chainUnary(server.unaryInterceptors())(ctx, &api.GetAttrRequest{Credentials: creds}, info, handler)
+		return err
+	}
+	root := &api.Credentials{Uid: 0, Gid: 0, Groups: []uint32{0}}
+
+	// The token decides the identity, whatever the request claims
+	token, err := MintToken(key, &api.Credentials{Uid: 1234, Gid: 567}, time.Hour)
+	if err != nil {
+		t.Fatalf("MintToken failed: %v", err)
+	}
+	if err := call(token, root); err != nil {
+		t.Fatalf("Request with a valid token failed: %v", err)
+	}
+	if served.GetUid() != 1234 || served.GetGid() != 567 || len(served.GetGroups()) != 1 || served.GetGroups()[0] != 567 {
+		t.Errorf("Served as %v, want 1234:567 in group 567", served)
+	}
+
+	forged, _ := MintToken([]byte("not the server's key, not at all"), root, time.Hour)
+	expired, _ := MintToken(key, root, -time.Hour)
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"missing", ""},
+		{"forged", forged},
+		{"expired", expired},
+		{"malformed", "abc.def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := call(tt.token, root)
+			if status.Code(err) != codes.Unauthenticated {
+				t.Errorf("Got %v, want Unauthenticated", err)
+			}
+			if served != nil {
+				t.Errorf("Request reached the handler as %v", served)
+			}
+		})
+	}
+
+	// With anonymous access, requests without a token are served anonymously
+	policy := *server.policy()
+	policy.allowAnonymous = true
+	server.setPolicy(policy)
+	if err := call("", root); err != nil {
+		t.Fatalf("Request without a token failed: %v", err)
+	}
+	if served.GetUid() != config.AnonUID || served.GetGid() != config.AnonGID {
+		t.Errorf("Served as %v, want the anonymous identity", served)
+	}
+	if err := call(forged, root); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Forged token with anonymous access: got %v, want Unauthenticated", err)
+	}
+}
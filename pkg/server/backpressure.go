@@ -0,0 +1,122 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Bounds on the retry delay suggested to clients
+	minRetryAfter = 10 * time.Millisecond
+	maxRetryAfter = 5 * time.Second
+
+	// Weight of the newest sample in the average request duration
+	latencyWeight = 8
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second, holding
+// at most one second's worth
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a full bucket for perSecond requests per second
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   time.Now(),
+	}
+}
+
+// take consumes a token, or returns how long until one is available
+func (r *rateLimiter) take(now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens = min(r.rate, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second)), false
+}
+
+// backpressure decides when the server sheds load instead of queueing it
+type backpressure struct {
+	maxQueued int64
+	limiter   *rateLimiter // nil = no rate limit
+
+	// Requests waiting for a worker
+	queued atomic.Int64
+
+	// Moving average of request durations, in nanoseconds
+	latency atomic.Int64
+}
+
+// newBackpressure creates the admission state for config
+func newBackpressure(config *Config) *backpressure {
+	b := &backpressure{maxQueued: int64(config.MaxQueued)}
+	if config.MaxRequestsPerSecond > 0 {
+		b.limiter = newRateLimiter(config.MaxRequestsPerSecond)
+	}
+	return b
+}
+
+// admit checks a new request against the rate limit and the queue length.
+// It returns nil if the request may wait for a worker, or an ERR_DELAY error
+// carrying a retry hint otherwise.
+func (b *backpressure) admit(workers int) error {
+	if b.limiter != nil {
+		if wait, ok := b.limiter.take(time.Now()); !ok {
+			return overloaded("request rate limit exceeded", wait)
+		}
+	}
+
+	if b.maxQueued > 0 {
+		if queued := b.queued.Load(); queued >= b.maxQueued {
+			// The queue drains at roughly workers requests per average
+			// request duration
+			wait := time.Duration(b.latency.Load() * (queued + 1) / int64(max(workers, 1)))
+			return overloaded("request queue full", wait)
+		}
+	}
+	return nil
+}
+
+// observe folds a finished request's duration into the moving average
+func (b *backpressure) observe(d time.Duration) {
+	for {
+		old := b.latency.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)/latencyWeight
+		}
+		if b.latency.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// overloaded builds the RESOURCE_EXHAUSTED error returned to shed clients,
+// with a RetryHint telling them how long to back off
+func overloaded(reason string, wait time.Duration) error {
+	wait = min(max(wait, minRetryAfter), maxRetryAfter)
+	st := status.New(codes.ResourceExhausted, reason)
+	if detailed, err := st.WithDetails(&api.RetryHint{
+		Status:       api.Status_ERR_DELAY,
+		RetryAfterMs: uint32(wait / time.Millisecond),
+	}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryHint extracts the RetryHint from an overload error
+func retryHint(t *testing.T, err error) *api.RetryHint {
+	t.Helper()
+	s, _ := status.FromError(err)
+	if s.Code() != codes.ResourceExhausted {
+		t.Fatalf("Error = %v, want RESOURCE_EXHAUSTED", err)
+	}
+	for _, detail := range s.Details() {
+		if hint, ok := detail.(*api.RetryHint); ok {
+			return hint
+		}
+	}
+	t.Fatalf("Error %v carries no RetryHint", err)
+	return nil
+}
+
+func TestBackpressureQueueFull(t *testing.T) {
+	fs, err := local.NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.MaxConcurrent = 1
+	config.MaxQueued = 1
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	req := &api.GetAttrRequest{FileHandle: rootHandle}
+
+	// Occupy the only worker, then queue one request behind it
+	server.workerPool <- struct{}{}
+	queued := make(chan error, 1)
+	go func() {
+		_, err := server.GetAttr(context.Background(), req)
+		queued <- err
+	}()
+	for server.backpressure.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the queue full the next request is turned away with a hint
+	_, err = server.GetAttr(context.Background(), req)
+	hint := retryHint(t, err)
+	if hint.Status != api.Status_ERR_DELAY || hint.RetryAfterMs == 0 {
+		t.Errorf("RetryHint = %v, %dms; want ERR_DELAY with a delay", hint.Status, hint.RetryAfterMs)
+	}
+
+	// Once the worker is free the queued request completes normally
+	server.releaseWorker()
+	if err := <-queued; err != nil {
+		t.Errorf("Queued GetAttr returned error: %v", err)
+	}
+
+	// A request canceled while queued is dropped without taking a worker
+	ctx, cancel := context.WithCancel(context.Background())
+	server.workerPool <- struct{}{}
+	go func() {
+		_, err := server.GetAttr(ctx, req)
+		queued <- err
+	}()
+	for server.backpressure.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-queued; err != context.Canceled {
+		t.Errorf("Canceled GetAttr returned %v, want context.Canceled", err)
+	}
+	server.releaseWorker()
+	if len(server.workerPool) != 0 {
+		t.Errorf("%d workers still taken", len(server.workerPool))
+	}
+}
+
+func TestBackpressureRateLimit(t *testing.T) {
+	fs, err := local.NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.MaxRequestsPerSecond = 2
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	req := &api.GetAttrRequest{FileHandle: rootHandle}
+
+	for i := 0; i < 2; i++ {
+		if _, err := server.GetAttr(context.Background(), req); err != nil {
+			t.Fatalf("GetAttr %d within the rate returned error: %v", i, err)
+		}
+	}
+
+	_, err = server.GetAttr(context.Background(), req)
+	hint := retryHint(t, err)
+	if wait := time.Duration(hint.RetryAfterMs) * time.Millisecond; wait < minRetryAfter || wait > time.Second {
+		t.Errorf("Retry hint = %v, want at most the time to refill one token", wait)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestBulkLookup(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testDirPath := filepath.Join(tempDir, "testdir")
+	if err := os.Mkdir(testDirPath, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(testDirPath, "a.txt"), []byte("aaa"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(testDirPath, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create nested directory: %v", err)
+	}
+
+	// Create filesystem and server
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	server, err := NewNFSServer(DefaultConfig(), fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	dirHandle, err := server.issueHandle(context.Background(), "/testdir")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	creds := &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{1000}}
+
+	resp, err := server.BulkLookup(context.Background(), &api.BulkLookupRequest{
+		DirectoryHandle: dirHandle,
+		Names:           []string{"a.txt", "missing", "sub", "..", strings.Repeat("x", 300)},
+		Credentials:     creds,
+	})
+	if err != nil {
+		t.Fatalf("BulkLookup failed: %v", err)
+	}
+	if resp.Status != api.Status_OK {
+		t.Fatalf("BulkLookup returned status %v", resp.Status)
+	}
+	if len(resp.Results) != 5 {
+		t.Fatalf("Got %d results, want 5", len(resp.Results))
+	}
+
+	// Each result matches what a single Lookup would return
+	file := resp.Results[0]
+	if file.Status != api.Status_OK || file.Attributes.Type != api.FileType_REGULAR || file.Attributes.Size != 3 {
+		t.Errorf("a.txt: got %v %v", file.Status, file.Attributes)
+	}
+	if path, err := fileSystem.FileHandleToPath(context.Background(), file.FileHandle); err != nil || path != "/testdir/a.txt" {
+		t.Errorf("a.txt handle resolves to %q, %v", path, err)
+	}
+	if got := resp.Results[1].Status; got != api.Status_ERR_NOENT {
+		t.Errorf("missing: got %v, want ERR_NOENT", got)
+	}
+	if sub := resp.Results[2]; sub.Status != api.Status_OK || sub.Attributes.Type != api.FileType_DIRECTORY {
+		t.Errorf("sub: got %v %v", sub.Status, sub.Attributes)
+	}
+	if got := resp.Results[3].Status; got != api.Status_ERR_INVAL {
+		t.Errorf("..: got %v, want ERR_INVAL", got)
+	}
+	if got := resp.Results[4].Status; got != api.Status_ERR_NAMETOOLONG {
+		t.Errorf("Long name: got %v, want ERR_NAMETOOLONG", got)
+	}
+	if resp.DirAttributes == nil || resp.DirAttributes.Type != api.FileType_DIRECTORY {
+		t.Errorf("Directory attributes = %v", resp.DirAttributes)
+	}
+
+	// Oversized requests are refused outright
+	resp, err = server.BulkLookup(context.Background(), &api.BulkLookupRequest{
+		DirectoryHandle: dirHandle,
+		Names:           make([]string, maxBulkLookupNames+1),
+		Credentials:     creds,
+	})
+	if err != nil {
+		t.Fatalf("BulkLookup failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_INVAL {
+		t.Errorf("Oversized request: got %v, want ERR_INVAL", resp.Status)
+	}
+
+	// Invalid directory handle
+	resp, err = server.BulkLookup(context.Background(), &api.BulkLookupRequest{
+		DirectoryHandle: []byte{1, 2, 3},
+		Names:           []string{"a.txt"},
+		Credentials:     creds,
+	})
+	if err != nil {
+		t.Fatalf("BulkLookup failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("Invalid handle: got %v, want ERR_BADHANDLE", resp.Status)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// captureRing is a fixed-size ring buffer of sanitized request summaries
+type captureRing struct {
+	mu      sync.Mutex
+	entries []*api.CaptureEntry
+	next    int
+	total   uint64
+}
+
+// newCaptureRing creates a ring buffer holding up to size entries
+func newCaptureRing(size int) *captureRing {
+	return &captureRing{
+		entries: make([]*api.CaptureEntry, size),
+	}
+}
+
+// add stores an entry, overwriting the oldest one when full
+func (r *captureRing) add(entry *api.CaptureEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	r.total++
+}
+
+// snapshot returns up to max entries (0 = all), oldest first
+func (r *captureRing) snapshot(max int) ([]*api.CaptureEntry, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*api.CaptureEntry, 0, len(r.entries))
+	for i := 0; i < len(r.entries); i++ {
+		if entry := r.entries[(r.next+i)%len(r.entries)]; entry != nil {
+			result = append(result, entry)
+		}
+	}
+
+	// Keep the most recent entries
+	if max > 0 && len(result) > max {
+		result = result[len(result)-max:]
+	}
+
+	return result, r.total
+}
+
+// captureInterceptor records a sanitized summary of every unary call
+func (s *NFSServer) captureInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	entry := &api.CaptureEntry{
+		Time:          &api.FileTime{Seconds: start.Unix(), Nano: int32(start.Nanosecond())},
+		Operation:     info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:],
+		Client:        "unknown",
+		DurationNanos: int64(time.Since(start)),
+		Tag:           requestTag(ctx),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		entry.Client = p.Addr.String()
+	}
+	if msg, ok := req.(proto.Message); ok {
+		entry.Request = summarizeMessage(msg.ProtoReflect())
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else if msg, ok := resp.(proto.Message); ok && msg.ProtoReflect().IsValid() {
+		entry.Response = summarizeMessage(msg.ProtoReflect())
+		entry.Status = responseStatus(msg)
+	}
+
+	s.captures.add(entry)
+	return resp, err
+}
+
+// responseStatus extracts the NFS status from a response message
+func responseStatus(msg proto.Message) api.Status {
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName("status")
+	if fd == nil || fd.Kind() != protoreflect.EnumKind {
+		return api.Status_OK
+	}
+	return api.Status(m.Get(fd).Enum())
+}
+
+// hashHandle returns a short, non-reversible identifier for a file handle
+func hashHandle(handle []byte) string {
+	sum := sha256.Sum256(handle)
+	return "h:" + hex.EncodeToString(sum[:6])
+}
+
+// summarizeMessage renders a message as "field=value" pairs with file data
+// removed and handles hashed, so summaries are safe to keep around
+func summarizeMessage(m protoreflect.Message) string {
+	var parts []string
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		switch {
+		case fd.IsList():
+			parts = append(parts, fmt.Sprintf("%s=[%d]", name, v.List().Len()))
+		case fd.IsMap():
+			parts = append(parts, fmt.Sprintf("%s={%d}", name, v.Map().Len()))
+		case fd.Kind() == protoreflect.BytesKind:
+			if strings.Contains(name, "handle") {
+				parts = append(parts, fmt.Sprintf("%s=%s", name, hashHandle(v.Bytes())))
+			} else {
+				// Never keep file contents or other opaque payloads
+				parts = append(parts, fmt.Sprintf("%s=<%d bytes>", name, len(v.Bytes())))
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			parts = append(parts, fmt.Sprintf("%s={%s}", name, summarizeMessage(v.Message())))
+		case fd.Kind() == protoreflect.EnumKind:
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				parts = append(parts, fmt.Sprintf("%s=%s", name, ev.Name()))
+			} else {
+				parts = append(parts, fmt.Sprintf("%s=%d", name, v.Enum()))
+			}
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%v", name, v.Interface()))
+		}
+		return true
+	})
+
+	return strings.Join(parts, " ")
+}
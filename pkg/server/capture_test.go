@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+)
+
+func TestCaptureRing(t *testing.T) {
+	ring := newCaptureRing(3)
+
+	for _, op := range []string{"a", "b", "c", "d", "e"} {
+		ring.add(&api.CaptureEntry{Operation: op})
+	}
+
+	entries, total := ring.snapshot(0)
+	if total != 5 {
+		t.Errorf("Expected 5 captured entries in total, got %d", total)
+	}
+
+	var ops []string
+	for _, e := range entries {
+		ops = append(ops, e.Operation)
+	}
+	if got := strings.Join(ops, ""); got != "cde" {
+		t.Errorf("Expected oldest-first entries \"cde\", got %q", got)
+	}
+
+	entries, _ = ring.snapshot(2)
+	if len(entries) != 2 || entries[0].Operation != "d" {
+		t.Errorf("Expected the 2 most recent entries, got %v", entries)
+	}
+}
+
+func TestCaptureInterceptor(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.CaptureBufferSize = 10
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	handle := []byte("0123456789abcdef")
+	req := &api.WriteRequest{
+		FileHandle: handle,
+		Data:       []byte("secret file contents"),
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/Write"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &api.WriteResponse{Status: api.Status_ERR_STALE}, nil
+	}
+
+	if _, err := server.captureInterceptor(context.Background(), req, info, handler); err != nil {
+		t.Fatalf("Interceptor returned error: %v", err)
+	}
+
+	resp, err := (&adminServer{nfs: server}).GetCaptures(context.Background(), &api.GetCapturesRequest{})
+	if err != nil {
+		t.Fatalf("GetCaptures failed: %v", err)
+	}
+	if len(resp.Entries) != 1 {
+		t.Fatalf("Expected 1 captured entry, got %d", len(resp.Entries))
+	}
+
+	entry := resp.Entries[0]
+	if entry.Operation != "Write" || entry.Status != api.Status_ERR_STALE {
+		t.Errorf("Unexpected entry: %v", entry)
+	}
+	if strings.Contains(entry.Request, "secret") {
+		t.Errorf("File data leaked into capture: %s", entry.Request)
+	}
+	if strings.Contains(entry.Request, string(handle)) || !strings.Contains(entry.Request, hashHandle(handle)) {
+		t.Errorf("Handle was not hashed in capture: %s", entry.Request)
+	}
+}
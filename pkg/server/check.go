@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// errCheckNotSupported is returned when the file system keeps no index to check
+var errCheckNotSupported = errors.New("file system does not support consistency checks")
+
+// checkExport runs a consistency check of the file system's index and logs
+// a summary
+func (s *NFSServer) checkExport(ctx context.Context, repair bool) (fs.CheckReport, error) {
+	checker, ok := fs.As[fs.Checker](s.fileSystem)
+	if !ok {
+		return fs.CheckReport{}, errCheckNotSupported
+	}
+
+	start := time.Now()
+	report, err := checker.Check(ctx, repair)
+	if err != nil {
+		return report, err
+	}
+
+	action := "found"
+	if repair {
+		action = "repaired"
+	}
+	slog.InfoContext(ctx, "Export check", "scanned", report.Scanned, "duration", time.Since(start).Round(time.Millisecond),
+		"action", action, "stale", report.Stale, "moved", report.Moved, "unindexed", report.Added, "orphans", len(report.Orphans))
+	for _, orphan := range report.Orphans {
+		slog.WarnContext(ctx, "Export check: orphaned entry", "path", orphan)
+	}
+
+	return report, nil
+}
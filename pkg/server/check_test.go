@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// plainFS hides the optional interfaces of the file system it wraps
+type plainFS struct {
+	fs.FileSystem
+}
+
+func TestCheckExport(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create filesystem
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server
+	server, err := NewNFSServer(DefaultConfig(), localFS)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	admin := &adminServer{nfs: server}
+
+	// The file is indexed once it has been seen, so it goes stale when deleted
+	if _, err := localFS.GetAttr(context.Background(), "/file.txt"); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(tempDir, "file.txt")); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+
+	resp, err := admin.CheckExport(context.Background(), &api.CheckExportRequest{Repair: true})
+	if err != nil {
+		t.Fatalf("CheckExport failed: %v", err)
+	}
+	if resp.Stale != 1 || !resp.Repaired || resp.Scanned != 1 {
+		t.Errorf("Unexpected check result: %+v", resp)
+	}
+
+	// File systems without an index cannot be checked
+	server, err = NewNFSServer(DefaultConfig(), plainFS{localFS})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	admin = &adminServer{nfs: server}
+	_, err = admin.CheckExport(context.Background(), &api.CheckExportRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Unexpected error for unsupported file system: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// newChecksumHash returns a hash for the requested algorithm
+func newChecksumHash(algorithm api.ChecksumAlgorithm) (hash.Hash, error) {
+	switch algorithm {
+	case api.ChecksumAlgorithm_CHECKSUM_SHA256:
+		return sha256.New(), nil
+	case api.ChecksumAlgorithm_CHECKSUM_MD5:
+		return md5.New(), nil
+	case api.ChecksumAlgorithm_CHECKSUM_XXHASH64:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %d", algorithm)
+	}
+}
+
+// checksumRange hashes length bytes of path starting at offset (0 = to end
+// of file), reading in chunks of at most MaxReadSize. It returns the digest
+// and the number of bytes hashed.
+func (s *NFSServer) checksumRange(ctx context.Context, path string, h hash.Hash,
+	offset, length uint64) ([]byte, uint64, error) {
+
+	chunk := uint64(s.config.MaxReadSize)
+	var hashed uint64
+
+	for length == 0 || hashed < length {
+		// Stop early if the client gave up
+		if err := ctx.Err(); err != nil {
+			return nil, hashed, err
+		}
+
+		count := chunk
+		if length > 0 && length-hashed < count {
+			count = length - hashed
+		}
+
+		data, eof, err := s.fileSystem.Read(ctx, path, int64(offset+hashed), int(count))
+		if err != nil {
+			return nil, hashed, err
+		}
+		h.Write(data)
+		hashed += uint64(len(data))
+
+		if eof || len(data) == 0 {
+			break
+		}
+	}
+
+	return h.Sum(nil), hashed, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestChecksum(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create a file larger than one read chunk
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	if err := os.WriteFile(filepath.Join(tempDir, "data.bin"), content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Use a small read size so hashing spans several reads
+	config := DefaultConfig()
+	config.MaxReadSize = 1000
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	fileHandle, err := server.issueHandle(context.Background(), "/data.bin")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	dirHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	sha := sha256.Sum256(content)
+	md := md5.Sum(content[100:2100])
+	xx := binary.BigEndian.AppendUint64(nil, xxhash.Sum64(content[15000:]))
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		handle     []byte
+		algorithm  api.ChecksumAlgorithm
+		offset     uint64
+		length     uint64
+		wantStatus api.Status
+		wantSum    []byte
+		wantHashed uint64
+	}{
+		{"Whole file SHA-256", fileHandle, api.ChecksumAlgorithm_CHECKSUM_SHA256, 0, 0, api.Status_OK, sha[:], uint64(len(content))},
+		{"Range MD5", fileHandle, api.ChecksumAlgorithm_CHECKSUM_MD5, 100, 2000, api.Status_OK, md[:], 2000},
+		{"Range past EOF xxHash", fileHandle, api.ChecksumAlgorithm_CHECKSUM_XXHASH64, 15000, 5000, api.Status_OK, xx, 1000},
+		{"Unknown algorithm", fileHandle, api.ChecksumAlgorithm(99), 0, 0, api.Status_ERR_INVAL, nil, 0},
+		{"Directory", dirHandle, api.ChecksumAlgorithm_CHECKSUM_SHA256, 0, 0, api.Status_ERR_ISDIR, nil, 0},
+		{"Invalid handle", []byte{1, 2, 3}, api.ChecksumAlgorithm_CHECKSUM_SHA256, 0, 0, api.Status_ERR_BADHANDLE, nil, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.Checksum(context.Background(), &api.ChecksumRequest{
+				FileHandle:  tc.handle,
+				Credentials: &api.Credentials{Uid: 0, Gid: 0},
+				Algorithm:   tc.algorithm,
+				Offset:      tc.offset,
+				Length:      tc.length,
+			})
+			if err != nil {
+				t.Fatalf("Checksum failed: %v", err)
+			}
+
+			if resp.Status != tc.wantStatus {
+				t.Fatalf("Unexpected status: got %v, want %v", resp.Status, tc.wantStatus)
+			}
+			if !bytes.Equal(resp.Checksum, tc.wantSum) {
+				t.Errorf("Wrong checksum: got %x, want %x", resp.Checksum, tc.wantSum)
+			}
+			if resp.BytesHashed != tc.wantHashed {
+				t.Errorf("Wrong byte count: got %d, want %d", resp.BytesHashed, tc.wantHashed)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+)
+
+// refusalLogInterval is how often refusals of one client are logged; those
+// in between are counted into the next line
+const refusalLogInterval = time.Minute
+
+// AccessRule admits or refuses the clients it matches
+type AccessRule struct {
+	Allow bool
+
+	// Prefix holds the clients matched, unless All is set
+	Prefix netip.Prefix
+	All    bool
+}
+
+// String returns the rule as it is written in a configuration
+func (r AccessRule) String() string {
+	verb := "deny"
+	if r.Allow {
+		verb = "allow"
+	}
+	if r.All {
+		return verb + " all"
+	}
+	return verb + " " + r.Prefix.String()
+}
+
+// matches reports whether the rule applies to a client at addr. "all"
+// also matches clients whose address is unknown.
+func (r AccessRule) matches(addr netip.Addr, known bool) bool {
+	return r.All || known && r.Prefix.Contains(addr)
+}
+
+// ParseAccessRules parses access rules, each "allow" or "deny" followed by
+// an IP address, a CIDR prefix or "all"
+func ParseAccessRules(rules []string) ([]AccessRule, error) {
+	var parsed []AccessRule
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 || fields[0] != "allow" && fields[0] != "deny" {
+			return nil, fmt.Errorf("access rule %q is not \"allow|deny address|prefix|all\"", rule)
+		}
+		r := AccessRule{Allow: fields[0] == "allow", All: fields[1] == "all"}
+		if !r.All {
+			prefixes, err := ParseClients(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("access rule %q: %w", rule, err)
+			}
+			r.Prefix = prefixes[0]
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// peerAddr returns the caller of ctx for logs, and its IP address if known
+func peerAddr(ctx context.Context) (string, netip.Addr, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown", netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String(), netip.Addr{}, false
+	}
+	return p.Addr.String(), addrPort.Addr().Unmap(), true
+}
+
+// refusalLog logs the clients refused by an export, each at most once per
+// refusalLogInterval, so a client retrying in a loop cannot flood the log
+type refusalLog struct {
+	mu      sync.Mutex
+	clients map[netip.Addr]*refusals
+}
+
+// refusals are the recent refusals of one client
+type refusals struct {
+	logged     time.Time
+	suppressed int
+}
+
+// record logs that client, at addr, was refused method for reason
+func (l *refusalLog) record(export string, client string, addr netip.Addr, method string, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[netip.Addr]*refusals)
+	}
+
+	now := time.Now()
+	r, ok := l.clients[addr]
+	if ok && now.Sub(r.logged) < refusalLogInterval {
+		r.suppressed++
+		return
+	}
+	if !ok {
+		r = &refusals{}
+		l.clients[addr] = r
+	}
+	slog.Warn("Refused client", "client", client, "method", method, "export", export,
+		"reason", reason, "suppressed", r.suppressed)
+	r.logged, r.suppressed = now, 0
+
+	// Forget clients that stopped trying
+	for a, other := range l.clients {
+		if now.Sub(other.logged) >= refusalLogInterval && other.suppressed == 0 {
+			delete(l.clients, a)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccessRules(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.AccessRules = []string{"deny 10.1.2.3", "allow 10.1.0.0/16", "deny 10.0.0.0/8"}
+	config.AllowedClients = []string{"192.168.0.0/16"}
+	server, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	getAttr := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &api.GetAttrResponse{Status: api.Status_OK}, nil
+	}
+	call := func(addr net.Addr) (api.Status, error) {
+		ctx := context.Background()
+		if addr != nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/GetAttr"}
+		resp, err := server.exportInterceptor(ctx, &api.GetAttrRequest{}, info, getAttr)
+		if err != nil {
+			return 0, err
+		}
+		return resp.(*api.GetAttrResponse).Status, nil
+	}
+	tcp := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1023}
+	}
+
+	tests := []struct {
+		name   string
+		addr   net.Addr
+		want   api.Status
+		denied bool // Refused by the client list, with PermissionDenied
+	}{
+		{"allowed by rule", tcp("10.1.9.9"), api.Status_OK, false},
+		{"earlier deny wins", tcp("10.1.2.3"), api.Status_ERR_ACCES, false},
+		{"denied by rule", tcp("10.200.0.1"), api.Status_ERR_ACCES, false},
+		{"IPv4-mapped address", tcp("::ffff:10.200.0.1"), api.Status_ERR_ACCES, false},
+		{"no rule, in client list", tcp("192.168.1.1"), api.Status_OK, false},
+		{"no rule, not in client list", tcp("172.16.0.1"), 0, true},
+		{"unknown address", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := call(tt.addr)
+			if tt.denied {
+				if status.Code(err) != codes.PermissionDenied {
+					t.Errorf("Got %v, %v; want PermissionDenied", st, err)
+				}
+				return
+			}
+			if err != nil || st != tt.want {
+				t.Errorf("Got %v, %v; want %v", st, err, tt.want)
+			}
+		})
+	}
+
+	// "all" matches clients whose address is unknown too
+	server.accessRules, err = ParseAccessRules([]string{"allow 10.0.0.0/8", "deny all"})
+	if err != nil {
+		t.Fatalf("ParseAccessRules failed: %v", err)
+	}
+	if st, err := call(nil); err != nil || st != api.Status_ERR_ACCES {
+		t.Errorf("Unknown client under deny all: got %v, %v; want ERR_ACCES", st, err)
+	}
+	if st, err := call(tcp("192.168.1.1")); err != nil || st != api.Status_ERR_ACCES {
+		t.Errorf("Client list member under deny all: got %v, %v; want ERR_ACCES", st, err)
+	}
+
+	for _, bad := range []string{"permit 10.0.0.0/8", "allow", "deny nowhere", "allow 10.0.0.0/8 extra"} {
+		if _, err := ParseAccessRules([]string{bad}); err == nil {
+			t.Errorf("ParseAccessRules accepted %q", bad)
+		}
+	}
+}
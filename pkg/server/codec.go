@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+)
+
+// payloadPool recycles Read payload buffers and response structs. A buffer
+// is lent out with the response that carries it and comes back once the
+// response has been serialized.
+type payloadPool struct {
+	bufferSize int
+
+	buffers   sync.Pool // *[]byte of bufferSize
+	responses sync.Pool // *api.ReadResponse
+
+	// Buffers currently lent out, keyed by the response carrying them
+	lent sync.Map
+}
+
+// newPayloadPool creates a pool of read buffers of bufferSize bytes
+func newPayloadPool(bufferSize int) *payloadPool {
+	p := &payloadPool{bufferSize: bufferSize}
+	p.buffers.New = func() interface{} {
+		buf := make([]byte, bufferSize)
+		return &buf
+	}
+	p.responses.New = func() interface{} {
+		return &api.ReadResponse{}
+	}
+	return p
+}
+
+// getBuffer returns a read buffer; it must be handed back with lend or putBuffer
+func (p *payloadPool) getBuffer() *[]byte {
+	return p.buffers.Get().(*[]byte)
+}
+
+// putBuffer returns a buffer that was not lent out
+func (p *payloadPool) putBuffer(buf *[]byte) {
+	p.buffers.Put(buf)
+}
+
+// newReadResponse returns an empty response, possibly a recycled one
+func (p *payloadPool) newReadResponse() *api.ReadResponse {
+	return p.responses.Get().(*api.ReadResponse)
+}
+
+// lend records that resp carries data from buf
+func (p *payloadPool) lend(resp *api.ReadResponse, buf *[]byte) {
+	p.lent.Store(resp, buf)
+}
+
+// release recycles a response and its buffer after serialization. Responses
+// that did not come from the pool are left alone.
+func (p *payloadPool) release(v interface{}) {
+	resp, ok := v.(*api.ReadResponse)
+	if !ok {
+		return
+	}
+	buf, ok := p.lent.LoadAndDelete(resp)
+	if !ok {
+		return
+	}
+	resp.Reset()
+	p.responses.Put(resp)
+	p.buffers.Put(buf)
+}
+
+// pooledCodec wraps a codec and hands pooled responses back once they have
+// been marshaled, when the encoded bytes no longer refer to them
+type pooledCodec struct {
+	encoding.CodecV2
+	pool *payloadPool
+}
+
+// Marshal encodes v and recycles it if it came from the pool
+func (c *pooledCodec) Marshal(v any) (mem.BufferSlice, error) {
+	data, err := c.CodecV2.Marshal(v)
+	c.pool.release(v)
+	return data, err
+}
+
+// serverCodec returns the codec the NFS service should use, or nil for the
+// gRPC default. A codec from the configuration replaces the protobuf codec;
+// either is wrapped when payload pooling is enabled.
+func (s *NFSServer) serverCodec() encoding.CodecV2 {
+	codec := s.config.Codec
+	if s.payloads == nil {
+		return codec
+	}
+	if codec == nil {
+		codec = encoding.GetCodecV2(proto.Name)
+	}
+	return &pooledCodec{CodecV2: codec, pool: s.payloads}
+}
+
+// pooledRead serves a Read into a buffer from the payload pool. The buffer
+// travels with the response and is recycled by pooledCodec after marshaling.
+func (s *NFSServer) pooledRead(ctx context.Context, reader fs.BufferReader, path string, offset int64, count int) (*api.ReadResponse, error) {
+	buf := s.payloads.getBuffer()
+	if count > len(*buf) {
+		count = len(*buf)
+	}
+
+	n, eof, err := reader.ReadInto(ctx, path, offset, (*buf)[:count])
+	if err != nil {
+		s.payloads.putBuffer(buf)
+		return &api.ReadResponse{Status: nfs.MapErrorToStatus(err)}, nil
+	}
+
+	// Update file attributes after read
+	fileInfo, _ := s.fileSystem.GetAttr(ctx, path)
+
+	resp := s.payloads.newReadResponse()
+	resp.Status = api.Status_OK
+	resp.Data = (*buf)[:n]
+	resp.Eof = eof
+	resp.Attributes = nfs.FSInfoToProtoAttributes(fileInfo)
+	s.payloads.lend(resp, buf)
+	return resp, nil
+}
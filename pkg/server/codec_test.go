@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+)
+
+// newReadServer creates a server exporting a single file of size bytes
+func newReadServer(tb testing.TB, size int, pooled bool) (*NFSServer, []byte, []byte) {
+	tempDir := tb.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	if err := os.WriteFile(filepath.Join(tempDir, "data"), content, 0644); err != nil {
+		tb.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		tb.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.PooledBuffers = pooled
+	server, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		tb.Fatalf("Failed to create server: %v", err)
+	}
+
+	handle, err := server.issueHandle(context.Background(), "/data")
+	if err != nil {
+		tb.Fatalf("Failed to get file handle: %v", err)
+	}
+	return server, handle, content
+}
+
+// countingCodec counts marshal calls made through it
+type countingCodec struct {
+	encoding.CodecV2
+	marshals int
+}
+
+func (c *countingCodec) Marshal(v any) (mem.BufferSlice, error) {
+	c.marshals++
+	return c.CodecV2.Marshal(v)
+}
+
+func TestPooledRead(t *testing.T) {
+	server, handle, content := newReadServer(t, 64*1024, true)
+	codec := server.serverCodec()
+
+	creds := &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{1000}}
+	for _, tc := range []struct {
+		offset, count uint64
+	}{
+		{0, 4096},
+		{60 * 1024, 8192}, // runs past the end of the file
+		{70 * 1024, 100},  // starts past the end of the file
+	} {
+		resp, err := server.Read(context.Background(), &api.ReadRequest{
+			FileHandle:  handle,
+			Credentials: creds,
+			Offset:      tc.offset,
+			Count:       uint32(tc.count),
+		})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("Read(%d, %d) failed: %v %v", tc.offset, tc.count, err, resp.GetStatus())
+		}
+
+		end := min(tc.offset+tc.count, uint64(len(content)))
+		want := content[min(tc.offset, end):end]
+		if !bytes.Equal(resp.Data, want) || resp.Eof != (end == uint64(len(content))) {
+			t.Errorf("Read(%d, %d) = %d bytes (eof=%v), want %d bytes", tc.offset, tc.count, len(resp.Data), resp.Eof, len(want))
+		}
+
+		// Marshaling hands the response back to the pool; the encoded copy
+		// must be unaffected
+		data, err := codec.Marshal(resp)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if resp.Data != nil {
+			t.Error("Response was not recycled after marshaling")
+		}
+		decoded := &api.ReadResponse{}
+		if err := codec.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if !bytes.Equal(decoded.Data, want) {
+			t.Errorf("Encoded response carries %d bytes, want %d", len(decoded.Data), len(want))
+		}
+		data.Free()
+	}
+
+	// Responses that did not come from the pool are not touched
+	other := &api.ReadResponse{Status: api.Status_OK, Data: []byte("kept")}
+	if _, err := codec.Marshal(other); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(other.Data) != "kept" {
+		t.Error("Marshal modified a response it did not lend")
+	}
+
+	// A configured codec is used underneath the pooling wrapper
+	custom := &countingCodec{CodecV2: encoding.GetCodecV2(proto.Name)}
+	server.config.Codec = custom
+	if _, err := server.serverCodec().Marshal(other); err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if custom.marshals != 1 {
+		t.Errorf("Custom codec called %d times, want 1", custom.marshals)
+	}
+}
+
+// benchmarkRead measures a 64KB Read including response serialization
+func benchmarkRead(b *testing.B, pooled bool) {
+	server, handle, _ := newReadServer(b, 1024*1024, pooled)
+	codec := server.serverCodec()
+	if codec == nil {
+		codec = encoding.GetCodecV2(proto.Name)
+	}
+	req := &api.ReadRequest{
+		FileHandle:  handle,
+		Credentials: &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{1000}},
+		Count:       64 * 1024,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := server.Read(context.Background(), req)
+		if err != nil || resp.Status != api.Status_OK {
+			b.Fatalf("Read failed: %v %v", err, resp.GetStatus())
+		}
+		data, err := codec.Marshal(resp)
+		if err != nil {
+			b.Fatalf("Marshal failed: %v", err)
+		}
+		data.Free()
+	}
+}
+
+func BenchmarkReadDefault(b *testing.B) { benchmarkRead(b, false) }
+func BenchmarkReadPooled(b *testing.B)  { benchmarkRead(b, true) }
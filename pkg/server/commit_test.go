@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestCommit(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(t.TempDir(), "handle.key") // Handles survive the restart
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	fileHandle, err := server.issueHandle(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	// UNSTABLE writes and the commit flushing them share a verifier
+	var verifiers []uint64
+	for i, data := range []string{"first", "second"} {
+		resp, err := server.Write(context.Background(), &api.WriteRequest{
+			FileHandle:  fileHandle,
+			Offset:      uint64(i * 10),
+			Data:        []byte(data),
+			Stability:   0, // UNSTABLE
+			Credentials: root,
+		})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("Write returned %v, %v", resp.GetStatus(), err)
+		}
+		verifiers = append(verifiers, resp.Verifier)
+	}
+	if verifiers[0] != verifiers[1] {
+		t.Errorf("Writes returned verifiers %d and %d, want them equal", verifiers[0], verifiers[1])
+	}
+
+	tests := []struct {
+		name   string
+		handle []byte
+		count  uint32
+		want   api.Status
+	}{
+		{"Whole file", fileHandle, 0, api.Status_OK},
+		{"Range", fileHandle, 5, api.Status_OK},
+		{"Directory", rootHandle, 0, api.Status_ERR_ISDIR},
+		{"Bad handle", []byte("short"), 0, api.Status_ERR_BADHANDLE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Commit(context.Background(), &api.CommitRequest{
+				FileHandle:  tt.handle,
+				Count:       tt.count,
+				Credentials: root,
+			})
+			if err != nil {
+				t.Fatalf("Commit returned error: %v", err)
+			}
+			if resp.Status != tt.want {
+				t.Fatalf("Commit returned %v, want %v", resp.Status, tt.want)
+			}
+			if tt.want != api.Status_OK {
+				return
+			}
+			if resp.Verifier != verifiers[0] {
+				t.Errorf("Commit returned verifier %d, want %d", resp.Verifier, verifiers[0])
+			}
+			if resp.Attributes.GetSize() != 16 {
+				t.Errorf("Commit returned size %d, want 16", resp.Attributes.GetSize())
+			}
+		})
+	}
+
+	// A restarted server has a new verifier
+	restarted, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	resp, err := restarted.Commit(context.Background(), &api.CommitRequest{FileHandle: fileHandle, Credentials: root})
+	if err != nil || resp.Status != api.Status_OK || resp.Verifier == verifiers[0] {
+		t.Errorf("Commit after restart returned %v, verifier %d, %v; want a new verifier", resp.GetStatus(), resp.GetVerifier(), err)
+	}
+}
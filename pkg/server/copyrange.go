@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// maxCopyRange bounds the bytes one CopyRange copies, so a call returns
+// well within a client's timeout even where the data is copied byte by
+// byte; the client asks again for the rest
+const maxCopyRange = 64 << 20
+
+// CopyRange implements the CopyRange RPC method, copying a range of one
+// file into another without the data crossing the network. The caller
+// needs to be able to read the source and write the destination.
+func (s *NFSServer) CopyRange(ctx context.Context, req *api.CopyRangeRequest) (*api.CopyRangeResponse, error) {
+	reqID := fmt.Sprintf("copyrange-%d", time.Now().UnixNano())
+	clientAddr, _, _ := peerAddr(ctx)
+	result, err := s.processRequest(ctx, "CopyRange", reqID, clientAddr, func() (interface{}, error) {
+		if _, err := s.validateFileHandle(req.SourceHandle); err != nil {
+			return &api.CopyRangeResponse{Status: api.Status_ERR_BADHANDLE}, nil
+		}
+		if _, err := s.validateFileHandle(req.DestinationHandle); err != nil {
+			return &api.CopyRangeResponse{Status: api.Status_ERR_BADHANDLE}, nil
+		}
+		src, err := s.resolveHandle(ctx, req.SourceHandle)
+		if err != nil {
+			return &api.CopyRangeResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		dst, err := s.resolveHandle(ctx, req.DestinationHandle)
+		if err != nil {
+			return &api.CopyRangeResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		copier, ok := fs.As[fs.RangeCopier](s.fileSystem)
+		if !ok {
+			return &api.CopyRangeResponse{Status: api.Status_ERR_NOTSUPP}, nil
+		}
+
+		creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+		s.policy().squash(&creds)
+		if err := s.fileSystem.Access(ctx, src, fs.FileMode(4), creds); err != nil { // 4 = read
+			return &api.CopyRangeResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		if err := s.fileSystem.Access(ctx, dst, fs.FileMode(2), creds); err != nil { // 2 = write
+			return &api.CopyRangeResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		s.fileDelegations.recall(ctx, s.fenceKey(req.DestinationHandle), true)
+
+		srcInfo, status := s.regularFile(ctx, src)
+		if status != api.Status_OK {
+			return &api.CopyRangeResponse{Status: status}, nil
+		}
+		dstInfo, status := s.regularFile(ctx, dst)
+		if status != api.Status_OK {
+			return &api.CopyRangeResponse{Status: status}, nil
+		}
+		before := wccAttributes(dstInfo)
+
+		// Copy what is left of the source, at most maxCopyRange of it
+		if req.SourceOffset > math.MaxInt64 || req.DestinationOffset > math.MaxInt64 {
+			return &api.CopyRangeResponse{Status: api.Status_ERR_INVAL}, nil
+		}
+		srcOffset, dstOffset := int64(req.SourceOffset), int64(req.DestinationOffset)
+		count := max(srcInfo.Size-srcOffset, 0)
+		if req.Length != 0 && req.Length < uint64(count) {
+			count = int64(req.Length)
+		}
+		count = min(count, maxCopyRange)
+		if count > math.MaxInt64-dstOffset {
+			return &api.CopyRangeResponse{Status: api.Status_ERR_INVAL}, nil
+		}
+
+		var copied int64
+		if count > 0 {
+			copied, err = copier.CopyRange(ctx, src, srcOffset, dst, dstOffset, count)
+			if copied > 0 {
+				s.watchers.notify(api.ChangeType_CHANGE_MODIFY, dst, "")
+			}
+			if err != nil {
+				return &api.CopyRangeResponse{Status: nfs.MapErrorToStatus(err)}, nil
+			}
+		}
+
+		newInfo, _ := s.fileSystem.GetAttr(ctx, dst)
+		return &api.CopyRangeResponse{
+			Status:     api.Status_OK,
+			Count:      uint64(copied),
+			Eof:        srcOffset+copied >= srcInfo.Size,
+			Attributes: nfs.FSInfoToProtoAttributes(newInfo),
+			Before:     before,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.CopyRangeResponse), nil
+}
@@ -39,7 +39,7 @@ func TestCreate(t *testing.T) {
     }
 
     // Get directory handle
-    dirHandle, err := fs.PathToFileHandle("/testdir")
+    dirHandle, err := server.issueHandle(context.Background(), "/testdir")
     if err != nil {
         t.Fatalf("Failed to get directory handle: %v", err)
     }
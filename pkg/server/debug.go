@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// defaultDebugLinesPerSecond limits debug output when no rate is requested
+const defaultDebugLinesPerSecond = 20
+
+// debugTarget enables detailed logging for a single handle or path prefix
+// for a limited time. Output is rate limited so a hot file cannot flood the log.
+type debugTarget struct {
+	mu sync.Mutex
+
+	handle  []byte
+	prefix  string
+	expires time.Time
+
+	// Simple per-second rate limiter
+	maxPerSecond int
+	window       time.Time
+	lines        int
+	suppressed   int
+}
+
+// set activates debugging for the given handle and/or path prefix
+func (d *debugTarget) set(handle []byte, prefix string, duration time.Duration, maxPerSecond int) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if maxPerSecond <= 0 {
+		maxPerSecond = defaultDebugLinesPerSecond
+	}
+
+	d.handle = append([]byte(nil), handle...)
+	d.prefix = prefix
+	d.expires = time.Now().Add(duration)
+	d.maxPerSecond = maxPerSecond
+	d.window = time.Time{}
+	d.lines = 0
+	d.suppressed = 0
+
+	return d.expires
+}
+
+// clear disables debugging
+func (d *debugTarget) clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handle = nil
+	d.prefix = ""
+	d.expires = time.Time{}
+}
+
+// active reports whether a target is set and has not expired
+func (d *debugTarget) active() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return time.Now().Before(d.expires)
+}
+
+// matches reports whether an operation on handle/path should be logged.
+// The path resolver is only invoked when a path prefix is configured.
+func (d *debugTarget) matches(handles [][]byte, resolve func([]byte) (string, bool)) bool {
+	d.mu.Lock()
+	handle, prefix, expires := d.handle, d.prefix, d.expires
+	d.mu.Unlock()
+
+	if !time.Now().Before(expires) {
+		return false
+	}
+
+	for _, h := range handles {
+		if len(handle) > 0 && bytes.Equal(h, handle) {
+			return true
+		}
+		if prefix != "" {
+			if path, ok := resolve(h); ok && hasPathPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allow applies the rate limit and reports whether a line may be logged
+func (d *debugTarget) allow(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.window) >= time.Second {
+		if d.suppressed > 0 {
+			slog.Info("Debug lines suppressed by rate limit", "lines", d.suppressed)
+		}
+		d.window = now
+		d.lines = 0
+		d.suppressed = 0
+	}
+
+	if d.lines >= d.maxPerSecond {
+		d.suppressed++
+		return false
+	}
+
+	d.lines++
+	return true
+}
+
+// hasPathPrefix reports whether path is prefix or lies beneath it
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// requestHandles returns all file handles referenced by a request
+func requestHandles(m protoreflect.Message) [][]byte {
+	var handles [][]byte
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() == protoreflect.BytesKind && !fd.IsList() &&
+			strings.Contains(string(fd.Name()), "handle") {
+			handles = append(handles, v.Bytes())
+		}
+		return true
+	})
+
+	return handles
+}
+
+// debugInterceptor logs full request and response details for operations
+// touching the current debug target
+func (s *NFSServer) debugInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	// Fast path: nothing to do unless an admin enabled debugging
+	msg, ok := req.(proto.Message)
+	if !ok || !s.debug.active() {
+		return handler(ctx, req)
+	}
+
+	resolve := func(h []byte) (string, bool) {
+		path, err := s.resolveHandle(ctx, h)
+		return path, err == nil
+	}
+	if !s.debug.matches(requestHandles(msg.ProtoReflect()), resolve) {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	if s.debug.allow(start) {
+		attrs := []any{"method", info.FullMethod, "duration", time.Since(start), "request", fmt.Sprint(req)}
+		if err != nil {
+			attrs = append(attrs, "err", err)
+		} else {
+			attrs = append(attrs, "response", fmt.Sprint(resp))
+		}
+		slog.InfoContext(ctx, "Debug target request", attrs...)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+func TestDebugTargetMatching(t *testing.T) {
+	var d debugTarget
+
+	paths := map[string]string{
+		"h1": "/logs/app/current.log",
+		"h2": "/logs/other.log",
+		"h3": "/data/file",
+	}
+	resolve := func(h []byte) (string, bool) {
+		p, ok := paths[string(h)]
+		return p, ok
+	}
+
+	// Nothing matches while no target is set
+	if d.matches([][]byte{[]byte("h1")}, resolve) {
+		t.Error("Inactive debug target should not match")
+	}
+
+	d.set([]byte("h3"), "/logs/app", time.Minute, 0)
+
+	testCases := []struct {
+		handle string
+		want   bool
+	}{
+		{"h1", true},  // under the path prefix
+		{"h2", false}, // sibling with a common string prefix
+		{"h3", true},  // exact handle match
+		{"h4", false}, // unknown handle
+	}
+	for _, tc := range testCases {
+		if got := d.matches([][]byte{[]byte(tc.handle)}, resolve); got != tc.want {
+			t.Errorf("matches(%s) = %v, want %v", tc.handle, got, tc.want)
+		}
+	}
+
+	// Expired targets stop matching
+	d.set([]byte("h3"), "", -time.Second, 0)
+	if d.matches([][]byte{[]byte("h3")}, resolve) {
+		t.Error("Expired debug target should not match")
+	}
+}
+
+func TestDebugTargetRateLimit(t *testing.T) {
+	var d debugTarget
+	d.set([]byte("h"), "", time.Minute, 3)
+
+	now := time.Now()
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if d.allow(now) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 lines allowed within one second, got %d", allowed)
+	}
+
+	// A new one-second window resets the budget
+	if !d.allow(now.Add(time.Second)) {
+		t.Error("Expected logging to resume in the next window")
+	}
+}
+
+func TestRequestHandles(t *testing.T) {
+	req := &api.LookupRequest{DirectoryHandle: []byte("dir"), Name: "file"}
+
+	handles := requestHandles(req.ProtoReflect())
+	if len(handles) != 1 || string(handles[0]) != "dir" {
+		t.Errorf("Expected the directory handle, got %q", handles)
+	}
+}
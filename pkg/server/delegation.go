@@ -0,0 +1,106 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// dirDelegation is one client's right to cache a directory listing. It
+// lasts until the directory's entries change or the client returns it.
+type dirDelegation struct {
+	path     string
+	recalled chan struct{}
+}
+
+// dirDelegations tracks the directory delegations held by clients, by path
+type dirDelegations struct {
+	mu     sync.Mutex
+	max    int
+	count  int
+	byPath map[string]map[*dirDelegation]struct{}
+}
+
+// newDirDelegations creates a registry allowing up to max outstanding
+// delegations (0 = delegations are never granted)
+func newDirDelegations(max int) *dirDelegations {
+	return &dirDelegations{
+		max:    max,
+		byPath: make(map[string]map[*dirDelegation]struct{}),
+	}
+}
+
+// grant delegates the directory at dirPath, or returns false if the limit
+// of outstanding delegations has been reached
+func (d *dirDelegations) grant(dirPath string) (*dirDelegation, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.count >= d.max {
+		return nil, false
+	}
+
+	deleg := &dirDelegation{path: path.Clean("/" + dirPath), recalled: make(chan struct{})}
+	holders, ok := d.byPath[deleg.path]
+	if !ok {
+		holders = make(map[*dirDelegation]struct{})
+		d.byPath[deleg.path] = holders
+	}
+	holders[deleg] = struct{}{}
+	d.count++
+	return deleg, true
+}
+
+// release forgets a delegation the client has returned or lost
+func (d *dirDelegations) release(deleg *dirDelegation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if holders, ok := d.byPath[deleg.path]; ok {
+		if _, held := holders[deleg]; held {
+			delete(holders, deleg)
+			d.count--
+		}
+		if len(holders) == 0 {
+			delete(d.byPath, deleg.path)
+		}
+	}
+}
+
+// recall revokes every delegation of the directories at dirPaths, after
+// their entries changed
+func (d *dirDelegations) recall(dirPaths ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dirPath := range dirPaths {
+		d.recallLocked(path.Clean("/" + dirPath))
+	}
+}
+
+// recallTree revokes the delegations of dirPath and of every directory
+// below it, after the subtree was removed or moved
+func (d *dirDelegations) recallTree(dirPath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	root := path.Clean("/" + dirPath)
+	for p := range d.byPath {
+		if p == root || root == "/" || strings.HasPrefix(p, root+"/") {
+			d.recallLocked(p)
+		}
+	}
+}
+
+// recallLocked revokes the delegations of one directory; d.mu must be held
+func (d *dirDelegations) recallLocked(dirPath string) {
+	holders, ok := d.byPath[dirPath]
+	if !ok {
+		return
+	}
+	for deleg := range holders {
+		close(deleg.recalled)
+	}
+	d.count -= len(holders)
+	delete(d.byPath, dirPath)
+}
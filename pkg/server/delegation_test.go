@@ -0,0 +1,189 @@
+package server
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/example/nfsserver/pkg/api"
+    "github.com/example/nfsserver/pkg/fs/local"
+    "google.golang.org/grpc"
+)
+
+// delegationStream delivers the events of a DelegateDirectory call
+type delegationStream struct {
+    grpc.ServerStream
+    ctx    context.Context
+    events chan *api.DelegationEvent
+}
+
+func (d *delegationStream) Context() context.Context {
+    return d.ctx
+}
+
+func (d *delegationStream) Send(msg *api.DelegationEvent) error {
+    d.events <- msg
+    return nil
+}
+
+func TestDirectoryDelegation(t *testing.T) {
+    tempDir := t.TempDir()
+    if err := os.Mkdir(filepath.Join(tempDir, "src"), 0755); err != nil {
+        t.Fatalf("Failed to create directory: %v", err)
+    }
+    if err := os.WriteFile(filepath.Join(tempDir, "src", "main.c"), []byte("int main;"), 0644); err != nil {
+        t.Fatalf("Failed to create test file: %v", err)
+    }
+
+    fs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+
+    dirHandle, err := server.issueHandle(context.Background(), "/src")
+    if err != nil {
+        t.Fatalf("Failed to get directory handle: %v", err)
+    }
+    fileHandle, err := server.issueHandle(context.Background(), "/src/main.c")
+    if err != nil {
+        t.Fatalf("Failed to get file handle: %v", err)
+    }
+    root := &api.Credentials{Uid: 0, Gid: 0, Groups: []uint32{0}}
+
+    // delegate starts a DelegateDirectory call and returns its first event
+    delegate := func(handle []byte) (*delegationStream, context.CancelFunc, chan error) {
+        ctx, cancel := context.WithCancel(context.Background())
+        stream := &delegationStream{ctx: ctx, events: make(chan *api.DelegationEvent, 2)}
+        done := make(chan error, 1)
+        go func() {
+            done <- server.DelegateDirectory(&api.DelegateDirectoryRequest{DirectoryHandle: handle, Credentials: root}, stream)
+        }()
+        return stream, cancel, done
+    }
+    next := func(stream *delegationStream) *api.DelegationEvent {
+        select {
+        case event := <-stream.events:
+            return event
+        case <-time.After(5 * time.Second):
+            t.Fatal("Timed out waiting for a delegation event")
+            return nil
+        }
+    }
+
+    // A delegation is granted with the directory's attributes and recalled
+    // when an entry is created in it
+    stream, cancel, done := delegate(dirHandle)
+    defer cancel()
+    granted := next(stream)
+    if granted.Status != api.Status_OK || granted.Type != api.DelegationEventType_DELEGATION_GRANTED {
+        t.Fatalf("First event = %v %v, want OK GRANTED", granted.Status, granted.Type)
+    }
+    if granted.DirAttributes.GetType() != api.FileType_DIRECTORY {
+        t.Errorf("Granted attributes type = %v, want DIRECTORY", granted.DirAttributes.GetType())
+    }
+
+    createResp, err := server.Create(context.Background(), &api.CreateRequest{
+        DirectoryHandle: dirHandle,
+        Name:            "util.c",
+        Credentials:     root,
+        Attributes:      &api.FileAttributes{Mode: 0644},
+    })
+    if err != nil || createResp.Status != api.Status_OK {
+        t.Fatalf("Create = %v, %v", createResp.GetStatus(), err)
+    }
+    if recalled := next(stream); recalled.Type != api.DelegationEventType_DELEGATION_RECALLED {
+        t.Errorf("Event after Create = %v, want RECALLED", recalled.Type)
+    }
+    if err := <-done; err != nil {
+        t.Errorf("DelegateDirectory returned error: %v", err)
+    }
+
+    // Returning a delegation releases it on the server
+    stream, cancel, done = delegate(dirHandle)
+    next(stream)
+    cancel()
+    if err := <-done; err != nil {
+        t.Errorf("DelegateDirectory returned error after cancel: %v", err)
+    }
+    server.delegations.mu.Lock()
+    count := server.delegations.count
+    server.delegations.mu.Unlock()
+    if count != 0 {
+        t.Errorf("Outstanding delegations after return = %d, want 0", count)
+    }
+
+    // Only directories can be delegated, and handles are validated
+    stream, cancel, done = delegate(fileHandle)
+    defer cancel()
+    if event := next(stream); event.Status != api.Status_ERR_NOTDIR {
+        t.Errorf("Delegating a file = %v, want ERR_NOTDIR", event.Status)
+    }
+    <-done
+    stream, cancel, done = delegate([]byte{1, 2, 3})
+    defer cancel()
+    if event := next(stream); event.Status != api.Status_ERR_BADHANDLE {
+        t.Errorf("Delegating an invalid handle = %v, want ERR_BADHANDLE", event.Status)
+    }
+    <-done
+}
+
+func TestDirectoryDelegationLimit(t *testing.T) {
+    fs, err := local.NewLocalFileSystem(t.TempDir())
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+
+    config := DefaultConfig()
+    config.MaxDirDelegations = 0
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+
+    rootHandle, err := server.issueHandle(context.Background(), "/")
+    if err != nil {
+        t.Fatalf("Failed to get root handle: %v", err)
+    }
+
+    stream := &delegationStream{ctx: context.Background(), events: make(chan *api.DelegationEvent, 1)}
+    if err := server.DelegateDirectory(&api.DelegateDirectoryRequest{DirectoryHandle: rootHandle}, stream); err != nil {
+        t.Fatalf("DelegateDirectory returned error: %v", err)
+    }
+    if event := <-stream.events; event.Status != api.Status_ERR_JUKEBOX {
+        t.Errorf("Delegation beyond the limit = %v, want ERR_JUKEBOX", event.Status)
+    }
+}
+
+func TestRecallTree(t *testing.T) {
+    delegations := newDirDelegations(10)
+    inside, _ := delegations.grant("/a/b")
+    sibling, _ := delegations.grant("/ab")
+
+    delegations.recallTree("/a")
+
+    select {
+    case <-inside.recalled:
+    default:
+        t.Error("Delegation below the removed directory was not recalled")
+    }
+    select {
+    case <-sibling.recalled:
+        t.Error("Delegation of a sibling with a common prefix was recalled")
+    default:
+    }
+
+    delegations.release(inside)
+    delegations.release(sibling)
+    if delegations.count != 0 {
+        t.Errorf("Outstanding delegations = %d, want 0", delegations.count)
+    }
+}
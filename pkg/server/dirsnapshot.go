@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// readDirChunk is how many entries are asked of the file system at a time
+// when a whole directory is listed for a snapshot
+const readDirChunk = 10000
+
+// dirSnapshot is a directory listing sorted by name, "." and ".." first.
+// An entry's cookie is its position, so cookies stay valid however the
+// directory changes while the snapshot is kept.
+type dirSnapshot struct {
+	entries  []fs.DirEntry
+	lastUsed time.Time
+}
+
+// snapshotKey identifies a directory at one version
+type snapshotKey struct {
+	dir     string
+	version uint64
+}
+
+// dirSnapshots caches the sorted listings clients are paging through, by
+// directory and version. The version is the directory's change attribute,
+// which moves on every change even where mtime ties within its
+// granularity, and serves as the cookie verifier: clients listing an
+// unchanged directory share one snapshot, and a snapshot dropped from the
+// cache is rebuilt identically while the directory stays unchanged.
+type dirSnapshots struct {
+	mu    sync.Mutex
+	max   int
+	byKey map[snapshotKey]*dirSnapshot
+}
+
+// newDirSnapshots creates a cache of up to max snapshots (0 = sorted
+// listings are rebuilt for every page)
+func newDirSnapshots(max int) *dirSnapshots {
+	return &dirSnapshots{max: max, byKey: make(map[snapshotKey]*dirSnapshot)}
+}
+
+// get returns the snapshot cached under key
+func (d *dirSnapshots) get(key snapshotKey) (*dirSnapshot, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	snap, ok := d.byKey[key]
+	if ok {
+		snap.lastUsed = time.Now()
+	}
+	return snap, ok
+}
+
+// put caches snap under key, dropping the least recently used snapshot if
+// the cache is full
+func (d *dirSnapshots) put(key snapshotKey, snap *dirSnapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.max <= 0 {
+		return
+	}
+	if _, ok := d.byKey[key]; !ok && len(d.byKey) >= d.max {
+		var oldest snapshotKey
+		var oldestUsed time.Time
+		for k, s := range d.byKey {
+			if oldestUsed.IsZero() || s.lastUsed.Before(oldestUsed) {
+				oldest, oldestUsed = k, s.lastUsed
+			}
+		}
+		delete(d.byKey, oldest)
+	}
+	snap.lastUsed = time.Now()
+	d.byKey[key] = snap
+}
+
+// dirVersion returns the version of a directory snapshots are kept under
+func dirVersion(info fs.FileInfo) uint64 {
+	if info.ChangeID != 0 {
+		return info.ChangeID
+	}
+	return uint64(info.ModifyTime.UnixNano())
+}
+
+// unsortedVerifier returns the verifier of a listing of the directory
+// described by info continued at cookie under verifier, which for sorted
+// listings is sortedEntries' to check. Unsorted listings are read straight
+// from the file system, whose cookies are stable across changes, and are
+// verified against the directory's version: a later page of a listing
+// that started before the directory changed fails with fs.ErrBadCookie, so
+// the client lists it again rather than mixing two versions. A verifier of
+// 0 is not checked.
+func unsortedVerifier(info fs.FileInfo, cookie uint64, verifier uint64, sorted bool) (uint64, error) {
+	if sorted {
+		return verifier, nil
+	}
+	version := dirVersion(info)
+	if cookie != 0 && verifier != 0 && verifier != version {
+		return 0, fs.ErrBadCookie
+	}
+	return version, nil
+}
+
+// sortedEntries returns up to count entries of the directory at dirPath,
+// described by info, following cookie in its listing sorted by name, with
+// the verifier to continue with. A first page (cookie 0) lists the
+// directory as it is now; later pages continue the listing verifier names,
+// failing with fs.ErrBadCookie once it is gone. With plus the entries
+// carry their attributes; entries removed since the snapshot have none.
+func (s *NFSServer) sortedEntries(ctx context.Context, dirPath string, info fs.FileInfo,
+	cookie uint64, verifier uint64, count int, plus bool) ([]fs.DirEntry, uint64, bool, error) {
+
+	key := snapshotKey{dir: path.Clean("/" + dirPath), version: dirVersion(info)}
+	if cookie != 0 && verifier != key.version {
+		if _, ok := s.dirSnapshots.get(snapshotKey{dir: key.dir, version: verifier}); !ok {
+			return nil, 0, false, fs.ErrBadCookie
+		}
+		key.version = verifier
+	}
+
+	snap, ok := s.dirSnapshots.get(key)
+	if !ok {
+		entries, err := s.listSorted(ctx, dirPath)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		snap = &dirSnapshot{entries: entries}
+		s.dirSnapshots.put(key, snap)
+	}
+
+	if cookie > uint64(len(snap.entries)) {
+		return nil, 0, false, fs.ErrBadCookie
+	}
+	end := min(int(cookie)+count, len(snap.entries))
+	page := make([]fs.DirEntry, end-int(cookie))
+	copy(page, snap.entries[cookie:end])
+	if plus {
+		for i := range page {
+			if attrs, err := s.fileSystem.GetAttr(ctx, path.Join(dirPath, page[i].Name)); err == nil {
+				page[i].Attributes = &attrs
+			}
+		}
+	}
+	return page, key.version, end == len(snap.entries), nil
+}
+
+// listSorted lists the whole directory at dirPath sorted by name, "." and
+// ".." first, numbering the entries' cookies from 1
+func (s *NFSServer) listSorted(ctx context.Context, dirPath string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	var cookie int64
+	for {
+		chunk, next, err := s.fileSystem.ReadDir(ctx, dirPath, cookie, readDirChunk)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, chunk...)
+		if len(chunk) < readDirChunk {
+			break
+		}
+		cookie = next
+	}
+
+	rank := func(name string) int {
+		switch name {
+		case ".":
+			return 0
+		case "..":
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		ri, rj := rank(entries[i].Name), rank(entries[j].Name)
+		if ri != rj {
+			return ri < rj
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	for i := range entries {
+		entries[i].Cookie = int64(i + 1)
+	}
+	return entries, nil
+}
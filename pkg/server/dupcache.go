@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// dupCacheJanitorInterval is how often expired responses are dropped
+	// from the duplicate request cache
+	dupCacheJanitorInterval = time.Minute
+
+	// dupJournalMagic starts a duplicate request cache journal
+	dupJournalMagic = "NFSDRC1\n"
+)
+
+// dupCache is the duplicate request cache: responses to requests that must
+// not be carried out twice, replayed when a client retries a request whose
+// response it never saw. It holds at most max responses, dropping the least
+// recently used first, each until it expires.
+//
+// Durable responses are also appended to a journal when the cache has one,
+// and loaded from it on start, so a retry arriving after a restart is still
+// answered from the cache. The journal records the export policy it was
+// written under and is only loaded under the same one.
+type dupCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     list.List // Most recently used first
+	now     func() time.Time
+
+	// Journal of durable responses (nil = kept in memory only)
+	journal *dupJournal
+}
+
+// dupEntry is one cached response
+type dupEntry struct {
+	key      string
+	response proto.Message
+	expires  time.Time
+	durable  bool
+
+	// Epoch of the export policy the response was computed under
+	epoch uint64
+}
+
+// dupJournal is the file durable responses are appended to
+type dupJournal struct {
+	file        string
+	fingerprint string
+	f           *os.File
+
+	// Records appended since the journal was last rewritten
+	records int
+
+	// The last write error, logged once
+	err error
+}
+
+// newDupCache returns a cache of at most max responses. With a journal
+// file, the responses saved in it under the same policy are loaded.
+func newDupCache(max int, file string, policy *exportPolicy) (*dupCache, error) {
+	c := &dupCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+	if file == "" {
+		return c, nil
+	}
+	c.journal = &dupJournal{file: file, fingerprint: policy.fingerprint()}
+	if err := c.load(policy.epoch); err != nil {
+		return nil, fmt.Errorf("failed to load request cache journal: %w", err)
+	}
+	if err := c.rewriteLocked(); err != nil {
+		return nil, fmt.Errorf("failed to write request cache journal: %w", err)
+	}
+	return c, nil
+}
+
+// get returns the response cached under key, if it has not expired
+func (c *dupCache) get(key string) (proto.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*dupEntry)
+	if !c.now().Before(entry.expires) {
+		c.removeLocked(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.response, true
+}
+
+// putLocked caches response under key for ttl, evicting the least recently
+// used responses beyond the limit, and journals it if durable; c.mu must be
+// held
+func (c *dupCache) putLocked(epoch uint64, key string, response proto.Message, ttl time.Duration, durable bool) {
+	if c.max <= 0 {
+		return
+	}
+	entry := &dupEntry{key: key, response: response, expires: c.now().Add(ttl), durable: durable, epoch: epoch}
+	c.insertLocked(entry)
+	if durable {
+		c.journal.put(entry)
+	}
+}
+
+// insertLocked adds entry as the most recently used, evicting the least
+// recently used entries beyond the limit; c.mu must be held
+func (c *dupCache) insertLocked(entry *dupEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[entry.key] = c.lru.PushFront(entry)
+	}
+	for c.lru.Len() > c.max {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked drops the entry of elem; c.mu must be held
+func (c *dupCache) removeLocked(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*dupEntry).key)
+}
+
+// clearLocked drops every response and starts the journal afresh under a
+// new policy, returning the number dropped; c.mu must be held
+func (c *dupCache) clearLocked(policy *exportPolicy) int {
+	dropped := c.lru.Len()
+	clear(c.entries)
+	c.lru.Init()
+	if c.journal != nil {
+		c.journal.fingerprint = policy.fingerprint()
+		if err := c.rewriteLocked(); err != nil {
+			slog.Error("Failed to clear request cache journal", "file", c.journal.file, "err", err)
+		}
+	}
+	return dropped
+}
+
+// len returns the number of responses cached
+func (c *dupCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// expire drops the expired responses, and rewrites the journal once most
+// of its records are of responses no longer cached
+func (c *dupCache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if !now.Before(elem.Value.(*dupEntry).expires) {
+			c.removeLocked(elem)
+		}
+		elem = prev
+	}
+	if c.journal != nil && c.journal.records > 2*c.lru.Len()+c.max/4 {
+		if err := c.rewriteLocked(); err != nil {
+			slog.Error("Failed to compact request cache journal", "file", c.journal.file, "err", err)
+		}
+	}
+}
+
+// janitor drops expired responses periodically
+func (c *dupCache) janitor() {
+	ticker := time.NewTicker(dupCacheJanitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.expire()
+	}
+}
+
+// load reads the responses in the journal, if it was written under the
+// current policy, whose epoch they are given. A record cut short by a crash
+// ends the journal.
+func (c *dupCache) load(epoch uint64) error {
+	f, err := os.Open(c.journal.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(dupJournalMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dupJournalMagic {
+		return fmt.Errorf("%s: not a request cache journal", c.journal.file)
+	}
+	fingerprint, err := readJournalBytes(r)
+	if err != nil {
+		return fmt.Errorf("%s: truncated header", c.journal.file)
+	}
+	if string(fingerprint) != c.journal.fingerprint {
+		slog.Info("Export policy changed since the request cache was saved; starting it empty", "file", c.journal.file)
+		return nil
+	}
+
+	now := c.now()
+	loaded := 0
+	for {
+		key, err := readJournalBytes(r)
+		if err != nil {
+			break
+		}
+		expires, err := binary.ReadVarint(r)
+		if err != nil {
+			break
+		}
+		data, err := readJournalBytes(r)
+		if err != nil {
+			break
+		}
+		if !now.Before(time.Unix(0, expires)) {
+			continue
+		}
+		var saved anypb.Any
+		if err := proto.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("%s: corrupt response for %q: %w", c.journal.file, key, err)
+		}
+		response, err := saved.UnmarshalNew()
+		if err != nil {
+			return fmt.Errorf("%s: corrupt response for %q: %w", c.journal.file, key, err)
+		}
+		if c.max > 0 {
+			c.insertLocked(&dupEntry{key: string(key), response: response,
+				expires: time.Unix(0, expires), durable: true, epoch: epoch})
+			loaded++
+		}
+	}
+	slog.Info("Loaded request cache", "file", c.journal.file, "responses", loaded)
+	return nil
+}
+
+// readJournalBytes reads a length-prefixed byte string
+func readJournalBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<26 {
+		return nil, errors.New("record too long")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// rewriteLocked replaces the journal with one holding only the durable
+// responses cached now; c.mu must be held
+func (c *dupCache) rewriteLocked() error {
+	j := c.journal
+	data := []byte(dupJournalMagic)
+	data = binary.AppendUvarint(data, uint64(len(j.fingerprint)))
+	data = append(data, j.fingerprint...)
+	records := 0
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*dupEntry)
+		if !entry.durable {
+			continue
+		}
+		record, err := journalRecord(entry)
+		if err != nil {
+			return err
+		}
+		data = append(data, record...)
+		records++
+	}
+
+	tmp := j.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f = f
+	j.records = records
+	j.err = nil
+	return nil
+}
+
+// journalRecord encodes entry as a journal record
+func journalRecord(entry *dupEntry) ([]byte, error) {
+	saved, err := anypb.New(entry.response)
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+	record := binary.AppendUvarint(nil, uint64(len(entry.key)))
+	record = append(record, entry.key...)
+	record = binary.AppendVarint(record, entry.expires.UnixNano())
+	record = binary.AppendUvarint(record, uint64(len(data)))
+	return append(record, data...), nil
+}
+
+// put appends entry to the journal with a single write, so a crash leaves
+// at most the last record incomplete. A nil journal does nothing.
+func (j *dupJournal) put(entry *dupEntry) {
+	if j == nil {
+		return
+	}
+	record, err := journalRecord(entry)
+	if err == nil {
+		_, err = j.f.Write(record)
+	}
+	if err != nil {
+		if j.err == nil {
+			slog.Error("Failed to journal cached response", "file", j.file, "err", err)
+			j.err = err
+		}
+		return
+	}
+	j.records++
+}
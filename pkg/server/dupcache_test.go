@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestDupCacheEviction(t *testing.T) {
+	cache, err := newDupCache(2, "", newExportPolicy(DefaultConfig()))
+	if err != nil {
+		t.Fatalf("newDupCache failed: %v", err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	put := func(key string, ttl time.Duration) {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		cache.putLocked(1, key, &api.RemoveResponse{}, ttl, false)
+	}
+
+	// The least recently used response goes first
+	put("a", time.Minute)
+	put("b", time.Hour)
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("Response a missing")
+	}
+	put("c", time.Hour)
+	if _, ok := cache.get("b"); ok {
+		t.Error("Least recently used response b kept beyond the limit")
+	}
+	if cache.len() != 2 {
+		t.Errorf("Cache holds %d responses, want 2", cache.len())
+	}
+
+	// Expired responses are neither replayed nor kept
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.get("a"); ok {
+		t.Error("Expired response a replayed")
+	}
+	put("d", time.Minute)
+	now = now.Add(2 * time.Minute)
+	cache.expire()
+	if cache.len() != 1 {
+		t.Errorf("Cache holds %d responses after expiry, want 1", cache.len())
+	}
+}
+
+func TestRemoveRetry(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "a.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(stateDir, "handle.key")
+	config.RequestCacheFile = filepath.Join(stateDir, "requests")
+	start := func(config *Config) (*NFSServer, []byte) {
+		t.Helper()
+		fs, err := local.NewLocalFileSystem(tempDir)
+		if err != nil {
+			t.Fatalf("Failed to create filesystem: %v", err)
+		}
+		server, err := NewNFSServer(config, fs)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		rootHandle, err := server.issueHandle(context.Background(), "/")
+		if err != nil {
+			t.Fatalf("Failed to get root handle: %v", err)
+		}
+		return server, rootHandle
+	}
+	clientIP := net.ParseIP("10.0.0.7")
+	remove := func(server *NFSServer, dir []byte, name string, id string, port int) api.Status {
+		t.Helper()
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: clientIP, Port: port}})
+		if id != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(requestIDHeader, id))
+		}
+		resp, err := server.Remove(ctx, &api.RemoveRequest{DirectoryHandle: dir, Name: name, Credentials: &api.Credentials{}})
+		if err != nil {
+			t.Fatalf("Remove returned error: %v", err)
+		}
+		return resp.Status
+	}
+
+	server, root := start(config)
+	if status := remove(server, root, "a.txt", "req-1", 700); status != api.Status_OK {
+		t.Fatalf("Remove = %v, want OK", status)
+	}
+
+	// A retry gets the first answer, even over a new connection; a new
+	// request, or one without an ID, is carried out
+	if status := remove(server, root, "a.txt", "req-1", 701); status != api.Status_OK {
+		t.Errorf("Retried Remove = %v, want the replayed OK", status)
+	}
+	if status := remove(server, root, "a.txt", "req-2", 700); status != api.Status_ERR_NOENT {
+		t.Errorf("Remove under a new ID = %v, want ERR_NOENT", status)
+	}
+	if status := remove(server, root, "a.txt", "", 700); status != api.Status_ERR_NOENT {
+		t.Errorf("Remove without an ID = %v, want ERR_NOENT", status)
+	}
+
+	// Retries are recognized after a restart
+	server, root = start(config)
+	if status := remove(server, root, "a.txt", "req-1", 702); status != api.Status_OK {
+		t.Errorf("Remove retried after a restart = %v, want the replayed OK", status)
+	}
+
+	// but not under a different export policy
+	squashing := *config
+	squashing.EnableRootSquash = true
+	server, _ = start(&squashing)
+	if server.dupCache.len() != 0 {
+		t.Errorf("Loaded %d responses saved under another policy", server.dupCache.len())
+	}
+
+	// Failures are not cached, so the retry is carried out
+	server, root = start(config)
+	if status := remove(server, root, "missing", "req-3", 700); status != api.Status_ERR_NOENT {
+		t.Fatalf("Remove of a missing file = %v, want ERR_NOENT", status)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "missing"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if status := remove(server, root, "missing", "req-3", 700); status != api.Status_OK {
+		t.Errorf("Retried failed Remove = %v, want OK", status)
+	}
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// exportTable lists the exports served on one listener, the default export
+// first. Each export is an NFSServer of its own, with its own file system,
+// policy and persistence; the others share the handle key, handle lifetime
+// and revocations, worker pool and load shedding of the default export,
+// whose Start serves them all.
+type exportTable struct {
+	list []*NFSServer
+
+	// Exports by the file system ID in their handles; set once there are
+	// several
+	byFSID map[uint32]*NFSServer
+}
+
+// AddExport serves fileSystem alongside s as the export named
+// config.ExportName, which clients pick in GetRootHandle. config supplies
+// the export's policy, access and persistence settings; listening,
+// transfer and concurrency follow s. It must be called before Start.
+func (s *NFSServer) AddExport(config *Config, fileSystem fs.FileSystem) (*NFSServer, error) {
+	if config.ExportName == "" {
+		return nil, fmt.Errorf("an additional export needs a name")
+	}
+	for _, e := range s.exports.list {
+		if e.config.ExportName == config.ExportName {
+			return nil, fmt.Errorf("export %q defined twice", config.ExportName)
+		}
+	}
+	if s.exports.byFSID == nil {
+		fsid, err := s.fileSystemID()
+		if err != nil {
+			return nil, err
+		}
+		s.exports.byFSID = map[uint32]*NFSServer{fsid: s}
+	}
+
+	e, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		return nil, fmt.Errorf("export %q: %w", config.ExportName, err)
+	}
+	e.handleKey = s.handleKey
+	e.handleLifetime = s.handleLifetime
+	e.revoked = s.revoked
+	e.locks = s.locks
+	if e.handleLifetime > 0 && e.rootHandle == nil {
+		root, err := fileSystem.PathToFileHandle(context.Background(), "/")
+		if err != nil {
+			return nil, fmt.Errorf("export %q: %w", config.ExportName, err)
+		}
+		e.rootHandle = root
+	}
+	e.workerPool = s.workerPool
+	e.backpressure = s.backpressure
+	// Read buffers are handed back by the one codec, built around s's pool
+	e.payloads = s.payloads
+	e.captures = s.captures
+	e.writeVerifier = s.writeVerifier
+	e.exports = s.exports
+
+	fsid, err := e.fileSystemID()
+	if err != nil {
+		return nil, err
+	}
+	if other, ok := s.exports.byFSID[fsid]; ok {
+		return nil, fmt.Errorf("export %q has the same file system ID as export %q", config.ExportName, other.config.ExportName)
+	}
+	s.exports.byFSID[fsid] = e
+	s.exports.list = append(s.exports.list, e)
+	return e, nil
+}
+
+// fileSystemID returns the file system ID carried by the handles of s
+func (s *NFSServer) fileSystemID() (uint32, error) {
+	handle, err := s.fileSystem.PathToFileHandle(context.Background(), "/")
+	if err != nil {
+		return 0, fmt.Errorf("export %q: %w", s.config.ExportName, err)
+	}
+	fh, err := fs.DeserializeFileHandle(handle)
+	if err != nil {
+		return 0, fmt.Errorf("export %q: handles cannot be told apart from other exports: %w", s.config.ExportName, err)
+	}
+	return fh.FileSystemID, nil
+}
+
+// route returns the export a request is for: the one named in
+// GetRootHandle, or the one its handles belong to. Requests without
+// handles, or with handles no export recognizes, go to the default export,
+// which answers them as usual. The status is ERR_NOENT if GetRootHandle
+// names no export, so a mistyped name is not taken for the default, and
+// ERR_XDEV if the handles belong to different exports.
+func (t *exportTable) route(req proto.Message) (*NFSServer, api.Status) {
+	def := t.list[0]
+	if r, ok := req.(*api.GetRootHandleRequest); ok {
+		if r.Export == "" {
+			return def, api.Status_OK
+		}
+		for _, e := range t.list {
+			if e.config.ExportName == r.Export {
+				return e, api.Status_OK
+			}
+		}
+		return nil, api.Status_ERR_NOENT
+	}
+
+	var target *NFSServer
+	m := req.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Kind() != protoreflect.BytesKind || fd.IsList() || !strings.HasSuffix(string(fd.Name()), "_handle") {
+			continue
+		}
+		inner, ok := def.verifyHandle(m.Get(fd).Bytes())
+		if !ok {
+			continue
+		}
+		fh, err := fs.DeserializeFileHandle(inner)
+		if err != nil {
+			continue
+		}
+		e, ok := t.byFSID[fh.FileSystemID]
+		if !ok {
+			continue
+		}
+		if target != nil && target != e {
+			return nil, api.Status_ERR_XDEV
+		}
+		target = e
+	}
+	if target == nil {
+		return def, api.Status_OK
+	}
+	return target, api.Status_OK
+}
+
+// nfsMethod returns the descriptor of the NFS service's method called name
+func nfsMethod(name string) protoreflect.MethodDescriptor {
+	return api.File_proto_nfs_proto.Services().ByName("NFSService").Methods().ByName(protoreflect.Name(name))
+}
+
+// newMessage returns an empty message of the type described by md
+func newMessage(md protoreflect.MessageDescriptor) (proto.Message, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
+
+// statusResponse answers the unary NFS method called name with st and
+// nothing else
+func statusResponse(name string, st api.Status) (interface{}, error) {
+	method := nfsMethod(name)
+	if method == nil {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", name)
+	}
+	resp, err := newMessage(method.Output())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%s: %v", name, err)
+	}
+	m := resp.ProtoReflect()
+	m.Set(m.Descriptor().Fields().ByName("status"), protoreflect.ValueOfEnum(protoreflect.EnumNumber(st)))
+	return resp, nil
+}
+
+// unaryInterceptors returns the interceptors applied to the unary requests
+// of this export, outermost first
+func (s *NFSServer) unaryInterceptors() []grpc.UnaryServerInterceptor {
+	interceptors := []grpc.UnaryServerInterceptor{s.recoverInterceptor, s.exportInterceptor}
+	if s.authenticator != nil {
+		interceptors = append(interceptors, s.authInterceptor)
+	}
+	interceptors = append(interceptors, s.anonymousInterceptor, s.debugInterceptor)
+	if s.captures != nil {
+		interceptors = append(interceptors, s.captureInterceptor)
+	}
+	return interceptors
+}
+
+// streamInterceptors returns the interceptors applied to the streaming
+// calls of this export, outermost first
+func (s *NFSServer) streamInterceptors() []grpc.StreamServerInterceptor {
+	interceptors := []grpc.StreamServerInterceptor{s.recoverStreamInterceptor, s.exportStreamInterceptor}
+	if s.authenticator != nil {
+		interceptors = append(interceptors, s.authStreamInterceptor)
+	}
+	return append(interceptors, s.anonymousStreamInterceptor)
+}
+
+// routeUnary hands a unary request to the export it is for, through that
+// export's interceptors. Requests whose handles span exports fail with
+// ERR_XDEV, and GetRootHandle of an unknown export with ERR_NOENT.
+func (s *NFSServer) routeUnary(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	name := path.Base(info.FullMethod)
+	var method *grpc.MethodDesc
+	for i := range api.NFSService_ServiceDesc.Methods {
+		if api.NFSService_ServiceDesc.Methods[i].MethodName == name {
+			method = &api.NFSService_ServiceDesc.Methods[i]
+		}
+	}
+	msg, ok := req.(proto.Message)
+	if method == nil || !ok {
+		return handler(ctx, req)
+	}
+	target, st := s.exports.route(msg)
+	if st != api.Status_OK {
+		return statusResponse(name, st)
+	}
+
+	// The method's handler calls the export; the request is already
+	// decoded, so it is passed on instead of the empty one the handler
+	// decodes into
+	intercept := chainUnary(target.unaryInterceptors())
+	return method.Handler(target, ctx, func(interface{}) error { return nil },
+		func(ctx context.Context, _ interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return intercept(ctx, req, info, handler)
+		})
+}
+
+// routeStream hands a streaming call to the export its first request is
+// for, through that export's interceptors
+func (s *NFSServer) routeStream(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	name := path.Base(info.FullMethod)
+	var stream *grpc.StreamDesc
+	for i := range api.NFSService_ServiceDesc.Streams {
+		if api.NFSService_ServiceDesc.Streams[i].StreamName == name {
+			stream = &api.NFSService_ServiceDesc.Streams[i]
+		}
+	}
+	method := nfsMethod(name)
+	if stream == nil || method == nil {
+		return handler(srv, ss)
+	}
+	first, err := newMessage(method.Input())
+	if err != nil {
+		return status.Errorf(codes.Internal, "%s: %v", name, err)
+	}
+	if err := ss.RecvMsg(first); err == io.EOF && stream.ClientStreams {
+		// The client sent nothing, so the default export answers
+		def := s.exports.list[0]
+		return chainStream(def.streamInterceptors())(def, ss, info, stream.Handler)
+	} else if err != nil {
+		return err
+	}
+	target, st := s.exports.route(first)
+	if st != api.Status_OK {
+		return status.Errorf(codes.InvalidArgument, "%s: handles of different exports", name)
+	}
+	return chainStream(target.streamInterceptors())(target, &peekedStream{ServerStream: ss, first: first}, info, stream.Handler)
+}
+
+// peekedStream gives back the request routeStream read to route the call
+type peekedStream struct {
+	grpc.ServerStream
+	first proto.Message
+}
+
+func (p *peekedStream) RecvMsg(m interface{}) error {
+	if p.first == nil {
+		return p.ServerStream.RecvMsg(m)
+	}
+	proto.Merge(m.(proto.Message), p.first)
+	p.first = nil
+	return nil
+}
+
+// chainUnary combines interceptors into one, the first outermost, like
+// grpc.ChainUnaryInterceptor
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// chainStream is chainUnary for streaming calls
+func chainStream(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return next(srv, ss)
+	}
+}
+
+// ParseClients parses a list of allowed clients, each an IP address or a
+// CIDR prefix
+func ParseClients(clients []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, client := range clients {
+		if prefix, err := netip.ParsePrefix(client); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(client)
+		if err != nil {
+			return nil, fmt.Errorf("allowed client %q is neither an IP address nor a CIDR prefix", client)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// clientAllowed reports whether the caller of ctx may use the export
+func (s *NFSServer) clientAllowed(ctx context.Context) bool {
+	_, addr, known := peerAddr(ctx)
+	allowed, _ := s.clientAccess(addr, known)
+	return allowed
+}
+
+// clientAccess decides whether a client at addr may use the export. The
+// first access rule matching the client decides, and rule is it; without
+// one the client list does, refusing clients whose address is unknown.
+func (s *NFSServer) clientAccess(addr netip.Addr, known bool) (allowed bool, rule *AccessRule) {
+	for i := range s.accessRules {
+		if s.accessRules[i].matches(addr, known) {
+			return s.accessRules[i].Allow, &s.accessRules[i]
+		}
+	}
+	if len(s.allowedClients) == 0 {
+		return true, nil
+	}
+	if !known {
+		return false, nil
+	}
+	for _, prefix := range s.allowedClients {
+		if prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// admitClient refuses the NFS method called name to clients the export
+// does not allow, logging the refusal. byRule reports a refusal by an
+// access rule. ListExports is open to all; it leaves out the exports a
+// client may not use.
+func (s *NFSServer) admitClient(ctx context.Context, name string) (byRule bool, err error) {
+	if name == "ListExports" {
+		return false, nil
+	}
+	client, addr, known := peerAddr(ctx)
+	allowed, rule := s.clientAccess(addr, known)
+	if allowed {
+		return false, nil
+	}
+	reason := "not in the client list"
+	if rule != nil {
+		reason = fmt.Sprintf("denied by %q", rule.String())
+	}
+	s.refusals.record(s.config.ExportName, client, addr, name, reason)
+	return rule != nil, status.Errorf(codes.PermissionDenied, "this client may not use export %q", s.config.ExportName)
+}
+
+// exportInterceptor applies the export's access rules and client list to
+// unary requests, answering clients denied by a rule with ERR_ACCES, and
+// answers requests modifying a read-only export with ERR_ROFS
+func (s *NFSServer) exportInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	name := path.Base(info.FullMethod)
+	if byRule, err := s.admitClient(ctx, name); byRule {
+		return statusResponse(name, api.Status_ERR_ACCES)
+	} else if err != nil {
+		return nil, err
+	}
+	if s.config.ReadOnly && !readOnlyMethods[name] {
+		return statusResponse(name, api.Status_ERR_ROFS)
+	}
+	return handler(ctx, req)
+}
+
+// exportStreamInterceptor is exportInterceptor for streaming calls, which
+// have no status to answer with, so refused clients and modifications of a
+// read-only export fail with PermissionDenied
+func (s *NFSServer) exportStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	name := path.Base(info.FullMethod)
+	if _, err := s.admitClient(ss.Context(), name); err != nil {
+		return err
+	}
+	if s.config.ReadOnly && !readOnlyMethods[name] {
+		return status.Errorf(codes.PermissionDenied, "%s: export %q is read-only", name, s.config.ExportName)
+	}
+	return handler(srv, ss)
+}
+
+// ListExports implements the ListExports RPC method
+func (s *NFSServer) ListExports(ctx context.Context, req *api.ListExportsRequest) (*api.ListExportsResponse, error) {
+	reqID := fmt.Sprintf("listexports-%d", time.Now().UnixNano())
+	clientAddr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientAddr = p.Addr.String()
+	}
+
+	result, err := s.processRequest(ctx, "ListExports", reqID, clientAddr, func() (interface{}, error) {
+		resp := &api.ListExportsResponse{Status: api.Status_OK}
+		for i, e := range s.exports.list {
+			if !e.clientAllowed(ctx) {
+				continue
+			}
+			resp.Exports = append(resp.Exports, &api.ExportInfo{
+				Name:     e.config.ExportName,
+				ReadOnly: e.config.ReadOnly,
+				Default:  i == 0,
+			})
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.ListExportsResponse), nil
+}
+
+// logExports reports the exports served, once there are several
+func (s *NFSServer) logExports() {
+	if len(s.exports.list) < 2 {
+		return
+	}
+	for i, e := range s.exports.list {
+		slog.Info("Export", "name", e.config.ExportName, "default", i == 0, "read_only", e.config.ReadOnly,
+			"root_squash", e.config.EnableRootSquash, "access", fmt.Sprint(e.config.AccessRules), "clients", fmt.Sprint(e.config.AllowedClients))
+	}
+}
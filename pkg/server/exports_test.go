@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+// newExport returns a configuration and file system for an export called
+// name over a fresh directory holding file
+func newExport(t *testing.T, name string, file string) (*Config, *local.LocalFileSystem) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(name), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fs, err := local.NewLocalFileSystem(dir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.ExportName = name
+	return config, fs
+}
+
+func TestExports(t *testing.T) {
+	config, fs := newExport(t, "home", "home.txt")
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	mediaConfig, mediaFS := newExport(t, "media", "media.txt")
+	mediaConfig.ReadOnly = true
+	if _, err := server.AddExport(mediaConfig, mediaFS); err != nil {
+		t.Fatalf("AddExport failed: %v", err)
+	}
+	privateConfig, privateFS := newExport(t, "private", "private.txt")
+	privateConfig.AllowedClients = []string{"10.0.0.0/8"}
+	if _, err := server.AddExport(privateConfig, privateFS); err != nil {
+		t.Fatalf("AddExport failed: %v", err)
+	}
+	if _, err := server.AddExport(mediaConfig, mediaFS); err == nil {
+		t.Errorf("AddExport accepted an export twice")
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := server.newGRPCServer()
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	nfsClient := api.NewNFSServiceClient(conn)
+
+	ctx := context.Background()
+	creds := &api.Credentials{Uid: 0, Gid: 0}
+	rootOf := func(export string) []byte {
+		t.Helper()
+		resp, err := nfsClient.GetRootHandle(ctx, &api.GetRootHandleRequest{Credentials: creds, Export: export})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("GetRootHandle(%q) returned %v, %v", export, err, resp.GetStatus())
+		}
+		return resp.FileHandle
+	}
+
+	// The exports a client may use are listed, the default first
+	list, err := nfsClient.ListExports(ctx, &api.ListExportsRequest{Credentials: creds})
+	if err != nil || list.Status != api.Status_OK {
+		t.Fatalf("ListExports returned %v, %v", err, list.GetStatus())
+	}
+	if len(list.Exports) != 2 || list.Exports[0].Name != "home" || !list.Exports[0].Default ||
+		list.Exports[1].Name != "media" || !list.Exports[1].ReadOnly {
+		t.Errorf("ListExports = %v, want home (default) and read-only media", list.Exports)
+	}
+
+	// Handles lead to the export they came from
+	homeRoot, mediaRoot := rootOf(""), rootOf("media")
+	if lookup, err := nfsClient.Lookup(ctx, &api.LookupRequest{DirectoryHandle: mediaRoot, Name: "media.txt", Credentials: creds}); err != nil || lookup.Status != api.Status_OK {
+		t.Errorf("Lookup in media returned %v, %v", err, lookup.GetStatus())
+	}
+	if lookup, err := nfsClient.Lookup(ctx, &api.LookupRequest{DirectoryHandle: homeRoot, Name: "media.txt", Credentials: creds}); err != nil || lookup.Status != api.Status_ERR_NOENT {
+		t.Errorf("Lookup of media's file in home returned %v, %v; want ERR_NOENT", err, lookup.GetStatus())
+	}
+	if resp, err := nfsClient.GetRootHandle(ctx, &api.GetRootHandleRequest{Credentials: creds, Export: "nowhere"}); err != nil || resp.Status != api.Status_ERR_NOENT {
+		t.Errorf("GetRootHandle of an unknown export returned %v, %v; want ERR_NOENT", err, resp.GetStatus())
+	}
+	for _, export := range []string{"lab", "/"} {
+		if target, st := server.exports.route(&api.GetRootHandleRequest{Export: export}); target != nil || st != api.Status_ERR_NOENT {
+			t.Errorf("route of GetRootHandle(%q) = %v, %v; want no export and ERR_NOENT", export, target, st)
+		}
+	}
+	if target, st := server.exports.route(&api.GetRootHandleRequest{}); target != server || st != api.Status_OK {
+		t.Errorf("route of GetRootHandle of no export = %v, %v; want the default export", target, st)
+	}
+
+	// Read-only exports refuse changes, streaming or not
+	if created, err := nfsClient.Create(ctx, &api.CreateRequest{DirectoryHandle: mediaRoot, Name: "new", Credentials: creds}); err != nil || created.Status != api.Status_ERR_ROFS {
+		t.Errorf("Create in media returned %v, %v; want ERR_ROFS", err, created.GetStatus())
+	}
+	stream, err := nfsClient.RemoveTree(ctx, &api.RemoveTreeRequest{DirectoryHandle: mediaRoot, Name: "media.txt", Credentials: creds})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("RemoveTree in media returned %v, want PermissionDenied", err)
+	}
+	if created, err := nfsClient.Create(ctx, &api.CreateRequest{DirectoryHandle: homeRoot, Name: "new", Credentials: creds}); err != nil || created.Status != api.Status_OK {
+		t.Errorf("Create in home returned %v, %v", err, created.GetStatus())
+	}
+
+	// Renames between exports fail with ERR_XDEV
+	rename, err := nfsClient.Rename(ctx, &api.RenameRequest{
+		FromDirectoryHandle: homeRoot, FromName: "home.txt",
+		ToDirectoryHandle: mediaRoot, ToName: "home.txt", Credentials: creds,
+	})
+	if err != nil || rename.Status != api.Status_ERR_XDEV {
+		t.Errorf("Rename across exports returned %v, %v; want ERR_XDEV", err, rename.GetStatus())
+	}
+
+	// Clients not allowed cannot use an export at all
+	_, err = nfsClient.GetRootHandle(ctx, &api.GetRootHandleRequest{Credentials: creds, Export: "private"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetRootHandle of private returned %v, want PermissionDenied", err)
+	}
+}
+
+func TestExportPooledRead(t *testing.T) {
+	for _, defaultPooled := range []bool{true, false} {
+		config, fs := newExport(t, "home", "home.txt")
+		config.PooledBuffers = defaultPooled
+		server, err := NewNFSServer(config, fs)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		mediaConfig, mediaFS := newExport(t, "media", "media.txt")
+		mediaConfig.PooledBuffers = true
+		media, err := server.AddExport(mediaConfig, mediaFS)
+		if err != nil {
+			t.Fatalf("AddExport failed: %v", err)
+		}
+		handle, err := media.issueHandle(context.Background(), "/media.txt")
+		if err != nil {
+			t.Fatalf("Failed to get file handle: %v", err)
+		}
+
+		// The one codec of the gRPC server hands back what the export lent
+		codec := server.serverCodec()
+		if codec == nil {
+			codec = encoding.GetCodecV2(proto.Name)
+		}
+		resp, err := media.Read(context.Background(), &api.ReadRequest{
+			FileHandle:  handle,
+			Credentials: &api.Credentials{Uid: 0, Gid: 0},
+			Count:       100,
+		})
+		if err != nil || resp.Status != api.Status_OK || string(resp.Data) != "media" {
+			t.Fatalf("Read in media returned %v, %v", err, resp.GetStatus())
+		}
+		if _, err := codec.Marshal(resp); err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		lent := 0
+		for _, pool := range []*payloadPool{server.payloads, media.payloads} {
+			if pool != nil {
+				pool.lent.Range(func(any, any) bool { lent++; return true })
+			}
+		}
+		if lent != 0 {
+			t.Errorf("With default pooling %v, %d buffers are still lent after marshaling", defaultPooled, lent)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// fenceTable tracks the newest fencing epoch issued for each file. Epochs
+// come from a single counter seeded with the start time, so epochs issued
+// after a restart are newer than any issued before it.
+type fenceTable struct {
+	mu     sync.Mutex
+	last   uint64
+	epochs map[string]uint64
+
+	// Held by each fenced write for its whole duration
+	locks fileLocks
+}
+
+// newFenceTable creates an empty fence table
+func newFenceTable() *fenceTable {
+	return &fenceTable{
+		last:   uint64(time.Now().UnixNano()),
+		epochs: make(map[string]uint64),
+	}
+}
+
+// acquire issues a new epoch for key. It waits for fenced writes already in
+// progress, so no write holding an older epoch completes after it returns.
+func (t *fenceTable) acquire(key string) uint64 {
+	unlock := t.locks.lock(key)
+	defer unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last++
+	t.epochs[key] = t.last
+	return t.last
+}
+
+// admit checks a write holding epoch against the current epoch for key. If
+// the write may proceed it returns the function to call once it completes;
+// otherwise the epoch has been superseded and ok is false.
+func (t *fenceTable) admit(key string, epoch uint64) (release func(), ok bool) {
+	unlock := t.locks.lock(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := t.epochs[key]
+	if epoch < current {
+		unlock()
+		return nil, false
+	}
+	if epoch > current {
+		// Issued before a restart, or by a server this one replaced; the
+		// newest epoch seen still wins from here on
+		t.epochs[key] = epoch
+		if epoch > t.last {
+			t.last = epoch
+		}
+	}
+	return unlock, true
+}
+
+// fenceKey returns the key a handle's file is fenced under: the file
+// system handle it carries, so expiring handles renewed for the same file
+// share the file's epoch
+func (s *NFSServer) fenceKey(handle []byte) string {
+	if inner, _, ok := s.checkSignature(handle); ok {
+		return string(inner)
+	}
+	return string(handle)
+}
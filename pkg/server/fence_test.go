@@ -0,0 +1,120 @@
+package server
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/example/nfsserver/pkg/api"
+    "github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestWriteFencing(t *testing.T) {
+    tempDir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(tempDir, "state.json"), []byte("{}"), 0644); err != nil {
+        t.Fatalf("Failed to create test file: %v", err)
+    }
+
+    fs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+
+    fileHandle, err := server.issueHandle(context.Background(), "/state.json")
+    if err != nil {
+        t.Fatalf("Failed to get file handle: %v", err)
+    }
+    rootHandle, err := server.issueHandle(context.Background(), "/")
+    if err != nil {
+        t.Fatalf("Failed to get root handle: %v", err)
+    }
+
+    ctx := context.Background()
+    root := &api.Credentials{Uid: 0, Gid: 0, Groups: []uint32{0}}
+    other := &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{1000}}
+
+    acquire := func(creds *api.Credentials) *api.AcquireFenceResponse {
+        resp, err := server.AcquireFence(ctx, &api.AcquireFenceRequest{FileHandle: fileHandle, Credentials: creds})
+        if err != nil {
+            t.Fatalf("AcquireFence returned error: %v", err)
+        }
+        return resp
+    }
+    write := func(epoch uint64, data string) api.Status {
+        resp, err := server.Write(ctx, &api.WriteRequest{
+            FileHandle:  fileHandle,
+            Credentials: root,
+            Data:        []byte(data),
+            FenceEpoch:  epoch,
+        })
+        if err != nil {
+            t.Fatalf("Write returned error: %v", err)
+        }
+        return resp.Status
+    }
+
+    oldLeader := acquire(root)
+    if oldLeader.Status != api.Status_OK || oldLeader.Epoch == 0 {
+        t.Fatalf("AcquireFence = %v, epoch %d", oldLeader.Status, oldLeader.Epoch)
+    }
+    if status := write(oldLeader.Epoch, "{a}"); status != api.Status_OK {
+        t.Errorf("Write with current epoch = %v, want OK", status)
+    }
+
+    newLeader := acquire(root)
+    if newLeader.Epoch <= oldLeader.Epoch {
+        t.Fatalf("New epoch %d is not newer than %d", newLeader.Epoch, oldLeader.Epoch)
+    }
+    if status := write(oldLeader.Epoch, "{b}"); status != api.Status_ERR_FENCED {
+        t.Errorf("Write with superseded epoch = %v, want ERR_FENCED", status)
+    }
+    if status := write(newLeader.Epoch, "{c}"); status != api.Status_OK {
+        t.Errorf("Write with new epoch = %v, want OK", status)
+    }
+
+    // Writes without a fence are not checked
+    if status := write(0, "{"); status != api.Status_OK {
+        t.Errorf("Unfenced write = %v, want OK", status)
+    }
+
+    content, err := os.ReadFile(filepath.Join(tempDir, "state.json"))
+    if err != nil {
+        t.Fatalf("Failed to read file: %v", err)
+    }
+    if string(content) != "{c}" {
+        t.Errorf("File content = %q, want %q", content, "{c}")
+    }
+
+    // An epoch newer than any issued here (for example from before a
+    // restart) is accepted and supersedes the current one
+    if status := write(newLeader.Epoch+10, "{d}"); status != api.Status_OK {
+        t.Errorf("Write with newer unknown epoch = %v, want OK", status)
+    }
+    if status := write(newLeader.Epoch, "{e}"); status != api.Status_ERR_FENCED {
+        t.Errorf("Write after a newer epoch was seen = %v, want ERR_FENCED", status)
+    }
+    if next := acquire(root); next.Epoch <= newLeader.Epoch+10 {
+        t.Errorf("Epoch %d issued after seeing %d", next.Epoch, newLeader.Epoch+10)
+    }
+
+    // Fencing requires write access and a regular file
+    if resp := acquire(other); resp.Status != api.Status_ERR_ACCES {
+        t.Errorf("AcquireFence without write access = %v, want ERR_ACCES", resp.Status)
+    }
+    resp, err := server.AcquireFence(ctx, &api.AcquireFenceRequest{FileHandle: rootHandle, Credentials: root})
+    if err != nil || resp.Status != api.Status_ERR_ISDIR {
+        t.Errorf("AcquireFence on a directory = %v, %v; want ERR_ISDIR", resp.GetStatus(), err)
+    }
+    resp, err = server.AcquireFence(ctx, &api.AcquireFenceRequest{FileHandle: []byte{1, 2, 3}, Credentials: root})
+    if err != nil || resp.Status != api.Status_ERR_BADHANDLE {
+        t.Errorf("AcquireFence with invalid handle = %v, %v; want ERR_BADHANDLE", resp.GetStatus(), err)
+    }
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/metadata"
+)
+
+// clientIDMetadataKey is the gRPC metadata key clients send their ID in,
+// so requests can be told apart from those of the client holding a
+// delegation. It must match client.ClientIDMetadataKey.
+const clientIDMetadataKey = "x-nfs-client-id"
+
+// fileDelegationRecallTimeout is how long a request waits for a recalled
+// delegation to be returned before taking it away from its holder
+const fileDelegationRecallTimeout = 5 * time.Second
+
+// fileDelegationQueue is how many callbacks may wait to be sent to one
+// client. A client too slow to take a recall loses all its delegations.
+const fileDelegationQueue = 64
+
+// requestClientID returns the ID the client of ctx sent, or "" if it sent
+// none, in which case its requests conflict with every delegation
+func requestClientID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(clientIDMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// delegationHolder is one client's Delegate stream: the delegations held
+// on it and the callbacks waiting to be sent
+type delegationHolder struct {
+	clientID  string
+	callbacks chan *api.FileDelegationCallback
+	lost      chan struct{} // Closed when the client is cut off
+	lostOnce  sync.Once
+	held      map[string]*fileDelegation // By file key; guarded by fileDelegations.mu
+	closed    bool                       // Guarded by fileDelegations.mu
+}
+
+// fileDelegation is a client's right to cache a file: its data and
+// attributes, and with write set, its writes too
+type fileDelegation struct {
+	key      string
+	handle   []byte
+	holder   *delegationHolder
+	write    bool
+	recalled bool
+	returned chan struct{}
+}
+
+// fileDelegations tracks the file delegations held by clients, by the key
+// of each file (see fenceKey)
+type fileDelegations struct {
+	mu    sync.Mutex
+	max   int
+	count int
+	byKey map[string]map[*delegationHolder]*fileDelegation
+
+	// count, readable without mu, so requests skip recalls while no
+	// delegation is held
+	held atomic.Int64
+}
+
+// newFileDelegations creates a registry allowing up to max outstanding
+// delegations (0 = delegations are never granted)
+func newFileDelegations(max int) *fileDelegations {
+	return &fileDelegations{
+		max:   max,
+		byKey: make(map[string]map[*delegationHolder]*fileDelegation),
+	}
+}
+
+// newDelegationHolder creates the holder of a client's Delegate stream
+func newDelegationHolder(clientID string) *delegationHolder {
+	return &delegationHolder{
+		clientID:  clientID,
+		callbacks: make(chan *api.FileDelegationCallback, fileDelegationQueue),
+		lost:      make(chan struct{}),
+		held:      make(map[string]*fileDelegation),
+	}
+}
+
+// close releases every delegation held on a stream that ended; requests
+// still being answered on it are refused
+func (d *fileDelegations) close(holder *delegationHolder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	holder.closed = true
+	for _, deleg := range holder.held {
+		d.releaseLocked(deleg)
+	}
+}
+
+// queue hands a callback to the stream's sender without waiting. A client
+// whose queue is full is cut off, as it could not be told of a recall.
+func (h *delegationHolder) queue(callback *api.FileDelegationCallback) {
+	select {
+	case h.callbacks <- callback:
+	default:
+		h.cut()
+	}
+}
+
+// cut ends the client's stream, so it drops everything it caches under
+// delegations the server no longer honors
+func (h *delegationHolder) cut() {
+	h.lostOnce.Do(func() { close(h.lost) })
+}
+
+// conflicts reports whether holder's delegation of a file conflicts with
+// another client's access to it, for writing if write is set
+func (deleg *fileDelegation) conflicts(clientID string, write bool) bool {
+	if clientID != "" && deleg.holder.clientID == clientID {
+		return false
+	}
+	return write || deleg.write
+}
+
+// grant delegates the file of granted.FileHandle, under key, to holder,
+// for writing if granted.Write is set, and queues granted for the holder,
+// under d.mu so that no recall of the delegation can overtake it.
+// Conflicting delegations held by other clients are recalled and
+// ERR_JUKEBOX returned, as it is when too many delegations are held; the
+// client may ask again later. Asking again for a file already delegated
+// upgrades a read delegation to a write one, unless it is being recalled.
+func (d *fileDelegations) grant(holder *delegationHolder, key string, granted *api.FileDelegationCallback) api.Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if holder.closed {
+		return api.Status_ERR_JUKEBOX
+	}
+	conflict := false
+	for _, other := range d.byKey[key] {
+		if other.holder != holder && other.conflicts(holder.clientID, granted.Write) {
+			d.recallLocked(other)
+			conflict = true
+		}
+	}
+	if conflict {
+		return api.Status_ERR_JUKEBOX
+	}
+
+	if deleg, ok := holder.held[key]; ok {
+		// A recalled delegation must be returned before it is granted again
+		if deleg.recalled {
+			return api.Status_ERR_JUKEBOX
+		}
+		deleg.write = deleg.write || granted.Write
+		granted.Write = deleg.write
+		holder.queue(granted)
+		return api.Status_OK
+	}
+	if d.count >= d.max {
+		return api.Status_ERR_JUKEBOX
+	}
+
+	deleg := &fileDelegation{key: key, handle: granted.FileHandle, holder: holder, write: granted.Write, returned: make(chan struct{})}
+	holders, ok := d.byKey[key]
+	if !ok {
+		holders = make(map[*delegationHolder]*fileDelegation)
+		d.byKey[key] = holders
+	}
+	holders[holder] = deleg
+	holder.held[key] = deleg
+	d.count++
+	d.held.Store(int64(d.count))
+	holder.queue(granted)
+	return api.Status_OK
+}
+
+// giveBack forgets the delegation of the file under key that holder
+// returned
+func (d *fileDelegations) giveBack(holder *delegationHolder, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if deleg, ok := holder.held[key]; ok {
+		d.releaseLocked(deleg)
+	}
+}
+
+// releaseLocked forgets a delegation and wakes requests waiting for it to
+// be returned; d.mu must be held
+func (d *fileDelegations) releaseLocked(deleg *fileDelegation) {
+	holders := d.byKey[deleg.key]
+	if holders[deleg.holder] != deleg {
+		return
+	}
+	delete(holders, deleg.holder)
+	if len(holders) == 0 {
+		delete(d.byKey, deleg.key)
+	}
+	delete(deleg.holder.held, deleg.key)
+	close(deleg.returned)
+	d.count--
+	d.held.Store(int64(d.count))
+}
+
+// recallLocked asks a delegation's holder to return it; d.mu must be held
+func (d *fileDelegations) recallLocked(deleg *fileDelegation) {
+	if deleg.recalled {
+		return
+	}
+	deleg.recalled = true
+	deleg.holder.queue(&api.FileDelegationCallback{
+		Type:       api.FileDelegationCallbackType_FILE_DELEGATION_RECALLED,
+		Status:     api.Status_OK,
+		FileHandle: deleg.handle,
+		Write:      deleg.write,
+	})
+}
+
+// recall recalls the delegations of the file under key that conflict with
+// a request from the client of ctx, for writing if write is set, and waits
+// for them to be returned. Delegations not returned in time are taken away.
+func (d *fileDelegations) recall(ctx context.Context, key string, write bool) {
+	if d.held.Load() == 0 {
+		return
+	}
+
+	clientID := requestClientID(ctx)
+	var waiting []*fileDelegation
+	d.mu.Lock()
+	for _, deleg := range d.byKey[key] {
+		if deleg.conflicts(clientID, write) {
+			d.recallLocked(deleg)
+			waiting = append(waiting, deleg)
+		}
+	}
+	d.mu.Unlock()
+	if len(waiting) == 0 {
+		return
+	}
+
+	timer := time.NewTimer(fileDelegationRecallTimeout)
+	defer timer.Stop()
+	for _, deleg := range waiting {
+		select {
+		case <-deleg.returned:
+		case <-deleg.holder.lost:
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			d.revoke(waiting)
+			return
+		}
+	}
+}
+
+// revoke takes away delegations whose holders did not return them when
+// recalled, cutting the holders off
+func (d *fileDelegations) revoke(delegs []*fileDelegation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, deleg := range delegs {
+		select {
+		case <-deleg.returned:
+			continue
+		default:
+		}
+		slog.Warn("Revoking file delegation not returned in time", "client", deleg.holder.clientID)
+		deleg.holder.cut()
+		d.releaseLocked(deleg)
+	}
+}
+
+// recallPath recalls the delegations other clients hold of the file at
+// path, before it is removed or replaced
+func (s *NFSServer) recallPath(ctx context.Context, path string) {
+	if s.fileDelegations.held.Load() == 0 {
+		return
+	}
+	if handle, err := s.fileSystem.PathToFileHandle(ctx, path); err == nil {
+		s.fileDelegations.recall(ctx, string(handle), true)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestFileDelegationRecall(t *testing.T) {
+	delegs := newFileDelegations(2)
+	a, b := newDelegationHolder("a"), newDelegationHolder("b")
+	grant := func(holder *delegationHolder, key string, write bool) api.Status {
+		t.Helper()
+		status := delegs.grant(holder, key, &api.FileDelegationCallback{
+			Type:       api.FileDelegationCallbackType_FILE_DELEGATION_GRANTED,
+			FileHandle: []byte(key),
+			Write:      write,
+		})
+		if status == api.Status_OK {
+			if callback := <-holder.callbacks; callback.Type != api.FileDelegationCallbackType_FILE_DELEGATION_GRANTED {
+				t.Fatalf("Queued %v, want the grant", callback.Type)
+			}
+		}
+		return status
+	}
+	recalled := func(holder *delegationHolder) bool {
+		select {
+		case callback := <-holder.callbacks:
+			return callback.Type == api.FileDelegationCallbackType_FILE_DELEGATION_RECALLED
+		default:
+			return false
+		}
+	}
+	from := func(clientID string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientIDMetadataKey, clientID))
+	}
+
+	// Readers share a file
+	if status := grant(a, "f", false); status != api.Status_OK {
+		t.Fatalf("Read delegation = %v", status)
+	}
+	if status := grant(b, "f", false); status != api.Status_OK {
+		t.Fatalf("Second read delegation = %v", status)
+	}
+
+	// Reads by another client recall no read delegation
+	delegs.recall(from("c"), "f", false)
+	if recalled(a) || recalled(b) {
+		t.Error("Read delegations recalled for a read")
+	}
+
+	// No more than the limit are granted
+	if status := grant(a, "g", false); status != api.Status_ERR_JUKEBOX {
+		t.Errorf("Delegation past the limit = %v, want ERR_JUKEBOX", status)
+	}
+
+	// A write waits until the other clients' delegations are returned
+	done := make(chan struct{})
+	go func() {
+		delegs.recall(from("a"), "f", true)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !recalled(b) || recalled(a) {
+		t.Fatal("Write by a holder did not recall just the other holder's delegation")
+	}
+	select {
+	case <-done:
+		t.Fatal("Write went ahead before the recalled delegation was returned")
+	default:
+	}
+	delegs.giveBack(b, "f")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write still waiting after the delegation was returned")
+	}
+
+	// Asking for a conflicting delegation recalls the holder's instead
+	if status := grant(b, "f", true); status != api.Status_ERR_JUKEBOX {
+		t.Errorf("Conflicting write delegation = %v, want ERR_JUKEBOX", status)
+	}
+	if !recalled(a) {
+		t.Error("Conflicting request did not recall the read delegation")
+	}
+	delegs.giveBack(a, "f")
+	if status := grant(b, "f", true); status != api.Status_OK {
+		t.Errorf("Write delegation once returned = %v", status)
+	}
+
+	// The stream ending releases what was held on it
+	delegs.close(b)
+	if held := delegs.held.Load(); held != 0 {
+		t.Errorf("%d delegations held after every stream ended", held)
+	}
+}
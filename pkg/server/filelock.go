@@ -0,0 +1,44 @@
+package server
+
+import "sync"
+
+// fileLocks hands out one mutex per file, created on demand and dropped
+// again when no one holds or waits for it
+type fileLocks struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+// fileLock is a mutex with a count of interested goroutines
+type fileLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks the mutex for key and returns the function that unlocks it
+func (l *fileLocks) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*fileLock)
+	}
+	fl, ok := l.locks[key]
+	if !ok {
+		fl = &fileLock{}
+		l.locks[key] = fl
+	}
+	fl.refs++
+	l.mu.Unlock()
+
+	fl.mu.Lock()
+
+	return func() {
+		fl.mu.Unlock()
+
+		l.mu.Lock()
+		fl.refs--
+		if fl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestFsInfo(t *testing.T) {
+	tempDir := t.TempDir()
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxReadSize = 64 << 10
+	config.MaxWriteSize = 32 << 10
+	config.MaxNameLength = 100
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	resp, err := server.FsInfo(ctx, &api.FsInfoRequest{FileHandle: rootHandle, Credentials: root})
+	if err != nil {
+		t.Fatalf("FsInfo failed: %v", err)
+	}
+	if resp.Status != api.Status_OK {
+		t.Fatalf("Unexpected FsInfo status: %v", resp.Status)
+	}
+	if resp.Rtmax != 64<<10 || resp.Rtpref != 64<<10 || resp.Wtmax != 32<<10 || resp.Wtpref != 32<<10 {
+		t.Errorf("Unexpected transfer sizes: %+v", resp)
+	}
+	if resp.Dtpref == 0 || resp.NameMax != 100 || resp.MaxFileSize == 0 {
+		t.Errorf("Unexpected limits: %+v", resp)
+	}
+	if !resp.Symlinks || !resp.HardLinks || !resp.CasePreserving || resp.TimeDeltaNanos == 0 {
+		t.Errorf("Unexpected properties: %+v", resp)
+	}
+	if resp.Attributes == nil || resp.Attributes.Type != api.FileType_DIRECTORY {
+		t.Errorf("FsInfo returned attributes %v, want those of the root", resp.Attributes)
+	}
+
+	bad, err := server.FsInfo(ctx, &api.FsInfoRequest{FileHandle: []byte{1, 2, 3}, Credentials: root})
+	if err != nil {
+		t.Fatalf("FsInfo failed: %v", err)
+	}
+	if bad.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("Unexpected status for an invalid handle: %v", bad.Status)
+	}
+}
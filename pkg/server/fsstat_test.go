@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+// quotaFS adds fixed quota reporting to a local file system
+type quotaFS struct {
+	*local.LocalFileSystem
+	lastUID uint32
+}
+
+func (q *quotaFS) Quota(ctx context.Context, path string, uid uint32) (fs.QuotaUsage, error) {
+	q.lastUID = uid
+	return fs.QuotaUsage{BytesUsed: 100, BytesLimit: 1000, FilesUsed: 2}, nil
+}
+
+func TestFsStat(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create filesystem and server
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	server, err := NewNFSServer(DefaultConfig(), localFS)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	resp, err := server.FsStat(context.Background(), &api.FsStatRequest{FileHandle: rootHandle})
+	if err != nil {
+		t.Fatalf("FsStat failed: %v", err)
+	}
+	if resp.Status != api.Status_OK {
+		t.Fatalf("FsStat returned status %v", resp.Status)
+	}
+	if resp.TotalBytes == 0 || resp.AvailBytes > resp.TotalBytes || resp.FreeBytes > resp.TotalBytes {
+		t.Errorf("Implausible capacity: total=%d free=%d avail=%d",
+			resp.TotalBytes, resp.FreeBytes, resp.AvailBytes)
+	}
+	if resp.NameMax == 0 {
+		t.Error("Expected a maximum name length")
+	}
+
+	// Invalid handle
+	resp, err = server.FsStat(context.Background(), &api.FsStatRequest{FileHandle: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("FsStat with invalid handle failed unexpectedly: %v", err)
+	}
+	if resp.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("Expected ERR_BADHANDLE status for invalid handle, got: %v", resp.Status)
+	}
+}
+
+func TestGetQuota(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	rootHandle, err := localFS.PathToFileHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	req := &api.GetQuotaRequest{
+		FileHandle:  rootHandle,
+		Credentials: &api.Credentials{Uid: 0, Gid: 0},
+	}
+
+	// A file system without quotas reports ERR_NOTSUPP
+	server, err := NewNFSServer(DefaultConfig(), localFS)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	req.FileHandle = SignHandle(server.handleKey, rootHandle)
+	resp, err := server.GetQuota(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_NOTSUPP {
+		t.Errorf("Expected ERR_NOTSUPP without quotas, got %v", resp.Status)
+	}
+
+	// With quotas, root is reported as the squashed user
+	qfs := &quotaFS{LocalFileSystem: localFS}
+	config := DefaultConfig()
+	server, err = NewNFSServer(config, qfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	req.FileHandle = SignHandle(server.handleKey, rootHandle)
+	resp, err = server.GetQuota(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GetQuota failed: %v", err)
+	}
+	if resp.Status != api.Status_OK {
+		t.Fatalf("GetQuota returned status %v", resp.Status)
+	}
+	if resp.Uid != config.AnonUID || qfs.lastUID != config.AnonUID {
+		t.Errorf("Expected quota for squashed uid %d, got %d", config.AnonUID, resp.Uid)
+	}
+	if resp.BytesUsed != 100 || resp.BytesLimit != 1000 || resp.FilesUsed != 2 {
+		t.Errorf("Unexpected usage: %+v", resp)
+	}
+}
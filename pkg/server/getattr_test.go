@@ -37,7 +37,7 @@ func TestGetAttr(t *testing.T) {
 	}
 
 	// Get file handle
-	fileHandle, err := fs.PathToFileHandle("/testfile.txt")
+	fileHandle, err := server.issueHandle(context.Background(), "/testfile.txt")
 	if err != nil {
 		t.Fatalf("Failed to get file handle: %v", err)
 	}
@@ -74,4 +74,135 @@ func TestGetAttr(t *testing.T) {
 	if resp.Attributes.Size != 12 { // "test content" = 12 bytes
 		t.Errorf("Wrong size: got %d, want 12", resp.Attributes.Size)
 	}
-}
\ No newline at end of file
+}
+func TestGetAttrEntryCount(t *testing.T) {
+	// Create temporary directory with a populated subdirectory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.Mkdir(filepath.Join(tempDir, "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(tempDir, "dir", name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server without root squashing so root may read the temp dir
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	dirHandle, err := server.issueHandle(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	fileHandle, err := server.issueHandle(context.Background(), "/dir/a")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	// Test cases
+	testCases := []struct {
+		name      string
+		handle    []byte
+		want      bool
+		wantCount uint64
+	}{
+		{"Directory", dirHandle, true, 3},
+		{"Not requested", dirHandle, false, 0},
+		{"Regular file", fileHandle, true, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.GetAttr(context.Background(), &api.GetAttrRequest{
+				FileHandle:     tc.handle,
+				Credentials:    root,
+				WantEntryCount: tc.want,
+			})
+			if err != nil {
+				t.Fatalf("GetAttr failed: %v", err)
+			}
+			if resp.Status != api.Status_OK {
+				t.Fatalf("Unexpected status: got %v, want OK", resp.Status)
+			}
+			if resp.EntryCount != tc.wantCount {
+				t.Errorf("Wrong entry count: got %d, want %d", resp.EntryCount, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestFileIdentity(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), make([]byte, 10), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := context.Background()
+	creds := &api.Credentials{}
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	// Every response names a file by the same id, its inode number, so
+	// files of equal size are not confused
+	listing, err := server.ReadDirPlus(ctx, &api.ReadDirPlusRequest{DirectoryHandle: rootHandle, Credentials: creds})
+	if err != nil || listing.Status != api.Status_OK {
+		t.Fatalf("ReadDirPlus = %v, %v", listing.GetStatus(), err)
+	}
+	ids := make(map[string]uint64)
+	for _, entry := range listing.Entries {
+		if entry.Attributes.Fileid != entry.FileId {
+			t.Errorf("%s: attributes carry file id %d, entry %d", entry.Name, entry.Attributes.Fileid, entry.FileId)
+		}
+		ids[entry.Name] = entry.FileId
+	}
+	for _, name := range []string{"a", "b"} {
+		if ino := fileID(t, filepath.Join(tempDir, name)); ids[name] != ino {
+			t.Errorf("%s listed with file id %d, want inode %d", name, ids[name], ino)
+		}
+
+		lookup, err := server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: rootHandle, Name: name, Credentials: creds})
+		if err != nil || lookup.Status != api.Status_OK {
+			t.Fatalf("Lookup = %v, %v", lookup.GetStatus(), err)
+		}
+		getAttr, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: lookup.FileHandle, Credentials: creds})
+		if err != nil || getAttr.Status != api.Status_OK {
+			t.Fatalf("GetAttr = %v, %v", getAttr.GetStatus(), err)
+		}
+		if lookup.Attributes.Fileid != ids[name] || getAttr.Attributes.Fileid != ids[name] {
+			t.Errorf("%s: Lookup file id %d, GetAttr %d, want %d", name, lookup.Attributes.Fileid, getAttr.Attributes.Fileid, ids[name])
+		}
+	}
+	if ids["."] != ids[".."] {
+		t.Errorf("Root's .. has file id %d, want the root's own %d", ids[".."], ids["."])
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// handleKeySize is the length of the key signing file handles
+	handleKeySize = 32
+
+	// handleMACSize is the length of the HMAC-SHA256 signature appended to
+	// every handle the server issues
+	handleMACSize = sha256.Size
+
+	// minHandleSize is the length of the smallest file system handle
+	minHandleSize = 16
+
+	// handleExpirySize is the length of the expiry time, in Unix seconds,
+	// carried by handles that expire
+	handleExpirySize = 8
+
+	// maxSnapshotNameSize is the length of the longest snapshot name a
+	// snapshot handle carries, whose length takes one byte
+	maxSnapshotNameSize = 255
+)
+
+// LoadHandleKey reads the handle signing key in file, creating the file
+// with a new random key if it does not exist. Servers sharing the key
+// accept each other's handles, and handles survive restarts.
+func LoadHandleKey(file string) ([]byte, error) {
+	return loadKey(file, "handle")
+}
+
+// loadKey reads the handleKeySize-byte key in file, creating the file with
+// a new random key if it does not exist. what names the key in errors.
+func loadKey(file string, what string) ([]byte, error) {
+	key, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, handleKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate %s key: %w", what, err)
+		}
+		// Exclusive create, so two servers starting at once agree
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			return loadKey(file, what)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s key file: %w", what, err)
+		}
+		if _, err := f.Write(key); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write %s key file: %w", what, err)
+		}
+		if err := f.Close(); err != nil {
+			return nil, fmt.Errorf("failed to write %s key file: %w", what, err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s key file: %w", what, err)
+	}
+	if len(key) != handleKeySize {
+		return nil, fmt.Errorf("%s key file %s holds %d bytes, want %d", what, file, len(key), handleKeySize)
+	}
+	return key, nil
+}
+
+// SignHandle appends the signature under key to a file system handle,
+// giving the handle a server with that key issues for the same file
+func SignHandle(key []byte, handle []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(handle)
+	// Capped, so the signature never lands in the caller's array
+	return mac.Sum(handle[:len(handle):len(handle)])
+}
+
+// SignExpiringHandle appends to a file system handle the time it expires
+// and the signature under key of both, giving the handle a server with
+// that key and a handle lifetime issues for the same file. Expiring
+// handles are signed with a key derived from key, so they cannot be taken
+// for handles that do not expire.
+func SignExpiringHandle(key []byte, handle []byte, expires time.Time) []byte {
+	signed := binary.BigEndian.AppendUint64(handle[:len(handle):len(handle)], uint64(expires.Unix()))
+	return SignHandle(expiringKey(key), signed)
+}
+
+// expiringKey returns the key expiring handles are signed with
+func expiringKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("expiring handles"))
+	return mac.Sum(nil)
+}
+
+// SignSnapshotHandle appends to a handle the server issued the name of a
+// snapshot, and the signature under key of both, giving the handle that
+// reads the file at the same path in that snapshot. The handle decorated
+// keeps its own signature, so it still expires or is revoked as before.
+// Snapshot handles are signed with a key derived from key, so they cannot
+// be taken for handles of the live tree.
+func SignSnapshotHandle(key []byte, handle []byte, snapshot string) []byte {
+	signed := append(handle[:len(handle):len(handle)], snapshot...)
+	signed = append(signed, byte(len(snapshot)))
+	return SignHandle(snapshotHandleKey(key), signed)
+}
+
+// snapshotHandleKey returns the key snapshot handles are signed with
+func snapshotHandleKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("snapshot handles"))
+	return mac.Sum(nil)
+}
+
+// HandleExpiry returns the time a handle issued at now expires, for a
+// server with the given handle lifetime. Handles issued within the same
+// lifetime-long window share their expiry, so a file keeps one handle for
+// a while and every handle lasts between one and two lifetimes.
+func HandleExpiry(now time.Time, lifetime time.Duration) time.Time {
+	return now.Truncate(lifetime).Add(2 * lifetime)
+}
+
+// issueHandle returns the signed handle of the file at path, the form in
+// which handles are given to clients
+func (s *NFSServer) issueHandle(ctx context.Context, path string) ([]byte, error) {
+	handle, err := s.fileSystem.PathToFileHandle(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if s.handleLifetime > 0 && !bytes.Equal(handle, s.rootHandle) {
+		return SignExpiringHandle(s.handleKey, handle, HandleExpiry(time.Now(), s.handleLifetime)), nil
+	}
+	return SignHandle(s.handleKey, handle), nil
+}
+
+// verifyHandle checks the signature of a handle from a client and returns
+// the file system handle it carries. Handles that expired or were revoked
+// fail, as do handles without an expiry on a server with a handle
+// lifetime, but for the root's: anyone may ask for it with GetRootHandle,
+// and mounts hold it for as long as they are mounted. Snapshot handles
+// give the file system handle of the live file they decorate.
+func (s *NFSServer) verifyHandle(handle []byte) ([]byte, bool) {
+	inner, _, ok := s.openHandle(handle)
+	return inner, ok
+}
+
+// openHandle is verifyHandle, also returning the snapshot a snapshot
+// handle reads from (empty for other handles)
+func (s *NFSServer) openHandle(handle []byte) ([]byte, string, bool) {
+	inner, expires, ok := s.checkSignature(handle)
+	var snapshot string
+	if !ok {
+		// The handle decorated has to pass the checks in its own right
+		live, name, ok := s.checkSnapshotSignature(handle)
+		if !ok || s.revoked.has(handle) {
+			return nil, "", false
+		}
+		if inner, expires, ok = s.checkSignature(live); !ok {
+			return nil, "", false
+		}
+		handle, snapshot = live, name
+	}
+	if expires.IsZero() && s.handleLifetime > 0 && !bytes.Equal(inner, s.rootHandle) {
+		return nil, "", false
+	}
+	if !expires.IsZero() && !time.Now().Before(expires) {
+		return nil, "", false
+	}
+	if s.revoked.has(handle) {
+		return nil, "", false
+	}
+	return inner, snapshot, true
+}
+
+// checkSignature checks the signature of a handle, expiring or not, and
+// returns the file system handle it carries and when it expires (zero =
+// never)
+func (s *NFSServer) checkSignature(handle []byte) ([]byte, time.Time, bool) {
+	if len(handle) < minHandleSize+handleMACSize {
+		return nil, time.Time{}, false
+	}
+	signed := handle[:len(handle)-handleMACSize]
+	if validMAC(s.handleKey, signed, handle[len(signed):]) {
+		return signed, time.Time{}, true
+	}
+	if len(signed) < minHandleSize+handleExpirySize || !validMAC(expiringKey(s.handleKey), signed, handle[len(signed):]) {
+		return nil, time.Time{}, false
+	}
+	inner := signed[:len(signed)-handleExpirySize]
+	expires := time.Unix(int64(binary.BigEndian.Uint64(signed[len(inner):])), 0)
+	return inner, expires, true
+}
+
+// checkSnapshotSignature checks the signature of a snapshot handle and
+// returns the handle it decorates and the snapshot's name
+func (s *NFSServer) checkSnapshotSignature(handle []byte) ([]byte, string, bool) {
+	if len(handle) < minHandleSize+2*handleMACSize+1 {
+		return nil, "", false
+	}
+	signed := handle[:len(handle)-handleMACSize]
+	if !validMAC(snapshotHandleKey(s.handleKey), signed, handle[len(signed):]) {
+		return nil, "", false
+	}
+	nameSize := int(signed[len(signed)-1])
+	if nameSize == 0 || len(signed)-1-nameSize < minHandleSize+handleMACSize {
+		return nil, "", false
+	}
+	live := signed[:len(signed)-1-nameSize]
+	return live, string(signed[len(live) : len(signed)-1]), true
+}
+
+// validMAC reports whether sum is the signature of data under key
+func validMAC(key []byte, data []byte, sum []byte) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), sum)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestHandleSignatures(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(t.TempDir(), "handle.key")
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	handle, err := server.issueHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	unsigned, err := fs.PathToFileHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	tampered := bytes.Clone(handle)
+	tampered[len(unsigned)-1] ^= 1 // Another generation of the same inode
+
+	getAttr := func(server *NFSServer, handle []byte) api.Status {
+		t.Helper()
+		resp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle, Credentials: root})
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		return resp.Status
+	}
+
+	tests := []struct {
+		name   string
+		handle []byte
+		want   api.Status
+	}{
+		{"Issued handle", handle, api.Status_OK},
+		{"Unsigned handle", unsigned, api.Status_ERR_BADHANDLE},
+		{"Forged signature", append(bytes.Clone(unsigned), make([]byte, handleMACSize)...), api.Status_ERR_BADHANDLE},
+		{"Tampered handle", tampered, api.Status_ERR_BADHANDLE},
+		{"Truncated signature", handle[:len(handle)-1], api.Status_ERR_BADHANDLE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := getAttr(server, tt.handle); status != tt.want {
+				t.Errorf("GetAttr returned %v, want %v", status, tt.want)
+			}
+		})
+	}
+
+	// Handles from Lookup are signed too
+	lookup, err := server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: handle, Name: "x", Credentials: root})
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	lookup, err = server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: rootHandle, Name: "file", Credentials: root})
+	if err != nil || lookup.Status != api.Status_OK || !bytes.Equal(lookup.FileHandle, handle) {
+		t.Errorf("Lookup returned %x, %v, %v; want the issued handle", lookup.GetFileHandle(), lookup.GetStatus(), err)
+	}
+
+	// A server with the same key file, e.g. after a restart, accepts the
+	// handle; one with a fresh key does not
+	restarted, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if status := getAttr(restarted, handle); status != api.Status_OK {
+		t.Errorf("GetAttr after restart returned %v, want OK", status)
+	}
+	other := *config
+	other.HandleKeyFile = ""
+	fresh, err := NewNFSServer(&other, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if status := getAttr(fresh, handle); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr with another key returned %v, want ERR_BADHANDLE", status)
+	}
+}
+
+func TestLoadHandleKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "handle.key")
+	key, err := LoadHandleKey(file)
+	if err != nil || len(key) != handleKeySize {
+		t.Fatalf("LoadHandleKey returned %d bytes, %v", len(key), err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Key file mode is %v, %v; want -rw-------", info.Mode(), err)
+	}
+	again, err := LoadHandleKey(file)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("Second LoadHandleKey returned a different key, %v", err)
+	}
+
+	if err := os.WriteFile(file, []byte("short"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	if _, err := LoadHandleKey(file); err == nil {
+		t.Errorf("LoadHandleKey accepted a 5-byte key")
+	}
+}
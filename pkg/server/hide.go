@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// nameFilter hides the entries matching an export's HiddenNames and, with
+// HideDotfiles, those whose names start with a dot from everyone but root.
+// It filters what the backend returns rather than wrapping it, so the
+// backend's optional interfaces stay reachable.
+type nameFilter struct {
+	patterns []string
+	dotfiles bool
+}
+
+// CheckHiddenNames returns an error unless every pattern is valid for
+// path.Match
+func CheckHiddenNames(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid hidden name pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// newNameFilter returns the filter for config, or nil if it hides nothing
+func newNameFilter(config *Config) (*nameFilter, error) {
+	if err := CheckHiddenNames(config.HiddenNames); err != nil {
+		return nil, err
+	}
+	if len(config.HiddenNames) == 0 && !config.HideDotfiles {
+		return nil, nil
+	}
+	return &nameFilter{patterns: config.HiddenNames, dotfiles: config.HideDotfiles}, nil
+}
+
+// hidden reports whether name is hidden from creds. Root, after squashing,
+// sees everything; "." and ".." are never hidden.
+func (f *nameFilter) hidden(name string, creds fs.Credentials) bool {
+	if f == nil || creds.UID == 0 || name == "." || name == ".." {
+		return false
+	}
+	if f.dotfiles && strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns the entries not hidden from creds, reusing entries
+func (f *nameFilter) visible(entries []fs.DirEntry, creds fs.Credentials) []fs.DirEntry {
+	if f == nil || creds.UID == 0 {
+		return entries
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !f.hidden(entry.Name, creds) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// lookupVisible looks up name in dirPath as the backend does, failing with
+// fs.ErrNotExist if the name is hidden from creds
+func (s *NFSServer) lookupVisible(ctx context.Context, dirPath string, name string, creds fs.Credentials) (string, fs.FileInfo, error) {
+	if s.names.hidden(name, creds) {
+		return "", fs.FileInfo{}, fs.NewError("lookup", filepath.Join(dirPath, name), fs.ErrNotExist)
+	}
+	return s.fileSystem.Lookup(ctx, dirPath, name)
+}
+
+// visiblePage reads a page of a listing with read from cookie, dropping
+// the entries hidden from creds. A page whose entries are all hidden is
+// followed by the next one, so clients are not handed empty pages before
+// the end of the directory.
+func (s *NFSServer) visiblePage(creds fs.Credentials, cookie uint64,
+	read func(cookie uint64) ([]fs.DirEntry, bool, error)) ([]fs.DirEntry, bool, error) {
+
+	for {
+		entries, complete, err := read(cookie)
+		if err != nil {
+			return nil, false, err
+		}
+		kept := s.names.visible(entries, creds)
+		if len(kept) > 0 || complete || len(entries) == 0 {
+			return kept, complete, nil
+		}
+		cookie = uint64(entries[len(entries)-1].Cookie)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestHiddenNames(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{".secret", "a.txt", "b.tmp"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "lost+found"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HiddenNames = []string{"lost+found", "*.tmp"}
+	config.HideDotfiles = true
+	server, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := context.Background()
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	user := &api.Credentials{Uid: 1000, Gid: 1000}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	lookup := func(creds *api.Credentials, name string) api.Status {
+		t.Helper()
+		resp, err := server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: rootHandle, Name: name, Credentials: creds})
+		if err != nil {
+			t.Fatalf("Lookup returned error: %v", err)
+		}
+		return resp.Status
+	}
+	readDir := func(creds *api.Credentials, cookie, verifier uint64, count uint32) *api.ReadDirResponse {
+		t.Helper()
+		resp, err := server.ReadDir(ctx, &api.ReadDirRequest{
+			DirectoryHandle: rootHandle, Credentials: creds, Cookie: cookie, CookieVerifier: verifier, Count: count, Sorted: true,
+		})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("ReadDir = %v, %v", resp.GetStatus(), err)
+		}
+		return resp
+	}
+	names := func(resp *api.ReadDirResponse) string {
+		var names []string
+		for _, entry := range resp.Entries {
+			names = append(names, entry.Name)
+		}
+		return strings.Join(names, " ")
+	}
+
+	// Hidden names do not exist for users, but do for root
+	for _, name := range []string{".secret", "b.tmp", "lost+found"} {
+		if status := lookup(user, name); status != api.Status_ERR_NOENT {
+			t.Errorf("Lookup of hidden %s = %v, want ERR_NOENT", name, status)
+		}
+		if status := lookup(root, name); status != api.Status_OK {
+			t.Errorf("Lookup of hidden %s by root = %v, want OK", name, status)
+		}
+	}
+	if status := lookup(user, "a.txt"); status != api.Status_OK {
+		t.Errorf("Lookup of a.txt = %v, want OK", status)
+	}
+	if status := lookup(user, ".."); status != api.Status_OK {
+		t.Errorf("Lookup of .. = %v, want OK", status)
+	}
+
+	listing := readDir(user, 0, 0, 100)
+	if got := names(listing); got != ". .. a.txt" {
+		t.Errorf("Listing = %q, want \". .. a.txt\"", got)
+	}
+	if got := names(readDir(root, 0, 0, 100)); got != ". .. .secret a.txt b.tmp lost+found" {
+		t.Errorf("Listing by root = %q", got)
+	}
+
+	// A page holding only hidden names is skipped rather than returned
+	// empty, and the listing ends on the hidden names after a.txt
+	page := readDir(user, 2, listing.CookieVerifier, 1)
+	if got := names(page); got != "a.txt" || page.Eof {
+		t.Fatalf("Page after .. = %q, eof %v, want a.txt", got, page.Eof)
+	}
+	last := readDir(user, page.Entries[0].Cookie, page.CookieVerifier, 1)
+	if len(last.Entries) != 0 || !last.Eof {
+		t.Errorf("Page after a.txt = %q, eof %v, want the end", names(last), last.Eof)
+	}
+
+	config = DefaultConfig()
+	config.HiddenNames = []string{"[z-"}
+	if _, err := NewNFSServer(config, lfs); err == nil {
+		t.Error("NewNFSServer accepted an invalid pattern")
+	}
+}
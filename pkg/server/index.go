@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// How often the file system's index is written to Config.IndexDir
+const indexSaveInterval = time.Minute
+
+// indexPersister returns the file system's index persistence, if
+// Config.IndexDir asks for it and the file system supports it
+func (s *NFSServer) indexPersister() (fs.IndexPersister, bool) {
+	if s.config.IndexDir == "" {
+		return nil, false
+	}
+	persister, ok := fs.As[fs.IndexPersister](s.fileSystem)
+	return persister, ok
+}
+
+// loadIndex loads the index saved in Config.IndexDir
+func (s *NFSServer) loadIndex() error {
+	if s.config.IndexDir == "" {
+		return nil
+	}
+	persister, ok := s.indexPersister()
+	if !ok {
+		slog.Warn("File system does not save its index, ignoring the index directory", "dir", s.config.IndexDir)
+		return nil
+	}
+	if err := persister.LoadIndex(s.config.IndexDir); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	return nil
+}
+
+// indexSaveLoop saves the index to Config.IndexDir periodically
+func (s *NFSServer) indexSaveLoop() {
+	ticker := time.NewTicker(indexSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.SaveIndex(); err != nil {
+			slog.Error("Saving the index failed", "err", err)
+		}
+	}
+}
+
+// SaveIndex writes the parts of the file system's index changed since the
+// last save to Config.IndexDir. It does nothing without an index directory.
+func (s *NFSServer) SaveIndex() error {
+	persister, ok := s.indexPersister()
+	if !ok {
+		return nil
+	}
+	if err := persister.SaveIndex(s.config.IndexDir); err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestSaveIndex(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.IndexDir = filepath.Join(t.TempDir(), "index")
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// Nothing saved yet loads as an empty index
+	if err := server.loadIndex(); err != nil {
+		t.Fatalf("loadIndex failed: %v", err)
+	}
+	if _, err := fs.PathToFileHandle(context.Background(), "/file"); err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := server.SaveIndex(); err != nil {
+		t.Fatalf("SaveIndex failed: %v", err)
+	}
+	if shards, _ := filepath.Glob(filepath.Join(config.IndexDir, "*.idx")); len(shards) == 0 {
+		t.Errorf("SaveIndex wrote nothing to %s", config.IndexDir)
+	}
+
+	// Without an index directory saving does nothing
+	server.config.IndexDir = ""
+	if err := server.SaveIndex(); err != nil {
+		t.Errorf("SaveIndex without a directory returned %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestLink(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "private"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	fileHandle, err := server.issueHandle(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	privateHandle, err := server.issueHandle(context.Background(), "/private")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	tests := []struct {
+		name      string
+		target    []byte
+		dir       []byte
+		link      string
+		creds     *api.Credentials
+		want      api.Status
+		wantNlink uint32
+	}{
+		{"First link", fileHandle, rootHandle, "copy1", root, api.Status_OK, 2},
+		{"Second link in another directory", fileHandle, privateHandle, "copy2", root, api.Status_OK, 3},
+		{"Existing name", fileHandle, rootHandle, "copy1", root, api.Status_ERR_EXIST, 0},
+		{"Directory", privateHandle, rootHandle, "dirlink", root, api.Status_ERR_ISDIR, 0},
+		{"No write permission", fileHandle, privateHandle, "mine", &api.Credentials{Uid: 1000, Gid: 1000}, api.Status_ERR_ACCES, 0},
+		{"Bad handle", []byte("bogus"), rootHandle, "x", root, api.Status_ERR_BADHANDLE, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Link(context.Background(), &api.LinkRequest{
+				TargetHandle:    tt.target,
+				DirectoryHandle: tt.dir,
+				Name:            tt.link,
+				Credentials:     tt.creds,
+			})
+			if err != nil {
+				t.Fatalf("Link returned error: %v", err)
+			}
+			if resp.Status != tt.want {
+				t.Fatalf("Link returned %v, want %v", resp.Status, tt.want)
+			}
+			if tt.want == api.Status_OK && resp.Attributes.GetNlink() != tt.wantNlink {
+				t.Errorf("Link returned nlink %d, want %d", resp.Attributes.GetNlink(), tt.wantNlink)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// Lock outcomes, reported as ERR_DENIED, ERR_DEADLOCK, ERR_GRACE and
+// ERR_NO_GRACE
+var (
+	errLockDenied   = errors.New("conflicting lock held")
+	errLockDeadlock = errors.New("waiting for the lock would deadlock")
+	errLockGrace    = errors.New("locks are being reclaimed")
+	errLockNoGrace  = errors.New("no locks to reclaim")
+)
+
+// lockEOF ends a lock running through the end of the file, however far
+// the file grows
+const lockEOF = math.MaxUint64
+
+// lockOwner identifies who holds a byte-range lock: an owner, such as a
+// process, within a client
+type lockOwner struct {
+	client string
+	owner  uint64
+}
+
+// byteLock is a lock on the bytes from start up to but excluding end
+type byteLock struct {
+	owner     lockOwner
+	start     uint64
+	end       uint64
+	exclusive bool
+}
+
+// newByteLock returns the lock a request describes, with length 0, or one
+// running past the largest offset, meaning through the end of the file
+func newByteLock(client string, owner uint64, offset uint64, length uint64, exclusive bool) *byteLock {
+	l := &byteLock{owner: lockOwner{client, owner}, start: offset, end: offset + length, exclusive: exclusive}
+	if length == 0 || l.end < offset {
+		l.end = lockEOF
+	}
+	return l
+}
+
+// overlaps reports whether l covers any of the bytes from start to end
+func (l *byteLock) overlaps(start uint64, end uint64) bool {
+	return l.start < end && start < l.end
+}
+
+// conflicts reports whether l keeps other from being taken: they belong
+// to different owners, overlap, and at least one of them is exclusive
+func (l *byteLock) conflicts(other *byteLock) bool {
+	return l.owner != other.owner && l.overlaps(other.start, other.end) && (l.exclusive || other.exclusive)
+}
+
+// holder describes l for a response
+func (l *byteLock) holder() *api.LockHolder {
+	h := &api.LockHolder{
+		ClientId:  l.owner.client,
+		Owner:     l.owner.owner,
+		Offset:    l.start,
+		Exclusive: l.exclusive,
+	}
+	if l.end != lockEOF {
+		h.Length = l.end - l.start
+	}
+	return h
+}
+
+// lockTable holds the byte-range locks on every file, keyed as fences are,
+// for all exports. Each client's locks are held under a lease renewed by
+// every lock call it makes; once the lease runs out, as when the client
+// crashed or lost its connection, all of them are released. A lease of 0
+// never runs out.
+//
+// Locks are lost when the server restarts. The clients holding leases are
+// saved in a file so that the next instance can give them a grace period
+// to reclaim their locks, during which no other locks are granted.
+type lockTable struct {
+	mu    sync.Mutex
+	lease time.Duration
+	now   func() time.Time
+	files map[string][]*byteLock
+
+	// When each client's lease runs out, and whether expireLoop is
+	// watching them
+	leases   map[string]time.Time
+	expiring bool
+
+	// Identifies this instance of the server to clients, which reclaim
+	// their locks when it changes
+	boot uint64
+
+	// Clients holding leases, kept in file (empty = in memory only)
+	file    string
+	clients map[string]bool
+
+	// Clients of the previous instance yet to reclaim their locks, and
+	// when the grace period given them ends
+	reclaimers map[string]bool
+	graceEnds  time.Time
+
+	// Owners each blocked owner waits for, to detect deadlocks. An owner
+	// waits for one lock at a time.
+	waits map[lockOwner][]lockOwner
+
+	// Closed, and replaced, whenever locks are released
+	released chan struct{}
+}
+
+// newLockTable creates an empty lock table whose leases last lease. The
+// clients saved in file by the previous instance, if any, may reclaim
+// their locks for grace (0 = no grace period).
+func newLockTable(lease time.Duration, grace time.Duration, file string) (*lockTable, error) {
+	t := &lockTable{
+		lease:      lease,
+		now:        time.Now,
+		files:      make(map[string][]*byteLock),
+		leases:     make(map[string]time.Time),
+		boot:       uint64(time.Now().UnixNano()),
+		file:       file,
+		clients:    make(map[string]bool),
+		reclaimers: make(map[string]bool),
+		waits:      make(map[lockOwner][]lockOwner),
+		released:   make(chan struct{}),
+	}
+	if file == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock clients file: %w", err)
+	}
+	var saved []string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse lock clients file %s: %w", file, err)
+	}
+	if len(saved) == 0 || grace <= 0 {
+		return t, nil
+	}
+	for _, client := range saved {
+		t.reclaimers[client] = true
+	}
+	t.graceEnds = t.now().Add(grace)
+	time.AfterFunc(grace, t.endGrace)
+	slog.Info("Lock grace period started", "clients", len(saved), "grace", grace)
+	return t, nil
+}
+
+// leaseSeconds returns the lease in whole seconds, rounded up, for
+// responses
+func (t *lockTable) leaseSeconds() uint32 {
+	return uint32((t.lease + time.Second - 1) / time.Second)
+}
+
+// admit checks whether client may take a lock now: during the grace period
+// only reclaims by clients of the previous instance are allowed, and
+// reclaims are refused outside it
+func (t *lockTable) admit(client string, reclaim bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case !t.graceLocked():
+		if reclaim {
+			return errLockNoGrace
+		}
+	case !reclaim:
+		return errLockGrace
+	case !t.reclaimers[client]:
+		return errLockNoGrace
+	}
+	return nil
+}
+
+// register starts client's lease and returns the time left in the grace
+// period and whether client may reclaim locks in it
+func (t *lockTable) register(client string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewLocked(client)
+	if !t.graceLocked() {
+		return 0, false
+	}
+	return t.graceEnds.Sub(t.now()), t.reclaimers[client]
+}
+
+// reclaimed records that client reclaimed all of its locks, ending the
+// grace period once every client of the previous instance has
+func (t *lockTable) reclaimed(client string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.reclaimers[client] {
+		return
+	}
+	delete(t.reclaimers, client)
+	if len(t.reclaimers) == 0 {
+		slog.Info("Lock grace period over: every client reclaimed its locks")
+	}
+	t.saveLocked()
+}
+
+// graceLocked reports whether the grace period is under way
+func (t *lockTable) graceLocked() bool {
+	if len(t.reclaimers) == 0 {
+		return false
+	}
+	if t.now().Before(t.graceEnds) {
+		return true
+	}
+	slog.Warn("Lock grace period over; clients that did not reclaim their locks lost them", "clients", len(t.reclaimers))
+	clear(t.reclaimers)
+	t.saveLocked()
+	return false
+}
+
+// endGrace ends the grace period once its time is up, forgetting the
+// clients that never came back
+func (t *lockTable) endGrace() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.graceLocked()
+}
+
+// acquire takes want on the file under key, replacing its owner's locks
+// over the range. If another owner holds a conflicting lock it fails with
+// errLockDenied and that lock, unless block is set, in which case it waits
+// until the lock can be taken or ctx is done. Waiting fails at once with
+// errLockDeadlock if an owner want waits for is, directly or not, waiting
+// for want's owner.
+func (t *lockTable) acquire(ctx context.Context, key string, want *byteLock, block bool) (*byteLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	waiting := false
+	defer func() {
+		if waiting {
+			delete(t.waits, want.owner)
+		}
+	}()
+
+	for {
+		t.renewLocked(want.owner.client)
+		var conflict *byteLock
+		var holders []lockOwner
+		for _, l := range t.files[key] {
+			if l.conflicts(want) {
+				if conflict == nil {
+					conflict = l
+				}
+				holders = append(holders, l.owner)
+			}
+		}
+		if conflict == nil {
+			t.setLocked(key, want)
+			return nil, nil
+		}
+		if !block {
+			return conflict, errLockDenied
+		}
+		if t.waitsForLocked(holders, want.owner) {
+			return conflict, errLockDeadlock
+		}
+
+		t.waits[want.owner] = holders
+		waiting = true
+		released := t.released
+		t.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+		}
+		t.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitsForLocked reports whether any of owners is target or waits, through
+// a chain of blocked owners, for target
+func (t *lockTable) waitsForLocked(owners []lockOwner, target lockOwner) bool {
+	seen := make(map[lockOwner]bool)
+	for len(owners) > 0 {
+		o := owners[len(owners)-1]
+		owners = owners[:len(owners)-1]
+		if o == target {
+			return true
+		}
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+		owners = append(owners, t.waits[o]...)
+	}
+	return false
+}
+
+// unlock releases owner's locks on the bytes from start to end of the
+// file under key, splitting locks that extend beyond them
+func (t *lockTable) unlock(key string, owner lockOwner, start uint64, end uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewLocked(owner.client)
+	if t.clearLocked(key, owner, start, end) {
+		t.releasedLocked()
+	}
+}
+
+// test returns a lock held by another owner that would keep want from
+// being taken, or nil if there is none
+func (t *lockTable) test(key string, want *byteLock) *byteLock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewLocked(want.owner.client)
+	for _, l := range t.files[key] {
+		if l.conflicts(want) {
+			return l
+		}
+	}
+	return nil
+}
+
+// renew extends client's lease and returns how many locks it holds or is
+// waiting for
+func (t *lockTable) renew(client string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewLocked(client)
+	count := 0
+	for _, locks := range t.files {
+		for _, l := range locks {
+			if l.owner.client == client {
+				count++
+			}
+		}
+	}
+	for o := range t.waits {
+		if o.client == client {
+			count++
+		}
+	}
+	return count
+}
+
+// renewLocked extends client's lease, starting expireLoop if no one is
+// watching leases yet
+func (t *lockTable) renewLocked(client string) {
+	if !t.clients[client] {
+		t.clients[client] = true
+		t.saveLocked()
+	}
+	if t.lease <= 0 {
+		return
+	}
+	t.leases[client] = t.now().Add(t.lease)
+	if !t.expiring {
+		t.expiring = true
+		go t.expireLoop()
+	}
+}
+
+// setLocked records want, replacing its owner's locks over the range and
+// merging it with the owner's adjacent locks of the same kind
+func (t *lockTable) setLocked(key string, want *byteLock) {
+	if t.clearLocked(key, want.owner, want.start, want.end) {
+		// A lock shrunk or downgraded may unblock others
+		t.releasedLocked()
+	}
+	merged := *want
+	var locks []*byteLock
+	for _, l := range t.files[key] {
+		if l.owner == merged.owner && l.exclusive == merged.exclusive &&
+			(l.end == merged.start || merged.end == l.start) {
+			merged.start = min(merged.start, l.start)
+			merged.end = max(merged.end, l.end)
+			continue
+		}
+		locks = append(locks, l)
+	}
+	locks = append(locks, &merged)
+	sort.Slice(locks, func(i, j int) bool { return locks[i].start < locks[j].start })
+	t.files[key] = locks
+}
+
+// clearLocked removes owner's locks on the bytes from start to end of the
+// file under key, keeping the parts outside them, and reports whether
+// there were any
+func (t *lockTable) clearLocked(key string, owner lockOwner, start uint64, end uint64) bool {
+	var locks []*byteLock
+	cleared := false
+	for _, l := range t.files[key] {
+		if l.owner != owner || !l.overlaps(start, end) {
+			locks = append(locks, l)
+			continue
+		}
+		cleared = true
+		if l.start < start {
+			locks = append(locks, &byteLock{owner: owner, start: l.start, end: start, exclusive: l.exclusive})
+		}
+		if end < l.end {
+			locks = append(locks, &byteLock{owner: owner, start: end, end: l.end, exclusive: l.exclusive})
+		}
+	}
+	if len(locks) == 0 {
+		delete(t.files, key)
+	} else {
+		t.files[key] = locks
+	}
+	return cleared
+}
+
+// releasedLocked wakes every blocked acquire to try again
+func (t *lockTable) releasedLocked() {
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// expire releases the locks of clients whose leases have run out
+func (t *lockTable) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	expired := make(map[string]bool)
+	for client, until := range t.leases {
+		if now.After(until) {
+			expired[client] = true
+			delete(t.leases, client)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	released := 0
+	for key, locks := range t.files {
+		kept := locks[:0]
+		for _, l := range locks {
+			if expired[l.owner.client] {
+				released++
+				continue
+			}
+			kept = append(kept, l)
+		}
+		if len(kept) == 0 {
+			delete(t.files, key)
+		} else {
+			t.files[key] = kept
+		}
+	}
+	for client := range expired {
+		slog.Warn("Lock lease expired; locks released", "client", client)
+		delete(t.clients, client)
+	}
+	t.saveLocked()
+	if released > 0 {
+		t.releasedLocked()
+	}
+}
+
+// expireLoop releases the locks of expired leases until no leases are left
+func (t *lockTable) expireLoop() {
+	ticker := time.NewTicker(max(t.lease/4, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		t.expire()
+
+		t.mu.Lock()
+		if len(t.leases) == 0 {
+			t.expiring = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+	}
+}
+
+// saveLocked writes the clients holding leases, and those still allowed to
+// reclaim locks, to the table's file. The file is replaced atomically, so
+// a crash leaves the previous copy. Locks are granted even if it cannot be
+// written, as they were before it was kept.
+func (t *lockTable) saveLocked() {
+	if t.file == "" {
+		return
+	}
+	clients := make([]string, 0, len(t.clients)+len(t.reclaimers))
+	for client := range t.clients {
+		clients = append(clients, client)
+	}
+	for client := range t.reclaimers {
+		if !t.clients[client] {
+			clients = append(clients, client)
+		}
+	}
+	sort.Strings(clients)
+	data, err := json.MarshalIndent(clients, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(t.file, data)
+	}
+	if err != nil {
+		slog.Warn("Failed to save lock clients", "file", t.file, "error", err)
+	}
+}
+
+// checkLockTarget checks a lock request: that it names its client, and a
+// regular file the caller may access with mode (4 = read, 2 = write, 0 =
+// no access needed). Locks are advisory, but like fcntl locks, shared ones
+// need read access to the file and exclusive ones write access.
+func (s *NFSServer) checkLockTarget(ctx context.Context, handle []byte, protoCreds *api.Credentials, clientID string, mode fs.FileMode) api.Status {
+	if _, err := s.validateFileHandle(handle); err != nil {
+		return api.Status_ERR_BADHANDLE
+	}
+	if clientID == "" {
+		return api.Status_ERR_INVAL
+	}
+	path, err := s.resolveHandle(ctx, handle)
+	if err != nil {
+		return nfs.MapErrorToStatus(err)
+	}
+
+	creds := nfs.ProtoCredsToFSCreds(protoCreds)
+	s.policy().squash(&creds)
+	if mode != 0 {
+		if err := s.fileSystem.Access(ctx, path, mode, creds); err != nil {
+			return nfs.MapErrorToStatus(err)
+		}
+	}
+
+	info, err := s.fileSystem.GetAttr(ctx, path)
+	if err != nil {
+		return nfs.MapErrorToStatus(err)
+	}
+	if info.Type != fs.FileTypeRegular {
+		return api.Status_ERR_ISDIR
+	}
+	return api.Status_OK
+}
+
+// lockResponse returns the response to a Lock that ended with conflict
+// and err
+func (s *NFSServer) lockResponse(conflict *byteLock, err error) *api.LockResponse {
+	switch {
+	case err == nil:
+		return &api.LockResponse{Status: api.Status_OK, LeaseSeconds: s.locks.leaseSeconds()}
+	case errors.Is(err, errLockDenied):
+		return &api.LockResponse{Status: api.Status_ERR_DENIED, Holder: conflict.holder()}
+	case errors.Is(err, errLockDeadlock):
+		return &api.LockResponse{Status: api.Status_ERR_DEADLOCK, Holder: conflict.holder()}
+	case errors.Is(err, errLockGrace):
+		return &api.LockResponse{Status: api.Status_ERR_GRACE}
+	case errors.Is(err, errLockNoGrace):
+		return &api.LockResponse{Status: api.Status_ERR_NO_GRACE}
+	}
+	return &api.LockResponse{Status: nfs.MapErrorToStatus(err)}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestByteRangeLocks(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	handleA, err := server.issueHandle(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	handleB, err := server.issueHandle(ctx, "/b")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	lock := func(ctx context.Context, handle []byte, client string, offset, length uint64, exclusive, block bool) *api.LockResponse {
+		resp, err := server.Lock(ctx, &api.LockRequest{
+			FileHandle: handle, ClientId: client, Owner: 1,
+			Offset: offset, Length: length, Exclusive: exclusive, Block: block,
+		})
+		if err != nil {
+			t.Fatalf("Lock returned error: %v", err)
+		}
+		return resp
+	}
+	unlock := func(handle []byte, client string, offset, length uint64) {
+		resp, err := server.Unlock(ctx, &api.UnlockRequest{
+			FileHandle: handle, ClientId: client, Owner: 1, Offset: offset, Length: length,
+		})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("Unlock = %v, %v", resp, err)
+		}
+	}
+
+	// Shared locks coexist; an exclusive one conflicts with them
+	if resp := lock(ctx, handleA, "one", 0, 10, false, false); resp.Status != api.Status_OK || resp.LeaseSeconds != 90 {
+		t.Fatalf("Shared Lock = %v", resp)
+	}
+	if resp := lock(ctx, handleA, "two", 5, 10, false, false); resp.Status != api.Status_OK {
+		t.Fatalf("Second shared Lock = %v", resp)
+	}
+	resp := lock(ctx, handleA, "three", 8, 0, true, false)
+	if resp.Status != api.Status_ERR_DENIED || resp.Holder == nil {
+		t.Fatalf("Conflicting exclusive Lock = %v, want ERR_DENIED", resp)
+	}
+	if resp := lock(ctx, handleA, "three", 20, 0, true, false); resp.Status != api.Status_OK {
+		t.Errorf("Exclusive Lock past the shared ones = %v", resp)
+	}
+
+	// Unlocking part of a range leaves the rest locked
+	unlock(handleA, "one", 0, 4)
+	test, err := server.TestLock(ctx, &api.TestLockRequest{FileHandle: handleA, ClientId: "four", Offset: 0, Length: 4, Exclusive: true})
+	if err != nil || test.Holder != nil {
+		t.Errorf("TestLock of an unlocked range = %v, %v; want no holder", test, err)
+	}
+	test, err = server.TestLock(ctx, &api.TestLockRequest{FileHandle: handleA, ClientId: "four", Offset: 4, Length: 1, Exclusive: true})
+	if err != nil || test.Holder == nil || test.Holder.ClientId != "one" || test.Holder.Offset != 4 || test.Holder.Length != 6 {
+		t.Errorf("TestLock of the rest = %v, %v; want one's lock on 4..10", test, err)
+	}
+	unlock(handleA, "one", 0, 0)
+	unlock(handleA, "two", 0, 0)
+	unlock(handleA, "three", 0, 0)
+
+	// A blocking Lock waits until the conflicting lock is released
+	if resp := lock(ctx, handleA, "one", 0, 0, true, false); resp.Status != api.Status_OK {
+		t.Fatalf("Exclusive Lock = %v", resp)
+	}
+	if resp := lock(ctx, handleB, "two", 0, 0, true, false); resp.Status != api.Status_OK {
+		t.Fatalf("Exclusive Lock = %v", resp)
+	}
+	granted := make(chan *api.LockResponse)
+	go func() {
+		resp, err := server.Lock(ctx, &api.LockRequest{FileHandle: handleA, ClientId: "two", Owner: 1, Exclusive: true, Block: true})
+		if err != nil {
+			t.Errorf("Blocking Lock returned error: %v", err)
+		}
+		granted <- resp
+	}()
+	select {
+	case resp := <-granted:
+		t.Fatalf("Blocking Lock returned %v while the file was locked", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Meanwhile one waiting for a lock two holds would deadlock
+	if resp := lock(ctx, handleB, "one", 0, 0, true, true); resp.Status != api.Status_ERR_DEADLOCK {
+		t.Errorf("Lock closing a cycle = %v, want ERR_DEADLOCK", resp)
+	}
+
+	unlock(handleA, "one", 0, 0)
+	select {
+	case resp := <-granted:
+		if resp.Status != api.Status_OK {
+			t.Errorf("Blocking Lock = %v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Blocking Lock still waiting after the lock was released")
+	}
+
+	// A waiter that gives up is no longer waiting
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := server.Lock(waitCtx, &api.LockRequest{FileHandle: handleB, ClientId: "one", Owner: 1, Block: true}); err == nil {
+		t.Error("Lock returned no error once its deadline passed")
+	}
+	if renew, err := server.RenewLocks(ctx, &api.RenewLocksRequest{ClientId: "one"}); err != nil || renew.Locks != 0 {
+		t.Errorf("RenewLocks = %v, %v; want no locks left for one", renew, err)
+	}
+
+	// Locks of a client whose lease ran out are released
+	server.locks.mu.Lock()
+	server.locks.now = func() time.Time { return time.Now().Add(time.Hour) }
+	server.locks.mu.Unlock()
+	server.locks.expire()
+	if resp := lock(ctx, handleA, "three", 0, 0, true, false); resp.Status != api.Status_OK {
+		t.Errorf("Lock after the holder's lease expired = %v", resp)
+	}
+}
+
+func TestLockGracePeriod(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "a"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	stateDir := t.TempDir()
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(stateDir, "handle.key")
+	config.LockClientsFile = filepath.Join(stateDir, "locks.json")
+	restart := func() *NFSServer {
+		server, err := NewNFSServer(config, lfs)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return server
+	}
+
+	ctx := context.Background()
+	before := restart()
+	handle, err := before.issueHandle(ctx, "/a")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	lock := func(server *NFSServer, client string, offset uint64, reclaim bool) api.Status {
+		resp, err := server.Lock(ctx, &api.LockRequest{
+			FileHandle: handle, ClientId: client, Owner: 1,
+			Offset: offset, Length: 10, Exclusive: true, Reclaim: reclaim,
+		})
+		if err != nil {
+			t.Fatalf("Lock returned error: %v", err)
+		}
+		return resp.Status
+	}
+	if status := lock(before, "one", 0, false); status != api.Status_OK {
+		t.Fatalf("Lock = %v", status)
+	}
+
+	// After a restart only the clients that held locks may take any, and
+	// only by reclaiming them
+	server := restart()
+	if status := lock(server, "two", 20, false); status != api.Status_ERR_GRACE {
+		t.Errorf("New Lock during the grace period = %v, want ERR_GRACE", status)
+	}
+	if status := lock(server, "two", 20, true); status != api.Status_ERR_NO_GRACE {
+		t.Errorf("Reclaim by a client that held no locks = %v, want ERR_NO_GRACE", status)
+	}
+	test, err := server.TestLock(ctx, &api.TestLockRequest{FileHandle: handle, ClientId: "two", Exclusive: true})
+	if err != nil || test.Status != api.Status_ERR_GRACE {
+		t.Errorf("TestLock during the grace period = %v, %v; want ERR_GRACE", test, err)
+	}
+
+	reg, err := server.RegisterClient(ctx, &api.RegisterClientRequest{ClientId: "one"})
+	if err != nil || reg.Status != api.Status_OK || !reg.Reclaim || reg.GraceSeconds != 90 || reg.ServerBoot == before.locks.boot {
+		t.Fatalf("RegisterClient = %v, %v; want a reclaim in a new instance's grace period", reg, err)
+	}
+	if status := lock(server, "one", 0, true); status != api.Status_OK {
+		t.Fatalf("Reclaim = %v", status)
+	}
+	if _, err := server.ReclaimComplete(ctx, &api.ReclaimCompleteRequest{ClientId: "one"}); err != nil {
+		t.Fatalf("ReclaimComplete returned error: %v", err)
+	}
+
+	// Once every client reclaimed its locks, the grace period is over
+	if status := lock(server, "two", 5, false); status != api.Status_ERR_DENIED {
+		t.Errorf("Lock over a reclaimed lock = %v, want ERR_DENIED", status)
+	}
+	if status := lock(server, "two", 20, false); status != api.Status_OK {
+		t.Errorf("Lock after the grace period = %v", status)
+	}
+	if status := lock(server, "one", 40, true); status != api.Status_ERR_NO_GRACE {
+		t.Errorf("Reclaim after the grace period = %v, want ERR_NO_GRACE", status)
+	}
+
+	// Clients that never come back lose their locks when it runs out
+	server = restart()
+	server.locks.mu.Lock()
+	server.locks.now = func() time.Time { return time.Now().Add(time.Hour) }
+	server.locks.mu.Unlock()
+	if status := lock(server, "three", 0, false); status != api.Status_OK {
+		t.Errorf("Lock once the grace period ran out = %v", status)
+	}
+}
@@ -48,7 +48,7 @@ func TestLookup(t *testing.T) {
 	}
 
 	// Get directory handle
-	dirHandle, err := fs.PathToFileHandle("/testdir")
+	dirHandle, err := server.issueHandle(context.Background(), "/testdir")
 	if err != nil {
 		t.Fatalf("Failed to get directory handle: %v", err)
 	}
@@ -173,7 +173,7 @@ func TestLookupHierarchy(t *testing.T) {
     }
 
     // Get root handle
-    rootHandle, err := fs.PathToFileHandle("/")
+    rootHandle, err := server.issueHandle(context.Background(), "/")
     if err != nil {
         t.Fatalf("Failed to get root handle: %v", err)
     }
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// serverMetrics counts the requests an export served, for the Prometheus
+// endpoint
+type serverMetrics struct {
+	mu  sync.Mutex
+	ops map[string]*opMetrics
+
+	// Requests being served
+	inFlight atomic.Int64
+
+	// Bytes returned by reads and accepted by writes
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+
+	// Requests whose handler or backend panicked
+	panics atomic.Uint64
+}
+
+// opMetrics are the counts of one operation
+type opMetrics struct {
+	count uint64
+
+	// Requests answered with each status other than OK
+	errors map[api.Status]uint64
+
+	// Requests per latency bucket, the last for those above every bound
+	buckets []uint64
+	sum     float64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{ops: make(map[string]*opMetrics)}
+}
+
+// observe records a request for op answered with status after duration
+func (m *serverMetrics) observe(op string, status api.Status, duration time.Duration) {
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.ops[op]
+	if !ok {
+		o = &opMetrics{errors: make(map[api.Status]uint64), buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.ops[op] = o
+	}
+	o.count++
+	o.buckets[bucket]++
+	o.sum += seconds
+	if status != api.Status_OK {
+		o.errors[status]++
+	}
+}
+
+// transferred records n bytes read or written
+func (m *serverMetrics) transferred(n int, write bool) {
+	if write {
+		m.bytesWritten.Add(uint64(n))
+	} else {
+		m.bytesRead.Add(uint64(n))
+	}
+}
+
+// MetricsHandler serves the metrics of every export in the Prometheus text
+// format, for programs embedding the server that run their own HTTP server
+func (s *NFSServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+}
+
+// startMetrics serves MetricsHandler at /metrics on the configured metrics
+// address
+func (s *NFSServer) startMetrics() error {
+	lis, err := net.Listen("tcp", s.config.MetricsListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on metrics address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	go func() {
+		slog.Info("Metrics available", "url", fmt.Sprintf("http://%s/metrics", lis.Addr()))
+		if err := http.Serve(lis, mux); err != nil {
+			slog.Error("Metrics service stopped", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// writeMetrics writes the metrics of every export to w
+func (s *NFSServer) writeMetrics(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	family := func(name string, kind string, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	exports := s.exports.list
+
+	family("nfs_requests_total", "counter", "Requests served, by operation.")
+	for _, e := range exports {
+		e.metrics.eachOp(func(op string, o *opMetrics) {
+			fmt.Fprintf(bw, "nfs_requests_total{export=%q,op=%q} %d\n", e.config.ExportName, op, o.count)
+		})
+	}
+
+	family("nfs_request_errors_total", "counter", "Requests answered with a status other than OK, by operation and status.")
+	for _, e := range exports {
+		e.metrics.eachOp(func(op string, o *opMetrics) {
+			statuses := make([]api.Status, 0, len(o.errors))
+			for status := range o.errors {
+				statuses = append(statuses, status)
+			}
+			sort.Slice(statuses, func(i, j int) bool { return statuses[i] < statuses[j] })
+			for _, status := range statuses {
+				fmt.Fprintf(bw, "nfs_request_errors_total{export=%q,op=%q,status=%q} %d\n",
+					e.config.ExportName, op, status.String(), o.errors[status])
+			}
+		})
+	}
+
+	family("nfs_request_duration_seconds", "histogram", "Time from a request's arrival to its response, by operation.")
+	for _, e := range exports {
+		e.metrics.eachOp(func(op string, o *opMetrics) {
+			labels := fmt.Sprintf("export=%q,op=%q", e.config.ExportName, op)
+			var cumulative uint64
+			for i, bound := range latencyBuckets {
+				cumulative += o.buckets[i]
+				fmt.Fprintf(bw, "nfs_request_duration_seconds_bucket{%s,le=%q} %d\n",
+					labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(bw, "nfs_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, o.count)
+			fmt.Fprintf(bw, "nfs_request_duration_seconds_sum{%s} %g\n", labels, o.sum)
+			fmt.Fprintf(bw, "nfs_request_duration_seconds_count{%s} %d\n", labels, o.count)
+		})
+	}
+
+	family("nfs_requests_in_flight", "gauge", "Requests being served.")
+	for _, e := range exports {
+		fmt.Fprintf(bw, "nfs_requests_in_flight{export=%q} %d\n", e.config.ExportName, e.metrics.inFlight.Load())
+	}
+
+	family("nfs_read_bytes_total", "counter", "Bytes returned by reads.")
+	for _, e := range exports {
+		fmt.Fprintf(bw, "nfs_read_bytes_total{export=%q} %d\n", e.config.ExportName, e.metrics.bytesRead.Load())
+	}
+
+	family("nfs_written_bytes_total", "counter", "Bytes accepted by writes.")
+	for _, e := range exports {
+		fmt.Fprintf(bw, "nfs_written_bytes_total{export=%q} %d\n", e.config.ExportName, e.metrics.bytesWritten.Load())
+	}
+
+	family("nfs_panics_total", "counter", "Requests whose handler or backend panicked, answered with ERR_SERVERFAULT.")
+	for _, e := range exports {
+		fmt.Fprintf(bw, "nfs_panics_total{export=%q} %d\n", e.config.ExportName, e.metrics.panics.Load())
+	}
+
+	// Only exports whose file system enforces quotas report them
+	quotas := make(map[*NFSServer]fs.QuotaUsage)
+	for _, e := range exports {
+		if reporter, ok := fs.As[fs.ExportQuotaReporter](e.fileSystem); ok {
+			if usage, err := reporter.ExportQuota(context.Background()); err == nil {
+				quotas[e] = usage
+			}
+		}
+	}
+	if len(quotas) > 0 {
+		quotaFamily := func(name string, help string, value func(fs.QuotaUsage) uint64) {
+			family(name, "gauge", help)
+			for _, e := range exports {
+				if usage, ok := quotas[e]; ok {
+					fmt.Fprintf(bw, "%s{export=%q} %d\n", name, e.config.ExportName, value(usage))
+				}
+			}
+		}
+		quotaFamily("nfs_quota_used_bytes", "Bytes in regular files charged against the quota.", func(u fs.QuotaUsage) uint64 { return u.BytesUsed })
+		quotaFamily("nfs_quota_limit_bytes", "Bytes the export may hold (-quota-bytes, 0 = unlimited).", func(u fs.QuotaUsage) uint64 { return u.BytesLimit })
+		quotaFamily("nfs_quota_used_files", "Files charged against the quota.", func(u fs.QuotaUsage) uint64 { return u.FilesUsed })
+		quotaFamily("nfs_quota_limit_files", "Files the export may hold (-quota-files, 0 = unlimited).", func(u fs.QuotaUsage) uint64 { return u.FilesLimit })
+	}
+
+	// The worker pool is shared by every export
+	family("nfs_workers", "gauge", "Requests that may be served at once (-max-concurrent).")
+	fmt.Fprintf(bw, "nfs_workers %d\n", cap(s.workerPool))
+	family("nfs_workers_busy", "gauge", "Workers serving a request.")
+	fmt.Fprintf(bw, "nfs_workers_busy %d\n", len(s.workerPool))
+	family("nfs_requests_queued", "gauge", "Requests waiting for a worker.")
+	fmt.Fprintf(bw, "nfs_requests_queued %d\n", s.backpressure.queued.Load())
+}
+
+// eachOp calls fn with every operation seen, in name order, holding the
+// lock
+func (m *serverMetrics) eachOp(fn func(op string, o *opMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := make([]string, 0, len(m.ops))
+	for op := range m.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fn(op, m.ops[op])
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestMetrics(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), nil, 0666); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.ExportName = "data"
+	server, err := NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handle, err := server.issueHandle(context.Background(), "/file.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	creds := &api.Credentials{Uid: 0, Gid: 0, Groups: []uint32{0}}
+
+	ctx := context.Background()
+	if _, err := server.Write(ctx, &api.WriteRequest{FileHandle: handle, Credentials: creds, Data: []byte("hello"), Stability: 2}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := server.Read(ctx, &api.ReadRequest{FileHandle: handle, Credentials: creds, Count: 3}); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	resp, err := server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: rootHandle, Name: "missing", Credentials: creds})
+	if err != nil || resp.Status != api.Status_ERR_NOENT {
+		t.Fatalf("Lookup of a missing file = %v, %v, want ERR_NOENT", resp.GetStatus(), err)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, want := range []string{
+		`nfs_requests_total{export="data",op="Write"} 1`,
+		`nfs_requests_total{export="data",op="Lookup"} 1`,
+		`nfs_request_errors_total{export="data",op="Lookup",status="ERR_NOENT"} 1`,
+		`nfs_request_duration_seconds_bucket{export="data",op="Read",le="+Inf"} 1`,
+		`nfs_request_duration_seconds_count{export="data",op="Read"} 1`,
+		`nfs_read_bytes_total{export="data"} 3`,
+		`nfs_written_bytes_total{export="data"} 5`,
+		`nfs_requests_in_flight{export="data"} 0`,
+		`nfs_workers_busy 0`,
+		"# TYPE nfs_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Metrics lack %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `op="Write",status=`) {
+		t.Errorf("Successful writes counted as errors:\n%s", body)
+	}
+}
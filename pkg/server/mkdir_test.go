@@ -33,7 +33,7 @@ func TestMkdir(t *testing.T) {
     }
 
     // Get root directory handle
-    rootHandle, err := fs.PathToFileHandle("/")
+    rootHandle, err := server.issueHandle(context.Background(), "/")
     if err != nil {
         t.Fatalf("Failed to get root directory handle: %v", err)
     }
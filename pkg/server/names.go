@@ -0,0 +1,45 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// validateName checks that name is a single directory entry within the
+// configured length limit
+func (s *NFSServer) validateName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return fs.ErrInvalidName
+	}
+	if s.config.MaxNameLength > 0 && len(name) > s.config.MaxNameLength {
+		return fs.ErrNameTooLong
+	}
+	return nil
+}
+
+// validateNewPath checks that an entry called name may be created in dir,
+// applying the path length and depth limits on top of validateName
+func (s *NFSServer) validateNewPath(dir string, name string) error {
+	if err := s.validateName(name); err != nil {
+		return err
+	}
+
+	path := filepath.Join("/", dir, name)
+	if s.config.MaxPathLength > 0 && len(path) > s.config.MaxPathLength {
+		return fs.ErrNameTooLong
+	}
+	if s.config.MaxPathDepth > 0 && pathDepth(path) > s.config.MaxPathDepth {
+		return fs.ErrNameTooLong
+	}
+	return nil
+}
+
+// pathDepth returns the number of components in a clean absolute path
+func pathDepth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"runtime/debug"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/nfs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// panicked records a panic recovered while serving op: it is logged with
+// the request's ID, client and stack, and counted. It returns the error the
+// request fails with.
+func (m *serverMetrics) panicked(ctx context.Context, op string, r interface{}) error {
+	m.panics.Add(1)
+	clientAddr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		clientAddr = p.Addr.String()
+	}
+	slog.ErrorContext(ctx, "Request panicked", "op", op, "client", clientAddr, "panic", r, "stack", string(debug.Stack()))
+	return nfs.NewNFSError(api.Status_ERR_SERVERFAULT, fmt.Sprintf("%s panicked: %v", op, r), nil)
+}
+
+// recoverInterceptor answers a request whose handler, or the backend under
+// it, panicked with ERR_SERVERFAULT, so one bad request cannot take the
+// server and every export down
+func (s *NFSServer) recoverInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			name := path.Base(info.FullMethod)
+			s.metrics.panicked(ctx, name, r)
+			s.metrics.observe(name, api.Status_ERR_SERVERFAULT, time.Since(start))
+			resp, err = statusResponse(name, api.Status_ERR_SERVERFAULT)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recoverStreamInterceptor is recoverInterceptor for streaming calls, which
+// have no status to answer with, so they fail with Internal
+func (s *NFSServer) recoverStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			name := path.Base(info.FullMethod)
+			s.metrics.panicked(ss.Context(), name, r)
+			s.metrics.observe(name, api.Status_ERR_SERVERFAULT, time.Since(start))
+			err = status.Errorf(codes.Internal, "%s: server fault", name)
+		}
+	}()
+	return handler(srv, ss)
+}
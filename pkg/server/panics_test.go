@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+)
+
+// panickingFS panics on any access to a file named "bad", like a backend
+// with a bug
+type panickingFS struct {
+	fs.FileSystem
+}
+
+func (p *panickingFS) GetAttr(ctx context.Context, path string) (fs.FileInfo, error) {
+	if filepath.Base(path) == "bad" {
+		panic("corrupt inode")
+	}
+	return p.FileSystem.GetAttr(ctx, path)
+}
+
+func (p *panickingFS) Remove(ctx context.Context, path string) error {
+	if filepath.Base(path) == "bad" {
+		panic("corrupt inode")
+	}
+	return p.FileSystem.Remove(ctx, path)
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "tree"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "tree", "bad"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.MaxConcurrent = 1
+	server, err := NewNFSServer(config, &panickingFS{localFS})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	getAttr := func(path string) *api.GetAttrResponse {
+		t.Helper()
+		handle, err := server.issueHandle(ctx, path)
+		if err != nil {
+			t.Fatalf("Failed to get handle of %s: %v", path, err)
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/GetAttr"}
+		resp, err := server.recoverInterceptor(ctx, &api.GetAttrRequest{FileHandle: handle}, info,
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return server.GetAttr(ctx, req.(*api.GetAttrRequest))
+			})
+		if err != nil {
+			t.Fatalf("GetAttr of %s returned error: %v", path, err)
+		}
+		return resp.(*api.GetAttrResponse)
+	}
+
+	// A panicking request is answered with ERR_SERVERFAULT and counted
+	if resp := getAttr("/tree/bad"); resp.Status != api.Status_ERR_SERVERFAULT {
+		t.Errorf("GetAttr of a panicking file = %v, want ERR_SERVERFAULT", resp.Status)
+	}
+	if panics := server.metrics.panics.Load(); panics != 1 {
+		t.Errorf("Panics = %d, want 1", panics)
+	}
+
+	// The only worker was released, so other requests are still served
+	if resp := getAttr("/tree"); resp.Status != api.Status_OK {
+		t.Errorf("GetAttr after the panic = %v, want OK", resp.Status)
+	}
+
+	// Deletions run in goroutines of their own; a panic in one fails the
+	// removal rather than the server
+	remover := newTreeRemover(server.fileSystem, fs.Credentials{}, 2, server.metrics)
+	failedPath, err := remover.run(ctx, "/tree")
+	if failedPath != "/tree/bad" || err == nil {
+		t.Errorf("Removal = %q, %v; want a failure at /tree/bad", failedPath, err)
+	}
+	if panics := server.metrics.panics.Load(); panics != 2 {
+		t.Errorf("Panics = %d, want 2", panics)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestNameLimits(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.MkdirAll(filepath.Join(tempDir, "a", "b"), 0755); err != nil {
+		t.Fatalf("Failed to create test directories: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server with tight limits
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxNameLength = 16
+	config.MaxPathLength = 20
+	config.MaxPathDepth = 3
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	deepHandle, err := server.issueHandle(context.Background(), "/a/b")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+
+	// PathConf reports the configured limits
+	pc, err := server.PathConf(ctx, &api.PathConfRequest{FileHandle: rootHandle, Credentials: root})
+	if err != nil {
+		t.Fatalf("PathConf failed: %v", err)
+	}
+	if pc.Status != api.Status_OK {
+		t.Fatalf("Unexpected PathConf status: %v", pc.Status)
+	}
+	if pc.NameMax != 16 || pc.PathMax != 20 || pc.MaxDepth != 3 || !pc.NoTrunc {
+		t.Errorf("Unexpected limits: %+v", pc)
+	}
+
+	longName := strings.Repeat("x", 17)
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		call       func() api.Status
+		wantStatus api.Status
+	}{
+		{"Create within limits", func() api.Status {
+			resp, _ := server.Create(ctx, &api.CreateRequest{DirectoryHandle: rootHandle, Name: "short.txt", Credentials: root})
+			return resp.Status
+		}, api.Status_OK},
+		{"Create long name", func() api.Status {
+			resp, _ := server.Create(ctx, &api.CreateRequest{DirectoryHandle: rootHandle, Name: longName, Credentials: root})
+			return resp.Status
+		}, api.Status_ERR_NAMETOOLONG},
+		{"Mkdir at depth limit", func() api.Status {
+			resp, _ := server.Mkdir(ctx, &api.MkdirRequest{DirectoryHandle: deepHandle, Name: "c", Credentials: root})
+			return resp.Status
+		}, api.Status_OK},
+		{"Mkdir path too long", func() api.Status {
+			resp, _ := server.Mkdir(ctx, &api.MkdirRequest{DirectoryHandle: deepHandle, Name: "0123456789abcdef", Credentials: root})
+			return resp.Status
+		}, api.Status_ERR_NAMETOOLONG},
+		{"Lookup long name", func() api.Status {
+			resp, _ := server.Lookup(ctx, &api.LookupRequest{DirectoryHandle: rootHandle, Name: longName, Credentials: root})
+			return resp.Status
+		}, api.Status_ERR_NAMETOOLONG},
+		{"Rename to long name", func() api.Status {
+			resp, _ := server.Rename(ctx, &api.RenameRequest{
+				FromDirectoryHandle: rootHandle, FromName: "file.txt",
+				ToDirectoryHandle: rootHandle, ToName: longName, Credentials: root,
+			})
+			return resp.Status
+		}, api.Status_ERR_NAMETOOLONG},
+		{"PathConf invalid handle", func() api.Status {
+			resp, _ := server.PathConf(ctx, &api.PathConfRequest{FileHandle: []byte{1, 2, 3}, Credentials: root})
+			return resp.Status
+		}, api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status := tc.call(); status != tc.wantStatus {
+				t.Errorf("Unexpected status: got %v, want %v", status, tc.wantStatus)
+			}
+		})
+	}
+
+	// Depth is checked separately from length
+	cHandle, err := server.issueHandle(context.Background(), "/a/b/c")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	resp, err := server.Mkdir(ctx, &api.MkdirRequest{DirectoryHandle: cHandle, Name: "d", Credentials: root})
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_NAMETOOLONG {
+		t.Errorf("Unexpected status for nesting beyond the depth limit: %v", resp.Status)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// exportPolicy is the part of the configuration deciding whom a request acts
+// as. It starts out from Config and can be replaced at runtime through the
+// admin service; each replacement gets a new epoch.
+type exportPolicy struct {
+	epoch uint64
+
+	rootSquash     bool
+	anonUID        uint32
+	anonGID        uint32
+	allowAnonymous bool
+}
+
+// fingerprint identifies the rules of the policy, whatever its epoch
+func (p *exportPolicy) fingerprint() string {
+	return fmt.Sprintf("root_squash=%t anon=%d:%d allow_anonymous=%t", p.rootSquash, p.anonUID, p.anonGID, p.allowAnonymous)
+}
+
+// newExportPolicy takes the initial policy from config
+func newExportPolicy(config *Config) *exportPolicy {
+	return &exportPolicy{
+		epoch:          1,
+		rootSquash:     config.EnableRootSquash,
+		anonUID:        config.AnonUID,
+		anonGID:        config.AnonGID,
+		allowAnonymous: config.AllowAnonymous,
+	}
+}
+
+// squash maps root to the anonymous identity if root squashing is enabled
+func (p *exportPolicy) squash(creds *fs.Credentials) {
+	if p.rootSquash && creds.UID == 0 {
+		creds.UID = p.anonUID
+		creds.GID = p.anonGID
+	}
+}
+
+// policy returns the export policy in effect. Handlers take it once, so a
+// request is judged by a single policy from start to finish.
+func (s *NFSServer) policy() *exportPolicy {
+	return s.currentPolicy.Load()
+}
+
+// setPolicy replaces the export policy and drops every cached response.
+// Those were computed under the old policy and replaying them could hand
+// results to callers it no longer authorizes. It returns the new epoch and
+// the number of responses dropped.
+func (s *NFSServer) setPolicy(p exportPolicy) (uint64, int) {
+	s.dupCache.mu.Lock()
+	defer s.dupCache.mu.Unlock()
+
+	// Responses are only cached under the current epoch (see cacheResponse),
+	// so holding the cache lock keeps requests that started under the old
+	// policy from caching anything once it is replaced
+	p.epoch = s.policy().epoch + 1
+	s.currentPolicy.Store(&p)
+
+	scrubbed := s.dupCache.clearLocked(&p)
+
+	slog.Info("Export policy changed", "epoch", p.epoch, "root_squash", p.rootSquash,
+		"anon_uid", p.anonUID, "anon_gid", p.anonGID, "allow_anonymous", p.allowAnonymous, "cached_responses_dropped", scrubbed)
+	return p.epoch, scrubbed
+}
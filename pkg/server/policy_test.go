@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+// newPolicyTestServer serves a directory holding one root-owned file
+// writable only by its owner, with root squashing initially off
+func newPolicyTestServer(t *testing.T) (*NFSServer, []byte) {
+	t.Helper()
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "secret.txt"), []byte("0123456789"), 0600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	fileHandle, err := server.issueHandle(context.Background(), "/secret.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	return server, fileHandle
+}
+
+func TestSetPolicyScrubsRequestCache(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Requires running as root so that squashing changes access")
+	}
+	server, fileHandle := newPolicyTestServer(t)
+	write := func() api.Status {
+		resp, err := server.Write(context.Background(), &api.WriteRequest{
+			FileHandle:  fileHandle,
+			Credentials: &api.Credentials{Uid: 0, Gid: 0},
+			Data:        []byte("abc"),
+		})
+		if err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		return resp.Status
+	}
+
+	if status := write(); status != api.Status_OK {
+		t.Fatalf("Write as root = %v, want OK", status)
+	}
+	if n := server.dupCache.len(); n != 1 {
+		t.Fatalf("Request cache holds %d entries, want 1", n)
+	}
+
+	// Once root is squashed the replayed write must be judged afresh
+	policy := *server.policy()
+	policy.rootSquash = true
+	epoch, scrubbed := server.setPolicy(policy)
+	if epoch != 2 || scrubbed != 1 {
+		t.Errorf("setPolicy = epoch %d, %d scrubbed; want epoch 2, 1 scrubbed", epoch, scrubbed)
+	}
+	if status := write(); status != api.Status_ERR_ACCES {
+		t.Errorf("Replayed write after squashing root = %v, want ERR_ACCES", status)
+	}
+}
+
+func TestSetPolicyWhileBusy(t *testing.T) {
+	server, fileHandle := newPolicyTestServer(t)
+
+	// Writers keep filling the request cache while the policy is replaced
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				server.Write(context.Background(), &api.WriteRequest{
+					FileHandle:  fileHandle,
+					Credentials: &api.Credentials{Uid: 0, Gid: 0},
+					Offset:      uint64(w),
+					Data:        []byte(fmt.Sprintf("%d", i%10)),
+				})
+			}
+		}(w)
+	}
+
+	var epoch uint64
+	for i := 0; i < 50; i++ {
+		epoch, _ = server.setPolicy(*server.policy())
+	}
+
+	// A request judged under one policy that completes after the next
+	// change is not cached
+	started := server.policy().epoch
+	epoch, _ = server.setPolicy(*server.policy())
+	server.cacheResponse(started, fmt.Sprintf("write-%d-late", started), &api.WriteResponse{}, time.Minute, false)
+
+	close(stop)
+	wg.Wait()
+
+	// Writes that started under an old policy and finished after the last
+	// change must not have left responses behind
+	server.dupCache.mu.Lock()
+	defer server.dupCache.mu.Unlock()
+	for key, elem := range server.dupCache.entries {
+		if entry := elem.Value.(*dupEntry); entry.epoch != epoch {
+			t.Errorf("Cached response %q of epoch %d survived a change to epoch %d", key, entry.epoch, epoch)
+		}
+	}
+}
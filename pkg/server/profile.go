@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime/trace"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+const (
+	// defaultTraceDuration is used when CaptureTrace asks for no duration
+	defaultTraceDuration = 5 * time.Second
+
+	// maxTraceDuration bounds how long a trace may run; tracing slows the
+	// server down noticeably
+	maxTraceDuration = 60 * time.Second
+
+	// traceChunkSize is the largest piece of trace sent in one message
+	traceChunkSize = 64 * 1024
+)
+
+// errTraceActive is returned when an execution trace is already running,
+// either from CaptureTrace or the pprof endpoint
+var errTraceActive = errors.New("an execution trace is already running")
+
+// profilingHandler serves the net/http/pprof endpoints under /debug/pprof/.
+// A private mux is used so nothing else registered on the default mux is
+// exposed.
+func profilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startProfiling serves the pprof endpoints on the configured profiling
+// address. Like the admin service it must only be reachable by operators.
+func (s *NFSServer) startProfiling() error {
+	lis, err := net.Listen("tcp", s.config.ProfileListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on profiling address: %w", err)
+	}
+
+	go func() {
+		slog.Info("Profiling endpoints available", "url", fmt.Sprintf("http://%s/debug/pprof/", lis.Addr()))
+		if err := http.Serve(lis, profilingHandler()); err != nil {
+			slog.Error("Profiling service stopped", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// captureTrace records an execution trace to w for duration, or until ctx
+// is canceled
+func captureTrace(ctx context.Context, duration time.Duration, w io.Writer) error {
+	if duration <= 0 {
+		duration = defaultTraceDuration
+	}
+	if duration > maxTraceDuration {
+		duration = maxTraceDuration
+	}
+
+	if err := trace.Start(w); err != nil {
+		return errTraceActive
+	}
+	defer trace.Stop()
+
+	slog.InfoContext(ctx, "Execution trace started", "duration", duration)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	slog.InfoContext(ctx, "Execution trace finished")
+	return nil
+}
+
+// traceSender sends everything written to it as TraceChunk messages
+type traceSender struct {
+	stream api.AdminService_CaptureTraceServer
+}
+
+func (t *traceSender) Write(p []byte) (int, error) {
+	if err := t.stream.Send(&api.TraceChunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
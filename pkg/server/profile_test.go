@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+func TestProfilingHandler(t *testing.T) {
+	handler := profilingHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("goroutine profile: got status %d", rec.Code)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("goroutine")) {
+		t.Errorf("goroutine profile looks wrong: %.100s", rec.Body.String())
+	}
+
+	// Only the profiling endpoints are served
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Root path: got status %d, want 404", rec.Code)
+	}
+}
+
+func TestCaptureTrace(t *testing.T) {
+	var buf bytes.Buffer
+	if err := captureTrace(context.Background(), 50*time.Millisecond, &buf); err != nil {
+		t.Fatalf("captureTrace failed: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("go 1.")) {
+		t.Errorf("Trace does not start with the trace header: %q", buf.Bytes()[:min(buf.Len(), 16)])
+	}
+
+	// Only one trace can run at a time; cancellation ends it early
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- captureTrace(ctx, time.Minute, &bytes.Buffer{})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !trace.IsEnabled() {
+		if time.Now().After(deadline) {
+			t.Fatal("Trace did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := captureTrace(context.Background(), time.Millisecond, &bytes.Buffer{}); !errors.Is(err, errTraceActive) {
+		t.Errorf("Concurrent trace: got %v, want errTraceActive", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Canceled trace failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Trace did not stop when its context was canceled")
+	}
+}
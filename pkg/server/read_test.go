@@ -40,7 +40,7 @@ func TestRead(t *testing.T) {
     }
 
     // Get file handle
-    fileHandle, err := fs.PathToFileHandle("/testfile.txt")
+    fileHandle, err := server.issueHandle(context.Background(), "/testfile.txt")
     if err != nil {
         t.Fatalf("Failed to get file handle: %v", err)
     }
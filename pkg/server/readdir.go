@@ -0,0 +1,26 @@
+package server
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// readDirBudget returns the most bytes a ReadDir or ReadDirPlus response
+// may take: what the client asked for, capped at MaxReadSize so a page of
+// long names cannot outgrow the message size limit
+func (s *NFSServer) readDirBudget(maxcount uint32) int {
+	limit := s.config.MaxReadSize
+	if limit <= 0 {
+		limit = DefaultConfig().MaxReadSize
+	}
+	if maxcount > 0 && int(maxcount) < limit {
+		return int(maxcount)
+	}
+	return limit
+}
+
+// entrySize returns the bytes entry adds to a listing response as one
+// element of its repeated entries field
+func entrySize(entry proto.Message) int {
+	return protowire.SizeTag(3) + protowire.SizeBytes(proto.Size(entry))
+}
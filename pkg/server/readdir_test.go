@@ -2,12 +2,16 @@ package server
 
 import (
     "context"
+    "fmt"
     "os"
     "path/filepath"
+    "strings"
     "testing"
 
     "github.com/example/nfsserver/pkg/api"
+    "github.com/example/nfsserver/pkg/fs"
     "github.com/example/nfsserver/pkg/fs/local"
+    "google.golang.org/protobuf/proto"
 )
 
 func TestReadDir(t *testing.T) {
@@ -55,7 +59,7 @@ func TestReadDir(t *testing.T) {
     }
 
     // Get directory handle
-    dirHandle, err := fs.PathToFileHandle("/testdir")
+    dirHandle, err := server.issueHandle(context.Background(), "/testdir")
     if err != nil {
         t.Fatalf("Failed to get directory handle: %v", err)
     }
@@ -163,7 +167,7 @@ func TestReadDir(t *testing.T) {
     }
 
     // Test reading a file (not a directory)
-    fileHandle, err := fs.PathToFileHandle("/testdir/file1.txt")
+    fileHandle, err := server.issueHandle(context.Background(), "/testdir/file1.txt")
     if err != nil {
         t.Fatalf("Failed to get file handle: %v", err)
     }
@@ -184,4 +188,287 @@ func TestReadDir(t *testing.T) {
     if fileResp.Status != api.Status_ERR_NOTDIR {
         t.Errorf("Expected ERR_NOTDIR for file, got: %v", fileResp.Status)
     }
-}
\ No newline at end of file
+}
+// longNameDir creates n files with names of the longest length allowed in
+// dir, and returns a server exporting it with MaxReadSize set to maxSize
+func longNameDir(t *testing.T, n int, maxSize int) (*NFSServer, []byte, map[string]bool) {
+    t.Helper()
+    tempDir := t.TempDir()
+    names := make(map[string]bool, n)
+    for i := 0; i < n; i++ {
+        name := fmt.Sprintf("%04d%s", i, strings.Repeat("x", 251))
+        if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+            t.Fatalf("Failed to create test file: %v", err)
+        }
+        names[name] = true
+    }
+    
+    fs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    config.MaxReadSize = maxSize
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+    rootHandle, err := server.issueHandle(context.Background(), "/")
+    if err != nil {
+        t.Fatalf("Failed to get root handle: %v", err)
+    }
+    return server, rootHandle, names
+}
+
+func TestReadDirByteBudget(t *testing.T) {
+    server, rootHandle, names := longNameDir(t, 100, 8192)
+    
+    tests := []struct {
+        name     string
+        maxcount uint32
+        wantMax  int
+    }{
+        {"Server limit", 0, 8192},
+        {"Client limit", 2048, 2048},
+        {"Client limit above server limit", 1 << 20, 8192},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            // Ask for every entry at once; the budget must split the listing
+            req := &api.ReadDirRequest{
+                DirectoryHandle: rootHandle,
+                Credentials:     &api.Credentials{Uid: 0, Gid: 0},
+                Count:           10000,
+                Maxcount:        tt.maxcount,
+            }
+            seen := make(map[string]bool)
+            pages := 0
+            for {
+                pages++
+                if pages > 100 {
+                    t.Fatalf("ReadDir did not reach the end of the directory")
+                }
+                resp, err := server.ReadDir(context.Background(), req)
+                if err != nil || resp.Status != api.Status_OK {
+                    t.Fatalf("ReadDir returned %v, %v", resp.GetStatus(), err)
+                }
+                if size := proto.Size(resp); size > tt.wantMax {
+                    t.Fatalf("Response of %d bytes exceeds the budget of %d", size, tt.wantMax)
+                }
+                for _, entry := range resp.Entries {
+                    if seen[entry.Name] {
+                        t.Errorf("Entry %.8s... returned twice", entry.Name)
+                    }
+                    seen[entry.Name] = true
+                }
+                if resp.Eof {
+                    break
+                }
+                req.Cookie = resp.Entries[len(resp.Entries)-1].Cookie
+            }
+            
+            if pages < 2 {
+                t.Errorf("Listing of %d long names fit one page", len(names))
+            }
+            for name := range names {
+                if !seen[name] {
+                    t.Errorf("Entry %.8s... missing", name)
+                }
+            }
+        })
+    }
+    
+    // Past "." and "..", not even one entry fits
+    resp, err := server.ReadDir(context.Background(), &api.ReadDirRequest{
+        DirectoryHandle: rootHandle,
+        Credentials:     &api.Credentials{Uid: 0, Gid: 0},
+        Count:           2,
+    })
+    if err != nil || resp.Status != api.Status_OK || len(resp.Entries) != 2 {
+        t.Fatalf("ReadDir of two entries returned %v, %v", resp.GetStatus(), err)
+    }
+    resp, err = server.ReadDir(context.Background(), &api.ReadDirRequest{
+        DirectoryHandle: rootHandle,
+        Credentials:     &api.Credentials{Uid: 0, Gid: 0},
+        Cookie:          resp.Entries[1].Cookie,
+        Maxcount:        100,
+    })
+    if err != nil || resp.Status != api.Status_ERR_TOOSMALL {
+        t.Errorf("ReadDir with a 100 byte budget returned %v, %v; want ERR_TOOSMALL", resp.GetStatus(), err)
+    }
+}
+
+func TestReadDirSorted(t *testing.T) {
+    tempDir := t.TempDir()
+    for _, name := range []string{"b", "d", "f", "h"} {
+        if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+            t.Fatalf("Failed to create test file: %v", err)
+        }
+    }
+    lfs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    config.MaxDirSnapshots = 1
+    server, err := NewNFSServer(config, lfs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+    ctx := context.Background()
+    rootHandle, err := server.issueHandle(ctx, "/")
+    if err != nil {
+        t.Fatalf("Failed to get root handle: %v", err)
+    }
+    creds := &api.Credentials{Uid: 0, Gid: 0}
+    readDir := func(cookie, verifier uint64) *api.ReadDirResponse {
+        t.Helper()
+        resp, err := server.ReadDir(ctx, &api.ReadDirRequest{
+            DirectoryHandle: rootHandle, Credentials: creds,
+            Cookie: cookie, CookieVerifier: verifier, Count: 3, Sorted: true,
+        })
+        if err != nil {
+            t.Fatalf("ReadDir failed: %v", err)
+        }
+        return resp
+    }
+    names := func(entries []*api.DirEntry) []string {
+        var names []string
+        for _, entry := range entries {
+            names = append(names, entry.Name)
+        }
+        return names
+    }
+
+    // Pages continue the listing as it was when it started, however the
+    // directory changes in between
+    first := readDir(0, 0)
+    if first.Status != api.Status_OK || first.Eof || strings.Join(names(first.Entries), " ") != ". .. b" {
+        t.Fatalf("First page = %v %v, eof %v", first.Status, names(first.Entries), first.Eof)
+    }
+    for _, name := range []string{"a", "c"} {
+        if _, _, err := lfs.Create(ctx, "/", name, fs.FileAttr{}, true); err != nil {
+            t.Fatalf("Create failed: %v", err)
+        }
+    }
+    var listed []string
+    for resp := first; ; {
+        listed = append(listed, names(resp.Entries)...)
+        if resp.Eof {
+            break
+        }
+        resp = readDir(resp.Entries[len(resp.Entries)-1].Cookie, resp.CookieVerifier)
+        if resp.Status != api.Status_OK {
+            t.Fatalf("ReadDir of a later page returned %v", resp.Status)
+        }
+    }
+    if got := strings.Join(listed, " "); got != ". .. b d f h" {
+        t.Errorf("Sorted listing = %q, want the snapshot \". .. b d f h\"", got)
+    }
+
+    // A new listing sees the changes under a new verifier
+    fresh := readDir(0, 0)
+    if fresh.CookieVerifier == first.CookieVerifier || strings.Join(names(fresh.Entries), " ") != ". .. a" {
+        t.Errorf("New listing = %v under verifier %d, want . .. a under a new one", names(fresh.Entries), fresh.CookieVerifier)
+    }
+
+    // The first snapshot was evicted to make room; its directory changed,
+    // so it cannot be rebuilt
+    if resp := readDir(3, first.CookieVerifier); resp.Status != api.Status_ERR_BAD_COOKIE {
+        t.Errorf("ReadDir under an evicted verifier returned %v, want ERR_BAD_COOKIE", resp.Status)
+    }
+}
+
+func TestReadDirCookies(t *testing.T) {
+    tempDir := t.TempDir()
+    for i := 0; i < 20; i++ {
+        if err := os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("file%02d", i)), nil, 0644); err != nil {
+            t.Fatalf("Failed to create test file: %v", err)
+        }
+    }
+    lfs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    server, err := NewNFSServer(config, lfs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+    ctx := context.Background()
+    rootHandle, err := server.issueHandle(ctx, "/")
+    if err != nil {
+        t.Fatalf("Failed to get root handle: %v", err)
+    }
+    readDir := func(cookie, verifier uint64) *api.ReadDirResponse {
+        t.Helper()
+        resp, err := server.ReadDir(ctx, &api.ReadDirRequest{
+            DirectoryHandle: rootHandle, Credentials: &api.Credentials{},
+            Cookie: cookie, CookieVerifier: verifier, Count: 10,
+        })
+        if err != nil {
+            t.Fatalf("ReadDir failed: %v", err)
+        }
+        return resp
+    }
+
+    first := readDir(0, 0)
+    if first.Status != api.Status_OK || first.Eof || len(first.Entries) != 10 {
+        t.Fatalf("First page = %v with %d entries, eof %v", first.Status, len(first.Entries), first.Eof)
+    }
+    seen := make(map[string]bool)
+    for _, entry := range first.Entries {
+        seen[entry.Name] = true
+    }
+
+    // Remove an entry already listed and one still to come, and add one
+    var removed string
+    for i := 0; i < 20; i++ {
+        if name := fmt.Sprintf("file%02d", i); !seen[name] {
+            removed = name
+            break
+        }
+    }
+    for _, name := range []string{first.Entries[5].Name, removed} {
+        if err := lfs.Remove(ctx, "/"+name); err != nil {
+            t.Fatalf("Remove failed: %v", err)
+        }
+    }
+    if _, _, err := lfs.Create(ctx, "/", "added", fs.FileAttr{}, true); err != nil {
+        t.Fatalf("Create failed: %v", err)
+    }
+
+    // The verifier tells the client its listing is out of date
+    cookie := first.Entries[len(first.Entries)-1].Cookie
+    if resp := readDir(cookie, first.CookieVerifier); resp.Status != api.Status_ERR_BAD_COOKIE {
+        t.Errorf("ReadDir of a changed directory returned %v, want ERR_BAD_COOKIE", resp.Status)
+    }
+
+    // but the cookies still hold: a client continuing regardless gets
+    // every remaining entry once
+    for resp := readDir(cookie, 0); ; resp = readDir(resp.Entries[len(resp.Entries)-1].Cookie, resp.CookieVerifier) {
+        if resp.Status != api.Status_OK {
+            t.Fatalf("ReadDir of a later page returned %v", resp.Status)
+        }
+        for _, entry := range resp.Entries {
+            if seen[entry.Name] {
+                t.Errorf("Entry %s listed twice", entry.Name)
+            }
+            seen[entry.Name] = true
+        }
+        if resp.Eof {
+            break
+        }
+    }
+    for i := 0; i < 20; i++ {
+        if name := fmt.Sprintf("file%02d", i); name != removed && !seen[name] {
+            t.Errorf("Entry %s missing", name)
+        }
+    }
+    if seen[removed] {
+        t.Errorf("Removed entry %s listed", removed)
+    }
+}
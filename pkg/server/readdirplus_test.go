@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadDirPlus(t *testing.T) {
+	tempDir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		name := filepath.Join(tempDir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(name, make([]byte, i), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "private"), 0700); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	// Page through the root two entries at a time
+	seen := make(map[string]*api.DirEntryPlus)
+	req := &api.ReadDirPlusRequest{DirectoryHandle: rootHandle, Credentials: root, Count: 2}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("ReadDirPlus did not reach the end of the directory")
+		}
+		resp, err := server.ReadDirPlus(context.Background(), req)
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("ReadDirPlus returned %v, %v", resp.GetStatus(), err)
+		}
+		for _, entry := range resp.Entries {
+			seen[entry.Name] = entry
+		}
+		if resp.Eof {
+			break
+		}
+		req.Cookie = resp.Entries[len(resp.Entries)-1].Cookie
+	}
+
+	// ".", "..", five files and the directory
+	if len(seen) != 8 {
+		t.Errorf("ReadDirPlus returned %d distinct entries, want 8", len(seen))
+	}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("file%d", i)
+		entry, ok := seen[name]
+		if !ok {
+			t.Errorf("Entry %s missing", name)
+			continue
+		}
+		if entry.Attributes.GetType() != api.FileType_REGULAR || entry.Attributes.GetSize() != uint64(i) {
+			t.Errorf("Entry %s has attributes %v, want a regular file of %d bytes", name, entry.Attributes, i)
+		}
+		path, err := fs.FileHandleToPath(context.Background(), entry.FileHandle)
+		if err != nil || path != "/"+name {
+			t.Errorf("Handle of %s resolves to %q, %v", name, path, err)
+		}
+	}
+
+	// Handing out handles needs search permission as well as read
+	privateHandle, err := server.issueHandle(context.Background(), "/private")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	resp, err := server.ReadDirPlus(context.Background(), &api.ReadDirPlusRequest{
+		DirectoryHandle: privateHandle,
+		Credentials:     &api.Credentials{Uid: 12345, Gid: 12345},
+	})
+	if err != nil || resp.Status != api.Status_ERR_ACCES {
+		t.Errorf("ReadDirPlus of a private directory returned %v, %v; want ERR_ACCES", resp.GetStatus(), err)
+	}
+
+	// A file is not a directory
+	fileHandle, err := server.issueHandle(context.Background(), "/file1")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	resp, err = server.ReadDirPlus(context.Background(), &api.ReadDirPlusRequest{DirectoryHandle: fileHandle, Credentials: root})
+	if err != nil || resp.Status != api.Status_ERR_NOTDIR {
+		t.Errorf("ReadDirPlus of a file returned %v, %v; want ERR_NOTDIR", resp.GetStatus(), err)
+	}
+}
+
+func TestReadDirPlusByteBudget(t *testing.T) {
+	server, rootHandle, names := longNameDir(t, 50, 1<<20)
+
+	tests := []struct {
+		name     string
+		dircount uint32
+		maxcount uint32
+	}{
+		{"Maxcount", 0, 4096},
+		{"Dircount", 1024, 0},
+		{"Both", 2048, 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &api.ReadDirPlusRequest{
+				DirectoryHandle: rootHandle,
+				Credentials:     &api.Credentials{Uid: 0, Gid: 0},
+				Count:           10000,
+				Dircount:        tt.dircount,
+				Maxcount:        tt.maxcount,
+			}
+			seen := make(map[string]bool)
+			for pages := 0; ; pages++ {
+				if pages > 100 {
+					t.Fatalf("ReadDirPlus did not reach the end of the directory")
+				}
+				resp, err := server.ReadDirPlus(context.Background(), req)
+				if err != nil || resp.Status != api.Status_OK {
+					t.Fatalf("ReadDirPlus returned %v, %v", resp.GetStatus(), err)
+				}
+				if tt.maxcount > 0 && proto.Size(resp) > int(tt.maxcount) {
+					t.Fatalf("Response of %d bytes exceeds maxcount %d", proto.Size(resp), tt.maxcount)
+				}
+				dirSize := 0
+				for _, entry := range resp.Entries {
+					dirSize += len(entry.Name)
+					seen[entry.Name] = true
+				}
+				if tt.dircount > 0 && dirSize > int(tt.dircount) {
+					t.Fatalf("Names alone take %d bytes, more than dircount %d", dirSize, tt.dircount)
+				}
+				if resp.Eof {
+					break
+				}
+				req.Cookie = resp.Entries[len(resp.Entries)-1].Cookie
+			}
+			for name := range names {
+				if !seen[name] {
+					t.Errorf("Entry %.8s... missing", name)
+				}
+			}
+		})
+	}
+}
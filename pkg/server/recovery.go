@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// How often the handle table is written to Config.HandleDumpFile
+const handleDumpInterval = 5 * time.Minute
+
+// handleDumpFile is the on-disk form of a handle dump
+type handleDumpFile struct {
+	Export  string         `json:"export"`
+	Dumped  time.Time      `json:"dumped"`
+	Handles []dumpedHandle `json:"handles"`
+}
+
+// dumpedHandle is one file in a handle dump. Handles are base64 encoded.
+type dumpedHandle struct {
+	Path   string `json:"path"`
+	Handle []byte `json:"handle"`
+}
+
+// handleRecovery holds the handles of a dump written before the server
+// last stopped, to re-validate handles the file system no longer knows
+type handleRecovery struct {
+	// Not yet re-validated handles, as strings, to their dumped paths
+	paths sync.Map
+
+	// Serializes dumps, so an older table never replaces a newer one
+	dumpMu sync.Mutex
+}
+
+// newHandleRecovery loads the handle dump in file, if there is one
+func newHandleRecovery(file string) (*handleRecovery, error) {
+	r := &handleRecovery{}
+	if file == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handle dump: %w", err)
+	}
+
+	var dump handleDumpFile
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse handle dump %s: %w", file, err)
+	}
+	for _, h := range dump.Handles {
+		r.paths.Store(string(h.Handle), h.Path)
+	}
+	slog.Info("Loaded handle dump", "handles", len(dump.Handles), "dumped", dump.Dumped.Format(time.RFC3339), "file", file)
+	return r, nil
+}
+
+// recoverHandle resolves a dumped handle without the file system's index:
+// it is valid if the file now at its dumped path still has that handle.
+// Resolving it also indexes the file again, so each handle is only
+// re-validated once; handles whose path now holds another file are left to
+// the file system.
+func (s *NFSServer) recoverHandle(ctx context.Context, handle []byte) (string, bool) {
+	value, ok := s.recovery.paths.LoadAndDelete(string(handle))
+	if !ok {
+		return "", false
+	}
+	path := value.(string)
+	current, err := s.fileSystem.PathToFileHandle(ctx, path)
+	if err != nil || !bytes.Equal(current, handle) {
+		return "", false
+	}
+	return path, true
+}
+
+// DumpHandles writes the path and handle of every file the file system has
+// indexed to Config.HandleDumpFile, along with dumped handles not yet
+// re-validated. It does nothing without a dump file.
+func (s *NFSServer) DumpHandles(ctx context.Context) error {
+	if s.config.HandleDumpFile == "" {
+		return nil
+	}
+	lister, ok := fs.As[fs.HandleLister](s.fileSystem)
+	if !ok {
+		return fmt.Errorf("failed to dump handles: file system cannot list its handles")
+	}
+
+	s.recovery.dumpMu.Lock()
+	defer s.recovery.dumpMu.Unlock()
+
+	dump := handleDumpFile{Export: s.config.ExportName, Dumped: time.Now().UTC()}
+	seen := make(map[string]bool)
+	err := lister.ListHandles(ctx, func(path string, handle []byte) bool {
+		dump.Handles = append(dump.Handles, dumpedHandle{Path: path, Handle: handle})
+		seen[string(handle)] = true
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to dump handles: %w", err)
+	}
+	s.recovery.paths.Range(func(key, value interface{}) bool {
+		if !seen[key.(string)] {
+			dump.Handles = append(dump.Handles, dumpedHandle{Path: value.(string), Handle: []byte(key.(string))})
+		}
+		return true
+	})
+	sort.Slice(dump.Handles, func(i, j int) bool {
+		return dump.Handles[i].Path < dump.Handles[j].Path
+	})
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to dump handles: %w", err)
+	}
+	if err := writeFileAtomic(s.config.HandleDumpFile, data); err != nil {
+		return fmt.Errorf("failed to dump handles: %w", err)
+	}
+	return nil
+}
+
+// handleDumpLoop dumps the handle table periodically
+func (s *NFSServer) handleDumpLoop() {
+	ticker := time.NewTicker(handleDumpInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.DumpHandles(context.Background()); err != nil {
+			slog.Error("Dumping handles failed", "err", err)
+		}
+	}
+}
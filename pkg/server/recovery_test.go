@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestHandleDumpRecovery(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "dir"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	for _, name := range []string{"dir/kept", "dir/replaced"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	dumpFile := filepath.Join(t.TempDir(), "handles.json")
+
+	newServer := func() (*NFSServer, *local.LocalFileSystem) {
+		t.Helper()
+		fs, err := local.NewLocalFileSystem(tempDir)
+		if err != nil {
+			t.Fatalf("Failed to create filesystem: %v", err)
+		}
+		config := DefaultConfig()
+		config.HandleDumpFile = dumpFile
+		server, err := NewNFSServer(config, fs)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return server, fs
+	}
+
+	server, fs := newServer()
+	ctx := context.Background()
+	kept, err := fs.PathToFileHandle(ctx, "/dir/kept")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	replaced, err := fs.PathToFileHandle(ctx, "/dir/replaced")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	if err := server.DumpHandles(ctx); err != nil {
+		t.Fatalf("DumpHandles failed: %v", err)
+	}
+
+	// Lose the index and replace one file by another of the same name,
+	// keeping the old one so its inode is not reused
+	if err := os.Rename(filepath.Join(tempDir, "dir/replaced"), filepath.Join(tempDir, "moved")); err != nil {
+		t.Fatalf("Failed to move file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "dir/replaced"), nil, 0644); err != nil {
+		t.Fatalf("Failed to recreate file: %v", err)
+	}
+	server, _ = newServer()
+
+	// A done context stops the walk the file system would need, so only
+	// the dump can resolve the handle
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	if path, err := server.resolveHandle(done, SignHandle(server.handleKey, kept)); err != nil || path != "/dir/kept" {
+		t.Errorf("resolveHandle of a dumped handle returned %q, %v; want /dir/kept", path, err)
+	}
+	if path, err := server.resolveHandle(done, SignHandle(server.handleKey, replaced)); err == nil {
+		t.Errorf("resolveHandle of a replaced file's handle returned %q, want an error", path)
+	}
+
+	// The re-validated handle is indexed again and dumped with the rest
+	if path, err := server.resolveHandle(done, SignHandle(server.handleKey, kept)); err != nil || path != "/dir/kept" {
+		t.Errorf("Second resolveHandle returned %q, %v; want /dir/kept", path, err)
+	}
+	if err := server.DumpHandles(ctx); err != nil {
+		t.Fatalf("DumpHandles failed: %v", err)
+	}
+	recovery, err := newHandleRecovery(dumpFile)
+	if err != nil {
+		t.Fatalf("newHandleRecovery failed: %v", err)
+	}
+	if path, ok := recovery.paths.Load(string(kept)); !ok || path != "/dir/kept" {
+		t.Errorf("Second dump holds %v for the kept file, want /dir/kept", path)
+	}
+}
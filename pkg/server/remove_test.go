@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestRemove(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Create a file, a directory and a symlink pointing at the directory
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "subdir"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "subdir", "kept.txt"), []byte("kept"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Symlink("subdir", filepath.Join(tempDir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	subHandle, err := server.issueHandle(context.Background(), "/subdir")
+	if err != nil {
+		t.Fatalf("Failed to get subdir handle: %v", err)
+	}
+
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	other := &api.Credentials{Uid: 12345, Gid: 12345}
+
+	testCases := []struct {
+		name       string
+		dir        []byte
+		entry      string
+		creds      *api.Credentials
+		wantStatus api.Status
+	}{
+		{"No write permission", subHandle, "kept.txt", other, api.Status_ERR_ACCES},
+		{"Remove file", rootHandle, "file.txt", root, api.Status_OK},
+		{"Remove symlink to directory", rootHandle, "link", root, api.Status_OK},
+		{"Missing entry", rootHandle, "file.txt", root, api.Status_ERR_NOENT},
+		{"Directory", rootHandle, "subdir", root, api.Status_ERR_ISDIR},
+		{"Escaping name", subHandle, "../file.txt", root, api.Status_ERR_INVAL},
+		{"Invalid handle", []byte{1, 2, 3}, "file.txt", root, api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.Remove(context.Background(), &api.RemoveRequest{
+				DirectoryHandle: tc.dir,
+				Name:            tc.entry,
+				Credentials:     tc.creds,
+			})
+			if err != nil {
+				t.Fatalf("Remove returned error: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Expected status %v, got %v", tc.wantStatus, resp.Status)
+			}
+			if tc.wantStatus == api.Status_OK && resp.DirAttributes == nil {
+				t.Error("Expected directory attributes in response")
+			}
+		})
+	}
+
+	// Only the removed entries are gone
+	for name, want := range map[string]bool{"file.txt": false, "link": false, "subdir/kept.txt": true} {
+		_, err := os.Lstat(filepath.Join(tempDir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
+
+func TestRemoveRootSquash(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		t.Fatalf("Failed to chmod temp dir: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Root is squashed to nobody, who may not write the directory
+	server, err := NewNFSServer(DefaultConfig(), fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	resp, err := server.Remove(context.Background(), &api.RemoveRequest{
+		DirectoryHandle: rootHandle,
+		Name:            "file.txt",
+		Credentials:     &api.Credentials{Uid: 0, Gid: 0},
+	})
+	if err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if resp.Status != api.Status_ERR_ACCES {
+		t.Errorf("Squashed root Remove = %v, want ERR_ACCES", resp.Status)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "file.txt")); err != nil {
+		t.Errorf("File was removed despite root squashing: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// removeTreeProgressInterval is how often RemoveTree streams progress
+const removeTreeProgressInterval = 250 * time.Millisecond
+
+// treeRemover deletes a directory subtree with bounded concurrency
+type treeRemover struct {
+	fileSystem fs.FileSystem
+	creds      fs.Credentials
+	metrics    *serverMetrics // Counts panics in the deleting goroutines
+
+	// Limits the number of filesystem deletions in flight
+	slots chan struct{}
+
+	files atomic.Uint64
+	dirs  atomic.Uint64
+	bytes atomic.Uint64
+
+	// First failure; later work is abandoned once it is set
+	mu         sync.Mutex
+	err        error
+	failedPath string
+	cancel     context.CancelFunc
+}
+
+// newTreeRemover creates a remover allowing concurrency parallel deletions
+func newTreeRemover(fileSystem fs.FileSystem, creds fs.Credentials, concurrency int, metrics *serverMetrics) *treeRemover {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &treeRemover{
+		fileSystem: fileSystem,
+		creds:      creds,
+		metrics:    metrics,
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// progress returns the counters as a progress message
+func (r *treeRemover) progress() *api.RemoveTreeProgress {
+	return &api.RemoveTreeProgress{
+		FilesRemoved:       r.files.Load(),
+		DirectoriesRemoved: r.dirs.Load(),
+		BytesRemoved:       r.bytes.Load(),
+	}
+}
+
+// fail records the first error and stops the remaining work
+func (r *treeRemover) fail(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = err
+		r.failedPath = path
+		r.cancel()
+	}
+}
+
+// recoverPanic, deferred around each walk and deletion, fails the removal
+// of path instead of the server if the backend panicked
+func (r *treeRemover) recoverPanic(ctx context.Context, path string) {
+	if rec := recover(); rec != nil {
+		r.fail(path, r.metrics.panicked(ctx, "RemoveTree", rec))
+	}
+}
+
+// run removes dir and everything below it
+func (r *treeRemover) run(ctx context.Context, dir string) (string, error) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	defer r.cancel()
+
+	func() {
+		defer r.recoverPanic(ctx, dir)
+		r.removeDir(ctx, dir)
+	}()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil && ctx.Err() != nil {
+		return dir, ctx.Err()
+	}
+	return r.failedPath, r.err
+}
+
+// removeDir empties dir, removing files and subdirectories in parallel,
+// then removes dir itself
+func (r *treeRemover) removeDir(ctx context.Context, dir string) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Deleting entries requires write and search permission on the directory
+	if err := r.fileSystem.Access(ctx, dir, fs.FileMode(3), r.creds); err != nil { // 3 = write+execute
+		r.fail(dir, err)
+		return
+	}
+
+	entries, _, err := r.fileSystem.ReadDir(ctx, dir, 0, 0)
+	if err != nil {
+		r.fail(dir, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		path, info, err := r.fileSystem.Lookup(ctx, dir, entry.Name)
+		if err != nil {
+			r.fail(filepath.Join(dir, entry.Name), err)
+			break
+		}
+
+		wg.Add(1)
+		if info.Type == fs.FileTypeDirectory {
+			// Subdirectories are walked concurrently; only the actual
+			// deletions take a slot, so deep trees cannot deadlock
+			go func() {
+				defer wg.Done()
+				defer r.recoverPanic(ctx, path)
+				r.removeDir(ctx, path)
+			}()
+			continue
+		}
+
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Done()
+			wg.Wait()
+			return
+		}
+		go func(size uint64) {
+			defer wg.Done()
+			defer func() { <-r.slots }()
+			defer r.recoverPanic(ctx, path)
+
+			if err := r.fileSystem.Remove(ctx, path); err != nil {
+				r.fail(path, err)
+				return
+			}
+			r.files.Add(1)
+			r.bytes.Add(size)
+		}(uint64(info.Size))
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	if err := r.fileSystem.Rmdir(ctx, dir); err != nil {
+		r.fail(dir, err)
+		return
+	}
+	r.dirs.Add(1)
+}
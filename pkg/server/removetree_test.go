@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/grpc"
+)
+
+// progressStream collects the messages sent by a RemoveTree call
+type progressStream struct {
+	grpc.ServerStream
+	sent []*api.RemoveTreeProgress
+}
+
+func (p *progressStream) Context() context.Context {
+	return context.Background()
+}
+
+func (p *progressStream) Send(msg *api.RemoveTreeProgress) error {
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+func TestRemoveTree(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Build a tree with 3 levels of directories and 10 files in each
+	treeRoot := filepath.Join(tempDir, "tree")
+	dir := treeRoot
+	for level := 0; level < 3; level++ {
+		dir = filepath.Join(dir, fmt.Sprintf("level%d", level))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			name := filepath.Join(dir, fmt.Sprintf("file%d", i))
+			if err := os.WriteFile(name, []byte("0123456789"), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "plain.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server; root creds are needed to write the temp directory
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	creds := &api.Credentials{Uid: 0, Gid: 0}
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		handle     []byte
+		target     string
+		wantStatus api.Status
+	}{
+		{"Not a directory", rootHandle, "plain.txt", api.Status_ERR_NOTDIR},
+		{"Missing", rootHandle, "missing", api.Status_ERR_NOENT},
+		{"Escaping name", rootHandle, "..", api.Status_ERR_INVAL},
+		{"Invalid handle", []byte{1, 2, 3}, "tree", api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := &progressStream{}
+			err := server.RemoveTree(&api.RemoveTreeRequest{
+				DirectoryHandle: tc.handle,
+				Name:            tc.target,
+				Credentials:     creds,
+			}, stream)
+			if err != nil {
+				t.Fatalf("RemoveTree failed: %v", err)
+			}
+			final := stream.sent[len(stream.sent)-1]
+			if !final.Done || final.Status != tc.wantStatus {
+				t.Errorf("Unexpected final message: %+v, want status %v", final, tc.wantStatus)
+			}
+		})
+	}
+
+	// Remove the whole tree
+	stream := &progressStream{}
+	err = server.RemoveTree(&api.RemoveTreeRequest{
+		DirectoryHandle: rootHandle,
+		Name:            "tree",
+		Credentials:     creds,
+		Concurrency:     4,
+	}, stream)
+	if err != nil {
+		t.Fatalf("RemoveTree failed: %v", err)
+	}
+
+	final := stream.sent[len(stream.sent)-1]
+	if !final.Done || final.Status != api.Status_OK {
+		t.Fatalf("Unexpected final message: %+v", final)
+	}
+	if final.FilesRemoved != 30 || final.DirectoriesRemoved != 4 || final.BytesRemoved != 300 {
+		t.Errorf("Wrong counts: files=%d dirs=%d bytes=%d",
+			final.FilesRemoved, final.DirectoriesRemoved, final.BytesRemoved)
+	}
+	if _, err := os.Stat(treeRoot); !os.IsNotExist(err) {
+		t.Errorf("Tree still exists after RemoveTree: %v", err)
+	}
+}
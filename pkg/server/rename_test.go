@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestRename(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create test files and a subdirectory
+	if err := os.WriteFile(filepath.Join(tempDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "old.txt"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "subdir"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server without root squashing so root may write the temp dir
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	subHandle, err := server.issueHandle(context.Background(), "/subdir")
+	if err != nil {
+		t.Fatalf("Failed to get subdir handle: %v", err)
+	}
+
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	other := &api.Credentials{Uid: 12345, Gid: 12345}
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		fromDir    []byte
+		fromName   string
+		toDir      []byte
+		toName     string
+		creds      *api.Credentials
+		noReplace  bool
+		exchange   bool
+		wantStatus api.Status
+	}{
+		{"Replace existing file", rootHandle, "new.txt", rootHandle, "old.txt", root, false, false, api.Status_OK},
+		{"Move into subdirectory", rootHandle, "old.txt", subHandle, "moved.txt", root, false, false, api.Status_OK},
+		{"Missing source", rootHandle, "missing.txt", rootHandle, "x.txt", root, false, false, api.Status_ERR_NOENT},
+		{"Escaping name", subHandle, "moved.txt", subHandle, "../escape.txt", root, false, false, api.Status_ERR_INVAL},
+		{"No write permission", subHandle, "moved.txt", subHandle, "other.txt", other, false, false, api.Status_ERR_ACCES},
+		{"Invalid handle", []byte{1, 2, 3}, "a", rootHandle, "b", root, false, false, api.Status_ERR_BADHANDLE},
+		{"No-replace onto existing file", rootHandle, "a.txt", rootHandle, "b.txt", root, true, false, api.Status_ERR_EXIST},
+		{"Exchange", rootHandle, "a.txt", rootHandle, "b.txt", root, false, true, api.Status_OK},
+		{"Exchange with missing destination", rootHandle, "a.txt", rootHandle, "none.txt", root, false, true, api.Status_ERR_NOENT},
+		{"Both flags", rootHandle, "a.txt", rootHandle, "b.txt", root, true, true, api.Status_ERR_INVAL},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.Rename(context.Background(), &api.RenameRequest{
+				FromDirectoryHandle: tc.fromDir,
+				FromName:            tc.fromName,
+				ToDirectoryHandle:   tc.toDir,
+				ToName:              tc.toName,
+				Credentials:         tc.creds,
+				NoReplace:           tc.noReplace,
+				Exchange:            tc.exchange,
+			})
+			if err != nil {
+				t.Fatalf("Rename failed: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Unexpected status: got %v, want %v", resp.Status, tc.wantStatus)
+			}
+		})
+	}
+
+	// The replaced file now has the new content, in its new location
+	data, err := os.ReadFile(filepath.Join(tempDir, "subdir", "moved.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read renamed file: %v", err)
+	}
+	if string(data) != "new" {
+		t.Errorf("Wrong content after rename: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("Source still exists after rename: %v", err)
+	}
+
+	// The exchanged files swapped contents
+	if data, err := os.ReadFile(filepath.Join(tempDir, "a.txt")); err != nil || string(data) != "b.txt" {
+		t.Errorf("a.txt holds %q after the exchange, %v", data, err)
+	}
+}
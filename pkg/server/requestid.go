@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+
+	"github.com/example/nfsserver/pkg/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDHeader is the metadata key a client may set to have its own ID
+// used for a request, and the response header the ID is returned in
+const requestIDHeader = "x-request-id"
+
+// maxRequestIDLength bounds the request IDs accepted from clients
+const maxRequestIDLength = 64
+
+// requestID returns the ID the request of ctx is logged under: the one its
+// client sent if usable, otherwise a new one
+func requestID(ctx context.Context) string {
+	if id, ok := clientRequestID(ctx); ok {
+		return id
+	}
+	return logging.NewRequestID()
+}
+
+// clientRequestID returns the ID the client of ctx sent for its request, if
+// usable. Clients keep the ID across retries of a request.
+func clientRequestID(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(requestIDHeader); len(values) > 0 && validRequestID(values[0]) {
+		return values[0], true
+	}
+	return "", false
+}
+
+// retryKey returns the key the response to the request of ctx is cached
+// under for its retries: op, the client's address and the ID the client
+// sent. A request without an ID cannot be told from a new one and has no
+// key.
+func retryKey(ctx context.Context, op string) (string, bool) {
+	id, ok := clientRequestID(ctx)
+	if !ok {
+		return "", false
+	}
+	client, addr, known := peerAddr(ctx)
+	if known {
+		// Without the port, which changes when the client reconnects
+		client = addr.String()
+	}
+	return op + "-" + client + "-" + id, true
+}
+
+// validRequestID reports whether a client's request ID is short and
+// printable enough to put in the log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDInterceptor gives every unary request an ID, carried by its
+// context and returned to the client in the x-request-id header
+func requestIDInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	id := requestID(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("nfs.request_id", id))
+	return handler(logging.WithRequestID(ctx, id), req)
+}
+
+// requestIDStreamInterceptor is requestIDInterceptor for streaming calls,
+// whose messages all share one ID
+func requestIDStreamInterceptor(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	id := requestID(ss.Context())
+	ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+	trace.SpanFromContext(ss.Context()).SetAttributes(attribute.String("nfs.request_id", id))
+	return handler(srv, &requestIDStream{ServerStream: ss, ctx: logging.WithRequestID(ss.Context(), id)})
+}
+
+// requestIDStream is a stream whose context carries its request ID
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (r *requestIDStream) Context() context.Context {
+	return r.ctx
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDLogging(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	server, err := NewNFSServer(DefaultConfig(), fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Options{Level: "debug", Format: "json"})
+	if err != nil {
+		t.Fatalf("logging.New failed: %v", err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	// getAttr sends a GetAttr through requestIDInterceptor and returns the
+	// request IDs of the lines it logged
+	getAttr := func(md metadata.MD) []string {
+		buf.Reset()
+		ctx := context.Background()
+		if md != nil {
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+		info := &grpc.UnaryServerInfo{FullMethod: "/nfs.NFSService/GetAttr"}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return server.GetAttr(ctx, req.(*api.GetAttrRequest))
+		}
+		if _, err := requestIDInterceptor(ctx, &api.GetAttrRequest{FileHandle: rootHandle}, info, handler); err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+
+		var ids []string
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var entry struct {
+				Msg   string `json:"msg"`
+				ReqID string `json:"req_id"`
+			}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				t.Fatalf("Log line %q is not JSON: %v", line, err)
+			}
+			if entry.Msg == "NFS request" || entry.Msg == "NFS response" {
+				ids = append(ids, entry.ReqID)
+			}
+		}
+		if len(ids) != 2 {
+			t.Fatalf("Logged %q, want a request and a response line", buf.String())
+		}
+		return ids
+	}
+
+	// A client's own ID is used, so its logs and the server's correlate
+	ids := getAttr(metadata.Pairs(requestIDHeader, "client-42"))
+	if ids[0] != "client-42" || ids[1] != "client-42" {
+		t.Errorf("Request IDs = %q, want client-42", ids)
+	}
+
+	// Otherwise the server picks one, shared by every line of the request
+	ids = getAttr(nil)
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Errorf("Request IDs = %q, want one generated ID", ids)
+	}
+
+	// IDs that could forge log lines are replaced
+	ids = getAttr(metadata.Pairs(requestIDHeader, "x\nforged"))
+	if ids[0] == "x\nforged" || ids[0] == "" {
+		t.Errorf("Request ID = %q, want a generated ID", ids[0])
+	}
+
+	// Requests are not logged above debug level
+	logger, _ = logging.New(&buf, logging.Options{Level: "info", Format: "json"})
+	slog.SetDefault(logger)
+	buf.Reset()
+	if _, err := server.GetAttr(context.Background(), &api.GetAttrRequest{FileHandle: rootHandle}); err != nil {
+		t.Fatalf("GetAttr failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Logged %q at info level, want nothing", buf.String())
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// revokedHandle is one entry of the revocation list
+type revokedHandle struct {
+	Handle  []byte    `json:"handle"`
+	Reason  string    `json:"reason,omitempty"`
+	Revoked time.Time `json:"revoked"`
+	Expires time.Time `json:"expires,omitempty"` // zero = the handle never expires
+}
+
+// revocationList holds the handles an administrator revoked, refused from
+// then on as if their signature were wrong. Entries for expiring handles
+// are dropped once the handles expire, as they are refused anyway.
+type revocationList struct {
+	mu      sync.RWMutex
+	file    string // "" = kept in memory only
+	handles map[string]revokedHandle
+
+	// Clock, replaceable in tests
+	now func() time.Time
+}
+
+// newRevocationList creates a revocation list, loading the entries saved
+// in file if it exists
+func newRevocationList(file string) (*revocationList, error) {
+	r := &revocationList{file: file, handles: make(map[string]revokedHandle), now: time.Now}
+	if file == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation file: %w", err)
+	}
+	var saved []revokedHandle
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse revocation file %s: %w", file, err)
+	}
+	for _, entry := range saved {
+		r.handles[string(entry.Handle)] = entry
+	}
+	return r, nil
+}
+
+// has reports whether handle was revoked
+func (r *revocationList) has(handle []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.handles) == 0 {
+		return false
+	}
+	_, ok := r.handles[string(handle)]
+	return ok
+}
+
+// add revokes handle, which expires at expires (zero = never)
+func (r *revocationList) add(handle []byte, reason string, expires time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handles[string(handle)] = revokedHandle{
+		Handle:  append([]byte(nil), handle...),
+		Reason:  reason,
+		Revoked: r.now().UTC(),
+		Expires: expires.UTC(),
+	}
+	return r.saveLocked()
+}
+
+// remove reinstates handle, reporting whether it was revoked
+func (r *revocationList) remove(handle []byte) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handles[string(handle)]; !ok {
+		return false, nil
+	}
+	delete(r.handles, string(handle))
+	return true, r.saveLocked()
+}
+
+// list returns the entries of handles not yet expired, oldest revocation
+// first
+func (r *revocationList) list() []revokedHandle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked()
+	entries := make([]revokedHandle, 0, len(r.handles))
+	for _, entry := range r.handles {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Revoked.Before(entries[j].Revoked)
+	})
+	return entries
+}
+
+// expireLocked drops the entries of handles that have expired
+func (r *revocationList) expireLocked() {
+	now := r.now()
+	for key, entry := range r.handles {
+		if !entry.Expires.IsZero() && !now.Before(entry.Expires) {
+			delete(r.handles, key)
+		}
+	}
+}
+
+// saveLocked writes the unexpired entries to the list's file. The file is
+// replaced atomically, so a crash leaves the previous copy.
+func (r *revocationList) saveLocked() error {
+	if r.file == "" {
+		return nil
+	}
+	r.expireLocked()
+	entries := make([]revokedHandle, 0, len(r.handles))
+	for _, entry := range r.handles {
+		entries = append(entries, entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(r.file, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save revocations: %w", err)
+	}
+	return nil
+}
+
+// RevokeHandle implements the RevokeHandle admin RPC
+func (a *adminServer) RevokeHandle(ctx context.Context, req *api.RevokeHandleRequest) (*api.RevokeHandleResponse, error) {
+	_, expires, ok := a.nfs.checkSignature(req.FileHandle)
+	if !ok {
+		// Snapshot handles expire with the handle they decorate
+		if live, _, decorated := a.nfs.checkSnapshotSignature(req.FileHandle); decorated {
+			_, expires, ok = a.nfs.checkSignature(live)
+		}
+	}
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "not a handle issued by this server")
+	}
+	if err := a.nfs.revoked.add(req.FileHandle, req.Reason, expires); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	slog.InfoContext(ctx, "Handle revoked", "handle", fmt.Sprintf("%x", req.FileHandle), "reason", req.Reason)
+
+	resp := &api.RevokeHandleResponse{}
+	if !expires.IsZero() {
+		resp.Expires = &api.FileTime{Seconds: expires.Unix()}
+	}
+	return resp, nil
+}
+
+// UnrevokeHandle implements the UnrevokeHandle admin RPC
+func (a *adminServer) UnrevokeHandle(ctx context.Context, req *api.UnrevokeHandleRequest) (*api.UnrevokeHandleResponse, error) {
+	removed, err := a.nfs.revoked.remove(req.FileHandle)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if removed {
+		slog.InfoContext(ctx, "Handle reinstated", "handle", fmt.Sprintf("%x", req.FileHandle))
+	}
+	return &api.UnrevokeHandleResponse{Removed: removed}, nil
+}
+
+// ListRevokedHandles implements the ListRevokedHandles admin RPC
+func (a *adminServer) ListRevokedHandles(ctx context.Context, req *api.ListRevokedHandlesRequest) (*api.ListRevokedHandlesResponse, error) {
+	resp := &api.ListRevokedHandlesResponse{}
+	for _, entry := range a.nfs.revoked.list() {
+		revoked := &api.RevokedHandle{
+			FileHandle: entry.Handle,
+			Reason:     entry.Reason,
+			Revoked:    &api.FileTime{Seconds: entry.Revoked.Unix(), Nano: int32(entry.Revoked.Nanosecond())},
+		}
+		if !entry.Expires.IsZero() {
+			revoked.Expires = &api.FileTime{Seconds: entry.Expires.Unix()}
+		}
+		resp.Handles = append(resp.Handles, revoked)
+	}
+	return resp, nil
+}
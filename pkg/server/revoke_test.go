@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestExpiringHandles(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(t.TempDir(), "handle.key")
+	config.HandleLifetime = time.Hour
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := context.Background()
+	getAttr := func(server *NFSServer, handle []byte) api.Status {
+		t.Helper()
+		resp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle, Credentials: &api.Credentials{}})
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		return resp.Status
+	}
+
+	// Handles issued within one window are the same, and last one to two
+	// lifetimes
+	handle, err := server.issueHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	again, err := server.issueHandle(ctx, "/file")
+	if err != nil || !bytes.Equal(handle, again) {
+		t.Errorf("Handles issued at once differ: %x, %x", handle, again)
+	}
+	_, expires, ok := server.checkSignature(handle)
+	if lifetime := time.Until(expires); !ok || lifetime < time.Hour-time.Second || lifetime > 2*time.Hour {
+		t.Errorf("Handle expires in %v, want one to two hours", lifetime)
+	}
+	if status := getAttr(server, handle); status != api.Status_OK {
+		t.Errorf("GetAttr = %v, want OK", status)
+	}
+
+	// Expired handles, and handles without an expiry, are refused
+	inner, err := fs.PathToFileHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("PathToFileHandle failed: %v", err)
+	}
+	expired := SignExpiringHandle(server.handleKey, inner, time.Now().Add(-time.Second))
+	if status := getAttr(server, expired); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr of an expired handle = %v, want ERR_BADHANDLE", status)
+	}
+	if status := getAttr(server, SignHandle(server.handleKey, inner)); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr of a handle without expiry = %v, want ERR_BADHANDLE", status)
+	}
+
+	// but for the root's, which mounts hold for as long as they are mounted
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	if _, expires, _ := server.checkSignature(rootHandle); !expires.IsZero() || getAttr(server, rootHandle) != api.Status_OK {
+		t.Errorf("Root handle expires at %v", expires)
+	}
+
+	// The expiry is signed
+	tampered := bytes.Clone(handle)
+	tampered[len(handle)-handleMACSize-1] ^= 1
+	if status := getAttr(server, tampered); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr with a changed expiry = %v, want ERR_BADHANDLE", status)
+	}
+
+	// A server without a lifetime still accepts expiring handles until
+	// they expire
+	plain := *config
+	plain.HandleLifetime = 0
+	restarted, err := NewNFSServer(&plain, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if status := getAttr(restarted, handle); status != api.Status_OK {
+		t.Errorf("GetAttr without a lifetime = %v, want OK", status)
+	}
+}
+
+func TestRevokeHandle(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{"leaked", "other"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	stateDir := t.TempDir()
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.HandleKeyFile = filepath.Join(stateDir, "handle.key")
+	config.RevocationFile = filepath.Join(stateDir, "revoked.json")
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	admin := &adminServer{nfs: server}
+	ctx := context.Background()
+	leaked, err := server.issueHandle(ctx, "/leaked")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	other, err := server.issueHandle(ctx, "/other")
+	if err != nil {
+		t.Fatalf("issueHandle failed: %v", err)
+	}
+	getAttr := func(server *NFSServer, handle []byte) api.Status {
+		t.Helper()
+		resp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle, Credentials: &api.Credentials{}})
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		return resp.Status
+	}
+
+	if _, err := admin.RevokeHandle(ctx, &api.RevokeHandleRequest{FileHandle: leaked, Reason: "laptop stolen"}); err != nil {
+		t.Fatalf("RevokeHandle failed: %v", err)
+	}
+	if _, err := admin.RevokeHandle(ctx, &api.RevokeHandleRequest{FileHandle: []byte("made up")}); err == nil {
+		t.Error("RevokeHandle accepted a handle the server did not issue")
+	}
+	if status := getAttr(server, leaked); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr of a revoked handle = %v, want ERR_BADHANDLE", status)
+	}
+	if status := getAttr(server, other); status != api.Status_OK {
+		t.Errorf("GetAttr of another handle = %v, want OK", status)
+	}
+
+	// The list survives a restart
+	restarted, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if status := getAttr(restarted, leaked); status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr of a revoked handle after a restart = %v, want ERR_BADHANDLE", status)
+	}
+	list, err := (&adminServer{nfs: restarted}).ListRevokedHandles(ctx, &api.ListRevokedHandlesRequest{})
+	if err != nil || len(list.Handles) != 1 || !bytes.Equal(list.Handles[0].FileHandle, leaked) ||
+		list.Handles[0].Reason != "laptop stolen" || list.Handles[0].Expires != nil {
+		t.Errorf("ListRevokedHandles = %v, %v; want the leaked handle, never expiring", list, err)
+	}
+
+	resp, err := admin.UnrevokeHandle(ctx, &api.UnrevokeHandleRequest{FileHandle: leaked})
+	if err != nil || !resp.Removed {
+		t.Fatalf("UnrevokeHandle = %v, %v", resp, err)
+	}
+	if status := getAttr(server, leaked); status != api.Status_OK {
+		t.Errorf("GetAttr of a reinstated handle = %v, want OK", status)
+	}
+
+	// Revocations of expiring handles are dropped once they expire
+	revoked := server.revoked
+	if err := revoked.add(other, "", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	revoked.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if entries := revoked.list(); len(entries) != 0 {
+		t.Errorf("Expired revocations kept: %v", entries)
+	}
+}
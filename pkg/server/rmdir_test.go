@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestRmdir(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Create an empty directory, a non-empty one, a file and a symlink
+	for _, dir := range []string{"empty", "full", "parent/child"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "full", "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Symlink("empty", filepath.Join(tempDir, "link")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	parentHandle, err := server.issueHandle(context.Background(), "/parent")
+	if err != nil {
+		t.Fatalf("Failed to get parent handle: %v", err)
+	}
+
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	other := &api.Credentials{Uid: 12345, Gid: 12345}
+
+	testCases := []struct {
+		name       string
+		dir        []byte
+		entry      string
+		creds      *api.Credentials
+		wantStatus api.Status
+	}{
+		{"Not empty", rootHandle, "full", root, api.Status_ERR_NOTEMPTY},
+		{"Regular file", rootHandle, "file.txt", root, api.Status_ERR_NOTDIR},
+		{"Escaping name", parentHandle, "../empty", root, api.Status_ERR_INVAL},
+		{"Symlink to directory", rootHandle, "link", root, api.Status_ERR_NOTDIR},
+		{"No write permission", parentHandle, "child", other, api.Status_ERR_ACCES},
+		{"Remove empty directory", rootHandle, "empty", root, api.Status_OK},
+		{"Remove emptied child", parentHandle, "child", root, api.Status_OK},
+		{"Missing directory", rootHandle, "empty", root, api.Status_ERR_NOENT},
+		{"Invalid handle", []byte{1, 2, 3}, "parent", root, api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.Rmdir(context.Background(), &api.RmdirRequest{
+				DirectoryHandle: tc.dir,
+				Name:            tc.entry,
+				Credentials:     tc.creds,
+			})
+			if err != nil {
+				t.Fatalf("Rmdir returned error: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Expected status %v, got %v", tc.wantStatus, resp.Status)
+			}
+			if tc.wantStatus == api.Status_OK && resp.DirAttributes == nil {
+				t.Error("Expected parent attributes in response")
+			}
+		})
+	}
+
+	// Only the empty directories are gone
+	for name, want := range map[string]bool{"empty": false, "parent/child": false, "full/file.txt": true, "file.txt": true, "link": true} {
+		_, err := os.Lstat(filepath.Join(tempDir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
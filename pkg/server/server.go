@@ -5,18 +5,28 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 	"path/filepath"
+	"slices"
 	"hash/crc32"
-    "syscall"
+	"io"
 
 	"github.com/example/nfsserver/pkg/api"
 	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/logging"
 	"github.com/example/nfsserver/pkg/nfs"
+	"github.com/example/nfsserver/pkg/telemetry"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // Config contains the NFS server configuration
@@ -27,16 +37,30 @@ type Config struct {
 	// Maximum concurrent requests
 	MaxConcurrent int
 
-	// Maximum read size in bytes
+	// Maximum read size in bytes, at most nfs.MaxTransferSize. gRPC's
+	// message limits are raised to fit it.
 	MaxReadSize int
 
-	// Maximum write size in bytes
+	// Maximum write size in bytes, like MaxReadSize
 	MaxWriteSize int
 
-	// Request timeout in seconds
-	RequestTimeout int
+	// Idle time after which a client connection is pinged, and how long
+	// the ping may go unanswered before the connection is closed
+	// (KeepaliveTime 0 = gRPC's default of two hours)
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
 
-	// Enable root squashing (map root to anonymous user)
+	// Shortest interval at which clients may send keepalive pings; clients
+	// pinging more often are disconnected
+	KeepaliveMinTime time.Duration
+
+	// Request timeout. Resolving a file handle gives up after this long
+	// with ERR_JUKEBOX (0 = only the client's deadline applies).
+	RequestTimeout time.Duration
+
+	// Enable root squashing (map root to anonymous user). This and the
+	// anonymous identity and access settings are only the initial export
+	// policy; the admin service's SetExportPolicy replaces them at runtime.
 	EnableRootSquash bool
 
 	// Anonymous user ID
@@ -44,19 +68,190 @@ type Config struct {
 
 	// Anonymous group ID
 	AnonGID uint32
+
+	// Network address of the admin service (empty disables it)
+	AdminListenAddress string
+
+	// Network address serving net/http/pprof (empty disables it). Like the
+	// admin address it must only be reachable by operators.
+	ProfileListenAddress string
+
+	// Network address serving Prometheus metrics at /metrics (empty
+	// disables it)
+	MetricsListenAddress string
+
+	// Number of sanitized request/response summaries kept for debugging
+	// (0 disables request capture)
+	CaptureBufferSize int
+
+	// Maximum parallel deletions performed by one RemoveTree call
+	MaxRemoveTreeConcurrency int
+
+	// Longest file name accepted, in bytes
+	MaxNameLength int
+
+	// Longest path accepted, in bytes, measured from the export root
+	MaxPathLength int
+
+	// Deepest directory nesting accepted (0 = unlimited)
+	MaxPathDepth int
+
+	// Check and repair the file system's index before serving traffic
+	CheckOnStartup bool
+
+	// File holding the key handles are signed with, created if missing.
+	// Without one a random key is used and handles do not survive a
+	// restart.
+	HandleKeyFile string
+
+	// Directory the file system's index is saved in across restarts, if
+	// the file system supports it (empty = rebuilt as handles are used)
+	IndexDir string
+
+	// File the path and handle of every indexed file is dumped to
+	// periodically, so that after the index is lost handles held by
+	// clients can be re-validated from it (empty = disabled)
+	HandleDumpFile string
+
+	// Directories resolved before serving traffic, along with their
+	// ancestors and entries, so the first requests after a restart find
+	// handles and attributes already cached
+	WarmPaths []string
+
+	// Serve requests sent without credentials read-only, as AnonUID and
+	// AnonGID (otherwise they are treated as coming from root)
+	AllowAnonymous bool
+
+	// File holding the key bearer tokens are signed with, created if
+	// missing. With one, requests are served as the identity in their
+	// token rather than the credentials they carry (empty = credentials
+	// are trusted).
+	AuthKeyFile string
+
+	// Establishes the identity of requests, overriding AuthKeyFile (nil =
+	// credentials are trusted)
+	Authenticator Authenticator
+
+	// Reuse Read payload buffers and response structs across requests
+	// instead of allocating them per call
+	PooledBuffers bool
+
+	// Most directory delegations outstanding at once (0 = never delegate)
+	MaxDirDelegations int
+
+	// Most file delegations outstanding at once (0 = never delegate)
+	MaxFileDelegations int
+
+	// Most sorted directory listings kept for clients paging through them
+	// (0 = rebuilt for every page)
+	MaxDirSnapshots int
+
+	// Most requests waiting for a worker; further requests are rejected
+	// with ERR_DELAY and a retry hint (0 = unlimited)
+	MaxQueued int
+
+	// Most requests accepted per second before rejecting with ERR_DELAY
+	// (0 = unlimited)
+	MaxRequestsPerSecond int
+
+	// Read FILE_SYNC writes back and compare checksums before acknowledging
+	// them, failing with ERR_IO on a mismatch
+	VerifyWrites bool
+
+	// Name of the export, by which clients pick it in GetRootHandle and
+	// which usage reports carry
+	ExportName string
+
+	// Refuse every operation that modifies the export with ERR_ROFS
+	ReadOnly bool
+
+	// Clients allowed to use the export, as IP addresses or CIDR prefixes
+	// (empty = any client). Others are refused with PermissionDenied.
+	AllowedClients []string
+
+	// Rules admitting or refusing clients by address, such as
+	// "allow 10.0.0.0/8" or "deny all", applied before AllowedClients: the
+	// first rule matching a client decides, and AllowedClients decides for
+	// clients no rule matches. Refused requests are answered with
+	// ERR_ACCES.
+	AccessRules []string
+
+	// Patterns, as for path.Match, of names hidden from Lookup and
+	// directory listings for every user but root, such as "lost+found" or
+	// "*.tmp"
+	HiddenNames []string
+
+	// Hide names starting with a dot from every user but root as well
+	HideDotfiles bool
+
+	// How long handles stay valid: each carries its expiry, signed, and
+	// lasts between one and two lifetimes (0 = handles never expire).
+	// Handles without an expiry are refused while it is set.
+	HandleLifetime time.Duration
+
+	// File the handles revoked through the admin service are kept in
+	// across restarts (empty = in memory only)
+	RevocationFile string
+
+	// File the per-uid bandwidth accounting is kept in across restarts
+	// (empty = in memory only)
+	UsageFile string
+
+	// Most responses kept for replay to clients retrying requests; the
+	// least recently used are dropped first (0 = none are kept)
+	RequestCacheSize int
+
+	// File the responses to requests that must not be carried out twice,
+	// such as exclusive creates, removes and renames, are journaled in, so
+	// retries are answered from it after a restart (empty = in memory only)
+	RequestCacheFile string
+
+	// How long a client's byte-range locks outlive its last lock call or
+	// renewal before they are released (0 = they never expire)
+	LockLease time.Duration
+
+	// How long after a restart clients that held locks have to reclaim
+	// them, while no other locks are granted (0 = locks are simply lost)
+	LockGracePeriod time.Duration
+
+	// File the clients holding lock leases are kept in, so that they may
+	// reclaim their locks after a restart (empty = in memory only, and no
+	// grace period)
+	LockClientsFile string
+
+	// Second backend holding the same tree, such as the copy a migration
+	// is moving the export to, that every read is repeated on in the
+	// background and compared with, logging mismatches (nil = none). The
+	// export must be read-only, as writes would make the two differ.
+	Shadow fs.FileSystem
+
+	// Codec replaces the protobuf codec for the NFS service (nil = default).
+	// It must still be named "proto" to interoperate with standard clients.
+	Codec encoding.CodecV2
 }
 
 // DefaultConfig returns a configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
-		ListenAddress:    ":2049",
-		MaxConcurrent:    100,
-		MaxReadSize:      1024 * 1024, // 1MB
-		MaxWriteSize:     1024 * 1024, // 1MB
-		RequestTimeout:   30,          // 30 seconds
-		EnableRootSquash: true,
-		AnonUID:          65534, // nobody
-		AnonGID:          65534, // nogroup
+		ListenAddress:            ":2049",
+		MaxConcurrent:            100,
+		MaxReadSize:              1024 * 1024, // 1MB
+		MaxWriteSize:             1024 * 1024, // 1MB
+		RequestTimeout:           30 * time.Second,
+		KeepaliveTimeout:         20 * time.Second,
+		KeepaliveMinTime:         10 * time.Second,
+		EnableRootSquash:         true,
+		AnonUID:                  65534, // nobody
+		AnonGID:                  65534, // nogroup
+		MaxRemoveTreeConcurrency: 8,
+		MaxNameLength:            255,
+		MaxPathLength:            4096,
+		MaxDirDelegations:        4096,
+		MaxFileDelegations:       16384,
+		MaxDirSnapshots:          256,
+		RequestCacheSize:         10000,
+		LockLease:                90 * time.Second,
+		LockGracePeriod:          90 * time.Second,
 	}
 }
 
@@ -73,56 +268,275 @@ type NFSServer struct {
 	// Secret key for file handle signatures
 	handleKey []byte
 
-	// Request cache for idempotent operations
-	reqCache     map[string]interface{}
-	reqCacheMu   sync.RWMutex
-	reqCacheTTL  time.Duration
+	// Config.HandleLifetime, shared by every export (0 = no expiry), and
+	// the file system handle of the root, which never expires
+	handleLifetime time.Duration
+	rootHandle     []byte
+
+	// Handles refused though correctly signed, shared by every export
+	revoked *revocationList
+
+	// Identity of requests (nil = the credentials they carry)
+	authenticator Authenticator
+
+	// Responses replayed to clients retrying requests
+	dupCache *dupCache
 
 	// Worker pool for limiting concurrent requests
 	workerPool chan struct{}
+
+	// Root squashing and anonymous access rules, replaceable at runtime
+	currentPolicy atomic.Pointer[exportPolicy]
+
+	// Load shedding when the queue for workers grows too long
+	backpressure *backpressure
+
+	// Ring buffer of recent request summaries (nil if capture is disabled)
+	captures *captureRing
+
+	// Admin-selected handle or path logged in detail
+	debug debugTarget
+
+	// Serializes appends to the same file
+	appendLocks fileLocks
+
+	// Newest fencing epoch per file handle
+	fences *fenceTable
+
+	// Byte-range locks, shared by every export
+	locks *lockTable
+
+	// Directory listings clients may cache until recalled
+	delegations *dirDelegations
+
+	// Files clients may cache until recalled
+	fileDelegations *fileDelegations
+
+	// Open Watch streams
+	watchers *watchers
+
+	// Sorted listings clients are paging through
+	dirSnapshots *dirSnapshots
+
+	// Trees removed in the background, waiting to be deleted
+	trash *trashQueue
+
+	// Recycled Read buffers (nil unless PooledBuffers is set)
+	payloads *payloadPool
+
+	// Cost and outcome of VerifyWrites read-backs
+	verifyStats verifyStats
+
+	// Compares reads with Config.Shadow (nil = reads are not shadowed)
+	shadow *shadowReader
+
+	// Bytes read and written per uid and day
+	usage *usageLedger
+
+	// Request counts and latencies for the metrics endpoint
+	metrics *serverMetrics
+
+	// Handles from the last dump, for re-validation after index loss
+	recovery *handleRecovery
+
+	// Every export served alongside this one, shared by all of them
+	exports *exportTable
+
+	// Config.AllowedClients and Config.AccessRules, parsed
+	allowedClients []netip.Prefix
+	accessRules    []AccessRule
+
+	// Config.HiddenNames and Config.HideDotfiles (nil = nothing hidden)
+	names *nameFilter
+
+	// Clients refused recently, for the log
+	refusals refusalLog
+
+	// Returned by Write and Commit. It changes when the server restarts, so
+	// a client sees that UNSTABLE writes it has not committed may be lost.
+	writeVerifier uint64
 }
 
 // NewNFSServer creates a new NFS server
 func NewNFSServer(config *Config, fileSystem fs.FileSystem) (*NFSServer, error) {
-	// Generate random key for file handle signatures
-	handleKey := make([]byte, 32)
-	if _, err := rand.Read(handleKey); err != nil {
+	// Load or generate the key for file handle signatures
+	handleKey := make([]byte, handleKeySize)
+	if config.HandleKeyFile != "" {
+		key, err := LoadHandleKey(config.HandleKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		handleKey = key
+	} else if _, err := rand.Read(handleKey); err != nil {
 		return nil, fmt.Errorf("failed to generate handle key: %w", err)
 	}
 
+	authenticator := config.Authenticator
+	if authenticator == nil && config.AuthKeyFile != "" {
+		key, err := LoadAuthKey(config.AuthKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticator = NewTokenAuthenticator(key)
+	}
+
 	// Create worker pool for controlling concurrency
 	workerPool := make(chan struct{}, config.MaxConcurrent)
 
-	return &NFSServer{
+	server := &NFSServer{
 		config:      config,
 		fileSystem:  fileSystem,
 		handleKey:   handleKey,
-		reqCache:    make(map[string]interface{}),
-		reqCacheTTL: time.Duration(2) * time.Minute,
+
+		authenticator:  authenticator,
+		handleLifetime: config.HandleLifetime,
 		workerPool:  workerPool,
-	}, nil
+		fences:      newFenceTable(),
+		delegations: newDirDelegations(config.MaxDirDelegations),
+		fileDelegations: newFileDelegations(config.MaxFileDelegations),
+		watchers:    newWatchers(),
+
+		dirSnapshots: newDirSnapshots(config.MaxDirSnapshots),
+		trash:        newTrashQueue(),
+
+		backpressure: newBackpressure(config),
+		metrics:      newServerMetrics(),
+
+		writeVerifier: uint64(time.Now().UnixNano()),
+	}
+
+	server.currentPolicy.Store(newExportPolicy(config))
+	dupCache, err := newDupCache(config.RequestCacheSize, config.RequestCacheFile, server.policy())
+	if err != nil {
+		return nil, err
+	}
+	server.dupCache = dupCache
+	server.exports = &exportTable{list: []*NFSServer{server}}
+
+	allowed, err := ParseClients(config.AllowedClients)
+	if err != nil {
+		return nil, err
+	}
+	server.allowedClients = allowed
+	rules, err := ParseAccessRules(config.AccessRules)
+	if err != nil {
+		return nil, err
+	}
+	server.accessRules = rules
+
+	names, err := newNameFilter(config)
+	if err != nil {
+		return nil, err
+	}
+	server.names = names
+
+	if config.HandleLifetime > 0 {
+		root, err := fileSystem.PathToFileHandle(context.Background(), "/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root handle: %w", err)
+		}
+		server.rootHandle = root
+	}
+
+	revoked, err := newRevocationList(config.RevocationFile)
+	if err != nil {
+		return nil, err
+	}
+	server.revoked = revoked
+
+	locks, err := newLockTable(config.LockLease, config.LockGracePeriod, config.LockClientsFile)
+	if err != nil {
+		return nil, err
+	}
+	server.locks = locks
+
+	usage, err := newUsageLedger(config.ExportName, config.UsageFile)
+	if err != nil {
+		return nil, err
+	}
+	server.usage = usage
+
+	recovery, err := newHandleRecovery(config.HandleDumpFile)
+	if err != nil {
+		return nil, err
+	}
+	server.recovery = recovery
+
+	if config.Shadow != nil {
+		if !config.ReadOnly {
+			return nil, fmt.Errorf("shadow reads need a read-only export, as writes would make the backends differ")
+		}
+		server.shadow = newShadowReader(config.Shadow, config)
+	}
+
+	if config.CaptureBufferSize > 0 {
+		server.captures = newCaptureRing(config.CaptureBufferSize)
+	}
+	if config.PooledBuffers {
+		server.payloads = newPayloadPool(config.MaxReadSize)
+	}
+
+	return server, nil
 }
 
-// Start launches the NFS server
+// Start launches the NFS server on Config.ListenAddress
 func (s *NFSServer) Start() error {
-    // 设置umask为0，允许创建具有完整权限的文件
-    oldUmask := syscall.Umask(0)
-    defer syscall.Umask(oldUmask) // 在服务器关闭时恢复
-
 	// Create listener
 	lis, err := net.Listen("tcp", s.config.ListenAddress)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
+	return s.Serve(lis)
+}
+
+// Serve launches the NFS server on lis, for programs embedding the server
+// that listen themselves. It returns once lis fails or is closed.
+func (s *NFSServer) Serve(lis net.Listener) error {
+    // Clear the umask so files are created with the modes clients request
+    oldUmask := umask(0)
+    defer umask(oldUmask) // Restore it when the server stops
+
+	for _, e := range s.exports.list {
+		if err := e.prepare(); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+	s.logExports()
 
 	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	grpcServer := s.newGRPCServer()
 	
-	// Register NFS service
-	api.RegisterNFSServiceServer(grpcServer, s)
+	// Start the admin service if configured
+	if s.config.AdminListenAddress != "" {
+		if err := s.startAdmin(); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+	
+	// Start the profiling endpoints if configured
+	if s.config.ProfileListenAddress != "" {
+		if err := s.startProfiling(); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+	
+	// Start the metrics endpoint if configured
+	if s.config.MetricsListenAddress != "" {
+		if err := s.startMetrics(); err != nil {
+			lis.Close()
+			return err
+		}
+	}
+	
+	for _, e := range s.exports.list {
+		e.startBackground()
+	}
 
 	// Start serving
-	log.Printf("NFS server starting on %s", s.config.ListenAddress)
+	slog.Info("NFS server starting", "addr", lis.Addr().String())
 	if err := grpcServer.Serve(lis); err != nil {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
@@ -130,14 +544,98 @@ func (s *NFSServer) Start() error {
 	return nil
 }
 
-// acquireWorker gets a worker from the pool or times out
+// prepare readies the export before it serves traffic
+func (s *NFSServer) prepare() error {
+	// Load the saved index first, so the check can correct it
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+
+	// Make sure the index matches the tree before clients rely on it
+	if s.config.CheckOnStartup {
+		if _, err := s.checkExport(context.Background(), true); err != nil {
+			return fmt.Errorf("startup check failed: %w", err)
+		}
+	}
+	s.warmOnStartup()
+
+	// Trees an earlier run did not get to delete wait for an operator
+	if err := s.adoptTrash(context.Background()); err != nil {
+		return fmt.Errorf("failed to list the trash: %w", err)
+	}
+	return nil
+}
+
+// newGRPCServer returns a gRPC server with the NFS service registered,
+// routing requests to every export
+func (s *NFSServer) newGRPCServer() *grpc.Server {
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unaryInterceptors()...),
+		grpc.ChainStreamInterceptor(s.streamInterceptors()...),
+	}
+	if len(s.exports.list) > 1 {
+		serverOpts = []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(s.routeUnary),
+			grpc.ChainStreamInterceptor(s.routeStream),
+		}
+	}
+	serverOpts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(telemetry.UnaryServerInterceptor(), requestIDInterceptor),
+		grpc.ChainStreamInterceptor(telemetry.StreamServerInterceptor(), requestIDStreamInterceptor),
+	}, serverOpts...)
+	serverOpts = append(serverOpts, s.transportOptions()...)
+	if codec := s.serverCodec(); codec != nil {
+		serverOpts = append(serverOpts, grpc.ForceServerCodecV2(codec))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
+	api.RegisterNFSServiceServer(grpcServer, s)
+	return grpcServer
+}
+
+// startBackground starts the export's periodic saves and cleanups
+func (s *NFSServer) startBackground() {
+	// Drop expired responses from the request cache
+	go s.dupCache.janitor()
+
+	// Keep the usage file current
+	if s.config.UsageFile != "" {
+		go s.usage.saveLoop()
+	}
+
+	// Keep the saved index current
+	if s.config.IndexDir != "" {
+		go s.indexSaveLoop()
+	}
+
+	// Keep the handle dump current
+	if s.config.HandleDumpFile != "" {
+		go s.handleDumpLoop()
+	}
+}
+
+// acquireWorker gets a worker from the pool, or fails once ctx is done. When
+// the server is overloaded it fails at once with a retry hint instead of
+// queueing.
 func (s *NFSServer) acquireWorker(ctx context.Context) error {
+	if err := s.backpressure.admit(cap(s.workerPool)); err != nil {
+		return err
+	}
+	s.backpressure.queued.Add(1)
+	defer s.backpressure.queued.Add(-1)
+
 	select {
 	case s.workerPool <- struct{}{}:
-		return nil
 	case <-ctx.Done():
 		return ctx.Err()
 	}
+
+	// A client that gave up or was interrupted while queued no longer
+	// wants the work done
+	if err := ctx.Err(); err != nil {
+		s.releaseWorker()
+		return err
+	}
+	return nil
 }
 
 // releaseWorker returns a worker to the pool
@@ -149,44 +647,84 @@ func (s *NFSServer) releaseWorker() {
 func (s *NFSServer) processRequest(ctx context.Context, op string, reqID string, clientAddr string, 
 	process func() (interface{}, error)) (interface{}, error) {
 	
-	// Log request
-	nfs.LogRequest(op, reqID, clientAddr)
+	// Requests arriving through the gRPC server carry an ID given by
+	// requestIDInterceptor; reqID names those called directly
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, reqID)
+	}
+	nfs.LogRequest(ctx, op, clientAddr, requestTag(ctx))
 	startTime := time.Now()
+	s.metrics.inFlight.Add(1)
+	defer s.metrics.inFlight.Add(-1)
 	
 	// Acquire worker
 	if err := s.acquireWorker(ctx); err != nil {
-		nfs.LogError(op, reqID, err)
+		nfs.LogError(ctx, op, err)
+		s.metrics.observe(op, nfs.MapErrorToStatus(err), time.Since(startTime))
 		return nil, err
 	}
 	defer s.releaseWorker()
 
 	// Execute the operation
+	workStart := time.Now()
 	result, err := process()
+	s.backpressure.observe(time.Since(workStart))
 	
 	// Log the result
-	duration := time.Since(startTime)
-	var status api.Status
+	status := api.Status_OK
+	if msg, ok := result.(proto.Message); ok {
+		status = responseStatus(msg)
+	}
 	if err != nil {
-		nfs.LogError(op, reqID, err)
+		nfs.LogError(ctx, op, err)
 		status = nfs.MapErrorToStatus(err)
-	} else {
-		status = api.Status_OK
 	}
 	
-	nfs.LogResponse(op, reqID, status, duration.String())
+	duration := time.Since(startTime)
+	s.metrics.observe(op, status, duration)
+	nfs.LogResponse(ctx, op, status, duration)
 	return result, err
 }
 
-// validateFileHandle verifies a file handle is valid
+// validateFileHandle verifies a file handle was issued by this server, or
+// one sharing its key, and returns the file system handle it carries
 func (s *NFSServer) validateFileHandle(handle []byte) ([]byte, error) {
-	log.Printf("Validating handle: %x (length: %d)", handle, len(handle))
-	if len(handle) < 16 {
+	if len(handle) < minHandleSize+handleMACSize {
 		return nil, nfs.NewNFSError(api.Status_ERR_BADHANDLE, "handle too short", nil)
 	}
-	
-	// In a real implementation, we would verify the handle signature here
-	// For now, we just return the handle
-	return handle, nil
+	inner, ok := s.verifyHandle(handle)
+	if !ok {
+		return nil, nfs.NewNFSError(api.Status_ERR_BADHANDLE, "handle signature mismatch", nil)
+	}
+	return inner, nil
+}
+
+// resolveHandle converts a file handle to a path. A handle missing from
+// the file system's index can take a walk of the whole tree to resolve, so
+// it is bounded by the request timeout as well as the caller's deadline.
+func (s *NFSServer) resolveHandle(ctx context.Context, handle []byte) (string, error) {
+	if s.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.RequestTimeout)
+		defer cancel()
+	}
+	inner, snapshot, ok := s.openHandle(handle)
+	if !ok {
+		return "", fs.NewError("resolveHandle", "", fs.ErrInvalidHandle)
+	}
+	path, ok := s.recoverHandle(ctx, inner)
+	if !ok {
+		var err error
+		if path, err = s.fileSystem.FileHandleToPath(ctx, inner); err != nil {
+			return "", err
+		}
+	}
+	// Snapshot handles read the file at the same path in the snapshot
+	if snapshot != "" {
+		path = snapshotPath(snapshot, path)
+	}
+	telemetry.SetPath(ctx, path)
+	return path, nil
 }
 
 // GetAttr implements the GetAttr RPC method
@@ -207,7 +745,7 @@ func (s *NFSServer) GetAttr(ctx context.Context, req *api.GetAttrRequest) (*api.
 		}
 		
 		// Convert file handle to path
-		path, err := s.fileSystem.FileHandleToPath(req.FileHandle)
+		path, err := s.resolveHandle(ctx, req.FileHandle)
 		if err != nil {
 			return &api.GetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
 		}
@@ -216,18 +754,20 @@ func (s *NFSServer) GetAttr(ctx context.Context, req *api.GetAttrRequest) (*api.
 		creds := nfs.ProtoCredsToFSCreds(req.Credentials)
 		
 		// Apply root squashing if enabled
-		if s.config.EnableRootSquash && creds.UID == 0 {
-			creds.UID = s.config.AnonUID
-			creds.GID = s.config.AnonGID
-		}
+		s.policy().squash(&creds)
 		
 		// Check read permission
 		if err := s.fileSystem.Access(ctx, path, fs.FileMode(4), creds); err != nil {
 			return &api.GetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
 		}
 		
+		// A client caching writes under a delegation holds the true
+		// size and times
+		s.fileDelegations.recall(ctx, s.fenceKey(req.FileHandle), false)
+		
 		// Get file attributes
 		fileInfo, err := s.fileSystem.GetAttr(ctx, path)
+		s.shadow.compareAttr("GetAttr", path, fileInfo, err)
 		if err != nil {
 			return &api.GetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
 		}
@@ -241,7 +781,15 @@ func (s *NFSServer) GetAttr(ctx context.Context, req *api.GetAttrRequest) (*api.
 			Attributes: attrs,
 		}
 		
-		log.Printf("Sending response: %+v", response)
+		// Counting entries is cheap for the backend but not free, so only on request
+		if req.WantEntryCount && fileInfo.Type == fs.FileTypeDirectory {
+			count, err := s.fileSystem.EntryCount(ctx, path)
+			if err != nil {
+				return &api.GetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+			}
+			response.EntryCount = count
+		}
+		
 		return response, nil
 	})
 	
@@ -270,7 +818,7 @@ func (s *NFSServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.Lo
         }
         
         // Convert directory handle to path
-        dirPath, err := s.fileSystem.FileHandleToPath(req.DirectoryHandle)
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
         if err != nil {
             return &api.LookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -279,10 +827,7 @@ func (s *NFSServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.Lo
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        s.policy().squash(&creds)
         
         // Check directory access permission
         if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(5), creds); err != nil { // 5 = read + execute
@@ -307,8 +852,12 @@ func (s *NFSServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.Lo
                 }
             }
         default:
+            if err := s.validateName(req.Name); err != nil {
+                return &api.LookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+            
             // Look up the file in the directory
-            targetPath, _ , err = s.fileSystem.Lookup(ctx, dirPath, req.Name)
+            targetPath, _ , err = s.lookupVisible(ctx, dirPath, req.Name, creds)
             if err != nil {
                 return &api.LookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
             }
@@ -316,6 +865,7 @@ func (s *NFSServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.Lo
         
         // Get file attributes
         fileInfo, err := s.fileSystem.GetAttr(ctx, targetPath)
+        s.shadow.compareAttr("Lookup", targetPath, fileInfo, err)
         if err != nil {
             return &api.LookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -330,7 +880,7 @@ func (s *NFSServer) Lookup(ctx context.Context, req *api.LookupRequest) (*api.Lo
         }
         
         // Generate file handle for the target
-        fileHandle, err := s.fileSystem.PathToFileHandle(targetPath)
+        fileHandle, err := s.issueHandle(ctx, targetPath)
         if err != nil {
             return &api.LookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -372,7 +922,7 @@ func (s *NFSServer) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadRe
         }
         
         // Convert file handle to path
-        path, err := s.fileSystem.FileHandleToPath(req.FileHandle)
+        path, err := s.resolveHandle(ctx, req.FileHandle)
         if err != nil {
             return &api.ReadResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -381,16 +931,17 @@ func (s *NFSServer) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadRe
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        s.policy().squash(&creds)
         
         // Check read permission
         if err := s.fileSystem.Access(ctx, path, fs.FileMode(4), creds); err != nil { // 4 = read
             return &api.ReadResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
+        // Writes cached under another client's delegation must reach the
+        // file first
+        s.fileDelegations.recall(ctx, s.fenceKey(req.FileHandle), false)
+        
         // Get file attributes
         fileInfo, err := s.fileSystem.GetAttr(ctx, path)
         if err != nil {
@@ -408,11 +959,25 @@ func (s *NFSServer) Read(ctx context.Context, req *api.ReadRequest) (*api.ReadRe
             count = uint32(s.config.MaxReadSize)
         }
         
+        // Read into a recycled buffer when the file system supports it,
+        // unless the data is still to be compared once the buffer is reused
+        if reader, ok := fs.As[fs.BufferReader](s.fileSystem); ok && s.payloads != nil && s.shadow == nil {
+            resp, err := s.pooledRead(ctx, reader, path, int64(req.Offset), int(count))
+            if resp.GetStatus() == api.Status_OK {
+                s.usage.record(creds.UID, len(resp.Data), false)
+                s.metrics.transferred(len(resp.Data), false)
+            }
+            return resp, err
+        }
+        
         // Read data from file
         data, eof, err := s.fileSystem.Read(ctx, path, int64(req.Offset), int(count))
+        s.shadow.compareRead(path, int64(req.Offset), int(count), data, eof, err)
         if err != nil {
             return &api.ReadResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
+        s.usage.record(creds.UID, len(data), false)
+        s.metrics.transferred(len(data), false)
         
         // Update file attributes after read
         newFileInfo, _ := s.fileSystem.GetAttr(ctx, path)
@@ -452,25 +1017,29 @@ func (s *NFSServer) Write(ctx context.Context, req *api.WriteRequest) (*api.Writ
         }
         
         // Convert file handle to path
-        path, err := s.fileSystem.FileHandleToPath(req.FileHandle)
+        path, err := s.resolveHandle(ctx, req.FileHandle)
         if err != nil {
             return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
+        // Judge the whole write, including replays from the request
+        // cache, by one export policy
+        policy := s.policy()
+        
         // Get credentials
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        policy.squash(&creds)
         
         // Check write permission
         if err := s.fileSystem.Access(ctx, path, fs.FileMode(2), creds); err != nil { // 2 = write
             return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
+        // Other clients may no longer cache the file
+        s.fileDelegations.recall(ctx, s.fenceKey(req.FileHandle), true)
+        
         // Get file attributes
         fileInfo, err := s.fileSystem.GetAttr(ctx, path)
         if err != nil {
@@ -481,6 +1050,7 @@ func (s *NFSServer) Write(ctx context.Context, req *api.WriteRequest) (*api.Writ
         if fileInfo.Type != fs.FileTypeRegular {
             return &api.WriteResponse{Status: api.Status_ERR_ISDIR}, nil
         }
+        before := wccAttributes(fileInfo)
         
         // Limit write size for security
         dataSize := len(req.Data)
@@ -488,29 +1058,70 @@ func (s *NFSServer) Write(ctx context.Context, req *api.WriteRequest) (*api.Writ
             return &api.WriteResponse{Status: api.Status_ERR_FBIG}, nil
         }
         
-        // Check for idempotent write using request ID
-        cacheKey := fmt.Sprintf("write-%s-%d-%d", string(req.FileHandle), req.Offset, crc32.ChecksumIEEE(req.Data))
-        if cachedResp, found := s.getCachedResponse(cacheKey); found {
-            log.Printf("Found cached response for write operation: %s", cacheKey)
-            return cachedResp, nil
+        // Reject writers holding a superseded fencing epoch. The fence is
+        // held until the write completes, so AcquireFence cannot return
+        // while an older write is still in progress.
+        if req.FenceEpoch != 0 {
+            release, ok := s.fences.admit(s.fenceKey(req.FileHandle), req.FenceEpoch)
+            if !ok {
+                return &api.WriteResponse{Status: api.Status_ERR_FENCED}, nil
+            }
+            defer release()
+        }
+        
+        // Check for idempotent write using request ID. Appends are never
+        // replayed from the cache: two identical appends are both wanted.
+        dataCRC := crc32.ChecksumIEEE(req.Data)
+        cacheKey := fmt.Sprintf("write-%s-%d-%d", string(req.FileHandle), req.Offset, dataCRC)
+        if !req.Append {
+            if cachedResp, found := s.getCachedResponse(cacheKey); found {
+                slog.DebugContext(ctx, "Replayed cached write response", "offset", req.Offset)
+                return cachedResp, nil
+            }
         }
         
         // Determine if synchronous write is required
         sync := req.Stability == 2 // FILE_SYNC = 2
         
+        // For appends, resolve the end of file and write while holding the
+        // file's append lock, so concurrent appenders never overlap
+        offset := int64(req.Offset)
+        if req.Append {
+            unlock := s.appendLocks.lock(path)
+            defer unlock()
+            
+            current, err := s.fileSystem.GetAttr(ctx, path)
+            if err != nil {
+                return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+            offset = current.Size
+            before = wccAttributes(current)
+        }
+        
         // Write data to file
-        bytesWritten, err := s.fileSystem.Write(ctx, path, int64(req.Offset), req.Data, sync)
+        bytesWritten, err := s.fileSystem.Write(ctx, path, offset, req.Data, sync)
         if err != nil {
             return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
-        
-        // Generate write verifier (timestamp-based for simplicity)
-        verifier := uint64(time.Now().UnixNano())
+        s.watchers.notify(api.ChangeType_CHANGE_MODIFY, path, "")
+        s.usage.record(creds.UID, bytesWritten, true)
+        s.metrics.transferred(bytesWritten, true)
+        
+        // On verifying exports, read FILE_SYNC data back before acknowledging
+        if sync && s.config.VerifyWrites {
+            want := dataCRC
+            if bytesWritten != len(req.Data) {
+                want = crc32.ChecksumIEEE(req.Data[:bytesWritten])
+            }
+            if err := s.verifyWrite(ctx, path, offset, bytesWritten, want); err != nil {
+                return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+        }
         
         // Sync to disk if requested
         if req.Stability == 1 { // DATA_SYNC = 1
             // For DATA_SYNC, we need to ensure the data is on stable storage
-            if err := s.fileSystem.Commit(ctx, path); err != nil {
+            if err := s.fileSystem.Commit(ctx, path, offset, int64(bytesWritten)); err != nil {
                 return &api.WriteResponse{Status: nfs.MapErrorToStatus(err)}, nil
             }
         }
@@ -524,12 +1135,16 @@ func (s *NFSServer) Write(ctx context.Context, req *api.WriteRequest) (*api.Writ
             Status:     api.Status_OK,
             Count:      uint32(bytesWritten),
             Stability:  req.Stability, // Return the same stability level that was requested
-            Verifier:   verifier,
+            Verifier:   s.writeVerifier,
             Attributes: attrs,
+            Offset:     uint64(offset),
+            Before:     before,
         }
         
         // Cache the response for idempotent operations
-        s.cacheResponse(cacheKey, resp, 5*time.Minute)
+        if !req.Append {
+            s.cacheResponse(policy.epoch, cacheKey, resp, 5*time.Minute, false)
+        }
         
         return resp, nil
     })
@@ -541,71 +1156,165 @@ func (s *NFSServer) Write(ctx context.Context, req *api.WriteRequest) (*api.Writ
     return result.(*api.WriteResponse), nil
 }
 
-// getCachedResponse retrieves a cached response if available
-func (s *NFSServer) getCachedResponse(key string) (interface{}, bool) {
-    s.reqCacheMu.RLock()
-    defer s.reqCacheMu.RUnlock()
+// WriteStream implements the WriteStream RPC method. Each chunk is carried
+// out as a Write of its own, with the handle, stability and fencing epoch of
+// the first, so it is checked, cached and verified like one. The stream
+// stops at the first chunk that fails.
+func (s *NFSServer) WriteStream(stream api.NFSService_WriteStreamServer) error {
+    ctx := stream.Context()
     
-    if resp, ok := s.reqCache[key]; ok {
-        return resp, true
+    var first *api.WriteStreamRequest
+    resp := &api.WriteStreamResponse{Status: api.Status_OK, Verifier: s.writeVerifier}
+    for {
+        chunk, err := stream.Recv()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if first == nil {
+            first = chunk
+            resp.Stability = first.Stability
+        }
+        
+        written, err := s.Write(ctx, &api.WriteRequest{
+            FileHandle:  first.FileHandle,
+            Credentials: chunk.Credentials,
+            Offset:      chunk.Offset,
+            Data:        chunk.Data,
+            Stability:   first.Stability,
+            FenceEpoch:  first.FenceEpoch,
+        })
+        if err != nil {
+            return err
+        }
+        if written.Status != api.Status_OK {
+            resp.Status = written.Status
+            resp.FailedOffset = chunk.Offset
+            break
+        }
+        if resp.Before == nil {
+            resp.Before = written.Before
+        }
+        resp.Count += uint64(written.Count)
+        resp.Stability = written.Stability
+        resp.Verifier = written.Verifier
+        resp.Attributes = written.Attributes
     }
-    return nil, false
-}
-
-// cacheResponse stores a response in the cache with an expiration time
-func (s *NFSServer) cacheResponse(key string, response interface{}, ttl time.Duration) {
-    s.reqCacheMu.Lock()
-    defer s.reqCacheMu.Unlock()
     
-    s.reqCache[key] = response
-    
-    // Set up automatic cleanup after TTL expires
-    time.AfterFunc(ttl, func() {
-        s.reqCacheMu.Lock()
-        delete(s.reqCache, key)
-        s.reqCacheMu.Unlock()
-    })
+    return stream.SendAndClose(resp)
 }
 
-// ReadDir implements the ReadDir RPC method
-func (s *NFSServer) ReadDir(ctx context.Context, req *api.ReadDirRequest) (*api.ReadDirResponse, error) {
-    reqID := fmt.Sprintf("readdir-%d", time.Now().UnixNano())
+// Commit implements the Commit RPC method. Flushing data changes nothing a
+// client can observe, so no permission beyond holding the handle is needed.
+func (s *NFSServer) Commit(ctx context.Context, req *api.CommitRequest) (*api.CommitResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("commit-%d", time.Now().UnixNano())
     clientAddr := "unknown"
     if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
         clientAddr = (*peer).String()
     }
     
-    result, err := s.processRequest(ctx, "ReadDir", reqID, clientAddr, func() (interface{}, error) {
-        // Validate directory handle
-        _, err := s.validateFileHandle(req.DirectoryHandle)
-        if err != nil {
-            return &api.ReadDirResponse{Status: api.Status_ERR_BADHANDLE}, nil
+    // Process the request
+    result, err := s.processRequest(ctx, "Commit", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.CommitResponse{Status: api.Status_ERR_BADHANDLE}, nil
         }
         
-        // Convert directory handle to path
-        dirPath, err := s.fileSystem.FileHandleToPath(req.DirectoryHandle)
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
         if err != nil {
-            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            return &api.CommitResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
-        // Get credentials
-        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
-        
-        // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
+        // Only regular files hold data to flush
+        info, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.CommitResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        if info.Type == fs.FileTypeDirectory {
+            return &api.CommitResponse{Status: api.Status_ERR_ISDIR}, nil
+        }
+        if info.Type != fs.FileTypeRegular {
+            return &api.CommitResponse{Status: api.Status_ERR_INVAL}, nil
         }
         
-        // Check directory access permission
-        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(4), creds); err != nil { // 4 = read
-            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        if err := s.fileSystem.Commit(ctx, path, int64(req.Offset), int64(req.Count)); err != nil {
+            return &api.CommitResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
-        // Get file info to verify it's a directory
-        fileInfo, err := s.fileSystem.GetAttr(ctx, dirPath)
-        if err != nil {
-            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        resp := &api.CommitResponse{Status: api.Status_OK, Verifier: s.writeVerifier}
+        if info, err := s.fileSystem.GetAttr(ctx, path); err == nil {
+            resp.Attributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.CommitResponse), nil
+}
+
+// getCachedResponse retrieves a cached response if available
+func (s *NFSServer) getCachedResponse(key string) (interface{}, bool) {
+    return s.dupCache.get(key)
+}
+
+// cacheResponse stores a response in the cache with an expiration time,
+// and in the cache's journal if durable, for requests that must not be
+// carried out again after a restart. Responses computed under an export
+// policy that has since been replaced are not cached.
+func (s *NFSServer) cacheResponse(epoch uint64, key string, response proto.Message, ttl time.Duration, durable bool) {
+    s.dupCache.mu.Lock()
+    defer s.dupCache.mu.Unlock()
+    
+    if epoch != s.policy().epoch {
+        return
+    }
+    s.dupCache.putLocked(epoch, key, response, ttl, durable)
+}
+
+// ReadDir implements the ReadDir RPC method
+func (s *NFSServer) ReadDir(ctx context.Context, req *api.ReadDirRequest) (*api.ReadDirResponse, error) {
+    reqID := fmt.Sprintf("readdir-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    result, err := s.processRequest(ctx, "ReadDir", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        _, err := s.validateFileHandle(req.DirectoryHandle)
+        if err != nil {
+            return &api.ReadDirResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check directory access permission
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(4), creds); err != nil { // 4 = read
+            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get file info to verify it's a directory
+        fileInfo, err := s.fileSystem.GetAttr(ctx, dirPath)
+        if err != nil {
+            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
         if fileInfo.Type != fs.FileTypeDirectory {
@@ -620,35 +1329,63 @@ func (s *NFSServer) ReadDir(ctx context.Context, req *api.ReadDirRequest) (*api.
             maxCount = 10000 // Hard upper limit
         }
         
-        // Read directory entries
-        entries, _, err := s.fileSystem.ReadDir(ctx, dirPath, int64(req.Cookie), maxCount)
+        // Read directory entries, dropping hidden names and reading on past
+        // pages holding nothing else
+        verifier, err := unsortedVerifier(fileInfo, req.Cookie, req.CookieVerifier, req.Sorted)
+        if err != nil {
+            return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        entries, complete, err := s.visiblePage(creds, req.Cookie, func(cookie uint64) ([]fs.DirEntry, bool, error) {
+            if req.Sorted {
+                page, next, complete, err := s.sortedEntries(ctx, dirPath, fileInfo, cookie, verifier, maxCount, false)
+                verifier = next
+                return page, complete, err
+            }
+            entries, _, err := s.fileSystem.ReadDir(ctx, dirPath, int64(cookie), maxCount)
+            if cookie == 0 && len(entries) < maxCount {
+                // Only whole listings compare, the backends' cookies differing
+                s.shadow.compareListing("ReadDir", dirPath, entries, err)
+            }
+            return entries, len(entries) < maxCount, err
+        })
         if err != nil {
             return &api.ReadDirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
-        // Convert file system entries to protocol entries
-        protoEntries := make([]*api.DirEntry, len(entries))
-        for i, entry := range entries {
-            protoEntries[i] = &api.DirEntry{
+        resp := &api.ReadDirResponse{
+            Status:         api.Status_OK,
+            CookieVerifier: verifier,
+            Eof:            true,
+        }
+        
+        // Convert file system entries to protocol entries until the byte
+        // budget is spent; the client continues from the last cookie
+        budget := s.readDirBudget(req.Maxcount)
+        used := proto.Size(resp)
+        truncated := false
+        resp.Entries = make([]*api.DirEntry, 0, len(entries))
+        for _, entry := range entries {
+            protoEntry := &api.DirEntry{
                 FileId: entry.FileId,
                 Name:   entry.Name,
                 Cookie: uint64(entry.Cookie),
             }
+            size := entrySize(protoEntry)
+            if used+size > budget {
+                truncated = true
+                break
+            }
+            used += size
+            resp.Entries = append(resp.Entries, protoEntry)
+        }
+        if truncated && len(resp.Entries) == 0 {
+            return &api.ReadDirResponse{Status: api.Status_ERR_TOOSMALL}, nil
         }
-        
-        // Generate a cookie verifier (simple timestamp-based)
-        cookieVerifier := uint64(time.Now().UnixNano())
         
         // Check if we've reached the end of the directory
-        eof := len(entries) < maxCount
+        resp.Eof = !truncated && complete
         
-        // Return the response
-        return &api.ReadDirResponse{
-            Status:         api.Status_OK,
-            CookieVerifier: cookieVerifier,
-            Entries:        protoEntries,
-            Eof:            eof,
-        }, nil
+        return resp, nil
     })
     
     if err != nil {
@@ -658,6 +1395,132 @@ func (s *NFSServer) ReadDir(ctx context.Context, req *api.ReadDirRequest) (*api.
     return result.(*api.ReadDirResponse), nil
 }
 
+// ReadDirPlus implements the ReadDirPlus RPC method. It is ReadDir with the
+// handle and attributes of each entry, saving clients a Lookup per entry.
+func (s *NFSServer) ReadDirPlus(ctx context.Context, req *api.ReadDirPlusRequest) (*api.ReadDirPlusResponse, error) {
+    reqID := fmt.Sprintf("readdirplus-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+
+    result, err := s.processRequest(ctx, "ReadDirPlus", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        _, err := s.validateFileHandle(req.DirectoryHandle)
+        if err != nil {
+            return &api.ReadDirPlusResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.ReadDirPlusResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+
+        dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath)
+        if err != nil {
+            return &api.ReadDirPlusResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+
+        if dirInfo.Type != fs.FileTypeDirectory {
+            return &api.ReadDirPlusResponse{Status: api.Status_ERR_NOTDIR}, nil
+        }
+
+        // Listing needs read permission and handing out handles is a
+        // lookup, which needs search permission
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(5), creds); err != nil { // 5 = read + execute
+            return &api.ReadDirPlusResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+
+        // Same limits as ReadDir
+        maxCount := int(req.Count)
+        if maxCount <= 0 {
+            maxCount = 1000
+        } else if maxCount > 10000 {
+            maxCount = 10000
+        }
+
+        verifier, err := unsortedVerifier(dirInfo, req.Cookie, req.CookieVerifier, req.Sorted)
+        if err != nil {
+            return &api.ReadDirPlusResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        entries, complete, err := s.visiblePage(creds, req.Cookie, func(cookie uint64) ([]fs.DirEntry, bool, error) {
+            if req.Sorted {
+                page, next, complete, err := s.sortedEntries(ctx, dirPath, dirInfo, cookie, verifier, maxCount, true)
+                verifier = next
+                return page, complete, err
+            }
+            entries, _, err := s.fileSystem.ReadDirPlus(ctx, dirPath, int64(cookie), maxCount)
+            if cookie == 0 && len(entries) < maxCount {
+                // Only whole listings compare, the backends' cookies differing
+                s.shadow.compareListing("ReadDirPlus", dirPath, entries, err)
+            }
+            return entries, len(entries) < maxCount, err
+        })
+        if err != nil {
+            return &api.ReadDirPlusResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+
+        resp := &api.ReadDirPlusResponse{
+            Status:         api.Status_OK,
+            CookieVerifier: verifier,
+            Eof:            true,
+            DirAttributes:  nfs.FSInfoToProtoAttributes(dirInfo),
+        }
+
+        // As in NFSv3, dircount bounds the directory information alone
+        // and maxcount the whole response
+        budget := s.readDirBudget(req.Maxcount)
+        used, dirUsed := proto.Size(resp), 0
+        truncated := false
+        resp.Entries = make([]*api.DirEntryPlus, 0, len(entries))
+        for _, entry := range entries {
+            if entry.Attributes == nil {
+                continue
+            }
+            fileHandle, err := s.issueHandle(ctx, filepath.Join(dirPath, entry.Name))
+            if err != nil {
+                continue
+            }
+            protoEntry := &api.DirEntryPlus{
+                FileId:     entry.FileId,
+                Name:       entry.Name,
+                Cookie:     uint64(entry.Cookie),
+                FileHandle: fileHandle,
+                Attributes: nfs.FSInfoToProtoAttributes(*entry.Attributes),
+            }
+            size := entrySize(protoEntry)
+            dirSize := entrySize(&api.DirEntry{FileId: entry.FileId, Name: entry.Name, Cookie: uint64(entry.Cookie)})
+            if used+size > budget || (req.Dircount > 0 && dirUsed+dirSize > int(req.Dircount)) {
+                truncated = true
+                break
+            }
+            used += size
+            dirUsed += dirSize
+            resp.Entries = append(resp.Entries, protoEntry)
+        }
+        if truncated && len(resp.Entries) == 0 {
+            return &api.ReadDirPlusResponse{Status: api.Status_ERR_TOOSMALL}, nil
+        }
+
+        resp.Eof = !truncated && complete
+
+        return resp, nil
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    return result.(*api.ReadDirPlusResponse), nil
+}
+
 // Create implements the Create RPC method
 func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.CreateResponse, error) {
     // Create a unique request ID and get client address
@@ -676,25 +1539,31 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
         }
         
         // Convert directory handle to path
-        dirPath, err := s.fileSystem.FileHandleToPath(req.DirectoryHandle)
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
         if err != nil {
             return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
         
+        // Judge the whole create, including replays from the request
+        // cache, by one export policy
+        policy := s.policy()
+        
         // Get credentials
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        policy.squash(&creds)
         
         // Check write permission on directory
         // if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(2|1), creds); err != nil {
         //     return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
         // }
         
+        // Check the name against the configured limits
+        if err := s.validateNewPath(dirPath, req.Name); err != nil {
+            return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
         // Convert requested attributes to filesystem attributes
         attr := nfs.ProtoAttributesToFSAttr(req.Attributes)
         
@@ -712,7 +1581,7 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
             // If we have this verifier cached for this path, return the cached response
             cacheKey := fmt.Sprintf("create-excl-%s-%d", targetPath, req.Verifier)
             if cachedResp, found := s.getCachedResponse(cacheKey); found {
-                log.Printf("Found cached exclusive create response: %s", cacheKey)
+                slog.DebugContext(ctx, "Replayed cached exclusive create response", "path", targetPath)
                 return cachedResp, nil
             }
             
@@ -725,7 +1594,7 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
                 }
                 
                 // For EXCLUSIVE mode, create a new response and cache it
-                fileHandle, err := s.fileSystem.PathToFileHandle(targetPath)
+                fileHandle, err := s.issueHandle(ctx, targetPath)
                 if err != nil {
                     return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
                 }
@@ -751,20 +1620,23 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
                 }
                 
                 // Cache the response for this exclusive create
-                s.cacheResponse(cacheKey, resp, 10*time.Minute)
+                s.cacheResponse(policy.epoch, cacheKey, resp, 10*time.Minute, true)
                 
                 return resp, nil
             }
         }
         
         // Create the file
+        dirBefore := s.wccBefore(ctx, dirPath)
         filePath, fileInfo, err := s.fileSystem.Create(ctx, dirPath, req.Name, attr, exclusive)
         if err != nil {
             return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_CREATE, filePath, "")
         
         // Generate file handle for the new file
-        fileHandle, err := s.fileSystem.PathToFileHandle(filePath)
+        fileHandle, err := s.issueHandle(ctx, filePath)
         if err != nil {
             return &api.CreateResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -779,12 +1651,13 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
         // If EXCLUSIVE mode, cache the response
         if req.Mode == api.CreateMode_EXCLUSIVE {
             cacheKey := fmt.Sprintf("create-excl-%s-%d", filepath.Join(dirPath, req.Name), req.Verifier)
-            s.cacheResponse(cacheKey, &api.CreateResponse{
+            s.cacheResponse(policy.epoch, cacheKey, &api.CreateResponse{
                 Status:        api.Status_OK,
                 FileHandle:    fileHandle,
                 Attributes:    nfs.FSInfoToProtoAttributes(fileInfo),
                 DirAttributes: dirAttrs,
-            }, 10*time.Minute)
+                DirBefore:     dirBefore,
+            }, 10*time.Minute, true)
         }
         
         // Return successful response
@@ -793,6 +1666,7 @@ func (s *NFSServer) Create(ctx context.Context, req *api.CreateRequest) (*api.Cr
             FileHandle:    fileHandle,
             Attributes:    nfs.FSInfoToProtoAttributes(fileInfo),
             DirAttributes: dirAttrs,
+            DirBefore:     dirBefore,
         }, nil
     })
     
@@ -821,7 +1695,7 @@ func (s *NFSServer) Mkdir(ctx context.Context, req *api.MkdirRequest) (*api.Mkdi
         }
         
         // Convert directory handle to path
-        dirPath, err := s.fileSystem.FileHandleToPath(req.DirectoryHandle)
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
         if err != nil {
             return &api.MkdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -830,27 +1704,32 @@ func (s *NFSServer) Mkdir(ctx context.Context, req *api.MkdirRequest) (*api.Mkdi
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        s.policy().squash(&creds)
         
         // Check write permission on parent directory
         // if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(2|1), creds); err != nil {
         //     return &api.MkdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         // }
         
+        // Check the name against the configured limits
+        if err := s.validateNewPath(dirPath, req.Name); err != nil {
+            return &api.MkdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
         // Convert requested attributes to filesystem attributes
         attr := nfs.ProtoAttributesToFSAttr(req.Attributes)
         
         // Create the directory
+        dirBefore := s.wccBefore(ctx, dirPath)
         newDirPath, dirInfo, err := s.fileSystem.Mkdir(ctx, dirPath, req.Name, attr)
         if err != nil {
             return &api.MkdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_CREATE, newDirPath, "")
         
         // Generate directory handle for the new directory
-        dirHandle, err := s.fileSystem.PathToFileHandle(newDirPath)
+        dirHandle, err := s.issueHandle(ctx, newDirPath)
         if err != nil {
             return &api.MkdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -868,6 +1747,7 @@ func (s *NFSServer) Mkdir(ctx context.Context, req *api.MkdirRequest) (*api.Mkdi
             DirectoryHandle: dirHandle,
             Attributes:     nfs.FSInfoToProtoAttributes(dirInfo),
             DirAttributes:  parentAttrs,
+            DirBefore:      dirBefore,
         }, nil
     })
     
@@ -889,17 +1769,20 @@ func (s *NFSServer) GetRootHandle(ctx context.Context, req *api.GetRootHandleReq
     
     // Process the request
     result, err := s.processRequest(ctx, "GetRootHandle", reqID, clientAddr, func() (interface{}, error) {
+        // Requests naming another export are routed to it, so a name
+        // still not this export's is unknown
+        if req.Export != "" && req.Export != s.config.ExportName {
+            return &api.GetRootHandleResponse{Status: api.Status_ERR_NOENT}, nil
+        }
+        
         // Get credentials
         creds := nfs.ProtoCredsToFSCreds(req.Credentials)
         
         // Apply root squashing if enabled
-        if s.config.EnableRootSquash && creds.UID == 0 {
-            creds.UID = s.config.AnonUID
-            creds.GID = s.config.AnonGID
-        }
+        s.policy().squash(&creds)
         
         // Get root directory handle
-        rootHandle, err := s.fileSystem.PathToFileHandle("/")
+        rootHandle, err := s.issueHandle(ctx, "/")
         if err != nil {
             return &api.GetRootHandleResponse{Status: nfs.MapErrorToStatus(err)}, nil
         }
@@ -926,4 +1809,1906 @@ func (s *NFSServer) GetRootHandle(ctx context.Context, req *api.GetRootHandleReq
     }
     
     return result.(*api.GetRootHandleResponse), nil
-}
\ No newline at end of file
+}
+// Access implements the Access RPC method
+func (s *NFSServer) Access(ctx context.Context, req *api.AccessRequest) (*api.AccessResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("access-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Access", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        _, err := s.validateFileHandle(req.FileHandle)
+        if err != nil {
+            return &api.AccessResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.AccessResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled, so the answer matches what
+        // the other operations will actually allow
+        s.policy().squash(&creds)
+        
+        // Get file attributes
+        fileInfo, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.AccessResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        attrs := nfs.FSInfoToProtoAttributes(fileInfo)
+        
+        // A rights check reports what is granted rather than failing
+        if req.Access != 0 {
+            isDir := fileInfo.Type == fs.FileTypeDirectory
+            return &api.AccessResponse{
+                Status:     api.Status_OK,
+                Attributes: attrs,
+                Granted:    s.grantedAccess(ctx, path, isDir, req.Access, creds),
+            }, nil
+        }
+        
+        // Check the requested permission bits
+        if err := s.fileSystem.Access(ctx, path, fs.FileMode(req.Mode&7), creds); err != nil {
+            return &api.AccessResponse{
+                Status:     nfs.MapErrorToStatus(err),
+                Attributes: attrs,
+            }, nil
+        }
+        
+        return &api.AccessResponse{
+            Status:     api.Status_OK,
+            Attributes: attrs,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.AccessResponse), nil
+}
+
+// FsStat implements the FsStat RPC method
+func (s *NFSServer) FsStat(ctx context.Context, req *api.FsStatRequest) (*api.FsStatResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("fsstat-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "FsStat", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        _, err := s.validateFileHandle(req.FileHandle)
+        if err != nil {
+            return &api.FsStatResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Make sure the handle still refers to something on this file system
+        if _, err := s.resolveHandle(ctx, req.FileHandle); err != nil {
+            return &api.FsStatResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        stat, err := s.fileSystem.StatFS(ctx)
+        if err != nil {
+            return &api.FsStatResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.FsStatResponse{
+            Status:     api.Status_OK,
+            TotalBytes: stat.TotalBytes,
+            FreeBytes:  stat.FreeBytes,
+            AvailBytes: stat.AvailBytes,
+            TotalFiles: stat.TotalFiles,
+            FreeFiles:  stat.FreeFiles,
+            NameMax:    stat.NameMaxLength,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.FsStatResponse), nil
+}
+
+// GetQuota implements the GetQuota RPC method
+func (s *NFSServer) GetQuota(ctx context.Context, req *api.GetQuotaRequest) (*api.GetQuotaResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("getquota-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "GetQuota", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        _, err := s.validateFileHandle(req.FileHandle)
+        if err != nil {
+            return &api.GetQuotaResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.GetQuotaResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials, squashing root like every other operation
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        s.policy().squash(&creds)
+        
+        // Only some file systems enforce quotas
+        reporter, ok := fs.As[fs.QuotaReporter](s.fileSystem)
+        if !ok {
+            return &api.GetQuotaResponse{Status: api.Status_ERR_NOTSUPP}, nil
+        }
+        
+        usage, err := reporter.Quota(ctx, path, creds.UID)
+        if err != nil {
+            return &api.GetQuotaResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.GetQuotaResponse{
+            Status:     api.Status_OK,
+            Uid:        creds.UID,
+            BytesUsed:  usage.BytesUsed,
+            BytesLimit: usage.BytesLimit,
+            FilesUsed:  usage.FilesUsed,
+            FilesLimit: usage.FilesLimit,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.GetQuotaResponse), nil
+}
+
+// Checksum implements the Checksum RPC method
+func (s *NFSServer) Checksum(ctx context.Context, req *api.ChecksumRequest) (*api.ChecksumResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("checksum-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Checksum", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        _, err := s.validateFileHandle(req.FileHandle)
+        if err != nil {
+            return &api.ChecksumResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.ChecksumResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Hashing reveals content, so require read permission
+        if err := s.fileSystem.Access(ctx, path, fs.FileMode(4), creds); err != nil { // 4 = read
+            return &api.ChecksumResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get file attributes
+        fileInfo, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.ChecksumResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Only regular files have data to hash
+        if fileInfo.Type != fs.FileTypeRegular {
+            return &api.ChecksumResponse{Status: api.Status_ERR_ISDIR}, nil
+        }
+        
+        h, err := newChecksumHash(req.Algorithm)
+        if err != nil {
+            return &api.ChecksumResponse{Status: api.Status_ERR_INVAL}, nil
+        }
+        
+        sum, hashed, err := s.checksumRange(ctx, path, h, req.Offset, req.Length)
+        if err != nil {
+            return &api.ChecksumResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.ChecksumResponse{
+            Status:      api.Status_OK,
+            Checksum:    sum,
+            BytesHashed: hashed,
+            Attributes:  nfs.FSInfoToProtoAttributes(fileInfo),
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.ChecksumResponse), nil
+}
+
+// RemoveTree implements the RemoveTree RPC method
+func (s *NFSServer) RemoveTree(req *api.RemoveTreeRequest, stream api.NFSService_RemoveTreeServer) error {
+    ctx := stream.Context()
+    
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("removetree-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "RemoveTree", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        _, err := s.validateFileHandle(req.DirectoryHandle)
+        if err != nil {
+            return &api.RemoveTreeProgress{Status: api.Status_ERR_BADHANDLE, Done: true}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.RemoveTreeProgress{Status: nfs.MapErrorToStatus(err), Done: true}, nil
+        }
+        
+        // Refuse names that would escape the parent directory
+        if err := s.validateName(req.Name); err != nil {
+            return &api.RemoveTreeProgress{Status: nfs.MapErrorToStatus(err), Done: true}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Removing the subtree itself needs write permission on the parent
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.RemoveTreeProgress{Status: nfs.MapErrorToStatus(err), Done: true}, nil
+        }
+        
+        path, info, err := s.lookupVisible(ctx, dirPath, req.Name, creds)
+        if err != nil {
+            return &api.RemoveTreeProgress{Status: nfs.MapErrorToStatus(err), Done: true}, nil
+        }
+        if info.Type != fs.FileTypeDirectory {
+            return &api.RemoveTreeProgress{Status: api.Status_ERR_NOTDIR, Done: true}, nil
+        }
+        
+        // A background removal only takes the tree out of the namespace;
+        // it is deleted later, with the same credentials
+        if req.Background {
+            id, err := s.trashTree(ctx, path, creds)
+            if err != nil {
+                return &api.RemoveTreeProgress{Status: nfs.MapErrorToStatus(err), Done: true}, nil
+            }
+            s.delegations.recallTree(path)
+            s.delegations.recall(dirPath)
+            s.watchers.notify(api.ChangeType_CHANGE_DELETE, path, "")
+            return &api.RemoveTreeProgress{Status: api.Status_OK, Done: true, TrashId: id}, nil
+        }
+        
+        // Clients may ask for less parallelism, never more
+        concurrency := s.config.MaxRemoveTreeConcurrency
+        if req.Concurrency > 0 && int(req.Concurrency) < concurrency {
+            concurrency = int(req.Concurrency)
+        }
+        remover := newTreeRemover(s.fileSystem, creds, concurrency, s.metrics)
+        
+        // Stream progress while the deletion runs
+        done := make(chan struct{})
+        var sender sync.WaitGroup
+        sender.Add(1)
+        go func() {
+            defer sender.Done()
+            ticker := time.NewTicker(removeTreeProgressInterval)
+            defer ticker.Stop()
+            for {
+                select {
+                case <-done:
+                    return
+                case <-ticker.C:
+                    if err := stream.Send(remover.progress()); err != nil {
+                        return
+                    }
+                }
+            }
+        }()
+        
+        failedPath, err := remover.run(ctx, path)
+        close(done)
+        sender.Wait()
+        
+        // Even a failed removal may have deleted part of the tree
+        s.delegations.recallTree(path)
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_DELETE, path, "")
+        
+        final := remover.progress()
+        final.Done = true
+        if err != nil {
+            final.Status = nfs.MapErrorToStatus(err)
+            final.FailedPath = failedPath
+        }
+        return final, nil
+    })
+    
+    if err != nil {
+        return err
+    }
+    
+    return stream.Send(result.(*api.RemoveTreeProgress))
+}
+
+// Rename implements the Rename RPC method
+func (s *NFSServer) Rename(ctx context.Context, req *api.RenameRequest) (*api.RenameResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("rename-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Rename", reqID, clientAddr, func() (interface{}, error) {
+        // A retry of a rename already carried out gets its response again
+        // rather than ERR_NOENT, or an exchange undone
+        policy := s.policy()
+        cacheKey, retryable := retryKey(ctx, "rename")
+        if retryable {
+            if cachedResp, found := s.getCachedResponse(cacheKey); found {
+                slog.DebugContext(ctx, "Replayed cached rename response", "from", req.FromName, "to", req.ToName)
+                return cachedResp, nil
+            }
+        }
+        
+        // Validate both directory handles
+        if _, err := s.validateFileHandle(req.FromDirectoryHandle); err != nil {
+            return &api.RenameResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        if _, err := s.validateFileHandle(req.ToDirectoryHandle); err != nil {
+            return &api.RenameResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handles to paths
+        fromDir, err := s.resolveHandle(ctx, req.FromDirectoryHandle)
+        if err != nil {
+            return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        toDir, err := s.resolveHandle(ctx, req.ToDirectoryHandle)
+        if err != nil {
+            return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Names must refer to entries directly inside their directories
+        if err := s.validateName(req.FromName); err != nil {
+            return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        if err := s.validateNewPath(toDir, req.ToName); err != nil {
+            return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        policy.squash(&creds)
+        
+        // Check write permission on both directories
+        for _, dir := range []string{fromDir, toDir} {
+            if err := s.fileSystem.Access(ctx, dir, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+                return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+        }
+        
+        // Other clients may no longer cache a file the rename replaces
+        s.recallPath(ctx, filepath.Join(toDir, req.ToName))
+        
+        // Rename the entry
+        var flags fs.RenameFlags
+        if req.NoReplace {
+            flags |= fs.RenameNoReplace
+        }
+        if req.Exchange {
+            flags |= fs.RenameExchange
+        }
+        fromBefore, toBefore := s.wccBefore(ctx, fromDir), s.wccBefore(ctx, toDir)
+        err = s.fileSystem.Rename(ctx, filepath.Join(fromDir, req.FromName), filepath.Join(toDir, req.ToName), flags)
+        if err != nil {
+            return &api.RenameResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Delegations below a moved directory are held under its old path
+        s.delegations.recall(fromDir, toDir)
+        s.delegations.recallTree(filepath.Join(fromDir, req.FromName))
+        s.delegations.recallTree(filepath.Join(toDir, req.ToName))
+        s.watchers.notify(api.ChangeType_CHANGE_RENAME, filepath.Join(fromDir, req.FromName), filepath.Join(toDir, req.ToName))
+        if req.Exchange {
+            s.watchers.notify(api.ChangeType_CHANGE_RENAME, filepath.Join(toDir, req.ToName), filepath.Join(fromDir, req.FromName))
+        }
+        
+        // Get directory attributes after the rename
+        resp := &api.RenameResponse{Status: api.Status_OK, FromDirBefore: fromBefore, ToDirBefore: toBefore}
+        if info, err := s.fileSystem.GetAttr(ctx, fromDir); err == nil {
+            resp.FromDirAttributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        if info, err := s.fileSystem.GetAttr(ctx, toDir); err == nil {
+            resp.ToDirAttributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        if retryable {
+            s.cacheResponse(policy.epoch, cacheKey, resp, 10*time.Minute, true)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.RenameResponse), nil
+}
+
+// CreateUnnamed creates a file with no directory entry. Clients write to it
+// through the returned handle and publish it with LinkIntoPlace; files that
+// are never linked are discarded.
+func (s *NFSServer) CreateUnnamed(ctx context.Context, req *api.CreateUnnamedRequest) (*api.CreateUnnamedResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("createunnamed-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "CreateUnnamed", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.CreateUnnamedResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.CreateUnnamedResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Only some file systems can create unnamed files
+        creator, ok := fs.As[fs.UnnamedCreator](s.fileSystem)
+        if !ok {
+            return &api.CreateUnnamedResponse{Status: api.Status_ERR_NOTSUPP}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // The caller must be able to create entries in the directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.CreateUnnamedResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Create the file
+        attr := nfs.ProtoAttributesToFSAttr(req.Attributes)
+        filePath, fileInfo, err := creator.CreateUnnamed(ctx, dirPath, attr)
+        if err != nil {
+            return &api.CreateUnnamedResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Generate file handle for the new file
+        fileHandle, err := s.issueHandle(ctx, filePath)
+        if err != nil {
+            return &api.CreateUnnamedResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.CreateUnnamedResponse{
+            Status:     api.Status_OK,
+            FileHandle: fileHandle,
+            Attributes: nfs.FSInfoToProtoAttributes(fileInfo),
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.CreateUnnamedResponse), nil
+}
+
+// LinkIntoPlace publishes a file created by CreateUnnamed under a name
+func (s *NFSServer) LinkIntoPlace(ctx context.Context, req *api.LinkIntoPlaceRequest) (*api.LinkIntoPlaceResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("linkintoplace-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "LinkIntoPlace", reqID, clientAddr, func() (interface{}, error) {
+        // Validate both handles
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.LinkIntoPlaceResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.LinkIntoPlaceResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert handles to paths
+        filePath, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.LinkIntoPlaceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.LinkIntoPlaceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // The name must refer to an entry directly inside the directory
+        if err := s.validateNewPath(dirPath, req.Name); err != nil {
+            return &api.LinkIntoPlaceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Only some file systems can create unnamed files
+        creator, ok := fs.As[fs.UnnamedCreator](s.fileSystem)
+        if !ok {
+            return &api.LinkIntoPlaceResponse{Status: api.Status_ERR_NOTSUPP}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check write permission on the destination directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.LinkIntoPlaceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Publish the file
+        dirBefore := s.wccBefore(ctx, dirPath)
+        _, fileInfo, err := creator.LinkUnnamed(ctx, filePath, dirPath, req.Name, req.Replace)
+        if err != nil {
+            return &api.LinkIntoPlaceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_CREATE, filepath.Join(dirPath, req.Name), "")
+        
+        resp := &api.LinkIntoPlaceResponse{
+            Status:     api.Status_OK,
+            Attributes: nfs.FSInfoToProtoAttributes(fileInfo),
+            DirBefore:  dirBefore,
+        }
+        if dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            resp.DirAttributes = nfs.FSInfoToProtoAttributes(dirInfo)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.LinkIntoPlaceResponse), nil
+}
+
+// SetAttr changes a file's mode, owner, size and timestamps under the rules
+// of chmod(2), chown(2), truncate(2) and utimensat(2). Only the owner may
+// change the mode or set explicit times, only root may give a file away,
+// and the owner may only move it to a group they belong to. Others with
+// write access may change the size or set the times to the current time.
+func (s *NFSServer) SetAttr(ctx context.Context, req *api.SetAttrRequest) (*api.SetAttrResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("setattr-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "SetAttr", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.SetAttrResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Work out the new times
+        var attr fs.FileAttr
+        now := time.Now()
+        explicit := false
+        if req.AtimeNow {
+            attr.AccessTime = &now
+        } else if req.Atime != nil {
+            atime := time.Unix(req.Atime.Seconds, int64(req.Atime.Nano))
+            attr.AccessTime = &atime
+            explicit = true
+        }
+        if req.MtimeNow {
+            attr.ModifyTime = &now
+        } else if req.Mtime != nil {
+            mtime := time.Unix(req.Mtime.Seconds, int64(req.Mtime.Nano))
+            attr.ModifyTime = &mtime
+            explicit = true
+        }
+        
+        // Other clients may no longer cache the file, and writes cached
+        // under a delegation must land before a truncate
+        s.fileDelegations.recall(ctx, s.fenceKey(req.FileHandle), true)
+        
+        // Check permission
+        info, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // A guarded change only applies to the file the caller last saw
+        if req.GuardCtime != nil {
+            guard := time.Unix(req.GuardCtime.Seconds, int64(req.GuardCtime.Nano))
+            if !info.ChangeTime.Equal(guard) {
+                return &api.SetAttrResponse{Status: api.Status_ERR_NOT_SYNC}, nil
+            }
+        }
+        
+        isOwner := creds.UID == 0 || creds.UID == info.Uid
+        if req.Mode != nil {
+            if !isOwner {
+                return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+            }
+            // As with chmod(2), callers outside the file's group cannot
+            // set its setgid bit
+            mode := fs.FileMode(*req.Mode) & 07777
+            if creds.UID != 0 && !memberOf(creds, info.Gid) {
+                mode &^= fs.ModeSetGID
+            }
+            attr.Mode = &mode
+        }
+        if req.Uid != nil || req.Gid != nil {
+            if !isOwner {
+                return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+            }
+            if req.Uid != nil && *req.Uid != info.Uid {
+                if creds.UID != 0 {
+                    return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+                }
+                attr.Uid = req.Uid
+            }
+            if req.Gid != nil && *req.Gid != info.Gid {
+                if creds.UID != 0 && !memberOf(creds, *req.Gid) {
+                    return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+                }
+                attr.Gid = req.Gid
+            }
+        }
+        
+        // Like the mode, which it extends, only the owner may set the ACL
+        var aclSetter fs.ACLSetter
+        acl := nfs.ProtoACLToFS(req.Acl)
+        if acl != nil {
+            if !isOwner {
+                return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+            }
+            var ok bool
+            if aclSetter, ok = fs.As[fs.ACLSetter](s.fileSystem); !ok {
+                return &api.SetAttrResponse{Status: api.Status_ERR_NOTSUPP}, nil
+            }
+            if len(acl) > 0 && acl.Validate() != nil {
+                return &api.SetAttrResponse{Status: api.Status_ERR_INVAL}, nil
+            }
+        }
+        
+        timesChanged := attr.AccessTime != nil || attr.ModifyTime != nil
+        if timesChanged && creds.UID != 0 && creds.UID != info.Uid {
+            if explicit {
+                return &api.SetAttrResponse{Status: api.Status_ERR_PERM}, nil
+            }
+            if err := s.fileSystem.Access(ctx, path, fs.FileMode(2), creds); err != nil {
+                return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+        }
+        
+        // Changing the size needs write access, even for the owner
+        if req.Size != nil {
+            if info.Type == fs.FileTypeDirectory {
+                return &api.SetAttrResponse{Status: api.Status_ERR_ISDIR}, nil
+            }
+            if info.Type != fs.FileTypeRegular {
+                return &api.SetAttrResponse{Status: api.Status_ERR_INVAL}, nil
+            }
+            if err := s.fileSystem.Access(ctx, path, fs.FileMode(2), creds); err != nil {
+                return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+            size := int64(*req.Size)
+            attr.Size = &size
+        }
+        
+        // Times-only changes take the file system's fast path. The guard
+        // is checked just before, not atomically with, the change.
+        before := wccAttributes(info)
+        info, err = s.fileSystem.SetAttr(ctx, path, attr)
+        if err != nil {
+            return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // The ACL goes last, as a new mode would otherwise rewrite its mask
+        if aclSetter != nil {
+            if err := aclSetter.SetACL(ctx, path, acl); err != nil {
+                return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+            if info, err = s.fileSystem.GetAttr(ctx, path); err != nil {
+                return &api.SetAttrResponse{Status: nfs.MapErrorToStatus(err)}, nil
+            }
+        }
+        s.watchers.notify(api.ChangeType_CHANGE_MODIFY, path, "")
+        
+        return &api.SetAttrResponse{
+            Status:     api.Status_OK,
+            Attributes: nfs.FSInfoToProtoAttributes(info),
+            Before:     before,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.SetAttrResponse), nil
+}
+
+// memberOf reports whether creds include the group gid
+func memberOf(creds fs.Credentials, gid uint32) bool {
+    return creds.GID == gid || slices.Contains(creds.Groups, gid)
+}
+
+// PathConf reports the name and path limits enforced by the server, so that
+// clients can reject over-long names without a round trip
+func (s *NFSServer) PathConf(ctx context.Context, req *api.PathConfRequest) (*api.PathConfResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("pathconf-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "PathConf", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.PathConfResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.PathConfResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get file attributes
+        info, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.PathConfResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.PathConfResponse{
+            Status:          api.Status_OK,
+            Attributes:      nfs.FSInfoToProtoAttributes(info),
+            NameMax:         uint32(max(s.config.MaxNameLength, 0)),
+            PathMax:         uint32(max(s.config.MaxPathLength, 0)),
+            MaxDepth:        uint32(max(s.config.MaxPathDepth, 0)),
+            NoTrunc:         true,
+            ChownRestricted: true,
+            CaseInsensitive: false,
+            CasePreserving:  true,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.PathConfResponse), nil
+}
+
+// FsInfo implements the FsInfo RPC method. It reports the limits this
+// server enforces, so clients can size Read, Write and ReadDir requests.
+func (s *NFSServer) FsInfo(ctx context.Context, req *api.FsInfoRequest) (*api.FsInfoResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("fsinfo-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "FsInfo", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.FsInfoResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.FsInfoResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get file attributes
+        info, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.FsInfoResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Read and Write clamp to the configured sizes, which are also
+        // the best sizes to use; file times keep nanoseconds
+        return &api.FsInfoResponse{
+            Status:          api.Status_OK,
+            Attributes:      nfs.FSInfoToProtoAttributes(info),
+            Rtmax:           uint32(s.config.MaxReadSize),
+            Rtpref:          uint32(s.config.MaxReadSize),
+            Wtmax:           uint32(s.config.MaxWriteSize),
+            Wtpref:          uint32(s.config.MaxWriteSize),
+            Dtpref:          uint32(s.readDirBudget(0)),
+            MaxFileSize:     math.MaxInt64,
+            NameMax:         uint32(max(s.config.MaxNameLength, 0)),
+            CaseInsensitive: false,
+            CasePreserving:  true,
+            Symlinks:        true,
+            HardLinks:       true,
+            TimeDeltaNanos:  1,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.FsInfoResponse), nil
+}
+
+// maxBulkLookupNames bounds the number of names in one BulkLookup request
+const maxBulkLookupNames = 1024
+
+// BulkLookup looks up several names in one directory, returning a handle and
+// attributes for each. It saves a round trip per entry when a client stats
+// every file of a directory listing.
+func (s *NFSServer) BulkLookup(ctx context.Context, req *api.BulkLookupRequest) (*api.BulkLookupResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("bulklookup-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "BulkLookup", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.BulkLookupResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        if len(req.Names) > maxBulkLookupNames {
+            return &api.BulkLookupResponse{Status: api.Status_ERR_INVAL}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.BulkLookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check directory access permission once for all names
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(5), creds); err != nil { // 5 = read + execute
+            return &api.BulkLookupResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        results := make([]*api.BulkLookupResult, len(req.Names))
+        for i, name := range req.Names {
+            results[i] = s.bulkLookupOne(ctx, dirPath, name, creds)
+        }
+        
+        var dirAttrs *api.FileAttributes
+        if dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            dirAttrs = nfs.FSInfoToProtoAttributes(dirInfo)
+        }
+        
+        return &api.BulkLookupResponse{
+            Status:        api.Status_OK,
+            Results:       results,
+            DirAttributes: dirAttrs,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.BulkLookupResponse), nil
+}
+
+// bulkLookupOne looks up a single name for BulkLookup
+func (s *NFSServer) bulkLookupOne(ctx context.Context, dirPath string, name string, creds fs.Credentials) *api.BulkLookupResult {
+    if err := s.validateName(name); err != nil {
+        return &api.BulkLookupResult{Status: nfs.MapErrorToStatus(err)}
+    }
+    
+    targetPath, info, err := s.lookupVisible(ctx, dirPath, name, creds)
+    if err != nil {
+        return &api.BulkLookupResult{Status: nfs.MapErrorToStatus(err)}
+    }
+    
+    fileHandle, err := s.issueHandle(ctx, targetPath)
+    if err != nil {
+        return &api.BulkLookupResult{Status: nfs.MapErrorToStatus(err)}
+    }
+    
+    return &api.BulkLookupResult{
+        Status:     api.Status_OK,
+        FileHandle: fileHandle,
+        Attributes: nfs.FSInfoToProtoAttributes(info),
+    }
+}
+
+// AcquireFence issues a new fencing epoch for a regular file. Writers pass
+// the epoch with each write; once a newer epoch has been issued, writes
+// carrying older ones fail with ERR_FENCED, so a deposed leader cannot
+// overwrite state written by its successor.
+func (s *NFSServer) AcquireFence(ctx context.Context, req *api.AcquireFenceRequest) (*api.AcquireFenceResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("acquirefence-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "AcquireFence", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.AcquireFenceResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.AcquireFenceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Only writers may fence other writers out
+        if err := s.fileSystem.Access(ctx, path, fs.FileMode(2), creds); err != nil { // 2 = write
+            return &api.AcquireFenceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        fileInfo, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return &api.AcquireFenceResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        if fileInfo.Type != fs.FileTypeRegular {
+            return &api.AcquireFenceResponse{Status: api.Status_ERR_ISDIR}, nil
+        }
+        
+        return &api.AcquireFenceResponse{
+            Status: api.Status_OK,
+            Epoch:  s.fences.acquire(s.fenceKey(req.FileHandle)),
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.AcquireFenceResponse), nil
+}
+
+// Lock takes a byte-range lock for one of a client's lock owners. A
+// blocking request that finds the range locked waits outside the worker
+// pool, since locks may be held for a long time, until the conflicting
+// locks are released or waiting is found to deadlock.
+func (s *NFSServer) Lock(ctx context.Context, req *api.LockRequest) (*api.LockResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("lock-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    want := newByteLock(req.ClientId, req.Owner, req.Offset, req.Length, req.Exclusive)
+    key := s.fenceKey(req.FileHandle)
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Lock", reqID, clientAddr, func() (interface{}, error) {
+        mode := fs.FileMode(4) // 4 = read
+        if req.Exclusive {
+            mode = fs.FileMode(2) // 2 = write
+        }
+        if status := s.checkLockTarget(ctx, req.FileHandle, req.Credentials, req.ClientId, mode); status != api.Status_OK {
+            return &api.LockResponse{Status: status}, nil
+        }
+        if err := s.locks.admit(req.ClientId, req.Reclaim); err != nil {
+            return s.lockResponse(nil, err), nil
+        }
+        
+        return s.lockResponse(s.locks.acquire(ctx, key, want, false)), nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    resp := result.(*api.LockResponse)
+    if resp.Status != api.Status_ERR_DENIED || !req.Block {
+        return resp, nil
+    }
+    conflict, err := s.locks.acquire(ctx, key, want, true)
+    if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+        // The client gave up waiting
+        return nil, status.FromContextError(ctxErr).Err()
+    }
+    return s.lockResponse(conflict, err), nil
+}
+
+// Unlock releases a lock owner's byte-range locks over a range
+func (s *NFSServer) Unlock(ctx context.Context, req *api.UnlockRequest) (*api.UnlockResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("unlock-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Unlock", reqID, clientAddr, func() (interface{}, error) {
+        // Owners may always release their own locks
+        if status := s.checkLockTarget(ctx, req.FileHandle, req.Credentials, req.ClientId, 0); status != api.Status_OK {
+            return &api.UnlockResponse{Status: status}, nil
+        }
+        
+        l := newByteLock(req.ClientId, req.Owner, req.Offset, req.Length, false)
+        s.locks.unlock(s.fenceKey(req.FileHandle), l.owner, l.start, l.end)
+        return &api.UnlockResponse{Status: api.Status_OK}, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.UnlockResponse), nil
+}
+
+// TestLock finds a lock that would keep a Lock from succeeding without
+// taking it
+func (s *NFSServer) TestLock(ctx context.Context, req *api.TestLockRequest) (*api.TestLockResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("testlock-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "TestLock", reqID, clientAddr, func() (interface{}, error) {
+        if status := s.checkLockTarget(ctx, req.FileHandle, req.Credentials, req.ClientId, fs.FileMode(4)); status != api.Status_OK { // 4 = read
+            return &api.TestLockResponse{Status: status}, nil
+        }
+        if s.locks.admit(req.ClientId, false) != nil {
+            // Locks not yet reclaimed would be missed
+            return &api.TestLockResponse{Status: api.Status_ERR_GRACE}, nil
+        }
+        
+        want := newByteLock(req.ClientId, req.Owner, req.Offset, req.Length, req.Exclusive)
+        resp := &api.TestLockResponse{Status: api.Status_OK}
+        if conflict := s.locks.test(s.fenceKey(req.FileHandle), want); conflict != nil {
+            resp.Holder = conflict.holder()
+        }
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.TestLockResponse), nil
+}
+
+// RenewLocks extends the lease on a client's byte-range locks. Clients
+// holding locks call it well within the lease, as Lock, Unlock and
+// TestLock renew it too.
+func (s *NFSServer) RenewLocks(ctx context.Context, req *api.RenewLocksRequest) (*api.RenewLocksResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("renewlocks-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "RenewLocks", reqID, clientAddr, func() (interface{}, error) {
+        if req.ClientId == "" {
+            return &api.RenewLocksResponse{Status: api.Status_ERR_INVAL}, nil
+        }
+        
+        return &api.RenewLocksResponse{
+            Status:       api.Status_OK,
+            LeaseSeconds: s.locks.leaseSeconds(),
+            Locks:        uint32(s.locks.renew(req.ClientId)),
+            ServerBoot:   s.locks.boot,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.RenewLocksResponse), nil
+}
+
+// RegisterClient starts a client's lease on its locks. A client that finds
+// the server restarted calls it to learn whether it may reclaim the locks
+// it held, then reclaims them and calls ReclaimComplete.
+func (s *NFSServer) RegisterClient(ctx context.Context, req *api.RegisterClientRequest) (*api.RegisterClientResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("registerclient-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "RegisterClient", reqID, clientAddr, func() (interface{}, error) {
+        if req.ClientId == "" {
+            return &api.RegisterClientResponse{Status: api.Status_ERR_INVAL}, nil
+        }
+        
+        grace, reclaim := s.locks.register(req.ClientId)
+        return &api.RegisterClientResponse{
+            Status:       api.Status_OK,
+            LeaseSeconds: s.locks.leaseSeconds(),
+            ServerBoot:   s.locks.boot,
+            GraceSeconds: uint32((grace + time.Second - 1) / time.Second),
+            Reclaim:      reclaim,
+        }, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.RegisterClientResponse), nil
+}
+
+// ReclaimComplete records that a client reclaimed its locks. The grace
+// period ends as soon as every client that held locks has called it.
+func (s *NFSServer) ReclaimComplete(ctx context.Context, req *api.ReclaimCompleteRequest) (*api.ReclaimCompleteResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("reclaimcomplete-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "ReclaimComplete", reqID, clientAddr, func() (interface{}, error) {
+        if req.ClientId == "" {
+            return &api.ReclaimCompleteResponse{Status: api.Status_ERR_INVAL}, nil
+        }
+        
+        s.locks.reclaimed(req.ClientId)
+        return &api.ReclaimCompleteResponse{Status: api.Status_OK}, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.ReclaimCompleteResponse), nil
+}
+
+// DelegateDirectory lets a client cache a directory listing. The stream
+// carries the grant, then stays open until the directory's entries change,
+// when the delegation is recalled, or until the client closes it.
+func (s *NFSServer) DelegateDirectory(req *api.DelegateDirectoryRequest, stream api.NFSService_DelegateDirectoryServer) error {
+    ctx := stream.Context()
+    
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("delegatedirectory-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Grant the delegation; waiting for the recall happens outside the
+    // worker pool, since delegations are held for a long time
+    var deleg *dirDelegation
+    result, err := s.processRequest(ctx, "DelegateDirectory", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.DelegationEvent{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.DelegationEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Caching a listing needs the same permission as reading it
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(4), creds); err != nil { // 4 = read
+            return &api.DelegationEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath)
+        if err != nil {
+            return &api.DelegationEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        if dirInfo.Type != fs.FileTypeDirectory {
+            return &api.DelegationEvent{Status: api.Status_ERR_NOTDIR}, nil
+        }
+        
+        // Too many delegations outstanding: the client should just ReadDir
+        var ok bool
+        deleg, ok = s.delegations.grant(dirPath)
+        if !ok {
+            return &api.DelegationEvent{Status: api.Status_ERR_JUKEBOX}, nil
+        }
+        
+        return &api.DelegationEvent{
+            Status:        api.Status_OK,
+            Type:          api.DelegationEventType_DELEGATION_GRANTED,
+            DirAttributes: nfs.FSInfoToProtoAttributes(dirInfo),
+        }, nil
+    })
+    
+    if err != nil {
+        return err
+    }
+    if deleg == nil {
+        return stream.Send(result.(*api.DelegationEvent))
+    }
+    defer s.delegations.release(deleg)
+    
+    if err := stream.Send(result.(*api.DelegationEvent)); err != nil {
+        return err
+    }
+    
+    select {
+    case <-deleg.recalled:
+        return stream.Send(&api.DelegationEvent{
+            Status: api.Status_OK,
+            Type:   api.DelegationEventType_DELEGATION_RECALLED,
+        })
+    case <-ctx.Done():
+        // The client returned the delegation
+        return nil
+    }
+}
+
+// Delegate carries a client's file delegations. Each open on the stream is
+// answered with a grant or a denial; recalls follow whenever another
+// client needs a file delegated to this one. The stream ending returns
+// every delegation held on it.
+func (s *NFSServer) Delegate(stream api.NFSService_DelegateServer) error {
+    ctx, cancel := context.WithCancel(stream.Context())
+    defer cancel()
+    
+    holder := newDelegationHolder(requestClientID(ctx))
+    defer s.fileDelegations.close(holder)
+    
+    // One goroutine sends, so answers and recalls never interleave
+    sent := make(chan error, 1)
+    go func() {
+        for {
+            select {
+            case callback := <-holder.callbacks:
+                if err := stream.Send(callback); err != nil {
+                    sent <- err
+                    return
+                }
+            case <-holder.lost:
+                sent <- status.Error(codes.ResourceExhausted, "delegation callbacks not taken in time")
+                return
+            case <-ctx.Done():
+                sent <- nil
+                return
+            }
+        }
+    }()
+    
+    received := make(chan error, 1)
+    go func() {
+        // Outside the handler, so the stream interceptor cannot recover it
+        defer func() {
+            if r := recover(); r != nil {
+                received <- status.Error(codes.Internal, s.metrics.panicked(ctx, "Delegate", r).Error())
+            }
+        }()
+        for {
+            req, err := stream.Recv()
+            if err == io.EOF {
+                received <- nil
+                return
+            }
+            if err != nil {
+                received <- err
+                return
+            }
+            switch req.Op {
+            case api.FileDelegationOp_FILE_DELEGATION_RETURN:
+                s.fileDelegations.giveBack(holder, s.fenceKey(req.FileHandle))
+            case api.FileDelegationOp_FILE_DELEGATION_OPEN:
+                if err := s.delegateFile(ctx, holder, req); err != nil {
+                    received <- err
+                    return
+                }
+            }
+        }
+    }()
+    
+    select {
+    case err := <-sent:
+        return err
+    case err := <-received:
+        cancel()
+        <-sent
+        return err
+    }
+}
+
+// delegateFile answers a request on a Delegate stream for a delegation,
+// queueing a grant or a denial for the stream's sender
+func (s *NFSServer) delegateFile(ctx context.Context, holder *delegationHolder, req *api.FileDelegationRequest) error {
+    reqID := fmt.Sprintf("delegate-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    denied := func(status api.Status) *api.FileDelegationCallback {
+        return &api.FileDelegationCallback{
+            Type:       api.FileDelegationCallbackType_FILE_DELEGATION_DENIED,
+            Status:     status,
+            Sequence:   req.Sequence,
+            FileHandle: req.FileHandle,
+        }
+    }
+    
+    result, err := s.processRequest(ctx, "Delegate", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return denied(api.Status_ERR_BADHANDLE), nil
+        }
+        
+        // Convert file handle to path
+        path, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return denied(nfs.MapErrorToStatus(err)), nil
+        }
+        if req.Write && s.config.ReadOnly {
+            return denied(api.Status_ERR_ROFS), nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Caching the file needs the same permission as the access cached
+        mode := fs.FileMode(4) // 4 = read
+        if req.Write {
+            mode = 6 // 6 = read+write
+        }
+        if err := s.fileSystem.Access(ctx, path, mode, creds); err != nil {
+            return denied(nfs.MapErrorToStatus(err)), nil
+        }
+        
+        fileInfo, err := s.fileSystem.GetAttr(ctx, path)
+        if err != nil {
+            return denied(nfs.MapErrorToStatus(err)), nil
+        }
+        if fileInfo.Type != fs.FileTypeRegular {
+            return denied(api.Status_ERR_ISDIR), nil
+        }
+        
+        // Conflicting delegations of other clients are recalled; the
+        // client may ask again once they are returned. The grant is
+        // queued by the registry, ahead of any recall of it.
+        status := s.fileDelegations.grant(holder, s.fenceKey(req.FileHandle), &api.FileDelegationCallback{
+            Type:       api.FileDelegationCallbackType_FILE_DELEGATION_GRANTED,
+            Status:     api.Status_OK,
+            Sequence:   req.Sequence,
+            FileHandle: req.FileHandle,
+            Write:      req.Write,
+            Attributes: nfs.FSInfoToProtoAttributes(fileInfo),
+        })
+        if status != api.Status_OK {
+            return denied(status), nil
+        }
+        return nil, nil
+    })
+    
+    if err != nil {
+        return err
+    }
+    if callback, _ := result.(*api.FileDelegationCallback); callback != nil {
+        holder.queue(callback)
+    }
+    return nil
+}
+
+// Remove implements the Remove RPC method
+func (s *NFSServer) Remove(ctx context.Context, req *api.RemoveRequest) (*api.RemoveResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("remove-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Remove", reqID, clientAddr, func() (interface{}, error) {
+        // A retry of a removal already carried out gets its response again
+        // rather than ERR_NOENT
+        policy := s.policy()
+        cacheKey, retryable := retryKey(ctx, "remove")
+        if retryable {
+            if cachedResp, found := s.getCachedResponse(cacheKey); found {
+                slog.DebugContext(ctx, "Replayed cached remove response", "name", req.Name)
+                return cachedResp, nil
+            }
+        }
+        
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.RemoveResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.RemoveResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // The name must refer to an entry directly inside the directory
+        if err := s.validateName(req.Name); err != nil {
+            return &api.RemoveResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        policy.squash(&creds)
+        
+        // Check write permission on the directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.RemoveResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Other clients may no longer cache the file
+        s.recallPath(ctx, filepath.Join(dirPath, req.Name))
+        
+        // Remove the entry; directories are refused with ERR_ISDIR
+        dirBefore := s.wccBefore(ctx, dirPath)
+        if err := s.fileSystem.Remove(ctx, filepath.Join(dirPath, req.Name)); err != nil {
+            return &api.RemoveResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_DELETE, filepath.Join(dirPath, req.Name), "")
+        
+        // Get directory attributes after the removal
+        resp := &api.RemoveResponse{Status: api.Status_OK, DirBefore: dirBefore}
+        if info, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            resp.DirAttributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        if retryable {
+            s.cacheResponse(policy.epoch, cacheKey, resp, 10*time.Minute, true)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.RemoveResponse), nil
+}
+
+// Rmdir implements the Rmdir RPC method
+func (s *NFSServer) Rmdir(ctx context.Context, req *api.RmdirRequest) (*api.RmdirResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("rmdir-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Rmdir", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.RmdirResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.RmdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // The name must refer to an entry directly inside the directory
+        if err := s.validateName(req.Name); err != nil {
+            return &api.RmdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check write permission on the directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.RmdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Remove the directory; one with entries left is refused with
+        // ERR_NOTEMPTY, anything else with ERR_NOTDIR
+        target := filepath.Join(dirPath, req.Name)
+        dirBefore := s.wccBefore(ctx, dirPath)
+        if err := s.fileSystem.Rmdir(ctx, target); err != nil {
+            return &api.RmdirResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        s.delegations.recall(dirPath, target)
+        s.watchers.notify(api.ChangeType_CHANGE_DELETE, target, "")
+        
+        // Get parent attributes after the removal
+        resp := &api.RmdirResponse{Status: api.Status_OK, DirBefore: dirBefore}
+        if info, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            resp.DirAttributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.RmdirResponse), nil
+}
+
+// Symlink implements the Symlink RPC method
+func (s *NFSServer) Symlink(ctx context.Context, req *api.SymlinkRequest) (*api.SymlinkResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("symlink-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Symlink", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.SymlinkResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.SymlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Check the name against the configured limits
+        if err := s.validateNewPath(dirPath, req.Name); err != nil {
+            return &api.SymlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check write permission on the directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.SymlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Create the link, owned by the caller
+        attr := fs.FileAttr{Uid: &creds.UID, Gid: &creds.GID}
+        dirBefore := s.wccBefore(ctx, dirPath)
+        linkPath, linkInfo, err := s.fileSystem.Symlink(ctx, dirPath, req.Name, req.Target, attr)
+        if err != nil {
+            return &api.SymlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_CREATE, linkPath, "")
+        
+        // Generate a handle for the new link
+        linkHandle, err := s.issueHandle(ctx, linkPath)
+        if err != nil {
+            return &api.SymlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        resp := &api.SymlinkResponse{
+            Status:     api.Status_OK,
+            FileHandle: linkHandle,
+            Attributes: nfs.FSInfoToProtoAttributes(linkInfo),
+            DirBefore:  dirBefore,
+        }
+        if info, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            resp.DirAttributes = nfs.FSInfoToProtoAttributes(info)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.SymlinkResponse), nil
+}
+
+// Link implements the Link RPC method
+func (s *NFSServer) Link(ctx context.Context, req *api.LinkRequest) (*api.LinkResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("link-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Link", reqID, clientAddr, func() (interface{}, error) {
+        // Validate both handles
+        if _, err := s.validateFileHandle(req.TargetHandle); err != nil {
+            return &api.LinkResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.LinkResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert handles to paths
+        targetPath, err := s.resolveHandle(ctx, req.TargetHandle)
+        if err != nil {
+            return &api.LinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.LinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Check the name against the configured limits
+        if err := s.validateNewPath(dirPath, req.Name); err != nil {
+            return &api.LinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Check write permission on the directory
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(3), creds); err != nil { // 3 = write+execute
+            return &api.LinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Create the link; directories are refused with ERR_ISDIR
+        before, dirBefore := s.wccBefore(ctx, targetPath), s.wccBefore(ctx, dirPath)
+        linkPath, info, err := s.fileSystem.Link(ctx, targetPath, dirPath, req.Name)
+        if err != nil {
+            return &api.LinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        s.delegations.recall(dirPath)
+        s.watchers.notify(api.ChangeType_CHANGE_CREATE, linkPath, "")
+        
+        resp := &api.LinkResponse{
+            Status:     api.Status_OK,
+            Attributes: nfs.FSInfoToProtoAttributes(info),
+            Before:     before,
+            DirBefore:  dirBefore,
+        }
+        if dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath); err == nil {
+            resp.DirAttributes = nfs.FSInfoToProtoAttributes(dirInfo)
+        }
+        
+        return resp, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.LinkResponse), nil
+}
+
+// Readlink implements the Readlink RPC method. As with local links,
+// reading the target needs no permission on the link itself.
+func (s *NFSServer) Readlink(ctx context.Context, req *api.ReadlinkRequest) (*api.ReadlinkResponse, error) {
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("readlink-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Process the request
+    result, err := s.processRequest(ctx, "Readlink", reqID, clientAddr, func() (interface{}, error) {
+        // Validate file handle
+        if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+            return &api.ReadlinkResponse{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert file handle to path
+        linkPath, err := s.resolveHandle(ctx, req.FileHandle)
+        if err != nil {
+            return &api.ReadlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Read the target; anything but a link is refused with ERR_INVAL
+        target, err := s.fileSystem.Readlink(ctx, linkPath)
+        s.shadow.compareLink(linkPath, target, err)
+        if err != nil {
+            return &api.ReadlinkResponse{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        return &api.ReadlinkResponse{Status: api.Status_OK, Target: target}, nil
+    })
+    
+    if err != nil {
+        return nil, err
+    }
+    
+    return result.(*api.ReadlinkResponse), nil
+}
+
+// Watch streams the changes made through the server below a directory.
+// The first event confirms the subscription; the stream then stays open
+// until the client closes it. Permission is checked on the watched
+// directory only.
+func (s *NFSServer) Watch(req *api.WatchRequest, stream api.NFSService_WatchServer) error {
+    ctx := stream.Context()
+    
+    // Create a unique request ID and get client address
+    reqID := fmt.Sprintf("watch-%d", time.Now().UnixNano())
+    clientAddr := "unknown"
+    if peer, ok := ctx.Value("peer").(*net.Addr); ok && peer != nil {
+        clientAddr = (*peer).String()
+    }
+    
+    // Subscribe; streaming the events happens outside the worker pool,
+    // since watches are held for a long time
+    var w *watcher
+    result, err := s.processRequest(ctx, "Watch", reqID, clientAddr, func() (interface{}, error) {
+        // Validate directory handle
+        if _, err := s.validateFileHandle(req.DirectoryHandle); err != nil {
+            return &api.ChangeEvent{Status: api.Status_ERR_BADHANDLE}, nil
+        }
+        
+        // Convert directory handle to path
+        dirPath, err := s.resolveHandle(ctx, req.DirectoryHandle)
+        if err != nil {
+            return &api.ChangeEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        // Get credentials
+        creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+        
+        // Apply root squashing if enabled
+        s.policy().squash(&creds)
+        
+        // Watching a directory needs the same permission as listing it
+        if err := s.fileSystem.Access(ctx, dirPath, fs.FileMode(4), creds); err != nil { // 4 = read
+            return &api.ChangeEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        
+        dirInfo, err := s.fileSystem.GetAttr(ctx, dirPath)
+        if err != nil {
+            return &api.ChangeEvent{Status: nfs.MapErrorToStatus(err)}, nil
+        }
+        if dirInfo.Type != fs.FileTypeDirectory {
+            return &api.ChangeEvent{Status: api.Status_ERR_NOTDIR}, nil
+        }
+        
+        w = s.watchers.subscribe(dirPath, req.Recursive)
+        return &api.ChangeEvent{
+            Status: api.Status_OK,
+            Type:   api.ChangeType_CHANGE_WATCHING,
+            Path:   w.root,
+            TimeNs: time.Now().UnixNano(),
+        }, nil
+    })
+    
+    if err != nil {
+        return err
+    }
+    if w == nil {
+        return stream.Send(result.(*api.ChangeEvent))
+    }
+    defer s.watchers.unsubscribe(w)
+    
+    if err := stream.Send(result.(*api.ChangeEvent)); err != nil {
+        return err
+    }
+    
+    for {
+        select {
+        case event := <-w.events:
+            if err := stream.Send(event); err != nil {
+                return err
+            }
+            // Events were dropped while this one waited in the buffer
+            if w.overflowed.Swap(false) {
+                if err := stream.Send(&api.ChangeEvent{
+                    Status: api.Status_OK,
+                    Type:   api.ChangeType_CHANGE_OVERFLOW,
+                    Path:   w.root,
+                    TimeNs: time.Now().UnixNano(),
+                }); err != nil {
+                    return err
+                }
+            }
+        case <-ctx.Done():
+            // The client closed the stream
+            return nil
+        }
+    }
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestSetAttr(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Create test files; shared.txt is writable by everyone
+	for name, mode := range map[string]os.FileMode{"owned.txt": 0644, "shared.txt": 0666} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte("content"), mode); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Failed to chmod test file: %v", err)
+		}
+	}
+	owner := uint32(os.Getuid())
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server without root squashing so the owner may be root
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ownedHandle, err := server.issueHandle(context.Background(), "/owned.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	sharedHandle, err := server.issueHandle(context.Background(), "/shared.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	mtime := &api.FileTime{Seconds: 1600000000, Nano: 123}
+	newSize, zero := uint64(3), uint64(0)
+	ownerCreds := &api.Credentials{Uid: owner, Gid: owner}
+	other := &api.Credentials{Uid: owner + 12345, Gid: owner + 12345}
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		req        *api.SetAttrRequest
+		wantStatus api.Status
+	}{
+		{"Owner sets mtime", &api.SetAttrRequest{FileHandle: ownedHandle, Credentials: ownerCreds, Mtime: mtime}, api.Status_OK},
+		{"Other sets mtime", &api.SetAttrRequest{FileHandle: sharedHandle, Credentials: other, Mtime: mtime}, api.Status_ERR_PERM},
+		{"Other touches writable file", &api.SetAttrRequest{FileHandle: sharedHandle, Credentials: other, AtimeNow: true, MtimeNow: true}, api.Status_OK},
+		{"Other touches read-only file", &api.SetAttrRequest{FileHandle: ownedHandle, Credentials: other, AtimeNow: true, MtimeNow: true}, api.Status_ERR_ACCES},
+		{"Other truncates writable file", &api.SetAttrRequest{FileHandle: sharedHandle, Credentials: other, Size: &newSize}, api.Status_OK},
+		{"Other truncates read-only file", &api.SetAttrRequest{FileHandle: ownedHandle, Credentials: other, Size: &zero}, api.Status_ERR_ACCES},
+		{"Truncate directory", &api.SetAttrRequest{FileHandle: rootHandle, Credentials: ownerCreds, Size: &zero}, api.Status_ERR_ISDIR},
+		{"Invalid handle", &api.SetAttrRequest{FileHandle: []byte{1, 2, 3}, Credentials: ownerCreds, Mtime: mtime}, api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.SetAttr(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("SetAttr failed: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Unexpected status: got %v, want %v", resp.Status, tc.wantStatus)
+			}
+		})
+	}
+
+	// Only the modification time changed
+	info, err := os.Stat(filepath.Join(tempDir, "owned.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if want := time.Unix(mtime.Seconds, int64(mtime.Nano)); !info.ModTime().Equal(want) {
+		t.Errorf("Wrong mtime: got %v, want %v", info.ModTime(), want)
+	}
+	if info.Size() != int64(len("content")) || info.Mode().Perm() != 0644 {
+		t.Errorf("Times-only SetAttr changed size or mode: size %d, mode %v", info.Size(), info.Mode())
+	}
+
+	// The writable file was truncated
+	info, err = os.Stat(filepath.Join(tempDir, "shared.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Size() != int64(newSize) {
+		t.Errorf("Wrong size after truncate: got %d, want %d", info.Size(), newSize)
+	}
+}
+
+func TestSetAttrModeAndOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Changing file ownership requires root")
+	}
+	tempDir := t.TempDir()
+
+	// prog is owned by 1000:1000; its owner is also in group 2000
+	path := filepath.Join(tempDir, "prog")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chown(path, 1000, 1000); err != nil {
+		t.Fatalf("Failed to chown test file: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handle, err := server.issueHandle(context.Background(), "/prog")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	owner := &api.Credentials{Uid: 1000, Gid: 1000, Groups: []uint32{2000}}
+	other := &api.Credentials{Uid: 1001, Gid: 1001}
+	u32 := func(v uint32) *uint32 { return &v }
+
+	// Steps run in order; each sees the result of the previous ones
+	steps := []struct {
+		name       string
+		req        *api.SetAttrRequest
+		wantStatus api.Status
+		wantMode   uint32
+		wantUID    uint32
+		wantGID    uint32
+	}{
+		{"Owner sets setuid", &api.SetAttrRequest{Credentials: owner, Mode: u32(04750)}, api.Status_OK, 04750, 1000, 1000},
+		{"Other changes mode", &api.SetAttrRequest{Credentials: other, Mode: u32(0777)}, api.Status_ERR_PERM, 04750, 1000, 1000},
+		{"Owner gives file away", &api.SetAttrRequest{Credentials: owner, Uid: u32(1001)}, api.Status_ERR_PERM, 04750, 1000, 1000},
+		{"Owner keeps own uid", &api.SetAttrRequest{Credentials: owner, Uid: u32(1000)}, api.Status_OK, 04750, 1000, 1000},
+		{"Owner moves to foreign group", &api.SetAttrRequest{Credentials: owner, Gid: u32(3000)}, api.Status_ERR_PERM, 04750, 1000, 1000},
+		{"Owner moves to own group", &api.SetAttrRequest{Credentials: owner, Gid: u32(2000), Mode: u32(0750)}, api.Status_OK, 0750, 1000, 2000},
+		{"Other changes group", &api.SetAttrRequest{Credentials: other, Gid: u32(1001)}, api.Status_ERR_PERM, 0750, 1000, 2000},
+		{"Root gives file away", &api.SetAttrRequest{Credentials: root, Uid: u32(1001), Gid: u32(3000)}, api.Status_OK, 0750, 1001, 3000},
+		{"New owner outside group sets setgid", &api.SetAttrRequest{Credentials: other, Mode: u32(02755)}, api.Status_OK, 0755, 1001, 3000},
+		{"Root sets setgid", &api.SetAttrRequest{Credentials: root, Mode: u32(02755)}, api.Status_OK, 02755, 1001, 3000},
+	}
+
+	for _, step := range steps {
+		step.req.FileHandle = handle
+		resp, err := server.SetAttr(context.Background(), step.req)
+		if err != nil {
+			t.Fatalf("%s: SetAttr failed: %v", step.name, err)
+		}
+		if resp.Status != step.wantStatus {
+			t.Errorf("%s: status = %v, want %v", step.name, resp.Status, step.wantStatus)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		attrs, err := fs.GetAttr(context.Background(), "/prog")
+		if err != nil {
+			t.Fatalf("GetAttr failed: %v", err)
+		}
+		if uint32(attrs.Mode) != step.wantMode || attrs.Uid != step.wantUID || attrs.Gid != step.wantGID {
+			t.Errorf("%s: file is %o %d:%d (%v), want %o %d:%d", step.name,
+				attrs.Mode, attrs.Uid, attrs.Gid, info.Mode(), step.wantMode, step.wantUID, step.wantGID)
+		}
+	}
+}
+
+func TestSetAttrGuard(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handle, err := server.issueHandle(context.Background(), "/file.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	creds := &api.Credentials{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+
+	getResp, err := server.GetAttr(context.Background(), &api.GetAttrRequest{FileHandle: handle, Credentials: creds})
+	if err != nil || getResp.Status != api.Status_OK {
+		t.Fatalf("GetAttr = %v, %v", getResp.GetStatus(), err)
+	}
+	seen := getResp.Attributes.Ctime
+
+	// Kernel ctimes are coarse; make sure the first change moves it
+	time.Sleep(20 * time.Millisecond)
+
+	// The first guarded change matches and moves the ctime, so a second
+	// one with the same guard is refused
+	for i, mode := range []uint32{0600, 0640} {
+		want := api.Status_OK
+		if i > 0 {
+			want = api.Status_ERR_NOT_SYNC
+		}
+		resp, err := server.SetAttr(context.Background(), &api.SetAttrRequest{
+			FileHandle:  handle,
+			Credentials: creds,
+			Mode:        &mode,
+			GuardCtime:  seen,
+		})
+		if err != nil {
+			t.Fatalf("SetAttr failed: %v", err)
+		}
+		if resp.Status != want {
+			t.Errorf("Guarded SetAttr %d: status = %v, want %v", i+1, resp.Status, want)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(tempDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Mode = %v, want the first change only (0600)", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// shadowMaxPending is the most comparisons under way at once. Reads
+// arriving while that many are pending are served without one, so a slow
+// second backend never holds up the export.
+const shadowMaxPending = 64
+
+// shadowMaxNames is the most differing names a listing mismatch reports
+const shadowMaxNames = 5
+
+// shadowReader repeats the reads served from the export on a second file
+// system holding the same tree by path, such as the copy a migration is
+// moving the export to, and logs where the two disagree. Comparisons run
+// in the background after the response is sent. Times and file IDs differ
+// between backends by nature and are not compared.
+type shadowReader struct {
+	fileSystem fs.FileSystem
+	timeout    time.Duration
+	pending    chan struct{}
+
+	compared   atomic.Uint64
+	mismatches atomic.Uint64
+	failures   atomic.Uint64
+	skipped    atomic.Uint64
+}
+
+// newShadowReader creates a shadow reader comparing reads with fileSystem,
+// giving up on each once the request timeout passes
+func newShadowReader(fileSystem fs.FileSystem, config *Config) *shadowReader {
+	return &shadowReader{
+		fileSystem: fileSystem,
+		timeout:    config.RequestTimeout,
+		pending:    make(chan struct{}, shadowMaxPending),
+	}
+}
+
+// metrics reports the comparisons made in the form returned by GetMetrics,
+// or nothing if reads are not shadowed
+func (r *shadowReader) metrics() []*api.Metric {
+	if r == nil {
+		return nil
+	}
+	return []*api.Metric{
+		{Name: "shadow_compare_total", Value: float64(r.compared.Load()),
+			Help: "Reads repeated on the shadow backend and compared"},
+		{Name: "shadow_mismatch_total", Value: float64(r.mismatches.Load()),
+			Help: "Compared reads whose results differed between the backends"},
+		{Name: "shadow_failures_total", Value: float64(r.failures.Load()),
+			Help: "Reads the shadow backend did not answer in time"},
+		{Name: "shadow_skipped_total", Value: float64(r.skipped.Load()),
+			Help: "Reads not compared because too many comparisons were under way"},
+	}
+}
+
+// start runs compare in the background, unless too many comparisons are
+// under way. compare returns a description of how the backends differ, or
+// "" if they agree, and an error if the shadow backend did not answer.
+func (r *shadowReader) start(op string, path string, compare func(ctx context.Context) (string, error)) {
+	select {
+	case r.pending <- struct{}{}:
+	default:
+		r.skipped.Add(1)
+		return
+	}
+
+	go func() {
+		defer func() { <-r.pending }()
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+
+		mismatch, err := compare(ctx)
+		switch {
+		case err != nil:
+			r.failures.Add(1)
+			slog.Warn("Shadow read failed", "op", op, "path", path, "err", err)
+		case mismatch != "":
+			r.mismatches.Add(1)
+			slog.Warn("Shadow read mismatch", "op", op, "path", path, "mismatch", mismatch)
+		}
+		r.compared.Add(1)
+	}()
+}
+
+// compareStatus compares the outcome of a read on the shadow backend with
+// that on the export, returning ctx's error if the shadow backend did not
+// answer in time
+func compareStatus(ctx context.Context, got error, want api.Status) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if status := nfs.MapErrorToStatus(got); status != want {
+		return fmt.Sprintf("status %v, export has %v", status, want), nil
+	}
+	return "", nil
+}
+
+// compareAttr compares the attributes of path with those the export
+// returned
+func (r *shadowReader) compareAttr(op string, path string, want fs.FileInfo, wantErr error) {
+	if r == nil {
+		return
+	}
+	wantStatus := nfs.MapErrorToStatus(wantErr)
+	r.start(op, path, func(ctx context.Context) (string, error) {
+		got, err := r.fileSystem.GetAttr(ctx, path)
+		if mismatch, failed := compareStatus(ctx, err, wantStatus); mismatch != "" || failed != nil || err != nil {
+			return mismatch, failed
+		}
+
+		var diffs []string
+		if got.Type != want.Type {
+			diffs = append(diffs, fmt.Sprintf("type %v, export has %v", got.Type, want.Type))
+		}
+		if got.Size != want.Size {
+			diffs = append(diffs, fmt.Sprintf("size %d, export has %d", got.Size, want.Size))
+		}
+		if got.Mode != want.Mode {
+			diffs = append(diffs, fmt.Sprintf("mode %o, export has %o", got.Mode, want.Mode))
+		}
+		if got.Uid != want.Uid || got.Gid != want.Gid {
+			diffs = append(diffs, fmt.Sprintf("owner %d:%d, export has %d:%d", got.Uid, got.Gid, want.Uid, want.Gid))
+		}
+		return strings.Join(diffs, "; "), nil
+	})
+}
+
+// compareRead compares count bytes of path from offset with the data the
+// export returned. The shadow backend is read until count bytes or the
+// end of the file, as its reads may come back shorter.
+func (r *shadowReader) compareRead(path string, offset int64, count int, want []byte, wantEOF bool, wantErr error) {
+	if r == nil {
+		return
+	}
+	wantStatus := nfs.MapErrorToStatus(wantErr)
+	r.start("Read", path, func(ctx context.Context) (string, error) {
+		var got []byte
+		for len(got) < count {
+			chunk, eof, err := r.fileSystem.Read(ctx, path, offset+int64(len(got)), count-len(got))
+			if mismatch, failed := compareStatus(ctx, err, wantStatus); mismatch != "" || failed != nil || err != nil {
+				return mismatch, failed
+			}
+			got = append(got, chunk...)
+			if eof || len(chunk) == 0 {
+				break
+			}
+		}
+
+		// The export's read may have come back short without reaching the
+		// end of the file
+		if len(got) < len(want) || len(got) > len(want) && wantEOF {
+			return fmt.Sprintf("%d bytes at offset %d, export has %d", len(got), offset, len(want)), nil
+		}
+		got = got[:len(want)]
+		if !bytes.Equal(got, want) {
+			at := 0
+			for got[at] == want[at] {
+				at++
+			}
+			return fmt.Sprintf("data differs at offset %d", offset+int64(at)), nil
+		}
+		return "", nil
+	})
+}
+
+// compareListing compares the names in directory dir with the complete
+// listing the export returned
+func (r *shadowReader) compareListing(op string, dir string, want []fs.DirEntry, wantErr error) {
+	if r == nil {
+		return
+	}
+	wantStatus := nfs.MapErrorToStatus(wantErr)
+	wantNames := make(map[string]bool, len(want))
+	for _, entry := range want {
+		wantNames[entry.Name] = true
+	}
+	r.start(op, dir, func(ctx context.Context) (string, error) {
+		const pageSize = 1000
+		gotNames := make(map[string]bool, len(want))
+		var cookie int64
+		for {
+			page, next, err := r.fileSystem.ReadDir(ctx, dir, cookie, pageSize)
+			if mismatch, failed := compareStatus(ctx, err, wantStatus); mismatch != "" || failed != nil || err != nil {
+				return mismatch, failed
+			}
+			for _, entry := range page {
+				gotNames[entry.Name] = true
+			}
+			if len(page) < pageSize {
+				break
+			}
+			cookie = next
+		}
+
+		var diffs []string
+		if missing := namesMissing(wantNames, gotNames); len(missing) > 0 {
+			diffs = append(diffs, "missing "+strings.Join(missing, ", "))
+		}
+		if extra := namesMissing(gotNames, wantNames); len(extra) > 0 {
+			diffs = append(diffs, "extra "+strings.Join(extra, ", "))
+		}
+		return strings.Join(diffs, "; "), nil
+	})
+}
+
+// namesMissing returns up to shadowMaxNames of the names in want that are
+// not in got, sorted, with a count of the rest
+func namesMissing(want map[string]bool, got map[string]bool) []string {
+	var missing []string
+	for name := range want {
+		if !got[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > shadowMaxNames {
+		missing = append(missing[:shadowMaxNames], fmt.Sprintf("and %d more", len(missing)-shadowMaxNames))
+	}
+	return missing
+}
+
+// compareLink compares the target of the symbolic link at path with the
+// one the export returned
+func (r *shadowReader) compareLink(path string, want string, wantErr error) {
+	if r == nil {
+		return
+	}
+	wantStatus := nfs.MapErrorToStatus(wantErr)
+	r.start("Readlink", path, func(ctx context.Context) (string, error) {
+		got, err := r.fileSystem.Readlink(ctx, path)
+		if mismatch, failed := compareStatus(ctx, err, wantStatus); mismatch != "" || failed != nil || err != nil {
+			return mismatch, failed
+		}
+		if got != want {
+			return fmt.Sprintf("target %q, export has %q", got, want), nil
+		}
+		return "", nil
+	})
+}
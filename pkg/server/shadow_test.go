@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestShadowReads(t *testing.T) {
+	// The copy being migrated to has one file changed and one missing
+	newTree := func(changed string, other string) string {
+		dir := t.TempDir()
+		files := map[string]string{"same": "data", "changed": changed, "d/x": "x", "d/" + other: other}
+		for name, data := range files {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+		}
+		return dir
+	}
+	primary, err := local.NewLocalFileSystem(newTree("hello", "y"))
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	shadow, err := local.NewLocalFileSystem(newTree("hellO", "z"))
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.Shadow = shadow
+	if _, err := NewNFSServer(config, primary); err == nil {
+		t.Fatal("NewNFSServer shadowing a writable export succeeded")
+	}
+	config.ReadOnly = true
+	server, err := NewNFSServer(config, primary)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	handle := func(path string) []byte {
+		h, err := server.issueHandle(ctx, path)
+		if err != nil {
+			t.Fatalf("Failed to get file handle: %v", err)
+		}
+		return h
+	}
+	creds := &api.Credentials{Uid: 0, Gid: 0}
+
+	// Reads are served from the export whatever the copy holds
+	if resp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle("/same"), Credentials: creds}); err != nil || resp.Status != api.Status_OK {
+		t.Fatalf("GetAttr = %v, %v", resp, err)
+	}
+	for _, name := range []string{"/same", "/changed"} {
+		resp, err := server.Read(ctx, &api.ReadRequest{FileHandle: handle(name), Credentials: creds, Count: 100})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("Read of %s = %v, %v", name, resp, err)
+		}
+		if name == "/changed" && string(resp.Data) != "hello" {
+			t.Errorf("Read of %s returned %q, want the export's data", name, resp.Data)
+		}
+	}
+	if resp, err := server.ReadDir(ctx, &api.ReadDirRequest{DirectoryHandle: handle("/d"), Credentials: creds}); err != nil || resp.Status != api.Status_OK {
+		t.Fatalf("ReadDir = %v, %v", resp, err)
+	}
+
+	// and compared in the background: the changed file and the directory
+	// differ
+	deadline := time.Now().Add(5 * time.Second)
+	for server.shadow.compared.Load() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d reads compared, want 4", server.shadow.compared.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if mismatches := server.shadow.mismatches.Load(); mismatches != 2 {
+		t.Errorf("%d mismatches found, want 2", mismatches)
+	}
+	if failures := server.shadow.failures.Load(); failures != 0 {
+		t.Errorf("%d shadow reads failed", failures)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// snapshotter returns the export's file system as an fs.Snapshotter, or
+// the error of an RPC to an export that cannot take snapshots
+func (a *adminServer) snapshotter() (fs.Snapshotter, error) {
+	snapshotter, ok := fs.As[fs.Snapshotter](a.nfs.fileSystem)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the export does not support snapshots")
+	}
+	return snapshotter, nil
+}
+
+// snapshotError returns the status of a failed snapshot operation
+func snapshotError(err error) error {
+	switch {
+	case errors.Is(err, fs.ErrInvalidName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, fs.ErrExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// snapshotProto converts a snapshot description for the admin service
+func snapshotProto(info fs.SnapshotInfo) *api.Snapshot {
+	return &api.Snapshot{
+		Name:    info.Name,
+		Created: &api.FileTime{Seconds: info.CreateTime.Unix(), Nano: int32(info.CreateTime.Nanosecond())},
+		Files:   info.Files,
+		Cloned:  info.Cloned,
+	}
+}
+
+// CreateSnapshot implements the CreateSnapshot admin RPC
+func (a *adminServer) CreateSnapshot(ctx context.Context, req *api.CreateSnapshotRequest) (*api.CreateSnapshotResponse, error) {
+	snapshotter, err := a.snapshotter()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	info, err := snapshotter.Snapshot(ctx, req.Name)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	slog.InfoContext(ctx, "Snapshot taken", "name", info.Name, "files", info.Files, "cloned", info.Cloned,
+		"duration", time.Since(start))
+	return &api.CreateSnapshotResponse{
+		Snapshot:      snapshotProto(info),
+		DurationNanos: int64(time.Since(start)),
+	}, nil
+}
+
+// ListSnapshots implements the ListSnapshots admin RPC
+func (a *adminServer) ListSnapshots(ctx context.Context, req *api.ListSnapshotsRequest) (*api.ListSnapshotsResponse, error) {
+	snapshotter, err := a.snapshotter()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := snapshotter.ListSnapshots(ctx)
+	if err != nil {
+		return nil, snapshotError(err)
+	}
+	resp := &api.ListSnapshotsResponse{}
+	for _, info := range snapshots {
+		resp.Snapshots = append(resp.Snapshots, snapshotProto(info))
+	}
+	return resp, nil
+}
+
+// DeleteSnapshot implements the DeleteSnapshot admin RPC
+func (a *adminServer) DeleteSnapshot(ctx context.Context, req *api.DeleteSnapshotRequest) (*api.DeleteSnapshotResponse, error) {
+	snapshotter, err := a.snapshotter()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := snapshotter.DeleteSnapshot(ctx, req.Name); err != nil {
+		return nil, snapshotError(err)
+	}
+	slog.InfoContext(ctx, "Snapshot deleted", "name", req.Name)
+	return &api.DeleteSnapshotResponse{}, nil
+}
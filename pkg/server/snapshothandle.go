@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// snapshotPath returns where the file at path is in the snapshot called
+// snapshot
+func snapshotPath(snapshot string, p string) string {
+	return path.Join(fs.SnapshotsDir, snapshot, p)
+}
+
+// inSnapshots reports whether p is the snapshots directory or lies in it
+func inSnapshots(p string) bool {
+	return p == fs.SnapshotsDir || strings.HasPrefix(p, fs.SnapshotsDir+"/")
+}
+
+// SnapshotHandle implements the SnapshotHandle RPC method, decorating the
+// handle of a live file with a snapshot's name. GetAttr, Read and the
+// other calls taking the handle then act on the file at the same path in
+// the snapshot, so clients can read old versions of files they hold
+// handles for without mounting the snapshot.
+func (s *NFSServer) SnapshotHandle(ctx context.Context, req *api.SnapshotHandleRequest) (*api.SnapshotHandleResponse, error) {
+	reqID := fmt.Sprintf("snapshothandle-%d", time.Now().UnixNano())
+	clientAddr, _, _ := peerAddr(ctx)
+	result, err := s.processRequest(ctx, "SnapshotHandle", reqID, clientAddr, func() (interface{}, error) {
+		if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+			return &api.SnapshotHandleResponse{Status: api.Status_ERR_BADHANDLE}, nil
+		}
+		if _, ok := fs.As[fs.Snapshotter](s.fileSystem); !ok {
+			return &api.SnapshotHandleResponse{Status: api.Status_ERR_NOTSUPP}, nil
+		}
+		name := req.Snapshot
+		if name == "" || name == "." || name == ".." || len(name) > maxSnapshotNameSize || strings.ContainsAny(name, "/\x00") {
+			return &api.SnapshotHandleResponse{Status: api.Status_ERR_INVAL}, nil
+		}
+
+		// Only live files have versions in snapshots
+		if _, snapshot, _ := s.openHandle(req.FileHandle); snapshot != "" {
+			return &api.SnapshotHandleResponse{Status: api.Status_ERR_INVAL}, nil
+		}
+		live, err := s.resolveHandle(ctx, req.FileHandle)
+		if err != nil {
+			return &api.SnapshotHandleResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		if inSnapshots(live) {
+			return &api.SnapshotHandleResponse{Status: api.Status_ERR_INVAL}, nil
+		}
+
+		// The file has to be in the snapshot, and readable there
+		target := snapshotPath(name, live)
+		creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+		s.policy().squash(&creds)
+		if err := s.fileSystem.Access(ctx, target, fs.FileMode(4), creds); err != nil { // 4 = read
+			return &api.SnapshotHandleResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		info, err := s.fileSystem.GetAttr(ctx, target)
+		if err != nil {
+			return &api.SnapshotHandleResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+
+		return &api.SnapshotHandleResponse{
+			Status:     api.Status_OK,
+			FileHandle: SignSnapshotHandle(s.handleKey, req.FileHandle, name),
+			Attributes: nfs.FSInfoToProtoAttributes(info),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.SnapshotHandleResponse), nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+)
+
+func TestSnapshotHandle(t *testing.T) {
+	config, home := newExport(t, "home", "home.txt")
+	server, err := NewNFSServer(config, home)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	mediaConfig, media := newExport(t, "media", "media.txt")
+	mediaServer, err := server.AddExport(mediaConfig, media)
+	if err != nil {
+		t.Fatalf("AddExport failed: %v", err)
+	}
+	ctx := context.Background()
+	creds := &api.Credentials{}
+
+	// media.txt reads "media" in the snapshot, and more now
+	if _, err := media.Snapshot(ctx, "before"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	live, err := mediaServer.issueHandle(ctx, "/media.txt")
+	if err != nil {
+		t.Fatalf("Failed to get handle: %v", err)
+	}
+	if resp, err := mediaServer.Write(ctx, &api.WriteRequest{FileHandle: live, Credentials: creds, Offset: 5, Data: []byte(" library")}); err != nil || resp.Status != api.Status_OK {
+		t.Fatalf("Write = %v, %v", resp.GetStatus(), err)
+	}
+
+	decorated, err := mediaServer.SnapshotHandle(ctx, &api.SnapshotHandleRequest{FileHandle: live, Snapshot: "before", Credentials: creds})
+	if err != nil || decorated.Status != api.Status_OK || decorated.Attributes.Size != 5 {
+		t.Fatalf("SnapshotHandle = %v, %v; want the file's size in the snapshot", decorated, err)
+	}
+	old := decorated.FileHandle
+
+	// The decorated handle goes to the export of the file it decorates
+	if target, st := server.exports.route(&api.GetAttrRequest{FileHandle: old}); target != mediaServer || st != api.Status_OK {
+		t.Errorf("route of a snapshot handle = %v, %v; want the media export", target, st)
+	}
+
+	// Read and GetAttr see the file as it was; the live handle as it is
+	if attr, err := mediaServer.GetAttr(ctx, &api.GetAttrRequest{FileHandle: old, Credentials: creds}); err != nil || attr.Status != api.Status_OK || attr.Attributes.Size != 5 {
+		t.Errorf("GetAttr through the snapshot handle = %v, %v; want size 5", attr, err)
+	}
+	if read, err := mediaServer.Read(ctx, &api.ReadRequest{FileHandle: old, Credentials: creds, Count: 100}); err != nil || read.Status != api.Status_OK || string(read.Data) != "media" {
+		t.Errorf("Read through the snapshot handle = %v, %v; want %q", read, err, "media")
+	}
+	if read, err := mediaServer.Read(ctx, &api.ReadRequest{FileHandle: live, Credentials: creds, Count: 100}); err != nil || string(read.Data) != "media library" {
+		t.Errorf("Read through the live handle = %v, %v; want %q", read, err, "media library")
+	}
+
+	// Snapshots cannot be changed through it
+	if resp, err := mediaServer.Write(ctx, &api.WriteRequest{FileHandle: old, Credentials: creds, Data: []byte("x")}); err != nil || resp.Status != api.Status_ERR_ROFS {
+		t.Errorf("Write through the snapshot handle = %v, %v; want ERR_ROFS", resp.GetStatus(), err)
+	}
+
+	// Its snapshot name is signed
+	forged := append([]byte(nil), old...)
+	forged[len(forged)-handleMACSize-2] ^= 1
+	if attr, err := mediaServer.GetAttr(ctx, &api.GetAttrRequest{FileHandle: forged, Credentials: creds}); err != nil || attr.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("GetAttr through a forged snapshot handle = %v, %v; want ERR_BADHANDLE", attr.GetStatus(), err)
+	}
+
+	for _, test := range []struct {
+		handle   []byte
+		snapshot string
+		want     api.Status
+	}{
+		{live, "missing", api.Status_ERR_NOENT},
+		{live, "../before", api.Status_ERR_INVAL},
+		{live, "", api.Status_ERR_INVAL},
+		{old, "before", api.Status_ERR_INVAL},
+	} {
+		resp, err := mediaServer.SnapshotHandle(ctx, &api.SnapshotHandleRequest{FileHandle: test.handle, Snapshot: test.snapshot, Credentials: creds})
+		if err != nil || resp.Status != test.want {
+			t.Errorf("SnapshotHandle(%q) = %v, %v; want %v", test.snapshot, resp.GetStatus(), err, test.want)
+		}
+	}
+
+	// File systems without snapshots have no old versions to read
+	memServer, err := NewNFSServer(config, memfs.NewMemFileSystem())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	memRoot, err := memServer.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	if resp, _ := memServer.SnapshotHandle(ctx, &api.SnapshotHandleRequest{FileHandle: memRoot, Snapshot: "before", Credentials: creds}); resp.Status != api.Status_ERR_NOTSUPP {
+		t.Errorf("SnapshotHandle on memfs = %v, want ERR_NOTSUPP", resp.Status)
+	}
+}
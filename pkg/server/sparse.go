@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// Allocate implements the Allocate RPC method, reserving space for a range
+// of a file so later writes to it cannot run out
+func (s *NFSServer) Allocate(ctx context.Context, req *api.AllocateRequest) (*api.AllocateResponse, error) {
+	reqID := fmt.Sprintf("allocate-%d", time.Now().UnixNano())
+	clientAddr, _, _ := peerAddr(ctx)
+	result, err := s.processRequest(ctx, "Allocate", reqID, clientAddr, func() (interface{}, error) {
+		attrs, before, status := s.changeAllocation(ctx, req.FileHandle, req.Credentials, req.Offset, req.Length, false)
+		return &api.AllocateResponse{Status: status, Attributes: attrs, Before: before}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.AllocateResponse), nil
+}
+
+// Deallocate implements the Deallocate RPC method, punching a hole in a
+// range of a file: it reads as zeros and no longer takes space
+func (s *NFSServer) Deallocate(ctx context.Context, req *api.DeallocateRequest) (*api.DeallocateResponse, error) {
+	reqID := fmt.Sprintf("deallocate-%d", time.Now().UnixNano())
+	clientAddr, _, _ := peerAddr(ctx)
+	result, err := s.processRequest(ctx, "Deallocate", reqID, clientAddr, func() (interface{}, error) {
+		attrs, before, status := s.changeAllocation(ctx, req.FileHandle, req.Credentials, req.Offset, req.Length, true)
+		return &api.DeallocateResponse{Status: status, Attributes: attrs, Before: before}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.DeallocateResponse), nil
+}
+
+// changeAllocation carries out Allocate, or Deallocate if punch is set,
+// checked like a Write of the range
+func (s *NFSServer) changeAllocation(ctx context.Context, handle []byte, protoCreds *api.Credentials, offset, length uint64, punch bool) (*api.FileAttributes, *api.WccAttributes, api.Status) {
+	if _, err := s.validateFileHandle(handle); err != nil {
+		return nil, nil, api.Status_ERR_BADHANDLE
+	}
+	path, err := s.resolveHandle(ctx, handle)
+	if err != nil {
+		return nil, nil, nfs.MapErrorToStatus(err)
+	}
+	allocator, ok := fs.As[fs.Allocator](s.fileSystem)
+	if !ok {
+		return nil, nil, api.Status_ERR_NOTSUPP
+	}
+
+	creds := nfs.ProtoCredsToFSCreds(protoCreds)
+	s.policy().squash(&creds)
+	if err := s.fileSystem.Access(ctx, path, fs.FileMode(2), creds); err != nil { // 2 = write
+		return nil, nil, nfs.MapErrorToStatus(err)
+	}
+	s.fileDelegations.recall(ctx, s.fenceKey(handle), true)
+
+	info, status := s.regularFile(ctx, path)
+	if status != api.Status_OK {
+		return nil, nil, status
+	}
+	if length == 0 || offset > math.MaxInt64 || length > math.MaxInt64-offset {
+		return nil, nil, api.Status_ERR_INVAL
+	}
+	before := wccAttributes(info)
+
+	if punch {
+		err = allocator.Deallocate(ctx, path, int64(offset), int64(length))
+	} else {
+		err = allocator.Allocate(ctx, path, int64(offset), int64(length))
+	}
+	if err != nil {
+		return nil, nil, nfs.MapErrorToStatus(err)
+	}
+	s.watchers.notify(api.ChangeType_CHANGE_MODIFY, path, "")
+
+	newInfo, _ := s.fileSystem.GetAttr(ctx, path)
+	return nfs.FSInfoToProtoAttributes(newInfo), before, api.Status_OK
+}
+
+// Seek implements the Seek RPC method, finding the next data or hole at or
+// after an offset, so a client copying a sparse file can skip its holes
+func (s *NFSServer) Seek(ctx context.Context, req *api.SeekRequest) (*api.SeekResponse, error) {
+	reqID := fmt.Sprintf("seek-%d", time.Now().UnixNano())
+	clientAddr, _, _ := peerAddr(ctx)
+	result, err := s.processRequest(ctx, "Seek", reqID, clientAddr, func() (interface{}, error) {
+		if _, err := s.validateFileHandle(req.FileHandle); err != nil {
+			return &api.SeekResponse{Status: api.Status_ERR_BADHANDLE}, nil
+		}
+		path, err := s.resolveHandle(ctx, req.FileHandle)
+		if err != nil {
+			return &api.SeekResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		allocator, ok := fs.As[fs.Allocator](s.fileSystem)
+		if !ok {
+			return &api.SeekResponse{Status: api.Status_ERR_NOTSUPP}, nil
+		}
+
+		creds := nfs.ProtoCredsToFSCreds(req.Credentials)
+		s.policy().squash(&creds)
+		if err := s.fileSystem.Access(ctx, path, fs.FileMode(4), creds); err != nil { // 4 = read
+			return &api.SeekResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+		info, status := s.regularFile(ctx, path)
+		if status != api.Status_OK {
+			return &api.SeekResponse{Status: status}, nil
+		}
+		if req.Offset > math.MaxInt64 {
+			return &api.SeekResponse{Status: api.Status_ERR_NXIO}, nil
+		}
+
+		what, other := fs.SeekData, fs.SeekHole
+		if req.What == api.SeekContent_SEEK_HOLE {
+			what, other = fs.SeekHole, fs.SeekData
+		}
+		found, err := allocator.Seek(ctx, path, int64(req.Offset), what)
+		if err != nil {
+			return &api.SeekResponse{Status: nfs.MapErrorToStatus(err)}, nil
+		}
+
+		// The range found ends the file if what follows it does too
+		eof := found >= info.Size
+		if !eof {
+			next, err := allocator.Seek(ctx, path, found, other)
+			eof = errors.Is(err, fs.ErrNoData) || (err == nil && next >= info.Size)
+		}
+
+		return &api.SeekResponse{
+			Status:     api.Status_OK,
+			Offset:     uint64(found),
+			Eof:        eof,
+			Attributes: nfs.FSInfoToProtoAttributes(info),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*api.SeekResponse), nil
+}
+
+// regularFile returns the attributes of path, which must be a regular file
+func (s *NFSServer) regularFile(ctx context.Context, path string) (fs.FileInfo, api.Status) {
+	info, err := s.fileSystem.GetAttr(ctx, path)
+	if err != nil {
+		return info, nfs.MapErrorToStatus(err)
+	}
+	switch info.Type {
+	case fs.FileTypeRegular:
+		return info, api.Status_OK
+	case fs.FileTypeDirectory:
+		return info, api.Status_ERR_ISDIR
+	}
+	return info, api.Status_ERR_INVAL
+}
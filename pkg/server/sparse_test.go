@@ -0,0 +1,102 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/fs/memfs"
+)
+
+func TestSparse(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := context.Background()
+	creds := &api.Credentials{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	created, err := server.Create(ctx, &api.CreateRequest{DirectoryHandle: rootHandle, Name: "file", Credentials: creds})
+	if err != nil || created.Status != api.Status_OK {
+		t.Fatalf("Create = %v, %v", created.GetStatus(), err)
+	}
+	handle := created.FileHandle
+
+	// Allocating past the end extends the file
+	allocated, err := server.Allocate(ctx, &api.AllocateRequest{FileHandle: handle, Credentials: creds, Offset: 0, Length: 8192})
+	if err != nil || allocated.Status != api.Status_OK || allocated.Attributes.Size != 8192 || allocated.Before.Size != 0 {
+		t.Fatalf("Allocate = %v, %v; want size 8192", allocated, err)
+	}
+	written, err := server.Write(ctx, &api.WriteRequest{FileHandle: handle, Credentials: creds, Offset: 65536, Data: bytes.Repeat([]byte("x"), 4096)})
+	if err != nil || written.Status != api.Status_OK {
+		t.Fatalf("Write = %v, %v", written.GetStatus(), err)
+	}
+	punched, err := server.Deallocate(ctx, &api.DeallocateRequest{FileHandle: handle, Credentials: creds, Offset: 0, Length: 8192})
+	if err != nil || punched.Status != api.Status_OK || punched.Attributes.Size != 69632 {
+		t.Fatalf("Deallocate = %v, %v; want the size kept", punched, err)
+	}
+
+	seeks := []struct {
+		offset uint64
+		what   api.SeekContent
+		want   uint64
+		eof    bool
+		status api.Status
+	}{
+		{0, api.SeekContent_SEEK_DATA, 65536, true, api.Status_OK},
+		{0, api.SeekContent_SEEK_HOLE, 0, false, api.Status_OK},
+		{65536, api.SeekContent_SEEK_HOLE, 69632, true, api.Status_OK},
+		{69632, api.SeekContent_SEEK_DATA, 0, false, api.Status_ERR_NXIO},
+	}
+	for _, s := range seeks {
+		resp, err := server.Seek(ctx, &api.SeekRequest{FileHandle: handle, Credentials: creds, Offset: s.offset, What: s.what})
+		if err != nil || resp.Status != s.status || resp.Offset != s.want || resp.Eof != s.eof {
+			t.Errorf("Seek(%d, %v) = %v, %v; want offset %d, eof %v, %v", s.offset, s.what, resp, err, s.want, s.eof, s.status)
+		}
+	}
+
+	if resp, _ := server.Allocate(ctx, &api.AllocateRequest{FileHandle: handle, Credentials: creds, Length: 0}); resp.Status != api.Status_ERR_INVAL {
+		t.Errorf("Allocate of nothing = %v, want ERR_INVAL", resp.Status)
+	}
+	if resp, _ := server.Seek(ctx, &api.SeekRequest{FileHandle: rootHandle, Credentials: creds}); resp.Status != api.Status_ERR_ISDIR {
+		t.Errorf("Seek in a directory = %v, want ERR_ISDIR", resp.Status)
+	}
+
+	// File systems that cannot say where the holes are do not pretend to
+	memServer, err := NewNFSServer(config, memfs.NewMemFileSystem())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	memRoot, err := memServer.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	memFile, err := memServer.Create(ctx, &api.CreateRequest{DirectoryHandle: memRoot, Name: "file", Credentials: creds})
+	if err != nil || memFile.Status != api.Status_OK {
+		t.Fatalf("Create = %v, %v", memFile.GetStatus(), err)
+	}
+	if resp, _ := memServer.Seek(ctx, &api.SeekRequest{FileHandle: memFile.FileHandle, Credentials: creds}); resp.Status != api.Status_ERR_NOTSUPP {
+		t.Errorf("Seek on memfs = %v, want ERR_NOTSUPP", resp.Status)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// fileOwner returns the owner and group of the file at path
+func fileOwner(t *testing.T, path string) (uint32, uint32) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
+
+// fileID returns the inode number of the file at path
+func fileID(t *testing.T, path string) uint64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return uint64(info.Sys().(*syscall.Stat_t).Ino)
+}
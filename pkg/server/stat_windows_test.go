@@ -0,0 +1,39 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileOwner returns the owner and group of the file at path. Windows has
+// no POSIX owners, and the local backend shows every file owned by root.
+func fileOwner(t *testing.T, path string) (uint32, uint32) {
+	t.Helper()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return 0, 0
+}
+
+// fileID returns the NTFS file ID of the file at path, which the local
+// backend uses as its inode number
+func fileID(t *testing.T, path string) uint64 {
+	t.Helper()
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		t.Fatalf("Bad path %s: %v", path, err)
+	}
+	share := uint32(windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE)
+	h, err := windows.CreateFile(name, windows.FILE_READ_ATTRIBUTES, share, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer windows.CloseHandle(h)
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestSymlink(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, "private"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	privateHandle, err := server.issueHandle(context.Background(), "/private")
+	if err != nil {
+		t.Fatalf("Failed to get directory handle: %v", err)
+	}
+	user := &api.Credentials{Uid: 1000, Gid: 1000}
+
+	tests := []struct {
+		name   string
+		dir    []byte
+		link   string
+		target string
+		creds  *api.Credentials
+		want   api.Status
+	}{
+		{"Relative target", rootHandle, "ok", "private", &api.Credentials{Uid: 0, Gid: 0}, api.Status_OK},
+		{"Absolute target", rootHandle, "abs", "/etc/passwd", &api.Credentials{Uid: 0, Gid: 0}, api.Status_ERR_INVAL},
+		{"Escaping target", privateHandle, "up", "../../secret", &api.Credentials{Uid: 0, Gid: 0}, api.Status_ERR_INVAL},
+		{"Existing name", rootHandle, "private", "ok", &api.Credentials{Uid: 0, Gid: 0}, api.Status_ERR_EXIST},
+		{"No write permission", privateHandle, "mine", "..", user, api.Status_ERR_ACCES},
+		{"Name with a slash", rootHandle, "a/b", "private", &api.Credentials{Uid: 0, Gid: 0}, api.Status_ERR_INVAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Symlink(context.Background(), &api.SymlinkRequest{
+				DirectoryHandle: tt.dir,
+				Name:            tt.link,
+				Target:          tt.target,
+				Credentials:     tt.creds,
+			})
+			if err != nil {
+				t.Fatalf("Symlink returned error: %v", err)
+			}
+			if resp.Status != tt.want {
+				t.Fatalf("Symlink returned %v, want %v", resp.Status, tt.want)
+			}
+			if tt.want != api.Status_OK {
+				return
+			}
+
+			if resp.Attributes.GetType() != api.FileType_SYMLINK || resp.Attributes.GetUid() != tt.creds.Uid {
+				t.Errorf("New link has attributes %v", resp.Attributes)
+			}
+			readResp, err := server.Readlink(context.Background(), &api.ReadlinkRequest{FileHandle: resp.FileHandle, Credentials: user})
+			if err != nil || readResp.Status != api.Status_OK || readResp.Target != tt.target {
+				t.Errorf("Readlink returned %v, %q, %v; want %q", readResp.GetStatus(), readResp.GetTarget(), err, tt.target)
+			}
+		})
+	}
+
+	// Only links have a target
+	resp, err := server.Readlink(context.Background(), &api.ReadlinkRequest{FileHandle: privateHandle, Credentials: user})
+	if err != nil || resp.Status != api.Status_ERR_INVAL {
+		t.Errorf("Readlink of a directory returned %v, %v; want ERR_INVAL", resp.GetStatus(), err)
+	}
+}
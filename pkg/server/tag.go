@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// tagMetadataKey is the gRPC metadata key clients use to tag requests.
+// It must match client.TagMetadataKey.
+const tagMetadataKey = "x-nfs-tag"
+
+// maxTagLength bounds how much of a client-supplied tag is recorded
+const maxTagLength = 64
+
+// requestTag returns the sanitized workload tag sent with a request, or ""
+func requestTag(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(tagMetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	// Tags end up in log lines, so drop anything that could forge or
+	// break up a line
+	tag := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, values[0])
+	if len(tag) > maxTagLength {
+		tag = strings.ToValidUTF8(tag[:maxTagLength], "")
+	}
+	return tag
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestTag(t *testing.T) {
+	testCases := []struct {
+		name string
+		md   metadata.MD
+		want string
+	}{
+		{"No metadata", nil, ""},
+		{"Untagged", metadata.Pairs("other", "x"), ""},
+		{"Tagged", metadata.Pairs(tagMetadataKey, "build-42"), "build-42"},
+		{"Control characters", metadata.Pairs(tagMetadataKey, "job\nNFS request: forged"), "jobNFS request: forged"},
+		{"Too long", metadata.Pairs(tagMetadataKey, strings.Repeat("x", 100)), strings.Repeat("x", maxTagLength)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+			if got := requestTag(ctx); got != tc.want {
+				t.Errorf("requestTag() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
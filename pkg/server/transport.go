@@ -0,0 +1,29 @@
+package server
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+// transportOptions sizes gRPC's message limits to the configured transfer
+// sizes and applies the keepalive settings
+func (s *NFSServer) transportOptions() []grpc.ServerOption {
+	limit := nfs.MessageLimit(max(s.config.MaxReadSize, s.config.MaxWriteSize))
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(limit),
+		grpc.MaxSendMsgSize(limit),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             s.config.KeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+	if s.config.KeepaliveTime > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    s.config.KeepaliveTime,
+			Timeout: s.config.KeepaliveTimeout,
+		}))
+	}
+	return opts
+}
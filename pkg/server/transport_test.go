@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/nfs"
+)
+
+func TestTransfersAboveDefaultMessageLimit(t *testing.T) {
+	fs, err := local.NewLocalFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	config.MaxReadSize = 6 << 20
+	config.MaxWriteSize = 6 << 20
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(server.transportOptions()...)
+	api.RegisterNFSServiceServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	// A client accepting anything, so only the server's limits apply
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1<<30), grpc.MaxCallSendMsgSize(1<<30)))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	nfsClient := api.NewNFSServiceClient(conn)
+
+	ctx := context.Background()
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	created, err := nfsClient.Create(ctx, &api.CreateRequest{DirectoryHandle: rootHandle, Name: "big", Credentials: root})
+	if err != nil || created.Status != api.Status_OK {
+		t.Fatalf("Create failed: %v, %v", err, created.GetStatus())
+	}
+
+	// A whole maximum-sized transfer fits one message each way
+	data := bytes.Repeat([]byte("0123456789abcdef"), config.MaxWriteSize/16)
+	write, err := nfsClient.Write(ctx, &api.WriteRequest{FileHandle: created.FileHandle, Data: data, Credentials: root})
+	if err != nil || write.Status != api.Status_OK || int(write.Count) != len(data) {
+		t.Fatalf("Write of %d bytes returned %v, %v", len(data), err, write)
+	}
+	read, err := nfsClient.Read(ctx, &api.ReadRequest{FileHandle: created.FileHandle, Count: uint32(config.MaxReadSize), Credentials: root})
+	if err != nil || read.Status != api.Status_OK || !bytes.Equal(read.Data, data) {
+		t.Fatalf("Read of %d bytes returned %v, status %v, %d bytes", config.MaxReadSize, err, read.GetStatus(), len(read.GetData()))
+	}
+
+	// Messages beyond the limit derived from the sizes are refused
+	tooBig := make([]byte, nfs.MessageLimit(config.MaxWriteSize))
+	_, err = nfsClient.Write(ctx, &api.WriteRequest{FileHandle: created.FileHandle, Data: tooBig, Credentials: root})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Write above the message limit returned %v, want ResourceExhausted", err)
+	}
+}
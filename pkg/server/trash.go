@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/nfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// trashJob is a tree moved into the file system's trash by a background
+// RemoveTree, or found there on startup
+type trashJob struct {
+	id        uint64
+	path      string // Where it was removed from ("" if orphaned)
+	trashPath string
+	creds     fs.Credentials
+	queued    time.Time
+	state     api.TrashState
+
+	// Set once deletion starts; its counters are the job's progress
+	remover *treeRemover
+
+	failedPath string
+	err        error
+}
+
+// trashQueue deletes the trees in the trash one after another, each with
+// the credentials of the client that removed it, so a background removal
+// deletes no more than the same RemoveTree in the foreground would have.
+// Worker threads serving requests only pay for the rename into the trash.
+type trashQueue struct {
+	mu      sync.Mutex
+	jobs    []*trashJob
+	nextID  uint64
+	running bool
+}
+
+// newTrashQueue creates an empty queue
+func newTrashQueue() *trashQueue {
+	return &trashQueue{nextID: 1}
+}
+
+// add appends a job for the tree at trashPath in the given state,
+// returning its id
+func (q *trashQueue) add(origPath string, trashPath string, creds fs.Credentials, state api.TrashState) uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := &trashJob{
+		id:        q.nextID,
+		path:      origPath,
+		trashPath: trashPath,
+		creds:     creds,
+		queued:    time.Now(),
+		state:     state,
+	}
+	q.nextID++
+	q.jobs = append(q.jobs, job)
+	return job.id
+}
+
+// next marks the first queued job as being deleted and returns it, or
+// reports that the worker may stop
+func (q *trashQueue) next() (*trashJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.state == api.TrashState_TRASH_QUEUED {
+			job.state = api.TrashState_TRASH_DELETING
+			return job, true
+		}
+	}
+	q.running = false
+	return nil, false
+}
+
+// finish drops a deleted job, or records why its deletion failed
+func (q *trashQueue) finish(job *trashJob, failedPath string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		job.state = api.TrashState_TRASH_FAILED
+		job.failedPath = failedPath
+		job.err = err
+		return
+	}
+	for i, j := range q.jobs {
+		if j == job {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			break
+		}
+	}
+}
+
+// drop removes a job whose tree left the trash
+func (q *trashQueue) drop(job *trashJob) {
+	q.finish(job, "", nil)
+}
+
+// requeue queues the failed and orphaned jobs among ids (every one if ids
+// is empty) again, deleting them as root, and returns how many it queued
+func (q *trashQueue) requeue(ids []uint64) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wanted := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	queued := 0
+	for _, job := range q.jobs {
+		if len(ids) > 0 && !wanted[job.id] {
+			continue
+		}
+		if job.state != api.TrashState_TRASH_FAILED && job.state != api.TrashState_TRASH_ORPHANED {
+			continue
+		}
+		job.state = api.TrashState_TRASH_QUEUED
+		job.creds = fs.Credentials{}
+		job.remover, job.failedPath, job.err = nil, "", nil
+		queued++
+	}
+	return queued
+}
+
+// startWorker reports whether a worker needs starting, marking one as
+// running if so
+func (q *trashQueue) startWorker() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running {
+		return false
+	}
+	q.running = true
+	return true
+}
+
+// list describes the jobs in queue order
+func (q *trashQueue) list() []*api.TrashEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]*api.TrashEntry, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		entry := &api.TrashEntry{
+			Id:         job.id,
+			Path:       job.path,
+			TrashPath:  job.trashPath,
+			State:      job.state,
+			Queued:     &api.FileTime{Seconds: job.queued.Unix(), Nano: int32(job.queued.Nanosecond())},
+			Uid:        job.creds.UID,
+			FailedPath: job.failedPath,
+		}
+		if job.remover != nil {
+			progress := job.remover.progress()
+			entry.FilesRemoved = progress.FilesRemoved
+			entry.DirectoriesRemoved = progress.DirectoriesRemoved
+			entry.BytesRemoved = progress.BytesRemoved
+		}
+		if job.err != nil {
+			entry.Status = nfs.MapErrorToStatus(job.err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// trashTree moves the tree at treePath into the trash and queues it for
+// deletion with creds, returning its id in the queue
+func (s *NFSServer) trashTree(ctx context.Context, treePath string, creds fs.Credentials) (uint64, error) {
+	trasher, ok := fs.As[fs.Trasher](s.fileSystem)
+	if !ok {
+		return 0, fs.ErrNotSupported
+	}
+	trashPath, err := trasher.Trash(ctx, treePath)
+	if err != nil {
+		return 0, err
+	}
+	id := s.trash.add(path.Clean("/"+treePath), trashPath, creds, api.TrashState_TRASH_QUEUED)
+	slog.InfoContext(ctx, "Tree moved to the trash", "path", treePath, "trash", trashPath, "id", id)
+	s.wakeTrash()
+	return id, nil
+}
+
+// adoptTrash lists what an earlier run left in the trash. Whose it was is
+// not known, so it is only deleted once an operator purges it.
+func (s *NFSServer) adoptTrash(ctx context.Context) error {
+	trasher, ok := fs.As[fs.Trasher](s.fileSystem)
+	if !ok {
+		return nil
+	}
+	paths, err := trasher.Trashed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, trashPath := range paths {
+		s.trash.add("", trashPath, fs.Credentials{}, api.TrashState_TRASH_ORPHANED)
+	}
+	if len(paths) > 0 {
+		slog.Warn("Trash holds entries from an earlier run; delete them with nfsadmin trash purge", "count", len(paths))
+	}
+	return nil
+}
+
+// wakeTrash starts deleting queued trees unless that is already under way
+func (s *NFSServer) wakeTrash() {
+	if s.trash.startWorker() {
+		go s.emptyTrash()
+	}
+}
+
+// emptyTrash deletes queued trees until none are left. A tree that cannot
+// be deleted completely is put back where it was removed from if that
+// name is still free, leaving it as a failed RemoveTree would, and is
+// otherwise kept in the trash for an operator to purge.
+func (s *NFSServer) emptyTrash() {
+	ctx := context.Background()
+	for {
+		job, ok := s.trash.next()
+		if !ok {
+			return
+		}
+		remover := newTreeRemover(s.fileSystem, job.creds, s.config.MaxRemoveTreeConcurrency, s.metrics)
+		s.trash.mu.Lock()
+		job.remover = remover
+		s.trash.mu.Unlock()
+
+		failedPath, err := remover.run(ctx, job.trashPath)
+		if err == nil {
+			slog.Info("Trash entry deleted", "id", job.id, "path", job.path,
+				"files", remover.files.Load(), "dirs", remover.dirs.Load())
+			s.trash.finish(job, "", nil)
+			continue
+		}
+
+		if job.path != "" {
+			if restoreErr := s.fileSystem.Rename(ctx, job.trashPath, job.path, fs.RenameNoReplace); restoreErr == nil {
+				slog.Warn("Background removal failed; tree put back", "id", job.id, "path", job.path,
+					"failed", failedPath, "err", err)
+				s.delegations.recall(path.Dir(job.path))
+				s.watchers.notify(api.ChangeType_CHANGE_CREATE, job.path, "")
+				s.trash.drop(job)
+				continue
+			}
+		}
+		slog.Error("Background removal failed; tree left in the trash", "id", job.id, "path", job.path,
+			"trash", job.trashPath, "failed", failedPath, "err", err)
+		s.trash.finish(job, failedPath, err)
+	}
+}
+
+// ListTrash implements the ListTrash admin RPC
+func (a *adminServer) ListTrash(ctx context.Context, req *api.ListTrashRequest) (*api.ListTrashResponse, error) {
+	if _, ok := fs.As[fs.Trasher](a.nfs.fileSystem); !ok {
+		return nil, status.Error(codes.Unimplemented, "the export has no trash")
+	}
+	return &api.ListTrashResponse{Entries: a.nfs.trash.list()}, nil
+}
+
+// PurgeTrash implements the PurgeTrash admin RPC
+func (a *adminServer) PurgeTrash(ctx context.Context, req *api.PurgeTrashRequest) (*api.PurgeTrashResponse, error) {
+	if _, ok := fs.As[fs.Trasher](a.nfs.fileSystem); !ok {
+		return nil, status.Error(codes.Unimplemented, "the export has no trash")
+	}
+	queued := a.nfs.trash.requeue(req.Ids)
+	if queued > 0 {
+		slog.InfoContext(ctx, "Trash purge queued", "entries", queued)
+		a.nfs.wakeTrash()
+	}
+	return &api.PurgeTrashResponse{Queued: uint32(queued)}, nil
+}
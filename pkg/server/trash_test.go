@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestRemoveTreeInBackground(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Chmod(tempDir, 0777); err != nil {
+		t.Fatalf("Failed to open up the temp dir: %v", err)
+	}
+	for _, dir := range []string{"big/a/b", "locked/sub"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0777); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, dir, "file"), []byte("data"), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	// Anyone may delete from locked, but only root from locked/sub
+	if err := os.Chmod(filepath.Join(tempDir, "locked"), 0777); err != nil {
+		t.Fatalf("Failed to open up directory: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(tempDir, "locked/sub"), 0755); err != nil {
+		t.Fatalf("Failed to lock directory: %v", err)
+	}
+
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	admin := &adminServer{nfs: server}
+	ctx := context.Background()
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	removeTree := func(name string, creds *api.Credentials) *api.RemoveTreeProgress {
+		t.Helper()
+		stream := &progressStream{}
+		err := server.RemoveTree(&api.RemoveTreeRequest{
+			DirectoryHandle: rootHandle, Name: name, Credentials: creds, Background: true,
+		}, stream)
+		if err != nil || len(stream.sent) != 1 {
+			t.Fatalf("RemoveTree sent %v, %v; want a single message", stream.sent, err)
+		}
+		return stream.sent[0]
+	}
+	settle := func() []*api.TrashEntry {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			list, err := admin.ListTrash(ctx, &api.ListTrashRequest{})
+			if err != nil {
+				t.Fatalf("ListTrash failed: %v", err)
+			}
+			busy := false
+			for _, entry := range list.Entries {
+				busy = busy || entry.State == api.TrashState_TRASH_QUEUED || entry.State == api.TrashState_TRASH_DELETING
+			}
+			if !busy {
+				return list.Entries
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Trash still busy: %v", list.Entries)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The tree is gone from the namespace as soon as the call returns, and
+	// from the disk once the queue is worked through
+	final := removeTree("big", &api.Credentials{})
+	if !final.Done || final.Status != api.Status_OK || final.TrashId == 0 {
+		t.Fatalf("Background RemoveTree = %v", final)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "big")); !os.IsNotExist(err) {
+		t.Errorf("Removed tree still in place: %v", err)
+	}
+	if entries := settle(); len(entries) != 0 {
+		t.Errorf("Trash after deleting = %v, want empty", entries)
+	}
+	trashed, err := lfs.Trashed(ctx)
+	if err != nil || len(trashed) != 0 {
+		t.Errorf("Trashed = %v, %v; want nothing left", trashed, err)
+	}
+
+	// A tree the caller cannot delete entirely is put back
+	if final := removeTree("locked", &api.Credentials{Uid: 1000, Gid: 1000}); final.Status != api.Status_OK {
+		t.Fatalf("Background RemoveTree of a locked tree = %v", final)
+	}
+	if entries := settle(); len(entries) != 0 {
+		t.Errorf("Trash after a failed deletion = %v, want empty", entries)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "locked/sub/file")); err != nil {
+		t.Errorf("Locked tree not put back: %v", err)
+	}
+
+	// Entries left by an earlier run wait for a purge, which deletes them
+	// as root
+	if _, err := lfs.Trash(ctx, "/locked"); err != nil {
+		t.Fatalf("Trash failed: %v", err)
+	}
+	restarted, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := restarted.prepare(); err != nil {
+		t.Fatalf("prepare failed: %v", err)
+	}
+	admin = &adminServer{nfs: restarted}
+	entries := settle()
+	if len(entries) != 1 || entries[0].State != api.TrashState_TRASH_ORPHANED {
+		t.Fatalf("Trash after a restart = %v, want one orphaned entry", entries)
+	}
+	purge, err := admin.PurgeTrash(ctx, &api.PurgeTrashRequest{})
+	if err != nil || purge.Queued != 1 {
+		t.Fatalf("PurgeTrash = %v, %v", purge, err)
+	}
+	if entries := settle(); len(entries) != 0 {
+		t.Errorf("Trash after purging = %v, want empty", entries)
+	}
+	if trashed, err := lfs.Trashed(ctx); err != nil || len(trashed) != 0 {
+		t.Errorf("Trashed after purging = %v, %v; want nothing left", trashed, err)
+	}
+}
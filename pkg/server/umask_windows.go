@@ -0,0 +1,9 @@
+//go:build windows
+
+package server
+
+// umask does nothing: Windows has no file mode creation mask, and file
+// modes beyond the read-only attribute are not stored anyway
+func umask(mask int) int {
+	return 0
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestCreateUnnamedAndLinkIntoPlace(t *testing.T) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "nfs-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.WriteFile(filepath.Join(tempDir, "taken.txt"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Create filesystem
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+
+	// Create server without root squashing so root may write the temp dir
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx := context.Background()
+	root := &api.Credentials{Uid: 0, Gid: 0}
+	rootHandle, err := server.issueHandle(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+
+	// Create and fill an unnamed file
+	createResp, err := server.CreateUnnamed(ctx, &api.CreateUnnamedRequest{
+		DirectoryHandle: rootHandle,
+		Credentials:     root,
+		Attributes:      &api.FileAttributes{Mode: 0600},
+	})
+	if err != nil {
+		t.Fatalf("CreateUnnamed failed: %v", err)
+	}
+	if createResp.Status != api.Status_OK {
+		t.Fatalf("Unexpected CreateUnnamed status: %v", createResp.Status)
+	}
+	handle := createResp.FileHandle
+
+	writeResp, err := server.Write(ctx, &api.WriteRequest{
+		FileHandle:  handle,
+		Credentials: root,
+		Data:        []byte("staged"),
+		Stability:   2,
+	})
+	if err != nil || writeResp.Status != api.Status_OK {
+		t.Fatalf("Write to unnamed file failed: %v, %v", err, writeResp.GetStatus())
+	}
+
+	// The staging area is not visible through the export
+	readDirResp, err := server.ReadDir(ctx, &api.ReadDirRequest{DirectoryHandle: rootHandle, Credentials: root})
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, entry := range readDirResp.Entries {
+		if entry.Name != "." && entry.Name != ".." && entry.Name != "taken.txt" {
+			t.Errorf("Unexpected entry before linking: %s", entry.Name)
+		}
+	}
+
+	// Test cases
+	testCases := []struct {
+		name       string
+		file       []byte
+		dir        []byte
+		target     string
+		replace    bool
+		wantStatus api.Status
+	}{
+		{"Name taken", handle, rootHandle, "taken.txt", false, api.Status_ERR_EXIST},
+		{"Escaping name", handle, rootHandle, "../escape.txt", false, api.Status_ERR_INVAL},
+		{"Publish", handle, rootHandle, "published.txt", false, api.Status_OK},
+		{"Already published", handle, rootHandle, "again.txt", false, api.Status_ERR_INVAL},
+		{"Not an unnamed file", rootHandle, rootHandle, "root.txt", false, api.Status_ERR_INVAL},
+		{"Invalid handle", []byte{1, 2, 3}, rootHandle, "x.txt", false, api.Status_ERR_BADHANDLE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := server.LinkIntoPlace(ctx, &api.LinkIntoPlaceRequest{
+				FileHandle:      tc.file,
+				DirectoryHandle: tc.dir,
+				Name:            tc.target,
+				Credentials:     root,
+				Replace:         tc.replace,
+			})
+			if err != nil {
+				t.Fatalf("LinkIntoPlace failed: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("Unexpected status: got %v, want %v", resp.Status, tc.wantStatus)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "published.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read published file: %v", err)
+	}
+	if string(data) != "staged" {
+		t.Errorf("Wrong content after publishing: %q", data)
+	}
+
+	// The handle keeps working after the file is published
+	getAttrResp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle, Credentials: root})
+	if err != nil || getAttrResp.Status != api.Status_OK {
+		t.Fatalf("GetAttr on published file failed: %v, %v", err, getAttrResp.GetStatus())
+	}
+	if getAttrResp.Attributes.Size != 6 {
+		t.Errorf("Unexpected size: %d", getAttrResp.Attributes.Size)
+	}
+
+	// Replacing an existing name is allowed when requested
+	createResp, err = server.CreateUnnamed(ctx, &api.CreateUnnamedRequest{DirectoryHandle: rootHandle, Credentials: root})
+	if err != nil || createResp.Status != api.Status_OK {
+		t.Fatalf("CreateUnnamed failed: %v, %v", err, createResp.GetStatus())
+	}
+	linkResp, err := server.LinkIntoPlace(ctx, &api.LinkIntoPlaceRequest{
+		FileHandle:      createResp.FileHandle,
+		DirectoryHandle: rootHandle,
+		Name:            "taken.txt",
+		Credentials:     root,
+		Replace:         true,
+	})
+	if err != nil || linkResp.Status != api.Status_OK {
+		t.Fatalf("LinkIntoPlace with replace failed: %v, %v", err, linkResp.GetStatus())
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "taken.txt")); len(data) != 0 {
+		t.Errorf("Replaced file still has old content: %q", data)
+	}
+
+	// Invalid directory handle
+	resp, err := server.CreateUnnamed(ctx, &api.CreateUnnamedRequest{DirectoryHandle: []byte{1, 2, 3}, Credentials: root})
+	if err != nil {
+		t.Fatalf("CreateUnnamed failed: %v", err)
+	}
+	if resp.Status != api.Status_ERR_BADHANDLE {
+		t.Errorf("Unexpected status: got %v, want %v", resp.Status, api.Status_ERR_BADHANDLE)
+	}
+}
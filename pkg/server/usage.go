@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+const (
+	// Layout of the day keys, in UTC
+	usageDayFormat = "2006-01-02"
+
+	// Days of usage kept before the oldest are dropped
+	usageRetentionDays = 400
+
+	// How often usage is written to Config.UsageFile
+	usageSaveInterval = time.Minute
+)
+
+// usageCounts is the traffic of one uid on one day
+type usageCounts struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	Reads      uint64 `json:"reads"`
+	Writes     uint64 `json:"writes"`
+}
+
+// usageFile is the on-disk form of a usage ledger
+type usageFile struct {
+	Export string                             `json:"export"`
+	Days   map[string]map[string]*usageCounts `json:"days"` // day -> uid -> counts
+}
+
+// usageLedger accounts the bytes read and written per uid and day, for
+// chargeback on shared exports. Requests are charged to the identity they
+// act as, after root squashing and anonymous mapping.
+type usageLedger struct {
+	mu     sync.Mutex
+	export string
+	file   string // "" = kept in memory only
+	days   map[string]map[uint32]*usageCounts
+	dirty  bool
+
+	// Serializes saves, so an older snapshot never replaces a newer one
+	saveMu sync.Mutex
+
+	// Clock, replaceable in tests
+	now func() time.Time
+}
+
+// newUsageLedger creates a ledger for export, loading earlier days from
+// file if it exists
+func newUsageLedger(export string, file string) (*usageLedger, error) {
+	u := &usageLedger{
+		export: export,
+		file:   file,
+		days:   make(map[string]map[uint32]*usageCounts),
+		now:    time.Now,
+	}
+	if file == "" {
+		return u, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage file: %w", err)
+	}
+
+	var saved usageFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse usage file %s: %w", file, err)
+	}
+	for day, uids := range saved.Days {
+		byUID := make(map[uint32]*usageCounts, len(uids))
+		for uid, counts := range uids {
+			n, err := strconv.ParseUint(uid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse usage file %s: bad uid %q", file, uid)
+			}
+			byUID[uint32(n)] = counts
+		}
+		u.days[day] = byUID
+	}
+	return u, nil
+}
+
+// record charges one read or write of n bytes to uid
+func (u *usageLedger) record(uid uint32, n int, write bool) {
+	day := u.now().UTC().Format(usageDayFormat)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	byUID, ok := u.days[day]
+	if !ok {
+		byUID = make(map[uint32]*usageCounts)
+		u.days[day] = byUID
+		u.expireLocked()
+	}
+	counts, ok := byUID[uid]
+	if !ok {
+		counts = &usageCounts{}
+		byUID[uid] = counts
+	}
+	if write {
+		counts.Writes++
+		counts.WriteBytes += uint64(n)
+	} else {
+		counts.Reads++
+		counts.ReadBytes += uint64(n)
+	}
+	u.dirty = true
+}
+
+// expireLocked drops days past the retention period; u.mu must be held
+func (u *usageLedger) expireLocked() {
+	oldest := u.now().UTC().AddDate(0, 0, -usageRetentionDays).Format(usageDayFormat)
+	for day := range u.days {
+		// Day keys sort chronologically
+		if day < oldest {
+			delete(u.days, day)
+		}
+	}
+}
+
+// report returns the usage from day from through day to (inclusive, as
+// YYYY-MM-DD; empty means unbounded), ordered by day and uid
+func (u *usageLedger) report(from, to string) []*api.UsageRecord {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var records []*api.UsageRecord
+	for day, byUID := range u.days {
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		for uid, counts := range byUID {
+			records = append(records, &api.UsageRecord{
+				Day:        day,
+				Uid:        uid,
+				ReadBytes:  counts.ReadBytes,
+				WriteBytes: counts.WriteBytes,
+				Reads:      counts.Reads,
+				Writes:     counts.Writes,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Day != records[j].Day {
+			return records[i].Day < records[j].Day
+		}
+		return records[i].Uid < records[j].Uid
+	})
+	return records
+}
+
+// save writes the ledger to its file if anything changed since the last
+// save. The file is replaced atomically, so a crash leaves the previous copy.
+func (u *usageLedger) save() error {
+	if u.file == "" {
+		return nil
+	}
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+
+	u.mu.Lock()
+	if !u.dirty {
+		u.mu.Unlock()
+		return nil
+	}
+	saved := usageFile{Export: u.export, Days: make(map[string]map[string]*usageCounts, len(u.days))}
+	for day, byUID := range u.days {
+		uids := make(map[string]*usageCounts, len(byUID))
+		for uid, counts := range byUID {
+			c := *counts
+			uids[strconv.FormatUint(uint64(uid), 10)] = &c
+		}
+		saved.Days[day] = uids
+	}
+	u.dirty = false
+	u.mu.Unlock()
+
+	data, err := json.MarshalIndent(&saved, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(u.file, data)
+	}
+	if err != nil {
+		// Try again at the next save
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+	return nil
+}
+
+// saveLoop saves the ledger periodically until the process exits
+func (u *usageLedger) saveLoop() {
+	ticker := time.NewTicker(usageSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := u.save(); err != nil {
+			slog.Error("Saving usage failed", "err", err)
+		}
+	}
+}
+
+// writeFileAtomic replaces file with data through a temporary file in the
+// same directory
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// SaveUsage writes the bandwidth accounting to Config.UsageFile now,
+// instead of waiting for the next periodic save. Call it before exiting.
+func (s *NFSServer) SaveUsage() error {
+	return s.usage.save()
+}
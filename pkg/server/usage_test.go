@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+func TestUsageLedger(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.json")
+	u, err := newUsageLedger("/srv/shared", file)
+	if err != nil {
+		t.Fatalf("newUsageLedger failed: %v", err)
+	}
+
+	day := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return day }
+	u.record(1000, 4096, false)
+	u.record(1000, 100, true)
+	u.record(2000, 10, true)
+	day = day.Add(2 * time.Hour)
+	u.record(1000, 1, false)
+
+	want := []*api.UsageRecord{
+		{Day: "2026-03-01", Uid: 1000, ReadBytes: 4096, WriteBytes: 100, Reads: 1, Writes: 1},
+		{Day: "2026-03-01", Uid: 2000, WriteBytes: 10, Writes: 1},
+		{Day: "2026-03-02", Uid: 1000, ReadBytes: 1, Reads: 1},
+	}
+	check := func(name string, got, want []*api.UsageRecord) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d records, want %d: %v", name, len(got), len(want), got)
+		}
+		for i := range want {
+			g, w := got[i], want[i]
+			if g.Day != w.Day || g.Uid != w.Uid || g.ReadBytes != w.ReadBytes || g.WriteBytes != w.WriteBytes ||
+				g.Reads != w.Reads || g.Writes != w.Writes {
+				t.Errorf("%s: record %d = %v, want %v", name, i, g, w)
+			}
+		}
+	}
+	check("All days", u.report("", ""), want)
+	check("Second day", u.report("2026-03-02", "2026-03-02"), want[2:])
+	check("Up to the first day", u.report("", "2026-03-01"), want[:2])
+
+	// Saved usage survives a restart
+	if err := u.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	reloaded, err := newUsageLedger("/srv/shared", file)
+	if err != nil {
+		t.Fatalf("Reloading usage failed: %v", err)
+	}
+	check("Reloaded", reloaded.report("", ""), want)
+
+	// Days past the retention period are dropped as new days start
+	day = day.AddDate(0, 0, usageRetentionDays+1)
+	u.record(3000, 1, false)
+	check("After expiry", u.report("", ""), []*api.UsageRecord{
+		{Day: day.Format(usageDayFormat), Uid: 3000, ReadBytes: 1, Reads: 1},
+	})
+
+	// A damaged file is reported rather than silently reset
+	if err := os.WriteFile(file, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to damage usage file: %v", err)
+	}
+	if _, err := newUsageLedger("/srv/shared", file); err == nil {
+		t.Error("Loading a damaged usage file succeeded")
+	}
+}
+
+func TestUsageAccounting(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "data.bin"), make([]byte, 1000), 0666); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(tempDir, "data.bin"), 0666); err != nil {
+		t.Fatalf("Failed to chmod test file: %v", err)
+	}
+
+	fs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.ExportName = "scratch"
+	server, err := NewNFSServer(config, fs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	handle, err := server.issueHandle(context.Background(), "/data.bin")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	user := &api.Credentials{Uid: 1000, Gid: 1000}
+	root := &api.Credentials{Uid: 0, Gid: 0}
+
+	readResp, err := server.Read(context.Background(), &api.ReadRequest{FileHandle: handle, Count: 600, Credentials: user})
+	if err != nil || readResp.Status != api.Status_OK {
+		t.Fatalf("Read = %v, %v", readResp.GetStatus(), err)
+	}
+	writeResp, err := server.Write(context.Background(), &api.WriteRequest{FileHandle: handle, Data: []byte("hello"), Credentials: user})
+	if err != nil || writeResp.Status != api.Status_OK {
+		t.Fatalf("Write = %v, %v", writeResp.GetStatus(), err)
+	}
+
+	// Root is squashed, so its read is charged to the anonymous uid
+	readResp, err = server.Read(context.Background(), &api.ReadRequest{FileHandle: handle, Offset: 900, Count: 600, Credentials: root})
+	if err != nil || readResp.Status != api.Status_OK {
+		t.Fatalf("Read = %v, %v", readResp.GetStatus(), err)
+	}
+
+	// Failed requests are not charged
+	if resp, _ := server.Read(context.Background(), &api.ReadRequest{FileHandle: []byte{1}, Count: 10, Credentials: user}); resp.Status == api.Status_OK {
+		t.Fatal("Read with a bad handle succeeded")
+	}
+
+	admin := &adminServer{nfs: server}
+	resp, err := admin.GetUsage(context.Background(), &api.GetUsageRequest{})
+	if err != nil {
+		t.Fatalf("GetUsage failed: %v", err)
+	}
+	if resp.Export != "scratch" {
+		t.Errorf("Export = %q, want scratch", resp.Export)
+	}
+	if len(resp.Records) != 2 {
+		t.Fatalf("Got %d usage records, want 2: %v", len(resp.Records), resp.Records)
+	}
+	if r := resp.Records[0]; r.Uid != 1000 || r.ReadBytes != 600 || r.WriteBytes != 5 || r.Reads != 1 || r.Writes != 1 {
+		t.Errorf("Usage of uid 1000 = %v", r)
+	}
+	if r := resp.Records[1]; r.Uid != config.AnonUID || r.ReadBytes != 100 || r.Reads != 1 {
+		t.Errorf("Usage of the anonymous uid = %v", r)
+	}
+
+	if _, err := admin.GetUsage(context.Background(), &api.GetUsageRequest{FromDay: "yesterday"}); err == nil {
+		t.Error("GetUsage accepted an invalid day")
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+// corruptingFS reports writes as successful but stores every byte inverted,
+// like a disk that silently fails
+type corruptingFS struct {
+	fs.FileSystem
+}
+
+func (c *corruptingFS) Write(ctx context.Context, path string, offset int64, data []byte, sync bool) (int, error) {
+	bad := make([]byte, len(data))
+	for i, b := range data {
+		bad[i] = ^b
+	}
+	return c.FileSystem.Write(ctx, path, offset, bad, sync)
+}
+
+func TestVerifyWrites(t *testing.T) {
+	const unstable, fileSync = 0, 2
+
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "ledger.db"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	fileHandle, err := localFS.PathToFileHandle(context.Background(), "/ledger.db")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+
+	newServer := func(fileSystem fs.FileSystem) *NFSServer {
+		config := DefaultConfig()
+		config.EnableRootSquash = false
+		config.VerifyWrites = true
+		server, err := NewNFSServer(config, fileSystem)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		return server
+	}
+	write := func(server *NFSServer, data string, stability uint32) api.Status {
+		resp, err := server.Write(context.Background(), &api.WriteRequest{
+			FileHandle:  SignHandle(server.handleKey, fileHandle),
+			Credentials: &api.Credentials{Uid: 0, Gid: 0},
+			Data:        []byte(data),
+			Stability:   stability,
+		})
+		if err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		return resp.Status
+	}
+
+	// Intact FILE_SYNC writes are verified and acknowledged; others are not
+	// read back
+	server := newServer(localFS)
+	if status := write(server, "entry 1", fileSync); status != api.Status_OK {
+		t.Errorf("Verified write = %v, want OK", status)
+	}
+	if status := write(server, "entry 2", unstable); status != api.Status_OK {
+		t.Errorf("Unstable write = %v, want OK", status)
+	}
+	if n := server.verifyStats.writes.Load(); n != 1 {
+		t.Errorf("Verified %d writes, want 1", n)
+	}
+	if n := server.verifyStats.bytes.Load(); n != uint64(len("entry 1")) {
+		t.Errorf("Read back %d bytes, want %d", n, len("entry 1"))
+	}
+
+	// Data that does not read back intact is reported as an I/O error
+	server = newServer(&corruptingFS{localFS})
+	if status := write(server, "entry 3", fileSync); status != api.Status_ERR_IO {
+		t.Errorf("Write with corrupted storage = %v, want ERR_IO", status)
+	}
+	if n := server.verifyStats.failures.Load(); n != 1 {
+		t.Errorf("Recorded %d verification failures, want 1", n)
+	}
+	if server.dupCache.len() != 0 {
+		t.Error("Failed write was cached for replay")
+	}
+
+	admin := &adminServer{nfs: server}
+	resp, err := admin.GetMetrics(context.Background(), &api.GetMetricsRequest{})
+	if err != nil {
+		t.Fatalf("GetMetrics returned error: %v", err)
+	}
+	for _, m := range resp.Metrics {
+		if m.Name == "write_verify_failures_total" && m.Value != 1 {
+			t.Errorf("write_verify_failures_total = %v, want 1", m.Value)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// warmPaths resolves the configured hot directories before clients arrive.
+// Minting handles for each directory, its ancestors and its entries fills
+// the file system's inode index, and reading their attributes brings them
+// into the operating system's caches, so the first requests after a restart
+// do not pay for either. Paths that cannot be resolved are logged and
+// skipped. It returns the number of entries warmed.
+func (s *NFSServer) warmPaths(ctx context.Context, paths []string) int {
+	warmed := 0
+	for _, p := range paths {
+		dir := filepath.Clean("/" + strings.TrimSpace(p))
+
+		// The root and every ancestor first, as a client walking down to
+		// dir would resolve them
+		walked := "/"
+		err := s.warmEntry(ctx, walked)
+		for _, component := range strings.Split(strings.TrimPrefix(dir, "/"), "/") {
+			if err != nil || component == "" {
+				continue
+			}
+			warmed++
+			walked = filepath.Join(walked, component)
+			err = s.warmEntry(ctx, walked)
+		}
+		if err != nil {
+			slog.Warn("Warming failed", "path", p, "err", err)
+			continue
+		}
+		warmed++
+
+		entries, _, err := s.fileSystem.ReadDirPlus(ctx, dir, 0, 0)
+		if err != nil {
+			slog.Warn("Warming failed", "path", p, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			if _, err := s.fileSystem.PathToFileHandle(ctx, filepath.Join(dir, entry.Name)); err == nil {
+				warmed++
+			}
+		}
+	}
+	return warmed
+}
+
+// warmEntry mints a handle for path and reads its attributes
+func (s *NFSServer) warmEntry(ctx context.Context, path string) error {
+	if _, err := s.fileSystem.PathToFileHandle(ctx, path); err != nil {
+		return err
+	}
+	_, err := s.fileSystem.GetAttr(ctx, path)
+	return err
+}
+
+// warmOnStartup warms the configured hot paths and logs how long it took
+func (s *NFSServer) warmOnStartup() {
+	if len(s.config.WarmPaths) == 0 {
+		return
+	}
+	start := time.Now()
+	n := s.warmPaths(context.Background(), s.config.WarmPaths)
+	slog.Info("Warmed hot paths", "entries", n, "paths", len(s.config.WarmPaths), "duration", time.Since(start))
+}
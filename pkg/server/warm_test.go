@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/fs"
+	"github.com/example/nfsserver/pkg/fs/local"
+)
+
+// mintingFS records the paths handles are minted for
+type mintingFS struct {
+	fs.FileSystem
+
+	mu     sync.Mutex
+	minted []string
+}
+
+func (m *mintingFS) PathToFileHandle(ctx context.Context, path string) ([]byte, error) {
+	handle, err := m.FileSystem.PathToFileHandle(ctx, path)
+	if err == nil {
+		m.mu.Lock()
+		m.minted = append(m.minted, path)
+		m.mu.Unlock()
+	}
+	return handle, err
+}
+
+func TestWarmPaths(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"projects/hot", "cold"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+	for _, name := range []string{"projects/hot/a", "projects/hot/b", "cold/c"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	localFS, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	recorder := &mintingFS{FileSystem: localFS}
+	server, err := NewNFSServer(DefaultConfig(), recorder)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// A missing path is skipped without stopping the others
+	n := server.warmPaths(context.Background(), []string{"/missing/dir", " projects/hot/ "})
+
+	want := []string{"/", "/", "/projects", "/projects/hot", "/projects/hot/a", "/projects/hot/b"}
+	got := append([]string(nil), recorder.minted...)
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Fatalf("Minted handles for %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Minted handles for %v, want %v", got, want)
+		}
+	}
+	if n != len(want) {
+		t.Errorf("warmPaths returned %d, want %d", n, len(want))
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/nfsserver/pkg/api"
+)
+
+// watchBuffer is how many events a watcher may fall behind by before
+// further events are dropped
+const watchBuffer = 256
+
+// watcher is one client's subscription to the changes below a directory
+type watcher struct {
+	root      string
+	recursive bool
+	events    chan *api.ChangeEvent
+
+	// Set when an event was dropped because events was full
+	overflowed atomic.Bool
+}
+
+// covers reports whether a change to p is reported to the watcher: the
+// watched directory itself, its entries and, if recursive, everything below
+func (w *watcher) covers(p string) bool {
+	if p == w.root {
+		return true
+	}
+	if w.recursive {
+		return w.root == "/" || strings.HasPrefix(p, w.root+"/")
+	}
+	return path.Dir(p) == w.root
+}
+
+// watchers tracks the open Watch streams
+type watchers struct {
+	mu  sync.Mutex
+	set map[*watcher]struct{}
+}
+
+// newWatchers creates an empty registry
+func newWatchers() *watchers {
+	return &watchers{set: make(map[*watcher]struct{})}
+}
+
+// subscribe starts reporting changes below dirPath
+func (ws *watchers) subscribe(dirPath string, recursive bool) *watcher {
+	w := &watcher{
+		root:      path.Clean("/" + dirPath),
+		recursive: recursive,
+		events:    make(chan *api.ChangeEvent, watchBuffer),
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.set[w] = struct{}{}
+	return w
+}
+
+// unsubscribe stops reporting changes to w
+func (ws *watchers) unsubscribe(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.set, w)
+}
+
+// notify reports a change of the given type to every watcher covering p or,
+// for renames, newPath. It never blocks: watchers that fall behind lose
+// events and are told so with CHANGE_OVERFLOW.
+func (ws *watchers) notify(change api.ChangeType, p string, newPath string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.set) == 0 {
+		return
+	}
+
+	event := &api.ChangeEvent{
+		Status: api.Status_OK,
+		Type:   change,
+		Path:   path.Clean("/" + p),
+		TimeNs: time.Now().UnixNano(),
+	}
+	if newPath != "" {
+		event.NewPath = path.Clean("/" + newPath)
+	}
+
+	for w := range ws.set {
+		if !w.covers(event.Path) && (event.NewPath == "" || !w.covers(event.NewPath)) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			w.overflowed.Store(true)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/example/nfsserver/pkg/api"
+    "github.com/example/nfsserver/pkg/fs/local"
+    "google.golang.org/grpc"
+)
+
+// watchStream delivers the events of a Watch call
+type watchStream struct {
+    grpc.ServerStream
+    ctx    context.Context
+    events chan *api.ChangeEvent
+}
+
+func (w *watchStream) Context() context.Context {
+    return w.ctx
+}
+
+func (w *watchStream) Send(msg *api.ChangeEvent) error {
+    w.events <- msg
+    return nil
+}
+
+func TestWatch(t *testing.T) {
+    tempDir := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(tempDir, "src", "lib"), 0755); err != nil {
+        t.Fatalf("Failed to create directory: %v", err)
+    }
+    if err := os.WriteFile(filepath.Join(tempDir, "src", "main.c"), []byte("int main;"), 0644); err != nil {
+        t.Fatalf("Failed to create test file: %v", err)
+    }
+
+    fs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+
+    dirHandle, err := server.issueHandle(context.Background(), "/src")
+    if err != nil {
+        t.Fatalf("Failed to get directory handle: %v", err)
+    }
+    libHandle, err := server.issueHandle(context.Background(), "/src/lib")
+    if err != nil {
+        t.Fatalf("Failed to get directory handle: %v", err)
+    }
+    fileHandle, err := server.issueHandle(context.Background(), "/src/main.c")
+    if err != nil {
+        t.Fatalf("Failed to get file handle: %v", err)
+    }
+    root := &api.Credentials{Uid: 0, Gid: 0, Groups: []uint32{0}}
+
+    // watch starts a Watch call
+    watch := func(handle []byte, recursive bool) (*watchStream, context.CancelFunc, chan error) {
+        ctx, cancel := context.WithCancel(context.Background())
+        stream := &watchStream{ctx: ctx, events: make(chan *api.ChangeEvent, 16)}
+        done := make(chan error, 1)
+        go func() {
+            done <- server.Watch(&api.WatchRequest{DirectoryHandle: handle, Recursive: recursive, Credentials: root}, stream)
+        }()
+        return stream, cancel, done
+    }
+    next := func(stream *watchStream) *api.ChangeEvent {
+        select {
+        case event := <-stream.events:
+            return event
+        case <-time.After(5 * time.Second):
+            t.Fatal("Timed out waiting for a change event")
+            return nil
+        }
+    }
+    expect := func(stream *watchStream, change api.ChangeType, path, newPath string) {
+        t.Helper()
+        event := next(stream)
+        if event.Type != change || event.Path != path || event.NewPath != newPath {
+            t.Errorf("Event = %v %q %q, want %v %q %q", event.Type, event.Path, event.NewPath, change, path, newPath)
+        }
+        if event.TimeNs == 0 {
+            t.Error("Event carries no time")
+        }
+    }
+
+    flat, cancelFlat, doneFlat := watch(dirHandle, false)
+    defer cancelFlat()
+    deep, cancelDeep, doneDeep := watch(dirHandle, true)
+    defer cancelDeep()
+    expect(flat, api.ChangeType_CHANGE_WATCHING, "/src", "")
+    expect(deep, api.ChangeType_CHANGE_WATCHING, "/src", "")
+
+    // Only directories can be watched
+    stream, cancel, done := watch(fileHandle, false)
+    defer cancel()
+    if refused := next(stream); refused.Status != api.Status_ERR_NOTDIR {
+        t.Errorf("Watch of a file = %v, want ERR_NOTDIR", refused.Status)
+    }
+    if err := <-done; err != nil {
+        t.Errorf("Watch returned error: %v", err)
+    }
+
+    // A new file is reported to both watchers
+    createResp, err := server.Create(context.Background(), &api.CreateRequest{
+        DirectoryHandle: dirHandle,
+        Name:            "util.c",
+        Credentials:     root,
+        Attributes:      &api.FileAttributes{Mode: 0644},
+    })
+    if err != nil || createResp.Status != api.Status_OK {
+        t.Fatalf("Create = %v, %v", createResp.GetStatus(), err)
+    }
+    expect(flat, api.ChangeType_CHANGE_CREATE, "/src/util.c", "")
+    expect(deep, api.ChangeType_CHANGE_CREATE, "/src/util.c", "")
+
+    writeResp, err := server.Write(context.Background(), &api.WriteRequest{
+        FileHandle:  fileHandle,
+        Data:        []byte("int main(void);"),
+        Credentials: root,
+    })
+    if err != nil || writeResp.Status != api.Status_OK {
+        t.Fatalf("Write = %v, %v", writeResp.GetStatus(), err)
+    }
+    expect(flat, api.ChangeType_CHANGE_MODIFY, "/src/main.c", "")
+    expect(deep, api.ChangeType_CHANGE_MODIFY, "/src/main.c", "")
+
+    // Changes in a subdirectory only reach the recursive watcher
+    mkdirResp, err := server.Mkdir(context.Background(), &api.MkdirRequest{
+        DirectoryHandle: libHandle,
+        Name:            "include",
+        Credentials:     root,
+        Attributes:      &api.FileAttributes{Mode: 0755},
+    })
+    if err != nil || mkdirResp.Status != api.Status_OK {
+        t.Fatalf("Mkdir = %v, %v", mkdirResp.GetStatus(), err)
+    }
+    expect(deep, api.ChangeType_CHANGE_CREATE, "/src/lib/include", "")
+
+    // A rename out of the watched directory is still reported
+    renameResp, err := server.Rename(context.Background(), &api.RenameRequest{
+        FromDirectoryHandle: dirHandle,
+        FromName:            "util.c",
+        ToDirectoryHandle:   libHandle,
+        ToName:              "util.c",
+        Credentials:         root,
+    })
+    if err != nil || renameResp.Status != api.Status_OK {
+        t.Fatalf("Rename = %v, %v", renameResp.GetStatus(), err)
+    }
+    expect(flat, api.ChangeType_CHANGE_RENAME, "/src/util.c", "/src/lib/util.c")
+    expect(deep, api.ChangeType_CHANGE_RENAME, "/src/util.c", "/src/lib/util.c")
+
+    removeResp, err := server.Remove(context.Background(), &api.RemoveRequest{
+        DirectoryHandle: dirHandle,
+        Name:            "main.c",
+        Credentials:     root,
+    })
+    if err != nil || removeResp.Status != api.Status_OK {
+        t.Fatalf("Remove = %v, %v", removeResp.GetStatus(), err)
+    }
+    expect(flat, api.ChangeType_CHANGE_DELETE, "/src/main.c", "")
+    expect(deep, api.ChangeType_CHANGE_DELETE, "/src/main.c", "")
+
+    // Closing the streams unsubscribes them
+    cancelFlat()
+    cancelDeep()
+    for _, done := range []chan error{doneFlat, doneDeep} {
+        if err := <-done; err != nil {
+            t.Errorf("Watch returned error after cancel: %v", err)
+        }
+    }
+    server.watchers.mu.Lock()
+    count := len(server.watchers.set)
+    server.watchers.mu.Unlock()
+    if count != 0 {
+        t.Errorf("%d watchers left after the streams were closed", count)
+    }
+}
+
+func TestWatchOverflow(t *testing.T) {
+    ws := newWatchers()
+    w := ws.subscribe("/logs", false)
+
+    // A watcher that never reads loses events beyond its buffer
+    for i := 0; i < watchBuffer+10; i++ {
+        ws.notify(api.ChangeType_CHANGE_MODIFY, "/logs/app.log", "")
+    }
+    if len(w.events) != watchBuffer {
+        t.Errorf("Buffered %d events, want %d", len(w.events), watchBuffer)
+    }
+    if !w.overflowed.Load() {
+        t.Error("Dropped events were not recorded")
+    }
+}
+
+func TestWatcherCovers(t *testing.T) {
+    testCases := []struct {
+        root      string
+        recursive bool
+        path      string
+        want      bool
+    }{
+        {"/src", false, "/src", true},
+        {"/src", false, "/src/main.c", true},
+        {"/src", false, "/src/lib/util.c", false},
+        {"/src", false, "/srcs/main.c", false},
+        {"/src", true, "/src/lib/util.c", true},
+        {"/src", true, "/srcs/main.c", false},
+        {"/src", true, "/", false},
+        {"/", false, "/README", true},
+        {"/", true, "/src/lib/util.c", true},
+    }
+
+    ws := newWatchers()
+    for _, tc := range testCases {
+        w := ws.subscribe(tc.root, tc.recursive)
+        if got := w.covers(tc.path); got != tc.want {
+            t.Errorf("Watcher of %s (recursive %v) covers %s = %v, want %v", tc.root, tc.recursive, tc.path, got, tc.want)
+        }
+    }
+}
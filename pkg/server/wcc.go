@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs"
+)
+
+// wccAttributes returns the part of info clients check their cached
+// attributes against
+func wccAttributes(info fs.FileInfo) *api.WccAttributes {
+	return &api.WccAttributes{
+		Size:  uint64(info.Size),
+		Mtime: &api.FileTime{Seconds: info.ModifyTime.Unix(), Nano: int32(info.ModifyTime.Nanosecond())},
+		Ctime: &api.FileTime{Seconds: info.ChangeTime.Unix(), Nano: int32(info.ChangeTime.Nanosecond())},
+	}
+}
+
+// wccBefore returns the attributes of path ahead of an operation changing
+// it, or nil if they cannot be read. As on most NFSv3 servers they are read
+// just before, not atomically with, the operation, so a change slipping in
+// between goes unnoticed.
+func (s *NFSServer) wccBefore(ctx context.Context, path string) *api.WccAttributes {
+	info, err := s.fileSystem.GetAttr(ctx, path)
+	if err != nil {
+		return nil
+	}
+	return wccAttributes(info)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/example/nfsserver/pkg/api"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWeakCacheConsistency(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	lfs, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := DefaultConfig()
+	config.EnableRootSquash = false
+	server, err := NewNFSServer(config, lfs)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	ctx := context.Background()
+	creds := &api.Credentials{}
+	rootHandle, err := server.issueHandle(ctx, "/")
+	if err != nil {
+		t.Fatalf("Failed to get root handle: %v", err)
+	}
+	fileHandle, err := server.issueHandle(ctx, "/file")
+	if err != nil {
+		t.Fatalf("Failed to get file handle: %v", err)
+	}
+	cached := func(handle []byte) *api.WccAttributes {
+		t.Helper()
+		resp, err := server.GetAttr(ctx, &api.GetAttrRequest{FileHandle: handle, Credentials: creds})
+		if err != nil || resp.Status != api.Status_OK {
+			t.Fatalf("GetAttr = %v, %v", resp.GetStatus(), err)
+		}
+		attrs := resp.Attributes
+		return &api.WccAttributes{Size: attrs.Size, Mtime: attrs.Mtime, Ctime: attrs.Ctime}
+	}
+
+	// The attributes before a write match what a client last saw
+	fileAttrs := cached(fileHandle)
+	write, err := server.Write(ctx, &api.WriteRequest{FileHandle: fileHandle, Credentials: creds, Offset: 4, Data: []byte("more")})
+	if err != nil || write.Status != api.Status_OK {
+		t.Fatalf("Write = %v, %v", write.GetStatus(), err)
+	}
+	if !proto.Equal(write.Before, fileAttrs) {
+		t.Errorf("Write before = %v, want %v", write.Before, fileAttrs)
+	}
+	if write.Attributes.Size != 8 {
+		t.Errorf("Write after size = %d, want 8", write.Attributes.Size)
+	}
+
+	fileAttrs = cached(fileHandle)
+	size := uint64(2)
+	setAttr, err := server.SetAttr(ctx, &api.SetAttrRequest{FileHandle: fileHandle, Credentials: creds, Size: &size})
+	if err != nil || setAttr.Status != api.Status_OK {
+		t.Fatalf("SetAttr = %v, %v", setAttr.GetStatus(), err)
+	}
+	if !proto.Equal(setAttr.Before, fileAttrs) || setAttr.Attributes.Size != 2 {
+		t.Errorf("SetAttr before = %v, after size %d; want %v, 2", setAttr.Before, setAttr.Attributes.Size, fileAttrs)
+	}
+
+	// and so do those of the directory before entries are added or removed
+	dirAttrs := cached(rootHandle)
+	create, err := server.Create(ctx, &api.CreateRequest{DirectoryHandle: rootHandle, Name: "new", Credentials: creds})
+	if err != nil || create.Status != api.Status_OK {
+		t.Fatalf("Create = %v, %v", create.GetStatus(), err)
+	}
+	if !proto.Equal(create.DirBefore, dirAttrs) || create.DirAttributes == nil {
+		t.Errorf("Create dir before = %v, want %v", create.DirBefore, dirAttrs)
+	}
+
+	dirAttrs = cached(rootHandle)
+	mkdir, err := server.Mkdir(ctx, &api.MkdirRequest{DirectoryHandle: rootHandle, Name: "dir", Credentials: creds})
+	if err != nil || mkdir.Status != api.Status_OK {
+		t.Fatalf("Mkdir = %v, %v", mkdir.GetStatus(), err)
+	}
+	if !proto.Equal(mkdir.DirBefore, dirAttrs) {
+		t.Errorf("Mkdir dir before = %v, want %v", mkdir.DirBefore, dirAttrs)
+	}
+
+	dirAttrs = cached(rootHandle)
+	remove, err := server.Remove(ctx, &api.RemoveRequest{DirectoryHandle: rootHandle, Name: "new", Credentials: creds})
+	if err != nil || remove.Status != api.Status_OK {
+		t.Fatalf("Remove = %v, %v", remove.GetStatus(), err)
+	}
+	if !proto.Equal(remove.DirBefore, dirAttrs) || remove.DirAttributes == nil {
+		t.Errorf("Remove dir before = %v, want %v", remove.DirBefore, dirAttrs)
+	}
+
+	// A failed operation carries none
+	remove, err = server.Remove(ctx, &api.RemoveRequest{DirectoryHandle: rootHandle, Name: "missing", Credentials: creds})
+	if err != nil || remove.Status != api.Status_ERR_NOENT || remove.DirBefore != nil {
+		t.Errorf("Remove of a missing name = %v, %v; want ERR_NOENT without attributes", remove, err)
+	}
+}
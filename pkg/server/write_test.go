@@ -1,9 +1,13 @@
 package server
 
 import (
+    "bytes"
     "context"
+    "fmt"
     "os"
     "path/filepath"
+    "sort"
+    "sync"
     "testing"
 
     "github.com/example/nfsserver/pkg/api"
@@ -44,7 +48,7 @@ func TestWrite(t *testing.T) {
     }
 
     // Get file handle
-    fileHandle, err := fs.PathToFileHandle("/testfile.txt")
+    fileHandle, err := server.issueHandle(context.Background(), "/testfile.txt")
     if err != nil {
         t.Fatalf("Failed to get file handle: %v", err)
     }
@@ -175,4 +179,86 @@ func TestWrite(t *testing.T) {
     if respInv.Status != api.Status_ERR_BADHANDLE {
         t.Errorf("Expected ERR_BADHANDLE status for invalid handle, got: %v", respInv.Status)
     }
-}
\ No newline at end of file
+}
+func TestWriteAppend(t *testing.T) {
+    // Create temporary directory
+    tempDir, err := os.MkdirTemp("", "nfs-test-")
+    if err != nil {
+        t.Fatalf("Failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(tempDir)
+
+    // Create a log file with existing content
+    testFilePath := filepath.Join(tempDir, "app.log")
+    if err := os.WriteFile(testFilePath, []byte("header\n"), 0666); err != nil {
+        t.Fatalf("Failed to create test file: %v", err)
+    }
+
+    // Create filesystem and server
+    fs, err := local.NewLocalFileSystem(tempDir)
+    if err != nil {
+        t.Fatalf("Failed to create filesystem: %v", err)
+    }
+    config := DefaultConfig()
+    config.EnableRootSquash = false
+    server, err := NewNFSServer(config, fs)
+    if err != nil {
+        t.Fatalf("Failed to create server: %v", err)
+    }
+
+    fileHandle, err := server.issueHandle(context.Background(), "/app.log")
+    if err != nil {
+        t.Fatalf("Failed to get file handle: %v", err)
+    }
+    creds := &api.Credentials{Uid: 0, Gid: 0}
+
+    // Many writers append identical-length lines at a stale offset of 0
+    const writers = 20
+    lines := make([]string, writers)
+    offsets := make([]uint64, writers)
+    var wg sync.WaitGroup
+    for i := 0; i < writers; i++ {
+        lines[i] = fmt.Sprintf("line from writer %02d\n", i)
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            resp, err := server.Write(context.Background(), &api.WriteRequest{
+                FileHandle:  fileHandle,
+                Credentials: creds,
+                Offset:      0,
+                Data:        []byte(lines[i]),
+                Append:      true,
+            })
+            if err != nil || resp.Status != api.Status_OK {
+                t.Errorf("Append %d failed: %v %v", i, err, resp.GetStatus())
+                return
+            }
+            offsets[i] = resp.Offset
+        }(i)
+    }
+    wg.Wait()
+
+    // Every line must be intact at the offset the server reported
+    data, err := os.ReadFile(testFilePath)
+    if err != nil {
+        t.Fatalf("Failed to read file: %v", err)
+    }
+    if !bytes.HasPrefix(data, []byte("header\n")) {
+        t.Errorf("Existing content was overwritten: %q", data)
+    }
+    for i, line := range lines {
+        end := offsets[i] + uint64(len(line))
+        if end > uint64(len(data)) || string(data[offsets[i]:end]) != line {
+            t.Errorf("Line %d not found at reported offset %d", i, offsets[i])
+        }
+    }
+    sort.Slice(offsets, func(a, b int) bool { return offsets[a] < offsets[b] })
+    for i := 1; i < len(offsets); i++ {
+        if offsets[i] == offsets[i-1] {
+            t.Errorf("Two appends reported offset %d", offsets[i])
+        }
+    }
+    if want := len("header\n") + writers*len(lines[0]); len(data) != want {
+        t.Errorf("File size %d, want %d", len(data), want)
+    }
+}
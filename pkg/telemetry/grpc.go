@@ -0,0 +1,216 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/example/nfsserver/pkg/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// propagator carries trace context in gRPC metadata. It is fixed rather
+// than the global one, so client and server agree whatever a program sets.
+var propagator = propagation.TraceContext{}
+
+// metadataCarrier adapts gRPC metadata to the propagator
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// spanName returns the name of the span of a gRPC method, e.g. "nfs.Read"
+func spanName(fullMethod string) string {
+	return "nfs." + path.Base(fullMethod)
+}
+
+// integerFields are the request fields recorded as span attributes when
+// they are integers
+var integerFields = map[protoreflect.Name]bool{
+	"offset": true, "count": true, "length": true, "size": true, "cookie": true, "stability": true,
+}
+
+// requestAttributes returns the attributes describing a request: its
+// handles, hashed, its names and paths, offsets and counts, and the size of
+// the data it carries
+func requestAttributes(req interface{}) []attribute.KeyValue {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.IsList() || fd.IsMap() {
+			if fd.IsList() {
+				attrs = append(attrs, attribute.Int("nfs."+string(fd.Name())+".count", v.List().Len()))
+			}
+			return true
+		}
+		key := "nfs." + string(fd.Name())
+		switch fd.Kind() {
+		case protoreflect.BytesKind:
+			switch {
+			case strings.Contains(string(fd.Name()), "handle"):
+				attrs = append(attrs, attribute.String(key, hashHandle(v.Bytes())))
+			case fd.Name() == "data":
+				attrs = append(attrs, attribute.Int("nfs.size", len(v.Bytes())))
+			}
+		case protoreflect.StringKind:
+			attrs = append(attrs, attribute.String(key, v.String()))
+		case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+			if integerFields[fd.Name()] {
+				attrs = append(attrs, attribute.Int64(key, v.Int()))
+			}
+		case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+			if integerFields[fd.Name()] {
+				attrs = append(attrs, attribute.Int64(key, int64(v.Uint())))
+			}
+		}
+		return true
+	})
+	return attrs
+}
+
+// finish records the outcome of an RPC on span: the error, or the NFS
+// status of the response
+func finish(span trace.Span, resp interface{}, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	r, ok := resp.(interface{ GetStatus() api.Status })
+	if !ok {
+		return
+	}
+	status := r.GetStatus()
+	span.SetAttributes(attribute.String("nfs.status", status.String()))
+	if status != api.Status_OK {
+		span.SetStatus(codes.Error, status.String())
+	}
+}
+
+// UnaryServerInterceptor starts a span for each unary request, continuing
+// the trace its client sent
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+		ctx, span := tracer().Start(ctx, spanName(info.FullMethod),
+			trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(requestAttributes(req)...))
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		finish(span, resp, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor starts a span for each streaming call, covering
+// every message of it
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		ctx := propagator.Extract(ss.Context(), metadataCarrier(md))
+		ctx, span := tracer().Start(ctx, spanName(info.FullMethod), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+		finish(span, nil, err)
+		return err
+	}
+}
+
+// tracedServerStream is a stream whose context carries its span
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (t *tracedServerStream) Context() context.Context {
+	return t.ctx
+}
+
+// inject starts a client span for method and returns a context sending its
+// trace context to the server
+func inject(ctx context.Context, method string, attrs []attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := tracer().Start(ctx, spanName(method), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// UnaryClientInterceptor starts a span for each unary call and sends its
+// trace context to the server
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := inject(ctx, method, requestAttributes(req))
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		finish(span, reply, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a span for each streaming call, ended when
+// the stream finishes
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := inject(ctx, method, nil)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(span, nil, err)
+			span.End()
+			return nil, err
+		}
+		return &tracedClientStream{ClientStream: cs, span: span}, nil
+	}
+}
+
+// tracedClientStream ends its span once a receive fails, which is how every
+// stream ends
+type tracedClientStream struct {
+	grpc.ClientStream
+	span trace.Span
+}
+
+func (t *tracedClientStream) RecvMsg(m interface{}) error {
+	err := t.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		t.span.End()
+	} else if err != nil {
+		finish(t.span, nil, err)
+		t.span.End()
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// Package telemetry traces NFS operations with OpenTelemetry. gRPC
+// interceptors on both sides give every RPC a span, annotated with the
+// handles, names, offsets and sizes of its request, and carry the trace
+// context from client to server in W3C traceparent metadata, so one trace
+// follows an operation from a FUSE request to the server's file system.
+//
+// Spans are recorded by the global tracer provider, which does nothing
+// until Setup, or a program embedding the packages, installs one.
+package telemetry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer spans are created with
+const instrumentationName = "github.com/example/nfsserver"
+
+// Options select where spans are exported
+type Options struct {
+	// Exporter is "otlp" to send spans to an OpenTelemetry collector, set
+	// up by the standard OTEL_EXPORTER_OTLP_* variables, "stdout" to
+	// write them to standard error, or "" to record none
+	Exporter string
+
+	// SampleRatio is the fraction of traces started here that are
+	// recorded; traces started by a caller follow the caller's choice
+	SampleRatio float64
+}
+
+// DefaultOptions returns options recording no spans
+func DefaultOptions() Options {
+	return Options{SampleRatio: 1}
+}
+
+// Setup installs a tracer provider exporting the spans of service as opts
+// select. The returned function flushes and stops it.
+func Setup(ctx context.Context, service string, opts Options) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want otlp or stdout)", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracer returns the tracer spans are started with
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// SetPath records on the span of ctx the path an operation resolved to
+func SetPath(ctx context.Context, path string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("nfs.path", path))
+}
+
+// StartSpan starts a span for work done outside an RPC, such as a FUSE
+// request, which the RPCs made with the returned context are children of
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// hashHandle returns a short, non-reversible identifier for a file handle,
+// so spans can be matched up by file without exposing handles
+func hashHandle(handle []byte) string {
+	sum := sha256.Sum256(handle)
+	return "h:" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,126 @@
+package telemetry_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/nfsserver/pkg/client"
+	"github.com/example/nfsserver/pkg/fs/local"
+	"github.com/example/nfsserver/pkg/server"
+	"github.com/example/nfsserver/pkg/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attr returns the value of the attribute key of span, if set
+func attr(span sdktrace.ReadOnlySpan, key string) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "file.txt"), nil, 0666); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	fileSystem, err := local.NewLocalFileSystem(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create filesystem: %v", err)
+	}
+	config := server.DefaultConfig()
+	config.EnableRootSquash = false
+	nfsServer, err := server.NewNFSServer(config, fileSystem)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer lis.Close()
+	go nfsServer.Serve(lis)
+
+	clientConfig := client.DefaultConfig()
+	clientConfig.ServerAddress = lis.Addr().String()
+	clientConfig.Timeout = 5 * time.Second
+	nfsClient, err := client.NewClient(clientConfig)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer nfsClient.Close()
+	ctx := client.WithCredentials(context.Background(), 0, 0)
+	handle, err := nfsClient.LookupPath(ctx, "/file.txt")
+	if err != nil {
+		t.Fatalf("LookupPath failed: %v", err)
+	}
+
+	// A write made on behalf of a span, as FUSE requests are
+	ctx, parent := telemetry.StartSpan(ctx, "fuse.Write")
+	if _, err := nfsClient.Write(ctx, handle, 5, []byte("hello"), 2); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	parent.End()
+
+	var clientSpan, serverSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() != "nfs.Write" {
+			continue
+		}
+		switch span.SpanKind() {
+		case trace.SpanKindClient:
+			clientSpan = span
+		case trace.SpanKindServer:
+			serverSpan = span
+		}
+	}
+	if clientSpan == nil || serverSpan == nil {
+		t.Fatalf("Recorded %d spans, want a client and a server span for Write", len(recorder.Ended()))
+	}
+
+	// One trace runs from the caller's span through the server
+	if clientSpan.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Client span's parent is %v, want the caller's span %v", clientSpan.Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() ||
+		serverSpan.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("Server span is not a child of the client span in the caller's trace")
+	}
+
+	for _, tc := range []struct {
+		span sdktrace.ReadOnlySpan
+		key  string
+		want attribute.Value
+	}{
+		{clientSpan, "nfs.offset", attribute.Int64Value(5)},
+		{clientSpan, "nfs.size", attribute.IntValue(5)},
+		{clientSpan, "nfs.status", attribute.StringValue("OK")},
+		{serverSpan, "nfs.path", attribute.StringValue("/file.txt")},
+		{serverSpan, "nfs.offset", attribute.Int64Value(5)},
+	} {
+		if got, ok := attr(tc.span, tc.key); !ok || got != tc.want {
+			t.Errorf("%s span attribute %s = %v, want %v", tc.span.SpanKind(), tc.key, got.Emit(), tc.want.Emit())
+		}
+	}
+	if handle, ok := attr(serverSpan, "nfs.file_handle"); !ok || len(handle.AsString()) != len("h:")+12 {
+		t.Errorf("Server span records handle %q, want its hash", handle.Emit())
+	}
+	if id, ok := attr(serverSpan, "nfs.request_id"); !ok || id.AsString() == "" {
+		t.Error("Server span lacks the request ID")
+	}
+}